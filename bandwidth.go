@@ -0,0 +1,195 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// otherMsgCommand is the command used to account for the bytes of
+	// messages which failed to be read or written.
+	otherMsgCommand = "*other*"
+
+	// uploadTargetTimeframe is the length of a cycle of the upload target.
+	uploadTargetTimeframe = time.Hour * 24
+
+	// historicalBlockAge is the minimum age of a block for it to be
+	// considered historical.  Historical blocks are no longer served once
+	// the upload target is reached.
+	historicalBlockAge = time.Hour * 24 * 7
+)
+
+// byteCounters keeps track of the number of bytes sent or received per message
+// type along with how many of those bytes were used for blocks and how many
+// for their utreexo proofs.  It is safe for concurrent access.
+type byteCounters struct {
+	mtx        sync.Mutex
+	perMsg     map[string]uint64
+	blockBytes uint64
+	proofBytes uint64
+}
+
+// byteCountersSnapshot is a snapshot of the byte counters.
+type byteCountersSnapshot struct {
+	PerMsg     map[string]uint64
+	BlockBytes uint64
+	ProofBytes uint64
+}
+
+// add accounts for the passed number of bytes used by the passed message.  A
+// nil message accounts for the bytes of a message that failed to be read or
+// written.
+func (c *byteCounters) add(msg wire.Message, n int) {
+	command := otherMsgCommand
+	var blockBytes, proofBytes uint64
+	if msg != nil {
+		command = msg.Command()
+		blockBytes, proofBytes = splitBlockBytes(msg, n)
+	}
+
+	c.mtx.Lock()
+	if c.perMsg == nil {
+		c.perMsg = make(map[string]uint64)
+	}
+	c.perMsg[command] += uint64(n)
+	c.blockBytes += blockBytes
+	c.proofBytes += proofBytes
+	c.mtx.Unlock()
+}
+
+// snapshot returns a snapshot of the byte counters.
+func (c *byteCounters) snapshot() byteCountersSnapshot {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	perMsg := make(map[string]uint64, len(c.perMsg))
+	for command, n := range c.perMsg {
+		perMsg[command] = n
+	}
+	return byteCountersSnapshot{
+		PerMsg:     perMsg,
+		BlockBytes: c.blockBytes,
+		ProofBytes: c.proofBytes,
+	}
+}
+
+// splitBlockBytes splits the passed number of bytes used by the passed message
+// into the bytes used for a block and the bytes used for its utreexo proof.
+// Both are zero for messages that don't carry a block.
+func splitBlockBytes(msg wire.Message, n int) (uint64, uint64) {
+	switch m := msg.(type) {
+	case *wire.MsgBlock:
+		return uint64(n), 0
+
+	case *wire.MsgUBlock:
+		proofBytes := uint64(m.UtreexoData.SerializeSize())
+		if proofBytes > uint64(n) {
+			proofBytes = uint64(n)
+		}
+		return uint64(n) - proofBytes, proofBytes
+	}
+
+	return 0, 0
+}
+
+// uploadTarget keeps the number of bytes sent during a cycle of 24 hours under
+// a target by telling when to stop serving historical blocks.  It is safe for
+// concurrent access.
+type uploadTarget struct {
+	mtx        sync.Mutex
+	target     uint64
+	cycleStart time.Time
+	sent       uint64
+}
+
+// uploadTargetSnapshot is a snapshot of the state of the upload target.
+type uploadTargetSnapshot struct {
+	Target                uint64
+	TargetReached         bool
+	ServeHistoricalBlocks bool
+	BytesLeftInCycle      uint64
+	TimeLeftInCycle       time.Duration
+}
+
+// newUploadTarget returns an upload target which limits the bytes sent per
+// cycle to the passed number of bytes.  A target of zero means no limit.
+func newUploadTarget(target uint64) *uploadTarget {
+	return &uploadTarget{target: target}
+}
+
+// maybeStartCycle starts a new cycle when the current one is over.
+//
+// This function MUST be called with the upload target lock held.
+func (u *uploadTarget) maybeStartCycle(now time.Time) {
+	if now.Sub(u.cycleStart) > uploadTargetTimeframe {
+		u.cycleStart = now
+		u.sent = 0
+	}
+}
+
+// addBytes accounts for the passed number of bytes sent at the passed time.
+func (u *uploadTarget) addBytes(n uint64, now time.Time) {
+	u.mtx.Lock()
+	u.maybeStartCycle(now)
+	u.sent += n
+	u.mtx.Unlock()
+}
+
+// reached returns whether the upload target of the cycle was reached at the
+// passed time.  When the historical flag is set, room is kept for serving a
+// new block every ten minutes for the rest of the cycle, so serving historical
+// blocks stops before the target itself is reached.
+//
+// This function MUST be called with the upload target lock held.
+func (u *uploadTarget) reached(historical bool, now time.Time) bool {
+	if u.target == 0 {
+		return false
+	}
+	u.maybeStartCycle(now)
+
+	if historical {
+		buffer := uint64(uploadTargetTimeframe/(time.Minute*10)) *
+			blockchain.MaxBlockWeight
+		return buffer >= u.target || u.sent >= u.target-buffer
+	}
+	return u.sent >= u.target
+}
+
+// servesHistoricalBlocks returns whether historical blocks may be served at
+// the passed time.
+func (u *uploadTarget) servesHistoricalBlocks(now time.Time) bool {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	return !u.reached(true, now)
+}
+
+// snapshot returns a snapshot of the state of the upload target at the passed
+// time.
+func (u *uploadTarget) snapshot(now time.Time) uploadTargetSnapshot {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	snap := uploadTargetSnapshot{
+		Target:                u.target,
+		TargetReached:         u.reached(false, now),
+		ServeHistoricalBlocks: !u.reached(true, now),
+	}
+	if u.target == 0 {
+		return snap
+	}
+	if u.sent < u.target {
+		snap.BytesLeftInCycle = u.target - u.sent
+	}
+	if left := u.cycleStart.Add(uploadTargetTimeframe).Sub(now); left > 0 {
+		snap.TimeLeftInCycle = left
+	}
+	return snap
+}
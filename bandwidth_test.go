@@ -0,0 +1,133 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+// TestByteCounters ensures bytes are accounted per message type and blocks are
+// split into the bytes used by the block and by its utreexo proof.
+func TestByteCounters(t *testing.T) {
+	t.Parallel()
+
+	ublock := &wire.MsgUBlock{UtreexoData: btcacc.UData{Height: 10}}
+	proofBytes := ublock.UtreexoData.SerializeSize()
+
+	var c byteCounters
+	c.add(&wire.MsgPing{}, 32)
+	c.add(&wire.MsgPing{}, 32)
+	c.add(&wire.MsgBlock{}, 1000)
+	c.add(ublock, 2000)
+	c.add(nil, 5)
+
+	snap := c.snapshot()
+	wantPerMsg := map[string]uint64{
+		wire.CmdPing:    64,
+		wire.CmdBlock:   1000,
+		wire.CmdUBlock:  2000,
+		otherMsgCommand: 5,
+	}
+	if len(snap.PerMsg) != len(wantPerMsg) {
+		t.Fatalf("got %d message types, want %d", len(snap.PerMsg),
+			len(wantPerMsg))
+	}
+	for command, want := range wantPerMsg {
+		if got := snap.PerMsg[command]; got != want {
+			t.Errorf("%s: got %d bytes, want %d", command, got, want)
+		}
+	}
+	if want := uint64(1000 + 2000 - proofBytes); snap.BlockBytes != want {
+		t.Errorf("got %d block bytes, want %d", snap.BlockBytes, want)
+	}
+	if want := uint64(proofBytes); snap.ProofBytes != want {
+		t.Errorf("got %d proof bytes, want %d", snap.ProofBytes, want)
+	}
+
+	// The snapshot must not change along with the counters.
+	c.add(&wire.MsgPing{}, 32)
+	if snap.PerMsg[wire.CmdPing] != 64 {
+		t.Errorf("snapshot changed after adding bytes")
+	}
+}
+
+// TestUploadTarget ensures historical blocks stop being served once the upload
+// target minus the room kept for new blocks is reached, the target itself is
+// reported as reached once all of it is used, and a new cycle starts after 24
+// hours.
+func TestUploadTarget(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1600000000, 0)
+
+	// Without a target, historical blocks are always served.
+	unlimited := newUploadTarget(0)
+	unlimited.addBytes(1<<40, now)
+	if !unlimited.servesHistoricalBlocks(now) {
+		t.Fatalf("unlimited target stopped serving historical blocks")
+	}
+	if snap := unlimited.snapshot(now); snap.TargetReached {
+		t.Fatalf("unlimited target reached")
+	}
+
+	// A target below the room kept for new blocks never serves historical
+	// blocks.
+	buffer := uint64(uploadTargetTimeframe/(time.Minute*10)) *
+		blockchain.MaxBlockWeight
+	small := newUploadTarget(buffer)
+	if small.servesHistoricalBlocks(now) {
+		t.Fatalf("target within the buffer serves historical blocks")
+	}
+
+	target := 2 * buffer
+	u := newUploadTarget(target)
+	u.addBytes(buffer-1, now)
+	if !u.servesHistoricalBlocks(now) {
+		t.Fatalf("stopped serving historical blocks too early")
+	}
+	u.addBytes(1, now)
+	if u.servesHistoricalBlocks(now) {
+		t.Fatalf("historical blocks still served after reaching target " +
+			"minus buffer")
+	}
+	snap := u.snapshot(now)
+	if snap.TargetReached {
+		t.Fatalf("target reached too early")
+	}
+	if snap.BytesLeftInCycle != buffer {
+		t.Fatalf("got %d bytes left, want %d", snap.BytesLeftInCycle,
+			buffer)
+	}
+	if snap.TimeLeftInCycle != uploadTargetTimeframe {
+		t.Fatalf("got %v left in cycle, want %v", snap.TimeLeftInCycle,
+			uploadTargetTimeframe)
+	}
+
+	later := now.Add(time.Hour)
+	u.addBytes(buffer, later)
+	snap = u.snapshot(later)
+	if !snap.TargetReached || snap.BytesLeftInCycle != 0 {
+		t.Fatalf("target not reached after sending %d bytes", target)
+	}
+	if want := uploadTargetTimeframe - time.Hour; snap.TimeLeftInCycle != want {
+		t.Fatalf("got %v left in cycle, want %v", snap.TimeLeftInCycle,
+			want)
+	}
+
+	// A new cycle starts once the current one is over.
+	nextCycle := now.Add(uploadTargetTimeframe + time.Second)
+	if !u.servesHistoricalBlocks(nextCycle) {
+		t.Fatalf("historical blocks not served in new cycle")
+	}
+	if snap := u.snapshot(nextCycle); snap.BytesLeftInCycle != target {
+		t.Fatalf("got %d bytes left in new cycle, want %d",
+			snap.BytesLeftInCycle, target)
+	}
+}
@@ -8,6 +8,7 @@ package blockchain
 import (
 	"container/list"
 	"fmt"
+	"math/big"
 	"sync"
 	"time"
 
@@ -130,6 +131,7 @@ type BlockChain struct {
 	sigCache            *txscript.SigCache
 	indexManager        IndexManager
 	hashCache           *txscript.HashCache
+	scriptCache         *txscript.ScriptExecCache
 
 	// These fields are utreexo specific. Some fields are compact-state-node only
 	// and some are shared by both the
@@ -205,6 +207,11 @@ type BlockChain struct {
 	nextCheckpoint *chaincfg.Checkpoint
 	checkpointNode *blockNode
 
+	// pendingBlocks are the blocks processed with the BFPipelineScripts
+	// flag which extend the main chain but whose scripts are still being
+	// validated, in order.  They are protected by the chain lock.
+	pendingBlocks []*pendingBlock
+
 	// The state is used as a fairly efficient way to cache information
 	// about the current best chain state that is returned to callers when
 	// requested.  It operates on the principle of MVCC such that any time a
@@ -1390,11 +1397,27 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 // The flags modify the behavior of this function as follows:
 //  - BFFastAdd: Avoids several expensive transaction validation operations.
 //    This is useful when using checkpoints.
+//  - BFPipelineScripts: Leaves the scripts of a block extending the main chain
+//    to be validated in the background.  See pipelineBlock.
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) connectBestChain(node *blockNode, block *btcutil.Block, flags BehaviorFlags) (bool, error) {
 	fastAdd := flags&BFFastAdd == BFFastAdd
 
+	// Blocks extending the script validation pipeline are added to it,
+	// otherwise it's drained so the main chain reflects all of the blocks
+	// processed so far.
+	parentHash := &block.MsgBlock().Header.PrevBlock
+	if b.canPipelineBlock(node, parentHash, flags) {
+		// The block isn't on the main chain until it is connected
+		// once its scripts are found to be valid.
+		err := b.pipelineBlock(node, block, nil)
+		return false, err
+	}
+	if err := b.drainPendingBlocks(node); err != nil {
+		return false, err
+	}
+
 	flushIndexState := func() {
 		// Intentionally ignore errors writing updated node status to DB. If
 		// it fails to write, it's not the end of the world. If the block is
@@ -1408,7 +1431,6 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *btcutil.Block, fla
 
 	// We are extending the main (best) chain with a new block.  This is the
 	// most common case.
-	parentHash := &block.MsgBlock().Header.PrevBlock
 	if parentHash.IsEqual(&b.bestChain.Tip().hash) {
 		// Skip checks if node has already been fully validated.
 		fastAdd = fastAdd || b.index.NodeStatus(node).KnownValid()
@@ -1589,14 +1611,29 @@ func (b *BlockChain) connectBestChainParallel(node *blockNode, ublock *btcutil.U
 // The flags modify the behavior of this function as follows:
 //  - BFFastAdd: Avoids several expensive transaction validation operations.
 //    This is useful when using checkpoints.
+//  - BFPipelineScripts: Leaves the scripts of a ublock extending the main chain
+//    to be validated in the background.  See pipelineBlock.
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) connectBestChainUBlock(node *blockNode, ublock *btcutil.UBlock, flags BehaviorFlags) (bool, error) {
 	fastAdd := flags&BFFastAdd == BFFastAdd
 
+	// Ublocks extending the script validation pipeline are added to it,
+	// otherwise it's drained so the main chain reflects all of the ublocks
+	// processed so far.
+	parentHash := &ublock.Block().MsgBlock().Header.PrevBlock
+	if b.canPipelineBlock(node, parentHash, flags) {
+		// The block isn't on the main chain until it is connected
+		// once its scripts are found to be valid.
+		err := b.pipelineBlock(node, nil, ublock)
+		return false, err
+	}
+	if err := b.drainPendingBlocks(node); err != nil {
+		return false, err
+	}
+
 	// We are extending the main (best) chain with a new block.  This is the
 	// most common case.
-	parentHash := &ublock.Block().MsgBlock().Header.PrevBlock
 	if parentHash.IsEqual(&b.bestChain.Tip().hash) {
 		// Skip checks if node has already been fully validated.
 		fastAdd = fastAdd || b.index.NodeStatus(node).KnownValid()
@@ -1769,6 +1806,20 @@ func (b *BlockChain) HeaderByHash(hash *chainhash.Hash) (wire.BlockHeader, error
 	return node.Header(), nil
 }
 
+// ChainWork returns the total amount of work in the chain up to and including
+// the block with the given hash.  Note that this works for blocks in both the
+// main and side chains.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ChainWork(hash *chainhash.Hash) (*big.Int, error) {
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return nil, fmt.Errorf("block %s is not known", hash)
+	}
+
+	return new(big.Int).Set(node.workSum), nil
+}
+
 // MainChainHasBlock returns whether or not the block with the given hash is in
 // the main chain.
 //
@@ -1778,6 +1829,16 @@ func (b *BlockChain) MainChainHasBlock(hash *chainhash.Hash) bool {
 	return node != nil && b.bestChain.Contains(node)
 }
 
+// IsKnownInvalid returns whether or not the block with the given hash is known
+// to be invalid, either because it failed validation itself or because it
+// descends from a block which did.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsKnownInvalid(hash *chainhash.Hash) bool {
+	node := b.index.LookupNode(hash)
+	return node != nil && b.index.NodeStatus(node).KnownInvalid()
+}
+
 // BlockLocatorFromHash returns a block locator for the passed block hash.
 // See BlockLocator for details on the algorithm used to create a block locator.
 //
@@ -2208,6 +2269,16 @@ type Config struct {
 	// signature cache.
 	HashCache *txscript.HashCache
 
+	// ScriptExecCache defines a cache of the transactions whose scripts
+	// are known to be valid, which are then not executed again when
+	// validating the blocks including them.  Like the signature cache, it
+	// is most useful when transactions are validated by a memory pool
+	// prior to their inclusion in a block.
+	//
+	// This field can be nil if the caller is not interested in using a
+	// script execution cache.
+	ScriptExecCache *txscript.ScriptExecCache
+
 	// Utreexo enables the Utreexo bridgenode state.
 	Utreexo bool
 
@@ -2297,6 +2368,7 @@ func New(config *Config) (*BlockChain, error) {
 		index:                 newBlockIndex(config.DB, params),
 		utxoCache:             initedUtxoCache,
 		hashCache:             config.HashCache,
+		scriptCache:           config.ScriptExecCache,
 		bestChain:             newChainView(nil),
 		orphans:               make(map[chainhash.Hash]*orphanBlock),
 		prevOrphans:           make(map[chainhash.Hash][]*orphanBlock),
@@ -2401,5 +2473,13 @@ func (b *BlockChain) CachedStateSize() uint64 {
 func (b *BlockChain) FlushCachedState(mode FlushMode) error {
 	b.chainLock.Lock()
 	defer b.chainLock.Unlock()
+
+	// The blocks pending script validation are connected first when the
+	// whole state must be flushed so their changes are flushed as well.
+	if mode == FlushRequired {
+		if err := b.drainPendingBlocks(nil); err != nil {
+			return err
+		}
+	}
 	return b.utxoCache.Flush(mode, b.stateSnapshot)
 }
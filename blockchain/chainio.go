@@ -1367,6 +1367,7 @@ func (b *BlockChain) createChainState() error {
 			if err != nil {
 				return err
 			}
+			b.UtreexoBS.tip = *b.chainParams.GenesisHash
 			b.proofFileState = NewProofFileState()
 			b.proofFileState.InitProofFileState(filepath.Join(b.dataDir, "proof"))
 			_, err = meta.CreateBucket(txoTTLBucketName)
@@ -1566,6 +1567,9 @@ func (b *BlockChain) initChainState() error {
 				"chain tip %s in block index", state.hash))
 		}
 		b.bestChain.SetTip(tip)
+		if b.UtreexoBS != nil {
+			b.UtreexoBS.tip = tip.hash
+		}
 
 		// Load the raw block bytes for the best block.
 		blockBytes, err := dbTx.FetchBlock(&state.hash)
@@ -1788,6 +1792,15 @@ func (b *BlockChain) FlushMemBlockStore() error {
 	b.chainLock.Lock()
 	defer b.chainLock.Unlock()
 
+	// The ublocks pending script validation have already modified the
+	// utreexo accumulator, so they are connected first for the stored
+	// block, best state and accumulator to match.  A pending ublock which
+	// turns out to be invalid restores the accumulator to its state before
+	// it, so it is only logged.
+	if err := b.drainPendingBlocks(nil); err != nil {
+		return err
+	}
+
 	b.utreexoQuit = true
 
 	err := b.index.flushToDB()
@@ -8,6 +8,7 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
@@ -299,6 +300,53 @@ func (b *BlockChain) calcNextRequiredDifficulty(lastNode *blockNode, newBlockTim
 	return newTargetBits, nil
 }
 
+// PermittedDifficultyTransition returns whether the difficulty bits of a block
+// at the given height may follow the difficulty bits of its parent.  Unlike
+// the full difficulty calculation, it doesn't require any other blocks, so it
+// can be used as a cheap sanity check on header chains that are not connected
+// to the block index yet.  Networks which allow minimum difficulty blocks
+// permit any transition.
+func PermittedDifficultyTransition(params *chaincfg.Params, height int32,
+	oldBits, newBits uint32) bool {
+
+	if params.ReduceMinDifficulty {
+		return true
+	}
+
+	// The difficulty may only change at retarget intervals.
+	targetTimespan := int64(params.TargetTimespan / time.Second)
+	targetTimePerBlock := int64(params.TargetTimePerBlock / time.Second)
+	blocksPerRetarget := int32(targetTimespan / targetTimePerBlock)
+	if height%blocksPerRetarget != 0 {
+		return oldBits == newBits
+	}
+
+	// At a retarget, the new target must lie within the bounds the
+	// adjustment factor allows.  The bounds are rounded through the compact
+	// representation the same way the actual retarget result is.
+	adjustmentFactor := params.RetargetAdjustmentFactor
+	oldTarget := CompactToBig(oldBits)
+	newTarget := CompactToBig(newBits)
+
+	largest := new(big.Int).Mul(oldTarget,
+		big.NewInt(targetTimespan*adjustmentFactor))
+	largest.Div(largest, big.NewInt(targetTimespan))
+	if largest.Cmp(params.PowLimit) > 0 {
+		largest.Set(params.PowLimit)
+	}
+	if newTarget.Cmp(CompactToBig(BigToCompact(largest))) > 0 {
+		return false
+	}
+
+	smallest := new(big.Int).Mul(oldTarget,
+		big.NewInt(targetTimespan/adjustmentFactor))
+	smallest.Div(smallest, big.NewInt(targetTimespan))
+	if smallest.Cmp(params.PowLimit) > 0 {
+		smallest.Set(params.PowLimit)
+	}
+	return newTarget.Cmp(CompactToBig(BigToCompact(smallest))) >= 0
+}
+
 // CalcNextRequiredDifficulty calculates the required difficulty for the block
 // after the end of the current best chain based on the difficulty retarget
 // rules.
@@ -7,6 +7,8 @@ package blockchain
 import (
 	"math/big"
 	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
 )
 
 // TestBigToCompact ensures BigToCompact converts big integers to the expected
@@ -73,3 +75,43 @@ func TestCalcWork(t *testing.T) {
 		}
 	}
 }
+
+// TestPermittedDifficultyTransition ensures PermittedDifficultyTransition only
+// allows difficulty changes at retarget intervals and within the bounds of the
+// adjustment factor.
+func TestPermittedDifficultyTransition(t *testing.T) {
+	const bits = 0x1b0404cb
+	target := CompactToBig(bits)
+	easier := func(mul int64) uint32 {
+		return BigToCompact(new(big.Int).Mul(target, big.NewInt(mul)))
+	}
+	harder := func(div int64) uint32 {
+		return BigToCompact(new(big.Int).Div(target, big.NewInt(div)))
+	}
+
+	tests := []struct {
+		name    string
+		params  *chaincfg.Params
+		height  int32
+		newBits uint32
+		want    bool
+	}{
+		{"same bits", &chaincfg.MainNetParams, 2017, bits, true},
+		{"change off retarget", &chaincfg.MainNetParams, 2017, easier(2), false},
+		{"same bits at retarget", &chaincfg.MainNetParams, 4032, bits, true},
+		{"4x easier at retarget", &chaincfg.MainNetParams, 4032, easier(4), true},
+		{"5x easier at retarget", &chaincfg.MainNetParams, 4032, easier(5), false},
+		{"4x harder at retarget", &chaincfg.MainNetParams, 4032, harder(4), true},
+		{"5x harder at retarget", &chaincfg.MainNetParams, 4032, harder(5), false},
+		{"min difficulty network", &chaincfg.TestNet3Params, 2017, easier(5), true},
+	}
+
+	for _, test := range tests {
+		got := PermittedDifficultyTransition(test.params, test.height,
+			bits, test.newBits)
+		if got != test.want {
+			t.Errorf("%s: unexpected result - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
@@ -6,6 +6,8 @@ package blockchain
 
 import (
 	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
 // DeploymentError identifies an error that indicates a deployment ID was
@@ -223,6 +225,27 @@ const (
 	// current chain tip. This is not a block validation rule, but is required
 	// for block proposals submitted via getblocktemplate RPC.
 	ErrPrevBlockNotBest
+
+	// ErrUtreexoProofTargets indicates that the targets of the utreexo
+	// proof of a block are malformed.  This includes targets which don't
+	// match the number of leaf datas, duplicate targets, targets outside
+	// of the accumulator and proofs which don't cover the targets.
+	ErrUtreexoProofTargets
+
+	// ErrUtreexoLeafDataMismatch indicates that the leaf datas of the
+	// utreexo proof of a block don't match the outputs spent by the block
+	// or don't hash to the leaves being proven.
+	ErrUtreexoLeafDataMismatch
+
+	// ErrUtreexoRootsMismatch indicates that the utreexo proof of a block
+	// doesn't prove the leaves being spent against the current roots of
+	// the accumulator.
+	ErrUtreexoRootsMismatch
+
+	// ErrBadSignetSolution indicates that a block of a signet network
+	// doesn't carry a well-formed solution in its witness commitment or
+	// that the solution doesn't satisfy the challenge of the network.
+	ErrBadSignetSolution
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -271,6 +294,10 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrPreviousHeaderUnknown:     "ErrPreviousHeaderUnknown",
 	ErrInvalidAncestorBlock:      "ErrInvalidAncestorBlock",
 	ErrPrevBlockNotBest:          "ErrPrevBlockNotBest",
+	ErrUtreexoProofTargets:       "ErrUtreexoProofTargets",
+	ErrUtreexoLeafDataMismatch:   "ErrUtreexoLeafDataMismatch",
+	ErrUtreexoRootsMismatch:      "ErrUtreexoRootsMismatch",
+	ErrBadSignetSolution:         "ErrBadSignetSolution",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -300,3 +327,26 @@ func (e RuleError) Error() string {
 func ruleError(c ErrorCode, desc string) RuleError {
 	return RuleError{ErrorCode: c, Description: desc}
 }
+
+// PendingBlockError identifies a block processed with the BFPipelineScripts
+// flag which failed its validation once its scripts were validated.  Since the
+// block was left pending, the error is only returned by the call which would
+// have connected it, such as the processing of a later block.  The caller can
+// use type assertions to tell the failure of the pending block apart from the
+// one of the block being processed.
+type PendingBlockError struct {
+	Hash   chainhash.Hash // Hash of the pending block which failed
+	Height int32          // Height of the pending block which failed
+	Err    RuleError      // The rule the pending block violated
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e PendingBlockError) Error() string {
+	return fmt.Sprintf("pending block %v (height %d) failed validation: %v",
+		e.Hash, e.Height, e.Err)
+}
+
+// Unwrap returns the rule error of the pending block.
+func (e PendingBlockError) Unwrap() error {
+	return e.Err
+}
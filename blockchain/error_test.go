@@ -58,6 +58,10 @@ func TestErrorCodeStringer(t *testing.T) {
 		{ErrPreviousBlockUnknown, "ErrPreviousBlockUnknown"},
 		{ErrInvalidAncestorBlock, "ErrInvalidAncestorBlock"},
 		{ErrPrevBlockNotBest, "ErrPrevBlockNotBest"},
+		{ErrUtreexoProofTargets, "ErrUtreexoProofTargets"},
+		{ErrUtreexoLeafDataMismatch, "ErrUtreexoLeafDataMismatch"},
+		{ErrUtreexoRootsMismatch, "ErrUtreexoRootsMismatch"},
+		{ErrBadSignetSolution, "ErrBadSignetSolution"},
 		{0xffff, "Unknown ErrorCode (65535)"},
 	}
 
@@ -308,3 +308,105 @@ func testFullBlocks(t *testing.T) {
 		}
 	}
 }
+
+// fullBlocksMainChain returns the blocks of the main chain the tests generated
+// by the fullblocktests package end up with, in order.
+func fullBlocksMainChain() ([]*btcutil.Block, error) {
+	tests, err := fullblocktests.Generate(false)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, teardownFunc, err := chainSetup("fullblocksmainchain",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		return nil, err
+	}
+	defer teardownFunc()
+
+	// The results are checked by the full block tests, only the resulting
+	// main chain matters here.
+	for _, test := range tests {
+		for _, item := range test {
+			var msgBlock *wire.MsgBlock
+			switch item := item.(type) {
+			case fullblocktests.AcceptedBlock:
+				msgBlock = item.Block
+			case fullblocktests.RejectedBlock:
+				msgBlock = item.Block
+			case fullblocktests.OrphanOrRejectedBlock:
+				msgBlock = item.Block
+			default:
+				continue
+			}
+			_, _, _ = chain.ProcessBlock(btcutil.NewBlock(msgBlock),
+				blockchain.BFNone)
+		}
+	}
+
+	best := chain.BestSnapshot()
+	blocks := make([]*btcutil.Block, 0, best.Height)
+	for height := int32(1); height <= best.Height; height++ {
+		block, err := chain.BlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, btcutil.NewBlock(block.MsgBlock()))
+	}
+	return blocks, nil
+}
+
+// BenchmarkProcessFullBlocks benchmarks processing the main chain generated by
+// the fullblocktests package back to back, as during the initial block
+// download, with and without pipelining the validation of the scripts.
+func BenchmarkProcessFullBlocks(b *testing.B) {
+	blocks, err := fullBlocksMainChain()
+	if err != nil {
+		b.Fatalf("unable to generate the main chain: %v", err)
+	}
+
+	benches := []struct {
+		name  string
+		flags blockchain.BehaviorFlags
+	}{
+		{name: "serial", flags: blockchain.BFNone},
+		{name: "pipelined", flags: blockchain.BFPipelineScripts},
+	}
+	for _, bench := range benches {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				chain, teardownFunc, err := chainSetup(
+					"benchfullblocks",
+					&chaincfg.RegressionNetParams)
+				if err != nil {
+					b.Fatalf("failed to setup chain instance: %v",
+						err)
+				}
+				b.StartTimer()
+
+				for _, block := range blocks {
+					_, _, err := chain.ProcessBlock(block,
+						bench.flags)
+					if err != nil {
+						b.Fatalf("block %v (height %d) "+
+							"rejected: %v", block.Hash(),
+							block.Height(), err)
+					}
+				}
+				if err := chain.ConnectPendingBlocks(); err != nil {
+					b.Fatalf("ConnectPendingBlocks: %v", err)
+				}
+
+				b.StopTimer()
+				best := chain.BestSnapshot()
+				if best.Hash != *blocks[len(blocks)-1].Hash() {
+					b.Fatalf("got tip %v, want %v", best.Hash,
+						blocks[len(blocks)-1].Hash())
+				}
+				teardownFunc()
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -31,6 +31,25 @@ const (
 	// not be performed.
 	BFNoPoWCheck
 
+	// BFPipelineScripts may be set to indicate the scripts of a block which
+	// extends the main chain, or the last block processed with the flag,
+	// can be validated in the background while the block processed before
+	// it is connected.  The block is then only connected once the next
+	// block is processed, or ConnectPendingBlocks is called.  This is
+	// primarily used during the initial block download, where the blocks
+	// are processed back to back.
+	//
+	// Since such a block isn't connected yet, it is not reported as being
+	// on the main chain.  A block failing its script validation is marked
+	// as invalid along with the blocks processed after it, and is reported
+	// with a PendingBlockError by the call which would have connected it.
+	BFPipelineScripts
+
+	// BFNoSignetCheck may be set to indicate the signet solution of a block
+	// will not be checked.  This is used for the block templates created by
+	// the miner since they are only signed once they have been created.
+	BFNoSignetCheck
+
 	// BFNone is a convenience value to specifically indicate no flags.
 	BFNone BehaviorFlags = 0
 )
@@ -229,6 +248,10 @@ func (b *BlockChain) ProcessHeaderUBlock(ublock *btcutil.UBlock, uView *UtreexoV
 	if err != nil {
 		return false, false, err
 	}
+	err = b.checkSignetBlock(ublock.Block(), flags)
+	if err != nil {
+		return false, false, err
+	}
 
 	// The block has passed all context independent checks and appears sane
 	// enough to potentially accept it into the block chain.
@@ -286,6 +309,10 @@ func (b *BlockChain) ProcessBlock(block *btcutil.Block, flags BehaviorFlags) (bo
 	if err != nil {
 		return false, false, err
 	}
+	err = b.checkSignetBlock(block, flags)
+	if err != nil {
+		return false, false, err
+	}
 
 	// Find the previous checkpoint and perform some additional checks based
 	// on the checkpoint.  This provides a few nice properties such as
@@ -452,6 +479,10 @@ func (b *BlockChain) ProcessUBlock(ublock *btcutil.UBlock, flags BehaviorFlags)
 	if err != nil {
 		return false, false, err
 	}
+	err = b.checkSignetBlock(ublock.Block(), flags)
+	if err != nil {
+		return false, false, err
+	}
 
 	// Find the previous checkpoint and perform some additional checks based
 	// on the checkpoint.  This provides a few nice properties such as
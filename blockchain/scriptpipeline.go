@@ -0,0 +1,391 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// pendingBlock is a block extending the main chain which passed all of the
+// connection checks except for its scripts, which are being validated in the
+// background.  It is connected once they are found to be valid.
+type pendingBlock struct {
+	node   *blockNode
+	block  *btcutil.Block
+	ublock *btcutil.UBlock
+
+	// view and stxos are the utxo view and the spent txouts of the block as
+	// built by its connection checks.
+	view  *UtxoViewpoint
+	stxos []SpentTxOut
+
+	// uViewBefore is the serialized utreexo accumulator of compact state
+	// nodes before it was modified by the ublock.  The accumulator is
+	// restored to it when the block turns out to be invalid.
+	uViewBefore []byte
+
+	// result receives the result of the validation of the scripts.
+	result chan error
+}
+
+// pendingUtxoSource is a utxoBatcher which looks up the outputs in the views of
+// the blocks pending script validation, the most recent first, before the utxo
+// cache.  It is only used to load the inputs of the next block of the pipeline.
+type pendingUtxoSource struct {
+	pending []*pendingBlock
+	cache   *utxoCache
+}
+
+// Ensure pendingUtxoSource implements the utxoBatcher interface.
+var _ utxoBatcher = (*pendingUtxoSource)(nil)
+
+// lookup returns the entry for the outpoint in the views of the pending
+// blocks along with whether any of them has one.
+func (s *pendingUtxoSource) lookup(outpoint wire.OutPoint) (*UtxoEntry, bool) {
+	for i := len(s.pending) - 1; i >= 0; i-- {
+		entry, ok := s.pending[i].view.entries[outpoint]
+		if ok {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// getEntry returns the entry for the outpoint from the point of view of the
+// last pending block.
+//
+// This method is part of the utxoView interface.
+func (s *pendingUtxoSource) getEntry(outpoint wire.OutPoint) (*UtxoEntry, error) {
+	if entry, ok := s.lookup(outpoint); ok {
+		return entry, nil
+	}
+	return s.cache.getEntry(outpoint)
+}
+
+// getEntries returns the entries for the outpoints from the point of view of
+// the last pending block.
+//
+// This method is part of the utxoBatcher interface.
+func (s *pendingUtxoSource) getEntries(outpoints map[wire.OutPoint]struct{}) (
+	map[wire.OutPoint]*UtxoEntry, error) {
+
+	entries := make(map[wire.OutPoint]*UtxoEntry, len(outpoints))
+	missing := make(map[wire.OutPoint]struct{})
+	for op := range outpoints {
+		if entry, ok := s.lookup(op); ok {
+			if entry != nil {
+				entries[op] = entry
+			}
+			continue
+		}
+		missing[op] = struct{}{}
+	}
+	if len(missing) == 0 {
+		return entries, nil
+	}
+
+	cached, err := s.cache.getEntries(missing)
+	if err != nil {
+		return nil, err
+	}
+	for op, entry := range cached {
+		entries[op] = entry
+	}
+	return entries, nil
+}
+
+// addEntry is part of the utxoView interface.  The source is read-only.
+func (s *pendingUtxoSource) addEntry(outpoint wire.OutPoint, entry *UtxoEntry,
+	overwrite bool) error {
+
+	return AssertError("addEntry called on the pending utxo source")
+}
+
+// spendEntry is part of the utxoView interface.  The source is read-only.
+func (s *pendingUtxoSource) spendEntry(outpoint wire.OutPoint,
+	addIfNil *UtxoEntry) error {
+
+	return AssertError("spendEntry called on the pending utxo source")
+}
+
+// pendingUtxoSource returns the source the inputs of the next block extending
+// the pipeline are loaded from.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) pendingUtxoSource() utxoBatcher {
+	if len(b.pendingBlocks) == 0 {
+		return b.utxoCache
+	}
+	return &pendingUtxoSource{
+		pending: b.pendingBlocks,
+		cache:   b.utxoCache,
+	}
+}
+
+// lookupPendingEntry returns the entry for the outpoint in the views of the
+// blocks pending script validation along with whether any of them has one.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) lookupPendingEntry(outpoint wire.OutPoint) (*UtxoEntry, bool) {
+	source := pendingUtxoSource{pending: b.pendingBlocks}
+	return source.lookup(outpoint)
+}
+
+// pipelineTip returns the node the next block of the script validation
+// pipeline must extend, which is the tip of the main chain when no block is
+// pending.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) pipelineTip() *blockNode {
+	if len(b.pendingBlocks) == 0 {
+		return b.bestChain.Tip()
+	}
+	return b.pendingBlocks[len(b.pendingBlocks)-1].node
+}
+
+// canPipelineBlock returns whether the passed block, which is processed with
+// the passed flags, can be added to the script validation pipeline.  This is
+// the case when it extends the pipeline tip and its scripts need to be
+// validated.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) canPipelineBlock(node *blockNode, parentHash *chainhash.Hash,
+	flags BehaviorFlags) bool {
+
+	if flags&BFPipelineScripts != BFPipelineScripts ||
+		flags&BFFastAdd == BFFastAdd {
+
+		return false
+	}
+	if b.index.NodeStatus(node).KnownValid() {
+		return false
+	}
+
+	// Compact state nodes verifying a utreexo root hint don't keep the
+	// blocks they connect.
+	if b.utreexoRootToVerify != nil {
+		return false
+	}
+	return parentHash.IsEqual(&b.pipelineTip().hash)
+}
+
+// pipelineBlock performs the connection checks of the passed block or ublock,
+// which must extend the pipeline tip, and starts validating its scripts in the
+// background.  The blocks pending before it are then connected once their
+// scripts are validated, so the scripts of the block are validated while they
+// are committed and the next block is fetched and its inputs loaded.
+//
+// An error is returned when the checks of the block fail.  When a block pending
+// before it turns out to be invalid, it is marked as such, every pending block
+// after it, including the passed one, is marked as descending from an invalid
+// block, and a PendingBlockError identifying the invalid block is returned.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) pipelineBlock(node *blockNode, block *btcutil.Block,
+	ublock *btcutil.UBlock) error {
+
+	if ublock != nil {
+		block = ublock.Block()
+	}
+	pb := &pendingBlock{
+		node:   node,
+		block:  block,
+		ublock: ublock,
+		view:   NewUtxoViewpoint(),
+		result: make(chan error, 1),
+	}
+
+	var scriptFlags txscript.ScriptFlags
+	var runScripts bool
+	var err error
+	if ublock != nil {
+		pb.uViewBefore, err = serializeUtreexoView(b.utreexoViewpoint)
+		if err != nil {
+			return err
+		}
+		scriptFlags, runScripts, err = b.checkConnectUBlockDeferScripts(
+			node, ublock, pb.view)
+		if err != nil {
+			// The accumulator may have been modified by the
+			// ublock before it was found to be invalid.
+			restoreErr := b.restoreUtreexoView(pb.uViewBefore)
+			if restoreErr != nil {
+				return restoreErr
+			}
+		}
+	} else {
+		pb.stxos = make([]SpentTxOut, 0, countSpentOutputs(block))
+		scriptFlags, runScripts, err = b.checkConnectBlockDeferScripts(
+			node, block, pb.view, &pb.stxos)
+	}
+	if err != nil {
+		if _, ok := err.(RuleError); ok {
+			b.index.SetStatusFlags(node, statusValidateFailed)
+			b.flushPendingIndexState()
+		}
+		return err
+	}
+
+	// The scripts only read the view, which is left alone until the block
+	// is connected.
+	if runScripts {
+		go func() {
+			pb.result <- checkBlockScripts(block, pb.view,
+				scriptFlags, b.sigCache, b.hashCache,
+				b.scriptCache)
+		}()
+	} else {
+		pb.result <- nil
+	}
+	b.pendingBlocks = append(b.pendingBlocks, pb)
+
+	_, err = b.connectPendingBlocks(1)
+	return err
+}
+
+// connectPendingBlocks waits for the scripts of the blocks pending script
+// validation to be validated and connects them in order, until only the passed
+// number of blocks are left pending.
+//
+// When a block turns out to be invalid, it is marked as such, the blocks
+// pending after it are marked as descending from an invalid block and dropped,
+// and the utreexo accumulator of compact state nodes is restored to its state
+// before the block.  The node of the block is returned along with its error,
+// which is wrapped in a PendingBlockError when it is a rule error.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) connectPendingBlocks(keep int) (*blockNode, error) {
+	for len(b.pendingBlocks) > keep {
+		pb := b.pendingBlocks[0]
+		err := <-pb.result
+		if err == nil {
+			// The block is popped before it is connected since the
+			// chain lock is released while notifying the caller.
+			b.pendingBlocks = b.pendingBlocks[1:]
+			b.index.SetStatusFlags(pb.node, statusValid)
+			if pb.ublock != nil {
+				err = b.connectUBlock(pb.node, pb.ublock)
+			} else {
+				err = b.connectBlock(pb.node, pb.block, pb.view,
+					pb.stxos)
+			}
+			if err == nil {
+				continue
+			}
+
+			// The block is put back so it's dropped along with the
+			// blocks descending from it.
+			b.pendingBlocks = append([]*pendingBlock{pb},
+				b.pendingBlocks...)
+		}
+
+		if rerr, ok := err.(RuleError); ok {
+			err = PendingBlockError{
+				Hash:   pb.node.hash,
+				Height: pb.node.height,
+				Err:    rerr,
+			}
+			log.Warnf("%v", err)
+			b.index.UnsetStatusFlags(pb.node, statusValid)
+			b.index.SetStatusFlags(pb.node, statusValidateFailed)
+		}
+		b.dropPendingBlocks()
+		return pb.node, err
+	}
+
+	return nil, nil
+}
+
+// dropPendingBlocks drops all of the blocks pending script validation after
+// the first one turned out to be invalid, which it marks as descending from an
+// invalid block, and restores the utreexo accumulator of compact state nodes to
+// its state before the first one.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) dropPendingBlocks() {
+	for _, pb := range b.pendingBlocks[1:] {
+		b.index.SetStatusFlags(pb.node, statusInvalidAncestor)
+	}
+
+	first := b.pendingBlocks[0]
+	b.pendingBlocks = nil
+	if first.ublock != nil {
+		if err := b.restoreUtreexoView(first.uViewBefore); err != nil {
+			log.Errorf("Unable to restore the utreexo accumulator "+
+				"before block %v: %v", first.node.hash, err)
+		}
+	}
+	b.flushPendingIndexState()
+}
+
+// drainPendingBlocks connects all of the blocks pending script validation so
+// the main chain reflects all of the blocks that were processed.  The passed
+// node, if any, is marked as descending from an invalid block and the
+// PendingBlockError of the invalid block is returned when it descends from a
+// pending block which turns out to be invalid.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) drainPendingBlocks(node *blockNode) error {
+	failed, err := b.connectPendingBlocks(0)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(PendingBlockError); !ok {
+		return err
+	}
+	if node == nil || node.Ancestor(failed.height) != failed {
+		return nil
+	}
+
+	b.index.SetStatusFlags(node, statusInvalidAncestor)
+	b.flushPendingIndexState()
+	return err
+}
+
+// ConnectPendingBlocks connects all of the blocks processed with the
+// BFPipelineScripts flag whose scripts are still being validated.  The
+// PendingBlockError of the first one which turns out to be invalid, if any, is
+// returned, in which case it and the blocks processed after it are not
+// connected.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ConnectPendingBlocks() error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	_, err := b.connectPendingBlocks(0)
+	return err
+}
+
+// restoreUtreexoView replaces the utreexo accumulator of the chain with the
+// passed serialized one.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) restoreUtreexoView(serialized []byte) error {
+	uView := NewUtreexoViewpoint()
+	err := deserializeUtreexoView(uView, serialized)
+	if err != nil {
+		return err
+	}
+	b.utreexoViewpoint = uView
+	return nil
+}
+
+// flushPendingIndexState writes the status changes of the block index made by
+// the pipeline to the database.  Errors are only logged, the worst that can
+// happen is invalid blocks being validated again after a restart.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) flushPendingIndexState() {
+	if b.utreexoCSN {
+		return
+	}
+	if err := b.index.flushToDB(); err != nil {
+		log.Warnf("Error flushing block index changes to disk: %v", err)
+	}
+}
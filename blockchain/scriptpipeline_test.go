@@ -0,0 +1,248 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// badScriptMunger makes the first spending transaction of a block fail its
+// script validation, which is only detected once its scripts are run.
+func badScriptMunger(msgBlock *wire.MsgBlock) {
+	msgBlock.Transactions[1].TxIn[0].SignatureScript = []byte{
+		txscript.OP_RETURN,
+	}
+}
+
+// TestPipelineScripts ensures blocks processed with the BFPipelineScripts flag
+// are connected once the next block is processed, including blocks spending the
+// outputs of the block pending before them.
+func TestPipelineScripts(t *testing.T) {
+	t.Parallel()
+
+	chain, params, tearDown := utxoCacheTestChain("TestPipelineScripts")
+	defer tearDown()
+	tip := btcutil.NewBlock(params.GenesisBlock)
+	tip, spends := addBlock(chain, tip, nil)
+
+	// Each block spends all of the outputs of the block before it, which
+	// is still pending when it is processed.
+	var spent []*spendableOut
+	for i := 0; i < 5; i++ {
+		prevHash := *tip.Hash()
+		block, outs := newTestBlock(chain, tip, spends)
+		isMainChain, isOrphan, err := chain.ProcessBlock(block,
+			BFPipelineScripts)
+		if err != nil {
+			t.Fatalf("block %d: unexpected error: %v", i, err)
+		}
+		if isMainChain || isOrphan {
+			t.Fatalf("block %d: got main chain %v and orphan %v, "+
+				"want pending", i, isMainChain, isOrphan)
+		}
+		if best := chain.BestSnapshot(); best.Hash != prevHash {
+			t.Fatalf("block %d: got tip %v, want %v", i, best.Hash,
+				prevHash)
+		}
+
+		spent = append(spent, spends...)
+		tip, spends = block, outs
+	}
+
+	if err := chain.ConnectPendingBlocks(); err != nil {
+		t.Fatalf("ConnectPendingBlocks: unexpected error: %v", err)
+	}
+	if best := chain.BestSnapshot(); best.Hash != *tip.Hash() {
+		t.Fatalf("got tip %v, want %v", best.Hash, tip.Hash())
+	}
+	if !chain.index.NodeStatus(chain.bestChain.Tip()).KnownValid() {
+		t.Fatal("the last block is not marked as valid")
+	}
+
+	for _, out := range spent {
+		entry, err := chain.FetchUtxoEntry(out.prevOut)
+		if err != nil {
+			t.Fatalf("unable to fetch %v: %v", out.prevOut, err)
+		}
+		if entry != nil && !entry.IsSpent() {
+			t.Fatalf("spent output %v is unspent", out.prevOut)
+		}
+	}
+	for _, out := range spends {
+		entry, err := chain.FetchUtxoEntry(out.prevOut)
+		if err != nil {
+			t.Fatalf("unable to fetch %v: %v", out.prevOut, err)
+		}
+		if entry == nil || entry.IsSpent() {
+			t.Fatalf("unspent output %v is missing", out.prevOut)
+		}
+	}
+}
+
+// TestPipelineScriptsRollback ensures a pending block failing its script
+// validation is not connected, is reported against its own hash, is marked as
+// invalid along with the blocks descending from it, and leaves the chain at the
+// block before it.
+func TestPipelineScriptsRollback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		// childFlags are the flags the child of the invalid block is
+		// processed with.
+		childFlags BehaviorFlags
+	}{{
+		name:       "pipelined child",
+		childFlags: BFPipelineScripts,
+	}, {
+		name:       "child",
+		childFlags: BFNone,
+	}}
+
+	for _, test := range tests {
+		chain, params, tearDown := utxoCacheTestChain(
+			"TestPipelineScriptsRollback")
+		tip := btcutil.NewBlock(params.GenesisBlock)
+		tip, spends := addBlock(chain, tip, nil)
+
+		// The valid block is connected once the invalid one is
+		// processed, which is only found to be invalid when its child
+		// is.
+		valid, outs := newTestBlock(chain, tip, spends)
+		invalid, _ := newTestBlock(chain, valid, outs, badScriptMunger)
+		child, _ := newTestBlock(chain, invalid, nil)
+		grandChild, _ := newTestBlock(chain, child, nil)
+		for _, block := range []*btcutil.Block{valid, invalid} {
+			_, _, err := chain.ProcessBlock(block, BFPipelineScripts)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+		}
+		if best := chain.BestSnapshot(); best.Hash != *valid.Hash() {
+			t.Fatalf("%s: got tip %v, want %v", test.name, best.Hash,
+				valid.Hash())
+		}
+
+		// The child is rejected with the error of the invalid block.
+		_, _, err := chain.ProcessBlock(child, test.childFlags)
+		perr, ok := err.(PendingBlockError)
+		if !ok || perr.Hash != *invalid.Hash() ||
+			perr.Height != 3 || perr.Err.ErrorCode != ErrScriptValidation {
+			t.Fatalf("%s: got error %v, want %v for block %v",
+				test.name, err, ErrScriptValidation, invalid.Hash())
+		}
+		if best := chain.BestSnapshot(); best.Hash != *valid.Hash() {
+			t.Fatalf("%s: got tip %v, want %v", test.name, best.Hash,
+				valid.Hash())
+		}
+
+		invalidNode := chain.index.LookupNode(invalid.Hash())
+		status := chain.index.NodeStatus(invalidNode)
+		if status&statusValidateFailed == 0 || status.KnownValid() {
+			t.Fatalf("%s: got invalid block status %v", test.name,
+				status)
+		}
+		childNode := chain.index.LookupNode(child.Hash())
+		status = chain.index.NodeStatus(childNode)
+		if status&statusInvalidAncestor == 0 {
+			t.Fatalf("%s: got child status %v", test.name, status)
+		}
+		if !chain.IsKnownInvalid(invalid.Hash()) ||
+			!chain.IsKnownInvalid(child.Hash()) {
+
+			t.Fatalf("%s: invalid blocks not known to be invalid",
+				test.name)
+		}
+		if chain.IsKnownInvalid(valid.Hash()) ||
+			chain.IsKnownInvalid(grandChild.Hash()) {
+
+			t.Fatalf("%s: valid or unknown block known to be invalid",
+				test.name)
+		}
+
+		// The descendants of the invalid block are rejected.
+		_, _, err = chain.ProcessBlock(grandChild, BFPipelineScripts)
+		if !isRuleErrorCode(err, ErrInvalidAncestorBlock) {
+			t.Fatalf("%s: got error %v, want %v", test.name, err,
+				ErrInvalidAncestorBlock)
+		}
+
+		// The outputs the invalid block spent are still unspent, and
+		// can be spent by another block.
+		for _, out := range outs {
+			entry, err := chain.FetchUtxoEntry(out.prevOut)
+			if err != nil {
+				t.Fatalf("%s: unable to fetch %v: %v", test.name,
+					out.prevOut, err)
+			}
+			if entry == nil || entry.IsSpent() {
+				t.Fatalf("%s: output %v is missing", test.name,
+					out.prevOut)
+			}
+		}
+		replacement, _ := newTestBlock(chain, valid, outs)
+		isMainChain, _, err := chain.ProcessBlock(replacement,
+			BFPipelineScripts)
+		if err != nil || isMainChain {
+			t.Fatalf("%s: got main chain %v and error %v for the "+
+				"pending replacement block", test.name, isMainChain,
+				err)
+		}
+		if err := chain.ConnectPendingBlocks(); err != nil {
+			t.Fatalf("%s: ConnectPendingBlocks: unexpected error: %v",
+				test.name, err)
+		}
+		best := chain.BestSnapshot()
+		if best.Hash != *replacement.Hash() {
+			t.Fatalf("%s: got tip %v, want %v", test.name, best.Hash,
+				replacement.Hash())
+		}
+
+		tearDown()
+	}
+}
+
+// isRuleErrorCode returns whether the passed error is a rule error with the
+// passed code.
+func isRuleErrorCode(err error, code ErrorCode) bool {
+	rerr, ok := err.(RuleError)
+	return ok && rerr.ErrorCode == code
+}
+
+// TestFlushMemBlockStorePending ensures the blocks pending script validation
+// are connected before the block kept in memory is flushed on shutdown.
+func TestFlushMemBlockStorePending(t *testing.T) {
+	t.Parallel()
+
+	chain, params, tearDown := utxoCacheTestChain(
+		"TestFlushMemBlockStorePending")
+	defer tearDown()
+	tip := btcutil.NewBlock(params.GenesisBlock)
+	tip, spends := addBlock(chain, tip, nil)
+	block, _ := newTestBlock(chain, tip, spends)
+
+	// The block kept in memory stands in for the one a compact state node
+	// would keep, which is not stored yet.
+	sibling, _ := newTestBlock(chain, tip, nil)
+	chain.memBlock = &memBlockStore{block: sibling}
+	if _, _, err := chain.ProcessBlock(block, BFPipelineScripts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := chain.FlushMemBlockStore(); err != nil {
+		t.Fatalf("FlushMemBlockStore: unexpected error: %v", err)
+	}
+	if best := chain.BestSnapshot(); best.Hash != *block.Hash() {
+		t.Fatalf("got tip %v, want %v", best.Hash, block.Hash())
+	}
+	if len(chain.pendingBlocks) != 0 {
+		t.Fatalf("got %d pending blocks, want none",
+			len(chain.pendingBlocks))
+	}
+}
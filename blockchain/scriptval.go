@@ -10,6 +10,8 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
@@ -34,6 +36,10 @@ type txValidator struct {
 	flags        txscript.ScriptFlags
 	sigCache     *txscript.SigCache
 	hashCache    *txscript.HashCache
+
+	// schnorrBatch, when set, collects the Schnorr signatures of the
+	// validated inputs so they can be verified at once afterwards.
+	schnorrBatch *schnorr.BatchVerifier
 }
 
 // sendResult sends the result of a script pair validation on the internal
@@ -76,7 +82,7 @@ out:
 			inputAmount := utxo.Amount()
 			vm, err := txscript.NewEngine(pkScript, txVI.tx.MsgTx(),
 				txVI.txInIndex, v.flags, v.sigCache, txVI.sigHashes,
-				inputAmount)
+				inputAmount, v.utxoView)
 			if err != nil {
 				str := fmt.Sprintf("failed to parse input "+
 					"%s:%d which references output %v - "+
@@ -89,6 +95,9 @@ out:
 				v.sendResult(err)
 				break out
 			}
+			if v.schnorrBatch != nil {
+				vm.SetSchnorrBatchVerifier(v.schnorrBatch)
+			}
 
 			// Execute the script pair.
 			if err := vm.Execute(); err != nil {
@@ -188,9 +197,18 @@ func newTxValidator(utxoView *UtxoViewpoint, flags txscript.ScriptFlags,
 
 // ValidateTransactionScripts validates the scripts for the passed transaction
 // using multiple goroutines.
+//
+// When a script execution cache is passed, the transaction is not validated
+// again if it is found in the cache, and is added to it once its scripts are
+// found to be valid.  The cache can be nil.
 func ValidateTransactionScripts(tx *btcutil.Tx, utxoView *UtxoViewpoint,
 	flags txscript.ScriptFlags, sigCache *txscript.SigCache,
-	hashCache *txscript.HashCache) error {
+	hashCache *txscript.HashCache,
+	scriptCache *txscript.ScriptExecCache) error {
+
+	if scriptCache != nil && scriptCache.Exists(*tx.WitnessHash(), flags) {
+		return nil
+	}
 
 	// First determine if segwit is active according to the scriptFlags. If
 	// it isn't then we don't need to interact with the HashCache.
@@ -201,7 +219,7 @@ func ValidateTransactionScripts(tx *btcutil.Tx, utxoView *UtxoViewpoint,
 	// amongst all worker validation goroutines.
 	if segwitActive && tx.MsgTx().HasWitness() &&
 		!hashCache.ContainsHashes(tx.Hash()) {
-		hashCache.AddSigHashes(tx.MsgTx())
+		hashCache.AddSigHashes(tx.MsgTx(), utxoView)
 	}
 
 	var cachedHashes *txscript.TxSigHashes
@@ -235,14 +253,23 @@ func ValidateTransactionScripts(tx *btcutil.Tx, utxoView *UtxoViewpoint,
 
 	// Validate all of the inputs.
 	validator := newTxValidator(utxoView, flags, sigCache, hashCache)
-	return validator.Validate(txValItems)
+	if err := validator.Validate(txValItems); err != nil {
+		return err
+	}
+
+	if scriptCache != nil {
+		scriptCache.Add(*tx.WitnessHash(), flags)
+	}
+	return nil
 }
 
 // checkBlockScripts executes and validates the scripts for all transactions in
-// the passed block using multiple goroutines.
+// the passed block using multiple goroutines.  The transactions found in the
+// passed script execution cache, which can be nil, are skipped.
 func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
 	scriptFlags txscript.ScriptFlags, sigCache *txscript.SigCache,
-	hashCache *txscript.HashCache) error {
+	hashCache *txscript.HashCache,
+	scriptCache *txscript.ScriptExecCache) error {
 
 	// First determine if segwit is active according to the scriptFlags. If
 	// it isn't then we don't need to interact with the HashCache.
@@ -256,6 +283,15 @@ func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
 	}
 	txValItems := make([]*txValidateItem, 0, numInputs)
 	for _, tx := range block.Transactions() {
+		// The scripts of the transactions already validated, typically
+		// on their acceptance to the mempool, don't need to be executed
+		// again.
+		if scriptCache != nil &&
+			scriptCache.Exists(*tx.WitnessHash(), scriptFlags) {
+
+			continue
+		}
+
 		hash := tx.Hash()
 
 		// If the HashCache is present, and it doesn't yet contain the
@@ -266,7 +302,7 @@ func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
 		if segwitActive && tx.HasWitness() && hashCache != nil &&
 			!hashCache.ContainsHashes(hash) {
 
-			hashCache.AddSigHashes(tx.MsgTx())
+			hashCache.AddSigHashes(tx.MsgTx(), utxoView)
 		}
 
 		var cachedHashes *txscript.TxSigHashes
@@ -274,7 +310,8 @@ func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
 			if hashCache != nil {
 				cachedHashes, _ = hashCache.GetSigHashes(hash)
 			} else {
-				cachedHashes = txscript.NewTxSigHashes(tx.MsgTx())
+				cachedHashes = txscript.NewTxSigHashes(tx.MsgTx(),
+					utxoView)
 			}
 		}
 
@@ -294,12 +331,43 @@ func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
 		}
 	}
 
-	// Validate all of the inputs.
+	// Validate all of the inputs.  Once taproot is active, the Schnorr
+	// signatures of the block are collected and verified as a single
+	// batch after all of the scripts have been executed.
 	validator := newTxValidator(utxoView, scriptFlags, sigCache, hashCache)
+	taprootActive := scriptFlags&txscript.ScriptVerifyTaproot ==
+		txscript.ScriptVerifyTaproot
+	if taprootActive {
+		validator.schnorrBatch = schnorr.NewBatchVerifier()
+	}
 	start := time.Now()
 	if err := validator.Validate(txValItems); err != nil {
 		return err
 	}
+
+	// A batch only tells that one of its signatures is invalid, so
+	// validate the inputs again verifying each signature on its own to
+	// find the offending input when the batch fails.
+	if taprootActive && !validator.schnorrBatch.Verify() {
+		validator = newTxValidator(utxoView, scriptFlags, sigCache,
+			hashCache)
+		if err := validator.Validate(txValItems); err != nil {
+			return err
+		}
+		str := fmt.Sprintf("block %v failed batch verification of its "+
+			"schnorr signatures", block.Hash())
+		return ruleError(ErrScriptValidation, str)
+	}
+
+	// The signatures of a valid batch are valid, so add them to the
+	// signature cache like the ones verified on their own.
+	if taprootActive && sigCache != nil {
+		validator.schnorrBatch.ForEach(func(hash, sig, pubKey []byte) {
+			var sigHash chainhash.Hash
+			copy(sigHash[:], hash)
+			sigCache.Add(sigHash, sig, pubKey)
+		})
+	}
 	elapsed := time.Since(start)
 
 	log.Tracef("block %v took %v to verify", block.Hash(), elapsed)
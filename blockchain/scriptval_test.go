@@ -5,10 +5,17 @@
 package blockchain
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"math"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 )
 
 // TestCheckBlockScripts ensures that validating the all of the scripts in a
@@ -38,9 +45,125 @@ func testCheckBlockScripts(t *testing.T) {
 	}
 
 	scriptFlags := txscript.ScriptBip16
-	err = checkBlockScripts(blocks[0], view, scriptFlags, nil, nil)
+	err = checkBlockScripts(blocks[0], view, scriptFlags, nil, nil, nil)
 	if err != nil {
 		t.Errorf("Transaction script validation failed: %v\n", err)
 		return
 	}
 }
+
+// TestCheckBlockScriptsCache ensures the scripts of the transactions found in
+// the script execution cache are not executed again when a block including
+// them is validated.
+func TestCheckBlockScriptsCache(t *testing.T) {
+	t.Parallel()
+
+	chain, params, tearDown := utxoCacheTestChain("TestCheckBlockScriptsCache")
+	defer tearDown()
+	chain.scriptCache = txscript.NewScriptExecCache(10)
+	tip := btcutil.NewBlock(params.GenesisBlock)
+	tip, spends := addBlock(chain, tip, nil)
+
+	// A transaction with an invalid script is only caught when it isn't
+	// known to the cache.  The standard flags it is added with are a
+	// superset of the flags the block is validated with.
+	bad, _ := newTestBlock(chain, tip, spends, badScriptMunger)
+	_, _, err := chain.ProcessBlock(bad, BFNone)
+	if !isRuleErrorCode(err, ErrScriptValidation) {
+		t.Fatalf("got error %v, want %v", err, ErrScriptValidation)
+	}
+
+	cached, _ := newTestBlock(chain, tip, spends, badScriptMunger)
+	chain.scriptCache.Add(*cached.Transactions()[1].WitnessHash(),
+		txscript.StandardVerifyFlags)
+	isMainChain, _, err := chain.ProcessBlock(cached, BFNone)
+	if err != nil || !isMainChain {
+		t.Fatalf("got main chain %v and error %v for the block with a "+
+			"cached transaction", isMainChain, err)
+	}
+}
+
+// TestCheckBlockScriptsSchnorrSigCache ensures the Schnorr signatures of a
+// block which pass batch verification are added to the signature cache.
+func TestCheckBlockScriptsSchnorrSigCache(t *testing.T) {
+	t.Parallel()
+
+	// Create an output paying to a taproot key.
+	keyBytes := sha256.Sum256([]byte("batch sigcache key"))
+	privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes[:])
+	outputKey, err := txscript.ComputeTaprootKeyNoScript(pubKey)
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(&wire.TxIn{})
+	prevTx.AddTxOut(wire.NewTxOut(100000, pkScript))
+	view := NewUtxoViewpoint()
+	view.AddTxOuts(btcutil.NewTx(prevTx), 1)
+
+	// Spend it with a key path signature in a block.
+	spendTx := wire.NewMsgTx(2)
+	spendTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: prevTx.TxHash()},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	spendTx.AddTxOut(wire.NewTxOut(90000, []byte{txscript.OP_TRUE}))
+	sigHashes := txscript.NewTxSigHashes(spendTx, view)
+	hash, err := txscript.CalcTaprootSignatureHash(sigHashes,
+		txscript.SigHashDefault, spendTx, 0, view)
+	if err != nil {
+		t.Fatalf("unable to compute sighash: %v", err)
+	}
+	tweakedKey, err := txscript.TweakTaprootPrivKey(privKey, nil)
+	if err != nil {
+		t.Fatalf("unable to tweak key: %v", err)
+	}
+	sig, err := schnorr.Sign(tweakedKey, hash)
+	if err != nil {
+		t.Fatalf("unable to sign: %v", err)
+	}
+	spendTx.TxIn[0].Witness = wire.TxWitness{sig.Serialize()}
+
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: math.MaxUint32},
+		SignatureScript:  []byte{0x51, 0x51},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_TRUE}))
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, spendTx},
+	})
+
+	var sigHash chainhash.Hash
+	copy(sigHash[:], hash)
+	pkBytes := schnorr.SerializePubKey(outputKey)
+	sigCache := txscript.NewSigCache(10)
+	err = checkBlockScripts(block, view, txscript.StandardVerifyFlags,
+		sigCache, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to check block scripts: %v", err)
+	}
+	if !sigCache.Exists(sigHash, sig.Serialize(), pkBytes) {
+		t.Fatal("batch verified signature not in the signature cache")
+	}
+
+	// An invalid signature fails the batch and is not cached.
+	badSig := sig.Serialize()
+	badSig[len(badSig)-1] ^= 0x01
+	spendTx.TxIn[0].Witness = wire.TxWitness{badSig}
+	block = btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, spendTx},
+	})
+	err = checkBlockScripts(block, view, txscript.StandardVerifyFlags,
+		sigCache, nil, nil)
+	if !isRuleErrorCode(err, ErrScriptValidation) {
+		t.Fatalf("got error %v, want %v", err, ErrScriptValidation)
+	}
+	if sigCache.Exists(sigHash, badSig, pkBytes) {
+		t.Fatal("invalid signature in the signature cache")
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// SignetHeader is the magic that marks the data push of the witness
+// commitment output of a coinbase which carries the signet solution of the
+// block as defined by BIP0325.
+var SignetHeader = [4]byte{0xec, 0xc7, 0xda, 0xa2}
+
+// signetScriptFlags are the script flags the signet solution of a block is
+// verified with.
+const signetScriptFlags = txscript.ScriptBip16 | txscript.ScriptVerifyWitness |
+	txscript.ScriptVerifyDERSignatures | txscript.ScriptStrictMultiSig
+
+// witnessCommitmentIndex returns the index of the output of the passed
+// coinbase transaction that holds the witness commitment of the block or -1
+// if there is none.  Like ExtractWitnessCommitment, the last matching output
+// is used.
+func witnessCommitmentIndex(coinbase *wire.MsgTx) int {
+	for i := len(coinbase.TxOut) - 1; i >= 0; i-- {
+		pkScript := coinbase.TxOut[i].PkScript
+		if len(pkScript) >= CoinbaseWitnessPkScriptLength &&
+			bytes.HasPrefix(pkScript, WitnessMagicBytes) {
+
+			return i
+		}
+	}
+	return -1
+}
+
+// appendSignetPush appends a push of the passed data to the script.  Unlike
+// the script builder, small values are never turned into small integer
+// opcodes so that rebuilt scripts match the ones created by bitcoind.
+func appendSignetPush(script, data []byte) []byte {
+	dataLen := len(data)
+	switch {
+	case dataLen < txscript.OP_PUSHDATA1:
+		script = append(script, byte(dataLen))
+
+	case dataLen <= 0xff:
+		script = append(script, txscript.OP_PUSHDATA1, byte(dataLen))
+
+	case dataLen <= 0xffff:
+		var buf [2]byte
+		binary.LittleEndian.PutUint16(buf[:], uint16(dataLen))
+		script = append(script, txscript.OP_PUSHDATA2)
+		script = append(script, buf[:]...)
+
+	default:
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], uint32(dataLen))
+		script = append(script, txscript.OP_PUSHDATA4)
+		script = append(script, buf[:]...)
+	}
+	return append(script, data...)
+}
+
+// rebuildSignetCommitment rebuilds the passed witness commitment script with
+// the signet solution removed and returns the rebuilt script along with the
+// solution.  The solution is carried by the first push that starts with the
+// signet header and is longer than it.  When keepHeader is set, the push is
+// replaced by a push of the bare header as done when computing the data the
+// solution signs, otherwise it is dropped.  A nil solution along with the
+// unmodified script is returned when the script doesn't carry one.
+//
+// Like FetchAndClearCommitmentSection in bitcoind, parsing silently stops at
+// the first malformed opcode, so a script that can't be parsed entirely is
+// rebuilt from the opcodes before it.
+func rebuildSignetCommitment(pkScript []byte, keepHeader bool) ([]byte, []byte) {
+	var rebuilt, solution []byte
+	found := false
+	tokenizer := txscript.MakeScriptTokenizer(0, pkScript)
+	for tokenizer.Next() {
+		data := tokenizer.Data()
+		if len(data) == 0 {
+			rebuilt = append(rebuilt, tokenizer.Opcode())
+			continue
+		}
+
+		if !found && len(data) > len(SignetHeader) &&
+			bytes.HasPrefix(data, SignetHeader[:]) {
+
+			found = true
+			solution = data[len(SignetHeader):]
+			if !keepHeader {
+				continue
+			}
+			data = SignetHeader[:]
+		}
+		rebuilt = appendSignetPush(rebuilt, data)
+	}
+	if !found {
+		return pkScript, nil
+	}
+
+	return rebuilt, solution
+}
+
+// CreateSignetTxs creates the virtual transactions used to verify the signet
+// solution of the passed block as defined by BIP0325.  The first one spends
+// nothing and pays to the challenge script while committing to the block with
+// its solution removed.  The second one spends it using the solution found in
+// the witness commitment of the coinbase, or nothing if the block doesn't
+// carry one.
+//
+// Since a solution signs the block with the push carrying it reduced to the
+// signet header, signers must store a placeholder solution with
+// SetSignetSolution before creating the transactions and signing the input of
+// the second one.
+func CreateSignetTxs(msgBlock *wire.MsgBlock, challenge []byte) (*wire.MsgTx, *wire.MsgTx, error) {
+	if len(msgBlock.Transactions) == 0 {
+		return nil, nil, ruleError(ErrNoTransactions, "block does not "+
+			"contain any transactions")
+	}
+
+	// The solution lives in the witness commitment of the coinbase, so a
+	// signet block must always have one.
+	coinbase := msgBlock.Transactions[0].Copy()
+	cidx := witnessCommitmentIndex(coinbase)
+	if cidx < 0 {
+		return nil, nil, ruleError(ErrBadSignetSolution, "signet block "+
+			"does not contain a witness commitment")
+	}
+
+	pkScript, solution := rebuildSignetCommitment(
+		coinbase.TxOut[cidx].PkScript, true,
+	)
+	coinbase.TxOut[cidx].PkScript = pkScript
+
+	// The solution is made up of the signature script followed by the
+	// witness stack spending the challenge.
+	var sigScript []byte
+	var witness wire.TxWitness
+	var err error
+	if solution != nil {
+		r := bytes.NewReader(solution)
+		maxSize := uint32(len(solution))
+		sigScript, err = wire.ReadVarBytes(r, 0, maxSize, "sigScript")
+		if err != nil {
+			str := fmt.Sprintf("unable to read signet solution "+
+				"script: %v", err)
+			return nil, nil, ruleError(ErrBadSignetSolution, str)
+		}
+		count, err := wire.ReadVarInt(r, 0)
+		if err != nil || count > uint64(r.Len()) {
+			return nil, nil, ruleError(ErrBadSignetSolution,
+				"unable to read signet solution witness")
+		}
+		witness = make(wire.TxWitness, 0, count)
+		for i := uint64(0); i < count; i++ {
+			item, err := wire.ReadVarBytes(r, 0, maxSize, "witness")
+			if err != nil {
+				str := fmt.Sprintf("unable to read signet "+
+					"solution witness: %v", err)
+				return nil, nil, ruleError(ErrBadSignetSolution, str)
+			}
+			witness = append(witness, item)
+		}
+		if r.Len() != 0 {
+			str := fmt.Sprintf("signet solution contains %d "+
+				"extraneous bytes", r.Len())
+			return nil, nil, ruleError(ErrBadSignetSolution, str)
+		}
+	}
+
+	// The solution signs the block header fields with the merkle root of
+	// the block with the solution removed.
+	txns := make([]*btcutil.Tx, 0, len(msgBlock.Transactions))
+	txns = append(txns, btcutil.NewTx(coinbase))
+	for _, tx := range msgBlock.Transactions[1:] {
+		txns = append(txns, btcutil.NewTx(tx))
+	}
+	merkles := BuildMerkleTreeStore(txns, false)
+	merkleRoot := merkles[len(merkles)-1]
+
+	header := &msgBlock.Header
+	var blockData bytes.Buffer
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(header.Version))
+	blockData.Write(buf[:])
+	blockData.Write(header.PrevBlock[:])
+	blockData.Write(merkleRoot[:])
+	binary.LittleEndian.PutUint32(buf[:], uint32(header.Timestamp.Unix()))
+	blockData.Write(buf[:])
+
+	toSpendSigScript := []byte{txscript.OP_0}
+	toSpendSigScript = appendSignetPush(toSpendSigScript, blockData.Bytes())
+
+	toSpend := wire.NewMsgTx(0)
+	toSpend.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  toSpendSigScript,
+		Sequence:         0,
+	})
+	toSpend.AddTxOut(wire.NewTxOut(0, challenge))
+
+	toSign := wire.NewMsgTx(0)
+	toSign.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash(), Index: 0},
+		SignatureScript:  sigScript,
+		Witness:          witness,
+		Sequence:         0,
+	})
+	toSign.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+
+	return toSpend, toSign, nil
+}
+
+// SetSignetSolution stores the passed signature script and witness as the
+// signet solution of the block, replacing any solution the block carried
+// before, and updates the merkle root of the block accordingly.  The block
+// must already contain a witness commitment.
+func SetSignetSolution(msgBlock *wire.MsgBlock, sigScript []byte, witness wire.TxWitness) error {
+	if len(msgBlock.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block does not contain "+
+			"any transactions")
+	}
+	coinbase := msgBlock.Transactions[0]
+	cidx := witnessCommitmentIndex(coinbase)
+	if cidx < 0 {
+		return ruleError(ErrBadSignetSolution, "signet block does not "+
+			"contain a witness commitment")
+	}
+
+	pkScript, _ := rebuildSignetCommitment(
+		coinbase.TxOut[cidx].PkScript, false,
+	)
+
+	var solution bytes.Buffer
+	solution.Write(SignetHeader[:])
+	if err := wire.WriteVarBytes(&solution, 0, sigScript); err != nil {
+		return err
+	}
+	if err := wire.WriteVarInt(&solution, 0, uint64(len(witness))); err != nil {
+		return err
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(&solution, 0, item); err != nil {
+			return err
+		}
+	}
+	coinbase.TxOut[cidx].PkScript = appendSignetPush(pkScript, solution.Bytes())
+
+	block := btcutil.NewBlock(msgBlock)
+	merkles := BuildMerkleTreeStore(block.Transactions(), false)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return nil
+}
+
+// CheckSignetSolution ensures the signet solution of the passed block
+// satisfies the passed challenge script as defined by BIP0325.
+func CheckSignetSolution(msgBlock *wire.MsgBlock, challenge []byte) error {
+	toSpend, toSign, err := CreateSignetTxs(msgBlock, challenge)
+	if err != nil {
+		return err
+	}
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		toSpend.TxOut[0].PkScript, toSpend.TxOut[0].Value,
+	)
+	sigHashes := txscript.NewTxSigHashes(toSign, prevOutFetcher)
+	vm, err := txscript.NewEngine(challenge, toSign, 0, signetScriptFlags,
+		nil, sigHashes, 0, prevOutFetcher)
+	if err == nil {
+		err = vm.Execute()
+	}
+	if err != nil {
+		str := fmt.Sprintf("signet solution of block %v does not "+
+			"satisfy the challenge: %v", msgBlock.BlockHash(), err)
+		return ruleError(ErrBadSignetSolution, str)
+	}
+
+	return nil
+}
+
+// checkSignetBlock ensures the passed block carries a valid signet solution
+// when the chain is a signet.  Unlike the proof of work, the solution is also
+// checked for block proposals since it doesn't commit to the nonce, so only
+// blocks that are processed with the BFNoSignetCheck flag are not checked.
+func (b *BlockChain) checkSignetBlock(block *btcutil.Block, flags BehaviorFlags) error {
+	if b.chainParams.SignetChallenge == nil || flags&BFNoSignetCheck == BFNoSignetCheck {
+		return nil
+	}
+	if block.Hash().IsEqual(b.chainParams.GenesisHash) {
+		return nil
+	}
+
+	return CheckSignetSolution(block.MsgBlock(), b.chainParams.SignetChallenge)
+}
@@ -0,0 +1,419 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// newSignetTestBlock returns an unsigned block whose coinbase carries a witness
+// commitment along with a second transaction so the merkle root depends on
+// more than the coinbase.
+func newSignetTestBlock() *wire.MsgBlock {
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  []byte{0x01, 0x01, 0x00},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(50e8, []byte{txscript.OP_TRUE}))
+	commitment := append([]byte(nil), WitnessMagicBytes...)
+	commitment = append(commitment, make([]byte, 32)...)
+	coinbase.AddTxOut(wire.NewTxOut(0, commitment))
+
+	spend := wire.NewMsgTx(1)
+	spend.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+	})
+	spend.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   4,
+			PrevBlock: chainhash.Hash{0x02},
+			Timestamp: time.Unix(1600000000, 0),
+			Bits:      0x1e0377ae,
+		},
+		Transactions: []*wire.MsgTx{coinbase, spend},
+	}
+	block := btcutil.NewBlock(msgBlock)
+	merkles := BuildMerkleTreeStore(block.Transactions(), false)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return msgBlock
+}
+
+// signetTestSignature returns the signature of the passed key for the signet
+// solution of the block with the passed challenge.
+func signetTestSignature(t *testing.T, msgBlock *wire.MsgBlock,
+	challenge []byte, key *btcec.PrivateKey, witness bool) []byte {
+
+	if err := SetSignetSolution(msgBlock, nil, nil); err != nil {
+		t.Fatalf("unable to store placeholder solution: %v", err)
+	}
+	_, toSign, err := CreateSignetTxs(msgBlock, challenge)
+	if err != nil {
+		t.Fatalf("unable to create signet txs: %v", err)
+	}
+
+	if !witness {
+		sig, err := txscript.RawTxInSignature(toSign, 0, challenge,
+			txscript.SigHashAll, key)
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		return sig
+	}
+
+	fetcher := txscript.NewCannedPrevOutputFetcher(challenge, 0)
+	sigHashes := txscript.NewTxSigHashes(toSign, fetcher)
+	sig, err := txscript.RawTxInWitnessSignature(toSign, sigHashes, 0, 0,
+		challenge, txscript.SigHashAll, key)
+	if err != nil {
+		t.Fatalf("unable to sign: %v", err)
+	}
+	return sig
+}
+
+// TestCheckSignetSolution ensures the signet solutions of blocks are checked
+// against the challenge as defined by BIP0325.
+func TestCheckSignetSolution(t *testing.T) {
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{0x01}, 32))
+	otherKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{0x02}, 32))
+	pubKey := key.PubKey().SerializeCompressed()
+
+	multiSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+		AddData(pubKey).AddOp(txscript.OP_1).
+		AddOp(txscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatalf("unable to build challenge: %v", err)
+	}
+	p2wpkh, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddData(btcutil.Hash160(pubKey)).Script()
+	if err != nil {
+		t.Fatalf("unable to build challenge: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		challenge []byte
+		sign      func(*wire.MsgBlock, []byte) error
+		valid     bool
+	}{{
+		name:      "OP_TRUE challenge without solution",
+		challenge: []byte{txscript.OP_TRUE},
+		valid:     true,
+	}, {
+		name:      "OP_TRUE challenge with empty solution",
+		challenge: []byte{txscript.OP_TRUE},
+		sign: func(b *wire.MsgBlock, challenge []byte) error {
+			return SetSignetSolution(b, nil, nil)
+		},
+		valid: true,
+	}, {
+		name:      "challenge without solution",
+		challenge: multiSig,
+		valid:     false,
+	}, {
+		name:      "multisig challenge",
+		challenge: multiSig,
+		sign: func(b *wire.MsgBlock, challenge []byte) error {
+			sig := signetTestSignature(t, b, challenge, key, false)
+			sigScript, err := txscript.NewScriptBuilder().
+				AddOp(txscript.OP_0).AddData(sig).Script()
+			if err != nil {
+				return err
+			}
+			return SetSignetSolution(b, sigScript, nil)
+		},
+		valid: true,
+	}, {
+		name:      "multisig challenge signed by other key",
+		challenge: multiSig,
+		sign: func(b *wire.MsgBlock, challenge []byte) error {
+			sig := signetTestSignature(t, b, challenge, otherKey, false)
+			sigScript, err := txscript.NewScriptBuilder().
+				AddOp(txscript.OP_0).AddData(sig).Script()
+			if err != nil {
+				return err
+			}
+			return SetSignetSolution(b, sigScript, nil)
+		},
+		valid: false,
+	}, {
+		name:      "p2wpkh challenge",
+		challenge: p2wpkh,
+		sign: func(b *wire.MsgBlock, challenge []byte) error {
+			sig := signetTestSignature(t, b, challenge, key, true)
+			return SetSignetSolution(b, nil, wire.TxWitness{sig, pubKey})
+		},
+		valid: true,
+	}, {
+		name:      "p2wpkh challenge signed for other block",
+		challenge: p2wpkh,
+		sign: func(b *wire.MsgBlock, challenge []byte) error {
+			sig := signetTestSignature(t, b, challenge, key, true)
+			err := SetSignetSolution(b, nil, wire.TxWitness{sig, pubKey})
+			if err != nil {
+				return err
+			}
+
+			// The signature commits to the timestamp.
+			b.Header.Timestamp = b.Header.Timestamp.Add(time.Second)
+			return nil
+		},
+		valid: false,
+	}, {
+		name:      "p2wpkh challenge signed twice",
+		challenge: p2wpkh,
+		sign: func(b *wire.MsgBlock, challenge []byte) error {
+			// Signing an already signed block must replace the
+			// previous solution.
+			err := SetSignetSolution(b, []byte{0x01}, nil)
+			if err != nil {
+				return err
+			}
+			sig := signetTestSignature(t, b, challenge, key, true)
+			return SetSignetSolution(b, nil, wire.TxWitness{sig, pubKey})
+		},
+		valid: true,
+	}}
+
+	for _, test := range tests {
+		msgBlock := newSignetTestBlock()
+		if test.sign != nil {
+			if err := test.sign(msgBlock, test.challenge); err != nil {
+				t.Fatalf("%s: unable to sign block: %v", test.name,
+					err)
+			}
+		}
+
+		err := CheckSignetSolution(msgBlock, test.challenge)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !test.valid {
+			rerr, ok := err.(RuleError)
+			if !ok || rerr.ErrorCode != ErrBadSignetSolution {
+				t.Errorf("%s: expected ErrBadSignetSolution, got %v",
+					test.name, err)
+			}
+		}
+	}
+}
+
+// TestSignetSolutionFormat ensures malformed signet solutions and blocks
+// without a witness commitment are rejected.
+func TestSignetSolutionFormat(t *testing.T) {
+	challenge := []byte{txscript.OP_TRUE}
+	tests := []struct {
+		name     string
+		solution []byte
+	}{{
+		name:     "extraneous bytes",
+		solution: []byte{0x00, 0x00, 0x00},
+	}, {
+		name:     "truncated script",
+		solution: []byte{0x02, 0x00},
+	}, {
+		name:     "truncated witness",
+		solution: []byte{0x00, 0x01, 0x02, 0x00},
+	}}
+
+	for _, test := range tests {
+		msgBlock := newSignetTestBlock()
+		coinbase := msgBlock.Transactions[0]
+		push := append(SignetHeader[:len(SignetHeader):len(SignetHeader)],
+			test.solution...)
+		coinbase.TxOut[1].PkScript = appendSignetPush(
+			coinbase.TxOut[1].PkScript, push,
+		)
+
+		err := CheckSignetSolution(msgBlock, challenge)
+		rerr, ok := err.(RuleError)
+		if !ok || rerr.ErrorCode != ErrBadSignetSolution {
+			t.Errorf("%s: expected ErrBadSignetSolution, got %v",
+				test.name, err)
+		}
+	}
+
+	// A signet block must always carry a witness commitment.
+	msgBlock := newSignetTestBlock()
+	coinbase := msgBlock.Transactions[0]
+	coinbase.TxOut = coinbase.TxOut[:1]
+	err := CheckSignetSolution(msgBlock, challenge)
+	rerr, ok := err.(RuleError)
+	if !ok || rerr.ErrorCode != ErrBadSignetSolution {
+		t.Errorf("missing commitment: expected ErrBadSignetSolution, "+
+			"got %v", err)
+	}
+	if err := SetSignetSolution(msgBlock, nil, nil); err == nil {
+		t.Errorf("missing commitment: expected error storing solution")
+	}
+}
+
+// signetBlock1Hex is block 1 of the default signet.
+const signetBlock1Hex = "00000020f61eee3b63a380a477a063af32b2bbc97c9ff9f01f2" +
+	"c4225e973988108000000f575c83235984e7dc4afc1f30944c170462e84437ab6f2d5" +
+	"2e16878a79e4678bd1914d5fae77031eccf4070001010000000001010000000000000" +
+	"000000000000000000000000000000000000000000000000000ffffffff025151feff" +
+	"ffff0200f2052a010000001600149243f727dd5343293eb83174324019ec16c2630f0" +
+	"000000000000000776a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689" +
+	"799962b48bebd836974e8cf94c4fecc7daa2490047304402205e423a8754336ca99db" +
+	"e16509b877ef1bf98d008836c725005b3c787c41ebe46022047246e4467ad7cc7f1ad" +
+	"98662afcaf14c115e0095a227c7b05c5182591c23e7e01000120000000000000000000" +
+	"000000000000000000000000000000000000000000000000000000"
+
+// signetBlock1 returns block 1 of the default signet.
+func signetBlock1(t *testing.T) *wire.MsgBlock {
+	t.Helper()
+
+	serialized, err := hex.DecodeString(signetBlock1Hex)
+	if err != nil {
+		t.Fatalf("unable to decode block: %v", err)
+	}
+	var msgBlock wire.MsgBlock
+	if err := msgBlock.Deserialize(bytes.NewReader(serialized)); err != nil {
+		t.Fatalf("unable to deserialize block: %v", err)
+	}
+	return &msgBlock
+}
+
+// TestSignetBlockVector ensures the solution of a block of the default signet
+// satisfies its challenge and that changing any of the signed fields of the
+// block invalidates it.
+func TestSignetBlockVector(t *testing.T) {
+	challenge := chaincfg.SigNetParams.SignetChallenge
+
+	msgBlock := signetBlock1(t)
+	wantHash := "00000086d6b2636cb2a392d45edc4ec544a10024d30141c9adf4bfd9de533b53"
+	if hash := msgBlock.BlockHash(); hash.String() != wantHash {
+		t.Fatalf("got block hash %v, want %v", hash, wantHash)
+	}
+	if err := CheckSignetSolution(msgBlock, challenge); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The nonce isn't signed, so grinding the proof of work doesn't
+	// invalidate the solution.
+	msgBlock.Header.Nonce++
+	if err := CheckSignetSolution(msgBlock, challenge); err != nil {
+		t.Fatalf("changed nonce: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(*wire.MsgBlock)
+		challenge []byte
+	}{{
+		name: "changed timestamp",
+		modify: func(b *wire.MsgBlock) {
+			b.Header.Timestamp = b.Header.Timestamp.Add(time.Second)
+		},
+	}, {
+		name: "changed version",
+		modify: func(b *wire.MsgBlock) {
+			b.Header.Version++
+		},
+	}, {
+		name: "changed coinbase",
+		modify: func(b *wire.MsgBlock) {
+			b.Transactions[0].TxOut[0].Value--
+		},
+	}, {
+		name:      "other challenge",
+		modify:    func(b *wire.MsgBlock) {},
+		challenge: append([]byte{txscript.OP_NOP}, challenge...),
+	}}
+
+	for _, test := range tests {
+		msgBlock := signetBlock1(t)
+		test.modify(msgBlock)
+		testChallenge := challenge
+		if test.challenge != nil {
+			testChallenge = test.challenge
+		}
+		err := CheckSignetSolution(msgBlock, testChallenge)
+		rerr, ok := err.(RuleError)
+		if !ok || rerr.ErrorCode != ErrBadSignetSolution {
+			t.Errorf("%s: expected ErrBadSignetSolution, got %v",
+				test.name, err)
+		}
+	}
+}
+
+// TestRebuildSignetCommitmentMalformed ensures parsing a witness commitment
+// stops at the first malformed opcode like bitcoind does instead of failing.
+func TestRebuildSignetCommitmentMalformed(t *testing.T) {
+	commitment := append([]byte(nil), WitnessMagicBytes...)
+	commitment = append(commitment, make([]byte, 32)...)
+	solution := append(SignetHeader[:len(SignetHeader):len(SignetHeader)],
+		0x00, 0x00)
+	pkScript := appendSignetPush(commitment, solution)
+
+	// A push running past the end of the script.
+	malformed := append(pkScript, txscript.OP_DATA_2, 0x01)
+
+	rebuilt, gotSolution := rebuildSignetCommitment(malformed, true)
+	want := appendSignetPush(commitment, SignetHeader[:])
+	if !bytes.Equal(rebuilt, want) {
+		t.Errorf("got rebuilt script %x, want %x", rebuilt, want)
+	}
+	if !bytes.Equal(gotSolution, solution[len(SignetHeader):]) {
+		t.Errorf("got solution %x, want %x", gotSolution,
+			solution[len(SignetHeader):])
+	}
+
+	// Scripts without a solution are returned unmodified.
+	noSolution := append(commitment, txscript.OP_DATA_2, 0x01)
+	rebuilt, gotSolution = rebuildSignetCommitment(noSolution, true)
+	if !bytes.Equal(rebuilt, noSolution) || gotSolution != nil {
+		t.Errorf("got rebuilt script %x and solution %x, want %x "+
+			"without solution", rebuilt, gotSolution, noSolution)
+	}
+}
+
+// TestCheckConnectBlockTemplateSignet ensures block proposals on a signet must
+// carry a valid solution unless the BFNoSignetCheck flag is set.
+func TestCheckConnectBlockTemplateSignet(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("signettemplate",
+		&chaincfg.SigNetParams)
+	if err != nil {
+		t.Fatalf("failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	msgBlock := signetBlock1(t)
+	block := btcutil.NewBlock(msgBlock)
+	if err := chain.CheckConnectBlockTemplate(block, BFNone); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Replace the signature of the solution by an invalid one.
+	err = SetSignetSolution(msgBlock, nil, wire.TxWitness{{0x01}})
+	if err != nil {
+		t.Fatalf("unable to store solution: %v", err)
+	}
+	block = btcutil.NewBlock(msgBlock)
+	err = chain.CheckConnectBlockTemplate(block, BFNone)
+	rerr, ok := err.(RuleError)
+	if !ok || rerr.ErrorCode != ErrBadSignetSolution {
+		t.Fatalf("expected ErrBadSignetSolution, got %v", err)
+	}
+	err = chain.CheckConnectBlockTemplate(block, BFNoSignetCheck)
+	if err != nil {
+		t.Fatalf("BFNoSignetCheck: unexpected error: %v", err)
+	}
+}
@@ -83,6 +83,10 @@ type thresholdConditionChecker interface {
 	// state retarget window.
 	MinerConfirmationWindow() uint32
 
+	// MinActivationHeight is the height of the first block at which a
+	// locked in rule change may become active.
+	MinActivationHeight() uint32
+
 	// Condition returns whether or not the rule change activation condition
 	// has been met.  This typically involves checking whether or not the
 	// bit associated with the condition is set, but can be more complex as
@@ -242,8 +246,11 @@ func (b *BlockChain) thresholdState(prevNode *blockNode, checker thresholdCondit
 
 		case ThresholdLockedIn:
 			// The new rule becomes active when its previous state
-			// was locked in.
-			state = ThresholdActive
+			// was locked in, unless the window starts before its
+			// minimum activation height.
+			if uint32(prevNode.height+1) >= checker.MinActivationHeight() {
+				state = ThresholdActive
+			}
 
 		// Nothing to do if the previous state is active or failed since
 		// they are both terminal states.
@@ -302,6 +309,10 @@ func (b *BlockChain) deploymentState(prevNode *blockNode, deploymentID uint32) (
 	}
 
 	deployment := &b.chainParams.Deployments[deploymentID]
+	if deployment.AlwaysActive {
+		return ThresholdActive, nil
+	}
+
 	checker := deploymentChecker{deployment: deployment, chain: b}
 	cache := &b.deploymentCaches[deploymentID]
 
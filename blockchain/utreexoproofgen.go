@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/mit-dci/utreexo/accumulator"
@@ -22,11 +24,71 @@ const (
 	// During the initial block download, a utreexo bridgenode will
 	// hold this many blocks in memory to update the ttl values
 	lookahead = 1000
+
+	// maxPrecomputedUData is the max amount of accumulator proofs generated
+	// ahead of time for block templates that the utreexo bridgenode holds
+	// onto.  Miners may be working on a few different templates at once.
+	maxPrecomputedUData = 16
 )
 
 // UtreexoBridgeState is the utreexo accumulator state for the bridgenode
 type UtreexoBridgeState struct {
+	// mtx protects the fields below.  Proving leaves isn't safe for
+	// concurrent access either, so it is held while the forest is used.
+	mtx sync.Mutex
+
 	forest *accumulator.Forest
+
+	// tip is the hash of the last block whose txos were added to the
+	// forest.
+	tip chainhash.Hash
+
+	// precomputed holds the accumulator proofs generated ahead of time for
+	// the deletions of block templates which build on precomputedTip.  They
+	// are keyed by the digest of the deleted leaves and are only valid until
+	// the forest is modified.
+	precomputed    map[chainhash.Hash]btcacc.UData
+	precomputedTip chainhash.Hash
+}
+
+// delLeavesDigest returns a digest committing to the passed leaves to be
+// deleted from the UtreexoBridgeState along with their order.
+func delLeavesDigest(dels []btcacc.LeafData) chainhash.Hash {
+	buf := make([]byte, 0, len(dels)*chainhash.HashSize)
+	for i := range dels {
+		leafHash := dels[i].LeafHash()
+		buf = append(buf, leafHash[:]...)
+	}
+	return chainhash.HashH(buf)
+}
+
+// addPrecomputed remembers the passed accumulator proof for the deletions of a
+// block building on the passed tip.  The proofs for any other tip are dropped.
+func (bs *UtreexoBridgeState) addPrecomputed(tip *chainhash.Hash, ud btcacc.UData) {
+	if bs.precomputed == nil || bs.precomputedTip != *tip {
+		bs.precomputed = make(map[chainhash.Hash]btcacc.UData)
+		bs.precomputedTip = *tip
+	}
+
+	// Evict a random proof to make room for the new one when needed.  Map
+	// iteration order is randomized in Go.
+	if len(bs.precomputed) >= maxPrecomputedUData {
+		for digest := range bs.precomputed {
+			delete(bs.precomputed, digest)
+			break
+		}
+	}
+	bs.precomputed[delLeavesDigest(ud.Stxos)] = ud
+}
+
+// lookupPrecomputed returns the accumulator proof generated ahead of time for
+// the passed deletions of a block building on the passed tip, if any.
+func (bs *UtreexoBridgeState) lookupPrecomputed(tip *chainhash.Hash, dels []btcacc.LeafData) (btcacc.UData, bool) {
+	if bs.precomputed == nil || bs.precomputedTip != *tip {
+		return btcacc.UData{}, false
+	}
+	ud, ok := bs.precomputed[delLeavesDigest(dels)]
+	return ud, ok
 }
 
 // NewUtreexoBridgeState returns a utreexo accumulator state in ram
@@ -82,6 +144,9 @@ func (b *BlockChain) WriteUtreexoBridgeState(utreexoBSPath string) error {
 	b.chainLock.Lock()
 	defer b.chainLock.Unlock()
 
+	b.UtreexoBS.mtx.Lock()
+	defer b.UtreexoBS.mtx.Unlock()
+
 	// Tells connectBlock to not update the stateSnapshot
 	b.utreexoQuit = true
 
@@ -130,23 +195,129 @@ func (b *BlockChain) UpdateUtreexoBS(block *btcutil.Block, stxos []SpentTxOut) (
 
 	adds := blockToAddLeaves(block, nil, outskip)
 
-	ud, err := btcacc.GenUData(dels, b.UtreexoBS.forest, block.Height())
-	if err != nil {
-		return nil, err
+	bs := b.UtreexoBS
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	// Reuse the proof generated for the block template this block was
+	// mined from, if any.  The deletions don't depend on the coinbase, so
+	// miners are free to change it.
+	prevHash := &block.MsgBlock().Header.PrevBlock
+	ud, ok := bs.lookupPrecomputed(prevHash, dels)
+	if !ok {
+		ud, err = btcacc.GenUData(dels, bs.forest, block.Height())
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// append space for the ttls
 	ud.TxoTTLs = make([]int32, len(adds))
 
 	// TODO don't ignore undoblock
-	_, err = b.UtreexoBS.forest.Modify(adds, ud.AccProof.Targets)
+	_, err = bs.forest.Modify(adds, ud.AccProof.Targets)
+	if err != nil {
+		return nil, err
+	}
+	bs.tip = *block.Hash()
+
+	// None of the precomputed proofs are valid for the modified forest.
+	bs.precomputed = nil
+
+	return &ud, nil
+}
+
+// PrecomputeUData generates the utreexo accumulator proof for the outputs
+// spent by the passed block template, whose height must be set, using the
+// passed view which must hold an entry for every input of the template.  The
+// entries may be marked spent.  The proof is remembered so that it is not
+// generated again when a block spending the same outputs is connected, which
+// lets a mined block be served to utreexo compact state nodes as soon as the
+// forest is modified.  Nil is returned when the chain is not a utreexo
+// bridgenode.
+//
+// The chain lock is not held, so an error is returned when the forest is not
+// at the block the template builds on, such as when the blocks connected
+// before it are still pending script validation.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PrecomputeUData(block *btcutil.Block,
+	view *UtxoViewpoint) (*btcacc.UData, error) {
+
+	if !b.utreexo {
+		return nil, nil
+	}
+
+	// Gather the outputs spent by the template in the order connectBlock
+	// does.
+	var stxos []SpentTxOut
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			entry := view.LookupEntry(txIn.PreviousOutPoint)
+			if entry == nil {
+				return nil, AssertError(fmt.Sprintf("view missing "+
+					"input %v", txIn.PreviousOutPoint))
+			}
+			stxos = append(stxos, SpentTxOut{
+				Amount:     entry.Amount(),
+				PkScript:   entry.PkScript(),
+				Height:     entry.BlockHeight(),
+				IsCoinBase: entry.IsCoinBase(),
+			})
+		}
+	}
+	inskip, _ := block.DedupeBlock()
+	dels, err := blockToDelLeaves(stxos, block, inskip)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := b.UtreexoBS
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	header := &block.MsgBlock().Header
+	if bs.tip != header.PrevBlock {
+		str := fmt.Sprintf("previous block must be the block %v the "+
+			"accumulator is at, instead got %v", bs.tip,
+			header.PrevBlock)
+		return nil, ruleError(ErrPrevBlockNotBest, str)
+	}
+
+	ud, err := btcacc.GenUData(dels, bs.forest, block.Height())
 	if err != nil {
 		return nil, err
 	}
+	bs.addPrecomputed(&bs.tip, ud)
 
 	return &ud, nil
 }
 
+// AddPrecomputedUData remembers the passed utreexo accumulator proof, which
+// was generated ahead of time for a block template building on the block with
+// the passed hash, so that it is not generated again when a block mined from
+// the template is connected.  Nothing is done when the chain is not a utreexo
+// bridgenode or when the proof is nil.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) AddPrecomputedUData(prevHash *chainhash.Hash,
+	ud *btcacc.UData) {
+
+	if !b.utreexo || ud == nil {
+		return
+	}
+
+	bs := b.UtreexoBS
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	// Proofs for blocks that don't build on the forest can't be used.
+	if bs.tip != *prevHash {
+		return
+	}
+	bs.addPrecomputed(prevHash, *ud)
+}
+
 // blockToDelLeaves takes a non-utreexo block and stxos and turns the block into
 // leaves that are to be deleted from the UtreexoBridgeState.
 func blockToDelLeaves(stxos []SpentTxOut, block *btcutil.Block, inskip []uint32) (delLeaves []btcacc.LeafData, err error) {
@@ -0,0 +1,192 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// bridgeTestChain creates a utreexo bridgenode BlockChain using the regression
+// test parameters and a coinbase maturity of 1 block.
+func bridgeTestChain(t *testing.T) (*BlockChain, *chaincfg.Params, func()) {
+	dataDir, err := ioutil.TempDir("", "utreexoproofgen")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	params := chaincfg.RegressionNetParams
+	db, err := database.Create("ffldb", filepath.Join(dataDir, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dataDir)
+	}
+
+	chain, err := New(&Config{
+		DB:           db,
+		ChainParams:  &params,
+		TimeSource:   NewMedianTime(),
+		DataDir:      dataDir,
+		Utreexo:      true,
+		UtreexoInRam: true,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	chain.TstSetCoinbaseMaturity(1)
+
+	return chain, &params, teardown
+}
+
+// templateView returns a view holding the outputs spent by the passed block
+// template, like the one its generator builds.
+func templateView(t *testing.T, chain *BlockChain, block *btcutil.Block) *UtxoViewpoint {
+	t.Helper()
+
+	chain.chainLock.Lock()
+	defer chain.chainLock.Unlock()
+
+	view := NewUtxoViewpoint()
+	if err := view.addInputUtxos(chain.pendingUtxoSource(), block); err != nil {
+		t.Fatalf("unable to fetch inputs: %v", err)
+	}
+	return view
+}
+
+// TestPrecomputeUData ensures the accumulator proofs generated for block
+// templates are the proofs stored for blocks spending the same outputs, even
+// when they have a different coinbase, and that they are dropped once the
+// forest is modified.
+func TestPrecomputeUData(t *testing.T) {
+	chain, params, teardown := bridgeTestChain(t)
+	defer teardown()
+
+	// The forest can only prove leaves once it holds at least two of them.
+	tip := btcutil.NewBlock(params.GenesisBlock)
+	tip, spends := addBlock(chain, tip, nil)
+	tip, _ = addBlock(chain, tip, nil)
+	tip, spends = addBlock(chain, tip, spends)
+
+	// The chain lock is held while the proof is generated to ensure it
+	// isn't needed.
+	template, _ := newTestBlock(chain, tip, spends)
+	view := templateView(t, chain, template)
+	chain.chainLock.Lock()
+	ud, err := chain.PrecomputeUData(template, view)
+	chain.chainLock.Unlock()
+	if err != nil {
+		t.Fatalf("PrecomputeUData: unexpected error: %v", err)
+	}
+	if ud == nil || len(ud.Stxos) != len(spends) {
+		t.Fatalf("PrecomputeUData: got proof %v, want proof of %d "+
+			"outputs", ud, len(spends))
+	}
+	if ud.Height != tip.Height()+1 {
+		t.Fatalf("PrecomputeUData: got height %d, want %d", ud.Height,
+			tip.Height()+1)
+	}
+
+	// Templates which don't build on the tip can't be proven.
+	stale, _ := newTestBlock(chain, btcutil.NewBlock(params.GenesisBlock),
+		nil)
+	_, err = chain.PrecomputeUData(stale, templateView(t, chain, stale))
+	if !isRuleErrorCode(err, ErrPrevBlockNotBest) {
+		t.Fatalf("PrecomputeUData of a stale template: got %v, want %v",
+			err, ErrPrevBlockNotBest)
+	}
+
+	// The mined block has a different coinbase than the template.
+	block, _ := newTestBlock(chain, tip, spends, func(msgBlock *wire.MsgBlock) {
+		coinbase := msgBlock.Transactions[0]
+		coinbase.TxIn[0].SignatureScript = append(
+			coinbase.TxIn[0].SignatureScript, txscript.OP_TRUE)
+	})
+	if block.Transactions()[0].Hash().IsEqual(template.Transactions()[0].Hash()) {
+		t.Fatal("mined block has the coinbase of the template")
+	}
+	prevHash := tip.Hash()
+	inskip, _ := block.DedupeBlock()
+	var stxos []SpentTxOut
+	for _, spend := range spends {
+		entry, err := chain.FetchUtxoEntry(spend.prevOut)
+		if err != nil || entry == nil {
+			t.Fatalf("unable to fetch %v: %v", spend.prevOut, err)
+		}
+		stxos = append(stxos, SpentTxOut{
+			Amount:     entry.Amount(),
+			PkScript:   entry.PkScript(),
+			Height:     entry.BlockHeight(),
+			IsCoinBase: entry.IsCoinBase(),
+		})
+	}
+	dels, err := blockToDelLeaves(stxos, block, inskip)
+	if err != nil {
+		t.Fatalf("blockToDelLeaves: unexpected error: %v", err)
+	}
+	if _, ok := chain.UtreexoBS.lookupPrecomputed(prevHash, dels); !ok {
+		t.Fatal("precomputed proof not found for the mined block")
+	}
+
+	// The proof of the template is handed back before the mined block is
+	// submitted, which makes it available again after it was evicted,
+	// unless it was made for another block.
+	chain.UtreexoBS.precomputed = nil
+	chain.AddPrecomputedUData(stale.Hash(), ud)
+	if _, ok := chain.UtreexoBS.lookupPrecomputed(prevHash, dels); ok {
+		t.Fatal("precomputed proof of another block added")
+	}
+	chain.AddPrecomputedUData(prevHash, ud)
+	if _, ok := chain.UtreexoBS.lookupPrecomputed(prevHash, dels); !ok {
+		t.Fatal("precomputed proof not added back for the mined block")
+	}
+
+	if _, _, err := chain.ProcessBlock(block, BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	stored, err := chain.FetchProof(block.Height())
+	if err != nil {
+		t.Fatalf("FetchProof: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(stored.AccProof, ud.AccProof) ||
+		!reflect.DeepEqual(stored.Stxos, ud.Stxos) {
+
+		t.Fatalf("stored proof %+v does not match the precomputed "+
+			"proof %+v", stored, ud)
+	}
+	if _, ok := chain.UtreexoBS.lookupPrecomputed(prevHash, dels); ok {
+		t.Fatal("precomputed proof kept after the forest was modified")
+	}
+}
+
+// TestPrecomputeUDataFullNode ensures no accumulator proofs are generated by
+// nodes which aren't utreexo bridgenodes.
+func TestPrecomputeUDataFullNode(t *testing.T) {
+	t.Parallel()
+
+	chain, params, tearDown := utxoCacheTestChain("TestPrecomputeUDataFullNode")
+	defer tearDown()
+	tip := btcutil.NewBlock(params.GenesisBlock)
+	tip, spends := addBlock(chain, tip, nil)
+
+	template, _ := newTestBlock(chain, tip, spends)
+	ud, err := chain.PrecomputeUData(template, templateView(t, chain, template))
+	if err != nil || ud != nil {
+		t.Fatalf("PrecomputeUData: got %v, %v, want no proof", ud, err)
+	}
+}
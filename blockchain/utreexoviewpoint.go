@@ -6,6 +6,8 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/sha512"
+	"fmt"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -30,10 +32,11 @@ func (uview *UtreexoViewpoint) Modify(ub *btcutil.UBlock) error {
 	// utreexo accumulator. h is the height of the utreexo accumulator
 	nl, h := uview.accumulator.ReconstructStats()
 
-	// ProofSanity checks the consistency of a UBlock. It checks that there are
-	// enough proofs for all the referenced txOuts and that the these proofs are
-	// for that txOut
-	err := ub.ProofSanity(inskip, nl, h)
+	// checkUBlockProofSanity checks the consistency of a UBlock. It checks that
+	// there are enough proofs for all the referenced txOuts and that the these
+	// proofs are for that txOut
+	_, err := checkUBlockProofSanity(ub, inskip, nl, h,
+		uview.accumulator.GetRoots())
 	if err != nil {
 		return err
 	}
@@ -42,7 +45,9 @@ func (uview *UtreexoViewpoint) Modify(ub *btcutil.UBlock) error {
 	// it readys the utreexo accumulator for additions/deletions.
 	err = uview.accumulator.IngestBatchProof(ub.UData().AccProof)
 	if err != nil {
-		return err
+		str := fmt.Sprintf("ublock %v at height %d: %v", ub.Hash(),
+			ub.UData().Height, err)
+		return ruleError(ErrUtreexoRootsMismatch, str)
 	}
 
 	// Remember is used to keep some utxos that will be spent in the near future
@@ -67,6 +72,220 @@ func (uview *UtreexoViewpoint) Modify(ub *btcutil.UBlock) error {
 	return nil
 }
 
+// checkUBlockProofSanity ensures the utreexo data of the passed ublock is
+// consistent with the block and an accumulator with the passed number of leaves,
+// rows and roots.  The leaf datas must match the outputs spent by the block
+// which aren't in the input skip list and must pass checkUDataProof, whose
+// reconstructed proof tree is returned.  It does not hash the proof up to the
+// roots of the accumulator.
+func checkUBlockProofSanity(ub *btcutil.UBlock, inskip []uint32, nl uint64,
+	h uint8, roots []accumulator.Hash) (map[uint64]accumulator.Hash, error) {
+
+	ud := ub.UData()
+
+	// Ensure there is a leaf data for each output spent by the block.
+	proveOPs := btcutil.BlockToDelOPs(ub.Block().MsgBlock(), inskip)
+	if len(proveOPs) != len(ud.Stxos) {
+		str := fmt.Sprintf("ublock %v at height %d spends %d outputs "+
+			"but has %d leaf datas", ub.Hash(), ud.Height,
+			len(proveOPs), len(ud.Stxos))
+		return nil, ruleError(ErrUtreexoLeafDataMismatch, str)
+	}
+	for i, op := range proveOPs {
+		stxo := &ud.Stxos[i]
+		if op.Hash != chainhash.Hash(stxo.TxHash) || op.Index != stxo.Index {
+			str := fmt.Sprintf("ublock %v at height %d spends %v but "+
+				"leaf data %d is for %s", ub.Hash(), ud.Height, op,
+				i, stxo.OPString())
+			return nil, ruleError(ErrUtreexoLeafDataMismatch, str)
+		}
+	}
+
+	desc := fmt.Sprintf("ublock %v at height %d", ub.Hash(), ud.Height)
+	return checkUDataProof(ud, nl, h, roots, desc)
+}
+
+// checkUDataProof ensures the proof of the passed utreexo data is consistent
+// with an accumulator with the passed number of leaves, rows and roots and
+// returns the reconstructed proof tree.  There must be exactly one unique
+// target within the accumulator per leaf data, the leaf datas must hash to the
+// leaves being proven, and leaves which are roots themselves must match their
+// root.  The description is used to prefix the error messages.
+func checkUDataProof(ud *btcacc.UData, nl uint64, h uint8,
+	roots []accumulator.Hash, desc string) (map[uint64]accumulator.Hash, error) {
+
+	// Ensure there is exactly one unique target for each leaf data and
+	// that all of the targets are leaves of the accumulator.
+	targets := ud.AccProof.Targets
+	if len(targets) != len(ud.Stxos) {
+		str := fmt.Sprintf("%s has %d proof targets for %d leaf datas",
+			desc, len(targets), len(ud.Stxos))
+		return nil, ruleError(ErrUtreexoProofTargets, str)
+	}
+	seen := make(map[uint64]struct{}, len(targets))
+	for _, target := range targets {
+		if target >= nl {
+			str := fmt.Sprintf("%s has proof target %d while the "+
+				"accumulator has %d leaves", desc, target, nl)
+			return nil, ruleError(ErrUtreexoProofTargets, str)
+		}
+		if _, ok := seen[target]; ok {
+			str := fmt.Sprintf("%s has duplicate proof target %d",
+				desc, target)
+			return nil, ruleError(ErrUtreexoProofTargets, str)
+		}
+		seen[target] = struct{}{}
+	}
+
+	// Ensure the proof covers all of the targets and that the leaf datas
+	// hash to the leaves in the proof.
+	proofTree, err := ud.AccProof.Reconstruct(nl, h)
+	if err != nil {
+		str := fmt.Sprintf("%s has a malformed proof: %v", desc, err)
+		return nil, ruleError(ErrUtreexoProofTargets, str)
+	}
+	for i, target := range targets {
+		leaf, ok := proofTree[target]
+		if !ok {
+			str := fmt.Sprintf("%s has no proof for target %d", desc,
+				target)
+			return nil, ruleError(ErrUtreexoProofTargets, str)
+		}
+		leafHash := accumulator.Hash(ud.Stxos[i].LeafHash())
+		if leafHash != leaf {
+			str := fmt.Sprintf("%s has leaf data for %s which doesn't "+
+				"hash to the leaf at target %d", desc,
+				ud.Stxos[i].OPString(), target)
+			return nil, ruleError(ErrUtreexoLeafDataMismatch, str)
+		}
+
+		// IngestBatchProof doesn't verify targets which are roots
+		// themselves.  A leaf is only a root when it is the last leaf
+		// of an accumulator with an odd number of leaves, in which case
+		// it is the last root.
+		if nl%2 == 1 && target == nl-1 && leafHash != roots[len(roots)-1] {
+			str := fmt.Sprintf("%s spends leaf %d which doesn't "+
+				"match its root", desc, target)
+			return nil, ruleError(ErrUtreexoRootsMismatch, str)
+		}
+	}
+
+	return proofTree, nil
+}
+
+// utreexoParentHash returns the hash of the parent of the passed children the
+// same way the accumulator does.
+func utreexoParentHash(l, r accumulator.Hash) accumulator.Hash {
+	var buf [64]byte
+	copy(buf[:32], l[:])
+	copy(buf[32:], r[:])
+	return sha512.Sum512_256(buf[:])
+}
+
+// verifyProofTree ensures the passed proof tree, as returned by
+// checkUDataProof, hashes up from the targets to the roots of an accumulator
+// with the passed number of leaves, rows and roots.  Unlike IngestBatchProof
+// it only reads the proof, so it can be used to verify against the roots of
+// an accumulator which must not be modified.
+func verifyProofTree(proofTree map[uint64]accumulator.Hash, targets []uint64,
+	nl uint64, h uint8, roots []accumulator.Hash, desc string) error {
+
+	// The roots are ordered from the tallest tree to the shortest one, so
+	// map the rows with a tree to their roots.
+	rowRoots := make(map[uint8]accumulator.Hash, len(roots))
+	idx := 0
+	for row := int(h); row >= 0; row-- {
+		if nl&(1<<uint(row)) == 0 {
+			continue
+		}
+		if idx >= len(roots) {
+			str := fmt.Sprintf("%s: accumulator with %d leaves has "+
+				"only %d roots", desc, nl, len(roots))
+			return ruleError(ErrUtreexoRootsMismatch, str)
+		}
+		rowRoots[uint8(row)] = roots[idx]
+		idx++
+	}
+
+	// Hash the targets up a row at a time until all of them reached the
+	// root of their tree.
+	nodes := make(map[uint64]accumulator.Hash, len(targets))
+	for _, target := range targets {
+		nodes[target] = proofTree[target]
+	}
+	var rowStart uint64
+	for row := uint8(0); row <= h && len(nodes) > 0; row++ {
+		// The root of the tree of this row, if any, is the last node
+		// of the row.
+		root, hasRoot := rowRoots[row]
+		rootPos := rowStart + (nl >> row) - 1
+
+		parents := make(map[uint64]accumulator.Hash, len(nodes))
+		for pos, hash := range nodes {
+			if hasRoot && pos == rootPos {
+				if hash != root {
+					str := fmt.Sprintf("%s doesn't hash to the "+
+						"root at row %d", desc, row)
+					return ruleError(ErrUtreexoRootsMismatch, str)
+				}
+				continue
+			}
+
+			sibling, ok := nodes[pos^1]
+			if !ok {
+				sibling, ok = proofTree[pos^1]
+			}
+			if !ok {
+				str := fmt.Sprintf("%s has no proof for position "+
+					"%d", desc, pos^1)
+				return ruleError(ErrUtreexoProofTargets, str)
+			}
+			parent := (pos >> 1) | (1 << h)
+			if pos&1 == 0 {
+				parents[parent] = utreexoParentHash(hash, sibling)
+			} else {
+				parents[parent] = utreexoParentHash(sibling, hash)
+			}
+		}
+		nodes = parents
+		rowStart += 1 << (h - row)
+	}
+	if len(nodes) > 0 {
+		str := fmt.Sprintf("%s doesn't hash up to the roots", desc)
+		return ruleError(ErrUtreexoRootsMismatch, str)
+	}
+
+	return nil
+}
+
+// VerifyUData ensures the passed utreexo data proves that its leaf datas are
+// in the current utreexo accumulator.  It is used to check the outputs
+// referenced by loose transactions and partially signed transactions, so unlike
+// checkUBlockProofSanity it doesn't check the leaf datas against the inputs of
+// a block.  The proof is only hashed up to the roots, so the accumulator is
+// neither modified nor copied.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) VerifyUData(ud *btcacc.UData) error {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	if b.utreexoViewpoint == nil {
+		return fmt.Errorf("no utreexo accumulator to verify against")
+	}
+
+	const desc = "utreexo data"
+	nl, h := b.utreexoViewpoint.accumulator.ReconstructStats()
+	roots := b.utreexoViewpoint.accumulator.GetRoots()
+	proofTree, err := checkUDataProof(ud, nl, h, roots, desc)
+	if err != nil {
+		return err
+	}
+
+	return verifyProofTree(proofTree, ud.AccProof.Targets, nl, h, roots,
+		desc)
+}
+
 // BlockToAddLeaves turns all the new utxos in the block into "leaves" which are 32 byte
 // hashes that are ready to be added into the utreexo accumulator. Unspendables and
 // same block spends are excluded.
@@ -0,0 +1,441 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/accumulator"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+// utreexoTestLeafDatas returns the leaf datas of the outputs of a transaction
+// paying the passed amounts.
+func utreexoTestLeafDatas(amounts []int64) []btcacc.LeafData {
+	txHash := chainhash.HashH([]byte("utreexo test funding tx"))
+	leafDatas := make([]btcacc.LeafData, 0, len(amounts))
+	for i, amount := range amounts {
+		leafDatas = append(leafDatas, btcacc.LeafData{
+			BlockHash: chainhash.HashH([]byte("utreexo test block")),
+			TxHash:    btcacc.Hash(txHash),
+			Index:     uint32(i),
+			Height:    1,
+			Amt:       amount,
+			PkScript:  []byte{0x51},
+		})
+	}
+	return leafDatas
+}
+
+// utreexoTestLeaves returns the accumulator leaves for the passed leaf datas.
+func utreexoTestLeaves(leafDatas []btcacc.LeafData) []accumulator.Leaf {
+	leaves := make([]accumulator.Leaf, 0, len(leafDatas))
+	for i := range leafDatas {
+		leaves = append(leaves, accumulator.Leaf{
+			Hash: leafDatas[i].LeafHash(),
+		})
+	}
+	return leaves
+}
+
+// utreexoTestProof returns the utreexo data proving the leaf datas at the
+// passed indexes against a forest of all of the passed leaf datas.
+func utreexoTestProof(t *testing.T, leafDatas []btcacc.LeafData,
+	spend []int) btcacc.UData {
+
+	t.Helper()
+
+	forest := accumulator.NewForest(nil, false, "", 0)
+	if _, err := forest.Modify(utreexoTestLeaves(leafDatas), nil); err != nil {
+		t.Fatalf("unable to add leaves to forest: %v", err)
+	}
+
+	ud := btcacc.UData{Height: 2}
+	hashes := make([]accumulator.Hash, 0, len(spend))
+	for _, idx := range spend {
+		ud.Stxos = append(ud.Stxos, leafDatas[idx])
+		hashes = append(hashes, leafDatas[idx].LeafHash())
+	}
+	proof, err := forest.ProveBatch(hashes)
+	if err != nil {
+		t.Fatalf("unable to prove leaves: %v", err)
+	}
+	ud.AccProof = proof
+	return ud
+}
+
+// utreexoTestBlock returns a block with a coinbase and a transaction spending
+// the outputs of the leaf datas at the passed indexes.
+func utreexoTestBlock(leafDatas []btcacc.LeafData, spend []int) wire.MsgBlock {
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x52, 0x00},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(50, []byte{0x51}))
+
+	spendTx := wire.NewMsgTx(1)
+	for _, idx := range spend {
+		txHash := chainhash.Hash(leafDatas[idx].TxHash)
+		spendTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&txHash,
+			leafDatas[idx].Index), nil, nil))
+	}
+	spendTx.AddTxOut(wire.NewTxOut(10, []byte{0x51}))
+
+	return wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, spendTx},
+	}
+}
+
+// TestUtreexoViewpointModifyMalformedProofs ensures ublocks with malformed
+// utreexo data are rejected with the rule error for the class of the failure
+// and without modifying the accumulator.
+func TestUtreexoViewpointModifyMalformedProofs(t *testing.T) {
+	t.Parallel()
+
+	leafDatas := utreexoTestLeafDatas([]int64{100, 200, 300, 400})
+	spend := []int{0, 2}
+
+	// The proof of a forest whose first leaf pays a different amount is
+	// internally consistent but doesn't match the roots of the viewpoint.
+	otherLeafDatas := utreexoTestLeafDatas([]int64{101, 200, 300, 400})
+
+	tests := []struct {
+		name    string
+		modify  func(ud *btcacc.UData)
+		errCode ErrorCode
+		wantErr bool
+	}{{
+		name:   "valid proof",
+		modify: func(ud *btcacc.UData) {},
+	}, {
+		name: "missing leaf data",
+		modify: func(ud *btcacc.UData) {
+			ud.Stxos = ud.Stxos[:1]
+		},
+		errCode: ErrUtreexoLeafDataMismatch,
+		wantErr: true,
+	}, {
+		name: "leaf data for another outpoint",
+		modify: func(ud *btcacc.UData) {
+			ud.Stxos[0], ud.Stxos[1] = ud.Stxos[1], ud.Stxos[0]
+		},
+		errCode: ErrUtreexoLeafDataMismatch,
+		wantErr: true,
+	}, {
+		name: "leaf data not hashing to the leaf",
+		modify: func(ud *btcacc.UData) {
+			ud.Stxos[1].Amt++
+		},
+		errCode: ErrUtreexoLeafDataMismatch,
+		wantErr: true,
+	}, {
+		name: "missing target",
+		modify: func(ud *btcacc.UData) {
+			ud.AccProof.Targets = ud.AccProof.Targets[:1]
+		},
+		errCode: ErrUtreexoProofTargets,
+		wantErr: true,
+	}, {
+		name: "extra target",
+		modify: func(ud *btcacc.UData) {
+			ud.AccProof.Targets = append(ud.AccProof.Targets, 1)
+		},
+		errCode: ErrUtreexoProofTargets,
+		wantErr: true,
+	}, {
+		name: "duplicate target",
+		modify: func(ud *btcacc.UData) {
+			ud.AccProof.Targets[1] = ud.AccProof.Targets[0]
+		},
+		errCode: ErrUtreexoProofTargets,
+		wantErr: true,
+	}, {
+		name: "target outside of the accumulator",
+		modify: func(ud *btcacc.UData) {
+			ud.AccProof.Targets[1] = 1000
+		},
+		errCode: ErrUtreexoProofTargets,
+		wantErr: true,
+	}, {
+		name: "target of another leaf",
+		modify: func(ud *btcacc.UData) {
+			ud.AccProof.Targets[1] = 3
+		},
+		errCode: ErrUtreexoLeafDataMismatch,
+		wantErr: true,
+	}, {
+		name: "truncated proof",
+		modify: func(ud *btcacc.UData) {
+			ud.AccProof.Proof = ud.AccProof.Proof[1:]
+		},
+		errCode: ErrUtreexoProofTargets,
+		wantErr: true,
+	}, {
+		name: "proof against other roots",
+		modify: func(ud *btcacc.UData) {
+			*ud = utreexoTestProof(t, otherLeafDatas, spend)
+		},
+		errCode: ErrUtreexoRootsMismatch,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		uview := NewUtreexoViewpoint()
+		err := uview.accumulator.Modify(utreexoTestLeaves(leafDatas), nil)
+		if err != nil {
+			t.Fatalf("%s: unable to add leaves: %v", test.name, err)
+		}
+		roots := uview.accumulator.GetRoots()
+
+		ud := utreexoTestProof(t, leafDatas, spend)
+		test.modify(&ud)
+		ublock := btcutil.NewUBlock(&wire.MsgUBlock{
+			MsgBlock:    utreexoTestBlock(leafDatas, spend),
+			UtreexoData: ud,
+		})
+
+		err = uview.Modify(ublock)
+		if !test.wantErr {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(RuleError)
+		if !ok {
+			t.Errorf("%s: got error %v (%T), want RuleError",
+				test.name, err, err)
+			continue
+		}
+		if rerr.ErrorCode != test.errCode {
+			t.Errorf("%s: got error code %v, want %v", test.name,
+				rerr.ErrorCode, test.errCode)
+			continue
+		}
+		if !uview.compareRoots(roots) {
+			t.Errorf("%s: accumulator modified by rejected ublock",
+				test.name)
+		}
+	}
+}
+
+// TestUtreexoViewpointModifyRootLeaf ensures the leaf data for a leaf which is
+// a root of the accumulator itself is checked against that root.
+func TestUtreexoViewpointModifyRootLeaf(t *testing.T) {
+	t.Parallel()
+
+	// The last of an odd number of leaves is a root.
+	leafDatas := utreexoTestLeafDatas([]int64{100, 200, 300})
+	spend := []int{2}
+
+	tests := []struct {
+		name    string
+		forge   bool
+		wantErr bool
+	}{
+		{name: "valid leaf data"},
+		{name: "forged leaf data", forge: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		uview := NewUtreexoViewpoint()
+		err := uview.accumulator.Modify(utreexoTestLeaves(leafDatas), nil)
+		if err != nil {
+			t.Fatalf("%s: unable to add leaves: %v", test.name, err)
+		}
+
+		ud := utreexoTestProof(t, leafDatas, spend)
+		if test.forge {
+			// Leaf data which matches the spent outpoint and the
+			// hash in the proof, but not the root.
+			ud.Stxos[0].Amt++
+			ud.AccProof.Proof = []accumulator.Hash{ud.Stxos[0].LeafHash()}
+		}
+		ublock := btcutil.NewUBlock(&wire.MsgUBlock{
+			MsgBlock:    utreexoTestBlock(leafDatas, spend),
+			UtreexoData: ud,
+		})
+
+		err = uview.Modify(ublock)
+		if !test.wantErr {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(RuleError)
+		if !ok || rerr.ErrorCode != ErrUtreexoRootsMismatch {
+			t.Errorf("%s: got error %v, want %v", test.name, err,
+				ErrUtreexoRootsMismatch)
+		}
+	}
+}
+
+// TestVerifyUData ensures utreexo data for loose outputs is checked against
+// the accumulator without modifying it.
+func TestVerifyUData(t *testing.T) {
+	t.Parallel()
+
+	leafDatas := utreexoTestLeafDatas([]int64{100, 200, 300, 400, 500})
+	otherLeafDatas := utreexoTestLeafDatas([]int64{101, 200, 300, 400, 500})
+
+	tests := []struct {
+		name    string
+		ud      func() btcacc.UData
+		errCode ErrorCode
+		wantErr bool
+	}{{
+		name: "valid proof",
+		ud: func() btcacc.UData {
+			return utreexoTestProof(t, leafDatas, []int{0, 3})
+		},
+	}, {
+		name: "valid root leaf",
+		ud: func() btcacc.UData {
+			return utreexoTestProof(t, leafDatas, []int{4})
+		},
+	}, {
+		name: "duplicate target",
+		ud: func() btcacc.UData {
+			ud := utreexoTestProof(t, leafDatas, []int{0, 3})
+			ud.AccProof.Targets[1] = ud.AccProof.Targets[0]
+			return ud
+		},
+		errCode: ErrUtreexoProofTargets,
+		wantErr: true,
+	}, {
+		name: "leaf data doesn't hash to leaf",
+		ud: func() btcacc.UData {
+			ud := utreexoTestProof(t, leafDatas, []int{0, 3})
+			ud.Stxos[0].Amt++
+			return ud
+		},
+		errCode: ErrUtreexoLeafDataMismatch,
+		wantErr: true,
+	}, {
+		name: "forged root leaf",
+		ud: func() btcacc.UData {
+			ud := utreexoTestProof(t, leafDatas, []int{4})
+			ud.Stxos[0].Amt++
+			ud.AccProof.Proof = []accumulator.Hash{
+				ud.Stxos[0].LeafHash(),
+			}
+			return ud
+		},
+		errCode: ErrUtreexoRootsMismatch,
+		wantErr: true,
+	}, {
+		name: "tampered proof hash",
+		ud: func() btcacc.UData {
+			ud := utreexoTestProof(t, leafDatas, []int{1})
+			leaf := accumulator.Hash(ud.Stxos[0].LeafHash())
+			for i := range ud.AccProof.Proof {
+				if ud.AccProof.Proof[i] != leaf {
+					ud.AccProof.Proof[i][0] ^= 0x01
+				}
+			}
+			return ud
+		},
+		errCode: ErrUtreexoRootsMismatch,
+		wantErr: true,
+	}, {
+		name: "proof for other roots",
+		ud: func() btcacc.UData {
+			return utreexoTestProof(t, otherLeafDatas, []int{0})
+		},
+		errCode: ErrUtreexoRootsMismatch,
+		wantErr: true,
+	}}
+
+	uview := NewUtreexoViewpoint()
+	err := uview.accumulator.Modify(utreexoTestLeaves(leafDatas), nil)
+	if err != nil {
+		t.Fatalf("unable to add leaves: %v", err)
+	}
+	chain := &BlockChain{utreexoViewpoint: uview}
+	roots := uview.GetRoots()
+
+	for _, test := range tests {
+		ud := test.ud()
+		err := chain.VerifyUData(&ud)
+		if !test.wantErr {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else {
+			rerr, ok := err.(RuleError)
+			if !ok || rerr.ErrorCode != test.errCode {
+				t.Errorf("%s: got error %v, want %v", test.name,
+					err, test.errCode)
+			}
+		}
+
+		if !uview.Equal(roots) {
+			t.Fatalf("%s: accumulator was modified", test.name)
+		}
+	}
+}
+
+// TestVerifyProofTree ensures proofs for every combination of targets of small
+// accumulators hash up to their roots and that a tampered leaf doesn't.
+func TestVerifyProofTree(t *testing.T) {
+	t.Parallel()
+
+	for numLeaves := 1; numLeaves <= 9; numLeaves++ {
+		amounts := make([]int64, numLeaves)
+		for i := range amounts {
+			amounts[i] = int64(i + 1)
+		}
+		leafDatas := utreexoTestLeafDatas(amounts)
+		uview := NewUtreexoViewpoint()
+		err := uview.accumulator.Modify(utreexoTestLeaves(leafDatas), nil)
+		if err != nil {
+			t.Fatalf("unable to add leaves: %v", err)
+		}
+		nl, h := uview.accumulator.ReconstructStats()
+		roots := uview.accumulator.GetRoots()
+
+		for mask := 1; mask < 1<<uint(numLeaves); mask++ {
+			var spend []int
+			for i := 0; i < numLeaves; i++ {
+				if mask&(1<<uint(i)) != 0 {
+					spend = append(spend, i)
+				}
+			}
+			ud := utreexoTestProof(t, leafDatas, spend)
+			if len(ud.AccProof.Targets) == 0 {
+				// The forest doesn't prove single leaf
+				// accumulators.
+				continue
+			}
+
+			proofTree, err := checkUDataProof(&ud, nl, h, roots, "test")
+			if err != nil {
+				t.Fatalf("%d leaves, spend %v: unexpected error: %v",
+					numLeaves, spend, err)
+			}
+			err = verifyProofTree(proofTree, ud.AccProof.Targets, nl,
+				h, roots, "test")
+			if err != nil {
+				t.Fatalf("%d leaves, spend %v: unexpected error: %v",
+					numLeaves, spend, err)
+			}
+
+			proofTree[ud.AccProof.Targets[0]] = accumulator.Hash{0x01}
+			err = verifyProofTree(proofTree, ud.AccProof.Targets, nl,
+				h, roots, "test")
+			rerr, ok := err.(RuleError)
+			if !ok || rerr.ErrorCode != ErrUtreexoRootsMismatch {
+				t.Fatalf("%d leaves, spend %v: got error %v, want %v",
+					numLeaves, spend, err, ErrUtreexoRootsMismatch)
+			}
+		}
+	}
+}
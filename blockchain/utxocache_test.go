@@ -134,6 +134,24 @@ func makeSpendableOutForTx(tx *wire.MsgTx, txOutIndex uint32) spendableOut {
 //
 // Panics on errors.
 func addBlock(chain *BlockChain, prev *btcutil.Block, spends []*spendableOut) (*btcutil.Block, []*spendableOut) {
+	block, outs := newTestBlock(chain, prev, spends)
+	_, _, err := chain.ProcessBlock(block, BFNone)
+	if err != nil {
+		panic(err)
+	}
+
+	return block, outs
+}
+
+// newTestBlock creates a block that succeeds prev without processing it.  The
+// block spends all the provided spendable outputs, and the passed mungers are
+// applied to it before it is solved.  The new block is returned, together with
+// the new spendable outputs created in the block.
+//
+// Panics on errors.
+func newTestBlock(chain *BlockChain, prev *btcutil.Block, spends []*spendableOut,
+	mungers ...func(*wire.MsgBlock)) (*btcutil.Block, []*spendableOut) {
+
 	blockHeight := prev.Height() + 1
 	txns := make([]*wire.MsgTx, 0, 1+len(spends))
 
@@ -193,6 +211,11 @@ func addBlock(chain *BlockChain, prev *btcutil.Block, spends []*spendableOut) (*
 	}
 
 	// Calculate merkle root.
+	msgBlock := &wire.MsgBlock{Transactions: txns}
+	for _, munge := range mungers {
+		munge(msgBlock)
+	}
+	txns = msgBlock.Transactions
 	utilTxns := make([]*btcutil.Tx, 0, len(txns))
 	for _, tx := range txns {
 		utilTxns = append(utilTxns, btcutil.NewTx(tx))
@@ -217,11 +240,6 @@ func addBlock(chain *BlockChain, prev *btcutil.Block, spends []*spendableOut) (*
 		panic(fmt.Sprintf("Unable to solve block at height %d", blockHeight))
 	}
 
-	_, _, err = chain.ProcessBlock(block, BFNone)
-	if err != nil {
-		panic(err)
-	}
-
 	return block, outs
 }
 
@@ -0,0 +1,402 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/accumulator"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+// utxoScanInterruptInterval is the number of outputs scanned between checks of
+// the interrupt channel and progress reports.
+const utxoScanInterruptInterval = 10000
+
+// UtxoScanEntry is an unspent transaction output found by a scan of the
+// utxo set.
+type UtxoScanEntry struct {
+	OutPoint wire.OutPoint
+	Entry    *UtxoEntry
+
+	// Proof is the proof of the output against the utreexo accumulator at
+	// the height of the scan.  It is only set by ScanUtreexoLeaves.
+	Proof *accumulator.BatchProof
+}
+
+// UtxoScanResult houses the outcome of a scan of the utxo set.
+type UtxoScanResult struct {
+	// Entries are the unspent outputs whose scripts matched.
+	Entries []UtxoScanEntry
+
+	// Scanned is the number of unspent outputs that were looked at.
+	Scanned int64
+
+	// Height and Hash identify the block the scan reflects the state of.
+	Height int32
+	Hash   chainhash.Hash
+
+	// Roots are the utreexo accumulator roots at the scanned block the
+	// proofs of the entries commit to.  It is only set by
+	// ScanUtreexoLeaves.
+	Roots []*chainhash.Hash
+}
+
+// ScanUtxoSet walks the whole unspent transaction output set, both the entries
+// held by the utxo cache and those stored in the database, and returns the
+// outputs whose public key scripts are accepted by match.
+//
+// The scan can be aborted by closing the interrupt channel, in which case an
+// error is returned.  The progress function, when not nil, is periodically
+// called with the fraction of the set that was scanned so far.
+//
+// This is not available to utreexo compact state nodes, which don't keep a
+// utxo set.  See ScanUtreexoLeaves.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ScanUtxoSet(match func(pkScript []byte) bool,
+	interrupt <-chan struct{}, progress func(float64)) (*UtxoScanResult, error) {
+
+	if b.utreexoCSN {
+		return nil, fmt.Errorf("the utxo set is not available to " +
+			"utreexo compact state nodes")
+	}
+
+	// The chain lock is only held until the database snapshot the scan
+	// iterates is taken, at which point the entries of the cache that
+	// are not flushed to it yet were copied.
+	b.chainLock.RLock()
+	locked := true
+	defer func() {
+		if locked {
+			b.chainLock.RUnlock()
+		}
+	}()
+
+	best := b.BestSnapshot()
+	result := &UtxoScanResult{
+		Height: best.Height,
+		Hash:   best.Hash,
+	}
+	err := b.db.View(func(dbTx database.Tx) error {
+		// Cached entries take precedence over the database, including
+		// the nil and spent entries which mark outputs that are still
+		// stored in it as spent.
+		cache := b.utxoCache
+		cache.mtx.Lock()
+		cached := make(map[wire.OutPoint]struct{}, len(cache.cachedEntries))
+		for outpoint, entry := range cache.cachedEntries {
+			cached[outpoint] = struct{}{}
+			if entry == nil || entry.IsSpent() {
+				continue
+			}
+
+			result.Scanned++
+			if match(entry.PkScript()) {
+				result.Entries = append(result.Entries, UtxoScanEntry{
+					OutPoint: outpoint,
+					Entry:    entry.Clone(),
+				})
+			}
+		}
+		cache.mtx.Unlock()
+
+		b.chainLock.RUnlock()
+		locked = false
+
+		var scanned int
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			key := cursor.Key()
+			if len(key) <= chainhash.HashSize {
+				continue
+			}
+
+			scanned++
+			if scanned%utxoScanInterruptInterval == 0 {
+				if interruptRequested(interrupt) {
+					return errInterruptRequested
+				}
+
+				// The keys are ordered by the transaction hash,
+				// so its first two bytes tell how far along the
+				// scan is.
+				if progress != nil {
+					progress(float64(uint16(key[0])<<8|
+						uint16(key[1])) / 65536)
+				}
+			}
+
+			var outpoint wire.OutPoint
+			copy(outpoint.Hash[:], key[:chainhash.HashSize])
+			index, _ := deserializeVLQ(key[chainhash.HashSize:])
+			outpoint.Index = uint32(index)
+			if _, ok := cached[outpoint]; ok {
+				continue
+			}
+
+			entry, err := deserializeUtxoEntry(cursor.Value())
+			if err != nil {
+				return err
+			}
+
+			result.Scanned++
+			if match(entry.PkScript()) {
+				result.Entries = append(result.Entries, UtxoScanEntry{
+					OutPoint: outpoint,
+					Entry:    entry,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if progress != nil {
+		progress(1)
+	}
+	return result, nil
+}
+
+// utreexoScanLeaf is an output matched by ScanUtreexoLeaves along with the
+// hash it is committed to the accumulator with.
+type utreexoScanLeaf struct {
+	leafData btcacc.LeafData
+	hash     accumulator.Hash
+}
+
+// errIncompleteUtreexoProof indicates that an accumulator rebuilt from a
+// utreexo root hint lacks some of the nodes needed to prove a matched output.
+var errIncompleteUtreexoProof = errors.New("incomplete utreexo proof")
+
+// ScanUtreexoLeaves finds the unspent transaction outputs created from the
+// passed height on whose public key scripts are accepted by match, along with
+// their proofs against the current utreexo accumulator.
+//
+// Compact state nodes keep neither blocks nor the positions of leaves, so the
+// accumulator is rebuilt from the utreexo root hint preceding the start height,
+// or from scratch if there is none, with the ublocks of the main chain up to
+// the current tip, which are retrieved through the passed fetch function.  The
+// rebuilt accumulator must end up with the roots of the chain.  Since an
+// accumulator rebuilt from a root hint only knows the nodes the later ublocks
+// revealed, it is rebuilt again from scratch when it can't prove all of the
+// matched outputs.
+//
+// The scan can be aborted by closing the interrupt channel, in which case an
+// error is returned.  The progress function, when not nil, is periodically
+// called with the fraction of the blocks that were scanned so far.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ScanUtreexoLeaves(startHeight int32,
+	fetch func(*chainhash.Hash) (*btcutil.UBlock, error),
+	match func(pkScript []byte) bool, interrupt <-chan struct{},
+	progress func(float64)) (*UtxoScanResult, error) {
+
+	if !b.utreexoCSN {
+		return nil, fmt.Errorf("utreexo leaves can only be scanned by " +
+			"utreexo compact state nodes")
+	}
+
+	b.chainLock.RLock()
+	tip := b.bestChain.Tip()
+	roots := b.utreexoViewpoint.GetRoots()
+	b.chainLock.RUnlock()
+
+	if startHeight < 0 || startHeight > tip.height {
+		return nil, fmt.Errorf("start height %d is out of range [0, %d]",
+			startHeight, tip.height)
+	}
+
+	hint := b.FindPreviousUtreexoRootHint(startHeight)
+	result, err := scanUtreexoLeaves(tip, roots, hint, startHeight, fetch,
+		match, interrupt, progress)
+	if err == errIncompleteUtreexoProof && hint != nil {
+		log.Debugf("The accumulator rebuilt from the utreexo root hint "+
+			"at height %d can't prove all of the matched outputs, "+
+			"rebuilding it from scratch", hint.Height)
+		result, err = scanUtreexoLeaves(tip, roots, nil, startHeight,
+			fetch, match, interrupt, progress)
+	}
+	return result, err
+}
+
+// scanUtreexoLeaves rebuilds the accumulator from the passed root hint, or from
+// scratch when it is nil, up to the passed tip and returns the matched outputs
+// created from the start height on along with their proofs.  See
+// ScanUtreexoLeaves for details.  errIncompleteUtreexoProof is returned when
+// one of the matched outputs can't be proven.
+func scanUtreexoLeaves(tip *blockNode, roots []*chainhash.Hash,
+	hint *chaincfg.UtreexoRootHint, startHeight int32,
+	fetch func(*chainhash.Hash) (*btcutil.UBlock, error),
+	match func(pkScript []byte) bool, interrupt <-chan struct{},
+	progress func(float64)) (*UtxoScanResult, error) {
+
+	// Only the leaves added after the root hint the accumulator starts
+	// from are known, which is enough to prove those which match.
+	uView := &UtreexoViewpoint{accumulator: accumulator.NewFullPollard()}
+	firstHeight := int32(1)
+	if hint != nil {
+		rootBytes, err := chaincfg.UtreexoRootHintToBytes(*hint)
+		if err != nil {
+			return nil, err
+		}
+		err = deserializeUtreexoView(uView, rootBytes)
+		if err != nil {
+			return nil, err
+		}
+		firstHeight = hint.Height + 1
+	}
+
+	result := &UtxoScanResult{
+		Height: tip.height,
+		Hash:   tip.hash,
+		Roots:  roots,
+	}
+	found := make(map[wire.OutPoint]utreexoScanLeaf)
+	for height := firstHeight; height <= tip.height; height++ {
+		if interruptRequested(interrupt) {
+			return nil, errInterruptRequested
+		}
+
+		node := tip.Ancestor(height)
+		ub, err := fetch(&node.hash)
+		if err != nil {
+			return nil, err
+		}
+		if !ub.Hash().IsEqual(&node.hash) {
+			return nil, fmt.Errorf("fetched ublock %v instead of %v",
+				ub.Hash(), node.hash)
+		}
+
+		// The outputs that are created and spent in the same block
+		// are never added to the accumulator.
+		_, outskip := ub.Block().DedupeBlock()
+		err = uView.Modify(ub)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stxo := range ub.UData().Stxos {
+			delete(found, wire.OutPoint{
+				Hash:  chainhash.Hash(stxo.TxHash),
+				Index: stxo.Index,
+			})
+		}
+		if height >= startHeight {
+			for _, leafData := range blockLeafDatas(ub.Block(),
+				outskip, height) {
+
+				result.Scanned++
+				if !match(leafData.PkScript) {
+					continue
+				}
+				found[wire.OutPoint{
+					Hash:  chainhash.Hash(leafData.TxHash),
+					Index: leafData.Index,
+				}] = utreexoScanLeaf{
+					leafData: leafData,
+					hash:     leafData.LeafHash(),
+				}
+			}
+		}
+
+		if progress != nil {
+			progress(float64(height-firstHeight+1) /
+				float64(tip.height-firstHeight+1))
+		}
+	}
+
+	if !uView.Equal(roots) {
+		return nil, ruleError(ErrUtreexoRootsMismatch, fmt.Sprintf(
+			"the accumulator rebuilt up to block %v doesn't match "+
+				"the roots of the chain", tip.hash))
+	}
+
+	nl, h := uView.accumulator.ReconstructStats()
+	accRoots := uView.accumulator.GetRoots()
+	for outpoint, leaf := range found {
+		proof, err := uView.accumulator.ProveBatch(
+			[]accumulator.Hash{leaf.hash},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure the proof hashes up to the roots since the nodes
+		// of the accumulator which are neither the leaves added after
+		// the root hint nor revealed by the proofs of the ublocks are
+		// unknown.  Accumulators with a single leaf have empty proofs.
+		if nl > 1 {
+			proofTree, err := proof.Reconstruct(nl, h)
+			if err == nil && len(proof.Targets) == 1 &&
+				proofTree[proof.Targets[0]] == leaf.hash {
+
+				err = verifyProofTree(proofTree, proof.Targets,
+					nl, h, accRoots, "proof")
+			} else if err == nil {
+				err = fmt.Errorf("no proof for %v", outpoint)
+			}
+			if err != nil {
+				log.Debugf("Unable to prove output %v: %v",
+					outpoint, err)
+				return nil, errIncompleteUtreexoProof
+			}
+		}
+
+		txOut := wire.NewTxOut(leaf.leafData.Amt, leaf.leafData.PkScript)
+		result.Entries = append(result.Entries, UtxoScanEntry{
+			OutPoint: outpoint,
+			Entry: NewUtxoEntry(txOut, leaf.leafData.Height,
+				leaf.leafData.Coinbase),
+			Proof: &proof,
+		})
+	}
+
+	if progress != nil {
+		progress(1)
+	}
+	return result, nil
+}
+
+// blockLeafDatas returns the leaf datas of the outputs the passed block adds to
+// the utreexo accumulator, in the same order as BlockToAddLeaves.
+func blockLeafDatas(blk *btcutil.Block, skiplist []uint32,
+	height int32) []btcacc.LeafData {
+
+	var leafDatas []btcacc.LeafData
+	var txonum uint32
+	for txIdx, tx := range blk.Transactions() {
+		for i, out := range tx.MsgTx().TxOut {
+			if isUnspendable(out) {
+				txonum++
+				continue
+			}
+			if len(skiplist) > 0 && skiplist[0] == txonum {
+				skiplist = skiplist[1:]
+				txonum++
+				continue
+			}
+
+			leafDatas = append(leafDatas, btcacc.LeafData{
+				TxHash:   btcacc.Hash(*tx.Hash()),
+				Index:    uint32(i),
+				Height:   height,
+				Coinbase: txIdx == 0,
+				Amt:      out.Value,
+				PkScript: out.PkScript,
+			})
+			txonum++
+		}
+	}
+	return leafDatas
+}
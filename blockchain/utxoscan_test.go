@@ -0,0 +1,383 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/accumulator"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+// TestScanUtxoSet ensures scanning the utxo set finds the unspent outputs both
+// in the cache and on disk, and skips the outputs on disk the cache marks as
+// spent.
+func TestScanUtxoSet(t *testing.T) {
+	t.Parallel()
+
+	chain, params, tearDown := utxoCacheTestChain("TestScanUtxoSet")
+	defer tearDown()
+	tip := btcutil.NewBlock(params.GenesisBlock)
+
+	// Add five blocks and flush their coinbase outputs to disk.
+	var spendableOuts []*spendableOut
+	for i := 0; i < 5; i++ {
+		var outs []*spendableOut
+		tip, outs = addBlock(chain, tip, nil)
+		spendableOuts = append(spendableOuts, outs[0])
+	}
+	if err := chain.FlushCachedState(FlushRequired); err != nil {
+		t.Fatalf("unexpected error while flushing cache: %v", err)
+	}
+
+	// Spend the first two coinbase outputs without flushing.
+	tip, outs := addBlock(chain, tip, spendableOuts[:2])
+
+	want := make(map[wire.OutPoint]struct{})
+	for _, out := range append(spendableOuts[2:], outs...) {
+		want[out.prevOut] = struct{}{}
+	}
+
+	isOpTrue := func(pkScript []byte) bool {
+		return bytes.Equal(pkScript, opTrueScript)
+	}
+	var lastProgress float64
+	result, err := chain.ScanUtxoSet(isOpTrue, nil, func(p float64) {
+		lastProgress = p
+	})
+	if err != nil {
+		t.Fatalf("ScanUtxoSet: unexpected error: %v", err)
+	}
+	if result.Height != tip.Height() || result.Hash != *tip.Hash() {
+		t.Fatalf("ScanUtxoSet: scanned block %d (%v), want %d (%v)",
+			result.Height, result.Hash, tip.Height(), tip.Hash())
+	}
+	if result.Scanned != int64(len(want)) {
+		t.Fatalf("ScanUtxoSet: scanned %d outputs, want %d",
+			result.Scanned, len(want))
+	}
+	if len(result.Entries) != len(want) {
+		t.Fatalf("ScanUtxoSet: found %d outputs, want %d",
+			len(result.Entries), len(want))
+	}
+	for _, entry := range result.Entries {
+		if _, ok := want[entry.OutPoint]; !ok {
+			t.Fatalf("ScanUtxoSet: found unexpected output %v",
+				entry.OutPoint)
+		}
+		if entry.Proof != nil {
+			t.Fatalf("ScanUtxoSet: unexpected proof for %v",
+				entry.OutPoint)
+		}
+	}
+	if lastProgress != 1 {
+		t.Fatalf("ScanUtxoSet: last progress %v, want 1", lastProgress)
+	}
+
+	// Nothing is found when nothing matches.
+	result, err = chain.ScanUtxoSet(func([]byte) bool { return false },
+		nil, nil)
+	if err != nil {
+		t.Fatalf("ScanUtxoSet: unexpected error: %v", err)
+	}
+	if len(result.Entries) != 0 {
+		t.Fatalf("ScanUtxoSet: found %d outputs, want none",
+			len(result.Entries))
+	}
+
+	// Leaves can only be scanned by compact state nodes.
+	_, err = chain.ScanUtreexoLeaves(0, nil, isOpTrue, nil, nil)
+	if err == nil {
+		t.Fatal("ScanUtreexoLeaves: expected error on a full node")
+	}
+}
+
+// TestScanUtreexoLeaves ensures compact state nodes find the unspent outputs
+// created from the start height on along with their current proofs by
+// rebuilding the accumulator from fetched ublocks.
+func TestScanUtreexoLeaves(t *testing.T) {
+	t.Parallel()
+
+	params := chaincfg.RegressionNetParams
+	params.UtreexoRootHints = nil
+	chain := newFakeChain(&params)
+	chain.utreexoCSN = true
+	chain.utreexoViewpoint = NewUtreexoViewpoint()
+
+	// The forest stands in for a bridge node proving the spent outputs.
+	forest := accumulator.NewForest(nil, false, "", 0)
+	wantScript, otherScript := []byte{0x51}, []byte{0x52}
+
+	// Each block pays its coinbase alternately to the scanned script and
+	// another one, and spends the coinbase of the block two blocks back
+	// to the scanned script.
+	const numBlocks = 8
+	const hintHeight = 4
+	var hint chaincfg.UtreexoRootHint
+	ublocks := make(map[chainhash.Hash]*btcutil.UBlock)
+	leafDatas := make(map[wire.OutPoint]btcacc.LeafData)
+	coinbases := make([]*wire.MsgTx, numBlocks+1)
+	tip := chain.bestChain.Tip()
+	for height := int32(1); height <= numBlocks; height++ {
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+				wire.MaxPrevOutIndex),
+			SignatureScript: []byte{0x01, byte(height), 0x00},
+		})
+		pkScript := otherScript
+		if height%2 == 0 {
+			pkScript = wantScript
+		}
+		coinbase.AddTxOut(wire.NewTxOut(50, pkScript))
+		coinbases[height] = coinbase
+
+		msgBlock := wire.MsgBlock{
+			Header: wire.BlockHeader{
+				PrevBlock: tip.hash,
+				Nonce:     uint32(height),
+			},
+			Transactions: []*wire.MsgTx{coinbase},
+		}
+		ud := btcacc.UData{Height: height}
+		if height > 2 {
+			spent := wire.OutPoint{
+				Hash: coinbases[height-2].TxHash(),
+			}
+			spendTx := wire.NewMsgTx(1)
+			spendTx.AddTxIn(wire.NewTxIn(&spent, nil, nil))
+			spendTx.AddTxOut(wire.NewTxOut(int64(10+height),
+				wantScript))
+			msgBlock.Transactions = append(msgBlock.Transactions,
+				spendTx)
+
+			leafData := leafDatas[spent]
+			proof, err := forest.ProveBatch(
+				[]accumulator.Hash{leafData.LeafHash()},
+			)
+			if err != nil {
+				t.Fatalf("unable to prove %v: %v", spent, err)
+			}
+			ud.Stxos = []btcacc.LeafData{leafData}
+			ud.AccProof = proof
+		}
+
+		ublock := btcutil.NewUBlock(&wire.MsgUBlock{
+			MsgBlock:    msgBlock,
+			UtreexoData: ud,
+		})
+		if err := chain.utreexoViewpoint.Modify(ublock); err != nil {
+			t.Fatalf("unable to connect ublock %d: %v", height, err)
+		}
+
+		added := blockLeafDatas(ublock.Block(), nil, height)
+		leaves := make([]accumulator.Leaf, 0, len(added))
+		for _, leafData := range added {
+			leafDatas[wire.OutPoint{
+				Hash:  chainhash.Hash(leafData.TxHash),
+				Index: leafData.Index,
+			}] = leafData
+			leaves = append(leaves, accumulator.Leaf{
+				Hash: leafData.LeafHash(),
+			})
+		}
+		if _, err := forest.Modify(leaves, ud.AccProof.Targets); err != nil {
+			t.Fatalf("unable to modify forest: %v", err)
+		}
+
+		ublocks[*ublock.Hash()] = ublock
+		tip = newBlockNode(&msgBlock.Header, tip)
+		tip.BuildAncestor()
+		chain.index.AddNode(tip)
+
+		if height == hintHeight {
+			numLeaves, _ := chain.utreexoViewpoint.accumulator.
+				ReconstructStats()
+			hint = chaincfg.UtreexoRootHint{
+				Height:    height,
+				Hash:      &tip.hash,
+				NumLeaves: numLeaves,
+				Roots:     chain.utreexoViewpoint.GetRoots(),
+			}
+		}
+	}
+	chain.bestChain.SetTip(tip)
+
+	// The fetched heights are recorded to ensure the blocks before a root
+	// hint are skipped.
+	var fetched []int32
+	fetch := func(hash *chainhash.Hash) (*btcutil.UBlock, error) {
+		ublock := ublocks[*hash]
+		if ublock != nil {
+			fetched = append(fetched, ublock.UData().Height)
+		}
+		return ublock, nil
+	}
+	isWanted := func(pkScript []byte) bool {
+		return bytes.Equal(pkScript, wantScript)
+	}
+
+	heights := func(from, to int32) []int32 {
+		var heights []int32
+		for height := from; height <= to; height++ {
+			heights = append(heights, height)
+		}
+		return heights
+	}
+	tests := []struct {
+		startHeight int32
+		useHint     bool
+		numFound    int
+		fetched     []int32
+	}{
+		// The coinbase of block 8 and the six spending outputs.
+		{startHeight: 0, numFound: 7, fetched: heights(1, numBlocks)},
+
+		// The coinbase of block 8 and the outputs of blocks 5 to 8.
+		{startHeight: 5, numFound: 5, fetched: heights(1, numBlocks)},
+
+		// The accumulator starts from the root hint of block 4, so the
+		// blocks before it are never fetched.  The proofs of blocks 5
+		// and 6 for the coinbases of blocks 3 and 4 reveal enough of
+		// it to prove the outputs of blocks 6 to 8.
+		{startHeight: 6, useHint: true, numFound: 4,
+			fetched: heights(hintHeight+1, numBlocks)},
+
+		// The output of block 5 ends up among nodes the root hint
+		// doesn't reveal, so the accumulator is rebuilt from scratch.
+		{startHeight: 5, useHint: true, numFound: 5,
+			fetched: append(heights(hintHeight+1, numBlocks),
+				heights(1, numBlocks)...)},
+
+		// Starting at or before the root hint doesn't use it.
+		{startHeight: hintHeight, useHint: true, numFound: 6,
+			fetched: heights(1, numBlocks)},
+	}
+	for _, test := range tests {
+		chain.utreexoRootHints = nil
+		if test.useHint {
+			chain.utreexoRootHints = []chaincfg.UtreexoRootHint{hint}
+		}
+		fetched = fetched[:0]
+		result, err := chain.ScanUtreexoLeaves(test.startHeight, fetch,
+			isWanted, nil, nil)
+		if err != nil {
+			t.Fatalf("start height %d: unexpected error: %v",
+				test.startHeight, err)
+		}
+		if !reflect.DeepEqual(fetched, test.fetched) {
+			t.Fatalf("start height %d: fetched blocks %v, want %v",
+				test.startHeight, fetched, test.fetched)
+		}
+		if result.Height != numBlocks || result.Hash != tip.hash {
+			t.Fatalf("start height %d: scanned block %d, want %d",
+				test.startHeight, result.Height, numBlocks)
+		}
+		if len(result.Entries) != test.numFound {
+			t.Fatalf("start height %d: found %d outputs, want %d",
+				test.startHeight, len(result.Entries),
+				test.numFound)
+		}
+
+		for _, entry := range result.Entries {
+			if entry.Entry.BlockHeight() < test.startHeight {
+				t.Fatalf("start height %d: found output %v "+
+					"of block %d", test.startHeight,
+					entry.OutPoint, entry.Entry.BlockHeight())
+			}
+			if _, ok := leafDatas[entry.OutPoint]; !ok {
+				t.Fatalf("start height %d: found unknown "+
+					"output %v", test.startHeight,
+					entry.OutPoint)
+			}
+
+			// The proof must be the one the forest has for the
+			// output.
+			leafData := leafDatas[entry.OutPoint]
+			want, err := forest.ProveBatch([]accumulator.Hash{
+				leafData.LeafHash(),
+			})
+			if err != nil {
+				t.Fatalf("unable to prove %v: %v",
+					entry.OutPoint, err)
+			}
+			if entry.Proof == nil ||
+				len(entry.Proof.Targets) != 1 ||
+				entry.Proof.Targets[0] != want.Targets[0] ||
+				len(entry.Proof.Proof) != len(want.Proof) {
+
+				t.Fatalf("start height %d: got proof %v for "+
+					"%v, want %v", test.startHeight,
+					entry.Proof, entry.OutPoint, want)
+			}
+			for i := range want.Proof {
+				if entry.Proof.Proof[i] != want.Proof[i] {
+					t.Fatalf("start height %d: got proof "+
+						"%v for %v, want %v",
+						test.startHeight, entry.Proof,
+						entry.OutPoint, want)
+				}
+			}
+		}
+	}
+
+	// The scan fails when the accumulator rebuilt from a root hint
+	// doesn't match the roots of the chain.
+	badHint := hint
+	badHint.Roots = append([]*chainhash.Hash{{0x01}}, hint.Roots[1:]...)
+	chain.utreexoRootHints = []chaincfg.UtreexoRootHint{badHint}
+	_, err := chain.ScanUtreexoLeaves(numBlocks, fetch, isWanted, nil, nil)
+	if err == nil {
+		t.Fatal("expected error for a bad root hint")
+	}
+	chain.utreexoRootHints = nil
+
+	// A closed interrupt channel aborts the scan.
+	interrupt := make(chan struct{})
+	close(interrupt)
+	_, err = chain.ScanUtreexoLeaves(0, fetch, isWanted, interrupt, nil)
+	if err != errInterruptRequested {
+		t.Fatalf("got error %v, want %v", err, errInterruptRequested)
+	}
+
+	// The fetched ublocks must be the blocks of the chain.
+	wrongFetch := func(hash *chainhash.Hash) (*btcutil.UBlock, error) {
+		for _, ublock := range ublocks {
+			if !ublock.Hash().IsEqual(hash) {
+				return ublock, nil
+			}
+		}
+		return nil, nil
+	}
+	_, err = chain.ScanUtreexoLeaves(0, wrongFetch, isWanted, nil, nil)
+	if err == nil {
+		t.Fatal("expected error for ublocks of other blocks")
+	}
+}
+
+// TestBlockLeafDatas ensures the leaf datas of the outputs a block adds to the
+// utreexo accumulator are the ones BlockToAddLeaves hashes.
+func TestBlockLeafDatas(t *testing.T) {
+	block := btcutil.NewBlock(&Block100000)
+	_, outskip := block.DedupeBlock()
+	leaves := BlockToAddLeaves(block, nil, outskip, 100000)
+	leafDatas := blockLeafDatas(block, outskip, 100000)
+	if len(leafDatas) != len(leaves) {
+		t.Fatalf("got %d leaf datas, want %d", len(leafDatas),
+			len(leaves))
+	}
+	for i := range leaves {
+		if leafDatas[i].LeafHash() != leaves[i].Hash {
+			t.Fatalf("leaf data %d hashes to %x, want %x", i,
+				leafDatas[i].LeafHash(), leaves[i].Hash)
+		}
+	}
+}
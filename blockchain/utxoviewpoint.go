@@ -37,6 +37,20 @@ func (view *UtxoViewpoint) LookupEntry(outpoint wire.OutPoint) *UtxoEntry {
 	return view.entries[outpoint]
 }
 
+// FetchPrevOutput returns the output referenced by the passed outpoint
+// according to the current state of the view.  It will return nil if the
+// output isn't available as described by LookupEntry.
+//
+// This is part of the txscript.PrevOutputFetcher interface.
+func (view *UtxoViewpoint) FetchPrevOutput(outpoint wire.OutPoint) *wire.TxOut {
+	entry := view.LookupEntry(outpoint)
+	if entry == nil {
+		return nil
+	}
+
+	return wire.NewTxOut(entry.Amount(), entry.PkScript())
+}
+
 //TODO(stevenroose) copy documentation.
 // This method is part of the utxoView interface.
 func (view *UtxoViewpoint) getEntry(outpoint wire.OutPoint) (*UtxoEntry, error) {
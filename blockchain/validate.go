@@ -343,6 +343,14 @@ func CheckProofOfWork(block *btcutil.Block, powLimit *big.Int) error {
 	return checkProofOfWork(&block.MsgBlock().Header, powLimit, BFNone)
 }
 
+// CheckHeaderProofOfWork ensures the header bits which indicate the target
+// difficulty is in min/max range and that the header hash is less than the
+// target difficulty as claimed.  It is the same as CheckProofOfWork except it
+// works with headers that don't have an associated block.
+func CheckHeaderProofOfWork(header *wire.BlockHeader, powLimit *big.Int) error {
+	return checkProofOfWork(header, powLimit, BFNone)
+}
+
 // CountSigOps returns the number of signature operations for all transaction
 // input and output scripts in the provided transaction.  This uses the
 // quicker, but imprecise, signature operation counting mechanism from
@@ -837,13 +845,19 @@ func (b *BlockChain) checkBIP0030(block *btcutil.Block, view *UtxoViewpoint) err
 		for txOutIdx := range tx.MsgTx().TxOut {
 			prevOut.Index = uint32(txOutIdx)
 
-			// First check if the view has the entry, otherwise fetch from state.
+			// First check if the view has the entry, then the views
+			// of the blocks pending script validation, otherwise
+			// fetch from state.
 			utxo := view.LookupEntry(prevOut)
 			if utxo == nil {
-				var err error
-				utxo, err = b.utxoCache.FetchEntry(prevOut)
-				if err != nil {
-					return err
+				var found bool
+				utxo, found = b.lookupPendingEntry(prevOut)
+				if !found {
+					var err error
+					utxo, err = b.utxoCache.FetchEntry(prevOut)
+					if err != nil {
+						return err
+					}
 				}
 			}
 
@@ -985,6 +999,40 @@ func CheckTransactionInputs(tx *btcutil.Tx, txHeight int32, utxoView *UtxoViewpo
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, view *UtxoViewpoint, stxos *[]SpentTxOut) error {
+	scriptFlags, runScripts, err := b.checkConnectBlockDeferScripts(node,
+		block, view, stxos)
+	if err != nil {
+		return err
+	}
+
+	// Now that the inexpensive checks are done and have passed, verify the
+	// transactions are actually allowed to spend the coins by running the
+	// expensive ECDSA signature check scripts.  Doing this last helps
+	// prevent CPU exhaustion attacks.
+	if runScripts {
+		err := checkBlockScripts(block, view, scriptFlags, b.sigCache,
+			b.hashCache, b.scriptCache)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkConnectBlockDeferScripts performs all of the checks of checkConnectBlock
+// except for the transaction scripts, and returns the flags the scripts must be
+// validated with along with whether they need to be validated at all.
+//
+// The referenced outputs which are not in the passed view are looked up in the
+// views of the blocks pending in the script validation pipeline before the utxo
+// cache, so the block may extend the last of them.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkConnectBlockDeferScripts(node *blockNode,
+	block *btcutil.Block, view *UtxoViewpoint,
+	stxos *[]SpentTxOut) (txscript.ScriptFlags, bool, error) {
+
 	// If the side chain blocks end up in the database, a call to
 	// CheckBlockSanity should be done here in case a previous version
 	// allowed a block that is no longer valid.  However, since the
@@ -995,7 +1043,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	// an error now.
 	if node.hash.IsEqual(b.chainParams.GenesisHash) {
 		str := "the coinbase for the genesis block is not spendable"
-		return ruleError(ErrMissingTxOut, str)
+		return 0, false, ruleError(ErrMissingTxOut, str)
 	}
 
 	// BIP0030 added a rule to prevent blocks which contain duplicate
@@ -1017,7 +1065,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	if !isBIP0030Node(node) && (node.height < b.chainParams.BIP0034Height) {
 		err := b.checkBIP0030(block, view)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
 	}
 
@@ -1026,9 +1074,9 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	//
 	// These utxo entries are needed for verification of things such as
 	// transaction inputs, counting pay-to-script-hashes, and scripts.
-	err := view.addInputUtxos(b.utxoCache, block)
+	err := view.addInputUtxos(b.pendingUtxoSource(), block)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 
 	// BIP0016 describes a pay-to-script-hash type that is considered a
@@ -1042,7 +1090,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	// the new rules.
 	segwitState, err := b.deploymentState(node.parent, chaincfg.DeploymentSegwit)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 	enforceSegWit := segwitState == ThresholdActive
 
@@ -1064,7 +1112,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		sigOpCost, err := GetSigOpCost(tx, i == 0, view, enforceBIP0016,
 			enforceSegWit)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
 
 		// Check for overflow or going over the limits.  We have to do
@@ -1075,7 +1123,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 			str := fmt.Sprintf("block contains too many "+
 				"signature operations - got %v, max %v",
 				totalSigOpCost, MaxBlockSigOpsCost)
-			return ruleError(ErrTooManySigOps, str)
+			return 0, false, ruleError(ErrTooManySigOps, str)
 		}
 	}
 
@@ -1092,7 +1140,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		txFee, err := CheckTransactionInputs(tx, node.height, view,
 			b.chainParams)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
 
 		// Sum the total fees and ensure we don't overflow the
@@ -1100,8 +1148,8 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		lastTotalFees := totalFees
 		totalFees += txFee
 		if totalFees < lastTotalFees {
-			return ruleError(ErrBadFees, "total fees for block "+
-				"overflows accumulator")
+			return 0, false, ruleError(ErrBadFees, "total fees "+
+				"for block overflows accumulator")
 		}
 
 		// Add all of the outputs for this transaction which are not
@@ -1110,7 +1158,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		// spent txout in the order each transaction spends them.
 		err = connectTransaction(view, tx, node.height, inskip, stxos, false)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
 	}
 
@@ -1129,7 +1177,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		str := fmt.Sprintf("coinbase transaction for block pays %v "+
 			"which is more than expected value of %v",
 			totalSatoshiOut, expectedSatoshiOut)
-		return ruleError(ErrBadCoinbaseValue, str)
+		return 0, false, ruleError(ErrBadCoinbaseValue, str)
 	}
 
 	// Don't run scripts if this node is before the latest known good
@@ -1187,7 +1235,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	// the soft-fork deployment is fully active.
 	csvState, err := b.deploymentState(node.parent, chaincfg.DeploymentCSV)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 	if csvState == ThresholdActive {
 		// If the CSV soft-fork is now active, then modify the
@@ -1210,14 +1258,14 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 			sequenceLock, err := b.calcSequenceLock(node, tx, view,
 				false)
 			if err != nil {
-				return err
+				return 0, false, err
 			}
 			if !SequenceLockActive(sequenceLock, node.height,
 				medianTime) {
 				str := fmt.Sprintf("block contains " +
 					"transaction whose input sequence " +
 					"locks are not met")
-				return ruleError(ErrUnfinalizedTx, str)
+				return 0, false, ruleError(ErrUnfinalizedTx, str)
 			}
 		}
 	}
@@ -1229,19 +1277,18 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		scriptFlags |= txscript.ScriptStrictMultiSig
 	}
 
-	// Now that the inexpensive checks are done and have passed, verify the
-	// transactions are actually allowed to spend the coins by running the
-	// expensive ECDSA signature check scripts.  Doing this last helps
-	// prevent CPU exhaustion attacks.
-	if runScripts {
-		err := checkBlockScripts(block, view, scriptFlags, b.sigCache,
-			b.hashCache)
-		if err != nil {
-			return err
-		}
+	// Enforce the taproot soft-fork package once the soft-fork has shifted
+	// into the "active" version bits state.
+	taprootState, err := b.deploymentState(node.parent,
+		chaincfg.DeploymentTaproot)
+	if err != nil {
+		return 0, false, err
+	}
+	if taprootState == ThresholdActive {
+		scriptFlags |= txscript.ScriptVerifyTaproot
 	}
 
-	return nil
+	return scriptFlags, runScripts, nil
 }
 
 // checkConnectParallel performs several checks to confirm connecting the passed
@@ -1471,13 +1518,24 @@ func (b *BlockChain) checkConnectParallel(node *blockNode, ublock *btcutil.UBloc
 		scriptFlags |= txscript.ScriptStrictMultiSig
 	}
 
+	// Enforce the taproot soft-fork package once the soft-fork has shifted
+	// into the "active" version bits state.
+	taprootState, err := b.deploymentState(node.parent,
+		chaincfg.DeploymentTaproot)
+	if err != nil {
+		return err
+	}
+	if taprootState == ThresholdActive {
+		scriptFlags |= txscript.ScriptVerifyTaproot
+	}
+
 	// Now that the inexpensive checks are done and have passed, verify the
 	// transactions are actually allowed to spend the coins by running the
 	// expensive ECDSA signature check scripts.  Doing this last helps
 	// prevent CPU exhaustion attacks.
 	if runScripts {
 		err := checkBlockScripts(ublock.Block(), view, scriptFlags, b.sigCache,
-			b.hashCache)
+			b.hashCache, b.scriptCache)
 		if err != nil {
 			return err
 		}
@@ -1503,6 +1561,36 @@ func (b *BlockChain) checkConnectParallel(node *blockNode, ublock *btcutil.UBloc
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock, view *UtxoViewpoint) error {
+	scriptFlags, runScripts, err := b.checkConnectUBlockDeferScripts(node,
+		ublock, view)
+	if err != nil {
+		return err
+	}
+
+	// Now that the inexpensive checks are done and have passed, verify the
+	// transactions are actually allowed to spend the coins by running the
+	// expensive ECDSA signature check scripts.  Doing this last helps
+	// prevent CPU exhaustion attacks.
+	if runScripts {
+		err := checkBlockScripts(ublock.Block(), view, scriptFlags, b.sigCache,
+			b.hashCache, b.scriptCache)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkConnectUBlockDeferScripts performs all of the checks of
+// checkConnectUBlock except for the transaction scripts, and returns the flags
+// the scripts must be validated with along with whether they need to be
+// validated at all.  The utreexo accumulator is modified by the ublock.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkConnectUBlockDeferScripts(node *blockNode,
+	ublock *btcutil.UBlock, view *UtxoViewpoint) (txscript.ScriptFlags, bool, error) {
+
 	// If the side chain blocks end up in the database, a call to
 	// CheckBlockSanity should be done here in case a previous version
 	// allowed a block that is no longer valid.  However, since the
@@ -1513,13 +1601,13 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 	// an error now.
 	if node.hash.IsEqual(b.chainParams.GenesisHash) {
 		str := "the coinbase for the genesis block is not spendable"
-		return ruleError(ErrMissingTxOut, str)
+		return 0, false, ruleError(ErrMissingTxOut, str)
 	}
 
 	// Check that the ublock txOuts are valid
 	err := b.utreexoViewpoint.Modify(ublock)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 
 	// convert to utxoview for backwards compat
@@ -1537,7 +1625,7 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 	// the new rules.
 	segwitState, err := b.deploymentState(node.parent, chaincfg.DeploymentSegwit)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 	enforceSegWit := segwitState == ThresholdActive
 
@@ -1559,7 +1647,7 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 		sigOpCost, err := GetSigOpCost(tx, i == 0, view, enforceBIP0016,
 			enforceSegWit)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
 
 		// Check for overflow or going over the limits.  We have to do
@@ -1570,7 +1658,7 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 			str := fmt.Sprintf("block contains too many "+
 				"signature operations - got %v, max %v",
 				totalSigOpCost, MaxBlockSigOpsCost)
-			return ruleError(ErrTooManySigOps, str)
+			return 0, false, ruleError(ErrTooManySigOps, str)
 		}
 	}
 
@@ -1586,7 +1674,7 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 		txFee, err := CheckTransactionInputs(tx, node.height, view,
 			b.chainParams)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
 
 		// Sum the total fees and ensure we don't overflow the
@@ -1594,8 +1682,8 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 		lastTotalFees := totalFees
 		totalFees += txFee
 		if totalFees < lastTotalFees {
-			return ruleError(ErrBadFees, "total fees for block "+
-				"overflows accumulator")
+			return 0, false, ruleError(ErrBadFees, "total fees "+
+				"for block overflows accumulator")
 		}
 	}
 
@@ -1614,7 +1702,7 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 		str := fmt.Sprintf("coinbase transaction for block pays %v "+
 			"which is more than expected value of %v",
 			totalSatoshiOut, expectedSatoshiOut)
-		return ruleError(ErrBadCoinbaseValue, str)
+		return 0, false, ruleError(ErrBadCoinbaseValue, str)
 	}
 
 	// Don't run scripts if this node is before the latest known good
@@ -1672,7 +1760,7 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 	// the soft-fork deployment is fully active.
 	csvState, err := b.deploymentState(node.parent, chaincfg.DeploymentCSV)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 	if csvState == ThresholdActive {
 		// If the CSV soft-fork is now active, then modify the
@@ -1695,14 +1783,14 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 			sequenceLock, err := b.calcSequenceLock(node, tx, view,
 				false)
 			if err != nil {
-				return err
+				return 0, false, err
 			}
 			if !SequenceLockActive(sequenceLock, node.height,
 				medianTime) {
 				str := fmt.Sprintf("block contains " +
 					"transaction whose input sequence " +
 					"locks are not met")
-				return ruleError(ErrUnfinalizedTx, str)
+				return 0, false, ruleError(ErrUnfinalizedTx, str)
 			}
 		}
 	}
@@ -1714,32 +1802,41 @@ func (b *BlockChain) checkConnectUBlock(node *blockNode, ublock *btcutil.UBlock,
 		scriptFlags |= txscript.ScriptStrictMultiSig
 	}
 
-	// Now that the inexpensive checks are done and have passed, verify the
-	// transactions are actually allowed to spend the coins by running the
-	// expensive ECDSA signature check scripts.  Doing this last helps
-	// prevent CPU exhaustion attacks.
-	if runScripts {
-		err := checkBlockScripts(ublock.Block(), view, scriptFlags, b.sigCache,
-			b.hashCache)
-		if err != nil {
-			return err
-		}
+	// Enforce the taproot soft-fork package once the soft-fork has shifted
+	// into the "active" version bits state.
+	taprootState, err := b.deploymentState(node.parent,
+		chaincfg.DeploymentTaproot)
+	if err != nil {
+		return 0, false, err
+	}
+	if taprootState == ThresholdActive {
+		scriptFlags |= txscript.ScriptVerifyTaproot
 	}
 
-	return nil
+	return scriptFlags, runScripts, nil
 }
 
 // CheckConnectBlockTemplate fully validates that connecting the passed block to
 // the main chain does not violate any consensus rules, aside from the proof of
 // work requirement. The block must connect to the current tip of the main chain.
 //
+// The flags modify the behavior of this function as follows:
+//  - BFNoSignetCheck: The signet solution of the block is not checked, which
+//    is needed for templates which haven't been signed yet.
+//
 // This function is safe for concurrent access.
-func (b *BlockChain) CheckConnectBlockTemplate(block *btcutil.Block) error {
+func (b *BlockChain) CheckConnectBlockTemplate(block *btcutil.Block, flags BehaviorFlags) error {
 	b.chainLock.Lock()
 	defer b.chainLock.Unlock()
 
+	// The blocks pending script validation are connected first since the
+	// template must extend the actual tip of the chain.
+	if err := b.drainPendingBlocks(nil); err != nil {
+		return err
+	}
+
 	// Skip the proof of work check as this is just a block template.
-	flags := BFNoPoWCheck
+	flags |= BFNoPoWCheck
 
 	// This only checks whether the block can be connected to the tip of the
 	// current chain.
@@ -1755,6 +1852,10 @@ func (b *BlockChain) CheckConnectBlockTemplate(block *btcutil.Block) error {
 	if err != nil {
 		return err
 	}
+	err = b.checkSignetBlock(block, flags)
+	if err != nil {
+		return err
+	}
 
 	err = b.checkBlockContext(block, tip, flags)
 	if err != nil {
@@ -109,21 +109,21 @@ func TestCheckConnectBlockTemplate(t *testing.T) {
 	}
 
 	// Block 3 should fail to connect since it's already inserted.
-	err = chain.CheckConnectBlockTemplate(blocks[3])
+	err = chain.CheckConnectBlockTemplate(blocks[3], BFNone)
 	if err == nil {
 		t.Fatal("CheckConnectBlockTemplate: Did not received expected error " +
 			"on block 3")
 	}
 
 	// Block 4 should connect successfully to tip of chain.
-	err = chain.CheckConnectBlockTemplate(blocks[4])
+	err = chain.CheckConnectBlockTemplate(blocks[4], BFNone)
 	if err != nil {
 		t.Fatalf("CheckConnectBlockTemplate: Received unexpected error on "+
 			"block 4: %v", err)
 	}
 
 	// Block 3a should fail to connect since does not build on chain tip.
-	err = chain.CheckConnectBlockTemplate(blocks[5])
+	err = chain.CheckConnectBlockTemplate(blocks[5], BFNone)
 	if err == nil {
 		t.Fatal("CheckConnectBlockTemplate: Did not received expected error " +
 			"on block 3a")
@@ -132,7 +132,7 @@ func TestCheckConnectBlockTemplate(t *testing.T) {
 	// Block 4 should connect even if proof of work is invalid.
 	invalidPowBlock := *blocks[4].MsgBlock()
 	invalidPowBlock.Header.Nonce++
-	err = chain.CheckConnectBlockTemplate(btcutil.NewBlock(&invalidPowBlock))
+	err = chain.CheckConnectBlockTemplate(btcutil.NewBlock(&invalidPowBlock), BFNone)
 	if err != nil {
 		t.Fatalf("CheckConnectBlockTemplate: Received unexpected error on "+
 			"block 4 with bad nonce: %v", err)
@@ -141,7 +141,7 @@ func TestCheckConnectBlockTemplate(t *testing.T) {
 	// Invalid block building on chain tip should fail to connect.
 	invalidBlock := *blocks[4].MsgBlock()
 	invalidBlock.Header.Bits--
-	err = chain.CheckConnectBlockTemplate(btcutil.NewBlock(&invalidBlock))
+	err = chain.CheckConnectBlockTemplate(btcutil.NewBlock(&invalidBlock), BFNone)
 	if err == nil {
 		t.Fatal("CheckConnectBlockTemplate: Did not received expected error " +
 			"on block 4 with invalid difficulty bits")
@@ -87,6 +87,17 @@ func (c bitConditionChecker) MinerConfirmationWindow() uint32 {
 	return c.chain.chainParams.MinerConfirmationWindow
 }
 
+// MinActivationHeight is the height of the first block at which a locked in
+// rule change may become active.
+//
+// Since this implementation checks for unknown rules, it returns 0 so the rule
+// is treated as active as soon as possible.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c bitConditionChecker) MinActivationHeight() uint32 {
+	return 0
+}
+
 // Condition returns true when the specific bit associated with the checker is
 // set and it's not supposed to be according to the expected version based on
 // the known deployments and the current state of the chain.
@@ -149,11 +160,15 @@ func (c deploymentChecker) EndTime() uint64 {
 // RuleChangeActivationThreshold is the number of blocks for which the condition
 // must be true in order to lock in a rule change.
 //
-// This implementation returns the value defined by the chain params the checker
-// is associated with.
+// This implementation returns the custom threshold of the specific deployment
+// the checker is associated with when it has one, and the value defined by the
+// chain params the checker is associated with otherwise.
 //
 // This is part of the thresholdConditionChecker interface implementation.
 func (c deploymentChecker) RuleChangeActivationThreshold() uint32 {
+	if c.deployment.CustomActivationThreshold != 0 {
+		return c.deployment.CustomActivationThreshold
+	}
 	return c.chain.chainParams.RuleChangeActivationThreshold
 }
 
@@ -168,6 +183,17 @@ func (c deploymentChecker) MinerConfirmationWindow() uint32 {
 	return c.chain.chainParams.MinerConfirmationWindow
 }
 
+// MinActivationHeight is the height of the first block at which a locked in
+// rule change may become active.
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) MinActivationHeight() uint32 {
+	return c.deployment.MinActivationHeight
+}
+
 // Condition returns true when the specific bit defined by the deployment
 // associated with the checker is set.
 //
@@ -195,6 +221,10 @@ func (b *BlockChain) calcNextBlockVersion(prevNode *blockNode) (int32, error) {
 	expectedVersion := uint32(vbTopBits)
 	for id := 0; id < len(b.chainParams.Deployments); id++ {
 		deployment := &b.chainParams.Deployments[id]
+		if deployment.AlwaysActive {
+			continue
+		}
+
 		cache := &b.deploymentCaches[id]
 		checker := deploymentChecker{deployment: deployment, chain: b}
 		state, err := b.thresholdState(prevNode, checker, cache)
@@ -864,6 +864,97 @@ func (curve *KoblitzCurve) ScalarMult(Bx, By *big.Int, k []byte) (*big.Int, *big
 	return curve.fieldJacobianToBigAffine(qx, qy, qz)
 }
 
+// msmTerm is a point along with the NAF of the scalar it is multiplied by in a
+// multi-scalar multiplication.
+type msmTerm struct {
+	x, y, yNeg, z *fieldVal
+	pos, neg      []byte
+}
+
+// MultiScalarMult returns k1*P1 + k2*P2 + ... + kn*Pn where Pi is the point
+// with the affine coordinates xs[i] and ys[i] and ki is the big endian integer
+// ks[i].  It panics when the lengths of the passed slices differ.
+//
+// The products are summed using Straus' method, which shares the doublings
+// among all of the points, along with the same endomorphism and NAF
+// optimizations as ScalarMult.  This makes it considerably faster than
+// summing the results of calling ScalarMult for each of the points.
+func (curve *KoblitzCurve) MultiScalarMult(xs, ys []*big.Int,
+	ks [][]byte) (*big.Int, *big.Int) {
+
+	if len(xs) != len(ys) || len(xs) != len(ks) {
+		panic("btcec: mismatched number of points and scalars")
+	}
+
+	// Decompose each k into k1 and k2 as ScalarMult does, so that
+	//   k * P = k1 * P + k2 * ϕ(P)
+	// and keep the NAF of both halves along with their point, negated as
+	// needed depending on their sign.
+	terms := make([]msmTerm, 0, 2*len(xs))
+	var m int
+	for i := range xs {
+		k1, k2, signK1, signK2 := curve.splitK(curve.moduloReduce(ks[i]))
+
+		p1x, p1y := curve.bigAffineToField(xs[i], ys[i])
+		p2x := new(fieldVal).Mul2(p1x, curve.beta)
+		p2y := new(fieldVal).Set(p1y)
+		for _, half := range []struct {
+			x, y *fieldVal
+			k    []byte
+			sign int
+		}{{p1x, p1y, k1, signK1}, {p2x, p2y, k2, signK2}} {
+			term := msmTerm{
+				x:    half.x,
+				y:    half.y,
+				yNeg: new(fieldVal).NegateVal(half.y, 1),
+				z:    new(fieldVal).SetInt(1),
+			}
+			if half.sign == -1 {
+				term.y, term.yNeg = term.yNeg, term.y
+			}
+			term.pos, term.neg = NAF(half.k)
+			if len(term.pos) > m {
+				m = len(term.pos)
+			}
+			terms = append(terms, term)
+		}
+	}
+
+	// Pad the front of the NAFs with 0s so they are all the same length
+	// since they are processed left-to-right.
+	for i := range terms {
+		if pad := m - len(terms[i].pos); pad > 0 {
+			zeros := make([]byte, pad)
+			terms[i].pos = append(zeros, terms[i].pos...)
+			terms[i].neg = append(zeros, terms[i].neg...)
+		}
+	}
+
+	// Point Q = ∞ (point at infinity).
+	qx, qy, qz := new(fieldVal), new(fieldVal), new(fieldVal)
+
+	// Add left-to-right, doubling once per bit for all of the points.
+	for i := 0; i < m; i++ {
+		for j := 7; j >= 0; j-- {
+			// Q = 2 * Q
+			curve.doubleJacobian(qx, qy, qz, qx, qy, qz)
+
+			for _, term := range terms {
+				if term.pos[i]>>uint(j)&1 == 1 {
+					curve.addJacobian(qx, qy, qz, term.x,
+						term.y, term.z, qx, qy, qz)
+				} else if term.neg[i]>>uint(j)&1 == 1 {
+					curve.addJacobian(qx, qy, qz, term.x,
+						term.yNeg, term.z, qx, qy, qz)
+				}
+			}
+		}
+	}
+
+	// Convert the Jacobian coordinate field values back to affine big.Ints.
+	return curve.fieldJacobianToBigAffine(qx, qy, qz)
+}
+
 // ScalarBaseMult returns k*G where G is the base point of the group and k is a
 // big endian integer.
 // Part of the elliptic.Curve interface.
@@ -663,6 +663,56 @@ func TestScalarMultRand(t *testing.T) {
 	}
 }
 
+// TestMultiScalarMultRand ensures the sum of the products of random points and
+// scalars computed at once matches that computed one product at a time.
+func TestMultiScalarMultRand(t *testing.T) {
+	s256 := S256()
+	for n := 0; n <= 16; n++ {
+		xs := make([]*big.Int, n)
+		ys := make([]*big.Int, n)
+		ks := make([][]byte, n)
+		var xWant, yWant *big.Int
+		for i := 0; i < n; i++ {
+			data := make([]byte, 32)
+			if _, err := rand.Read(data); err != nil {
+				t.Fatalf("failed to read random data at %d", i)
+			}
+			xs[i], ys[i] = s256.ScalarBaseMult(data)
+			if _, err := rand.Read(data); err != nil {
+				t.Fatalf("failed to read random data at %d", i)
+			}
+			ks[i] = data
+
+			x, y := s256.ScalarMult(xs[i], ys[i], ks[i])
+			if xWant == nil {
+				xWant, yWant = x, y
+				continue
+			}
+			xWant, yWant = s256.Add(xWant, yWant, x, y)
+		}
+		if xWant == nil {
+			xWant, yWant = new(big.Int), new(big.Int)
+		}
+
+		x, y := s256.MultiScalarMult(xs, ys, ks)
+		if x.Cmp(xWant) != 0 || y.Cmp(yWant) != 0 {
+			t.Fatalf("%d points: bad output: got (%X, %X), want "+
+				"(%X, %X)", n, x, y, xWant, yWant)
+		}
+	}
+
+	// Products which cancel each other out sum to the point at infinity.
+	x, y := s256.ScalarBaseMult([]byte{0x07})
+	yNeg := new(big.Int).Sub(s256.P, y)
+	k := []byte{0x2a}
+	xGot, yGot := s256.MultiScalarMult([]*big.Int{x, x},
+		[]*big.Int{y, yNeg}, [][]byte{k, k})
+	if xGot.Sign() != 0 || yGot.Sign() != 0 {
+		t.Fatalf("bad output for cancelling products: got (%X, %X), "+
+			"want the point at infinity", xGot, yGot)
+	}
+}
+
 func TestSplitK(t *testing.T) {
 	tests := []struct {
 		k      string
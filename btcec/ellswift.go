@@ -0,0 +1,296 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// EllswiftEncodingLen is the length in bytes of an ElligatorSwift encoded
+// public key as specified in BIP0324.
+const EllswiftEncodingLen = 64
+
+var (
+	// ErrEllswiftEncode is returned when a public key can't be encoded using
+	// ElligatorSwift.  This should never happen in practice since nearly
+	// every random u value yields a valid encoding.
+	ErrEllswiftEncode = errors.New("unable to find ellswift encoding")
+
+	// ellswiftTag is the BIP0340 style tag used to hash the ElligatorSwift
+	// x-only ECDH shared secret as specified in BIP0324.
+	ellswiftTag = []byte("bip324_ellswift_xonly_ecdh")
+
+	// ellswiftC is the square root of -3 mod p which is used by the
+	// SwiftEC mapping.  It is computed the same way as the BIP0324
+	// reference implementation so both sides agree on the exact root.
+	ellswiftC *big.Int
+)
+
+// fieldMod reduces a modulo the secp256k1 field prime and returns it.
+func fieldMod(a *big.Int) *big.Int {
+	return a.Mod(a, S256().P)
+}
+
+// fieldInv returns the multiplicative inverse of a modulo the field prime.
+func fieldInv(a *big.Int) *big.Int {
+	return new(big.Int).ModInverse(a, S256().P)
+}
+
+// fieldSqrt returns a square root of a modulo the field prime or nil when a is
+// not a quadratic residue.  Since p = 3 mod 4, the root is a^((p+1)/4).
+func fieldSqrt(a *big.Int) *big.Int {
+	curve := S256()
+	r := new(big.Int).Exp(a, curve.Q(), curve.P)
+	if new(big.Int).Exp(r, big.NewInt(2), curve.P).Cmp(fieldMod(new(big.Int).Set(a))) != 0 {
+		return nil
+	}
+	return r
+}
+
+// isValidX returns whether x is the x coordinate of a point on the curve.
+func isValidX(x *big.Int) bool {
+	curve := S256()
+	y2 := new(big.Int).Exp(x, big.NewInt(3), curve.P)
+	y2.Add(y2, curve.B)
+	return fieldSqrt(fieldMod(y2)) != nil
+}
+
+// xswiftec implements the SwiftEC mapping from BIP0324 which maps any pair of
+// field elements (u, t) to the x coordinate of a point on the curve.
+func xswiftec(u, t *big.Int) *big.Int {
+	curve := S256()
+	p := curve.P
+
+	u = fieldMod(new(big.Int).Set(u))
+	t = fieldMod(new(big.Int).Set(t))
+	if u.Sign() == 0 {
+		u.SetInt64(1)
+	}
+	if t.Sign() == 0 {
+		t.SetInt64(1)
+	}
+
+	// u^3 + 7.
+	u3b := new(big.Int).Exp(u, big.NewInt(3), p)
+	u3b = fieldMod(u3b.Add(u3b, curve.B))
+
+	// if u^3 + t^2 + 7 == 0, t = 2t.
+	t2 := fieldMod(new(big.Int).Mul(t, t))
+	if fieldMod(new(big.Int).Add(u3b, t2)).Sign() == 0 {
+		t = fieldMod(t.Lsh(t, 1))
+		t2 = fieldMod(new(big.Int).Mul(t, t))
+	}
+
+	// X = (u^3 + 7 - t^2) / (2t).
+	x := new(big.Int).Sub(u3b, t2)
+	x.Mul(x, fieldInv(fieldMod(new(big.Int).Lsh(t, 1))))
+	x = fieldMod(x)
+
+	// Y = (X + t) / (c * u).
+	y := new(big.Int).Add(x, t)
+	y.Mul(y, fieldInv(fieldMod(new(big.Int).Mul(ellswiftC, u))))
+	y = fieldMod(y)
+
+	// x3 = u + 4Y^2.
+	x3 := new(big.Int).Mul(y, y)
+	x3.Lsh(x3, 2)
+	x3 = fieldMod(x3.Add(x3, u))
+	if isValidX(x3) {
+		return x3
+	}
+
+	// x2 = (-X/Y - u) / 2.
+	half := fieldInv(big.NewInt(2))
+	xDivY := fieldMod(new(big.Int).Mul(x, fieldInv(y)))
+	x2 := new(big.Int).Neg(xDivY)
+	x2.Sub(x2, u)
+	x2 = fieldMod(x2.Mul(x2, half))
+	if isValidX(x2) {
+		return x2
+	}
+
+	// x1 = (X/Y - u) / 2.
+	x1 := new(big.Int).Sub(xDivY, u)
+	return fieldMod(x1.Mul(x1, half))
+}
+
+// xswiftecInv implements the inverse of the SwiftEC mapping from BIP0324.  It
+// attempts to find a t such that xswiftec(u, t) = x for the given case (0-7)
+// and returns nil when no such t exists.
+func xswiftecInv(x, u *big.Int, c int) *big.Int {
+	curve := S256()
+	p := curve.P
+
+	u3b := new(big.Int).Exp(u, big.NewInt(3), p)
+	u3b = fieldMod(u3b.Add(u3b, curve.B))
+
+	var s, v *big.Int
+	if c&2 == 0 {
+		// -x - u must not be a valid x coordinate.
+		negXU := fieldMod(new(big.Int).Neg(new(big.Int).Add(x, u)))
+		if isValidX(negXU) {
+			return nil
+		}
+		v = new(big.Int).Set(x)
+
+		// s = -(u^3 + 7) / (u^2 + uv + v^2).
+		denom := new(big.Int).Mul(u, u)
+		denom.Add(denom, new(big.Int).Mul(u, v))
+		denom.Add(denom, new(big.Int).Mul(v, v))
+		denom = fieldMod(denom)
+		if denom.Sign() == 0 {
+			return nil
+		}
+		s = new(big.Int).Neg(u3b)
+		s = fieldMod(s.Mul(s, fieldInv(denom)))
+	} else {
+		s = fieldMod(new(big.Int).Sub(x, u))
+		if s.Sign() == 0 {
+			return nil
+		}
+
+		// r = sqrt(-s * (4(u^3 + 7) + 3su^2)).
+		inner := new(big.Int).Lsh(u3b, 2)
+		su2 := new(big.Int).Mul(s, new(big.Int).Mul(u, u))
+		inner.Add(inner, su2.Mul(su2, big.NewInt(3)))
+		inner.Mul(inner, new(big.Int).Neg(s))
+		r := fieldSqrt(fieldMod(inner))
+		if r == nil {
+			return nil
+		}
+		if c&1 != 0 && r.Sign() == 0 {
+			return nil
+		}
+
+		// v = (-u + r/s) / 2.
+		v = new(big.Int).Mul(r, fieldInv(s))
+		v.Sub(v, u)
+		v = fieldMod(v.Mul(v, fieldInv(big.NewInt(2))))
+	}
+
+	w := fieldSqrt(s)
+	if w == nil {
+		return nil
+	}
+
+	// t = w * (u * (1 - c) / 2 + v) for the even cases and
+	// t = w * (u * (1 + c) / 2 + v) for the odd ones, negated when case & 5
+	// is 0 or 5.
+	t := new(big.Int).Set(ellswiftC)
+	if c&1 == 0 {
+		t.Neg(t)
+	}
+	t.Add(t, big.NewInt(1))
+	t.Mul(t, u)
+	t.Mul(t, fieldInv(big.NewInt(2)))
+	t.Add(t, v)
+	t.Mul(t, w)
+	if c&5 == 0 || c&5 == 5 {
+		t.Neg(t)
+	}
+	return fieldMod(t)
+}
+
+// EllswiftEncode returns a uniformly random 64-byte ElligatorSwift encoding of
+// the x coordinate of the passed public key using randomness from rand.
+func EllswiftEncode(pubKey *PublicKey, rand io.Reader) ([EllswiftEncodingLen]byte, error) {
+	var enc [EllswiftEncodingLen]byte
+
+	p := S256().P
+	var buf [33]byte
+	for i := 0; i < 1000; i++ {
+		if _, err := io.ReadFull(rand, buf[:]); err != nil {
+			return enc, err
+		}
+
+		u := new(big.Int).SetBytes(buf[:32])
+		if u.Sign() == 0 || u.Cmp(p) >= 0 {
+			continue
+		}
+		t := xswiftecInv(pubKey.X, u, int(buf[32]&7))
+		if t == nil {
+			continue
+		}
+
+		u.FillBytes(enc[:32])
+		t.FillBytes(enc[32:])
+		return enc, nil
+	}
+
+	return enc, ErrEllswiftEncode
+}
+
+// EllswiftDecode decodes a 64-byte ElligatorSwift encoding into the public key
+// with the even y coordinate that has the encoded x coordinate.
+func EllswiftDecode(enc [EllswiftEncodingLen]byte) (*PublicKey, error) {
+	curve := S256()
+	u := new(big.Int).SetBytes(enc[:32])
+	t := new(big.Int).SetBytes(enc[32:])
+	x := xswiftec(u, t)
+
+	y, err := decompressPoint(curve, x, false)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// EllswiftCreate generates a new private key along with a random
+// ElligatorSwift encoding of its public key.
+func EllswiftCreate() (*PrivateKey, [EllswiftEncodingLen]byte, error) {
+	privKey, err := NewPrivateKey(S256())
+	if err != nil {
+		return nil, [EllswiftEncodingLen]byte{}, err
+	}
+	enc, err := EllswiftEncode(privKey.PubKey(), rand.Reader)
+	if err != nil {
+		return nil, [EllswiftEncodingLen]byte{}, err
+	}
+	return privKey, enc, nil
+}
+
+// EllswiftECDHXOnly performs an x-only ECDH between the passed private key and
+// the ElligatorSwift encoded public key and returns the 32-byte x coordinate of
+// the shared point.
+func EllswiftECDHXOnly(privKey *PrivateKey, theirEnc [EllswiftEncodingLen]byte) ([32]byte, error) {
+	var secret [32]byte
+	theirPub, err := EllswiftDecode(theirEnc)
+	if err != nil {
+		return secret, err
+	}
+	x, _ := S256().ScalarMult(theirPub.X, theirPub.Y, privKey.D.Bytes())
+	x.FillBytes(secret[:])
+	return secret, nil
+}
+
+// V2Ecdh computes the BIP0324 shared secret between the local private key and
+// the remote ElligatorSwift encoded public key.  The encodings are hashed in
+// initiator, responder order so both sides derive the same secret.
+func V2Ecdh(privKey *PrivateKey, theirEnc, ourEnc [EllswiftEncodingLen]byte,
+	initiating bool) (*chainhash.Hash, error) {
+
+	x, err := EllswiftECDHXOnly(privKey, theirEnc)
+	if err != nil {
+		return nil, err
+	}
+
+	if initiating {
+		return chainhash.TaggedHash(ellswiftTag, ourEnc[:],
+			theirEnc[:], x[:]), nil
+	}
+	return chainhash.TaggedHash(ellswiftTag, theirEnc[:], ourEnc[:],
+		x[:]), nil
+}
+
+func init() {
+	curve := S256()
+	minus3 := new(big.Int).Sub(curve.P, big.NewInt(3))
+	ellswiftC = new(big.Int).Exp(minus3, curve.Q(), curve.P)
+}
@@ -4,6 +4,8 @@ package btcec
 #include <stdio.h>
 #include <string.h>
 #include "secp256k1.h"
+#include "secp256k1_extrakeys.h"
+#include "secp256k1_schnorrsig.h"
 
 static secp256k1_context *ctx;
 
@@ -157,6 +159,16 @@ static int secp256k1_verify(unsigned char *msg, unsigned char *sig, int siglen,
 	return result;
 }
 
+static int secp256k1_schnorr_verify(unsigned char *msg, unsigned char *sig, unsigned char *pk) {
+	secp256k1_xonly_pubkey pubkey;
+
+	if (!secp256k1_xonly_pubkey_parse(ctx, &pubkey, pk)) {
+		return -1;
+	}
+
+	return secp256k1_schnorrsig_verify(ctx, sig, msg, 32, &pubkey);
+}
+
 */
 // #cgo CFLAGS: -I./secp256k1
 // #cgo CFLAGS: -I./secp256k1/include
@@ -171,8 +183,21 @@ func ECVerify(pkey, sign, hash []byte) int {
 		(*C.uchar)(unsafe.Pointer(&pkey[0])), C.int(len(pkey))))
 }
 
+// SchnorrVerify verifies a BIP0340 Schnorr signature of a 32-byte message by
+// a 32-byte x-only public key.  It returns -1 when the public key or the
+// arguments are malformed.
+func SchnorrVerify(pkey, sign, hash []byte) int {
+	if len(pkey) != 32 || len(sign) != 64 || len(hash) != 32 {
+		return -1
+	}
+	return int(C.secp256k1_schnorr_verify((*C.uchar)(unsafe.Pointer(&hash[0])),
+		(*C.uchar)(unsafe.Pointer(&sign[0])),
+		(*C.uchar)(unsafe.Pointer(&pkey[0]))))
+}
+
 func init() {
 	C.secp256k1_start()
 	LibsecpAvailable = true
 	LibsecpVerify = ECVerify
+	LibsecpSchnorrVerify = SchnorrVerify
 }
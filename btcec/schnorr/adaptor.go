@@ -0,0 +1,252 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// AdaptorSignatureSize is the size of an encoded adaptor signature, which is
+// the compressed encoding of the nonce point followed by the s value.
+const AdaptorSignatureSize = 65
+
+var (
+	// adaptorNonceTag is the tag of the hash the nonce of an adaptor
+	// signature is derived from.
+	adaptorNonceTag = []byte("BIP0340/nonce/adaptor")
+)
+
+var (
+	// ErrAdaptorSecretMismatch is returned when the secret extracted from
+	// an adaptor signature and a signature doesn't correspond to the
+	// adaptor point.
+	ErrAdaptorSecretMismatch = errors.New("extracted secret does not " +
+		"match the adaptor point")
+)
+
+// AdaptorSignature is a Schnorr adaptor signature, also known as a
+// pre-signature.  It is created for an adaptor point T and turns into a valid
+// BIP0340 signature once it is adapted with the discrete logarithm t of T.
+// Conversely, anyone holding both the adaptor signature and the adapted
+// signature learns t, which is what makes adaptor signatures useful for atomic
+// swaps and payment channels.
+//
+// The nonce point R of the final signature is R' + T for the nonce point R' of
+// the signer.  Since BIP0340 requires R to have an even y coordinate and the
+// signer can't negate T, the parity of R is kept in the adaptor signature and
+// decides whether t is added to or subtracted from s.
+type AdaptorSignature struct {
+	rx      *big.Int
+	ryIsOdd bool
+	s       *big.Int
+}
+
+// Serialize returns the 65-byte encoding of the adaptor signature.
+func (sig *AdaptorSignature) Serialize() []byte {
+	var b [AdaptorSignatureSize]byte
+	b[0] = 0x02
+	if sig.ryIsOdd {
+		b[0] = 0x03
+	}
+	sig.rx.FillBytes(b[1:33])
+	sig.s.FillBytes(b[33:])
+	return b[:]
+}
+
+// ParseAdaptorSignature parses a 65-byte adaptor signature.
+func ParseAdaptorSignature(sig []byte) (*AdaptorSignature, error) {
+	if len(sig) != AdaptorSignatureSize {
+		return nil, fmt.Errorf("malformed adaptor signature: wrong size: "+
+			"%d != %d", len(sig), AdaptorSignatureSize)
+	}
+	if sig[0] != 0x02 && sig[0] != 0x03 {
+		return nil, fmt.Errorf("malformed adaptor signature: invalid "+
+			"nonce point format %d", sig[0])
+	}
+
+	curve := btcec.S256()
+	rx := new(big.Int).SetBytes(sig[1:33])
+	if rx.Cmp(curve.P) >= 0 {
+		return nil, ErrSigRTooBig
+	}
+	s := new(big.Int).SetBytes(sig[33:])
+	if s.Cmp(curve.N) >= 0 {
+		return nil, ErrSigSTooBig
+	}
+
+	return &AdaptorSignature{rx: rx, ryIsOdd: sig[0] == 0x03, s: s}, nil
+}
+
+// nonce returns the nonce point R of the adaptor signature.
+func (sig *AdaptorSignature) nonce() (*btcec.PublicKey, error) {
+	var b [btcec.PubKeyBytesLenCompressed]byte
+	b[0] = 0x02
+	if sig.ryIsOdd {
+		b[0] = 0x03
+	}
+	sig.rx.FillBytes(b[1:])
+	return btcec.ParsePubKey(b[:], btcec.S256())
+}
+
+// AdaptorSign creates an adaptor signature of the passed 32-byte message with
+// the passed private key for the passed adaptor point.  The nonce is derived
+// the same way as Sign derives it, additionally committing to the adaptor
+// point, so the CustomNonce option applies as well.
+func AdaptorSign(privKey *btcec.PrivateKey, hash []byte,
+	adaptorPoint *btcec.PublicKey,
+	signOpts ...SignOption) (*AdaptorSignature, error) {
+
+	opts := &signOptions{}
+	for _, option := range signOpts {
+		option(opts)
+	}
+
+	if len(hash) != chainhash.HashSize {
+		return nil, fmt.Errorf("wrong size for message (got %v, want %v)",
+			len(hash), chainhash.HashSize)
+	}
+
+	curve := btcec.S256()
+	d := new(big.Int).Set(privKey.D)
+	if d.Sign() == 0 || d.Cmp(curve.N) >= 0 {
+		return nil, ErrPrivateKeyIsZero
+	}
+	pubKey := privKey.PubKey()
+	if pubKey.Y.Bit(0) == 1 {
+		d.Sub(curve.N, d)
+	}
+	pubKeyBytes := SerializePubKey(pubKey)
+
+	auxRand := opts.auxRand
+	if auxRand == nil {
+		auxRand = new([32]byte)
+		if _, err := rand.Read(auxRand[:]); err != nil {
+			return nil, err
+		}
+	}
+	var t [32]byte
+	d.FillBytes(t[:])
+	auxHash := chainhash.TaggedHash(auxTag, auxRand[:])
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	nonceHash := chainhash.TaggedHash(adaptorNonceTag, t[:], pubKeyBytes,
+		hash, adaptorPoint.SerializeCompressed())
+	k := new(big.Int).SetBytes(nonceHash[:])
+	k.Mod(k, curve.N)
+	if k.Sign() == 0 {
+		return nil, ErrNonceIsZero
+	}
+
+	// R = k*G + T.  The signer's nonce k is negated when R has an odd y
+	// coordinate so that s*G = -R' + e*P, where R' = R - T.
+	kx, ky := curve.ScalarBaseMult(k.Bytes())
+	rx, ry := curve.Add(kx, ky, adaptorPoint.X, adaptorPoint.Y)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return nil, ErrSigRNotOnCurve
+	}
+	ryIsOdd := ry.Bit(0) == 1
+	if ryIsOdd {
+		k.Sub(curve.N, k)
+	}
+
+	// s' = k + e*d mod n with e committing to the final nonce point.
+	var rBytes [32]byte
+	rx.FillBytes(rBytes[:])
+	s := challenge(rBytes[:], pubKeyBytes, hash)
+	s.Mul(s, d)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	sig := &AdaptorSignature{rx: rx, ryIsOdd: ryIsOdd, s: s}
+	if !opts.fastSign && !sig.Verify(hash, pubKey, adaptorPoint) {
+		return nil, errors.New("created adaptor signature is invalid")
+	}
+	return sig, nil
+}
+
+// Verify returns whether or not the adaptor signature is valid for the passed
+// 32-byte message, public key and adaptor point, which means that adapting it
+// with the discrete logarithm of the adaptor point results in a valid BIP0340
+// signature.  Only the x coordinate of the public key is used.
+func (sig *AdaptorSignature) Verify(hash []byte, pubKey *btcec.PublicKey,
+	adaptorPoint *btcec.PublicKey) bool {
+
+	if len(hash) != chainhash.HashSize {
+		return false
+	}
+	r, err := sig.nonce()
+	if err != nil {
+		return false
+	}
+	pubKeyBytes := SerializePubKey(pubKey)
+	p, err := ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return false
+	}
+
+	// R' = R - T, negated when R has an odd y coordinate.
+	curve := btcec.S256()
+	negTY := new(big.Int).Sub(curve.P, adaptorPoint.Y)
+	rpx, rpy := curve.Add(r.X, r.Y, adaptorPoint.X, negTY)
+	if sig.ryIsOdd && (rpx.Sign() != 0 || rpy.Sign() != 0) {
+		rpy = new(big.Int).Sub(curve.P, rpy)
+	}
+
+	// s'*G = R' + e*P.
+	var rBytes [32]byte
+	sig.rx.FillBytes(rBytes[:])
+	e := challenge(rBytes[:], pubKeyBytes, hash)
+	epx, epy := curve.ScalarMult(p.X, p.Y, e.Bytes())
+	wantX, wantY := curve.Add(rpx, rpy, epx, epy)
+	sgx, sgy := curve.ScalarBaseMult(sig.s.Bytes())
+	return sgx.Cmp(wantX) == 0 && sgy.Cmp(wantY) == 0
+}
+
+// Adapt returns the BIP0340 signature resulting from adapting the adaptor
+// signature with the passed adaptor secret, which is the discrete logarithm
+// of the adaptor point the adaptor signature was created for.
+func (sig *AdaptorSignature) Adapt(adaptorSecret *btcec.PrivateKey) *Signature {
+	curve := btcec.S256()
+	s := new(big.Int)
+	if sig.ryIsOdd {
+		s.Sub(sig.s, adaptorSecret.D)
+	} else {
+		s.Add(sig.s, adaptorSecret.D)
+	}
+	s.Mod(s, curve.N)
+	return NewSignature(sig.rx, s)
+}
+
+// Extract returns the adaptor secret for the passed adaptor point given the
+// signature that resulted from adapting the adaptor signature with it.
+func (sig *AdaptorSignature) Extract(adaptedSig *Signature,
+	adaptorPoint *btcec.PublicKey) (*btcec.PrivateKey, error) {
+
+	if adaptedSig.r.Cmp(sig.rx) != 0 {
+		return nil, ErrUnequalRValues
+	}
+
+	curve := btcec.S256()
+	t := new(big.Int)
+	if sig.ryIsOdd {
+		t.Sub(sig.s, adaptedSig.s)
+	} else {
+		t.Sub(adaptedSig.s, sig.s)
+	}
+	t.Mod(t, curve.N)
+
+	secret, pub := btcec.PrivKeyFromBytes(curve, t.Bytes())
+	if pub.X.Cmp(adaptorPoint.X) != 0 || pub.Y.Cmp(adaptorPoint.Y) != 0 {
+		return nil, ErrAdaptorSecretMismatch
+	}
+	return secret, nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// TestAdaptorSignatures ensures adaptor signatures verify, adapt into valid
+// signatures and reveal their adaptor secret.
+func TestAdaptorSignatures(t *testing.T) {
+	t.Parallel()
+
+	// Use enough keys, secrets and messages to cover both parities of the
+	// public key and of the final nonce point.
+	seenParity := make(map[bool]bool)
+	for i := 0; i < 16; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprintf("adaptor key %d", i)))
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), seed[:])
+		seed = sha256.Sum256([]byte(fmt.Sprintf("adaptor secret %d", i)))
+		secret, adaptorPoint := btcec.PrivKeyFromBytes(btcec.S256(),
+			seed[:])
+		msg := sha256.Sum256([]byte(fmt.Sprintf("adaptor msg %d", i)))
+
+		adaptorSig, err := AdaptorSign(privKey, msg[:], adaptorPoint)
+		if err != nil {
+			t.Fatalf("test #%d: unable to sign: %v", i, err)
+		}
+		seenParity[adaptorSig.ryIsOdd] = true
+		if !adaptorSig.Verify(msg[:], pubKey, adaptorPoint) {
+			t.Fatalf("test #%d: adaptor signature does not verify", i)
+		}
+
+		// The adaptor signature must round trip through its encoding.
+		parsed, err := ParseAdaptorSignature(adaptorSig.Serialize())
+		if err != nil {
+			t.Fatalf("test #%d: unable to parse: %v", i, err)
+		}
+		if !bytes.Equal(parsed.Serialize(), adaptorSig.Serialize()) {
+			t.Fatalf("test #%d: adaptor signature does not round trip",
+				i)
+		}
+
+		// It must not verify for another message, key or adaptor
+		// point.
+		otherMsg := sha256.Sum256(msg[:])
+		if adaptorSig.Verify(otherMsg[:], pubKey, adaptorPoint) {
+			t.Fatalf("test #%d: verified for wrong message", i)
+		}
+		if adaptorSig.Verify(msg[:], adaptorPoint, adaptorPoint) {
+			t.Fatalf("test #%d: verified for wrong key", i)
+		}
+		if adaptorSig.Verify(msg[:], pubKey, pubKey) {
+			t.Fatalf("test #%d: verified for wrong adaptor point", i)
+		}
+
+		// Adapting it with the secret results in a valid signature,
+		// which reveals the secret.
+		sig := adaptorSig.Adapt(secret)
+		if !sig.Verify(msg[:], pubKey) {
+			t.Fatalf("test #%d: adapted signature does not verify", i)
+		}
+		extracted, err := adaptorSig.Extract(sig, adaptorPoint)
+		if err != nil {
+			t.Fatalf("test #%d: unable to extract secret: %v", i, err)
+		}
+		if extracted.D.Cmp(secret.D) != 0 {
+			t.Fatalf("test #%d: extracted wrong secret", i)
+		}
+
+		// Adapting with the wrong secret results in an invalid
+		// signature.
+		if adaptorSig.Adapt(privKey).Verify(msg[:], pubKey) {
+			t.Fatalf("test #%d: signature adapted with wrong secret "+
+				"verifies", i)
+		}
+		if _, err := adaptorSig.Extract(sig, pubKey); err == nil {
+			t.Fatalf("test #%d: extracted secret of wrong adaptor "+
+				"point", i)
+		}
+	}
+	if !seenParity[true] || !seenParity[false] {
+		t.Fatalf("nonce point parities not covered: %v", seenParity)
+	}
+}
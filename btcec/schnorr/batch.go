@@ -0,0 +1,176 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// batchEntry houses a signature queued for batch verification along with the
+// message and public key it is verified against.
+type batchEntry struct {
+	sig     *Signature
+	hash    [chainhash.HashSize]byte
+	pubKey  []byte
+	pubKeyX *big.Int
+	pubKeyY *big.Int
+}
+
+// BatchVerifier collects signatures and verifies all of them at once using
+// the batch verification algorithm of BIP0340, which is cheaper than
+// verifying each of them on its own.  A batch only tells whether or not all
+// of the signatures are valid; the signatures must be verified individually
+// to find out which of them are invalid when a batch fails.
+//
+// The verifier is safe for concurrent access.
+type BatchVerifier struct {
+	mtx     sync.Mutex
+	entries []batchEntry
+}
+
+// NewBatchVerifier returns a new empty batch verifier.
+func NewBatchVerifier() *BatchVerifier {
+	return &BatchVerifier{}
+}
+
+// Add queues the signature of the passed 32-byte message by the passed public
+// key for verification.  Only the x coordinate of the public key is used.
+func (b *BatchVerifier) Add(sig *Signature, hash []byte,
+	pubKey *btcec.PublicKey) {
+
+	// Use the point with the x coordinate of the key and an even y
+	// coordinate as BIP0340 does.
+	curve := btcec.S256()
+	entry := batchEntry{
+		sig:     sig,
+		pubKey:  SerializePubKey(pubKey),
+		pubKeyX: pubKey.X,
+		pubKeyY: pubKey.Y,
+	}
+	if pubKey.Y.Bit(0) == 1 {
+		entry.pubKeyY = new(big.Int).Sub(curve.P, pubKey.Y)
+	}
+	copy(entry.hash[:], hash)
+
+	b.mtx.Lock()
+	b.entries = append(b.entries, entry)
+	b.mtx.Unlock()
+}
+
+// Len returns the number of signatures queued for verification.
+func (b *BatchVerifier) Len() int {
+	b.mtx.Lock()
+	n := len(b.entries)
+	b.mtx.Unlock()
+	return n
+}
+
+// ForEach calls the passed function with the message, serialized signature and
+// serialized x-only public key of each of the queued signatures.  It allows
+// the callers to remember the signatures once the batch is known to be valid.
+func (b *BatchVerifier) ForEach(f func(hash, sig, pubKey []byte)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for i := range b.entries {
+		entry := &b.entries[i]
+		f(entry.hash[:], entry.sig.Serialize(), entry.pubKey)
+	}
+}
+
+// randomizer returns a uniformly random integer in the range [1, n-1].
+func randomizer() (*big.Int, error) {
+	nMinusOne := new(big.Int).Sub(btcec.S256().N, big.NewInt(1))
+	a, err := rand.Int(rand.Reader, nMinusOne)
+	if err != nil {
+		return nil, err
+	}
+	return a.Add(a, big.NewInt(1)), nil
+}
+
+// Verify returns whether or not all of the queued signatures are valid.  An
+// empty batch is valid.
+//
+// Following BIP0340, the batch is valid when
+// (s1 + a2*s2 + ... + au*su)*G = R1 + a2*R2 + ... + au*Ru + e1*P1 +
+// (a2*e2)*P2 + ... + (au*eu)*Pu for random a2...au, which a batch containing
+// an invalid signature only satisfies with negligible probability.  The right
+// hand side is computed with a single multi-scalar multiplication, which is
+// what makes verifying a batch cheaper than verifying its signatures one at a
+// time.
+func (b *BatchVerifier) Verify() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if len(b.entries) == 0 {
+		return true
+	}
+
+	// libsecp256k1 verifies a signature faster than the batch verifies
+	// its share of it, so verify the signatures on their own when it is
+	// available.
+	if btcec.LibsecpSchnorrVerify != nil {
+		for i := range b.entries {
+			entry := &b.entries[i]
+			if !entry.sig.Verify(entry.hash[:], &btcec.PublicKey{
+				Curve: btcec.S256(),
+				X:     entry.pubKeyX,
+				Y:     entry.pubKeyY,
+			}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	curve := btcec.S256()
+	sumS := new(big.Int)
+	xs := make([]*big.Int, 0, 2*len(b.entries))
+	ys := make([]*big.Int, 0, 2*len(b.entries))
+	ks := make([][]byte, 0, 2*len(b.entries))
+	for i, entry := range b.entries {
+		// a1 is 1 while the others are random.
+		a := big.NewInt(1)
+		if i > 0 {
+			var err error
+			a, err = randomizer()
+			if err != nil {
+				return false
+			}
+		}
+
+		// R = lift_x(r), which fails when r isn't the x coordinate of
+		// a point on the curve.
+		var rBytes [32]byte
+		entry.sig.r.FillBytes(rBytes[:])
+		r, err := ParsePubKey(rBytes[:])
+		if err != nil {
+			return false
+		}
+
+		// e = int(hash_BIP0340/challenge(bytes(r) || bytes(P) || m))
+		// mod n.
+		e := challenge(rBytes[:], entry.pubKey, entry.hash[:])
+		e.Mul(e, a)
+		e.Mod(e, curve.N)
+
+		// Accumulate a*s and queue the terms a*R and a*e*P.
+		as := new(big.Int).Mul(a, entry.sig.s)
+		sumS.Add(sumS, as)
+		xs = append(xs, r.X, entry.pubKeyX)
+		ys = append(ys, r.Y, entry.pubKeyY)
+		ks = append(ks, a.Bytes(), e.Bytes())
+	}
+	sumS.Mod(sumS, curve.N)
+
+	sumX, sumY := curve.MultiScalarMult(xs, ys, ks)
+	sgx, sgy := curve.ScalarBaseMult(sumS.Bytes())
+	return sgx.Cmp(sumX) == 0 && sgy.Cmp(sumY) == 0
+}
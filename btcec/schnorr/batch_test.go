@@ -0,0 +1,141 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// TestBatchVerify ensures batches are only valid when all of the signatures
+// in them are.
+func TestBatchVerify(t *testing.T) {
+	t.Parallel()
+
+	// Create signatures by keys with both even and odd y coordinates.
+	const numSigs = 8
+	sigs := make([]*Signature, numSigs)
+	msgs := make([][]byte, numSigs)
+	pubKeys := make([]*btcec.PublicKey, numSigs)
+	for i := 0; i < numSigs; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprintf("batch key %d", i)))
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), seed[:])
+		msg := sha256.Sum256([]byte(fmt.Sprintf("batch msg %d", i)))
+
+		sig, err := Sign(privKey, msg[:])
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		sigs[i], msgs[i], pubKeys[i] = sig, msg[:], pubKey
+	}
+
+	if !NewBatchVerifier().Verify() {
+		t.Fatalf("empty batch is invalid")
+	}
+
+	batch := NewBatchVerifier()
+	for i := range sigs {
+		batch.Add(sigs[i], msgs[i], pubKeys[i])
+	}
+	if batch.Len() != numSigs {
+		t.Fatalf("got batch length %d, want %d", batch.Len(), numSigs)
+	}
+	if !batch.Verify() {
+		t.Fatalf("valid batch is invalid")
+	}
+
+	// Each signature in the wrong place must invalidate the batch.
+	for i := range sigs {
+		batch := NewBatchVerifier()
+		for j := range sigs {
+			msg := msgs[j]
+			if i == j {
+				msg = msgs[(j+1)%numSigs]
+			}
+			batch.Add(sigs[j], msg, pubKeys[j])
+		}
+		if batch.Verify() {
+			t.Errorf("batch with invalid signature #%d is valid", i)
+		}
+	}
+
+	// The BIP0340 test vectors must be accepted and rejected in a batch of
+	// their own just like they are when verified individually.
+	for i, test := range bip340TestVectors {
+		pubKey, err := ParsePubKey(decodeHex(test.publicKey))
+		if err != nil {
+			continue
+		}
+		sig, err := ParseSignature(decodeHex(test.signature))
+		if err != nil {
+			continue
+		}
+
+		batch := NewBatchVerifier()
+		batch.Add(sigs[0], msgs[0], pubKeys[0])
+		batch.Add(sig, decodeHex(test.message), pubKey)
+		if batch.Verify() != test.verifyResult {
+			t.Errorf("test #%v: got batch result %v, want %v", i,
+				!test.verifyResult, test.verifyResult)
+		}
+	}
+}
+
+// batchBenchSigs returns the passed number of signatures along with the
+// messages and keys they are verified against.
+func batchBenchSigs(b *testing.B, n int) ([]*Signature, [][]byte,
+	[]*btcec.PublicKey) {
+
+	sigs := make([]*Signature, n)
+	msgs := make([][]byte, n)
+	pubKeys := make([]*btcec.PublicKey, n)
+	for i := 0; i < n; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprintf("bench key %d", i)))
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), seed[:])
+		msg := sha256.Sum256([]byte(fmt.Sprintf("bench msg %d", i)))
+		sig, err := Sign(privKey, msg[:])
+		if err != nil {
+			b.Fatalf("unable to sign: %v", err)
+		}
+		sigs[i], msgs[i], pubKeys[i] = sig, msg[:], pubKey
+	}
+	return sigs, msgs, pubKeys
+}
+
+// BenchmarkBatchVerify benchmarks verifying 100 signatures in a batch.
+func BenchmarkBatchVerify(b *testing.B) {
+	sigs, msgs, pubKeys := batchBenchSigs(b, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch := NewBatchVerifier()
+		for j := range sigs {
+			batch.Add(sigs[j], msgs[j], pubKeys[j])
+		}
+		if !batch.Verify() {
+			b.Fatal("valid batch is invalid")
+		}
+	}
+}
+
+// BenchmarkVerifyIndividually benchmarks verifying the same 100 signatures as
+// BenchmarkBatchVerify one at a time.
+func BenchmarkVerifyIndividually(b *testing.B) {
+	sigs, msgs, pubKeys := batchBenchSigs(b, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range sigs {
+			if !sigs[j].Verify(msgs[j], pubKeys[j]) {
+				b.Fatal("valid signature is invalid")
+			}
+		}
+	}
+}
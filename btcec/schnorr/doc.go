@@ -0,0 +1,23 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package schnorr implements the Schnorr signatures over secp256k1 specified by
+BIP0340 along with the x-only public keys they are made with.
+
+Signatures are 64 bytes: the x coordinate of the nonce point followed by the s
+value.  Public keys are the 32-byte x coordinate of a point on the curve, which
+is implicitly the point with that x coordinate and an even y coordinate.
+
+Signatures are created with Sign and verified with Signature.Verify, which uses
+libsecp256k1 when it is available.  Many signatures can be verified at once
+with a BatchVerifier, which is cheaper than verifying them one at a time but
+only tells whether or not all of them are valid.
+
+Adaptor signatures, created with AdaptorSign, are signatures which only become
+valid once adapted with the discrete logarithm of an adaptor point, and which
+reveal that secret to anyone holding the adapted signature.  Multi-signatures
+are provided by the musig2 subpackage.
+*/
+package schnorr
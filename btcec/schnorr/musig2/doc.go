@@ -0,0 +1,24 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package musig2 implements the MuSig2 multi-signature scheme for BIP0340 Schnorr
+signatures as specified by BIP0327.
+
+A group of signers aggregates their public keys into a single x-only key with
+AggregateKeys, which may then be tweaked, for example to commit to a taproot
+script tree.  Signing takes two rounds:
+
+ 1. Each signer creates a pair of nonces with GenNonces and shares the public
+    half.  The public nonces are combined with AggregateNonces.
+ 2. Each signer creates a partial signature with Sign, which consumes the
+    secret nonce.  The partial signatures can be checked with
+    PartialSignature.Verify and are combined with AggregatePartialSigs into an
+    ordinary BIP0340 signature valid for the aggregate key.
+
+A secret nonce must never be used for more than one signature since doing so
+leaks the private key of the signer.  Sign zeroes the secret nonce it is given
+to guard against accidental reuse.
+*/
+package musig2
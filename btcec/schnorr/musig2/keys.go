@@ -0,0 +1,324 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package musig2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var (
+	// keyAggListTag is the tag of the hash committing to the list of
+	// aggregated public keys.
+	keyAggListTag = []byte("KeyAgg list")
+
+	// keyAggCoeffTag is the tag of the hash the key aggregation
+	// coefficient of each public key is derived from.
+	keyAggCoeffTag = []byte("KeyAgg coefficient")
+
+	// tapTweakTag is the BIP0341 tag of the hash an internal key is
+	// tweaked with to commit to a script tree.
+	tapTweakTag = []byte("TapTweak")
+)
+
+var (
+	// ErrNoKeys is returned when aggregating an empty set of public keys.
+	ErrNoKeys = errors.New("no public keys to aggregate")
+
+	// ErrAggregateKeyIsInfinity is returned when the aggregate public key
+	// is the point at infinity.
+	ErrAggregateKeyIsInfinity = errors.New("aggregate public key is the " +
+		"point at infinity")
+
+	// ErrTweakTooBig is returned when a tweak isn't less than the group
+	// order.
+	ErrTweakTooBig = errors.New("the tweak must be less than n")
+
+	// ErrTweakedKeyIsInfinity is returned when tweaking an aggregate key
+	// results in the point at infinity.
+	ErrTweakedKeyIsInfinity = errors.New("the result of tweaking cannot " +
+		"be infinity")
+)
+
+// point is an affine point on the secp256k1 curve, with the point at infinity
+// represented as (0, 0).
+type point struct {
+	x, y *big.Int
+}
+
+// infinity returns the point at infinity.
+func infinity() point {
+	return point{x: new(big.Int), y: new(big.Int)}
+}
+
+// isInfinity returns whether or not the point is the point at infinity.
+func (p point) isInfinity() bool {
+	return p.x.Sign() == 0 && p.y.Sign() == 0
+}
+
+// hasEvenY returns whether or not the y coordinate of the point is even.
+func (p point) hasEvenY() bool {
+	return p.y.Bit(0) == 0
+}
+
+// add returns the sum of the point and the passed point.
+func (p point) add(q point) point {
+	x, y := btcec.S256().Add(p.x, p.y, q.x, q.y)
+	return point{x: x, y: y}
+}
+
+// mul returns the point multiplied by the passed scalar.
+func (p point) mul(k *big.Int) point {
+	if p.isInfinity() {
+		return infinity()
+	}
+	x, y := btcec.S256().ScalarMult(p.x, p.y, k.Bytes())
+	return point{x: x, y: y}
+}
+
+// negate returns the negation of the point.
+func (p point) negate() point {
+	if p.isInfinity() {
+		return p
+	}
+	return point{x: p.x, y: new(big.Int).Sub(btcec.S256().P, p.y)}
+}
+
+// equal returns whether or not the point is the same as the passed point.
+func (p point) equal(q point) bool {
+	return p.x.Cmp(q.x) == 0 && p.y.Cmp(q.y) == 0
+}
+
+// pubKey returns the point as a public key.  It must not be the point at
+// infinity.
+func (p point) pubKey() *btcec.PublicKey {
+	return &btcec.PublicKey{Curve: btcec.S256(), X: p.x, Y: p.y}
+}
+
+// cbytes returns the 33-byte compressed encoding of the point, which must not
+// be the point at infinity.
+func (p point) cbytes() []byte {
+	return p.pubKey().SerializeCompressed()
+}
+
+// cbytesExt returns the 33-byte compressed encoding of the point, encoding the
+// point at infinity as 33 zero bytes.
+func (p point) cbytesExt() []byte {
+	if p.isInfinity() {
+		return make([]byte, btcec.PubKeyBytesLenCompressed)
+	}
+	return p.cbytes()
+}
+
+// xbytes returns the 32-byte x coordinate of the point.
+func (p point) xbytes() []byte {
+	var b [32]byte
+	p.x.FillBytes(b[:])
+	return b[:]
+}
+
+// baseMul returns the generator multiplied by the passed scalar.
+func baseMul(k *big.Int) point {
+	x, y := btcec.S256().ScalarBaseMult(k.Bytes())
+	return point{x: x, y: y}
+}
+
+// pointFromPubKey returns the point of the passed public key.
+func pointFromPubKey(pubKey *btcec.PublicKey) point {
+	return point{x: pubKey.X, y: pubKey.Y}
+}
+
+// parseCompressedPoint parses a 33-byte compressed point, rejecting x
+// coordinates which aren't field elements.
+func parseCompressedPoint(b []byte) (point, error) {
+	if len(b) != btcec.PubKeyBytesLenCompressed {
+		return point{}, fmt.Errorf("invalid compressed point length %d",
+			len(b))
+	}
+	if new(big.Int).SetBytes(b[1:]).Cmp(btcec.S256().P) >= 0 {
+		return point{}, fmt.Errorf("point x coordinate is >= field prime")
+	}
+	pubKey, err := btcec.ParsePubKey(b, btcec.S256())
+	if err != nil {
+		return point{}, err
+	}
+	return pointFromPubKey(pubKey), nil
+}
+
+// parseCompressedPointExt parses a 33-byte compressed point, where 33 zero
+// bytes encode the point at infinity.
+func parseCompressedPointExt(b []byte) (point, error) {
+	if bytes.Equal(b, make([]byte, btcec.PubKeyBytesLenCompressed)) {
+		return infinity(), nil
+	}
+	return parseCompressedPoint(b)
+}
+
+// KeySort returns a copy of the passed public keys sorted by their compressed
+// encoding, which lets signers agree on the aggregate key without agreeing on
+// the order of the keys beforehand.
+func KeySort(pubKeys []*btcec.PublicKey) []*btcec.PublicKey {
+	sorted := make([]*btcec.PublicKey, len(pubKeys))
+	copy(sorted, pubKeys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].SerializeCompressed(),
+			sorted[j].SerializeCompressed()) < 0
+	})
+	return sorted
+}
+
+// KeyAggContext houses an aggregate public key along with the state needed to
+// sign for it, which includes the tweaks applied to it.  It is immutable.
+type KeyAggContext struct {
+	// pubKeys are the compressed encodings of the aggregated public keys
+	// in the order they were aggregated.
+	pubKeys [][]byte
+
+	// secondKey is the compressed encoding of the first aggregated key
+	// which differs from the first one, or nil if there is none.  It is
+	// the only key with a coefficient of 1.
+	secondKey []byte
+
+	// keysHash is the hash of the list of aggregated public keys.
+	keysHash *chainhash.Hash
+
+	// q is the aggregate public key with the tweaks applied.
+	q point
+
+	// gacc and tacc accumulate the negations and the tweaks applied to
+	// the aggregate key.
+	gacc *big.Int
+	tacc *big.Int
+}
+
+// AggregateKeys aggregates the passed public keys, in the passed order, into
+// a single MuSig2 public key.  Callers which don't want to depend on the order
+// of the keys should sort them with KeySort first.
+func AggregateKeys(pubKeys []*btcec.PublicKey) (*KeyAggContext, error) {
+	if len(pubKeys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	ctx := &KeyAggContext{
+		pubKeys: make([][]byte, len(pubKeys)),
+		gacc:    big.NewInt(1),
+		tacc:    new(big.Int),
+	}
+	for i, pubKey := range pubKeys {
+		ctx.pubKeys[i] = pubKey.SerializeCompressed()
+	}
+	for _, pubKey := range ctx.pubKeys[1:] {
+		if !bytes.Equal(pubKey, ctx.pubKeys[0]) {
+			ctx.secondKey = pubKey
+			break
+		}
+	}
+	ctx.keysHash = chainhash.TaggedHash(keyAggListTag, ctx.pubKeys...)
+
+	// Q = a1*P1 + ... + au*Pu.
+	q := infinity()
+	for i, pubKey := range pubKeys {
+		a := ctx.keyAggCoeff(ctx.pubKeys[i])
+		q = q.add(pointFromPubKey(pubKey).mul(a))
+	}
+	if q.isInfinity() {
+		return nil, ErrAggregateKeyIsInfinity
+	}
+	ctx.q = q
+
+	return ctx, nil
+}
+
+// keyAggCoeff returns the key aggregation coefficient of the passed compressed
+// public key.
+func (ctx *KeyAggContext) keyAggCoeff(pubKey []byte) *big.Int {
+	if ctx.secondKey != nil && bytes.Equal(pubKey, ctx.secondKey) {
+		return big.NewInt(1)
+	}
+	h := chainhash.TaggedHash(keyAggCoeffTag, ctx.keysHash[:], pubKey)
+	a := new(big.Int).SetBytes(h[:])
+	return a.Mod(a, btcec.S256().N)
+}
+
+// hasKey returns whether or not the passed compressed public key is one of the
+// aggregated keys.
+func (ctx *KeyAggContext) hasKey(pubKey []byte) bool {
+	for _, key := range ctx.pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tweak returns a new context for the aggregate key tweaked by the passed
+// 32-byte tweak.  An x-only tweak adds the tweak to the aggregate key with an
+// even y coordinate as done by taproot, while a plain tweak adds it to the
+// aggregate key itself as done by BIP0032 derivation.
+func (ctx *KeyAggContext) Tweak(tweak [32]byte,
+	isXOnly bool) (*KeyAggContext, error) {
+
+	curve := btcec.S256()
+	t := new(big.Int).SetBytes(tweak[:])
+	if t.Cmp(curve.N) >= 0 {
+		return nil, ErrTweakTooBig
+	}
+
+	// Q' = g*Q + t*G where g is -1 for x-only tweaks of a key with an odd
+	// y coordinate and 1 otherwise.
+	g := big.NewInt(1)
+	q := ctx.q
+	if isXOnly && !q.hasEvenY() {
+		g.Sub(curve.N, g)
+		q = q.negate()
+	}
+	q = q.add(baseMul(t))
+	if q.isInfinity() {
+		return nil, ErrTweakedKeyIsInfinity
+	}
+
+	// gacc' = g*gacc mod n and tacc' = t + g*tacc mod n.
+	gacc := new(big.Int).Mul(g, ctx.gacc)
+	gacc.Mod(gacc, curve.N)
+	tacc := new(big.Int).Mul(g, ctx.tacc)
+	tacc.Add(tacc, t)
+	tacc.Mod(tacc, curve.N)
+
+	tweaked := *ctx
+	tweaked.q = q
+	tweaked.gacc = gacc
+	tweaked.tacc = tacc
+	return &tweaked, nil
+}
+
+// TaprootTweak returns a new context for the BIP0341 taproot output key which
+// has the aggregate key as its internal key and commits to the passed script
+// tree root.  The script root is empty for outputs which can only be spent
+// via the key path.
+func (ctx *KeyAggContext) TaprootTweak(scriptRoot []byte) (*KeyAggContext, error) {
+	var tweak [32]byte
+	copy(tweak[:], chainhash.TaggedHash(tapTweakTag, ctx.XOnlyPubKey(),
+		scriptRoot)[:])
+	return ctx.Tweak(tweak, true)
+}
+
+// PubKey returns the aggregate public key with the tweaks applied.
+func (ctx *KeyAggContext) PubKey() *btcec.PublicKey {
+	return ctx.q.pubKey()
+}
+
+// XOnlyPubKey returns the BIP0340 x-only encoding of the aggregate public key
+// with the tweaks applied, which is the key the final signatures are valid
+// for.
+func (ctx *KeyAggContext) XOnlyPubKey() []byte {
+	return schnorr.SerializePubKey(ctx.PubKey())
+}
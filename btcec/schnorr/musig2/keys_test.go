@@ -0,0 +1,192 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package musig2
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// loadTestVectors decodes the BIP0327 JSON test vectors in the passed file of
+// the testdata directory into the passed value.
+func loadTestVectors(t *testing.T, fileName string, v interface{}) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", fileName))
+	if err != nil {
+		t.Fatalf("unable to read test vectors: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("unable to decode test vectors: %v", err)
+	}
+}
+
+// decodeHex decodes the passed hex string and returns the resulting bytes.  It
+// panics if an error occurs.  This is only used in the tests as a helper since
+// the only way it can fail is if there is an error in the test source code.
+func decodeHex(hexStr string) []byte {
+	b, err := hex.DecodeString(hexStr)
+	if err != nil {
+		panic("invalid hex string in test source: err " + err.Error() +
+			", hex: " + hexStr)
+	}
+
+	return b
+}
+
+// toHex returns the upper case hex encoding of the passed bytes as used by the
+// test vectors.
+func toHex(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// parseTestKeys parses the public keys with the passed indices.  It returns
+// the index of the first key which isn't a valid compressed public key along
+// with the error parsing it, if any.
+func parseTestKeys(keys []string, indices []int) ([]*btcec.PublicKey, int, error) {
+	pubKeys := make([]*btcec.PublicKey, 0, len(indices))
+	for i, idx := range indices {
+		p, err := parseCompressedPoint(decodeHex(keys[idx]))
+		if err != nil {
+			return nil, i, err
+		}
+		pubKeys = append(pubKeys, p.pubKey())
+	}
+	return pubKeys, -1, nil
+}
+
+// testVectorError is the error of a BIP0327 test vector.
+type testVectorError struct {
+	Type    string `json:"type"`
+	Signer  *int   `json:"signer"`
+	Contrib string `json:"contrib"`
+	Message string `json:"message"`
+}
+
+// applyTestTweaks applies the tweaks with the passed indices to the passed
+// context.
+func applyTestTweaks(ctx *KeyAggContext, tweaks []string, indices []int,
+	isXOnly []bool) (*KeyAggContext, error) {
+
+	for i, idx := range indices {
+		var tweak [32]byte
+		copy(tweak[:], decodeHex(tweaks[idx]))
+
+		var err error
+		ctx, err = ctx.Tweak(tweak, isXOnly[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// TestKeySort ensures keys are sorted as specified by the BIP0327 test
+// vectors.
+func TestKeySort(t *testing.T) {
+	t.Parallel()
+
+	var vectors struct {
+		PubKeys       []string `json:"pubkeys"`
+		SortedPubKeys []string `json:"sorted_pubkeys"`
+	}
+	loadTestVectors(t, "key_sort_vectors.json", &vectors)
+
+	indices := make([]int, len(vectors.PubKeys))
+	for i := range indices {
+		indices[i] = i
+	}
+	pubKeys, _, err := parseTestKeys(vectors.PubKeys, indices)
+	if err != nil {
+		t.Fatalf("unable to parse keys: %v", err)
+	}
+	for i, pubKey := range KeySort(pubKeys) {
+		got := toHex(pubKey.SerializeCompressed())
+		if got != vectors.SortedPubKeys[i] {
+			t.Errorf("key #%d: got %s, want %s", i, got,
+				vectors.SortedPubKeys[i])
+		}
+	}
+}
+
+// TestAggregateKeys ensures keys are aggregated as specified by the BIP0327
+// test vectors.
+func TestAggregateKeys(t *testing.T) {
+	t.Parallel()
+
+	var vectors struct {
+		PubKeys    []string `json:"pubkeys"`
+		Tweaks     []string `json:"tweaks"`
+		ValidCases []struct {
+			KeyIndices []int  `json:"key_indices"`
+			Expected   string `json:"expected"`
+		} `json:"valid_test_cases"`
+		ErrorCases []struct {
+			KeyIndices   []int           `json:"key_indices"`
+			TweakIndices []int           `json:"tweak_indices"`
+			IsXOnly      []bool          `json:"is_xonly"`
+			Error        testVectorError `json:"error"`
+			Comment      string          `json:"comment"`
+		} `json:"error_test_cases"`
+	}
+	loadTestVectors(t, "key_agg_vectors.json", &vectors)
+
+	for i, test := range vectors.ValidCases {
+		pubKeys, _, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to parse keys: %v", i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate keys: %v", i,
+				err)
+		}
+		if got := toHex(ctx.XOnlyPubKey()); got != test.Expected {
+			t.Errorf("valid case #%d: got key %s, want %s", i, got,
+				test.Expected)
+		}
+	}
+
+	for i, test := range vectors.ErrorCases {
+		pubKeys, badKey, err := parseTestKeys(vectors.PubKeys,
+			test.KeyIndices)
+		if test.Error.Contrib == "pubkey" {
+			if err == nil || badKey != *test.Error.Signer {
+				t.Errorf("error case #%d (%s): got invalid key %d "+
+					"(%v)", i, test.Comment, badKey, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("error case #%d: unable to parse keys: %v", i,
+				err)
+		}
+
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("error case #%d: unable to aggregate keys: %v",
+				i, err)
+		}
+		_, err = applyTestTweaks(ctx, vectors.Tweaks, test.TweakIndices,
+			test.IsXOnly)
+		if err == nil || !strings.EqualFold(err.Error()+".",
+			test.Error.Message) {
+
+			t.Errorf("error case #%d (%s): got error %v, want %s", i,
+				test.Comment, err, test.Error.Message)
+		}
+	}
+
+	if _, err := AggregateKeys(nil); err != ErrNoKeys {
+		t.Errorf("got error %v aggregating no keys, want %v", err,
+			ErrNoKeys)
+	}
+}
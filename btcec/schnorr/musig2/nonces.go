@@ -0,0 +1,238 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package musig2
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// PubNonceSize is the size of a public nonce, which is the compressed
+	// encoding of two points.
+	PubNonceSize = 66
+
+	// SecNonceSize is the size of a secret nonce, which is the two 32-byte
+	// scalars of the public nonce followed by the compressed public key of
+	// the signer it belongs to.
+	SecNonceSize = 97
+)
+
+var (
+	// nonceAuxTag is the tag of the hash of the randomness which is mixed
+	// into the secret key when generating nonces.
+	nonceAuxTag = []byte("MuSig/aux")
+
+	// nonceTag is the tag of the hash the secret nonces are derived from.
+	nonceTag = []byte("MuSig/nonce")
+)
+
+var (
+	// ErrNonceIsZero is returned when a generated secret nonce is zero,
+	// which happens with negligible probability.
+	ErrNonceIsZero = errors.New("generated secret nonce is zero")
+)
+
+// InvalidContributionError is returned when a value contributed by one of the
+// signers, such as a public nonce or a partial signature, is invalid.  It
+// identifies the signer so it can be blamed.
+type InvalidContributionError struct {
+	// Signer is the index of the signer which contributed the value, or
+	// -1 when the value was combined from the contributions of all
+	// signers, as is the case for the aggregate nonce.
+	Signer int
+
+	// Contribution names the invalid value.
+	Contribution string
+
+	// Err is the reason the value is invalid.
+	Err error
+}
+
+// Error returns a human-readable description of the invalid contribution.
+func (e InvalidContributionError) Error() string {
+	if e.Signer < 0 {
+		return fmt.Sprintf("invalid %s: %v", e.Contribution, e.Err)
+	}
+	return fmt.Sprintf("invalid %s of signer %d: %v", e.Contribution,
+		e.Signer, e.Err)
+}
+
+// Nonces houses the nonces of a signer for one signing session.  The public
+// nonce is shared with the other signers while the secret nonce is kept
+// private and used exactly once.
+type Nonces struct {
+	PubNonce [PubNonceSize]byte
+	SecNonce [SecNonceSize]byte
+}
+
+// NonceGenOption is a functional option which provides optional inputs to
+// GenNonces.  Each of them adds defense in depth against bad randomness.
+type NonceGenOption func(*nonceGenOptions)
+
+// nonceGenOptions houses the optional inputs of nonce generation.
+type nonceGenOptions struct {
+	randomness *[32]byte
+	secretKey  []byte
+	aggPubKey  []byte
+	msg        []byte
+	msgSet     bool
+	extraIn    []byte
+}
+
+// WithSecretKey returns a NonceGenOption which mixes the secret key of the
+// signer into the nonces.
+func WithSecretKey(privKey *btcec.PrivateKey) NonceGenOption {
+	return func(o *nonceGenOptions) {
+		var sk [32]byte
+		privKey.D.FillBytes(sk[:])
+		o.secretKey = sk[:]
+	}
+}
+
+// WithAggregateKey returns a NonceGenOption which commits the nonces to the
+// passed x-only aggregate public key.
+func WithAggregateKey(aggPubKey []byte) NonceGenOption {
+	return func(o *nonceGenOptions) {
+		o.aggPubKey = aggPubKey
+	}
+}
+
+// WithMessage returns a NonceGenOption which commits the nonces to the message
+// that will be signed.
+func WithMessage(msg []byte) NonceGenOption {
+	return func(o *nonceGenOptions) {
+		o.msg = msg
+		o.msgSet = true
+	}
+}
+
+// WithExtraInput returns a NonceGenOption which commits the nonces to
+// arbitrary extra data, such as a session identifier or a counter.
+func WithExtraInput(extraIn []byte) NonceGenOption {
+	return func(o *nonceGenOptions) {
+		o.extraIn = extraIn
+	}
+}
+
+// WithRandomness returns a NonceGenOption which replaces the fresh randomness
+// the nonces are derived from with the passed value.  It is only meant for
+// tests since nonces derived from predictable values leak the secret key.
+func WithRandomness(randomness [32]byte) NonceGenOption {
+	return func(o *nonceGenOptions) {
+		o.randomness = &randomness
+	}
+}
+
+// nonceHash returns the i-th secret nonce scalar derived from the passed
+// inputs as specified by BIP0327.
+func nonceHash(randBytes, pubKey []byte, opts *nonceGenOptions, i byte) *big.Int {
+	var lenBuf [8]byte
+	msgPrefixed := []byte{0x00}
+	if opts.msgSet {
+		binary.BigEndian.PutUint64(lenBuf[:], uint64(len(opts.msg)))
+		msgPrefixed = append([]byte{0x01}, lenBuf[:]...)
+		msgPrefixed = append(msgPrefixed, opts.msg...)
+	}
+	var extraLen [4]byte
+	binary.BigEndian.PutUint32(extraLen[:], uint32(len(opts.extraIn)))
+
+	h := chainhash.TaggedHash(nonceTag, randBytes,
+		[]byte{byte(len(pubKey))}, pubKey,
+		[]byte{byte(len(opts.aggPubKey))}, opts.aggPubKey,
+		msgPrefixed, extraLen[:], opts.extraIn, []byte{i})
+	k := new(big.Int).SetBytes(h[:])
+	return k.Mod(k, btcec.S256().N)
+}
+
+// GenNonces generates the nonces of the signer with the passed public key for
+// a new signing session.  The nonces are derived from fresh randomness along
+// with the optional inputs.
+func GenNonces(pubKey *btcec.PublicKey,
+	options ...NonceGenOption) (*Nonces, error) {
+
+	opts := &nonceGenOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	var randBytes [32]byte
+	if opts.randomness != nil {
+		randBytes = *opts.randomness
+	} else if _, err := rand.Read(randBytes[:]); err != nil {
+		return nil, err
+	}
+
+	// rand = bytes(sk) xor hash_MuSig/aux(rand') when the secret key is
+	// given.
+	if opts.secretKey != nil {
+		auxHash := chainhash.TaggedHash(nonceAuxTag, randBytes[:])
+		for i := range randBytes {
+			randBytes[i] = opts.secretKey[i] ^ auxHash[i]
+		}
+	}
+
+	pubKeyBytes := pubKey.SerializeCompressed()
+	k1 := nonceHash(randBytes[:], pubKeyBytes, opts, 0)
+	k2 := nonceHash(randBytes[:], pubKeyBytes, opts, 1)
+	if k1.Sign() == 0 || k2.Sign() == 0 {
+		return nil, ErrNonceIsZero
+	}
+
+	var nonces Nonces
+	k1.FillBytes(nonces.SecNonce[:32])
+	k2.FillBytes(nonces.SecNonce[32:64])
+	copy(nonces.SecNonce[64:], pubKeyBytes)
+	copy(nonces.PubNonce[:33], baseMul(k1).cbytes())
+	copy(nonces.PubNonce[33:], baseMul(k2).cbytes())
+
+	return &nonces, nil
+}
+
+// parsePubNonce parses the two points of a public nonce.
+func parsePubNonce(pubNonce [PubNonceSize]byte) (point, point, error) {
+	r1, err := parseCompressedPoint(pubNonce[:33])
+	if err != nil {
+		return point{}, point{}, err
+	}
+	r2, err := parseCompressedPoint(pubNonce[33:])
+	if err != nil {
+		return point{}, point{}, err
+	}
+	return r1, r2, nil
+}
+
+// AggregateNonces combines the public nonces of all of the signers into the
+// aggregate nonce every signer needs to create its partial signature.  An
+// InvalidContributionError identifying the signer is returned for invalid
+// public nonces.
+func AggregateNonces(
+	pubNonces [][PubNonceSize]byte) ([PubNonceSize]byte, error) {
+
+	var aggNonce [PubNonceSize]byte
+	r1, r2 := infinity(), infinity()
+	for i, pubNonce := range pubNonces {
+		nonceR1, nonceR2, err := parsePubNonce(pubNonce)
+		if err != nil {
+			return aggNonce, InvalidContributionError{
+				Signer:       i,
+				Contribution: "pubnonce",
+				Err:          err,
+			}
+		}
+		r1 = r1.add(nonceR1)
+		r2 = r2.add(nonceR2)
+	}
+
+	copy(aggNonce[:33], r1.cbytesExt())
+	copy(aggNonce[33:], r2.cbytesExt())
+	return aggNonce, nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package musig2
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// toPubNonce returns the passed hex encoded public nonce as an array, and
+// whether or not it has the size of a public nonce.
+func toPubNonce(hexStr string) ([PubNonceSize]byte, bool) {
+	var pubNonce [PubNonceSize]byte
+	b := decodeHex(hexStr)
+	copy(pubNonce[:], b)
+	return pubNonce, len(b) == PubNonceSize
+}
+
+// TestGenNonces ensures nonces are generated as specified by the BIP0327 test
+// vectors.
+func TestGenNonces(t *testing.T) {
+	t.Parallel()
+
+	var vectors struct {
+		TestCases []struct {
+			Rand     string  `json:"rand_"`
+			SecKey   *string `json:"sk"`
+			PubKey   string  `json:"pk"`
+			AggPK    *string `json:"aggpk"`
+			Msg      *string `json:"msg"`
+			ExtraIn  *string `json:"extra_in"`
+			Expected string  `json:"expected"`
+		} `json:"test_cases"`
+	}
+	loadTestVectors(t, "nonce_gen_vectors.json", &vectors)
+
+	for i, test := range vectors.TestCases {
+		pubKey, err := parseCompressedPoint(decodeHex(test.PubKey))
+		if err != nil {
+			t.Fatalf("test #%d: unable to parse key: %v", i, err)
+		}
+
+		var randomness [32]byte
+		copy(randomness[:], decodeHex(test.Rand))
+		opts := []NonceGenOption{WithRandomness(randomness)}
+		if test.SecKey != nil {
+			privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(),
+				decodeHex(*test.SecKey))
+			opts = append(opts, WithSecretKey(privKey))
+		}
+		if test.AggPK != nil {
+			opts = append(opts, WithAggregateKey(decodeHex(*test.AggPK)))
+		}
+		if test.Msg != nil {
+			opts = append(opts, WithMessage(decodeHex(*test.Msg)))
+		}
+		if test.ExtraIn != nil {
+			opts = append(opts, WithExtraInput(decodeHex(*test.ExtraIn)))
+		}
+
+		nonces, err := GenNonces(pubKey.pubKey(), opts...)
+		if err != nil {
+			t.Fatalf("test #%d: unable to generate nonces: %v", i, err)
+		}
+		if got := toHex(nonces.SecNonce[:]); got != test.Expected {
+			t.Errorf("test #%d: got secret nonce %s, want %s", i, got,
+				test.Expected)
+		}
+	}
+
+	// Nonces from fresh randomness must differ.
+	privKey, _ := btcec.NewPrivateKey(btcec.S256())
+	nonces1, err := GenNonces(privKey.PubKey())
+	if err != nil {
+		t.Fatalf("unable to generate nonces: %v", err)
+	}
+	nonces2, err := GenNonces(privKey.PubKey())
+	if err != nil {
+		t.Fatalf("unable to generate nonces: %v", err)
+	}
+	if nonces1.SecNonce == nonces2.SecNonce {
+		t.Fatalf("generated the same nonces twice")
+	}
+}
+
+// TestAggregateNonces ensures nonces are aggregated as specified by the
+// BIP0327 test vectors.
+func TestAggregateNonces(t *testing.T) {
+	t.Parallel()
+
+	var vectors struct {
+		PubNonces  []string `json:"pnonces"`
+		ValidCases []struct {
+			Indices  []int  `json:"pnonce_indices"`
+			Expected string `json:"expected"`
+		} `json:"valid_test_cases"`
+		ErrorCases []struct {
+			Indices []int           `json:"pnonce_indices"`
+			Error   testVectorError `json:"error"`
+			Comment string          `json:"comment"`
+		} `json:"error_test_cases"`
+	}
+	loadTestVectors(t, "nonce_agg_vectors.json", &vectors)
+
+	toPubNonces := func(indices []int) [][PubNonceSize]byte {
+		pubNonces := make([][PubNonceSize]byte, len(indices))
+		for i, idx := range indices {
+			pubNonces[i], _ = toPubNonce(vectors.PubNonces[idx])
+		}
+		return pubNonces
+	}
+
+	for i, test := range vectors.ValidCases {
+		aggNonce, err := AggregateNonces(toPubNonces(test.Indices))
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate nonces: %v",
+				i, err)
+		}
+		if got := toHex(aggNonce[:]); got != test.Expected {
+			t.Errorf("valid case #%d: got %s, want %s", i, got,
+				test.Expected)
+		}
+	}
+
+	for i, test := range vectors.ErrorCases {
+		_, err := AggregateNonces(toPubNonces(test.Indices))
+		contribErr, ok := err.(InvalidContributionError)
+		if !ok || contribErr.Signer != *test.Error.Signer ||
+			contribErr.Contribution != test.Error.Contrib {
+
+			t.Errorf("error case #%d (%s): got error %v", i,
+				test.Comment, err)
+		}
+	}
+}
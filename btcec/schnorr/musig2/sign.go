@@ -0,0 +1,284 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package musig2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// PartialSignatureSize is the size of an encoded partial signature.
+const PartialSignatureSize = 32
+
+var (
+	// nonceCoeffTag is the tag of the hash the nonce coefficient of a
+	// signing session is derived from.
+	nonceCoeffTag = []byte("MuSig/noncecoef")
+
+	// challengeTag is the BIP0340 tag of the hash committing to the
+	// nonce, the public key and the message of a signature.
+	challengeTag = []byte("BIP0340/challenge")
+)
+
+var (
+	// ErrSecNonceInvalid is returned when a secret nonce holds an out of
+	// range value, which is the case for nonces that were already used.
+	ErrSecNonceInvalid = errors.New("secret nonce value is out of range")
+
+	// ErrSecNonceKeyMismatch is returned when signing with a secret nonce
+	// that was generated for a different key.
+	ErrSecNonceKeyMismatch = errors.New("secret nonce was generated for a " +
+		"different public key")
+
+	// ErrPrivKeyInvalid is returned when signing with a private key which
+	// is zero or not less than the group order.
+	ErrPrivKeyInvalid = errors.New("private key is zero or >= group order")
+
+	// ErrSignerNotInKeys is returned when signing with a key which isn't
+	// one of the aggregated keys.
+	ErrSignerNotInKeys = errors.New("the signer's pubkey must be included " +
+		"in the list of pubkeys")
+
+	// ErrPartialSigTooBig is returned when parsing a partial signature
+	// which isn't less than the group order.
+	ErrPartialSigTooBig = errors.New("partial signature is >= group order")
+
+	// ErrPartialSigInvalid is returned when a created partial signature
+	// fails verification.
+	ErrPartialSigInvalid = errors.New("created partial signature is " +
+		"invalid")
+)
+
+// PartialSignature is the partial signature of one of the signers of a MuSig2
+// signing session.
+type PartialSignature struct {
+	s *big.Int
+}
+
+// ParsePartialSignature parses a 32-byte partial signature.
+func ParsePartialSignature(b []byte) (*PartialSignature, error) {
+	if len(b) != PartialSignatureSize {
+		return nil, fmt.Errorf("malformed partial signature: wrong size: "+
+			"%d != %d", len(b), PartialSignatureSize)
+	}
+	s := new(big.Int).SetBytes(b)
+	if s.Cmp(btcec.S256().N) >= 0 {
+		return nil, ErrPartialSigTooBig
+	}
+	return &PartialSignature{s: s}, nil
+}
+
+// Serialize returns the 32-byte encoding of the partial signature.
+func (p *PartialSignature) Serialize() []byte {
+	var b [PartialSignatureSize]byte
+	p.s.FillBytes(b[:])
+	return b[:]
+}
+
+// sessionValues houses the values derived from the aggregate nonce, the key
+// aggregation context and the message of a signing session.
+type sessionValues struct {
+	ctx *KeyAggContext
+	b   *big.Int
+	r   point
+	e   *big.Int
+}
+
+// newSessionValues derives the values of the signing session with the passed
+// aggregate nonce, key aggregation context and message.
+func newSessionValues(aggNonce [PubNonceSize]byte, ctx *KeyAggContext,
+	msg []byte) (*sessionValues, error) {
+
+	curve := btcec.S256()
+	qBytes := ctx.q.xbytes()
+
+	// b = int(hash_MuSig/noncecoef(aggnonce || xbytes(Q) || m)) mod n.
+	bHash := chainhash.TaggedHash(nonceCoeffTag, aggNonce[:], qBytes, msg)
+	b := new(big.Int).SetBytes(bHash[:])
+	b.Mod(b, curve.N)
+
+	// R = R1 + b*R2, or G when that is the point at infinity.
+	r1, err := parseCompressedPointExt(aggNonce[:33])
+	if err == nil {
+		var r2 point
+		r2, err = parseCompressedPointExt(aggNonce[33:])
+		if err == nil {
+			r1 = r1.add(r2.mul(b))
+		}
+	}
+	if err != nil {
+		return nil, InvalidContributionError{
+			Signer:       -1,
+			Contribution: "aggnonce",
+			Err:          err,
+		}
+	}
+	r := r1
+	if r.isInfinity() {
+		r = point{x: curve.Gx, y: curve.Gy}
+	}
+
+	// e = int(hash_BIP0340/challenge(xbytes(R) || xbytes(Q) || m)) mod n.
+	eHash := chainhash.TaggedHash(challengeTag, r.xbytes(), qBytes, msg)
+	e := new(big.Int).SetBytes(eHash[:])
+	e.Mod(e, curve.N)
+
+	return &sessionValues{ctx: ctx, b: b, r: r, e: e}, nil
+}
+
+// keyParity returns 1 when the aggregate key has an even y coordinate and -1
+// mod n otherwise.
+func (v *sessionValues) keyParity() *big.Int {
+	if v.ctx.q.hasEvenY() {
+		return big.NewInt(1)
+	}
+	return new(big.Int).Sub(btcec.S256().N, big.NewInt(1))
+}
+
+// Sign creates the partial signature of the signer with the passed private
+// key for the signing session with the passed aggregate nonce, key
+// aggregation context and message.  The secret nonce is zeroed so it can't be
+// used again, and the created partial signature is verified before it is
+// returned.
+func Sign(secNonce *[SecNonceSize]byte, privKey *btcec.PrivateKey,
+	aggNonce [PubNonceSize]byte, ctx *KeyAggContext,
+	msg []byte) (*PartialSignature, error) {
+
+	curve := btcec.S256()
+	session, err := newSessionValues(aggNonce, ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read and then zero the secret nonce so a failed attempt can't be
+	// followed by one with different inputs.
+	k1 := new(big.Int).SetBytes(secNonce[:32])
+	k2 := new(big.Int).SetBytes(secNonce[32:64])
+	nonceKey := make([]byte, btcec.PubKeyBytesLenCompressed)
+	copy(nonceKey, secNonce[64:])
+	for i := 0; i < 64; i++ {
+		secNonce[i] = 0
+	}
+	if k1.Sign() == 0 || k1.Cmp(curve.N) >= 0 ||
+		k2.Sign() == 0 || k2.Cmp(curve.N) >= 0 {
+
+		return nil, ErrSecNonceInvalid
+	}
+	var pubNonce [PubNonceSize]byte
+	copy(pubNonce[:33], baseMul(k1).cbytes())
+	copy(pubNonce[33:], baseMul(k2).cbytes())
+
+	// Negate the nonces when R has an odd y coordinate.
+	if !session.r.hasEvenY() {
+		k1.Sub(curve.N, k1)
+		k2.Sub(curve.N, k2)
+	}
+
+	d := new(big.Int).Set(privKey.D)
+	if d.Sign() == 0 || d.Cmp(curve.N) >= 0 {
+		return nil, ErrPrivKeyInvalid
+	}
+	pubKey := privKey.PubKey()
+	pubKeyBytes := pubKey.SerializeCompressed()
+	if !bytes.Equal(pubKeyBytes, nonceKey) {
+		return nil, ErrSecNonceKeyMismatch
+	}
+	if !ctx.hasKey(pubKeyBytes) {
+		return nil, ErrSignerNotInKeys
+	}
+
+	// d = g*gacc*d' mod n and s = k1 + b*k2 + e*a*d mod n.
+	a := ctx.keyAggCoeff(pubKeyBytes)
+	d.Mul(d, session.keyParity())
+	d.Mul(d, ctx.gacc)
+	s := d.Mul(d, a)
+	s.Mul(s, session.e)
+	s.Add(s, k1)
+	s.Add(s, k2.Mul(k2, session.b))
+	s.Mod(s, curve.N)
+
+	partialSig := &PartialSignature{s: s}
+	if !partialSig.verify(pubNonce, pubKey, session) {
+		return nil, ErrPartialSigInvalid
+	}
+	return partialSig, nil
+}
+
+// Verify returns whether or not the partial signature is valid for the signer
+// with the passed public nonce and public key in the signing session with the
+// passed aggregate nonce, key aggregation context and message.
+func (p *PartialSignature) Verify(pubNonce, aggNonce [PubNonceSize]byte,
+	pubKey *btcec.PublicKey, ctx *KeyAggContext, msg []byte) bool {
+
+	session, err := newSessionValues(aggNonce, ctx, msg)
+	if err != nil {
+		return false
+	}
+	return p.verify(pubNonce, pubKey, session)
+}
+
+// verify returns whether or not the partial signature is valid for the signer
+// with the passed public nonce and public key in the passed signing session.
+func (p *PartialSignature) verify(pubNonce [PubNonceSize]byte,
+	pubKey *btcec.PublicKey, session *sessionValues) bool {
+
+	curve := btcec.S256()
+	pubKeyBytes := pubKey.SerializeCompressed()
+	if !session.ctx.hasKey(pubKeyBytes) {
+		return false
+	}
+	r1, r2, err := parsePubNonce(pubNonce)
+	if err != nil {
+		return false
+	}
+
+	// Re = R1 + b*R2, negated when R has an odd y coordinate.
+	re := r1.add(r2.mul(session.b))
+	if !session.r.hasEvenY() {
+		re = re.negate()
+	}
+
+	// s*G = Re + e*a*g*gacc*P.
+	k := session.ctx.keyAggCoeff(pubKeyBytes)
+	k.Mul(k, session.e)
+	k.Mul(k, session.keyParity())
+	k.Mul(k, session.ctx.gacc)
+	k.Mod(k, curve.N)
+	want := re.add(pointFromPubKey(pubKey).mul(k))
+
+	return baseMul(p.s).equal(want)
+}
+
+// AggregatePartialSigs combines the partial signatures of all of the signers
+// of the signing session with the passed aggregate nonce, key aggregation
+// context and message into a BIP0340 signature for the aggregate key.  The
+// partial signatures should be verified first since an invalid one results
+// in an invalid signature.
+func AggregatePartialSigs(partialSigs []*PartialSignature,
+	aggNonce [PubNonceSize]byte, ctx *KeyAggContext,
+	msg []byte) (*schnorr.Signature, error) {
+
+	curve := btcec.S256()
+	session, err := newSessionValues(aggNonce, ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// s = s1 + ... + su + e*g*tacc mod n.
+	s := new(big.Int).Mul(session.e, session.keyParity())
+	s.Mul(s, ctx.tacc)
+	for _, partialSig := range partialSigs {
+		s.Add(s, partialSig.s)
+	}
+	s.Mod(s, curve.N)
+
+	return schnorr.NewSignature(session.r.x, s), nil
+}
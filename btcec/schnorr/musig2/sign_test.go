@@ -0,0 +1,582 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package musig2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// signVectors houses the BIP0327 signing and verification test vectors.
+type signVectors struct {
+	SecKey     string   `json:"sk"`
+	PubKeys    []string `json:"pubkeys"`
+	SecNonces  []string `json:"secnonces"`
+	PubNonces  []string `json:"pnonces"`
+	AggNonces  []string `json:"aggnonces"`
+	Msgs       []string `json:"msgs"`
+	ValidCases []struct {
+		KeyIndices    []int  `json:"key_indices"`
+		NonceIndices  []int  `json:"nonce_indices"`
+		AggNonceIndex int    `json:"aggnonce_index"`
+		MsgIndex      int    `json:"msg_index"`
+		SignerIndex   int    `json:"signer_index"`
+		Expected      string `json:"expected"`
+	} `json:"valid_test_cases"`
+	SignErrorCases []struct {
+		KeyIndices    []int           `json:"key_indices"`
+		AggNonceIndex int             `json:"aggnonce_index"`
+		MsgIndex      int             `json:"msg_index"`
+		SecNonceIndex int             `json:"secnonce_index"`
+		Error         testVectorError `json:"error"`
+		Comment       string          `json:"comment"`
+	} `json:"sign_error_test_cases"`
+	VerifyFailCases []struct {
+		Sig          string `json:"sig"`
+		KeyIndices   []int  `json:"key_indices"`
+		NonceIndices []int  `json:"nonce_indices"`
+		MsgIndex     int    `json:"msg_index"`
+		SignerIndex  int    `json:"signer_index"`
+		Comment      string `json:"comment"`
+	} `json:"verify_fail_test_cases"`
+	VerifyErrorCases []struct {
+		Sig          string          `json:"sig"`
+		KeyIndices   []int           `json:"key_indices"`
+		NonceIndices []int           `json:"nonce_indices"`
+		MsgIndex     int             `json:"msg_index"`
+		SignerIndex  int             `json:"signer_index"`
+		Error        testVectorError `json:"error"`
+		Comment      string          `json:"comment"`
+	} `json:"verify_error_test_cases"`
+}
+
+// toSecNonce returns the passed hex encoded secret nonce as an array.
+func toSecNonce(hexStr string) *[SecNonceSize]byte {
+	var secNonce [SecNonceSize]byte
+	copy(secNonce[:], decodeHex(hexStr))
+	return &secNonce
+}
+
+// verifyTestPartialSig verifies the passed partial signature of the signer
+// with the passed index as done by the BIP0327 PartialSigVerify algorithm,
+// which aggregates the public nonces of all of the signers itself.
+func verifyTestPartialSig(partialSig *PartialSignature,
+	pubNonces [][PubNonceSize]byte, pubKeys []*btcec.PublicKey,
+	ctx *KeyAggContext, msg []byte, signerIdx int) (bool, error) {
+
+	aggNonce, err := AggregateNonces(pubNonces)
+	if err != nil {
+		return false, err
+	}
+	return partialSig.Verify(pubNonces[signerIdx], aggNonce,
+		pubKeys[signerIdx], ctx, msg), nil
+}
+
+// TestSign ensures partial signatures are created and verified as specified
+// by the BIP0327 test vectors.
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	var vectors signVectors
+	loadTestVectors(t, "sign_verify_vectors.json", &vectors)
+
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(),
+		decodeHex(vectors.SecKey))
+	toPubNonces := func(indices []int) ([][PubNonceSize]byte, int) {
+		pubNonces := make([][PubNonceSize]byte, len(indices))
+		for i, idx := range indices {
+			var ok bool
+			pubNonces[i], ok = toPubNonce(vectors.PubNonces[idx])
+			if !ok {
+				return nil, i
+			}
+		}
+		return pubNonces, -1
+	}
+
+	for i, test := range vectors.ValidCases {
+		pubKeys, _, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to parse keys: %v", i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate keys: %v", i,
+				err)
+		}
+		aggNonce, _ := toPubNonce(vectors.AggNonces[test.AggNonceIndex])
+		msg := decodeHex(vectors.Msgs[test.MsgIndex])
+
+		secNonce := toSecNonce(vectors.SecNonces[0])
+		partialSig, err := Sign(secNonce, privKey, aggNonce, ctx, msg)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to sign: %v", i, err)
+		}
+		if got := toHex(partialSig.Serialize()); got != test.Expected {
+			t.Errorf("valid case #%d: got %s, want %s", i, got,
+				test.Expected)
+		}
+
+		// The secret nonce must not be usable twice.
+		_, err = Sign(secNonce, privKey, aggNonce, ctx, msg)
+		if err != ErrSecNonceInvalid {
+			t.Errorf("valid case #%d: got error %v reusing secret "+
+				"nonce, want %v", i, err, ErrSecNonceInvalid)
+		}
+
+		pubNonces, _ := toPubNonces(test.NonceIndices)
+		valid, err := verifyTestPartialSig(partialSig, pubNonces,
+			pubKeys, ctx, msg, test.SignerIndex)
+		if err != nil || !valid {
+			t.Errorf("valid case #%d: partial signature does not "+
+				"verify: %v", i, err)
+		}
+	}
+
+	for i, test := range vectors.SignErrorCases {
+		pubKeys, badKey, err := parseTestKeys(vectors.PubKeys,
+			test.KeyIndices)
+		if test.Error.Contrib == "pubkey" {
+			if err == nil || badKey != *test.Error.Signer {
+				t.Errorf("sign error case #%d (%s): got invalid "+
+					"key %d (%v)", i, test.Comment, badKey, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("sign error case #%d: unable to parse keys: %v",
+				i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("sign error case #%d: unable to aggregate keys: "+
+				"%v", i, err)
+		}
+		aggNonce, _ := toPubNonce(vectors.AggNonces[test.AggNonceIndex])
+		msg := decodeHex(vectors.Msgs[test.MsgIndex])
+		secNonce := toSecNonce(vectors.SecNonces[test.SecNonceIndex])
+
+		_, err = Sign(secNonce, privKey, aggNonce, ctx, msg)
+		switch {
+		case test.Error.Contrib == "aggnonce":
+			contribErr, ok := err.(InvalidContributionError)
+			if !ok || contribErr.Signer != -1 {
+				t.Errorf("sign error case #%d (%s): got error %v",
+					i, test.Comment, err)
+			}
+
+		case err == nil:
+			t.Errorf("sign error case #%d (%s): signed", i,
+				test.Comment)
+		}
+	}
+
+	for i, test := range vectors.VerifyFailCases {
+		pubKeys, _, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		if err != nil {
+			t.Fatalf("verify fail case #%d: unable to parse keys: %v",
+				i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("verify fail case #%d: unable to aggregate keys: "+
+				"%v", i, err)
+		}
+		partialSig, err := ParsePartialSignature(decodeHex(test.Sig))
+		if err != nil {
+			continue
+		}
+		pubNonces, _ := toPubNonces(test.NonceIndices)
+		msg := decodeHex(vectors.Msgs[test.MsgIndex])
+		valid, err := verifyTestPartialSig(partialSig, pubNonces,
+			pubKeys, ctx, msg, test.SignerIndex)
+		if err != nil || valid {
+			t.Errorf("verify fail case #%d (%s): got valid %v, error "+
+				"%v", i, test.Comment, valid, err)
+		}
+	}
+
+	for i, test := range vectors.VerifyErrorCases {
+		_, badKey, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		_, badNonce := toPubNonces(test.NonceIndices)
+		switch test.Error.Contrib {
+		case "pubkey":
+			if err == nil || badKey != *test.Error.Signer {
+				t.Errorf("verify error case #%d (%s): got invalid "+
+					"key %d (%v)", i, test.Comment, badKey, err)
+			}
+
+		case "pubnonce":
+			if badNonce != *test.Error.Signer {
+				t.Errorf("verify error case #%d (%s): got invalid "+
+					"nonce %d", i, test.Comment, badNonce)
+			}
+		}
+	}
+}
+
+// TestSignTweaked ensures partial signatures for tweaked keys are created as
+// specified by the BIP0327 test vectors.
+func TestSignTweaked(t *testing.T) {
+	t.Parallel()
+
+	var vectors struct {
+		SecKey     string   `json:"sk"`
+		PubKeys    []string `json:"pubkeys"`
+		SecNonce   string   `json:"secnonce"`
+		PubNonces  []string `json:"pnonces"`
+		AggNonce   string   `json:"aggnonce"`
+		Tweaks     []string `json:"tweaks"`
+		Msg        string   `json:"msg"`
+		ValidCases []struct {
+			KeyIndices   []int  `json:"key_indices"`
+			NonceIndices []int  `json:"nonce_indices"`
+			TweakIndices []int  `json:"tweak_indices"`
+			IsXOnly      []bool `json:"is_xonly"`
+			SignerIndex  int    `json:"signer_index"`
+			Expected     string `json:"expected"`
+			Comment      string `json:"comment"`
+		} `json:"valid_test_cases"`
+		ErrorCases []struct {
+			KeyIndices   []int  `json:"key_indices"`
+			TweakIndices []int  `json:"tweak_indices"`
+			IsXOnly      []bool `json:"is_xonly"`
+			Comment      string `json:"comment"`
+		} `json:"error_test_cases"`
+	}
+	loadTestVectors(t, "tweak_vectors.json", &vectors)
+
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(),
+		decodeHex(vectors.SecKey))
+	aggNonce, _ := toPubNonce(vectors.AggNonce)
+	msg := decodeHex(vectors.Msg)
+
+	for i, test := range vectors.ValidCases {
+		pubKeys, _, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to parse keys: %v", i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate keys: %v", i,
+				err)
+		}
+		ctx, err = applyTestTweaks(ctx, vectors.Tweaks, test.TweakIndices,
+			test.IsXOnly)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to tweak key: %v", i, err)
+		}
+
+		partialSig, err := Sign(toSecNonce(vectors.SecNonce), privKey,
+			aggNonce, ctx, msg)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to sign: %v", i, err)
+		}
+		if got := toHex(partialSig.Serialize()); got != test.Expected {
+			t.Errorf("valid case #%d (%s): got %s, want %s", i,
+				test.Comment, got, test.Expected)
+		}
+
+		pubNonces := make([][PubNonceSize]byte, len(test.NonceIndices))
+		for j, idx := range test.NonceIndices {
+			pubNonces[j], _ = toPubNonce(vectors.PubNonces[idx])
+		}
+		valid, err := verifyTestPartialSig(partialSig, pubNonces,
+			pubKeys, ctx, msg, test.SignerIndex)
+		if err != nil || !valid {
+			t.Errorf("valid case #%d: partial signature does not "+
+				"verify: %v", i, err)
+		}
+	}
+
+	for i, test := range vectors.ErrorCases {
+		pubKeys, _, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		if err != nil {
+			t.Fatalf("error case #%d: unable to parse keys: %v", i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("error case #%d: unable to aggregate keys: %v", i,
+				err)
+		}
+		_, err = applyTestTweaks(ctx, vectors.Tweaks, test.TweakIndices,
+			test.IsXOnly)
+		if err != ErrTweakTooBig {
+			t.Errorf("error case #%d (%s): got error %v, want %v", i,
+				test.Comment, err, ErrTweakTooBig)
+		}
+	}
+}
+
+// TestAggregatePartialSigs ensures partial signatures are aggregated as
+// specified by the BIP0327 test vectors and that the results are valid
+// BIP0340 signatures for the aggregate key.
+func TestAggregatePartialSigs(t *testing.T) {
+	t.Parallel()
+
+	var vectors struct {
+		PubKeys    []string `json:"pubkeys"`
+		PubNonces  []string `json:"pnonces"`
+		Tweaks     []string `json:"tweaks"`
+		PSigs      []string `json:"psigs"`
+		Msg        string   `json:"msg"`
+		ValidCases []struct {
+			AggNonce     string `json:"aggnonce"`
+			NonceIndices []int  `json:"nonce_indices"`
+			KeyIndices   []int  `json:"key_indices"`
+			TweakIndices []int  `json:"tweak_indices"`
+			IsXOnly      []bool `json:"is_xonly"`
+			PSigIndices  []int  `json:"psig_indices"`
+			Expected     string `json:"expected"`
+		} `json:"valid_test_cases"`
+		ErrorCases []struct {
+			PSigIndices []int           `json:"psig_indices"`
+			Error       testVectorError `json:"error"`
+			Comment     string          `json:"comment"`
+		} `json:"error_test_cases"`
+	}
+	loadTestVectors(t, "sig_agg_vectors.json", &vectors)
+
+	msg := decodeHex(vectors.Msg)
+	for i, test := range vectors.ValidCases {
+		pubKeys, _, err := parseTestKeys(vectors.PubKeys, test.KeyIndices)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to parse keys: %v", i, err)
+		}
+		ctx, err := AggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate keys: %v", i,
+				err)
+		}
+		ctx, err = applyTestTweaks(ctx, vectors.Tweaks, test.TweakIndices,
+			test.IsXOnly)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to tweak key: %v", i, err)
+		}
+
+		pubNonces := make([][PubNonceSize]byte, len(test.NonceIndices))
+		for j, idx := range test.NonceIndices {
+			pubNonces[j], _ = toPubNonce(vectors.PubNonces[idx])
+		}
+		aggNonce, err := AggregateNonces(pubNonces)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate nonces: %v",
+				i, err)
+		}
+		if got := toHex(aggNonce[:]); got != test.AggNonce {
+			t.Errorf("valid case #%d: got aggregate nonce %s, want %s",
+				i, got, test.AggNonce)
+		}
+
+		partialSigs := make([]*PartialSignature, len(test.PSigIndices))
+		for j, idx := range test.PSigIndices {
+			partialSigs[j], err = ParsePartialSignature(
+				decodeHex(vectors.PSigs[idx]))
+			if err != nil {
+				t.Fatalf("valid case #%d: unable to parse partial "+
+					"signature: %v", i, err)
+			}
+		}
+		sig, err := AggregatePartialSigs(partialSigs, aggNonce, ctx, msg)
+		if err != nil {
+			t.Fatalf("valid case #%d: unable to aggregate: %v", i, err)
+		}
+		if got := toHex(sig.Serialize()); got != test.Expected {
+			t.Errorf("valid case #%d: got %s, want %s", i, got,
+				test.Expected)
+		}
+		if !sig.Verify(msg, ctx.PubKey()) {
+			t.Errorf("valid case #%d: signature does not verify", i)
+		}
+	}
+
+	for i, test := range vectors.ErrorCases {
+		badSig := -1
+		for j, idx := range test.PSigIndices {
+			_, err := ParsePartialSignature(decodeHex(vectors.PSigs[idx]))
+			if err != nil {
+				badSig = j
+				break
+			}
+		}
+		if badSig != *test.Error.Signer {
+			t.Errorf("error case #%d (%s): got invalid partial "+
+				"signature %d", i, test.Comment, badSig)
+		}
+	}
+}
+
+// TestSigningSession ensures a full signing session among several signers of
+// a tweaked key results in a valid signature.
+func TestSigningSession(t *testing.T) {
+	t.Parallel()
+
+	const numSigners = 4
+	privKeys := make([]*btcec.PrivateKey, numSigners)
+	pubKeys := make([]*btcec.PublicKey, numSigners)
+	for i := range privKeys {
+		privKey, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("unable to create key: %v", err)
+		}
+		privKeys[i], pubKeys[i] = privKey, privKey.PubKey()
+	}
+	ctx, err := AggregateKeys(KeySort(pubKeys))
+	if err != nil {
+		t.Fatalf("unable to aggregate keys: %v", err)
+	}
+	ctx, err = ctx.Tweak([32]byte{0x01, 0x02, 0x03}, true)
+	if err != nil {
+		t.Fatalf("unable to tweak key: %v", err)
+	}
+	msg := make([]byte, 32)
+	msg[0] = 0xaa
+
+	nonces := make([]*Nonces, numSigners)
+	pubNonces := make([][PubNonceSize]byte, numSigners)
+	for i := range privKeys {
+		nonces[i], err = GenNonces(pubKeys[i], WithSecretKey(privKeys[i]),
+			WithAggregateKey(ctx.XOnlyPubKey()), WithMessage(msg))
+		if err != nil {
+			t.Fatalf("unable to generate nonces: %v", err)
+		}
+		pubNonces[i] = nonces[i].PubNonce
+	}
+	aggNonce, err := AggregateNonces(pubNonces)
+	if err != nil {
+		t.Fatalf("unable to aggregate nonces: %v", err)
+	}
+
+	partialSigs := make([]*PartialSignature, numSigners)
+	for i := range privKeys {
+		partialSigs[i], err = Sign(&nonces[i].SecNonce, privKeys[i],
+			aggNonce, ctx, msg)
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		if !partialSigs[i].Verify(pubNonces[i], aggNonce, pubKeys[i],
+			ctx, msg) {
+
+			t.Fatalf("partial signature #%d does not verify", i)
+		}
+	}
+
+	// A partial signature must not verify for another signer.
+	if partialSigs[0].Verify(pubNonces[1], aggNonce, pubKeys[1], ctx, msg) {
+		t.Fatalf("partial signature verifies for the wrong signer")
+	}
+
+	sig, err := AggregatePartialSigs(partialSigs, aggNonce, ctx, msg)
+	if err != nil {
+		t.Fatalf("unable to aggregate partial signatures: %v", err)
+	}
+	pubKey, err := schnorr.ParsePubKey(ctx.XOnlyPubKey())
+	if err != nil {
+		t.Fatalf("unable to parse aggregate key: %v", err)
+	}
+	if !sig.Verify(msg, pubKey) {
+		t.Fatalf("aggregate signature does not verify")
+	}
+}
+
+// TestTaprootKeySpend ensures a signature created by a group of signers for
+// the taproot output key of their aggregate key is accepted by the script
+// engine.
+func TestTaprootKeySpend(t *testing.T) {
+	t.Parallel()
+
+	const numSigners = 3
+	privKeys := make([]*btcec.PrivateKey, numSigners)
+	pubKeys := make([]*btcec.PublicKey, numSigners)
+	for i := range privKeys {
+		privKey, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("unable to create key: %v", err)
+		}
+		privKeys[i], pubKeys[i] = privKey, privKey.PubKey()
+	}
+	internalCtx, err := AggregateKeys(KeySort(pubKeys))
+	if err != nil {
+		t.Fatalf("unable to aggregate keys: %v", err)
+	}
+	ctx, err := internalCtx.TaprootTweak(nil)
+	if err != nil {
+		t.Fatalf("unable to tweak key: %v", err)
+	}
+
+	// The tweaked key must be the output key txscript derives from the
+	// aggregate key.
+	outputKey, err := txscript.ComputeTaprootKeyNoScript(
+		internalCtx.PubKey())
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	if !bytes.Equal(ctx.XOnlyPubKey(), schnorr.SerializePubKey(outputKey)) {
+		t.Fatalf("tweaked key does not match taproot output key")
+	}
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+
+	const amt = 100000000
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	tx.AddTxOut(wire.NewTxOut(amt-1000, []byte{txscript.OP_TRUE}))
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(pkScript, amt)
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	msg, err := txscript.CalcTaprootSignatureHash(sigHashes,
+		txscript.SigHashDefault, tx, 0, prevOutFetcher)
+	if err != nil {
+		t.Fatalf("unable to calculate sighash: %v", err)
+	}
+
+	nonces := make([]*Nonces, numSigners)
+	pubNonces := make([][PubNonceSize]byte, numSigners)
+	for i := range privKeys {
+		nonces[i], err = GenNonces(pubKeys[i], WithSecretKey(privKeys[i]))
+		if err != nil {
+			t.Fatalf("unable to generate nonces: %v", err)
+		}
+		pubNonces[i] = nonces[i].PubNonce
+	}
+	aggNonce, err := AggregateNonces(pubNonces)
+	if err != nil {
+		t.Fatalf("unable to aggregate nonces: %v", err)
+	}
+	partialSigs := make([]*PartialSignature, numSigners)
+	for i := range privKeys {
+		partialSigs[i], err = Sign(&nonces[i].SecNonce, privKeys[i],
+			aggNonce, ctx, msg)
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+	}
+	sig, err := AggregatePartialSigs(partialSigs, aggNonce, ctx, msg)
+	if err != nil {
+		t.Fatalf("unable to aggregate partial signatures: %v", err)
+	}
+
+	tx.TxIn[0].Witness = wire.TxWitness{sig.Serialize()}
+	flags := txscript.ScriptBip16 | txscript.ScriptVerifyWitness |
+		txscript.ScriptVerifyTaproot
+	vm, err := txscript.NewEngine(pkScript, tx, 0, flags, nil, sigHashes,
+		amt, prevOutFetcher)
+	if err != nil {
+		t.Fatalf("unable to create engine: %v", err)
+	}
+	if err := vm.Execute(); err != nil {
+		t.Fatalf("taproot spend is invalid: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// PubKeyBytesLen is the length in bytes of a serialized BIP0340 x-only public
+// key.
+const PubKeyBytesLen = 32
+
+// ParsePubKey parses a BIP0340 x-only public key into a btcec.PublicKey.  The
+// returned key is the point with the passed x coordinate and an even y
+// coordinate, and an error is returned when no such point exists.
+func ParsePubKey(pubKeyStr []byte) (*btcec.PublicKey, error) {
+	if len(pubKeyStr) != PubKeyBytesLen {
+		return nil, fmt.Errorf("bad pubkey byte string size (want %v, "+
+			"have %v)", PubKeyBytesLen, len(pubKeyStr))
+	}
+
+	// Prepend the compressed even y byte so the existing pubkey parsing of
+	// btcec can be used to lift the x coordinate onto the curve.  Unlike
+	// btcec, BIP0340 rejects x coordinates which aren't field elements.
+	var keyCompressed [btcec.PubKeyBytesLenCompressed]byte
+	keyCompressed[0] = 0x02
+	copy(keyCompressed[1:], pubKeyStr)
+	pubKey, err := btcec.ParsePubKey(keyCompressed[:], btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	if pubKey.X.Cmp(btcec.S256().P) >= 0 {
+		return nil, fmt.Errorf("pubkey X parameter is >= to P")
+	}
+	return pubKey, nil
+}
+
+// SerializePubKey serializes a public key as the x-only format specified by
+// BIP0340.  The parity of the y coordinate of the key is dropped.
+func SerializePubKey(pub *btcec.PublicKey) []byte {
+	return pub.SerializeCompressed()[1:]
+}
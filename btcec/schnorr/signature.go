@@ -0,0 +1,287 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// SignatureSize is the size of an encoded BIP0340 Schnorr signature.
+const SignatureSize = 64
+
+var (
+	// ErrSigRTooBig is returned when the R value of a signature isn't a
+	// field element.
+	ErrSigRTooBig = errors.New("signature R value is >= field prime")
+
+	// ErrSigSTooBig is returned when the S value of a signature isn't
+	// less than the group order.
+	ErrSigSTooBig = errors.New("signature S value is >= group order")
+
+	// ErrSigRNotOnCurve is returned when the point calculated while
+	// verifying a signature is the point at infinity.
+	ErrSigRNotOnCurve = errors.New("calculated R point is the point at " +
+		"infinity")
+
+	// ErrSigRYIsOdd is returned when the point calculated while verifying a
+	// signature has an odd y coordinate.
+	ErrSigRYIsOdd = errors.New("calculated R y-value is odd")
+
+	// ErrUnequalRValues is returned when the x coordinate of the point
+	// calculated while verifying a signature doesn't match its R value.
+	ErrUnequalRValues = errors.New("calculated R point was not given R")
+
+	// ErrPrivateKeyIsZero is returned when signing with a private key
+	// which is zero or not less than the group order.
+	ErrPrivateKeyIsZero = errors.New("private key is zero or >= group " +
+		"order")
+
+	// ErrNonceIsZero is returned when the nonce derived while signing is
+	// zero, which happens with negligible probability.
+	ErrNonceIsZero = errors.New("calculated nonce is zero")
+)
+
+var (
+	// challengeTag is the BIP0340 tag of the hash committing to the
+	// nonce, the public key and the message of a signature.
+	challengeTag = []byte("BIP0340/challenge")
+
+	// auxTag is the BIP0340 tag of the hash of the auxiliary randomness
+	// which is mixed into the private key when deriving a nonce.
+	auxTag = []byte("BIP0340/aux")
+
+	// nonceTag is the BIP0340 tag of the hash the nonce is derived from.
+	nonceTag = []byte("BIP0340/nonce")
+)
+
+// Signature is a type representing a BIP0340 Schnorr signature.
+type Signature struct {
+	r *big.Int
+	s *big.Int
+}
+
+// NewSignature instantiates a new signature given the x coordinate of the
+// nonce point r and the s value.
+func NewSignature(r, s *big.Int) *Signature {
+	return &Signature{
+		r: new(big.Int).Set(r),
+		s: new(big.Int).Set(s),
+	}
+}
+
+// Serialize returns the 64-byte encoding of the signature as specified by
+// BIP0340, which is the 32-byte big-endian x coordinate of the nonce point
+// followed by the 32-byte big-endian s value.
+func (sig *Signature) Serialize() []byte {
+	var b [SignatureSize]byte
+	sig.r.FillBytes(b[:32])
+	sig.s.FillBytes(b[32:])
+	return b[:]
+}
+
+// IsEqual compares this Signature instance to the one passed, returning true
+// if both Signatures are equivalent.
+func (sig *Signature) IsEqual(otherSig *Signature) bool {
+	return sig.r.Cmp(otherSig.r) == 0 && sig.s.Cmp(otherSig.s) == 0
+}
+
+// ParseSignature parses a 64-byte BIP0340 Schnorr signature.  An error is
+// returned when the R value isn't a field element or the S value isn't less
+// than the group order.
+func ParseSignature(sig []byte) (*Signature, error) {
+	if len(sig) != SignatureSize {
+		return nil, fmt.Errorf("malformed signature: wrong size: %d "+
+			"!= %d", len(sig), SignatureSize)
+	}
+
+	curve := btcec.S256()
+	r := new(big.Int).SetBytes(sig[:32])
+	if r.Cmp(curve.P) >= 0 {
+		return nil, ErrSigRTooBig
+	}
+	s := new(big.Int).SetBytes(sig[32:])
+	if s.Cmp(curve.N) >= 0 {
+		return nil, ErrSigSTooBig
+	}
+
+	return &Signature{r: r, s: s}, nil
+}
+
+// challenge returns the BIP0340 challenge e = int(hash(r || P || m)) mod n for
+// the passed nonce x coordinate, x-only public key and message.
+func challenge(r []byte, pubKey []byte, hash []byte) *big.Int {
+	commitment := chainhash.TaggedHash(challengeTag, r, pubKey, hash)
+	e := new(big.Int).SetBytes(commitment[:])
+	return e.Mod(e, btcec.S256().N)
+}
+
+// schnorrVerify verifies the signature for the passed 32-byte message against
+// the passed x-only public key as specified by BIP0340 and returns the reason
+// the signature is invalid, if any.
+func schnorrVerify(sig *Signature, hash []byte, pubKeyBytes []byte) error {
+	if len(hash) != chainhash.HashSize {
+		return fmt.Errorf("wrong size for message (got %v, want %v)",
+			len(hash), chainhash.HashSize)
+	}
+
+	// P = lift_x(int(pk)), which fails when pk isn't the x coordinate of
+	// a point on the curve.
+	pubKey, err := ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return err
+	}
+
+	// e = int(hash_BIP0340/challenge(bytes(r) || bytes(P) || m)) mod n.
+	var rBytes [32]byte
+	sig.r.FillBytes(rBytes[:])
+	e := challenge(rBytes[:], pubKeyBytes, hash)
+
+	// R = s*G - e*P, which is calculated as s*G + (n-e)*P.
+	curve := btcec.S256()
+	sgx, sgy := curve.ScalarBaseMult(sig.s.Bytes())
+	negE := new(big.Int).Sub(curve.N, e)
+	epx, epy := curve.ScalarMult(pubKey.X, pubKey.Y, negE.Bytes())
+	rx, ry := curve.Add(sgx, sgy, epx, epy)
+
+	// Fail if is_infinite(R), not has_even_y(R) or x(R) != r.
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return ErrSigRNotOnCurve
+	}
+	if ry.Bit(0) == 1 {
+		return ErrSigRYIsOdd
+	}
+	if rx.Cmp(sig.r) != 0 {
+		return ErrUnequalRValues
+	}
+
+	return nil
+}
+
+// Verify returns whether or not the signature is valid for the provided
+// 32-byte message and public key as specified by BIP0340.  Only the x
+// coordinate of the public key is used.  The verification is done by
+// libsecp256k1 when it is available.
+func (sig *Signature) Verify(hash []byte, pubKey *btcec.PublicKey) bool {
+	pubKeyBytes := SerializePubKey(pubKey)
+	if btcec.LibsecpSchnorrVerify != nil {
+		return btcec.LibsecpSchnorrVerify(pubKeyBytes, sig.Serialize(),
+			hash) == 1
+	}
+	return schnorrVerify(sig, hash, pubKeyBytes) == nil
+}
+
+// SignOption is a functional option which modifies the way Sign creates a
+// signature.
+type SignOption func(*signOptions)
+
+// signOptions houses the options which modify the way Sign creates a
+// signature.
+type signOptions struct {
+	// auxRand is the auxiliary randomness the nonce is derived from.  It
+	// is read from crypto/rand when nil.
+	auxRand *[32]byte
+
+	// fastSign skips verifying the created signature.
+	fastSign bool
+}
+
+// CustomNonce returns a SignOption which derives the nonce from the passed
+// auxiliary data instead of fresh randomness.  The resulting signatures are
+// deterministic, which is what the BIP0340 test vectors and schemes that
+// must reproduce a signature rely on.
+func CustomNonce(auxData [32]byte) SignOption {
+	return func(o *signOptions) {
+		o.auxRand = &auxData
+	}
+}
+
+// FastSign returns a SignOption which skips verifying the created signature
+// before returning it.
+func FastSign() SignOption {
+	return func(o *signOptions) {
+		o.fastSign = true
+	}
+}
+
+// Sign creates a BIP0340 signature of the passed 32-byte message with the
+// passed private key.  Unless the CustomNonce option is given, the nonce is
+// derived from fresh randomness as recommended by BIP0340.  The signature is
+// verified before it is returned unless the FastSign option is given.
+func Sign(privKey *btcec.PrivateKey, hash []byte,
+	signOpts ...SignOption) (*Signature, error) {
+
+	opts := &signOptions{}
+	for _, option := range signOpts {
+		option(opts)
+	}
+
+	if len(hash) != chainhash.HashSize {
+		return nil, fmt.Errorf("wrong size for message (got %v, want %v)",
+			len(hash), chainhash.HashSize)
+	}
+
+	// Fail if d' = 0 or d' >= n and negate d when P.y is odd so that the
+	// public key is the one with an even y coordinate.
+	curve := btcec.S256()
+	d := new(big.Int).Set(privKey.D)
+	if d.Sign() == 0 || d.Cmp(curve.N) >= 0 {
+		return nil, ErrPrivateKeyIsZero
+	}
+	pubKey := privKey.PubKey()
+	if pubKey.Y.Bit(0) == 1 {
+		d.Sub(curve.N, d)
+	}
+	pubKeyBytes := SerializePubKey(pubKey)
+
+	// t = bytes(d) xor hash_BIP0340/aux(a) and
+	// k' = int(hash_BIP0340/nonce(t || bytes(P) || m)) mod n.
+	auxRand := opts.auxRand
+	if auxRand == nil {
+		auxRand = new([32]byte)
+		if _, err := rand.Read(auxRand[:]); err != nil {
+			return nil, err
+		}
+	}
+	var t [32]byte
+	d.FillBytes(t[:])
+	auxHash := chainhash.TaggedHash(auxTag, auxRand[:])
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	nonceHash := chainhash.TaggedHash(nonceTag, t[:], pubKeyBytes, hash)
+	k := new(big.Int).SetBytes(nonceHash[:])
+	k.Mod(k, curve.N)
+	if k.Sign() == 0 {
+		return nil, ErrNonceIsZero
+	}
+
+	// R = k'*G, negating k when R.y is odd.
+	rx, ry := curve.ScalarBaseMult(k.Bytes())
+	if ry.Bit(0) == 1 {
+		k.Sub(curve.N, k)
+	}
+
+	// s = k + e*d mod n.
+	var rBytes [32]byte
+	rx.FillBytes(rBytes[:])
+	s := challenge(rBytes[:], pubKeyBytes, hash)
+	s.Mul(s, d)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	sig := &Signature{r: rx, s: s}
+	if !opts.fastSign {
+		if err := schnorrVerify(sig, hash, pubKeyBytes); err != nil {
+			return nil, err
+		}
+	}
+	return sig, nil
+}
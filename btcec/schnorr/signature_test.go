@@ -0,0 +1,318 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package schnorr
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// bip340Test houses a BIP0340 test vector.
+type bip340Test struct {
+	secretKey    string
+	publicKey    string
+	auxRand      string
+	message      string
+	signature    string
+	verifyResult bool
+	validPubKey  bool
+	expectErr    error
+}
+
+// bip340TestVectors are the verification test vectors from BIP0340.
+var bip340TestVectors = []bip340Test{
+	{
+		secretKey:    "0000000000000000000000000000000000000000000000000000000000000003",
+		publicKey:    "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		auxRand:      "0000000000000000000000000000000000000000000000000000000000000000",
+		message:      "0000000000000000000000000000000000000000000000000000000000000000",
+		signature:    "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		verifyResult: true,
+		validPubKey:  true,
+	},
+	{
+		secretKey:    "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		auxRand:      "0000000000000000000000000000000000000000000000000000000000000001",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		verifyResult: true,
+		validPubKey:  true,
+	},
+	{
+		secretKey:    "C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+		publicKey:    "DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+		auxRand:      "C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+		message:      "7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+		signature:    "5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7",
+		verifyResult: true,
+		validPubKey:  true,
+	},
+	{
+		secretKey:    "0B432B2677937381AEF05BB02A66ECD012773062CF3FA2549E44F58ED2401710",
+		publicKey:    "25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517",
+		auxRand:      "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		message:      "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		signature:    "7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3",
+		verifyResult: true,
+		validPubKey:  true,
+	},
+	{
+		publicKey:    "D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9",
+		message:      "4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+		signature:    "00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4",
+		verifyResult: true,
+		validPubKey:  true,
+	},
+	{
+		publicKey:    "EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		verifyResult: false,
+		validPubKey:  false,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrSigRYIsOdd,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrSigRYIsOdd,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769961764B3AA9B2FFCB6EF947B6887A226E8D7C93E00C5ED0C1834FF0D0C2E6DA6",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrUnequalRValues,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "0000000000000000000000000000000000000000000000000000000000000000123DDA8328AF9C23A94C1FEECFD123BA4FB73476F0D594DCB65C6425BD186051",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrSigRNotOnCurve,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "00000000000000000000000000000000000000000000000000000000000000017615FBAF5AE28864013C099742DEADB4DBA87F11AC6754F93780D5A1837CF197",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrSigRNotOnCurve,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "4A298DACAE57395A15D0795DDBFD1DCB564DA82B0F269BC70A74F8220429BA1D69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrUnequalRValues,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrSigRTooBig,
+	},
+	{
+		publicKey:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141",
+		verifyResult: false,
+		validPubKey:  true,
+		expectErr:    ErrSigSTooBig,
+	},
+	{
+		publicKey:    "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30",
+		message:      "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		signature:    "6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		verifyResult: false,
+		validPubKey:  false,
+	},
+}
+
+// decodeHex decodes the passed hex string and returns the resulting bytes.  It
+// panics if an error occurs.  This is only used in the tests as a helper since
+// the only way it can fail is if there is an error in the test source code.
+func decodeHex(hexStr string) []byte {
+	b, err := hex.DecodeString(hexStr)
+	if err != nil {
+		panic("invalid hex string in test source: err " + err.Error() +
+			", hex: " + hexStr)
+	}
+
+	return b
+}
+
+// TestSchnorrVerify ensures signatures are verified as specified by the
+// BIP0340 test vectors.
+func TestSchnorrVerify(t *testing.T) {
+	t.Parallel()
+
+	for i, test := range bip340TestVectors {
+		pubKeyBytes := decodeHex(test.publicKey)
+		pubKey, err := ParsePubKey(pubKeyBytes)
+		switch {
+		case !test.validPubKey && err != nil:
+			continue
+		case !test.validPubKey:
+			t.Errorf("test #%v: parsed invalid pubkey %s", i,
+				test.publicKey)
+			continue
+		case err != nil:
+			t.Errorf("test #%v: unable to parse pubkey: %v", i, err)
+			continue
+		}
+
+		// Signatures with out of range values can't be parsed.
+		sig, err := ParseSignature(decodeHex(test.signature))
+		if err != nil {
+			if test.verifyResult || err != test.expectErr {
+				t.Errorf("test #%v: unexpected parse error: %v, "+
+					"want %v", i, err, test.expectErr)
+			}
+			continue
+		}
+
+		msg := decodeHex(test.message)
+		err = schnorrVerify(sig, msg, pubKeyBytes)
+		if err != test.expectErr {
+			t.Errorf("test #%v: got verify error %v, want %v", i,
+				err, test.expectErr)
+			continue
+		}
+		if sig.Verify(msg, pubKey) != test.verifyResult {
+			t.Errorf("test #%v: got verify result %v, want %v", i,
+				!test.verifyResult, test.verifyResult)
+		}
+	}
+}
+
+// TestSchnorrSign ensures signatures are created as specified by the BIP0340
+// test vectors.
+func TestSchnorrSign(t *testing.T) {
+	t.Parallel()
+
+	for i, test := range bip340TestVectors {
+		if test.secretKey == "" {
+			continue
+		}
+
+		privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(),
+			decodeHex(test.secretKey))
+		var auxRand [32]byte
+		copy(auxRand[:], decodeHex(test.auxRand))
+		msg := decodeHex(test.message)
+
+		sig, err := Sign(privKey, msg, CustomNonce(auxRand))
+		if err != nil {
+			t.Errorf("test #%v: unable to sign: %v", i, err)
+			continue
+		}
+		got := strings.ToUpper(hex.EncodeToString(sig.Serialize()))
+		if got != test.signature {
+			t.Errorf("test #%v: got signature %s, want %s", i, got,
+				test.signature)
+		}
+		if got := strings.ToUpper(hex.EncodeToString(
+			SerializePubKey(privKey.PubKey()))); got != test.publicKey {
+
+			t.Errorf("test #%v: got public key %s, want %s", i, got,
+				test.publicKey)
+		}
+
+		// Signatures with fresh randomness must verify as well.
+		sig, err = Sign(privKey, msg)
+		if err != nil {
+			t.Errorf("test #%v: unable to sign: %v", i, err)
+			continue
+		}
+		if !sig.Verify(msg, privKey.PubKey()) {
+			t.Errorf("test #%v: randomized signature does not verify",
+				i)
+		}
+	}
+
+	// Messages must be 32 bytes.
+	privKey, _ := btcec.NewPrivateKey(btcec.S256())
+	if _, err := Sign(privKey, make([]byte, 31)); err == nil {
+		t.Errorf("signed message of invalid size")
+	}
+}
+
+// TestLibsecpCrossCheck ensures the pure Go verification agrees with
+// libsecp256k1 for the BIP0340 test vectors and for mutated signatures when
+// the library is available.
+func TestLibsecpCrossCheck(t *testing.T) {
+	if btcec.LibsecpSchnorrVerify == nil {
+		t.Skip("libsecp256k1 not available")
+	}
+
+	for i, test := range bip340TestVectors {
+		pubKeyBytes := decodeHex(test.publicKey)
+		sigBytes := decodeHex(test.signature)
+		msg := decodeHex(test.message)
+
+		for j := 0; j <= len(sigBytes); j++ {
+			// Check the signature unmodified and with each of its
+			// bytes flipped in turn.
+			mutated := append([]byte(nil), sigBytes...)
+			if j < len(sigBytes) {
+				mutated[j] ^= 0x01
+			}
+
+			goValid := false
+			if sig, err := ParseSignature(mutated); err == nil {
+				goValid = schnorrVerify(sig, msg, pubKeyBytes) == nil
+			}
+			libValid := btcec.LibsecpSchnorrVerify(pubKeyBytes,
+				mutated, msg) == 1
+			if goValid != libValid {
+				t.Errorf("test #%v, mutation %d: go result %v, "+
+					"libsecp result %v", i, j, goValid,
+					libValid)
+			}
+		}
+	}
+}
+
+// TestSignatureSerialize ensures signatures round trip through their BIP0340
+// encoding.
+func TestSignatureSerialize(t *testing.T) {
+	t.Parallel()
+
+	for i, test := range bip340TestVectors {
+		sigBytes := decodeHex(test.signature)
+		sig, err := ParseSignature(sigBytes)
+		if err != nil {
+			continue
+		}
+		if got := sig.Serialize(); hex.EncodeToString(got) !=
+			hex.EncodeToString(sigBytes) {
+
+			t.Errorf("test #%v: got serialized signature %x, want %x",
+				i, got, sigBytes)
+		}
+		if !sig.IsEqual(NewSignature(sig.r, sig.s)) {
+			t.Errorf("test #%v: signature not equal to its copy", i)
+		}
+	}
+}
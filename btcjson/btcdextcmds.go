@@ -59,6 +59,32 @@ func NewDebugLevelCmd(levelSpec string) *DebugLevelCmd {
 	}
 }
 
+// DebugScriptCmd defines the debugscript JSON-RPC command.  This command is
+// not a standard Bitcoin command.  It is an extension for btcd.
+//
+// UData is the hex-encoded utreexo data proving the outputs spent by the
+// transaction.  It is only used by compact state nodes, which don't keep the
+// UTXO set.
+type DebugScriptCmd struct {
+	HexTx      string
+	InputIndex uint32
+	UData      *string
+}
+
+// NewDebugScriptCmd returns a new DebugScriptCmd which can be used to issue a
+// debugscript JSON-RPC command.  This command is not a standard Bitcoin
+// command.  It is an extension for btcd.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewDebugScriptCmd(hexTx string, inputIndex uint32, udata *string) *DebugScriptCmd {
+	return &DebugScriptCmd{
+		HexTx:      hexTx,
+		InputIndex: inputIndex,
+		UData:      udata,
+	}
+}
+
 // GenerateToAddressCmd defines the generatetoaddress JSON-RPC command.
 type GenerateToAddressCmd struct {
 	NumBlocks int64
@@ -128,6 +154,15 @@ func NewGetHeadersCmd(blockLocators []string, hashStop string) *GetHeadersCmd {
 	}
 }
 
+// GetRebroadcastInfoCmd defines the getrebroadcastinfo JSON-RPC command.
+type GetRebroadcastInfoCmd struct{}
+
+// NewGetRebroadcastInfoCmd returns a new instance which can be used to issue a
+// getrebroadcastinfo JSON-RPC command.
+func NewGetRebroadcastInfoCmd() *GetRebroadcastInfoCmd {
+	return &GetRebroadcastInfoCmd{}
+}
+
 // VersionCmd defines the version JSON-RPC command.
 //
 // NOTE: This is a btcsuite extension ported from
@@ -146,11 +181,13 @@ func init() {
 	flags := UsageFlag(0)
 
 	MustRegisterCmd("debuglevel", (*DebugLevelCmd)(nil), flags)
+	MustRegisterCmd("debugscript", (*DebugScriptCmd)(nil), flags)
 	MustRegisterCmd("node", (*NodeCmd)(nil), flags)
 	MustRegisterCmd("generate", (*GenerateCmd)(nil), flags)
 	MustRegisterCmd("generatetoaddress", (*GenerateToAddressCmd)(nil), flags)
 	MustRegisterCmd("getbestblock", (*GetBestBlockCmd)(nil), flags)
 	MustRegisterCmd("getcurrentnet", (*GetCurrentNetCmd)(nil), flags)
 	MustRegisterCmd("getheaders", (*GetHeadersCmd)(nil), flags)
+	MustRegisterCmd("getrebroadcastinfo", (*GetRebroadcastInfoCmd)(nil), flags)
 	MustRegisterCmd("version", (*VersionCmd)(nil), flags)
 }
@@ -43,6 +43,36 @@ func TestBtcdExtCmds(t *testing.T) {
 				LevelSpec: "trace",
 			},
 		},
+		{
+			name: "debugscript",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("debugscript", "0100", 1)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewDebugScriptCmd("0100", 1, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"debugscript","params":["0100",1],"id":1}`,
+			unmarshalled: &btcjson.DebugScriptCmd{
+				HexTx:      "0100",
+				InputIndex: 1,
+			},
+		},
+		{
+			name: "debugscript optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("debugscript", "0100", 1, "00")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewDebugScriptCmd("0100", 1,
+					btcjson.String("00"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"debugscript","params":["0100",1,"00"],"id":1}`,
+			unmarshalled: &btcjson.DebugScriptCmd{
+				HexTx:      "0100",
+				InputIndex: 1,
+				UData:      btcjson.String("00"),
+			},
+		},
 		{
 			name: "node",
 			newCmd: func() (interface{}, error) {
@@ -154,6 +184,17 @@ func TestBtcdExtCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"getcurrentnet","params":[],"id":1}`,
 			unmarshalled: &btcjson.GetCurrentNetCmd{},
 		},
+		{
+			name: "getrebroadcastinfo",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getrebroadcastinfo")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetRebroadcastInfoCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"getrebroadcastinfo","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetRebroadcastInfoCmd{},
+		},
 		{
 			name: "getheaders",
 			newCmd: func() (interface{}, error) {
@@ -18,3 +18,59 @@ type VersionResult struct {
 	Prerelease    string `json:"prerelease"`
 	BuildMetadata string `json:"buildmetadata"`
 }
+
+// DebugScriptSigCheck models a signature checked while executing a script
+// traced by the debugscript command.
+type DebugScriptSigCheck struct {
+	PubKey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+	HashType  uint32 `json:"hashtype"`
+	SigHash   string `json:"sighash"`
+	Valid     bool   `json:"valid"`
+}
+
+// DebugScriptStep models an opcode executed while executing a script traced by
+// the debugscript command.  The stacks are hex encoded with their top element
+// last.
+type DebugScriptStep struct {
+	Script      int                   `json:"script"`
+	Index       int                   `json:"index"`
+	Opcode      string                `json:"opcode"`
+	Executed    bool                  `json:"executed"`
+	StackBefore []string              `json:"stackbefore"`
+	Stack       []string              `json:"stack"`
+	AltStack    []string              `json:"altstack"`
+	CondStack   []int                 `json:"condstack"`
+	NumOps      int                   `json:"numops"`
+	SigChecks   []DebugScriptSigCheck `json:"sigchecks,omitempty"`
+	Error       string                `json:"error,omitempty"`
+}
+
+// DebugScriptResult models the data returned from the debugscript command.
+//
+// NOTE: This is a btcsuite extension.
+type DebugScriptResult struct {
+	TxID         string            `json:"txid"`
+	Vin          uint32            `json:"vin"`
+	Amount       float64           `json:"amount"`
+	ScriptPubKey string            `json:"scriptpubkey"`
+	Flags        uint32            `json:"flags"`
+	Steps        []DebugScriptStep `json:"steps"`
+	Valid        bool              `json:"valid"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// GetRebroadcastInfoResult models the data returned from the
+// getrebroadcastinfo command for each transaction submitted through the RPC
+// server which hasn't been mined yet.  LastAnnounced is zero when the
+// transaction was never announced and Expires is zero when it never expires.
+//
+// NOTE: This is a btcsuite extension.
+type GetRebroadcastInfoResult struct {
+	TxID          string  `json:"txid"`
+	Time          int64   `json:"time"`
+	LastAnnounced int64   `json:"lastannounced"`
+	Expires       int64   `json:"expires"`
+	InTemplate    bool    `json:"intemplate"`
+	Peers         []int32 `json:"peers"`
+}
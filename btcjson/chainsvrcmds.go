@@ -55,6 +55,53 @@ type TransactionInput struct {
 	Vout uint32 `json:"vout"`
 }
 
+// CombinePsbtCmd defines the combinepsbt JSON-RPC command.
+type CombinePsbtCmd struct {
+	Psbts []string
+}
+
+// NewCombinePsbtCmd returns a new instance which can be used to issue a
+// combinepsbt JSON-RPC command.
+func NewCombinePsbtCmd(psbts []string) *CombinePsbtCmd {
+	return &CombinePsbtCmd{
+		Psbts: psbts,
+	}
+}
+
+// CreatePsbtCmd defines the createpsbt JSON-RPC command.
+//
+// A zero sequence number for an input selects the default sequence number,
+// which depends on the locktime and whether the transaction is replaceable.
+type CreatePsbtCmd struct {
+	Inputs      []PsbtInput
+	Outputs     []PsbtOutput
+	Locktime    *uint32
+	Replaceable *bool   `jsonrpcdefault:"false"`
+	PsbtVersion *uint32 `jsonrpcdefault:"0"`
+}
+
+// NewCreatePsbtCmd returns a new instance which can be used to issue a
+// createpsbt JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewCreatePsbtCmd(inputs []PsbtInput, outputs []PsbtOutput,
+	locktime *uint32, replaceable *bool, psbtVersion *uint32) *CreatePsbtCmd {
+
+	// to make sure we're serializing this to the empty list and not null, we
+	// explicitly initialize the list
+	if inputs == nil {
+		inputs = []PsbtInput{}
+	}
+	return &CreatePsbtCmd{
+		Inputs:      inputs,
+		Outputs:     outputs,
+		Locktime:    locktime,
+		Replaceable: replaceable,
+		PsbtVersion: psbtVersion,
+	}
+}
+
 // CreateRawTransactionCmd defines the createrawtransaction JSON-RPC command.
 type CreateRawTransactionCmd struct {
 	Inputs   []TransactionInput
@@ -81,6 +128,19 @@ func NewCreateRawTransactionCmd(inputs []TransactionInput, amounts map[string]fl
 	}
 }
 
+// DecodePsbtCmd defines the decodepsbt JSON-RPC command.
+type DecodePsbtCmd struct {
+	Psbt string
+}
+
+// NewDecodePsbtCmd returns a new instance which can be used to issue a
+// decodepsbt JSON-RPC command.
+func NewDecodePsbtCmd(psbt string) *DecodePsbtCmd {
+	return &DecodePsbtCmd{
+		Psbt: psbt,
+	}
+}
+
 // DecodeRawTransactionCmd defines the decoderawtransaction JSON-RPC command.
 type DecodeRawTransactionCmd struct {
 	HexTx string
@@ -149,6 +209,24 @@ type FundRawTransactionOpts struct {
 	EstimateMode           *EstimateSmartFeeMode `json:"estimate_mode,omitempty"`
 }
 
+// FinalizePsbtCmd defines the finalizepsbt JSON-RPC command.
+type FinalizePsbtCmd struct {
+	Psbt    string
+	Extract *bool `jsonrpcdefault:"true"`
+}
+
+// NewFinalizePsbtCmd returns a new instance which can be used to issue a
+// finalizepsbt JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewFinalizePsbtCmd(psbt string, extract *bool) *FinalizePsbtCmd {
+	return &FinalizePsbtCmd{
+		Psbt:    psbt,
+		Extract: extract,
+	}
+}
+
 // FundRawTransactionCmd defines the fundrawtransaction JSON-RPC command
 type FundRawTransactionCmd struct {
 	HexTx     string
@@ -536,6 +614,42 @@ func NewGetInfoCmd() *GetInfoCmd {
 	return &GetInfoCmd{}
 }
 
+// GetMempoolAncestorsCmd defines the getmempoolancestors JSON-RPC command.
+type GetMempoolAncestorsCmd struct {
+	TxID    string
+	Verbose *bool `jsonrpcdefault:"false"`
+}
+
+// NewGetMempoolAncestorsCmd returns a new instance which can be used to issue
+// a getmempoolancestors JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetMempoolAncestorsCmd(txHash string, verbose *bool) *GetMempoolAncestorsCmd {
+	return &GetMempoolAncestorsCmd{
+		TxID:    txHash,
+		Verbose: verbose,
+	}
+}
+
+// GetMempoolDescendantsCmd defines the getmempooldescendants JSON-RPC command.
+type GetMempoolDescendantsCmd struct {
+	TxID    string
+	Verbose *bool `jsonrpcdefault:"false"`
+}
+
+// NewGetMempoolDescendantsCmd returns a new instance which can be used to
+// issue a getmempooldescendants JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetMempoolDescendantsCmd(txHash string, verbose *bool) *GetMempoolDescendantsCmd {
+	return &GetMempoolDescendantsCmd{
+		TxID:    txHash,
+		Verbose: verbose,
+	}
+}
+
 // GetMempoolEntryCmd defines the getmempoolentry JSON-RPC command.
 type GetMempoolEntryCmd struct {
 	TxID string
@@ -768,6 +882,15 @@ func NewInvalidateBlockCmd(blockHash string) *InvalidateBlockCmd {
 	}
 }
 
+// LoadMempoolCmd defines the loadmempool JSON-RPC command.
+type LoadMempoolCmd struct{}
+
+// NewLoadMempoolCmd returns a new instance which can be used to issue a
+// loadmempool JSON-RPC command.
+func NewLoadMempoolCmd() *LoadMempoolCmd {
+	return &LoadMempoolCmd{}
+}
+
 // PingCmd defines the ping JSON-RPC command.
 type PingCmd struct{}
 
@@ -790,6 +913,25 @@ func NewPreciousBlockCmd(blockHash string) *PreciousBlockCmd {
 	}
 }
 
+// PrioritiseTransactionCmd defines the prioritisetransaction JSON-RPC command.
+//
+// The priority delta is no longer supported and must be zero.  It is only kept
+// for compatibility with previous versions of the command.
+type PrioritiseTransactionCmd struct {
+	TxID          string
+	PriorityDelta float64
+	FeeDelta      int64
+}
+
+// NewPrioritiseTransactionCmd returns a new instance which can be used to issue
+// a prioritisetransaction JSON-RPC command.
+func NewPrioritiseTransactionCmd(txHash string, feeDelta int64) *PrioritiseTransactionCmd {
+	return &PrioritiseTransactionCmd{
+		TxID:     txHash,
+		FeeDelta: feeDelta,
+	}
+}
+
 // ReconsiderBlockCmd defines the reconsiderblock JSON-RPC command.
 type ReconsiderBlockCmd struct {
 	BlockHash string
@@ -803,6 +945,70 @@ func NewReconsiderBlockCmd(blockHash string) *ReconsiderBlockCmd {
 	}
 }
 
+// SaveMempoolCmd defines the savemempool JSON-RPC command.
+type SaveMempoolCmd struct{}
+
+// NewSaveMempoolCmd returns a new instance which can be used to issue a
+// savemempool JSON-RPC command.
+func NewSaveMempoolCmd() *SaveMempoolCmd {
+	return &SaveMempoolCmd{}
+}
+
+// ScanObject is a target of a scantxoutset JSON-RPC command.  It is either an
+// output descriptor, which is marshalled as a plain string, or a ranged output
+// descriptor along with the range of indexes to derive it at.
+type ScanObject struct {
+	Desc  string           `json:"desc"`
+	Range *DescriptorRange `json:"range,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface for ScanObject.
+func (s ScanObject) MarshalJSON() ([]byte, error) {
+	if s.Range == nil {
+		return json.Marshal(s.Desc)
+	}
+
+	type scanObject ScanObject
+	return json.Marshal(scanObject(s))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for ScanObject.
+func (s *ScanObject) UnmarshalJSON(data []byte) error {
+	var desc string
+	if err := json.Unmarshal(data, &desc); err == nil {
+		*s = ScanObject{Desc: desc}
+		return nil
+	}
+
+	type scanObject ScanObject
+	var obj scanObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("invalid scan object: %s", data)
+	}
+	*s = ScanObject(obj)
+	return nil
+}
+
+// ScanTxOutSetCmd defines the scantxoutset JSON-RPC command.
+type ScanTxOutSetCmd struct {
+	Action      string
+	ScanObjects *[]ScanObject
+	StartHeight *int32 `jsonrpcdefault:"0"`
+}
+
+// NewScanTxOutSetCmd returns a new instance which can be used to issue a
+// scantxoutset JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewScanTxOutSetCmd(action string, scanObjects *[]ScanObject, startHeight *int32) *ScanTxOutSetCmd {
+	return &ScanTxOutSetCmd{
+		Action:      action,
+		ScanObjects: scanObjects,
+		StartHeight: startHeight,
+	}
+}
+
 // SearchRawTransactionsCmd defines the searchrawtransactions JSON-RPC command.
 type SearchRawTransactionsCmd struct {
 	Address     string
@@ -984,6 +1190,50 @@ func NewSubmitBlockCmd(hexBlock string, options *SubmitBlockOptions) *SubmitBloc
 	}
 }
 
+// SubmitPackageCmd defines the submitpackage JSON-RPC command.
+//
+// RawTxns holds the hex-encoded transactions of a package made of a child
+// coming last and of its unconfirmed parents.  MaxFeeRate is the maximum fee
+// rate in BTC/kvB the transactions may pay, where 0 means no limit.
+type SubmitPackageCmd struct {
+	RawTxns    []string
+	MaxFeeRate *float64 `jsonrpcdefault:"0.1"`
+}
+
+// NewSubmitPackageCmd returns a new instance which can be used to issue a
+// submitpackage JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewSubmitPackageCmd(rawTxns []string, maxFeeRate *float64) *SubmitPackageCmd {
+	return &SubmitPackageCmd{
+		RawTxns:    rawTxns,
+		MaxFeeRate: maxFeeRate,
+	}
+}
+
+// TestMempoolAcceptCmd defines the testmempoolaccept JSON-RPC command.
+//
+// RawTxns holds either a single hex-encoded transaction, or the transactions of
+// a package as passed to submitpackage.  MaxFeeRate is the maximum fee rate in
+// BTC/kvB the transactions may pay, where 0 means no limit.
+type TestMempoolAcceptCmd struct {
+	RawTxns    []string
+	MaxFeeRate *float64 `jsonrpcdefault:"0.1"`
+}
+
+// NewTestMempoolAcceptCmd returns a new instance which can be used to issue a
+// testmempoolaccept JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewTestMempoolAcceptCmd(rawTxns []string, maxFeeRate *float64) *TestMempoolAcceptCmd {
+	return &TestMempoolAcceptCmd{
+		RawTxns:    rawTxns,
+		MaxFeeRate: maxFeeRate,
+	}
+}
+
 // UptimeCmd defines the uptime JSON-RPC command.
 type UptimeCmd struct{}
 
@@ -992,6 +1242,27 @@ func NewUptimeCmd() *UptimeCmd {
 	return &UptimeCmd{}
 }
 
+// UtxoUpdatePsbtCmd defines the utxoupdatepsbt JSON-RPC command.
+//
+// UData is the hex-encoded utreexo data proving the outputs spent by the
+// PSBT.  It is only used by compact state nodes, which don't keep the UTXO set.
+type UtxoUpdatePsbtCmd struct {
+	Psbt  string
+	UData *string
+}
+
+// NewUtxoUpdatePsbtCmd returns a new instance which can be used to issue a
+// utxoupdatepsbt JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewUtxoUpdatePsbtCmd(psbt string, udata *string) *UtxoUpdatePsbtCmd {
+	return &UtxoUpdatePsbtCmd{
+		Psbt:  psbt,
+		UData: udata,
+	}
+}
+
 // ValidateAddressCmd defines the validateaddress JSON-RPC command.
 type ValidateAddressCmd struct {
 	Address string
@@ -1058,10 +1329,14 @@ func init() {
 	flags := UsageFlag(0)
 
 	MustRegisterCmd("addnode", (*AddNodeCmd)(nil), flags)
+	MustRegisterCmd("combinepsbt", (*CombinePsbtCmd)(nil), flags)
+	MustRegisterCmd("createpsbt", (*CreatePsbtCmd)(nil), flags)
 	MustRegisterCmd("createrawtransaction", (*CreateRawTransactionCmd)(nil), flags)
+	MustRegisterCmd("decodepsbt", (*DecodePsbtCmd)(nil), flags)
 	MustRegisterCmd("decoderawtransaction", (*DecodeRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("decodescript", (*DecodeScriptCmd)(nil), flags)
 	MustRegisterCmd("deriveaddresses", (*DeriveAddressesCmd)(nil), flags)
+	MustRegisterCmd("finalizepsbt", (*FinalizePsbtCmd)(nil), flags)
 	MustRegisterCmd("fundrawtransaction", (*FundRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("getaddednodeinfo", (*GetAddedNodeInfoCmd)(nil), flags)
 	MustRegisterCmd("getbestblockhash", (*GetBestBlockHashCmd)(nil), flags)
@@ -1083,6 +1358,8 @@ func init() {
 	MustRegisterCmd("getgenerate", (*GetGenerateCmd)(nil), flags)
 	MustRegisterCmd("gethashespersec", (*GetHashesPerSecCmd)(nil), flags)
 	MustRegisterCmd("getinfo", (*GetInfoCmd)(nil), flags)
+	MustRegisterCmd("getmempoolancestors", (*GetMempoolAncestorsCmd)(nil), flags)
+	MustRegisterCmd("getmempooldescendants", (*GetMempoolDescendantsCmd)(nil), flags)
 	MustRegisterCmd("getmempoolentry", (*GetMempoolEntryCmd)(nil), flags)
 	MustRegisterCmd("getmempoolinfo", (*GetMempoolInfoCmd)(nil), flags)
 	MustRegisterCmd("getmininginfo", (*GetMiningInfoCmd)(nil), flags)
@@ -1100,16 +1377,23 @@ func init() {
 	MustRegisterCmd("getttl", (*GetTTLCmd)(nil), flags)
 	MustRegisterCmd("help", (*HelpCmd)(nil), flags)
 	MustRegisterCmd("invalidateblock", (*InvalidateBlockCmd)(nil), flags)
+	MustRegisterCmd("loadmempool", (*LoadMempoolCmd)(nil), flags)
 	MustRegisterCmd("ping", (*PingCmd)(nil), flags)
 	MustRegisterCmd("preciousblock", (*PreciousBlockCmd)(nil), flags)
+	MustRegisterCmd("prioritisetransaction", (*PrioritiseTransactionCmd)(nil), flags)
 	MustRegisterCmd("reconsiderblock", (*ReconsiderBlockCmd)(nil), flags)
+	MustRegisterCmd("savemempool", (*SaveMempoolCmd)(nil), flags)
+	MustRegisterCmd("scantxoutset", (*ScanTxOutSetCmd)(nil), flags)
 	MustRegisterCmd("searchrawtransactions", (*SearchRawTransactionsCmd)(nil), flags)
 	MustRegisterCmd("sendrawtransaction", (*SendRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("setgenerate", (*SetGenerateCmd)(nil), flags)
 	MustRegisterCmd("signmessagewithprivkey", (*SignMessageWithPrivKeyCmd)(nil), flags)
 	MustRegisterCmd("stop", (*StopCmd)(nil), flags)
 	MustRegisterCmd("submitblock", (*SubmitBlockCmd)(nil), flags)
+	MustRegisterCmd("submitpackage", (*SubmitPackageCmd)(nil), flags)
+	MustRegisterCmd("testmempoolaccept", (*TestMempoolAcceptCmd)(nil), flags)
 	MustRegisterCmd("uptime", (*UptimeCmd)(nil), flags)
+	MustRegisterCmd("utxoupdatepsbt", (*UtxoUpdatePsbtCmd)(nil), flags)
 	MustRegisterCmd("validateaddress", (*ValidateAddressCmd)(nil), flags)
 	MustRegisterCmd("verifychain", (*VerifyChainCmd)(nil), flags)
 	MustRegisterCmd("verifymessage", (*VerifyMessageCmd)(nil), flags)
@@ -42,6 +42,66 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"addnode","params":["127.0.0.1","remove"],"id":1}`,
 			unmarshalled: &btcjson.AddNodeCmd{Addr: "127.0.0.1", SubCmd: btcjson.ANRemove},
 		},
+		{
+			name: "combinepsbt",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("combinepsbt", []string{"cHNidP8B", "cHNidP8C"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewCombinePsbtCmd([]string{"cHNidP8B", "cHNidP8C"})
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"combinepsbt","params":[["cHNidP8B","cHNidP8C"]],"id":1}`,
+			unmarshalled: &btcjson.CombinePsbtCmd{
+				Psbts: []string{"cHNidP8B", "cHNidP8C"},
+			},
+		},
+		{
+			name: "createpsbt",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("createpsbt", `[{"txid":"123","vout":1,"sequence":0}]`,
+					`[{"456":0.0123}]`)
+			},
+			staticCmd: func() interface{} {
+				inputs := []btcjson.PsbtInput{{Txid: "123", Vout: 1}}
+				outputs := []btcjson.PsbtOutput{{"456": .0123}}
+				return btcjson.NewCreatePsbtCmd(inputs, outputs, nil, nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"createpsbt","params":[[{"txid":"123","vout":1,"sequence":0}],[{"456":0.0123}]],"id":1}`,
+			unmarshalled: &btcjson.CreatePsbtCmd{
+				Inputs:      []btcjson.PsbtInput{{Txid: "123", Vout: 1}},
+				Outputs:     []btcjson.PsbtOutput{{"456": .0123}},
+				Replaceable: btcjson.Bool(false),
+				PsbtVersion: btcjson.Uint32(0),
+			},
+		},
+		{
+			name: "createpsbt optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("createpsbt", `[{"txid":"123","vout":1,"sequence":5}]`,
+					`[{"456":0.0123},{"data":"01020304"}]`, 500000, true, 2)
+			},
+			staticCmd: func() interface{} {
+				inputs := []btcjson.PsbtInput{{Txid: "123", Vout: 1, Sequence: 5}}
+				outputs := []btcjson.PsbtOutput{
+					{"456": .0123},
+					btcjson.NewPsbtDataOutput([]byte{1, 2, 3, 4}),
+				}
+				return btcjson.NewCreatePsbtCmd(inputs, outputs,
+					btcjson.Uint32(500000), btcjson.Bool(true),
+					btcjson.Uint32(2))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"createpsbt","params":[[{"txid":"123","vout":1,"sequence":5}],[{"456":0.0123},{"data":"01020304"}],500000,true,2],"id":1}`,
+			unmarshalled: &btcjson.CreatePsbtCmd{
+				Inputs: []btcjson.PsbtInput{{Txid: "123", Vout: 1, Sequence: 5}},
+				Outputs: []btcjson.PsbtOutput{
+					{"456": .0123},
+					btcjson.NewPsbtDataOutput([]byte{1, 2, 3, 4}),
+				},
+				Locktime:    btcjson.Uint32(500000),
+				Replaceable: btcjson.Bool(true),
+				PsbtVersion: btcjson.Uint32(2),
+			},
+		},
 		{
 			name: "createrawtransaction",
 			newCmd: func() (interface{}, error) {
@@ -96,6 +156,34 @@ func TestChainSvrCmds(t *testing.T) {
 				LockTime: btcjson.Int64(12312333333),
 			},
 		},
+		{
+			name: "finalizepsbt",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("finalizepsbt", "cHNidP8B")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewFinalizePsbtCmd("cHNidP8B", nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"finalizepsbt","params":["cHNidP8B"],"id":1}`,
+			unmarshalled: &btcjson.FinalizePsbtCmd{
+				Psbt:    "cHNidP8B",
+				Extract: btcjson.Bool(true),
+			},
+		},
+		{
+			name: "finalizepsbt optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("finalizepsbt", "cHNidP8B", false)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewFinalizePsbtCmd("cHNidP8B", btcjson.Bool(false))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"finalizepsbt","params":["cHNidP8B",false],"id":1}`,
+			unmarshalled: &btcjson.FinalizePsbtCmd{
+				Psbt:    "cHNidP8B",
+				Extract: btcjson.Bool(false),
+			},
+		},
 		{
 			name: "fundrawtransaction - empty opts",
 			newCmd: func() (i interface{}, e error) {
@@ -198,6 +286,17 @@ func TestChainSvrCmds(t *testing.T) {
 				}(),
 			},
 		},
+		{
+			name: "decodepsbt",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("decodepsbt", "cHNidP8B")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewDecodePsbtCmd("cHNidP8B")
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"decodepsbt","params":["cHNidP8B"],"id":1}`,
+			unmarshalled: &btcjson.DecodePsbtCmd{Psbt: "cHNidP8B"},
+		},
 		{
 			name: "decoderawtransaction",
 			newCmd: func() (interface{}, error) {
@@ -699,6 +798,36 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"getinfo","params":[],"id":1}`,
 			unmarshalled: &btcjson.GetInfoCmd{},
 		},
+		{
+			name: "getmempoolancestors",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getmempoolancestors", "txhash")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetMempoolAncestorsCmd("txhash", nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempoolancestors","params":["txhash"],"id":1}`,
+			unmarshalled: &btcjson.GetMempoolAncestorsCmd{
+				TxID:    "txhash",
+				Verbose: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "getmempooldescendants",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getmempooldescendants", "txhash",
+					true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetMempoolDescendantsCmd("txhash",
+					btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempooldescendants","params":["txhash",true],"id":1}`,
+			unmarshalled: &btcjson.GetMempoolDescendantsCmd{
+				TxID:    "txhash",
+				Verbose: btcjson.Bool(true),
+			},
+		},
 		{
 			name: "getmempoolentry",
 			newCmd: func() (interface{}, error) {
@@ -1025,6 +1154,17 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "123",
 			},
 		},
+		{
+			name: "loadmempool",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("loadmempool")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewLoadMempoolCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"loadmempool","params":[],"id":1}`,
+			unmarshalled: &btcjson.LoadMempoolCmd{},
+		},
 		{
 			name: "ping",
 			newCmd: func() (interface{}, error) {
@@ -1049,6 +1189,23 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "0123",
 			},
 		},
+		{
+			name: "prioritisetransaction",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("prioritisetransaction", "txhash",
+					0.0, 10000)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewPrioritiseTransactionCmd("txhash",
+					10000)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"prioritisetransaction","params":["txhash",0,10000],"id":1}`,
+			unmarshalled: &btcjson.PrioritiseTransactionCmd{
+				TxID:          "txhash",
+				PriorityDelta: 0,
+				FeeDelta:      10000,
+			},
+		},
 		{
 			name: "reconsiderblock",
 			newCmd: func() (interface{}, error) {
@@ -1062,6 +1219,59 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "123",
 			},
 		},
+		{
+			name: "savemempool",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("savemempool")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSaveMempoolCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"savemempool","params":[],"id":1}`,
+			unmarshalled: &btcjson.SaveMempoolCmd{},
+		},
+		{
+			name: "scantxoutset status",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("scantxoutset", "status")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewScanTxOutSetCmd("status", nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"scantxoutset","params":["status"],"id":1}`,
+			unmarshalled: &btcjson.ScanTxOutSetCmd{
+				Action:      "status",
+				StartHeight: btcjson.Int32(0),
+			},
+		},
+		{
+			name: "scantxoutset start",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("scantxoutset", "start",
+					`["addr(1Address)",{"desc":"raw(00)","range":[1,5]}]`, 10)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewScanTxOutSetCmd("start", &[]btcjson.ScanObject{
+					{Desc: "addr(1Address)"},
+					{
+						Desc:  "raw(00)",
+						Range: &btcjson.DescriptorRange{Value: []int{1, 5}},
+					},
+				}, btcjson.Int32(10))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"scantxoutset","params":["start",["addr(1Address)",{"desc":"raw(00)","range":[1,5]}],10],"id":1}`,
+			unmarshalled: &btcjson.ScanTxOutSetCmd{
+				Action: "start",
+				ScanObjects: &[]btcjson.ScanObject{
+					{Desc: "addr(1Address)"},
+					{
+						Desc:  "raw(00)",
+						Range: &btcjson.DescriptorRange{Value: []int{1, 5}},
+					},
+				},
+				StartHeight: btcjson.Int32(10),
+			},
+		},
 		{
 			name: "searchrawtransactions",
 			newCmd: func() (interface{}, error) {
@@ -1355,6 +1565,64 @@ func TestChainSvrCmds(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "submitpackage",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("submitpackage", []string{"0011", "2233"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSubmitPackageCmd([]string{"0011", "2233"}, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"submitpackage","params":[["0011","2233"]],"id":1}`,
+			unmarshalled: &btcjson.SubmitPackageCmd{
+				RawTxns:    []string{"0011", "2233"},
+				MaxFeeRate: btcjson.Float64(0.1),
+			},
+		},
+		{
+			name: "submitpackage optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("submitpackage", []string{"0011", "2233"}, 0.5)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSubmitPackageCmd([]string{"0011", "2233"},
+					btcjson.Float64(0.5))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"submitpackage","params":[["0011","2233"],0.5],"id":1}`,
+			unmarshalled: &btcjson.SubmitPackageCmd{
+				RawTxns:    []string{"0011", "2233"},
+				MaxFeeRate: btcjson.Float64(0.5),
+			},
+		},
+		{
+			name: "testmempoolaccept",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("testmempoolaccept", []string{"0011"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewTestMempoolAcceptCmd([]string{"0011"}, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"testmempoolaccept","params":[["0011"]],"id":1}`,
+			unmarshalled: &btcjson.TestMempoolAcceptCmd{
+				RawTxns:    []string{"0011"},
+				MaxFeeRate: btcjson.Float64(0.1),
+			},
+		},
+		{
+			name: "testmempoolaccept optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("testmempoolaccept", []string{"0011"}, 0.0)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewTestMempoolAcceptCmd([]string{"0011"},
+					btcjson.Float64(0))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"testmempoolaccept","params":[["0011"],0],"id":1}`,
+			unmarshalled: &btcjson.TestMempoolAcceptCmd{
+				RawTxns:    []string{"0011"},
+				MaxFeeRate: btcjson.Float64(0),
+			},
+		},
 		{
 			name: "uptime",
 			newCmd: func() (interface{}, error) {
@@ -1366,6 +1634,31 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"uptime","params":[],"id":1}`,
 			unmarshalled: &btcjson.UptimeCmd{},
 		},
+		{
+			name: "utxoupdatepsbt",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("utxoupdatepsbt", "cHNidP8B")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewUtxoUpdatePsbtCmd("cHNidP8B", nil)
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"utxoupdatepsbt","params":["cHNidP8B"],"id":1}`,
+			unmarshalled: &btcjson.UtxoUpdatePsbtCmd{Psbt: "cHNidP8B"},
+		},
+		{
+			name: "utxoupdatepsbt optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("utxoupdatepsbt", "cHNidP8B", "00")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewUtxoUpdatePsbtCmd("cHNidP8B", btcjson.String("00"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"utxoupdatepsbt","params":["cHNidP8B","00"],"id":1}`,
+			unmarshalled: &btcjson.UtxoUpdatePsbtCmd{
+				Psbt:  "cHNidP8B",
+				UData: btcjson.String("00"),
+			},
+		},
 		{
 			name: "validateaddress",
 			newCmd: func() (interface{}, error) {
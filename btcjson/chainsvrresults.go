@@ -145,6 +145,116 @@ type DecodeScriptResult struct {
 	P2sh      string   `json:"p2sh,omitempty"`
 }
 
+// PsbtScriptResult models a redeem or witness script in the data returned from
+// the decodepsbt command.
+type PsbtScriptResult struct {
+	Asm  string `json:"asm"`
+	Hex  string `json:"hex"`
+	Type string `json:"type"`
+}
+
+// PsbtWitnessUtxoResult models the witness UTXO of an input in the data
+// returned from the decodepsbt command.
+type PsbtWitnessUtxoResult struct {
+	Amount       float64            `json:"amount"`
+	ScriptPubKey ScriptPubKeyResult `json:"scriptPubKey"`
+}
+
+// PsbtBip32DerivResult models a BIP32 derivation path in the data returned
+// from the decodepsbt command.
+type PsbtBip32DerivResult struct {
+	PubKey            string `json:"pubkey"`
+	MasterFingerprint string `json:"master_fingerprint"`
+	Path              string `json:"path"`
+}
+
+// PsbtTaprootBip32DerivResult models a taproot BIP32 derivation path in the
+// data returned from the decodepsbt command.
+type PsbtTaprootBip32DerivResult struct {
+	PubKey            string   `json:"pubkey"`
+	MasterFingerprint string   `json:"master_fingerprint"`
+	Path              string   `json:"path"`
+	LeafHashes        []string `json:"leaf_hashes"`
+}
+
+// PsbtTaprootScriptSigResult models a taproot script path signature in the
+// data returned from the decodepsbt command.
+type PsbtTaprootScriptSigResult struct {
+	PubKey   string `json:"pubkey"`
+	LeafHash string `json:"leaf_hash"`
+	Sig      string `json:"sig"`
+}
+
+// PsbtTaprootScriptResult models a taproot leaf script along with the control
+// blocks for it in the data returned from the decodepsbt command.
+type PsbtTaprootScriptResult struct {
+	Script        string   `json:"script"`
+	LeafVer       uint8    `json:"leaf_ver"`
+	ControlBlocks []string `json:"control_blocks"`
+}
+
+// PsbtTaprootTreeLeafResult models a leaf of the taproot tree of an output in
+// the data returned from the decodepsbt command.
+type PsbtTaprootTreeLeafResult struct {
+	Depth   uint8  `json:"depth"`
+	LeafVer uint8  `json:"leaf_ver"`
+	Script  string `json:"script"`
+}
+
+// PsbtInputResult models the data of an input in the data returned from the
+// decodepsbt command.
+type PsbtInputResult struct {
+	NonWitnessUtxo         *TxRawDecodeResult            `json:"non_witness_utxo,omitempty"`
+	WitnessUtxo            *PsbtWitnessUtxoResult        `json:"witness_utxo,omitempty"`
+	PartialSignatures      map[string]string             `json:"partial_signatures,omitempty"`
+	Sighash                string                        `json:"sighash,omitempty"`
+	RedeemScript           *PsbtScriptResult             `json:"redeem_script,omitempty"`
+	WitnessScript          *PsbtScriptResult             `json:"witness_script,omitempty"`
+	Bip32Derivs            []PsbtBip32DerivResult        `json:"bip32_derivs,omitempty"`
+	FinalScriptSig         *ScriptSig                    `json:"final_scriptSig,omitempty"`
+	FinalScriptWitness     []string                      `json:"final_scriptwitness,omitempty"`
+	TaprootKeyPathSig      string                        `json:"taproot_key_path_sig,omitempty"`
+	TaprootScriptPathSigs  []PsbtTaprootScriptSigResult  `json:"taproot_script_path_sigs,omitempty"`
+	TaprootScripts         []PsbtTaprootScriptResult     `json:"taproot_scripts,omitempty"`
+	TaprootBip32Derivs     []PsbtTaprootBip32DerivResult `json:"taproot_bip32_derivs,omitempty"`
+	TaprootInternalKey     string                        `json:"taproot_internal_key,omitempty"`
+	TaprootMerkleRoot      string                        `json:"taproot_merkle_root,omitempty"`
+	RequiredTimeLocktime   uint32                        `json:"required_time_locktime,omitempty"`
+	RequiredHeightLocktime uint32                        `json:"required_height_locktime,omitempty"`
+	Unknown                map[string]string             `json:"unknown,omitempty"`
+}
+
+// PsbtOutputResult models the data of an output in the data returned from the
+// decodepsbt command.
+type PsbtOutputResult struct {
+	RedeemScript       *PsbtScriptResult             `json:"redeem_script,omitempty"`
+	WitnessScript      *PsbtScriptResult             `json:"witness_script,omitempty"`
+	Bip32Derivs        []PsbtBip32DerivResult        `json:"bip32_derivs,omitempty"`
+	TaprootInternalKey string                        `json:"taproot_internal_key,omitempty"`
+	TaprootTree        []PsbtTaprootTreeLeafResult   `json:"taproot_tree,omitempty"`
+	TaprootBip32Derivs []PsbtTaprootBip32DerivResult `json:"taproot_bip32_derivs,omitempty"`
+	Unknown            map[string]string             `json:"unknown,omitempty"`
+}
+
+// DecodePsbtResult models the data returned from the decodepsbt command.
+//
+// The fee is only set when the amounts of all of the spent outputs are known.
+type DecodePsbtResult struct {
+	Tx          TxRawDecodeResult  `json:"tx"`
+	PsbtVersion uint32             `json:"psbt_version"`
+	Unknown     map[string]string  `json:"unknown"`
+	Inputs      []PsbtInputResult  `json:"inputs"`
+	Outputs     []PsbtOutputResult `json:"outputs"`
+	Fee         *float64           `json:"fee,omitempty"`
+}
+
+// FinalizePsbtResult models the data returned from the finalizepsbt command.
+type FinalizePsbtResult struct {
+	Psbt     string `json:"psbt,omitempty"`
+	Hex      string `json:"hex,omitempty"`
+	Complete bool   `json:"complete"`
+}
+
 // GetAddedNodeInfoResultAddr models the data of the addresses portion of the
 // getaddednodeinfo command.
 type GetAddedNodeInfoResultAddr struct {
@@ -278,6 +388,11 @@ type GetBlockTemplateResult struct {
 	// Witness commitment defined in BIP 0141.
 	DefaultWitnessCommitment string `json:"default_witness_commitment,omitempty"`
 
+	// Versionbits deployments from BIP 0009.
+	Rules       []string         `json:"rules"`
+	VbAvailable map[string]uint8 `json:"vbavailable"`
+	VbRequired  int64            `json:"vbrequired"`
+
 	// Optional long polling from BIP 0022.
 	LongPollID  string `json:"longpollid,omitempty"`
 	LongPollURI string `json:"longpolluri,omitempty"`
@@ -332,8 +447,65 @@ type GetMempoolEntryResult struct {
 // GetMempoolInfoResult models the data returned from the getmempoolinfo
 // command.
 type GetMempoolInfoResult struct {
-	Size  int64 `json:"size"`
-	Bytes int64 `json:"bytes"`
+	Size          int64   `json:"size"`
+	Bytes         int64   `json:"bytes"`
+	Usage         int64   `json:"usage"`
+	MaxMempool    int64   `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+}
+
+// LoadMempoolResult models the data returned from the loadmempool command.
+type LoadMempoolResult struct {
+	Succeeded    int `json:"succeeded"`
+	Failed       int `json:"failed"`
+	Expired      int `json:"expired"`
+	AlreadyThere int `json:"alreadythere"`
+}
+
+// SaveMempoolResult models the data returned from the savemempool command.
+type SaveMempoolResult struct {
+	Filename string `json:"filename"`
+}
+
+// MempoolAcceptFees models the fees of a transaction returned by the
+// testmempoolaccept and submitpackage commands.  The effective fee rate is the
+// one of the transactions in EffectiveIncludes as a whole, which are listed by
+// witness hash.
+type MempoolAcceptFees struct {
+	Base              float64  `json:"base"`
+	EffectiveFeeRate  float64  `json:"effective-feerate"`
+	EffectiveIncludes []string `json:"effective-includes"`
+}
+
+// TestMempoolAcceptResult models the data returned from the testmempoolaccept
+// command for each transaction.
+type TestMempoolAcceptResult struct {
+	Txid         string             `json:"txid"`
+	Wtxid        string             `json:"wtxid"`
+	PackageError string             `json:"package-error,omitempty"`
+	Allowed      bool               `json:"allowed"`
+	Vsize        int64              `json:"vsize,omitempty"`
+	Fees         *MempoolAcceptFees `json:"fees,omitempty"`
+	RejectReason string             `json:"reject-reason,omitempty"`
+}
+
+// SubmitPackageTxResult models the data returned from the submitpackage command
+// for each transaction.
+type SubmitPackageTxResult struct {
+	Txid       string             `json:"txid"`
+	OtherWtxid string             `json:"other-wtxid,omitempty"`
+	Vsize      int64              `json:"vsize,omitempty"`
+	Fees       *MempoolAcceptFees `json:"fees,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// SubmitPackageResult models the data returned from the submitpackage command.
+// The results of the transactions are keyed by witness hash.
+type SubmitPackageResult struct {
+	PackageMsg           string                            `json:"package_msg"`
+	TxResults            map[string]*SubmitPackageTxResult `json:"tx-results"`
+	ReplacedTransactions []string                          `json:"replaced-transactions"`
 }
 
 // NetworksResult models the networks data from the getnetworkinfo command.
@@ -404,6 +576,15 @@ type GetPeerInfoResult struct {
 	BanScore       int32   `json:"banscore"`
 	FeeFilter      int64   `json:"feefilter"`
 	SyncNode       bool    `json:"syncnode"`
+	Transport      string  `json:"transport_protocol_type"`
+	SessionID      string  `json:"session_id"`
+
+	BytesSentPerMsg map[string]uint64 `json:"bytessent_per_msg"`
+	BytesRecvPerMsg map[string]uint64 `json:"bytesrecv_per_msg"`
+	BlockBytesSent  uint64            `json:"blockbytessent"`
+	ProofBytesSent  uint64            `json:"proofbytessent"`
+	BlockBytesRecv  uint64            `json:"blockbytesrecv"`
+	ProofBytesRecv  uint64            `json:"proofbytesrecv"`
 }
 
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
@@ -419,6 +600,12 @@ type GetRawMempoolVerboseResult struct {
 	StartingPriority float64  `json:"startingpriority"`
 	CurrentPriority  float64  `json:"currentpriority"`
 	Depends          []string `json:"depends"`
+	AncestorCount    int64    `json:"ancestorcount"`
+	AncestorSize     int64    `json:"ancestorsize"`
+	AncestorFees     float64  `json:"ancestorfees"`
+	DescendantCount  int64    `json:"descendantcount"`
+	DescendantSize   int64    `json:"descendantsize"`
+	DescendantFees   float64  `json:"descendantfees"`
 }
 
 // ScriptPubKeyResult models the scriptPubKey data of a tx script.  It is
@@ -500,9 +687,27 @@ func (g *GetTxOutSetInfoResult) UnmarshalJSON(data []byte) error {
 
 // GetNetTotalsResult models the data returned from the getnettotals command.
 type GetNetTotalsResult struct {
-	TotalBytesRecv uint64 `json:"totalbytesrecv"`
-	TotalBytesSent uint64 `json:"totalbytessent"`
-	TimeMillis     int64  `json:"timemillis"`
+	TotalBytesRecv  uint64                    `json:"totalbytesrecv"`
+	TotalBytesSent  uint64                    `json:"totalbytessent"`
+	TimeMillis      int64                     `json:"timemillis"`
+	BytesSentPerMsg map[string]uint64         `json:"bytessent_per_msg"`
+	BytesRecvPerMsg map[string]uint64         `json:"bytesrecv_per_msg"`
+	BlockBytesSent  uint64                    `json:"blockbytessent"`
+	ProofBytesSent  uint64                    `json:"proofbytessent"`
+	BlockBytesRecv  uint64                    `json:"blockbytesrecv"`
+	ProofBytesRecv  uint64                    `json:"proofbytesrecv"`
+	UploadTarget    *GetNetTotalsUploadTarget `json:"uploadtarget"`
+}
+
+// GetNetTotalsUploadTarget models the state of the upload target returned as
+// part of the getnettotals command.
+type GetNetTotalsUploadTarget struct {
+	TimeFrame             int64  `json:"timeframe"`
+	Target                uint64 `json:"target"`
+	TargetReached         bool   `json:"target_reached"`
+	ServeHistoricalBlocks bool   `json:"serve_historical_blocks"`
+	BytesLeftInCycle      uint64 `json:"bytes_left_in_cycle"`
+	TimeLeftInCycle       int64  `json:"time_left_in_cycle"`
 }
 
 // ScriptSig models a signature script.  It is defined separately since it only
@@ -832,6 +1037,43 @@ type GetDescriptorInfoResult struct {
 // DeriveAddressesResult models the data from the deriveaddresses command.
 type DeriveAddressesResult []string
 
+// UtreexoProofResult models a utreexo accumulator proof of a set of leaves.
+type UtreexoProofResult struct {
+	Targets []uint64 `json:"targets"` // positions of the proven leaves
+	Hashes  []string `json:"hashes"`  // hashes of the proof, in the order of their positions
+}
+
+// ScanTxOutSetUnspent models an unspent output found by the scantxoutset
+// command.
+type ScanTxOutSetUnspent struct {
+	TxID         string              `json:"txid"`
+	Vout         uint32              `json:"vout"`
+	ScriptPubKey string              `json:"scriptPubKey"`
+	Desc         string              `json:"desc"`
+	Amount       float64             `json:"amount"`
+	Coinbase     bool                `json:"coinbase"`
+	Height       int32               `json:"height"`
+	Proof        *UtreexoProofResult `json:"proof,omitempty"` // only returned by utreexo compact state nodes
+}
+
+// ScanTxOutSetResult models the data from the scantxoutset command when a scan
+// is started.
+type ScanTxOutSetResult struct {
+	Success     bool                  `json:"success"`
+	TxOuts      int64                 `json:"txouts"`
+	Height      int32                 `json:"height"`
+	BestBlock   string                `json:"bestblock"`
+	Unspents    []ScanTxOutSetUnspent `json:"unspents"`
+	TotalAmount float64               `json:"total_amount"`
+	Roots       []string              `json:"roots,omitempty"` // only returned by utreexo compact state nodes
+}
+
+// ScanTxOutSetStatusResult models the data from the scantxoutset command when
+// the status of a scan is queried.
+type ScanTxOutSetStatusResult struct {
+	Progress float64 `json:"progress"`
+}
+
 // LoadWalletResult models the data from the loadwallet command
 type LoadWalletResult struct {
 	Name    string `json:"name"`
@@ -31,3 +31,26 @@ func DoubleHashH(b []byte) Hash {
 	first := sha256.Sum256(b)
 	return Hash(sha256.Sum256(first[:]))
 }
+
+// TaggedHash implements the tagged hash scheme described in BIP0340.  The
+// tag is hashed once and the result is prepended twice to the concatenation
+// of the passed messages before the final hash is computed:
+//
+//	sha256(sha256(tag) || sha256(tag) || msgs...)
+//
+// Tagged hashes ensure that hashes computed for one purpose can never collide
+// with hashes computed for another.
+func TaggedHash(tag []byte, msgs ...[]byte) *Hash {
+	shaTag := sha256.Sum256(tag)
+
+	h := sha256.New()
+	h.Write(shaTag[:])
+	h.Write(shaTag[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+
+	var hash Hash
+	copy(hash[:], h.Sum(nil))
+	return &hash
+}
@@ -134,3 +134,27 @@ func TestDoubleHashFuncs(t *testing.T) {
 		}
 	}
 }
+
+// TestTaggedHash ensures the TaggedHash function returns the expected BIP0340
+// style tagged hashes.
+func TestTaggedHash(t *testing.T) {
+	tests := []struct {
+		out  string
+		tag  string
+		msgs [][]byte
+	}{
+		{"c216d352f5818b7b4beacd4ae0a26fe888080823d2a598856661bcd54f1b3713", "BIP0340/challenge", nil},
+		{"a85b2107f791b26a84e7586c28cec7cb61202ed3d01944d832500f363782d675", "TapLeaf", [][]byte{{0xc0}, {0x01, 0x51}}},
+		{"f54e929445f199f3a9b0940b3415828924afebc6917215b2945068ccd17ab7f3", "bip324_ellswift_xonly_ecdh", [][]byte{[]byte("a"), []byte("b")}},
+	}
+
+	for _, test := range tests {
+		hash := TaggedHash([]byte(test.tag), test.msgs...)
+		h := fmt.Sprintf("%x", hash[:])
+		if h != test.out {
+			t.Errorf("TaggedHash(%q) = %s, want %s", test.tag, h,
+				test.out)
+			continue
+		}
+	}
+}
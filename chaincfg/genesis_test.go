@@ -281,3 +281,20 @@ var simNetGenesisBlockBytes = []byte{
 	0x8a, 0x4c, 0x70, 0x2b, 0x6b, 0xf1, 0x1d, 0x5f, /* |.Lp+k.._|*/
 	0xac, 0x00, 0x00, 0x00, 0x00, /* |.....|    */
 }
+
+// TestSigNetGenesisBlock tests the genesis block of the signet test network for
+// validity by checking its hash.
+func TestSigNetGenesisBlock(t *testing.T) {
+	want := "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"
+
+	hash := SigNetParams.GenesisBlock.BlockHash()
+	if !SigNetParams.GenesisHash.IsEqual(&hash) {
+		t.Fatalf("TestSigNetGenesisBlock: Genesis block hash does "+
+			"not appear valid - got %v, want %v", spew.Sdump(hash),
+			spew.Sdump(SigNetParams.GenesisHash))
+	}
+	if hash.String() != want {
+		t.Fatalf("TestSigNetGenesisBlock: unexpected genesis hash - "+
+			"got %v, want %v", hash, want)
+	}
+}
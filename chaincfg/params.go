@@ -42,6 +42,10 @@ var (
 	// simNetPowLimit is the highest proof of work value a Bitcoin block
 	// can have for the simulation test network.  It is the value 2^255 - 1.
 	simNetPowLimit = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne)
+
+	// sigNetPowLimit is the highest proof of work value a Bitcoin block can
+	// have for the signet test network.  It is the value 0x0377ae << 216.
+	sigNetPowLimit = new(big.Int).Lsh(big.NewInt(0x0377ae), 216)
 )
 
 // Checkpoint identifies a known good point in the block chain.  Using
@@ -213,6 +217,22 @@ type ConsensusDeployment struct {
 	// ExpireTime is the median block time after which the attempted
 	// deployment expires.
 	ExpireTime uint64
+
+	// MinActivationHeight is the height of the first block at which a
+	// locked in deployment may become active.  Deployments which lock in
+	// earlier remain locked in until the retarget window starting at or
+	// after this height.  This is part of the BIP0341 deployment mechanism
+	// known as speedy trial.
+	MinActivationHeight uint32
+
+	// CustomActivationThreshold overrides the RuleChangeActivationThreshold
+	// of the chain for this deployment when it is non-zero.
+	CustomActivationThreshold uint32
+
+	// AlwaysActive marks the deployment as active from the genesis block
+	// on without any signalling.  The other fields are ignored when it is
+	// set.
+	AlwaysActive bool
 }
 
 // Constants that define the deployment offset in the deployments field of the
@@ -233,6 +253,11 @@ const (
 	// includes the deployment of BIPS 141, 142, 144, 145, 147 and 173.
 	DeploymentSegwit
 
+	// DeploymentTaproot defines the rule change deployment ID for the
+	// Taproot soft-fork package.  The taproot package includes the
+	// deployment of BIPS 340, 341 and 342.
+	DeploymentTaproot
+
 	// NOTE: DefinedDeployments must always come last since it is used to
 	// determine how many defined deployments there currently are.
 
@@ -319,6 +344,13 @@ type Params struct {
 	// checked
 	AssumeValid *chainhash.Hash
 
+	// MinimumChainWork is the minimum amount of cumulative work a header
+	// chain must have before its headers are stored while syncing headers
+	// past the final checkpoint.  This prevents peers from wasting memory
+	// with long chains of low difficulty headers.  A nil value disables
+	// the threshold.
+	MinimumChainWork *big.Int
+
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
@@ -345,6 +377,12 @@ type Params struct {
 	// Mempool parameters
 	RelayNonStdTxs bool
 
+	// SignetChallenge is the script every block of a signet network, as
+	// defined by BIP0325, has to provide a valid solution for in its
+	// coinbase witness commitment.  It is nil for networks that are not a
+	// signet.
+	SignetChallenge []byte
+
 	// Human-readable part for Bech32 encoded segwit addresses, as defined
 	// in BIP 173.
 	Bech32HRPSegwit string
@@ -398,6 +436,8 @@ var MainNetParams = Params{
 
 	AssumeValid: newHashFromStr("0000000000000000000b9d2ec5a352ecba0592946514a92f14319dc2b367fc72"), // 654683
 
+	MinimumChainWork: newBigFromHex("00000000000000000000000000000000000000001533efd8d716a517fe2c5008"), // 654683
+
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: []Checkpoint{
 		{11111, newHashFromStr("0000000069e244f73d78e8fd29ba2fd2ed618bd6fa2ee92559f542fdb26e7c1d")},
@@ -454,6 +494,13 @@ var MainNetParams = Params{
 			StartTime:  1479168000, // November 15, 2016 UTC
 			ExpireTime: 1510704000, // November 15, 2017 UTC.
 		},
+		DeploymentTaproot: {
+			BitNumber:                 2,
+			StartTime:                 1619222400, // April 24th, 2021 UTC.
+			ExpireTime:                1628640000, // August 11th, 2021 UTC.
+			MinActivationHeight:       709632,
+			CustomActivationThreshold: 1815, // 90% of MinerConfirmationWindow
+		},
 	},
 
 	// Mempool parameters
@@ -530,6 +577,11 @@ var RegressionNetParams = Params{
 			StartTime:  0,             // Always available for vote
 			ExpireTime: math.MaxInt64, // Never expires.
 		},
+		DeploymentTaproot: {
+			BitNumber:  2,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires.
+		},
 	},
 
 	// Mempool parameters
@@ -586,6 +638,8 @@ var TestNet3Params = Params{
 
 	AssumeValid: newHashFromStr("000000000000006433d1efec504c53ca332b64963c425395515b01977bd7b3b0"), // 1864000
 
+	MinimumChainWork: newBigFromHex("0000000000000000000000000000000000000000000001db6ec4ac88cf2272c6"), // 1864000
+
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: []Checkpoint{
 		{546, newHashFromStr("000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70")},
@@ -1091,6 +1145,11 @@ var TestNet3Params = Params{
 			StartTime:  1462060800, // May 1, 2016 UTC
 			ExpireTime: 1493596800, // May 1, 2017 UTC.
 		},
+		DeploymentTaproot: {
+			BitNumber:  2,
+			StartTime:  1619222400, // April 24th, 2021 UTC.
+			ExpireTime: 1628640000, // August 11th, 2021 UTC.
+		},
 	},
 
 	// Mempool parameters
@@ -1171,6 +1230,11 @@ var SimNetParams = Params{
 			StartTime:  0,             // Always available for vote
 			ExpireTime: math.MaxInt64, // Never expires.
 		},
+		DeploymentTaproot: {
+			BitNumber:  2,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires.
+		},
 	},
 
 	// Mempool parameters
@@ -1196,6 +1260,113 @@ var SimNetParams = Params{
 	HDCoinType: 115, // ASCII for s
 }
 
+// sigNetDefaultChallenge is the challenge script of the public default signet.
+// It is a 1-of-2 bare multisig script.
+var sigNetDefaultChallenge = mustDecodeHex("512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be430210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae")
+
+// sigNetDefaultDNSSeeds are the seeds of the public default signet.
+var sigNetDefaultDNSSeeds = []DNSSeed{
+	{"seed.signet.bitcoin.sprovoost.nl", false},
+	{"178.128.221.177", false},
+}
+
+// SigNetParams defines the network parameters for the public default signet
+// test network as defined by BIP0325.  Custom signets with another challenge
+// script can be created with CustomSignetParams.
+var SigNetParams = CustomSignetParams(sigNetDefaultChallenge, sigNetDefaultDNSSeeds)
+
+// CustomSignetParams returns the network parameters for a signet test network
+// whose blocks have to be signed to satisfy the passed challenge script.  All
+// signets share the same genesis block and rules and only differ in their
+// challenge, which also determines the magic bytes of the network as defined
+// by BIP0325.  The returned parameters are not registered; it is up to the
+// caller to Register them if needed.
+func CustomSignetParams(challenge []byte, dnsSeeds []DNSSeed) Params {
+	// The magic of the network is made up of the first four bytes of the
+	// double sha256 of the serialized challenge script.
+	var buf bytes.Buffer
+	wire.WriteVarBytes(&buf, 0, challenge)
+	hash := chainhash.DoubleHashB(buf.Bytes())
+	net := wire.BitcoinNet(binary.LittleEndian.Uint32(hash[:4]))
+
+	return Params{
+		Name:        "signet",
+		Net:         net,
+		DefaultPort: "38333",
+		DNSSeeds:    dnsSeeds,
+
+		// Chain parameters
+		GenesisBlock:             &sigNetGenesisBlock,
+		GenesisHash:              &sigNetGenesisHash,
+		PowLimit:                 sigNetPowLimit,
+		PowLimitBits:             0x1e0377ae,
+		BIP0034Height:            1,
+		BIP0065Height:            1,
+		BIP0066Height:            1,
+		CoinbaseMaturity:         100,
+		SubsidyReductionInterval: 210000,
+		TargetTimespan:           time.Hour * 24 * 14, // 14 days
+		TargetTimePerBlock:       time.Minute * 10,    // 10 minutes
+		RetargetAdjustmentFactor: 4,                   // 25% less, 400% more
+		ReduceMinDifficulty:      false,
+		MinDiffReductionTime:     time.Minute * 20, // TargetTimePerBlock * 2
+		GenerateSupported:        true,
+
+		// Checkpoints ordered from oldest to newest.
+		Checkpoints: nil,
+
+		// Consensus rule change deployments.
+		//
+		// The miner confirmation window is defined as:
+		//   target proof of work timespan / target proof of work spacing
+		RuleChangeActivationThreshold: 1815, // 90% of MinerConfirmationWindow
+		MinerConfirmationWindow:       2016,
+		Deployments: [DefinedDeployments]ConsensusDeployment{
+			DeploymentTestDummy: {
+				BitNumber:  28,
+				StartTime:  0,             // Always available for vote
+				ExpireTime: math.MaxInt64, // Never expires
+			},
+			DeploymentCSV: {
+				BitNumber:    0,
+				AlwaysActive: true,
+			},
+			DeploymentSegwit: {
+				BitNumber:    1,
+				AlwaysActive: true,
+			},
+			DeploymentTaproot: {
+				BitNumber:    2,
+				AlwaysActive: true,
+			},
+		},
+
+		// Mempool parameters
+		RelayNonStdTxs: false,
+
+		SignetChallenge: challenge,
+
+		// Human-readable part for Bech32 encoded segwit addresses, as
+		// defined in BIP 173.
+		Bech32HRPSegwit: "tb", // always tb for test net
+
+		// Address encoding magics
+		PubKeyHashAddrID:        0x6f, // starts with m or n
+		ScriptHashAddrID:        0xc4, // starts with 2
+		WitnessPubKeyHashAddrID: 0x03, // starts with QW
+		WitnessScriptHashAddrID: 0x28, // starts with T7n
+		PrivateKeyID:            0xef, // starts with 9 (uncompressed) or c (compressed)
+
+		// BIP32 hierarchical deterministic extended key magics
+		HDPrivateKeyID: [4]byte{0x04, 0x35, 0x83, 0x94}, // starts with tprv
+		HDPublicKeyID:  [4]byte{0x04, 0x35, 0x87, 0xcf}, // starts with tpub
+
+		// BIP44 coin type used in the hierarchical deterministic path for
+		// address generation.
+		HDCoinType: 1,
+	}
+}
+
 var (
 	// ErrDuplicateNet describes an error where the parameters for a Bitcoin
 	// network could not be set due to the network already being a standard
@@ -1352,10 +1523,33 @@ func newHashFromStr(hexStr string) *chainhash.Hash {
 	return hash
 }
 
+// mustDecodeHex converts the passed hex string into bytes.  It panics on an
+// error since it will only (and must only) be called with hard-coded, and
+// therefore known good, values.
+func mustDecodeHex(hexStr string) []byte {
+	b, err := hex.DecodeString(hexStr)
+	if err != nil {
+		panic("invalid hex in source file: " + hexStr)
+	}
+	return b
+}
+
+// newBigFromHex converts the passed big-endian hex string into a big.Int.  It
+// panics on an error since it will only (and must only) be called with
+// hard-coded, and therefore known good, values.
+func newBigFromHex(hexStr string) *big.Int {
+	n, ok := new(big.Int).SetString(hexStr, 16)
+	if !ok {
+		panic("invalid hex in source file: " + hexStr)
+	}
+	return n
+}
+
 func init() {
 	// Register all default networks when the package is initialized.
 	mustRegister(&MainNetParams)
 	mustRegister(&TestNet3Params)
 	mustRegister(&RegressionNetParams)
 	mustRegister(&SimNetParams)
+	mustRegister(&SigNetParams)
 }
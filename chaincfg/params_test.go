@@ -7,6 +7,8 @@ package chaincfg
 import (
 	"bytes"
 	"testing"
+
+	"github.com/btcsuite/btcd/wire"
 )
 
 // TestInvalidHashStr ensures the newShaHashFromStr function panics when used to
@@ -20,6 +22,17 @@ func TestInvalidHashStr(t *testing.T) {
 	newHashFromStr("banana")
 }
 
+// TestInvalidBigHex ensures the newBigFromHex function panics when used with
+// an invalid hex string.
+func TestInvalidBigHex(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic for invalid hex, got nil")
+		}
+	}()
+	newBigFromHex("banana")
+}
+
 // TestMustRegisterPanic ensures the mustRegister function panics when used to
 // register an invalid network.
 func TestMustRegisterPanic(t *testing.T) {
@@ -84,3 +97,27 @@ func TestInvalidHDKeyID(t *testing.T) {
 		t.Fatalf("HDPrivateKeyToPublicKeyID: want err ErrUnknownHDKeyID, got %v", err)
 	}
 }
+
+// TestCustomSignetParams ensures the network magic of signets is derived from
+// their challenge script as defined by BIP0325.
+func TestCustomSignetParams(t *testing.T) {
+	if SigNetParams.Net != wire.SigNet {
+		t.Fatalf("unexpected default signet magic - got %v, want %v",
+			SigNetParams.Net, wire.SigNet)
+	}
+
+	// A custom signet must use its own magic and challenge while sharing
+	// the genesis block of the default signet.
+	challenge := []byte{0x51}
+	params := CustomSignetParams(challenge, nil)
+	if params.Net == wire.SigNet {
+		t.Fatalf("custom signet uses the default signet magic")
+	}
+	if !bytes.Equal(params.SignetChallenge, challenge) {
+		t.Fatalf("unexpected challenge - got %x, want %x",
+			params.SignetChallenge, challenge)
+	}
+	if !params.GenesisHash.IsEqual(SigNetParams.GenesisHash) {
+		t.Fatalf("custom signet uses a different genesis block")
+	}
+}
@@ -73,6 +73,11 @@ func TestRegister(t *testing.T) {
 					params: &SimNetParams,
 					err:    ErrDuplicateNet,
 				},
+				{
+					name:   "duplicate signet",
+					params: &SigNetParams,
+					err:    ErrDuplicateNet,
+				},
 			},
 			p2pkhMagics: []magicTest{
 				{
@@ -329,6 +334,11 @@ func TestRegister(t *testing.T) {
 					params: &SimNetParams,
 					err:    ErrDuplicateNet,
 				},
+				{
+					name:   "duplicate signet",
+					params: &SigNetParams,
+					err:    ErrDuplicateNet,
+				},
 				{
 					name:   "duplicate mocknet",
 					params: &mockNetParams,
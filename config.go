@@ -8,9 +8,11 @@ import (
 	"bufio"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"os"
 	"path/filepath"
@@ -21,6 +23,7 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/connmgr"
@@ -28,6 +31,7 @@ import (
 	_ "github.com/btcsuite/btcd/database/ffldb"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/go-socks/socks"
 	flags "github.com/jessevdk/go-flags"
@@ -40,15 +44,20 @@ const (
 	defaultLogDirname            = "logs"
 	defaultLogFilename           = "btcd.log"
 	defaultMaxPeers              = 125
+	defaultBlockRelayOnlyPeers   = 2
+	defaultMinUtreexoBridges     = 4
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
 	defaultConnectTimeout        = time.Second * 30
 	defaultMaxRPCClients         = 10
+	defaultStratumPort           = "3333"
+	defaultStratumDifficulty     = 1
 	defaultMaxRPCWebsockets      = 25
 	defaultMaxRPCConcurrentReqs  = 20
 	defaultDbType                = "ffldb"
 	defaultFreeTxRelayLimit      = 15.0
 	defaultTrickleInterval       = peer.DefaultTrickleInterval
+	defaultRebroadcastExpiry     = 7 * 24
 	defaultBlockMinSize          = 0
 	defaultBlockMaxSize          = 750000
 	defaultBlockMinWeight        = 0
@@ -61,6 +70,7 @@ const (
 	defaultMaxOrphanTransactions = 100
 	defaultMaxOrphanTxSize       = 100000
 	defaultSigCacheMaxSize       = 100000
+	defaultScriptCacheMaxSize    = 50000
 	defaultUtxoCacheMaxSizeMiB   = 250
 	sampleConfigFilename         = "sample-btcd.conf"
 	defaultTxIndex               = false
@@ -111,6 +121,7 @@ type config struct {
 	BlockMaxWeight       uint32        `long:"blockmaxweight" description:"Maximum block weight to be used when creating a block"`
 	BlockMinWeight       uint32        `long:"blockminweight" description:"Mininum block weight to be used when creating a block"`
 	BlockPrioritySize    uint32        `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	BlockRelayOnlyPeers  int           `long:"blockrelayonlypeers" description:"Number of additional outbound peers to connect to which only relay blocks"`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
 	ConfigFile           string        `short:"C" long:"configfile" description:"Path to configuration file"`
 	ConnectPeers         []string      `long:"connect" description:"Connect only to the specified peers at startup"`
@@ -127,10 +138,15 @@ type config struct {
 	FreeTxRelayLimit     float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
 	Listeners            []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
 	LogDir               string        `long:"logdir" description:"Directory to log output."`
+	MaxMempoolMB         uint          `long:"maxmempool" description:"Keep the estimated memory usage of the transaction memory pool under the given size in MB by evicting the transactions paying the lowest fees (0 = no limit)"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
 	MaxPeers             int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	MaxUploadTarget      uint64        `long:"maxuploadtarget" description:"Try to keep the data sent to peers under the given target in MiB per 24h -- historical blocks and ublocks are no longer served to peers that aren't whitelisted once it is reached (0 = no limit)"`
 	MiningAddrs          []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
+	MempoolExpiryHours   uint          `long:"mempoolexpiry" description:"Evict the transactions which have been in the memory pool for longer than the given number of hours (0 = never)"`
 	MinRelayTxFee        float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTC/kB to be considered a non-zero fee."`
+	MinUtreexoBridges    int           `long:"minutreexobridges" description:"Minimum number of outbound peers serving Utreexo proofs to keep when running as a Utreexo CSN -- other outbound full nodes are only accepted once this many are connected"`
+	MinimumChainWork     string        `long:"minimumchainwork" description:"Minimum cumulative work in hex a header chain must have before its headers are stored when syncing past the final checkpoint (default: network specific)"`
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	NoCFilters           bool          `long:"nocfilters" description:"Disable committed filtering (CF) support"`
 	DisableCheckpoints   bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
@@ -138,6 +154,7 @@ type config struct {
 	DisableListen        bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
 	NoOnion              bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
+	NoPersistMempool     bool          `long:"nopersistmempool" description:"Do not save the transaction memory pool to the data directory on shutdown and load it on startup"`
 	NoRelayPriority      bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
 	NoWinService         bool          `long:"nowinservice" description:"Do not start as a background service on Windows -- NOTE: This flag only works on the command line, not in the config file"`
 	DisableRPC           bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
@@ -145,11 +162,13 @@ type config struct {
 	OnionProxy           string        `long:"onion" description:"Connect to tor hidden services via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
 	OnionProxyPass       string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	OnionProxyUser       string        `long:"onionuser" description:"Username for onion proxy server"`
+	PersistSigCache      bool          `long:"persistsigcache" description:"Enable the signature verification cache, save it to the data directory on shutdown and load it on startup"`
 	Profile              string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	Proxy                string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
 	ProxyPass            string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
 	ProxyUser            string        `long:"proxyuser" description:"Username for proxy server"`
 	RegressionTest       bool          `long:"regtest" description:"Use the regression test network"`
+	RebroadcastExpiry    uint          `long:"rebroadcastexpiry" description:"Stop rebroadcasting the transactions submitted through the RPC server which haven't been mined after the given number of hours (0 = never)"`
 	RejectNonStd         bool          `long:"rejectnonstd" description:"Reject non-standard transactions regardless of the default settings for the active network."`
 	RejectReplacement    bool          `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
 	RelayNonStd          bool          `long:"relaynonstd" description:"Relay non-standard transactions regardless of the default settings for the active network."`
@@ -164,8 +183,16 @@ type config struct {
 	RPCQuirks            bool          `long:"rpcquirks" description:"Mirror some JSON-RPC quirks of Bitcoin Core -- NOTE: Discouraged unless interoperability issues need to be worked around"`
 	RPCPass              string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCUser              string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
+	ScriptCacheMaxSize   uint          `long:"scriptcachemaxsize" description:"The maximum number of entries in the cache of transactions whose scripts are known to be valid"`
 	SigCacheMaxSize      uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
+	SigNet               bool          `long:"signet" description:"Use the signet test network"`
+	SigNetChallenge      string        `long:"signetchallenge" description:"Hex encoded challenge script of a custom signet which blocks must satisfy -- Requires --signet"`
+	SigNetKeys           []string      `long:"signetkey" description:"Add the specified WIF private key to the list of keys used to sign generated blocks on a signet"`
+	SigNetSeedNodes      []string      `long:"signetseednode" description:"Use the specified seed instead of the default ones of the signet -- Requires --signet"`
 	SimNet               bool          `long:"simnet" description:"Use the simulation test network"`
+	StratumDifficulty    float64       `long:"stratumdiff" description:"The share difficulty of new Stratum mining connections"`
+	StratumListeners     []string      `long:"stratumlisten" description:"Add an interface/port to listen for Stratum mining connections (default port: 3333) -- The Stratum server is only started when at least one is specified and requires at least one mining address"`
+	StratumMinDifficulty float64       `long:"stratummindiff" description:"The minimum share difficulty the difficulty of Stratum mining connections is adjusted down to (default: the difficulty of new connections)"`
 	TestNet3             bool          `long:"testnet" description:"Use the test network"`
 	Utreexo              bool          `long:"utreexo" description:"Serve Utreexo Proofs"`
 	UtreexoInRam         bool          `long:"utreexoinram" description:"Whether to keep the Utreexo accumulator in ram or not"`
@@ -179,6 +206,7 @@ type config struct {
 	TorIsolation         bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
 	TrickleInterval      time.Duration `long:"trickleinterval" description:"Minimum time between attempts to send new inventory to a connected peer"`
 	UserAgentComments    []string      `long:"uacomment" description:"Comment to add to the user agent -- See BIP 14 for more information."`
+	V2Transport          bool          `long:"v2transport" description:"Use the BIP0324 v2 encrypted transport with peers that support it, falling back to the unencrypted v1 transport otherwise"`
 	UtxoCacheMaxSizeMiB  uint          `long:"utxocachemaxsize" description:"The maximum size in MiB of the UTXO cache"`
 	TTL                  bool          `long:"ttl" description:"Enable indexing of the time-to-live values for txos"`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
@@ -190,7 +218,9 @@ type config struct {
 	oniondial            func(string, string, time.Duration) (net.Conn, error)
 	dial                 func(string, string, time.Duration) (net.Conn, error)
 	addCheckpoints       []chaincfg.Checkpoint
+	minChainWork         *big.Int
 	miningAddrs          []btcutil.Address
+	signetKeys           []*btcec.PrivateKey
 	minRelayTxFee        btcutil.Amount
 	whitelists           []*net.IPNet
 }
@@ -429,6 +459,8 @@ func loadConfig() (*config, []string, error) {
 		ConfigFile:           defaultConfigFile,
 		DebugLevel:           defaultLogLevel,
 		MaxPeers:             defaultMaxPeers,
+		BlockRelayOnlyPeers:  defaultBlockRelayOnlyPeers,
+		MinUtreexoBridges:    defaultMinUtreexoBridges,
 		BanDuration:          defaultBanDuration,
 		BanThreshold:         defaultBanThreshold,
 		RPCMaxClients:        defaultMaxRPCClients,
@@ -448,9 +480,14 @@ func loadConfig() (*config, []string, error) {
 		BlockMaxWeight:       defaultBlockMaxWeight,
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
+		MaxMempoolMB:         mempool.DefaultMaxPoolSize / 1000 / 1000,
+		MempoolExpiryHours:   uint(mempool.DefaultExpiryTime / time.Hour),
+		RebroadcastExpiry:    defaultRebroadcastExpiry,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
+		ScriptCacheMaxSize:   defaultScriptCacheMaxSize,
 		UtxoCacheMaxSizeMiB:  defaultUtxoCacheMaxSizeMiB,
 		Generate:             defaultGenerate,
+		StratumDifficulty:    defaultStratumDifficulty,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
 	}
@@ -640,8 +677,12 @@ func loadConfig() (*config, []string, error) {
 		activeNetParams = &simNetParams
 		cfg.DisableDNSSeed = true
 	}
+	if cfg.SigNet {
+		numNets++
+		activeNetParams = &sigNetParams
+	}
 	if numNets > 1 {
-		str := "%s: The testnet, regtest, segnet, and simnet params " +
+		str := "%s: The testnet, regtest, simnet, and signet params " +
 			"can't be used together -- choose one of the four"
 		err := fmt.Errorf(str, funcName)
 		fmt.Fprintln(os.Stderr, err)
@@ -649,6 +690,61 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// A custom challenge makes up a signet of its own which has to be
+	// registered since its magic differs from the default one.
+	if !cfg.SigNet && (cfg.SigNetChallenge != "" ||
+		len(cfg.SigNetSeedNodes) > 0) {
+
+		str := "%s: the signetchallenge and signetseednode options " +
+			"require the signet option"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.SigNet && (cfg.SigNetChallenge != "" ||
+		len(cfg.SigNetSeedNodes) > 0) {
+
+		challenge := chaincfg.SigNetParams.SignetChallenge
+		if cfg.SigNetChallenge != "" {
+			challenge, err = hex.DecodeString(cfg.SigNetChallenge)
+			if err != nil || len(challenge) == 0 {
+				str := "%s: invalid signet challenge '%s'"
+				err := fmt.Errorf(str, funcName,
+					cfg.SigNetChallenge)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
+		}
+
+		dnsSeeds := chaincfg.SigNetParams.DNSSeeds
+		if len(cfg.SigNetSeedNodes) > 0 {
+			dnsSeeds = make([]chaincfg.DNSSeed, 0,
+				len(cfg.SigNetSeedNodes))
+			for _, seed := range cfg.SigNetSeedNodes {
+				dnsSeeds = append(dnsSeeds, chaincfg.DNSSeed{
+					Host: seed,
+				})
+			}
+		}
+
+		signetParams := chaincfg.CustomSignetParams(challenge, dnsSeeds)
+		if signetParams.Net != wire.SigNet {
+			err := chaincfg.Register(&signetParams)
+			if err != nil {
+				str := "%s: unable to register signet: %v"
+				err := fmt.Errorf(str, funcName, err)
+				fmt.Fprintln(os.Stderr, err)
+				return nil, nil, err
+			}
+		}
+		activeNetParams = &params{
+			Params:  &signetParams,
+			rpcPort: sigNetParams.rpcPort,
+		}
+	}
+
 	// Set the default policy for relaying non-standard transactions
 	// according to the default of the active network. The set
 	// configuration value takes precedence over the default value for the
@@ -734,6 +830,30 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Don't allow a negative number of block relay only peers.
+	if cfg.BlockRelayOnlyPeers < 0 {
+		str := "%s: The blockrelayonlypeers option may not be negative " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.BlockRelayOnlyPeers)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The minimum number of utreexo bridges must fit in the regular
+	// outbound peers.
+	if cfg.MinUtreexoBridges < 0 ||
+		cfg.MinUtreexoBridges > defaultTargetOutbound {
+
+		str := "%s: The minutreexobridges option must be between 0 " +
+			"and %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, defaultTargetOutbound,
+			cfg.MinUtreexoBridges)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Validate any given whitelisted IP addresses and networks.
 	if len(cfg.Whitelists) > 0 {
 		var ip net.IP
@@ -1021,6 +1141,20 @@ func loadConfig() (*config, []string, error) {
 		cfg.miningAddrs = append(cfg.miningAddrs, addr)
 	}
 
+	// Check the signet keys are valid and save parsed versions.
+	cfg.signetKeys = make([]*btcec.PrivateKey, 0, len(cfg.SigNetKeys))
+	for _, strKey := range cfg.SigNetKeys {
+		wif, err := btcutil.DecodeWIF(strKey)
+		if err != nil {
+			str := "%s: signet key failed to decode: %v"
+			err := fmt.Errorf(str, funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.signetKeys = append(cfg.signetKeys, wif.PrivKey)
+	}
+
 	// Ensure there is at least one mining address when the generate flag is
 	// set.
 	if cfg.Generate && len(cfg.MiningAddrs) == 0 {
@@ -1032,6 +1166,30 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure there is at least one mining address when the stratum server
+	// is enabled.  Signet blocks can't be mined through it since the
+	// signature of the blocks commits to their coinbase.
+	if len(cfg.StratumListeners) > 0 {
+		var str string
+		switch {
+		case len(cfg.MiningAddrs) == 0:
+			str = "%s: the stratumlisten option is set, but there " +
+				"are no mining addresses specified"
+		case cfg.SigNet:
+			str = "%s: the stratumlisten option is not supported " +
+				"on signet"
+		case cfg.StratumDifficulty <= 0 || cfg.StratumMinDifficulty < 0:
+			str = "%s: the stratumdiff and stratummindiff options " +
+				"must be positive"
+		}
+		if str != "" {
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
 	// Add default port to all listener addresses if needed and remove
 	// duplicate addresses.
 	cfg.Listeners = normalizeAddresses(cfg.Listeners,
@@ -1042,6 +1200,11 @@ func loadConfig() (*config, []string, error) {
 	cfg.RPCListeners = normalizeAddresses(cfg.RPCListeners,
 		activeNetParams.rpcPort)
 
+	// Add default port to all stratum listener addresses if needed and
+	// remove duplicate addresses.
+	cfg.StratumListeners = normalizeAddresses(cfg.StratumListeners,
+		defaultStratumPort)
+
 	// Only allow TLS to be disabled if the RPC is bound to localhost
 	// addresses.
 	if !cfg.DisableRPC && cfg.DisableTLS {
@@ -1098,6 +1261,20 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Parse the minimum chain work override.
+	if cfg.MinimumChainWork != "" {
+		minChainWork, ok := new(big.Int).SetString(cfg.MinimumChainWork, 16)
+		if !ok || minChainWork.Sign() < 0 {
+			str := "%s: The minimumchainwork option is not a valid " +
+				"hex number: %v"
+			err := fmt.Errorf(str, funcName, cfg.MinimumChainWork)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.minChainWork = minChainWork
+	}
+
 	// Tor stream isolation requires either proxy or onion proxy to be set.
 	if cfg.TorIsolation && cfg.Proxy == "" && cfg.OnionProxy == "" {
 		str := "%s: Tor stream isolation requires either proxy or " +
@@ -61,6 +61,11 @@ type ConnReq struct {
 	Addr      net.Addr
 	Permanent bool
 
+	// BlockRelayOnly marks the requests made for the outbound connections
+	// which only relay blocks.  Failed requests are replaced by requests
+	// of the same kind.
+	BlockRelayOnly bool
+
 	conn       net.Conn
 	state      ConnState
 	stateMtx   sync.RWMutex
@@ -124,6 +129,10 @@ type Config struct {
 	// maintain. Defaults to 8.
 	TargetOutbound uint32
 
+	// TargetBlockRelayOnly is the number of outbound network connections
+	// which only relay blocks to maintain in addition to TargetOutbound.
+	TargetBlockRelayOnly uint32
+
 	// RetryDuration is the duration to wait before retrying connection
 	// requests. Defaults to 5s.
 	RetryDuration time.Duration
@@ -211,10 +220,10 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 				"-- retrying connection in: %v", maxFailedAttempts,
 				cm.cfg.RetryDuration)
 			time.AfterFunc(cm.cfg.RetryDuration, func() {
-				cm.NewConnReq()
+				cm.newConnReq(c.BlockRelayOnly)
 			})
 		} else {
-			go cm.NewConnReq()
+			go cm.newConnReq(c.BlockRelayOnly)
 		}
 	}
 }
@@ -322,7 +331,9 @@ out:
 				// re added to the pending map, so that
 				// subsequent processing of connections and
 				// failures do not ignore the request.
-				if uint32(len(conns)) < cm.cfg.TargetOutbound ||
+				target := cm.cfg.TargetOutbound +
+					cm.cfg.TargetBlockRelayOnly
+				if uint32(len(conns)) < target ||
 					connReq.Permanent {
 
 					connReq.updateState(ConnPending)
@@ -359,6 +370,19 @@ out:
 // NewConnReq creates a new connection request and connects to the
 // corresponding address.
 func (cm *ConnManager) NewConnReq() {
+	cm.newConnReq(false)
+}
+
+// NewBlockRelayOnlyConnReq creates a new connection request for a connection
+// which only relays blocks and connects to the corresponding address.
+func (cm *ConnManager) NewBlockRelayOnlyConnReq() {
+	cm.newConnReq(true)
+}
+
+// newConnReq creates a new connection request, which is marked as being for a
+// block relay only connection when blockRelayOnly is set, and connects to the
+// corresponding address.
+func (cm *ConnManager) newConnReq(blockRelayOnly bool) {
 	if atomic.LoadInt32(&cm.stop) != 0 {
 		return
 	}
@@ -366,7 +390,7 @@ func (cm *ConnManager) NewConnReq() {
 		return
 	}
 
-	c := &ConnReq{}
+	c := &ConnReq{BlockRelayOnly: blockRelayOnly}
 	atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
 
 	// Submit a request of a pending connection attempt to the connection
@@ -529,6 +553,9 @@ func (cm *ConnManager) Start() {
 	for i := atomic.LoadUint64(&cm.connReqCount); i < uint64(cm.cfg.TargetOutbound); i++ {
 		go cm.NewConnReq()
 	}
+	for i := uint32(0); i < cm.cfg.TargetBlockRelayOnly; i++ {
+		go cm.NewBlockRelayOnlyConnReq()
+	}
 }
 
 // Wait blocks until the connection manager halts gracefully.
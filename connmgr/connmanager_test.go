@@ -216,6 +216,60 @@ func TestTargetOutbound(t *testing.T) {
 	cmgr.Stop()
 }
 
+// TestTargetBlockRelayOnly tests that the block relay only connections are
+// made in addition to the target outbound connections and that failed block
+// relay only connection requests are replaced by requests of the same kind.
+func TestTargetBlockRelayOnly(t *testing.T) {
+	targetOutbound := uint32(3)
+	targetBlockRelayOnly := uint32(2)
+
+	// Fail the first few dials so failed requests have to be replaced.
+	var dials uint32
+	dialer := func(addr net.Addr) (net.Conn, error) {
+		if atomic.AddUint32(&dials, 1) <= 4 {
+			return nil, errors.New("connection refused")
+		}
+		return mockDialer(addr)
+	}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound:       targetOutbound,
+		TargetBlockRelayOnly: targetBlockRelayOnly,
+		Dial:                 dialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+	var blockRelayOnly uint32
+	for i := uint32(0); i < targetOutbound+targetBlockRelayOnly; i++ {
+		if c := <-connected; c.BlockRelayOnly {
+			blockRelayOnly++
+		}
+	}
+	if blockRelayOnly != targetBlockRelayOnly {
+		t.Fatalf("block relay only: got %d connections, want %d",
+			blockRelayOnly, targetBlockRelayOnly)
+	}
+
+	select {
+	case c := <-connected:
+		t.Fatalf("block relay only: got unexpected connection - %v", c.Addr)
+	case <-time.After(time.Millisecond):
+		break
+	}
+	cmgr.Stop()
+}
+
 // TestRetryPermanent tests that permanent connection requests are retried.
 //
 // We make a permanent connection request using Connect, disconnect it using
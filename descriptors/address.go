@@ -0,0 +1,196 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+// The btcutil address types only cover version 0 witness programs, so the
+// addresses of later witness versions, which use the bech32m checksum defined
+// by BIP0350, are encoded and decoded here.
+
+const (
+	// bech32Charset is the character set of bech32 strings.
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	// bech32Const and bech32mConst are the constants the checksums of
+	// bech32 and bech32m strings are xored with.
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// bech32Polymod computes the bech32 checksum state of the passed human
+// readable part and 5-bit values.
+func bech32Polymod(hrp string, values []byte) uint32 {
+	gen := [5]uint32{
+		0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3,
+	}
+	chk := uint32(1)
+	feed := func(v byte) {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 != 0 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	for i := 0; i < len(hrp); i++ {
+		feed(hrp[i] >> 5)
+	}
+	feed(0)
+	for i := 0; i < len(hrp); i++ {
+		feed(hrp[i] & 31)
+	}
+	for _, v := range values {
+		feed(v)
+	}
+	return chk
+}
+
+// checksumConst returns the checksum constant used by addresses of the passed
+// witness version.
+func checksumConst(version byte) uint32 {
+	if version == 0 {
+		return bech32Const
+	}
+	return bech32mConst
+}
+
+// encodeSegWitAddress encodes the passed witness program as a segwit address
+// for the passed human readable part.
+func encodeSegWitAddress(hrp string, version byte, program []byte) (string, error) {
+	converted, err := bech32.ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	data := append([]byte{version}, converted...)
+
+	// Append the six checksum values to the data.
+	chk := bech32Polymod(hrp, append(data, make([]byte, 6)...)) ^
+		checksumConst(version)
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range data {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(chk>>uint(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// decodeSegWitAddress decodes the passed segwit address of the passed human
+// readable part into its witness version and program.
+func decodeSegWitAddress(hrp, addr string) (byte, []byte, error) {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return 0, nil, fmt.Errorf("address '%s' uses mixed case", addr)
+	}
+	addr = strings.ToLower(addr)
+
+	sep := strings.LastIndexByte(addr, '1')
+	if sep < 1 || addr[:sep] != hrp || len(addr)-sep-1 < 7 ||
+		len(addr) > 90 {
+
+		return 0, nil, fmt.Errorf("address '%s' is not a segwit "+
+			"address of the network", addr)
+	}
+
+	data := make([]byte, 0, len(addr)-sep-1)
+	for i := sep + 1; i < len(addr); i++ {
+		v := strings.IndexByte(bech32Charset, addr[i])
+		if v < 0 {
+			return 0, nil, fmt.Errorf("address '%s' contains "+
+				"invalid character %q", addr, addr[i])
+		}
+		data = append(data, byte(v))
+	}
+
+	version := data[0]
+	if bech32Polymod(hrp, data) != checksumConst(version) {
+		return 0, nil, fmt.Errorf("address '%s' has an invalid "+
+			"checksum", addr)
+	}
+
+	program, err := bech32.ConvertBits(data[1:len(data)-6], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > 16 || len(program) < 2 || len(program) > 40 ||
+		(version == 0 && len(program) != 20 && len(program) != 32) {
+
+		return 0, nil, fmt.Errorf("address '%s' has an invalid "+
+			"witness program", addr)
+	}
+	return version, program, nil
+}
+
+// witnessProgramScript returns the output script paying to the passed witness
+// program.
+func witnessProgramScript(version byte, program []byte) ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	if version == 0 {
+		builder.AddOp(txscript.OP_0)
+	} else {
+		builder.AddOp(txscript.OP_1 + version - 1)
+	}
+	return builder.AddData(program).Script()
+}
+
+// decodeAddressScript returns the output script paying to the passed address
+// of the passed network.
+func decodeAddressScript(addr string, params *chaincfg.Params) ([]byte, error) {
+	decoded, err := btcutil.DecodeAddress(addr, params)
+	if err == nil && decoded.IsForNet(params) {
+		return txscript.PayToAddrScript(decoded)
+	}
+
+	version, program, err := decodeSegWitAddress(
+		params.Bech32HRPSegwit, addr,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("address '%s' is not valid", addr)
+	}
+	return witnessProgramScript(version, program)
+}
+
+// scriptAddress returns the address of the passed output script on the passed
+// network.  ErrNoAddress is returned for scripts without an address, such as
+// bare multisig or pay-to-pubkey scripts.
+func scriptAddress(script []byte, params *chaincfg.Params) (string, error) {
+	switch txscript.GetScriptClass(script) {
+	case txscript.PubKeyHashTy, txscript.ScriptHashTy,
+		txscript.WitnessV0PubKeyHashTy, txscript.WitnessV0ScriptHashTy:
+
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, params)
+		if err != nil {
+			return "", err
+		}
+		if len(addrs) == 1 {
+			return addrs[0].EncodeAddress(), nil
+		}
+	}
+
+	if txscript.IsWitnessProgram(script) {
+		version, program, err := txscript.ExtractWitnessProgramInfo(script)
+		if err != nil {
+			return "", err
+		}
+		return encodeSegWitAddress(
+			params.Bech32HRPSegwit, byte(version), program,
+		)
+	}
+
+	return "", ErrNoAddress
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptors
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// inputCharset is the set of characters a descriptor may consist of.
+	// It is ordered so that the characters most likely to appear in a
+	// descriptor come first, which lets the checksum detect more errors
+	// in them.
+	inputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+	// checksumCharset is the set of characters the checksum is encoded
+	// with.  It is the same as the bech32 character set.
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	// checksumLength is the number of characters of a checksum.
+	checksumLength = 8
+)
+
+// checksumGenerator holds the generator of the BCH code the checksum is
+// computed with.
+var checksumGenerator = [5]uint64{
+	0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd,
+}
+
+// polymod feeds the passed 5-bit value into the checksum state c.
+func polymod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = (c&0x7ffffffff)<<5 ^ uint64(val)
+	for i := 0; i < 5; i++ {
+		if (c0>>uint(i))&1 != 0 {
+			c ^= checksumGenerator[i]
+		}
+	}
+	return c
+}
+
+// Checksum returns the checksum of the passed descriptor as defined by BIP0380.
+// The descriptor must not carry a checksum itself.
+func Checksum(desc string) (string, error) {
+	c := uint64(1)
+	cls, clsCount := 0, 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(inputCharset, ch)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q in descriptor",
+				ch)
+		}
+
+		// Emit a symbol for the position inside the group for every
+		// character, and a symbol for the group of every three
+		// characters.
+		c = polymod(c, pos&31)
+		cls = cls*3 + pos>>5
+		clsCount++
+		if clsCount == 3 {
+			c = polymod(c, cls)
+			cls, clsCount = 0, 0
+		}
+	}
+	if clsCount > 0 {
+		c = polymod(c, cls)
+	}
+
+	// Shift further to determine the checksum and prevent appending
+	// characters from leaving it unchanged.
+	for i := 0; i < checksumLength; i++ {
+		c = polymod(c, 0)
+	}
+	c ^= 1
+
+	var sb strings.Builder
+	for i := 0; i < checksumLength; i++ {
+		sb.WriteByte(checksumCharset[(c>>(5*uint(7-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// AddChecksum returns the passed descriptor with its checksum appended.
+func AddChecksum(desc string) (string, error) {
+	checksum, err := Checksum(desc)
+	if err != nil {
+		return "", err
+	}
+	return desc + "#" + checksum, nil
+}
+
+// splitChecksum splits the checksum off the passed descriptor and verifies it.
+// ErrMissingChecksum is returned if the descriptor has no checksum but
+// requireChecksum is set.
+func splitChecksum(desc string, requireChecksum bool) (string, error) {
+	idx := strings.IndexByte(desc, '#')
+	if idx < 0 {
+		if requireChecksum {
+			return "", ErrMissingChecksum
+		}
+		if _, err := Checksum(desc); err != nil {
+			return "", err
+		}
+		return desc, nil
+	}
+
+	payload, checksum := desc[:idx], desc[idx+1:]
+	if len(checksum) != checksumLength {
+		return "", fmt.Errorf("%w: expected %d characters, got %d",
+			ErrInvalidChecksum, checksumLength, len(checksum))
+	}
+	want, err := Checksum(payload)
+	if err != nil {
+		return "", err
+	}
+	if checksum != want {
+		return "", fmt.Errorf("%w: provided %s, expected %s",
+			ErrInvalidChecksum, checksum, want)
+	}
+	return payload, nil
+}
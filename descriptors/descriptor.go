@@ -0,0 +1,667 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// maxPubKeysPerMultiSig is the maximum number of keys of a multi() or
+	// sortedmulti() expression.
+	maxPubKeysPerMultiSig = txscript.MaxPubKeysPerMultiSig
+
+	// maxBareMultiSigKeys is the maximum number of keys of a multi() or
+	// sortedmulti() expression which is not nested in sh() or wsh().
+	maxBareMultiSigKeys = 3
+
+	// maxTapTreeDepth is the maximum depth of the script tree of a tr()
+	// expression.
+	maxTapTreeDepth = 128
+)
+
+var (
+	// ErrMissingChecksum is returned when a descriptor which is required
+	// to carry a checksum does not.
+	ErrMissingChecksum = errors.New("missing checksum")
+
+	// ErrInvalidChecksum is returned when the checksum of a descriptor
+	// does not match it.
+	ErrInvalidChecksum = errors.New("invalid checksum")
+
+	// ErrHardenedFromPublic is returned when a descriptor is expanded
+	// which requires hardened derivation from an extended public key.
+	ErrHardenedFromPublic = errors.New("cannot derive hardened key " +
+		"from public key")
+
+	// ErrNoAddress is returned when the address of a script which has no
+	// address form, such as a bare multisig script, is requested.
+	ErrNoAddress = errors.New("script has no address")
+)
+
+// scriptContext is the context a script expression is parsed in, which
+// restricts the expressions and keys that may appear.
+type scriptContext int
+
+const (
+	// ctxTop is the context of the outermost expression.
+	ctxTop scriptContext = iota
+
+	// ctxP2SH is the context of an expression nested in sh().
+	ctxP2SH
+
+	// ctxP2WSH is the context of an expression nested in wsh().
+	ctxP2WSH
+
+	// ctxP2TR is the context of the keys and leaf scripts of tr().
+	ctxP2TR
+)
+
+// allowsUncompressed returns whether uncompressed keys may be used in the
+// context.
+func (ctx scriptContext) allowsUncompressed() bool {
+	return ctx == ctxTop || ctx == ctxP2SH
+}
+
+// exprType identifies the kind of a script expression.
+type exprType int
+
+const (
+	exprPK exprType = iota
+	exprPKH
+	exprWPKH
+	exprSH
+	exprWSH
+	exprMulti
+	exprSortedMulti
+	exprTR
+	exprAddr
+	exprRaw
+)
+
+// exprNames maps the script expression types to the names they are written
+// with.
+var exprNames = map[exprType]string{
+	exprPK:          "pk",
+	exprPKH:         "pkh",
+	exprWPKH:        "wpkh",
+	exprSH:          "sh",
+	exprWSH:         "wsh",
+	exprMulti:       "multi",
+	exprSortedMulti: "sortedmulti",
+	exprTR:          "tr",
+	exprAddr:        "addr",
+	exprRaw:         "raw",
+}
+
+// scriptExpr is a parsed script expression.  Which fields are set depends on
+// its type.
+type scriptExpr struct {
+	typ exprType
+
+	// keys holds the keys of key based expressions, with the internal key
+	// of tr() expressions first.
+	keys []*keyExpr
+
+	// threshold is the number of required signatures of multisig
+	// expressions.
+	threshold int
+
+	// sub is the expression nested in sh() and wsh().
+	sub *scriptExpr
+
+	// tree is the script tree of tr() expressions, if any.
+	tree *tapTree
+
+	// rawScript is the script of addr() and raw() expressions and addr is
+	// the address of addr() expressions as given.
+	rawScript []byte
+	addr      string
+}
+
+// tapTree is a node of the script tree of a tr() expression.  It is either a
+// leaf holding a script expression or a branch with two children.
+type tapTree struct {
+	leaf        *scriptExpr
+	left, right *tapTree
+}
+
+// Descriptor is a parsed output script descriptor as defined by BIP0380
+// through BIP0386.  It describes a single output script or, when it contains
+// extended keys with a wildcard, a range of output scripts.
+type Descriptor struct {
+	expr   *scriptExpr
+	params *chaincfg.Params
+}
+
+// splitArgs splits the passed argument list at the commas which are not
+// nested in parentheses, brackets or braces.
+func splitArgs(args string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '(', '[', '{':
+			depth++
+
+		case ')', ']', '}':
+			depth--
+
+		case ',':
+			if depth == 0 {
+				parts = append(parts, args[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, args[start:])
+}
+
+// splitFunc splits the passed expression of the form name(args) into its name
+// and arguments.
+func splitFunc(expr string) (string, string, error) {
+	open := strings.IndexByte(expr, '(')
+	if open < 0 || !strings.HasSuffix(expr, ")") {
+		return "", "", fmt.Errorf("'%s' is not a valid script "+
+			"expression", expr)
+	}
+	return expr[:open], expr[open+1 : len(expr)-1], nil
+}
+
+// parseScriptExpr parses the passed script expression used in the passed
+// context.
+func parseScriptExpr(expr string, ctx scriptContext, params *chaincfg.Params) (*scriptExpr, error) {
+	name, args, err := splitFunc(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "pk":
+		key, err := parseKeyExpr(args, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return &scriptExpr{typ: exprPK, keys: []*keyExpr{key}}, nil
+
+	case "pkh":
+		if ctx == ctxP2TR {
+			break
+		}
+		key, err := parseKeyExpr(args, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return &scriptExpr{typ: exprPKH, keys: []*keyExpr{key}}, nil
+
+	case "wpkh":
+		if ctx != ctxTop && ctx != ctxP2SH {
+			return nil, fmt.Errorf("wpkh() is only allowed at top " +
+				"level or inside sh()")
+		}
+		key, err := parseKeyExpr(args, ctxP2WSH, params)
+		if err != nil {
+			return nil, err
+		}
+		return &scriptExpr{typ: exprWPKH, keys: []*keyExpr{key}}, nil
+
+	case "sh":
+		if ctx != ctxTop {
+			return nil, fmt.Errorf("sh() is only allowed at top level")
+		}
+		sub, err := parseScriptExpr(args, ctxP2SH, params)
+		if err != nil {
+			return nil, err
+		}
+		return &scriptExpr{typ: exprSH, sub: sub}, nil
+
+	case "wsh":
+		if ctx != ctxTop && ctx != ctxP2SH {
+			return nil, fmt.Errorf("wsh() is only allowed at top " +
+				"level or inside sh()")
+		}
+		sub, err := parseScriptExpr(args, ctxP2WSH, params)
+		if err != nil {
+			return nil, err
+		}
+		return &scriptExpr{typ: exprWSH, sub: sub}, nil
+
+	case "multi", "sortedmulti":
+		if ctx == ctxP2TR {
+			break
+		}
+		typ := exprMulti
+		if name == "sortedmulti" {
+			typ = exprSortedMulti
+		}
+		return parseMultiExpr(typ, splitArgs(args), ctx, params)
+
+	case "tr":
+		if ctx != ctxTop {
+			return nil, fmt.Errorf("tr() is only allowed at top level")
+		}
+		return parseTrExpr(splitArgs(args), params)
+
+	case "addr":
+		if ctx != ctxTop {
+			return nil, fmt.Errorf("addr() is only allowed at top " +
+				"level")
+		}
+		script, err := decodeAddressScript(args, params)
+		if err != nil {
+			return nil, err
+		}
+		return &scriptExpr{
+			typ: exprAddr, rawScript: script, addr: args,
+		}, nil
+
+	case "raw":
+		if ctx != ctxTop {
+			return nil, fmt.Errorf("raw() is only allowed at top level")
+		}
+		script, err := hex.DecodeString(args)
+		if err != nil {
+			return nil, fmt.Errorf("raw script '%s' is not hex", args)
+		}
+		return &scriptExpr{typ: exprRaw, rawScript: script}, nil
+
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid script expression",
+			expr)
+	}
+
+	return nil, fmt.Errorf("%s() is not allowed in tapscript", name)
+}
+
+// parseMultiExpr parses the arguments of a multi() or sortedmulti() expression
+// used in the passed context.
+func parseMultiExpr(typ exprType, args []string, ctx scriptContext,
+	params *chaincfg.Params) (*scriptExpr, error) {
+
+	threshold, err := strconv.Atoi(args[0])
+	if err != nil || strings.HasPrefix(args[0], "+") {
+		return nil, fmt.Errorf("multisig threshold '%s' is not valid",
+			args[0])
+	}
+
+	expr := &scriptExpr{typ: typ, threshold: threshold}
+	scriptSize := 0
+	for _, arg := range args[1:] {
+		key, err := parseKeyExpr(arg, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		expr.keys = append(expr.keys, key)
+
+		// Keys derived from extended keys are always compressed.
+		if key.extKey != nil || key.compressed {
+			scriptSize += 1 + 33
+		} else {
+			scriptSize += 1 + 65
+		}
+	}
+
+	numKeys := len(expr.keys)
+	switch {
+	case numKeys < 1 || numKeys > maxPubKeysPerMultiSig:
+		return nil, fmt.Errorf("cannot have %d keys in multisig; must "+
+			"have between 1 and %d keys", numKeys,
+			maxPubKeysPerMultiSig)
+
+	case threshold < 1 || threshold > numKeys:
+		return nil, fmt.Errorf("multisig threshold cannot be %d, must "+
+			"be between 1 and %d", threshold, numKeys)
+
+	case ctx == ctxTop && numKeys > maxBareMultiSigKeys:
+		return nil, fmt.Errorf("cannot have %d keys in bare multisig; "+
+			"only at most %d keys", numKeys, maxBareMultiSigKeys)
+
+	case ctx == ctxP2SH && scriptSize+3 > txscript.MaxScriptElementSize:
+		return nil, fmt.Errorf("P2SH script is too large, %d bytes is "+
+			"larger than %d bytes", scriptSize+3,
+			txscript.MaxScriptElementSize)
+	}
+
+	return expr, nil
+}
+
+// parseTrExpr parses the arguments of a tr() expression.
+func parseTrExpr(args []string, params *chaincfg.Params) (*scriptExpr, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("tr() takes at most two arguments")
+	}
+	key, err := parseKeyExpr(args[0], ctxP2TR, params)
+	if err != nil {
+		return nil, err
+	}
+
+	expr := &scriptExpr{typ: exprTR, keys: []*keyExpr{key}}
+	if len(args) == 2 {
+		expr.tree, err = parseTapTree(args[1], 0, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expr, nil
+}
+
+// parseTapTree parses the passed script tree of a tr() expression, which is
+// nested the passed depth into the tree.
+func parseTapTree(expr string, depth int, params *chaincfg.Params) (*tapTree, error) {
+	if !strings.HasPrefix(expr, "{") {
+		leaf, err := parseScriptExpr(expr, ctxP2TR, params)
+		if err != nil {
+			return nil, err
+		}
+		return &tapTree{leaf: leaf}, nil
+	}
+
+	if depth+1 > maxTapTreeDepth {
+		return nil, fmt.Errorf("tr() supports at most %d nesting "+
+			"levels", maxTapTreeDepth)
+	}
+	if !strings.HasSuffix(expr, "}") {
+		return nil, fmt.Errorf("'%s' is not a valid script tree", expr)
+	}
+	children := splitArgs(expr[1 : len(expr)-1])
+	if len(children) != 2 {
+		return nil, fmt.Errorf("script tree branch '%s' does not have "+
+			"exactly two children", expr)
+	}
+
+	left, err := parseTapTree(children[0], depth+1, params)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseTapTree(children[1], depth+1, params)
+	if err != nil {
+		return nil, err
+	}
+	return &tapTree{left: left, right: right}, nil
+}
+
+// Parse parses the passed output script descriptor for the passed network.  The
+// descriptor may carry a checksum, which is verified, and must carry one if
+// requireChecksum is set.
+func Parse(desc string, params *chaincfg.Params, requireChecksum bool) (*Descriptor, error) {
+	payload, err := splitChecksum(desc, requireChecksum)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := parseScriptExpr(payload, ctxTop, params)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{expr: expr, params: params}, nil
+}
+
+// walkKeys calls the passed function with all keys of the expression,
+// including those of nested expressions and script trees.
+func (e *scriptExpr) walkKeys(fn func(*keyExpr)) {
+	for _, key := range e.keys {
+		fn(key)
+	}
+	if e.sub != nil {
+		e.sub.walkKeys(fn)
+	}
+	if e.tree != nil {
+		e.tree.walkKeys(fn)
+	}
+}
+
+// walkKeys calls the passed function with all keys of the leaves of the tree.
+func (t *tapTree) walkKeys(fn func(*keyExpr)) {
+	if t.leaf != nil {
+		t.leaf.walkKeys(fn)
+		return
+	}
+	t.left.walkKeys(fn)
+	t.right.walkKeys(fn)
+}
+
+// String returns the expression with private keys replaced by their public
+// counterparts.
+func (e *scriptExpr) String() string {
+	switch e.typ {
+	case exprAddr:
+		return "addr(" + e.addr + ")"
+
+	case exprRaw:
+		return "raw(" + hex.EncodeToString(e.rawScript) + ")"
+	}
+
+	args := make([]string, 0, len(e.keys)+1)
+	if e.typ == exprMulti || e.typ == exprSortedMulti {
+		args = append(args, strconv.Itoa(e.threshold))
+	}
+	for _, key := range e.keys {
+		args = append(args, key.String())
+	}
+	if e.sub != nil {
+		args = append(args, e.sub.String())
+	}
+	if e.tree != nil {
+		args = append(args, e.tree.String())
+	}
+	return exprNames[e.typ] + "(" + strings.Join(args, ",") + ")"
+}
+
+// String returns the script tree with private keys replaced by their public
+// counterparts.
+func (t *tapTree) String() string {
+	if t.leaf != nil {
+		return t.leaf.String()
+	}
+	return "{" + t.left.String() + "," + t.right.String() + "}"
+}
+
+// script returns the script the expression stands for at the passed index.
+func (e *scriptExpr) script(index uint32) ([]byte, error) {
+	keys := make([][]byte, 0, len(e.keys))
+	for _, key := range e.keys {
+		pubKey, err := key.derive(index)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pubKey)
+	}
+
+	switch e.typ {
+	case exprPK:
+		return txscript.NewScriptBuilder().AddData(keys[0]).
+			AddOp(txscript.OP_CHECKSIG).Script()
+
+	case exprPKH:
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+			AddOp(txscript.OP_HASH160).
+			AddData(btcutil.Hash160(keys[0])).
+			AddOp(txscript.OP_EQUALVERIFY).
+			AddOp(txscript.OP_CHECKSIG).Script()
+
+	case exprWPKH:
+		return witnessProgramScript(0, btcutil.Hash160(keys[0]))
+
+	case exprSH:
+		sub, err := e.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).
+			AddData(btcutil.Hash160(sub)).
+			AddOp(txscript.OP_EQUAL).Script()
+
+	case exprWSH:
+		sub, err := e.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		hash := chainhash.HashB(sub)
+		return witnessProgramScript(0, hash)
+
+	case exprMulti, exprSortedMulti:
+		if e.typ == exprSortedMulti {
+			sort.Slice(keys, func(i, j int) bool {
+				return bytes.Compare(keys[i], keys[j]) < 0
+			})
+		}
+		builder := txscript.NewScriptBuilder().
+			AddInt64(int64(e.threshold))
+		for _, key := range keys {
+			builder.AddData(key)
+		}
+		return builder.AddInt64(int64(len(keys))).
+			AddOp(txscript.OP_CHECKMULTISIG).Script()
+
+	case exprTR:
+		internalKey, err := schnorr.ParsePubKey(xOnlyKey(keys[0]))
+		if err != nil {
+			return nil, err
+		}
+		var root []byte
+		if e.tree != nil {
+			hash, err := e.tree.hash(index)
+			if err != nil {
+				return nil, err
+			}
+			root = hash[:]
+		}
+		outputKey, err := txscript.ComputeTaprootOutputKey(
+			internalKey, root,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.PayToTaprootScript(outputKey)
+
+	default:
+		return e.rawScript, nil
+	}
+}
+
+// leafScript returns the tapscript the leaf expression stands for at the
+// passed index.  Keys in tapscript are pushed in their x-only form.
+func (e *scriptExpr) leafScript(index uint32) ([]byte, error) {
+	pubKey, err := e.keys[0].derive(index)
+	if err != nil {
+		return nil, err
+	}
+	return txscript.NewScriptBuilder().AddData(xOnlyKey(pubKey)).
+		AddOp(txscript.OP_CHECKSIG).Script()
+}
+
+// hash returns the tagged hash of the tree at the passed index as defined by
+// BIP0341.
+func (t *tapTree) hash(index uint32) (chainhash.Hash, error) {
+	if t.leaf != nil {
+		script, err := t.leaf.leafScript(index)
+		if err != nil {
+			return chainhash.Hash{}, err
+		}
+		return txscript.NewBaseTapLeaf(script).TapHash(), nil
+	}
+
+	left, err := t.left.hash(index)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	right, err := t.right.hash(index)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	// The children of a branch are hashed in lexicographic order.
+	l, r := left[:], right[:]
+	if bytes.Compare(l, r) > 0 {
+		l, r = r, l
+	}
+	return *chainhash.TaggedHash([]byte("TapBranch"), l, r), nil
+}
+
+// String returns the descriptor along with its checksum.  Private keys are
+// replaced by their public counterparts.
+func (d *Descriptor) String() string {
+	// The descriptor only consists of valid characters, so computing the
+	// checksum can't fail.
+	desc, _ := AddChecksum(d.expr.String())
+	return desc
+}
+
+// IsRange returns whether the descriptor contains extended keys with a
+// wildcard and therefore describes a range of output scripts.
+func (d *Descriptor) IsRange() bool {
+	isRange := false
+	d.expr.walkKeys(func(key *keyExpr) {
+		isRange = isRange || key.isRange()
+	})
+	return isRange
+}
+
+// IsSolvable returns whether the descriptor holds the information needed to
+// spend its outputs given the private keys, which is the case for all
+// descriptors but addr() and raw().
+func (d *Descriptor) IsSolvable() bool {
+	return d.expr.typ != exprAddr && d.expr.typ != exprRaw
+}
+
+// HasPrivateKeys returns whether the descriptor contains at least one private
+// key.
+func (d *Descriptor) HasPrivateKeys() bool {
+	hasPrivateKeys := false
+	d.expr.walkKeys(func(key *keyExpr) {
+		hasPrivateKeys = hasPrivateKeys || key.hasPrivateKey()
+	})
+	return hasPrivateKeys
+}
+
+// Script returns the output script the descriptor describes at the passed
+// index.  The index is ignored by descriptors which are not ranged.
+func (d *Descriptor) Script(index uint32) ([]byte, error) {
+	return d.expr.script(index)
+}
+
+// Expand returns the output scripts the descriptor describes at the indices
+// from start through end inclusive.
+func (d *Descriptor) Expand(start, end uint32) ([][]byte, error) {
+	if start > end {
+		return nil, fmt.Errorf("range start %d is greater than range "+
+			"end %d", start, end)
+	}
+
+	scripts := make([][]byte, 0, end-start+1)
+	for index := start; ; index++ {
+		script, err := d.Script(index)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+		if index == end {
+			break
+		}
+	}
+	return scripts, nil
+}
+
+// Address returns the address of the output script the descriptor describes
+// at the passed index.  ErrNoAddress is returned if the script has no address
+// form.
+func (d *Descriptor) Address(index uint32) (string, error) {
+	script, err := d.Script(index)
+	if err != nil {
+		return "", err
+	}
+	return scriptAddress(script, d.params)
+}
@@ -0,0 +1,383 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptors
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+const (
+	// testXprv is the master key of the seed of the mnemonic "abandon
+	// abandon ... about" used by the test vectors of BIP0084 and BIP0086.
+	testXprv = "xprv9s21ZrQH143K3GJpoapnV8SFfukcVBSfeCficPSGfubmSFDxo1kuHnLi" +
+		"sriDvSnRRuL2Qrg5ggqHKNVpxR86QEC8w35uxmGoggxtQTPvfUu"
+
+	// testXpub is the public counterpart of testXprv.
+	testXpub = "xpub661MyMwAqRbcFkPHucMnrGNzDwb6teAX1RbKQmqtEF8kK3Z7LZ59qafC" +
+		"jB9eCRLiTVG3uxBxgKvRgbubRhqSKXnGGb1aoaqLrpMBDrVxga8"
+
+	// testPubKey is the compressed public key of the private key 1.
+	testPubKey = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b1" +
+		"6f81798"
+
+	// testUncompressedPubKey is the uncompressed public key of the
+	// private key 1.
+	testUncompressedPubKey = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce" +
+		"28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a6" +
+		"8554199c47d08ffb10d4b8"
+)
+
+// The keys of the test vectors of BIP0383 and BIP0386.  The expected scripts
+// of the vectors using them were checked against an independent implementation
+// of the derivation, sorting and tap tree hashing.
+const (
+	bip383KeyA = "022f8bde4d1a07209355b4a7250a5c5128e88b84bddc619ab7cba8d5" +
+		"69b240efe4"
+	bip383KeyB = "025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bdd" +
+		"edcac4f9bc"
+
+	bip386Key = "a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c" +
+		"540c5bd"
+	bip386LeafKey = "669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec" +
+		"52421adbd0"
+	bip386Xpub = "xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgb" +
+		"mJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL"
+)
+
+// TestChecksum ensures descriptor checksums are computed and verified as
+// defined by BIP0380.
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		desc    string
+		require bool
+		err     error
+		valid   bool
+	}{
+		{desc: "raw(deadbeef)#89f8spxm", require: true, valid: true},
+		{desc: "raw(deadbeef)", require: false, valid: true},
+		{desc: "raw(deadbeef)", require: true, err: ErrMissingChecksum},
+		{desc: "raw(deadbeef)#", err: ErrInvalidChecksum},
+		{desc: "raw(deadbeef)#89f8spxmx", err: ErrInvalidChecksum},
+		{desc: "raw(deadbeef)#89f8spx", err: ErrInvalidChecksum},
+		{desc: "raw(deadbeef)#89f8spxn", err: ErrInvalidChecksum},
+		{desc: "raw(deedbeef)#89f8spxm", err: ErrInvalidChecksum},
+		{desc: "raw(Ü)#00000000"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.desc, &chaincfg.MainNetParams, test.require)
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected error", test.desc)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.desc, test.err,
+				err)
+		}
+	}
+
+	desc, err := AddChecksum("raw(deadbeef)")
+	if err != nil || desc != "raw(deadbeef)#89f8spxm" {
+		t.Errorf("AddChecksum: got %s, %v", desc, err)
+	}
+}
+
+// TestDescriptorAddresses ensures descriptors expand to the expected scripts
+// and addresses.
+func TestDescriptorAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		desc   string
+		index  uint32
+		script string
+		addr   string
+	}{{
+		name:  "BIP0044 pkh",
+		desc:  "pkh(" + testXprv + "/44'/0'/0'/0/*)",
+		addr:  "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA",
+		index: 0,
+	}, {
+		name:  "BIP0084 wpkh",
+		desc:  "wpkh(" + testXprv + "/84h/0h/0h/0/*)",
+		addr:  "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu",
+		index: 0,
+	}, {
+		name: "BIP0086 tr",
+		desc: "tr(" + testXprv + "/86'/0'/0'/0/*)",
+		addr: "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwud" +
+			"pxqkedrcr",
+		index: 0,
+	}, {
+		name:   "pk",
+		desc:   "pk(" + testPubKey + ")",
+		script: "21" + testPubKey + "ac",
+	}, {
+		name: "pkh",
+		desc: "pkh(" + testPubKey + ")",
+		script: "76a914751e76e8199196d454941c45d1b3a323f1433bd688" +
+			"ac",
+		addr: "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH",
+	}, {
+		name: "pkh uncompressed",
+		desc: "pkh(" + testUncompressedPubKey + ")",
+		addr: "1EHNa6Q4Jz2uvNExL497mE43ikXhwF6kZm",
+	}, {
+		name:   "wpkh",
+		desc:   "wpkh(" + testPubKey + ")",
+		script: "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		addr:   "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+	}, {
+		name: "sh wpkh",
+		desc: "sh(wpkh(" + testPubKey + "))",
+		addr: "3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN",
+	}, {
+		name:   "multi",
+		desc:   "multi(1," + testPubKey + ")",
+		script: "5121" + testPubKey + "51ae",
+	}, {
+		name:   "raw",
+		desc:   "raw(deadbeef)",
+		script: "deadbeef",
+	}, {
+		name: "addr",
+		desc: "addr(bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqj" +
+			"jwudpxqkedrcr)",
+		script: "5120a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1" +
+			"dc6880949dc684c",
+		addr: "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwud" +
+			"pxqkedrcr",
+	}, {
+		name:   "BIP0383 sortedmulti",
+		desc:   "sortedmulti(1," + bip383KeyA + "," + bip383KeyB + ")",
+		script: "5121" + bip383KeyA + "21" + bip383KeyB + "52ae",
+	}, {
+		name:   "BIP0383 sortedmulti reordered keys",
+		desc:   "sortedmulti(1," + bip383KeyB + "," + bip383KeyA + ")",
+		script: "5121" + bip383KeyA + "21" + bip383KeyB + "52ae",
+	}, {
+		name: "BIP0383 sh sortedmulti",
+		desc: "sh(sortedmulti(2,03acd484e2f0c7f65309ad178a9f559abde09796" +
+			"974c57e714c35f110dfc27ccbe,022f01e5e15cca351daff3843fb70" +
+			"f3c2f0a1bdd05e5af888a67784ef3e10a2a01))",
+		script: "a914a6a8b030a38762f4c1f5cbe387b61a3c5da5cd2687",
+		addr:   "3GtEB3yg3r5de2cDJG48SkQwxfxJumKQdN",
+	}, {
+		name:  "wsh sortedmulti of derived keys",
+		desc:  "wsh(sortedmulti(1," + testXpub + "/0/*," + testXpub + "/1/*))",
+		index: 1,
+		script: "00209d07828c3b9ff00d2fa7cc2592de925f9bd2807f90897c7b2991" +
+			"a898eb39ec5a",
+		addr: "bc1qn5rc9rpmnlcq6ta8esje9h5jt7da9qrljzyhc7efjx5f36eea3d" +
+			"qadmzc7",
+	}, {
+		name: "BIP0386 tr",
+		desc: "tr(" + bip386Key + ")",
+		script: "512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d" +
+			"7a970a093f11",
+		addr: "bc1pw74tdcrxlzn5r8z6ku2vztr86fgq0m245s72mjktf4afwzsf8ugs" +
+			"0gs8zu",
+	}, {
+		name: "BIP0386 tr private key",
+		desc: "tr(L4rK1yDtCWekvXuE6oXD9jCYfFNV2cWRpVuPLBcCU2z8TrisoyY1)",
+		script: "512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d" +
+			"7a970a093f11",
+		addr: "bc1pw74tdcrxlzn5r8z6ku2vztr86fgq0m245s72mjktf4afwzsf8ugs" +
+			"0gs8zu",
+	}, {
+		name: "BIP0386 tr script tree",
+		desc: "tr(" + bip386Key + ",{pk(" + bip386LeafKey + "),{pk(" +
+			bip386Xpub + "/0/0/*),pk(" + testPubKey + ")}})",
+		index: 1,
+		script: "512011cf6833dd84d9cba1fddd5592a0dd1f154ac0f6b2145acebfc4" +
+			"8f23f956f80f",
+		addr: "bc1pz88ksv7asnvuhg0am42e9gxaru254s8kkg294n4lcj8j872klq8s" +
+			"hkgyfx",
+	}, {
+		name: "tr script tree",
+		desc: "tr(" + bip386Key + ",{pk(" + bip386LeafKey + "),pk(" +
+			testPubKey + ")})",
+		script: "51204f7a11a36e31cbe9410d54e23dd7c74017c99a5acd280c0048f3" +
+			"2e54ba899fdb",
+		addr: "bc1pfaaprgmwx897jsgd2n3rm478gqtunxj6e55qcqzg7vh9fw5fnlds" +
+			"t74ln4",
+	}, {
+		name: "tr script tree reordered leaves",
+		desc: "tr(" + bip386Key + ",{pk(" + testPubKey + "),pk(" +
+			bip386LeafKey + ")})",
+		script: "51204f7a11a36e31cbe9410d54e23dd7c74017c99a5acd280c0048f3" +
+			"2e54ba899fdb",
+		addr: "bc1pfaaprgmwx897jsgd2n3rm478gqtunxj6e55qcqzg7vh9fw5fnlds" +
+			"t74ln4",
+	}}
+
+	for _, test := range tests {
+		desc, err := Parse(test.desc, &chaincfg.MainNetParams, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		script, err := desc.Script(test.index)
+		if err != nil {
+			t.Errorf("%s: unable to derive script: %v", test.name, err)
+			continue
+		}
+		if test.script != "" && hex.EncodeToString(script) != test.script {
+			t.Errorf("%s: got script %x, want %s", test.name, script,
+				test.script)
+		}
+
+		addr, err := desc.Address(test.index)
+		switch {
+		case test.addr == "" && err != ErrNoAddress:
+			t.Errorf("%s: expected ErrNoAddress, got %s, %v",
+				test.name, addr, err)
+
+		case test.addr != "" && addr != test.addr:
+			t.Errorf("%s: got address %s, %v, want %s", test.name,
+				addr, err, test.addr)
+		}
+	}
+}
+
+// TestDescriptorProperties ensures the canonical form and properties of
+// descriptors are reported correctly.
+func TestDescriptorProperties(t *testing.T) {
+	tests := []struct {
+		desc           string
+		canonical      string
+		isRange        bool
+		isSolvable     bool
+		hasPrivateKeys bool
+	}{{
+		desc:           "wpkh([d34db33f/84h/0h/0h]" + testXprv + "/0/*)",
+		canonical:      "wpkh([d34db33f/84h/0h/0h]" + testXpub + "/0/*)",
+		isRange:        true,
+		isSolvable:     true,
+		hasPrivateKeys: true,
+	}, {
+		desc:       "sh(wsh(sortedmulti(1," + testXpub + "/1/*'," + testPubKey + ")))",
+		canonical:  "sh(wsh(sortedmulti(1," + testXpub + "/1/*'," + testPubKey + ")))",
+		isRange:    true,
+		isSolvable: true,
+	}, {
+		desc:       "tr(" + testPubKey[2:] + ",{pk(" + testPubKey + "),pk(" + testXpub + "/0)})",
+		canonical:  "tr(" + testPubKey[2:] + ",{pk(" + testPubKey + "),pk(" + testXpub + "/0)})",
+		isSolvable: true,
+	}, {
+		desc:      "raw(deadbeef)",
+		canonical: "raw(deadbeef)",
+	}}
+
+	for _, test := range tests {
+		desc, err := Parse(test.desc, &chaincfg.MainNetParams, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+			continue
+		}
+		want, _ := AddChecksum(test.canonical)
+		if desc.String() != want {
+			t.Errorf("%s: got canonical form %s, want %s", test.desc,
+				desc.String(), want)
+		}
+		if desc.IsRange() != test.isRange {
+			t.Errorf("%s: got isrange %v", test.desc, desc.IsRange())
+		}
+		if desc.IsSolvable() != test.isSolvable {
+			t.Errorf("%s: got issolvable %v", test.desc,
+				desc.IsSolvable())
+		}
+		if desc.HasPrivateKeys() != test.hasPrivateKeys {
+			t.Errorf("%s: got hasprivatekeys %v", test.desc,
+				desc.HasPrivateKeys())
+		}
+	}
+}
+
+// TestExpand ensures ranged descriptors expand to distinct scripts and that
+// hardened derivation from public keys is reported.
+func TestExpand(t *testing.T) {
+	desc, err := Parse("wpkh("+testXpub+"/0/*)", &chaincfg.MainNetParams,
+		false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	scripts, err := desc.Expand(2, 4)
+	if err != nil {
+		t.Fatalf("unable to expand: %v", err)
+	}
+	if len(scripts) != 3 {
+		t.Fatalf("got %d scripts, want 3", len(scripts))
+	}
+	for i, script := range scripts {
+		want, err := desc.Script(uint32(i + 2))
+		if err != nil {
+			t.Fatalf("unable to derive script: %v", err)
+		}
+		if hex.EncodeToString(script) != hex.EncodeToString(want) {
+			t.Errorf("script %d: got %x, want %x", i, script, want)
+		}
+	}
+	if hex.EncodeToString(scripts[0]) == hex.EncodeToString(scripts[1]) {
+		t.Errorf("ranged descriptor expanded to identical scripts")
+	}
+
+	desc, err = Parse("wpkh("+testXpub+"/0h/*)", &chaincfg.MainNetParams,
+		false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := desc.Expand(0, 1); err != ErrHardenedFromPublic {
+		t.Errorf("expected ErrHardenedFromPublic, got %v", err)
+	}
+}
+
+// TestParseErrors ensures invalid descriptors are rejected.
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		desc string
+	}{
+		{"unknown function", "foo(" + testPubKey + ")"},
+		{"missing parenthesis", "pkh(" + testPubKey},
+		{"invalid key", "pkh(02deadbeef)"},
+		{"key path on constant key", "pkh(" + testPubKey + "/0)"},
+		{"wrong network", "pkh(tpubD6NzVbkrYhZ4WaWSyoBvQwbpLkojyoTZPRsgXELWz3Popb3qkjcJyJUGLnL4qHHoQvao8ESaAstxYSnhyswJ76uZPStJRJCTKvosUCJZL5B/0)"},
+		{"path out of range", "pkh(" + testXpub + "/2147483648)"},
+		{"bad fingerprint", "pkh([d34db3/0]" + testPubKey + ")"},
+		{"unterminated origin", "pkh([d34db33f/0" + testPubKey + ")"},
+		{"uncompressed wpkh", "wpkh(" + testUncompressedPubKey + ")"},
+		{"uncompressed wsh", "wsh(pk(" + testUncompressedPubKey + "))"},
+		{"nested sh", "sh(sh(pk(" + testPubKey + ")))"},
+		{"wpkh in wsh", "wsh(wpkh(" + testPubKey + "))"},
+		{"nested tr", "sh(tr(" + testPubKey + "))"},
+		{"nested raw", "sh(raw(deadbeef))"},
+		{"multisig threshold zero", "multi(0," + testPubKey + ")"},
+		{"multisig threshold too big", "multi(2," + testPubKey + ")"},
+		{"bare multisig with 4 keys", "multi(1," + strings.Repeat(testPubKey+",", 3) + testPubKey + ")"},
+		{"multisig with 21 keys", "wsh(multi(1," + strings.Repeat(testPubKey+",", 20) + testPubKey + "))"},
+		{"p2sh multisig too large", "sh(multi(1," + strings.Repeat(testPubKey+",", 15) + testPubKey + "))"},
+		{"multi in tapscript", "tr(" + testPubKey + ",multi(1," + testPubKey + "))"},
+		{"uneven script tree", "tr(" + testPubKey + ",{pk(" + testPubKey + ")})"},
+		{"addr of other network", "addr(tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx)"},
+		{"invalid raw", "raw(deadbeefz)"},
+	}
+
+	for _, test := range tests {
+		if _, err := Parse(test.desc, &chaincfg.MainNetParams, false); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptors
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// wildcard describes whether and how the last step of the derivation path of
+// an extended key is left open to be filled in with the index a descriptor is
+// expanded at.
+type wildcard int
+
+const (
+	// noWildcard indicates the derivation path is fully specified.
+	noWildcard wildcard = iota
+
+	// unhardenedWildcard indicates the index is derived unhardened, as in
+	// /*.
+	unhardenedWildcard
+
+	// hardenedWildcard indicates the index is derived hardened, as in
+	// /*' or /*h.
+	hardenedWildcard
+)
+
+// keyOrigin describes the origin of a key as the fingerprint of the master
+// key it was derived from along with the derivation path.
+type keyOrigin struct {
+	fingerprint [4]byte
+	path        []uint32
+}
+
+// keyExpr is a parsed key expression.  It is either a constant public or
+// private key or an extended key along with a derivation path.
+type keyExpr struct {
+	origin *keyOrigin
+
+	// pubKey is set for constant keys.  compressed tells how the key is
+	// serialized and xOnly is set when only the x coordinate of the key was
+	// given inside tr().
+	pubKey     *btcec.PublicKey
+	compressed bool
+	xOnly      bool
+
+	// privKey is set if the key was given as a WIF private key.
+	privKey *btcutil.WIF
+
+	// extKey is set for extended keys, which are derived along path and
+	// the index the descriptor is expanded at if wc is a wildcard.
+	extKey *hdkeychain.ExtendedKey
+	path   []uint32
+	wc     wildcard
+
+	// apostrophe tells whether hardened derivation steps are written with
+	// an apostrophe rather than an h.
+	apostrophe bool
+}
+
+// parsePath parses the passed slash separated derivation path elements.  The
+// second return value tells whether an apostrophe was used to mark hardened
+// steps.
+func parsePath(elems []string) ([]uint32, bool, error) {
+	path := make([]uint32, 0, len(elems))
+	apostrophe := false
+	for _, elem := range elems {
+		hardened := false
+		switch {
+		case strings.HasSuffix(elem, "'"):
+			hardened, apostrophe = true, true
+			elem = elem[:len(elem)-1]
+
+		case strings.HasSuffix(elem, "h"):
+			hardened = true
+			elem = elem[:len(elem)-1]
+		}
+
+		idx, err := strconv.ParseUint(elem, 10, 32)
+		if err != nil || idx >= hdkeychain.HardenedKeyStart ||
+			strings.HasPrefix(elem, "+") {
+
+			return nil, false, fmt.Errorf("key path value '%s' is "+
+				"out of range", elem)
+		}
+		if hardened {
+			idx += hdkeychain.HardenedKeyStart
+		}
+		path = append(path, uint32(idx))
+	}
+	return path, apostrophe, nil
+}
+
+// formatPath returns the string form of the passed derivation path with each
+// step prefixed by a slash.
+func formatPath(path []uint32, apostrophe bool) string {
+	var sb strings.Builder
+	for _, idx := range path {
+		sb.WriteByte('/')
+		if idx >= hdkeychain.HardenedKeyStart {
+			sb.WriteString(strconv.FormatUint(
+				uint64(idx-hdkeychain.HardenedKeyStart), 10,
+			))
+			if apostrophe {
+				sb.WriteByte('\'')
+			} else {
+				sb.WriteByte('h')
+			}
+			continue
+		}
+		sb.WriteString(strconv.FormatUint(uint64(idx), 10))
+	}
+	return sb.String()
+}
+
+// parseKeyExpr parses the passed key expression used in the passed context.
+func parseKeyExpr(expr string, ctx scriptContext, params *chaincfg.Params) (*keyExpr, error) {
+	key := &keyExpr{}
+
+	// The key may be prefixed with its origin enclosed in brackets.
+	if strings.HasPrefix(expr, "[") {
+		end := strings.IndexByte(expr, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("key origin start '[' character " +
+				"without matching ']' character")
+		}
+		elems := strings.Split(expr[1:end], "/")
+		if len(elems[0]) != 8 {
+			return nil, fmt.Errorf("fingerprint is not 4 bytes (%d "+
+				"characters instead of 8 characters)",
+				len(elems[0]))
+		}
+		fingerprint, err := hex.DecodeString(elems[0])
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint '%s' is not hex",
+				elems[0])
+		}
+		path, apostrophe, err := parsePath(elems[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		key.origin = &keyOrigin{path: path}
+		copy(key.origin.fingerprint[:], fingerprint)
+		key.apostrophe = apostrophe
+		expr = expr[end+1:]
+	}
+	if strings.ContainsAny(expr, "[]") {
+		return nil, fmt.Errorf("multiple key origins are not allowed")
+	}
+
+	// Anything but an extended key is a constant key which can't be
+	// followed by a derivation path.
+	elems := strings.Split(expr, "/")
+	extKey, err := hdkeychain.NewKeyFromString(elems[0])
+	if err != nil && len(elems) == 1 {
+		if err := key.parseConstKey(expr, ctx, params); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil || !extKey.IsForNet(params) {
+		return nil, fmt.Errorf("key '%s' is not valid", elems[0])
+	}
+	key.extKey = extKey
+
+	// The last step of the path may be a wildcard.
+	elems = elems[1:]
+	if len(elems) == 0 {
+		return key, nil
+	}
+	switch elems[len(elems)-1] {
+	case "*":
+		key.wc = unhardenedWildcard
+		elems = elems[:len(elems)-1]
+
+	case "*'":
+		key.wc = hardenedWildcard
+		key.apostrophe = true
+		elems = elems[:len(elems)-1]
+
+	case "*h":
+		key.wc = hardenedWildcard
+		elems = elems[:len(elems)-1]
+	}
+	path, apostrophe, err := parsePath(elems)
+	if err != nil {
+		return nil, err
+	}
+	key.path = path
+	key.apostrophe = key.apostrophe || apostrophe
+
+	return key, nil
+}
+
+// parseConstKey parses the passed hex encoded public key or WIF encoded private
+// key into the key expression.
+func (k *keyExpr) parseConstKey(expr string, ctx scriptContext, params *chaincfg.Params) error {
+	if data, err := hex.DecodeString(expr); err == nil {
+		if ctx == ctxP2TR && len(data) == schnorr.PubKeyBytesLen {
+			pubKey, err := schnorr.ParsePubKey(data)
+			if err != nil {
+				return fmt.Errorf("pubkey '%s' is invalid", expr)
+			}
+			k.pubKey, k.compressed, k.xOnly = pubKey, true, true
+			return nil
+		}
+
+		pubKey, err := btcec.ParsePubKey(data, btcec.S256())
+		if err != nil || (len(data) != btcec.PubKeyBytesLenCompressed &&
+			len(data) != btcec.PubKeyBytesLenUncompressed) {
+
+			return fmt.Errorf("pubkey '%s' is invalid", expr)
+		}
+		k.pubKey = pubKey
+		k.compressed = len(data) == btcec.PubKeyBytesLenCompressed
+		if !k.compressed && !ctx.allowsUncompressed() {
+			return fmt.Errorf("uncompressed keys are not allowed")
+		}
+		return nil
+	}
+
+	wif, err := btcutil.DecodeWIF(expr)
+	if err != nil || !wif.IsForNet(params) {
+		return fmt.Errorf("key '%s' is not valid", expr)
+	}
+	if !wif.CompressPubKey && !ctx.allowsUncompressed() {
+		return fmt.Errorf("uncompressed keys are not allowed")
+	}
+	k.privKey = wif
+	k.pubKey = wif.PrivKey.PubKey()
+	k.compressed = wif.CompressPubKey
+	k.xOnly = ctx == ctxP2TR
+	return nil
+}
+
+// isRange returns whether the key depends on the index the descriptor is
+// expanded at.
+func (k *keyExpr) isRange() bool {
+	return k.wc != noWildcard
+}
+
+// hasPrivateKey returns whether the key was given as a private key.
+func (k *keyExpr) hasPrivateKey() bool {
+	return k.privKey != nil || (k.extKey != nil && k.extKey.IsPrivate())
+}
+
+// String returns the key expression with private keys replaced by their
+// public counterparts.
+func (k *keyExpr) String() string {
+	var sb strings.Builder
+	if k.origin != nil {
+		sb.WriteByte('[')
+		sb.WriteString(hex.EncodeToString(k.origin.fingerprint[:]))
+		sb.WriteString(formatPath(k.origin.path, k.apostrophe))
+		sb.WriteByte(']')
+	}
+
+	if k.extKey == nil {
+		sb.WriteString(hex.EncodeToString(k.serialize()))
+		return sb.String()
+	}
+
+	extKey := k.extKey
+	if extKey.IsPrivate() {
+		// Neutering only fails for keys of unregistered networks,
+		// which are rejected when parsing.
+		extKey, _ = extKey.Neuter()
+	}
+	sb.WriteString(extKey.String())
+	sb.WriteString(formatPath(k.path, k.apostrophe))
+	switch k.wc {
+	case unhardenedWildcard:
+		sb.WriteString("/*")
+
+	case hardenedWildcard:
+		if k.apostrophe {
+			sb.WriteString("/*'")
+		} else {
+			sb.WriteString("/*h")
+		}
+	}
+	return sb.String()
+}
+
+// serialize returns the serialized form of a constant key.
+func (k *keyExpr) serialize() []byte {
+	switch {
+	case k.xOnly:
+		return schnorr.SerializePubKey(k.pubKey)
+
+	case k.compressed:
+		return k.pubKey.SerializeCompressed()
+
+	default:
+		return k.pubKey.SerializeUncompressed()
+	}
+}
+
+// derive returns the serialized public key the key expression stands for at
+// the passed index.  Keys derived from extended keys are always compressed.
+func (k *keyExpr) derive(index uint32) ([]byte, error) {
+	if k.extKey == nil {
+		return k.serialize(), nil
+	}
+
+	path := k.path
+	switch k.wc {
+	case unhardenedWildcard:
+		path = append(path[:len(path):len(path)], index)
+
+	case hardenedWildcard:
+		path = append(path[:len(path):len(path)],
+			index+hdkeychain.HardenedKeyStart)
+	}
+
+	extKey := k.extKey
+	for _, idx := range path {
+		var err error
+		extKey, err = extKey.Derive(idx)
+		if err == hdkeychain.ErrDeriveHardFromPublic {
+			return nil, ErrHardenedFromPublic
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pubKey, err := extKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.SerializeCompressed(), nil
+}
+
+// xOnlyKey returns the x-only form of the passed serialized public key.
+func xOnlyKey(pubKey []byte) []byte {
+	if len(pubKey) == schnorr.PubKeyBytesLen {
+		return pubKey
+	}
+	return pubKey[1 : schnorr.PubKeyBytesLen+1]
+}
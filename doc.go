@@ -74,6 +74,10 @@ Application Options:
                               (default all interfaces port: 8333, testnet:
                               18333)
       --logdir=               Directory to log output
+      --maxmempool=           Keep the estimated memory usage of the
+                              transaction memory pool under the given size in
+                              MB by evicting the transactions paying the lowest
+                              fees (0 = no limit) (default: 300)
       --maxorphantx=          Max number of orphan transactions to keep in
                               memory (default: 100)
       --maxpeers=             Max number of inbound and outbound peers
@@ -82,6 +86,9 @@ Application Options:
                               addresses to use for generated blocks -- At least
                               one address is required if the generate option is
                               set
+      --mempoolexpiry=        Evict the transactions which have been in the
+                              memory pool for longer than the given number of
+                              hours (0 = never) (default: 336)
       --minrelaytxfee=        The minimum transaction fee in BTC/kB to be
                               considered a non-zero fee. (default: 1e-05)
       --nobanning             Disable banning of misbehaving peers
@@ -95,6 +102,8 @@ Application Options:
                               also specifying listen interfaces via --listen
       --noonion               Disable connecting to tor hidden services
       --nopeerbloomfilters    Disable bloom filtering support
+      --nopersistmempool      Do not save the transaction memory pool to the
+                              data directory on shutdown and load it on startup
       --norelaypriority       Do not require free or low-fee transactions to
                               have high priority for relaying
       --norpc                 Disable built-in RPC server -- NOTE: The RPC
@@ -108,12 +117,19 @@ Application Options:
                               (eg. 127.0.0.1:9050)
       --onionpass=            Password for onion proxy server
       --onionuser=            Username for onion proxy server
+      --persistsigcache       Enable the signature verification cache, save it
+                              to the data directory on shutdown and load it on
+                              startup
       --profile=              Enable HTTP profiling on given port -- NOTE port
                               must be between 1024 and 65536
       --proxy=                Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)
       --proxypass=            Password for proxy server
       --proxyuser=            Username for proxy server
       --regtest               Use the regression test network
+      --rebroadcastexpiry=    Stop rebroadcasting the transactions submitted
+                              through the RPC server which haven't been mined
+                              after the given number of hours (0 = never)
+                              (default: 168)
       --rejectnonstd          Reject non-standard transactions regardless of
                               the default settings for the active network.
       --relaynonstd           Relay non-standard transactions regardless of the
@@ -135,9 +151,29 @@ Application Options:
                               need to be worked around
   -P, --rpcpass=              Password for RPC connections
   -u, --rpcuser=              Username for RPC connections
+      --scriptcachemaxsize=   The maximum number of entries in the cache of
+                              transactions whose scripts are known to be valid
+                              (default: 50000)
       --sigcachemaxsize=      The maximum number of entries in the signature
                               verification cache (default: 100000)
+      --signet                Use the signet test network
+      --signetchallenge=      Hex encoded challenge script of a custom signet
+                              which blocks must satisfy -- Requires --signet
+      --signetkey=            Add the specified WIF private key to the list of
+                              keys used to sign generated blocks on a signet
+      --signetseednode=       Use the specified seed instead of the default
+                              ones of the signet -- Requires --signet
       --simnet                Use the simulation test network
+      --stratumdiff=          The share difficulty of new Stratum mining
+                              connections (default: 1)
+      --stratumlisten=        Add an interface/port to listen for Stratum
+                              mining connections (default port: 3333) -- The
+                              Stratum server is only started when at least one
+                              is specified and requires at least one mining
+                              address
+      --stratummindiff=       The minimum share difficulty the difficulty of
+                              Stratum mining connections is adjusted down to
+                              (default: the difficulty of new connections)
       --testnet               Use the test network
       --torisolation          Enable Tor stream isolation by randomizing user
                               credentials for each connection.
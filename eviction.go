@@ -0,0 +1,180 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// evictProtectNetGroups is the number of inbound peers with distinct
+	// network groups that are protected from eviction.  The groups are
+	// chosen using a secret key so an attacker can't predict which ones
+	// are protected.
+	evictProtectNetGroups = 4
+
+	// evictProtectPing is the number of inbound peers with the lowest
+	// minimum ping time that are protected from eviction.
+	evictProtectPing = 8
+
+	// evictProtectTx is the number of inbound peers that most recently
+	// relayed a new transaction which are protected from eviction.
+	evictProtectTx = 4
+
+	// evictProtectBlock is the number of inbound peers that most recently
+	// relayed a new block which are protected from eviction.
+	evictProtectBlock = 4
+)
+
+// evictionCandidate houses the details of an inbound peer which are used to
+// choose a peer to evict when the server is full.
+type evictionCandidate struct {
+	id            int32
+	timeConnected time.Time
+	minPingMicros int64
+	lastBlockTime int64
+	lastTxTime    int64
+	relayTxs      bool
+	netGroup      string
+	keyedNetGroup uint64
+}
+
+// newEvictionCandidate returns the eviction candidate for the passed inbound
+// peer.  The network group key is used to derive the keyed network group.
+func newEvictionCandidate(sp *serverPeer, netGroupKey []byte) *evictionCandidate {
+	netGroup := addrmgr.GroupKey(sp.NA())
+	keyed := chainhash.HashB(append(append([]byte{}, netGroupKey...),
+		netGroup...))
+	return &evictionCandidate{
+		id:            sp.ID(),
+		timeConnected: sp.TimeConnected(),
+		minPingMicros: sp.MinPingMicros(),
+		lastBlockTime: atomic.LoadInt64(&sp.lastBlockTime),
+		lastTxTime:    atomic.LoadInt64(&sp.lastTxTime),
+		relayTxs:      !sp.relayTxDisabled(),
+		netGroup:      netGroup,
+		keyedNetGroup: binary.LittleEndian.Uint64(keyed),
+	}
+}
+
+// protectCandidates removes up to the passed number of candidates which sort
+// first according to the passed less function and returns the remaining ones.
+func protectCandidates(candidates []*evictionCandidate, n int,
+	less func(a, b *evictionCandidate) bool) []*evictionCandidate {
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	return candidates[n:]
+}
+
+// selectPeerToEvict returns the inbound peer to evict in order to make room for
+// a new peer, or nil when all of the candidates are protected.
+//
+// Peers are protected from eviction based on characteristics that are hard for
+// an attacker to forge in bulk so that a flood of connections can't push all
+// honest peers out: a diverse set of network groups, low latency, recent relay
+// of new transactions and blocks, and long uptime.  Among the remaining peers,
+// the youngest one in the network group with the most connections is evicted.
+func selectPeerToEvict(candidates []*evictionCandidate) *evictionCandidate {
+	remaining := make([]*evictionCandidate, len(candidates))
+	copy(remaining, candidates)
+
+	// Older connections are protected first when all else is equal.
+	older := func(a, b *evictionCandidate) bool {
+		if !a.timeConnected.Equal(b.timeConnected) {
+			return a.timeConnected.Before(b.timeConnected)
+		}
+		return a.id < b.id
+	}
+
+	// Protect the peers with the highest keyed network groups.
+	remaining = protectCandidates(remaining, evictProtectNetGroups,
+		func(a, b *evictionCandidate) bool {
+			if a.keyedNetGroup != b.keyedNetGroup {
+				return a.keyedNetGroup > b.keyedNetGroup
+			}
+			return older(a, b)
+		})
+
+	// Protect the peers with the lowest minimum ping time.  Peers which
+	// have not answered a ping yet sort last.
+	pingTime := func(c *evictionCandidate) int64 {
+		if c.minPingMicros == 0 {
+			return math.MaxInt64
+		}
+		return c.minPingMicros
+	}
+	remaining = protectCandidates(remaining, evictProtectPing,
+		func(a, b *evictionCandidate) bool {
+			if pingTime(a) != pingTime(b) {
+				return pingTime(a) < pingTime(b)
+			}
+			return older(a, b)
+		})
+
+	// Protect the peers which most recently relayed new transactions,
+	// favoring peers that relay transactions at all.
+	remaining = protectCandidates(remaining, evictProtectTx,
+		func(a, b *evictionCandidate) bool {
+			if a.lastTxTime != b.lastTxTime {
+				return a.lastTxTime > b.lastTxTime
+			}
+			if a.relayTxs != b.relayTxs {
+				return a.relayTxs
+			}
+			return older(a, b)
+		})
+
+	// Protect the peers which most recently relayed new blocks.
+	remaining = protectCandidates(remaining, evictProtectBlock,
+		func(a, b *evictionCandidate) bool {
+			if a.lastBlockTime != b.lastBlockTime {
+				return a.lastBlockTime > b.lastBlockTime
+			}
+			return older(a, b)
+		})
+
+	// Protect half of the remaining peers which have been connected the
+	// longest.
+	remaining = protectCandidates(remaining, len(remaining)/2, older)
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	// Find the network group with the most connections.  Ties are broken
+	// in favor of the group with the youngest connection.  The remaining
+	// peers are sorted from old to young, so the last peer of a group is
+	// its youngest one.
+	groups := make(map[string][]*evictionCandidate)
+	for _, c := range remaining {
+		groups[c.netGroup] = append(groups[c.netGroup], c)
+	}
+	var evictGroup []*evictionCandidate
+	for _, group := range groups {
+		if evictGroup == nil || len(group) > len(evictGroup) {
+			evictGroup = group
+			continue
+		}
+		if len(group) == len(evictGroup) &&
+			older(evictGroup[len(evictGroup)-1], group[len(group)-1]) {
+
+			evictGroup = group
+		}
+	}
+
+	// Evict the youngest peer of the group.
+	return evictGroup[len(evictGroup)-1]
+}
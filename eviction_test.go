@@ -0,0 +1,160 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// makeEvictionCandidates returns the passed number of eviction candidates which
+// are all in distinct network groups and connected one minute apart with the
+// first candidate being the oldest.
+func makeEvictionCandidates(num int) []*evictionCandidate {
+	start := time.Unix(1600000000, 0)
+	candidates := make([]*evictionCandidate, 0, num)
+	for i := 0; i < num; i++ {
+		candidates = append(candidates, &evictionCandidate{
+			id:            int32(i),
+			timeConnected: start.Add(time.Duration(i) * time.Minute),
+			relayTxs:      true,
+			netGroup:      fmt.Sprintf("group%d", i),
+			keyedNetGroup: uint64(i) * 7919 % 101,
+		})
+	}
+	return candidates
+}
+
+// evictAll repeatedly evicts candidates until all remaining ones are protected
+// and returns the ids of the evicted candidates in order.
+func evictAll(candidates []*evictionCandidate) []int32 {
+	var evicted []int32
+	for {
+		c := selectPeerToEvict(candidates)
+		if c == nil {
+			return evicted
+		}
+		evicted = append(evicted, c.id)
+		for i := range candidates {
+			if candidates[i] == c {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// TestSelectPeerToEvictProtected ensures no peer is evicted when all of them
+// are protected.
+func TestSelectPeerToEvictProtected(t *testing.T) {
+	t.Parallel()
+
+	numProtected := evictProtectNetGroups + evictProtectPing +
+		evictProtectTx + evictProtectBlock
+	for num := 0; num <= numProtected; num++ {
+		c := selectPeerToEvict(makeEvictionCandidates(num))
+		if c != nil {
+			t.Fatalf("%d candidates: unexpected eviction of %d", num,
+				c.id)
+		}
+	}
+
+	// Once there are more candidates than protected by the specific
+	// characteristics, half of the remaining ones are protected by uptime.
+	candidates := makeEvictionCandidates(numProtected + 2)
+	if c := selectPeerToEvict(candidates); c == nil {
+		t.Fatalf("expected a peer to be evicted")
+	}
+}
+
+// TestSelectPeerToEvictProtections ensures peers with low ping times and recent
+// relay of new transactions and blocks are never evicted.
+func TestSelectPeerToEvictProtections(t *testing.T) {
+	t.Parallel()
+
+	const num = 100
+	candidates := makeEvictionCandidates(num)
+	protected := make(map[int32]string)
+
+	// The youngest peers are the preferred eviction targets, so give the
+	// protecting characteristics to them.
+	for i := 0; i < evictProtectPing; i++ {
+		c := candidates[num-1-i]
+		c.minPingMicros = int64(100 + i)
+		protected[c.id] = "ping"
+	}
+	for i := 0; i < evictProtectTx; i++ {
+		c := candidates[num-1-evictProtectPing-i]
+		c.lastTxTime = int64(1600000000 + i)
+		protected[c.id] = "tx relay"
+	}
+	for i := 0; i < evictProtectBlock; i++ {
+		c := candidates[num-1-evictProtectPing-evictProtectTx-i]
+		c.lastBlockTime = int64(1600000000 + i)
+		protected[c.id] = "block relay"
+	}
+
+	// All other peers have a slow ping time.
+	for _, c := range candidates {
+		if c.minPingMicros == 0 {
+			c.minPingMicros = 1000000
+		}
+	}
+
+	// The youngest peer which isn't protected is evicted first.
+	c := selectPeerToEvict(candidates)
+	want := int32(num - 1 - evictProtectPing - evictProtectTx -
+		evictProtectBlock)
+	if c == nil || c.id != want {
+		t.Fatalf("evicted peer %v, want %d", c, want)
+	}
+
+	evicted := evictAll(candidates)
+	for _, id := range evicted {
+		if reason, ok := protected[id]; ok {
+			t.Fatalf("evicted peer %d protected by %s", id, reason)
+		}
+	}
+}
+
+// TestSelectPeerToEvictNetGroup ensures the youngest peer of the network group
+// with the most connections is evicted so a flood of connections from a single
+// network group can't push out peers from other groups.
+func TestSelectPeerToEvictNetGroup(t *testing.T) {
+	t.Parallel()
+
+	const numHonest = 40
+	const numAttacker = 40
+	candidates := makeEvictionCandidates(numHonest + numAttacker)
+	for _, c := range candidates[numHonest:] {
+		c.netGroup = "attacker"
+		c.keyedNetGroup = 1000
+	}
+
+	c := selectPeerToEvict(candidates)
+	if c == nil {
+		t.Fatalf("expected a peer to be evicted")
+	}
+	if want := int32(numHonest + numAttacker - 1); c.id != want {
+		t.Fatalf("evicted peer %d, want %d", c.id, want)
+	}
+
+	// All attackers except the one protected by its network group and the
+	// ones protected for being connected the longest are evicted before
+	// any honest peer.
+	evicted := evictAll(candidates)
+	var numEvictedAttackers int
+	for _, id := range evicted {
+		if id < numHonest {
+			break
+		}
+		numEvictedAttackers++
+	}
+	if numEvictedAttackers < numAttacker/2 {
+		t.Fatalf("evicted %d attackers before an honest peer, want "+
+			"at least %d", numEvictedAttackers, numAttacker/2)
+	}
+}
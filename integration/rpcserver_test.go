@@ -9,13 +9,23 @@ package integration
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/integration/rpctest"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 )
 
 func testGetBestBlock(r *rpctest.Harness, t *testing.T) {
@@ -94,10 +104,227 @@ func testGetBlockHash(r *rpctest.Harness, t *testing.T) {
 	}
 }
 
+// getBlockTemplate calls getblocktemplate with the passed request.
+func getBlockTemplate(r *rpctest.Harness, request *btcjson.TemplateRequest) (*btcjson.GetBlockTemplateResult, error) {
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := r.Node.RawRequest("getblocktemplate",
+		[]json.RawMessage{marshalled})
+	if err != nil {
+		return nil, err
+	}
+	var result btcjson.GetBlockTemplateResult
+	if err := json.Unmarshal(reply, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// proposeBlock calls getblocktemplate in proposal mode with the passed block
+// and returns the reject reason, which is empty for valid blocks.
+func proposeBlock(r *rpctest.Harness, t *testing.T, block *wire.MsgBlock) string {
+	var buf bytes.Buffer
+	if err := block.Serialize(&buf); err != nil {
+		t.Fatalf("Unable to serialize block: %v", err)
+	}
+	marshalled, err := json.Marshal(&btcjson.TemplateRequest{
+		Mode: "proposal",
+		Data: hex.EncodeToString(buf.Bytes()),
+	})
+	if err != nil {
+		t.Fatalf("Unable to marshal proposal: %v", err)
+	}
+	reply, err := r.Node.RawRequest("getblocktemplate",
+		[]json.RawMessage{marshalled})
+	if err != nil {
+		t.Fatalf("Call to `getblocktemplate` proposal failed: %v", err)
+	}
+
+	var reason *string
+	if err := json.Unmarshal(reply, &reason); err != nil {
+		t.Fatalf("Malformed proposal result %s: %v", reply, err)
+	}
+	if reason == nil {
+		return ""
+	}
+	return *reason
+}
+
+// templateBlock builds the block described by a block template which includes
+// a coinbase transaction.
+func templateBlock(t *testing.T, template *btcjson.GetBlockTemplateResult) *wire.MsgBlock {
+	decodeTx := func(data string) *wire.MsgTx {
+		serialized, err := hex.DecodeString(data)
+		if err != nil {
+			t.Fatalf("Malformed template transaction %q: %v", data,
+				err)
+		}
+		var tx wire.MsgTx
+		if err := tx.Deserialize(bytes.NewReader(serialized)); err != nil {
+			t.Fatalf("Malformed template transaction %q: %v", data,
+				err)
+		}
+		return &tx
+	}
+
+	prevHash, err := chainhash.NewHashFromStr(template.PreviousHash)
+	if err != nil {
+		t.Fatalf("Malformed previous block hash %q: %v",
+			template.PreviousHash, err)
+	}
+	bits, err := strconv.ParseUint(template.Bits, 16, 32)
+	if err != nil {
+		t.Fatalf("Malformed bits %q: %v", template.Bits, err)
+	}
+	block := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   template.Version,
+		PrevBlock: *prevHash,
+		Timestamp: time.Unix(template.CurTime, 0),
+		Bits:      uint32(bits),
+	})
+	block.AddTransaction(decodeTx(template.CoinbaseTxn.Data))
+	for _, tx := range template.Transactions {
+		block.AddTransaction(decodeTx(tx.Data))
+	}
+	merkles := blockchain.BuildMerkleTreeStore(
+		btcutil.NewBlock(block).Transactions(), false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return block
+}
+
+// witnessCommitmentScript returns the script of the coinbase output which
+// commits to the witness data of the passed block with a coinbase witness
+// nonce of all zeroes.
+func witnessCommitmentScript(block *wire.MsgBlock) []byte {
+	merkles := blockchain.BuildMerkleTreeStore(
+		btcutil.NewBlock(block).Transactions(), true)
+	var preimage [64]byte
+	copy(preimage[:], merkles[len(merkles)-1][:])
+	commitment := chainhash.DoubleHashB(preimage[:])
+	return append(append([]byte{}, blockchain.WitnessMagicBytes...),
+		commitment...)
+}
+
+func testGetBlockTemplate(r *rpctest.Harness, t *testing.T) {
+	segwitRules := []string{"segwit"}
+	segwitBit := int32(1) << chaincfg.SimNetParams.Deployments[chaincfg.DeploymentSegwit].BitNumber
+
+	// Segwit is available to be signalled on simnet before it is active.
+	// Its bit is only set in the version for clients which support it.
+	template, err := getBlockTemplate(r, &btcjson.TemplateRequest{})
+	if err != nil {
+		t.Fatalf("Call to `getblocktemplate` failed: %v", err)
+	}
+	bit, ok := template.VbAvailable["segwit"]
+	if !ok || int32(1)<<bit != segwitBit {
+		t.Fatalf("Segwit not available with bit %d: got %v",
+			chaincfg.SimNetParams.Deployments[chaincfg.DeploymentSegwit].BitNumber,
+			template.VbAvailable)
+	}
+	if template.Version&segwitBit != 0 {
+		t.Fatalf("Segwit signalled for a client without segwit " +
+			"support")
+	}
+	if template.VbRequired != 0 {
+		t.Fatalf("Unexpected required versionbits %x",
+			template.VbRequired)
+	}
+	template, err = getBlockTemplate(r, &btcjson.TemplateRequest{
+		Rules: segwitRules,
+	})
+	if err != nil {
+		t.Fatalf("Call to `getblocktemplate` failed: %v", err)
+	}
+	if template.Version&segwitBit == 0 {
+		t.Fatalf("Segwit not signalled for a client with segwit " +
+			"support")
+	}
+
+	// Generate blocks until segwit is active.  It then can't be ignored by
+	// clients and is reported as a rule which must be supported.
+	for i := 0; ; i++ {
+		template, err = getBlockTemplate(r, &btcjson.TemplateRequest{
+			Rules: segwitRules,
+		})
+		if err != nil {
+			t.Fatalf("Call to `getblocktemplate` failed: %v", err)
+		}
+		if _, ok := template.VbAvailable["segwit"]; !ok {
+			break
+		}
+		if i == 3*int(chaincfg.SimNetParams.MinerConfirmationWindow) {
+			t.Fatalf("Segwit not active after %d blocks", i)
+		}
+		if _, err := r.Node.Generate(1); err != nil {
+			t.Fatalf("Unable to generate block: %v", err)
+		}
+	}
+	var hasSegwit bool
+	for _, rule := range template.Rules {
+		hasSegwit = hasSegwit || rule == "!segwit"
+	}
+	if !hasSegwit {
+		t.Fatalf("Active segwit rule not reported: got %v",
+			template.Rules)
+	}
+	_, err = getBlockTemplate(r, &btcjson.TemplateRequest{})
+	if err == nil || !strings.Contains(err.Error(), "segwit") {
+		t.Fatalf("Call to `getblocktemplate` without segwit support "+
+			"did not fail: %v", err)
+	}
+
+	// Clients which build their own coinbase are given the full script of
+	// the witness commitment output.
+	template, err = getBlockTemplate(r, &btcjson.TemplateRequest{
+		Rules:        segwitRules,
+		Capabilities: []string{"coinbasetxn"},
+	})
+	if err != nil {
+		t.Fatalf("Call to `getblocktemplate` failed: %v", err)
+	}
+	block := templateBlock(t, template)
+	wantCommitment := hex.EncodeToString(witnessCommitmentScript(block))
+	if template.DefaultWitnessCommitment != wantCommitment {
+		t.Fatalf("Unexpected witness commitment: got %s, want %s",
+			template.DefaultWitnessCommitment, wantCommitment)
+	}
+
+	// Valid proposals are accepted regardless of their proof of work.
+	if reason := proposeBlock(r, t, block); reason != "" {
+		t.Fatalf("Valid block proposal rejected: %s", reason)
+	}
+
+	// Invalid proposals are rejected with the reasons of BIP0023.
+	badMerkleRoot := *block
+	badMerkleRoot.Header.MerkleRoot[0] ^= 1
+	if reason := proposeBlock(r, t, &badMerkleRoot); reason != "bad-txnmrklroot" {
+		t.Fatalf("Proposal with a bad merkle root: got reason %q, "+
+			"want %q", reason, "bad-txnmrklroot")
+	}
+	bestBlock, err := r.Node.GetBlock(&block.Header.PrevBlock)
+	if err != nil {
+		t.Fatalf("Call to `getblock` failed: %v", err)
+	}
+	notBest := *block
+	notBest.Header.PrevBlock = bestBlock.Header.PrevBlock
+	reason := proposeBlock(r, t, &notBest)
+	if reason != "inconclusive-not-best-prevblk" {
+		t.Fatalf("Proposal not building on the best block: got reason "+
+			"%q, want %q", reason, "inconclusive-not-best-prevblk")
+	}
+	if reason := proposeBlock(r, t, bestBlock); reason != "duplicate" {
+		t.Fatalf("Proposal of the best block: got reason %q, want %q",
+			reason, "duplicate")
+	}
+}
+
 var rpcTestCases = []rpctest.HarnessTestCase{
 	testGetBestBlock,
 	testGetBlockCount,
 	testGetBlockHash,
+	testGetBlockTemplate,
 }
 
 var primaryHarness *rpctest.Harness
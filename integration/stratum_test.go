@@ -0,0 +1,262 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file is ignored during the regular tests due to the following build tag.
+// +build rpctest
+
+package integration
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/integration/rpctest"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// stratumMessage is a response or notification received from the stratum
+// server.
+type stratumMessage struct {
+	ID     *int              `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  json.RawMessage   `json:"error"`
+}
+
+// stratumJob is a job received from the stratum server.
+type stratumJob struct {
+	id                   string
+	prevHash             chainhash.Hash
+	coinbase1, coinbase2 []byte
+	branch               []chainhash.Hash
+	version, bits, ntime uint32
+	clean                bool
+}
+
+// stratumClient is a minimal stratum client which builds block headers from
+// the jobs it is sent the way mining software does.
+type stratumClient struct {
+	t           *testing.T
+	conn        net.Conn
+	scanner     *bufio.Scanner
+	nextID      int
+	extraNonce1 []byte
+	queued      []*stratumMessage
+}
+
+// read returns the next message sent by the server.
+func (c *stratumClient) read() *stratumMessage {
+	c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if !c.scanner.Scan() {
+		c.t.Fatalf("unable to read from stratum server: %v",
+			c.scanner.Err())
+	}
+	var msg stratumMessage
+	if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+		c.t.Fatalf("malformed stratum message %s: %v", c.scanner.Text(),
+			err)
+	}
+	return &msg
+}
+
+// call sends a request and returns its result, queueing the notifications
+// received in the meantime.
+func (c *stratumClient) call(method string, params ...interface{}) json.RawMessage {
+	c.nextID++
+	req, _ := json.Marshal(map[string]interface{}{
+		"id": c.nextID, "method": method, "params": params,
+	})
+	if _, err := c.conn.Write(append(req, '\n')); err != nil {
+		c.t.Fatalf("unable to send stratum request: %v", err)
+	}
+	for {
+		msg := c.read()
+		if msg.ID == nil || *msg.ID != c.nextID {
+			c.queued = append(c.queued, msg)
+			continue
+		}
+		if string(msg.Error) != "null" && len(msg.Error) != 0 {
+			c.t.Fatalf("%s failed: %s", method, msg.Error)
+		}
+		return msg.Result
+	}
+}
+
+// job decodes the next mining.notify notification.
+func (c *stratumClient) job() *stratumJob {
+	var msg *stratumMessage
+	for msg == nil || msg.Method != "mining.notify" {
+		if len(c.queued) > 0 {
+			msg, c.queued = c.queued[0], c.queued[1:]
+		} else {
+			msg = c.read()
+		}
+	}
+
+	var id, prevHash, coinbase1, coinbase2, version, bits, ntime string
+	var branch []string
+	j := &stratumJob{}
+	fields := []interface{}{&id, &prevHash, &coinbase1, &coinbase2,
+		&branch, &version, &bits, &ntime, &j.clean}
+	for i, field := range fields {
+		if err := json.Unmarshal(msg.Params[i], field); err != nil {
+			c.t.Fatalf("malformed mining.notify: %v", err)
+		}
+	}
+	decode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			c.t.Fatalf("malformed hex %q: %v", s, err)
+		}
+		return b
+	}
+
+	// The previous block hash is sent with the byte order of each 32-bit
+	// word swapped.
+	j.id = id
+	swapped := decode(prevHash)
+	for i := 0; i < chainhash.HashSize; i++ {
+		j.prevHash[i] = swapped[i/4*4+3-i%4]
+	}
+	j.coinbase1, j.coinbase2 = decode(coinbase1), decode(coinbase2)
+	for _, hash := range branch {
+		var h chainhash.Hash
+		copy(h[:], decode(hash))
+		j.branch = append(j.branch, h)
+	}
+	j.version = binary.BigEndian.Uint32(decode(version))
+	j.bits = binary.BigEndian.Uint32(decode(bits))
+	j.ntime = binary.BigEndian.Uint32(decode(ntime))
+	return j
+}
+
+// mine finds a nonce whose header meets the block target of the job, submits
+// it and returns the hash of the block.
+func (c *stratumClient) mine(j *stratumJob, extraNonce2 []byte) chainhash.Hash {
+	coinbase := append(append(append(append([]byte{}, j.coinbase1...),
+		c.extraNonce1...), extraNonce2...), j.coinbase2...)
+	merkleRoot := chainhash.DoubleHashH(coinbase)
+	for i := range j.branch {
+		merkleRoot = *blockchain.HashMerkleBranches(&merkleRoot,
+			&j.branch[i])
+	}
+	header := wire.BlockHeader{
+		Version:    int32(j.version),
+		PrevBlock:  j.prevHash,
+		MerkleRoot: merkleRoot,
+		Timestamp:  time.Unix(int64(j.ntime), 0),
+		Bits:       j.bits,
+	}
+	target := blockchain.CompactToBig(j.bits)
+	for {
+		hash := header.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		header.Nonce++
+	}
+
+	c.call("mining.submit", "worker", j.id, hex.EncodeToString(extraNonce2),
+		fmt.Sprintf("%08x", j.ntime), fmt.Sprintf("%08x", header.Nonce))
+	return header.BlockHash()
+}
+
+// TestStratum ensures blocks can be mined through the stratum server of a
+// node with a local stratum client, and that the client is sent new jobs when
+// blocks are connected.
+func TestStratum(t *testing.T) {
+	t.Parallel()
+
+	// Find a free port for the stratum server.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to find a free port: %v", err)
+	}
+	stratumAddr := listener.Addr().String()
+	listener.Close()
+
+	btcdCfg := []string{"--stratumlisten=" + stratumAddr,
+		"--stratumdiff=0.000000000001"}
+	r, err := rpctest.New(&chaincfg.SimNetParams, nil, btcdCfg, "")
+	if err != nil {
+		t.Fatal("unable to create primary harness: ", err)
+	}
+	if err := r.SetUp(true, 1); err != nil {
+		t.Fatalf("unable to setup test chain: %v", err)
+	}
+	defer r.TearDown()
+
+	conn, err := net.Dial("tcp", stratumAddr)
+	if err != nil {
+		t.Fatalf("unable to connect to the stratum server: %v", err)
+	}
+	defer conn.Close()
+	c := &stratumClient{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+
+	var subscription []json.RawMessage
+	err = json.Unmarshal(c.call("mining.subscribe", "test/1.0"),
+		&subscription)
+	if err != nil || len(subscription) != 3 {
+		t.Fatalf("malformed mining.subscribe result: %v", err)
+	}
+	var extraNonce1 string
+	json.Unmarshal(subscription[1], &extraNonce1)
+	c.extraNonce1, err = hex.DecodeString(extraNonce1)
+	if err != nil {
+		t.Fatalf("malformed extranonce1 %q: %v", extraNonce1, err)
+	}
+	c.call("mining.authorize", "worker", "x")
+
+	// Mine a few blocks through the stratum server.  Each of them results
+	// in a job which builds on it.
+	j := c.job()
+	for i := 0; i < 3; i++ {
+		_, height, err := r.Node.GetBestBlock()
+		if err != nil {
+			t.Fatalf("unable to get best block: %v", err)
+		}
+		hash := c.mine(j, []byte{0, 0, 0, byte(i)})
+
+		j = c.job()
+		for !j.clean {
+			j = c.job()
+		}
+		if j.prevHash != hash {
+			t.Fatalf("job %s does not build on the mined block %v",
+				j.id, hash)
+		}
+		bestHash, bestHeight, err := r.Node.GetBestBlock()
+		if err != nil {
+			t.Fatalf("unable to get best block: %v", err)
+		}
+		if *bestHash != hash || bestHeight != height+1 {
+			t.Fatalf("mined block %v is not the tip %v", hash,
+				bestHash)
+		}
+	}
+
+	// Blocks generated otherwise result in new jobs as well.
+	hashes, err := r.Node.Generate(1)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	j = c.job()
+	for !j.clean {
+		j = c.job()
+	}
+	if j.prevHash != *hashes[0] {
+		t.Fatalf("job %s does not build on the generated block %v",
+			j.id, hashes[0])
+	}
+}
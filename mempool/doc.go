@@ -48,6 +48,9 @@ be an exhaustive list.
    - Automatic addition of orphan transactions that are no longer orphans as new
      transactions are added to the pool
    - Individual orphan transaction query support
+ - Package acceptance (a child transaction along with its unconfirmed parents)
+   - Children paying for parents which don't pay enough fees on their own
+   - Replacement of transactions by a parent along with its child
  - Configurable transaction acceptance policy
    - Option to accept or reject standard transactions
    - Option to accept or reject transactions based on priority calculations
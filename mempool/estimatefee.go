@@ -11,39 +11,79 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"math/rand"
 	"sort"
-	"strings"
 	"sync"
 
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 )
 
-// TODO incorporate Alex Morcos' modifications to Gavin's initial model
-// https://lists.linuxfoundation.org/pipermail/bitcoin-dev/2014-October/006824.html
-
 const (
-	// estimateFeeDepth is the maximum number of blocks before a transaction
-	// is confirmed that we want to track.
-	estimateFeeDepth = 25
-
-	// estimateFeeBinSize is the number of txs stored in each bin.
-	estimateFeeBinSize = 100
-
-	// estimateFeeMaxReplacements is the max number of replacements that
-	// can be made by the txs found in a given block.
-	estimateFeeMaxReplacements = 10
-
-	// DefaultEstimateFeeMaxRollback is the default number of rollbacks
-	// allowed by the fee estimator for orphaned blocks.
-	DefaultEstimateFeeMaxRollback = 2
-
-	// DefaultEstimateFeeMinRegisteredBlocks is the default minimum
-	// number of blocks which must be observed by the fee estimator before
-	// it will provide fee estimations.
-	DefaultEstimateFeeMinRegisteredBlocks = 3
+	// The confirmations of transactions are tracked over three horizons.
+	// Each one tracks confirmations up to a number of periods of scale
+	// blocks, and decays its statistics by its decay for each block, which
+	// gives the short, medium and long horizons half-lives of about 18,
+	// 144 and 1008 blocks.
+	shortBlockPeriods = 12
+	shortScale        = 1
+	shortDecay        = .962
+
+	mediumBlockPeriods = 24
+	mediumScale        = 2
+	mediumDecay        = .9952
+
+	longBlockPeriods = 42
+	longScale        = 24
+	longDecay        = .99931
+
+	// MaxEstimateConfTarget is the highest confirmation target fee rates
+	// can be estimated for, which is the number of blocks tracked by the
+	// long horizon.
+	MaxEstimateConfTarget = longBlockPeriods * longScale
+
+	// halfSuccessPct, successPct and doubleSuccessPct are the fractions of
+	// the transactions paying a fee rate which must have been confirmed
+	// within half the target, the target and twice the target for the fee
+	// rate to be estimated.
+	halfSuccessPct   = .6
+	successPct       = .85
+	doubleSuccessPct = .95
+
+	// sufficientFeeTxs and sufficientTxsShort are the average numbers of
+	// confirmed transactions per block a range of fee rate buckets needs
+	// for its confirmation rate to be trusted.  The short horizon decays
+	// faster so it requires more of them.
+	sufficientFeeTxs   = .1
+	sufficientTxsShort = .5
+
+	// minBucketFeeRate and maxBucketFeeRate are the bounds, in satoshis
+	// per kilobyte, of the fee rate buckets, each one being
+	// feeBucketSpacing times higher than the previous one.
+	minBucketFeeRate = 1000
+	maxBucketFeeRate = 1e7
+	feeBucketSpacing = 1.05
+
+	// oldestEstimateHistory is the number of blocks after which the
+	// statistics restored from an earlier session are too old to tell how
+	// many blocks they cover.
+	oldestEstimateHistory = 6 * MaxEstimateConfTarget
+
+	// blockFullWeight is the weight from which a block is considered
+	// full, so that its cheapest transactions tell the fee rate it took to
+	// be included in it.
+	blockFullWeight = blockchain.MaxBlockWeight * 3 / 4
+
+	// blockFeeOutliers is the part of the virtual size of the transactions
+	// of a block, from the cheapest ones, which is ignored when finding the
+	// fee rate it took to be included in it, since miners are free to
+	// include their own transactions regardless of their fee.
+	blockFeeOutliers = .05
+
+	// sufficientBlocks is the decayed number of blocks a horizon must have
+	// registered for the fee rates included by them to be trusted.
+	sufficientBlocks = 6
 
 	bytePerKb = 1000
 
@@ -90,588 +130,896 @@ func NewSatoshiPerByte(fee btcutil.Amount, size uint32) SatoshiPerByte {
 	return SatoshiPerByte(float64(fee) / float64(size))
 }
 
-// observedTransaction represents an observed transaction and some
-// additional data required for the fee estimation algorithm.
-type observedTransaction struct {
-	// A transaction hash.
-	hash chainhash.Hash
-
-	// The fee per byte of the transaction in satoshis.
-	feeRate SatoshiPerByte
-
-	// The block height when it was observed.
-	observed int32
-
-	// The height of the block in which it was mined.
-	// If the transaction has not yet been mined, it is zero.
-	mined int32
+// txConfirmStats tracks, for each fee rate bucket, the exponentially decaying
+// numbers of transactions which were confirmed within each number of periods
+// of scale blocks, or which left the pool without being confirmed, along with
+// the transactions which are still unconfirmed.
+type txConfirmStats struct {
+	buckets []float64
+	decay   float64
+	scale   int
+
+	// confAvg and failAvg hold, by period and bucket, the number of
+	// transactions confirmed within that many periods, and the number of
+	// transactions which left the pool after having been in it for more
+	// than that many periods.
+	confAvg [][]float64
+	failAvg [][]float64
+
+	// txCtAvg and feeRateAvg hold, by bucket, the number of confirmed
+	// transactions and the sum of their fee rates.
+	txCtAvg    []float64
+	feeRateAvg []float64
+
+	// unconfTxs holds, by bucket, the number of unconfirmed transactions
+	// which entered the pool at each of the heights still tracked, indexed
+	// by height modulo the number of blocks tracked, and oldUnconfTxs the
+	// number of older ones.
+	unconfTxs    [][]int
+	oldUnconfTxs []int
 }
 
-func (o *observedTransaction) Serialize(w io.Writer) {
-	binary.Write(w, binary.BigEndian, o.hash)
-	binary.Write(w, binary.BigEndian, o.feeRate)
-	binary.Write(w, binary.BigEndian, o.observed)
-	binary.Write(w, binary.BigEndian, o.mined)
+// newTxConfirmStats returns statistics tracking confirmations in the passed
+// fee rate buckets up to the passed number of periods of scale blocks.
+func newTxConfirmStats(buckets []float64, periods, scale int,
+	decay float64) *txConfirmStats {
+
+	s := &txConfirmStats{
+		buckets:      buckets,
+		decay:        decay,
+		scale:        scale,
+		confAvg:      make([][]float64, periods),
+		failAvg:      make([][]float64, periods),
+		txCtAvg:      make([]float64, len(buckets)),
+		feeRateAvg:   make([]float64, len(buckets)),
+		unconfTxs:    make([][]int, periods*scale),
+		oldUnconfTxs: make([]int, len(buckets)),
+	}
+	for i := 0; i < periods; i++ {
+		s.confAvg[i] = make([]float64, len(buckets))
+		s.failAvg[i] = make([]float64, len(buckets))
+	}
+	for i := range s.unconfTxs {
+		s.unconfTxs[i] = make([]int, len(buckets))
+	}
+	return s
 }
 
-func deserializeObservedTransaction(r io.Reader) (*observedTransaction, error) {
-	ot := observedTransaction{}
-
-	// The first 32 bytes should be a hash.
-	binary.Read(r, binary.BigEndian, &ot.hash)
-
-	// The next 8 are SatoshiPerByte
-	binary.Read(r, binary.BigEndian, &ot.feeRate)
-
-	// And next there are two uint32's.
-	binary.Read(r, binary.BigEndian, &ot.observed)
-	binary.Read(r, binary.BigEndian, &ot.mined)
-
-	return &ot, nil
+// maxConfirms returns the number of blocks confirmations are tracked for.
+func (s *txConfirmStats) maxConfirms() int {
+	return len(s.unconfTxs)
 }
 
-// registeredBlock has the hash of a block and the list of transactions
-// it mined which had been previously observed by the FeeEstimator. It
-// is used if Rollback is called to reverse the effect of registering
-// a block.
-type registeredBlock struct {
-	hash         chainhash.Hash
-	transactions []*observedTransaction
+// unconfIndex returns the index in unconfTxs of the transactions which entered
+// the pool at the passed height.
+func (s *txConfirmStats) unconfIndex(height int32) int {
+	i := int(height) % len(s.unconfTxs)
+	if i < 0 {
+		i += len(s.unconfTxs)
+	}
+	return i
 }
 
-func (rb *registeredBlock) serialize(w io.Writer, txs map[*observedTransaction]uint32) {
-	binary.Write(w, binary.BigEndian, rb.hash)
+// clearCurrent moves the unconfirmed transactions which entered the pool as
+// many blocks as tracked before the passed height to the old ones, making room
+// for the ones entering the pool at that height.
+func (s *txConfirmStats) clearCurrent(height int32) {
+	current := s.unconfTxs[s.unconfIndex(height)]
+	for bucket, count := range current {
+		s.oldUnconfTxs[bucket] += count
+		current[bucket] = 0
+	}
+}
 
-	binary.Write(w, binary.BigEndian, uint32(len(rb.transactions)))
-	for _, o := range rb.transactions {
-		binary.Write(w, binary.BigEndian, txs[o])
+// decayAverages decays the statistics of the confirmed and failed transactions
+// for a new block.
+func (s *txConfirmStats) decayAverages() {
+	for i := range s.confAvg {
+		for bucket := range s.buckets {
+			s.confAvg[i][bucket] *= s.decay
+			s.failAvg[i][bucket] *= s.decay
+		}
+	}
+	for bucket := range s.buckets {
+		s.txCtAvg[bucket] *= s.decay
+		s.feeRateAvg[bucket] *= s.decay
 	}
 }
 
-// FeeEstimator manages the data necessary to create
-// fee estimations. It is safe for concurrent access.
-type FeeEstimator struct {
-	maxRollback uint32
-	binSize     int32
+// newTx records an unconfirmed transaction entering the pool at the passed
+// height.
+func (s *txConfirmStats) newTx(height int32, bucket int) {
+	s.unconfTxs[s.unconfIndex(height)][bucket]++
+}
 
-	// The maximum number of replacements that can be made in a single
-	// bin per block. Default is estimateFeeMaxReplacements
-	maxReplacements int32
+// removeTx removes a transaction which entered the pool at entryHeight from the
+// unconfirmed ones.  Unless it was included in a block, it's recorded as a
+// failure to be confirmed within each period it spent in the pool.
+func (s *txConfirmStats) removeTx(entryHeight, bestHeight int32, bucket int,
+	inBlock bool) {
 
-	// The minimum number of blocks that can be registered with the fee
-	// estimator before it will provide answers.
-	minRegisteredBlocks uint32
+	blocksAgo := int(bestHeight - entryHeight)
+	if blocksAgo < 0 {
+		blocksAgo = 0
+	}
+	unconf := s.oldUnconfTxs
+	if blocksAgo < s.maxConfirms() {
+		unconf = s.unconfTxs[s.unconfIndex(entryHeight)]
+	}
+	if unconf[bucket] > 0 {
+		unconf[bucket]--
+	}
 
-	// The last known height.
-	lastKnownHeight int32
+	if inBlock {
+		return
+	}
+	periodsAgo := blocksAgo / s.scale
+	for i := 0; i < periodsAgo && i < len(s.failAvg); i++ {
+		s.failAvg[i][bucket]++
+	}
+}
 
-	// The number of blocks that have been registered.
-	numBlocksRegistered uint32
+// record records a transaction which was confirmed blocksToConfirm blocks after
+// entering the pool.
+func (s *txConfirmStats) record(blocksToConfirm int, feeRate float64, bucket int) {
+	if blocksToConfirm < 1 {
+		return
+	}
+	periodsToConfirm := (blocksToConfirm + s.scale - 1) / s.scale
+	for i := periodsToConfirm; i <= len(s.confAvg); i++ {
+		s.confAvg[i-1][bucket]++
+	}
+	s.txCtAvg[bucket]++
+	s.feeRateAvg[bucket] += feeRate
+}
 
-	mtx      sync.RWMutex
-	observed map[chainhash.Hash]*observedTransaction
-	bin      [estimateFeeDepth][]*observedTransaction
+// estimateMedianVal returns the fee rate of the median transaction of the
+// cheapest range of buckets in which enough transactions were confirmed within
+// confTarget blocks, or -1 if there is none.  Buckets are merged into ranges,
+// from the highest fee rates, until they have enough confirmed transactions
+// for their success rate to be compared with successBreakPoint.  The
+// transactions which left the pool, or which are still in it, after confTarget
+// blocks count as failures.
+func (s *txConfirmStats) estimateMedianVal(confTarget int, sufficientTxVal,
+	successBreakPoint float64, height int32) float64 {
+
+	periodTarget := (confTarget + s.scale - 1) / s.scale
+	maxBucket := len(s.buckets) - 1
+
+	// The current range goes from the near bucket down to the far one, and
+	// the best range is the last one which passed.
+	var nConf, totalNum, failNum float64
+	var extraNum int
+	curNear, curFar := maxBucket, maxBucket
+	bestNear, bestFar := maxBucket, maxBucket
+	foundAnswer, newRange := false, true
+	for bucket := maxBucket; bucket >= 0; bucket-- {
+		if newRange {
+			curNear = bucket
+			newRange = false
+		}
+		curFar = bucket
+		nConf += s.confAvg[periodTarget-1][bucket]
+		totalNum += s.txCtAvg[bucket]
+		failNum += s.failAvg[periodTarget-1][bucket]
+		for confct := confTarget; confct < s.maxConfirms(); confct++ {
+			extraNum += s.unconfTxs[s.unconfIndex(height-int32(confct))][bucket]
+		}
+		extraNum += s.oldUnconfTxs[bucket]
 
-	// The cached estimates.
-	cached []SatoshiPerByte
+		// Only the confirmed transactions are counted to decide
+		// whether a range has enough of them, so that every target
+		// looks at the same ranges.
+		if totalNum < sufficientTxVal/(1-s.decay) {
+			continue
+		}
+		curPct := nConf / (totalNum + failNum + float64(extraNum))
+		if curPct < successBreakPoint {
+			continue
+		}
 
-	// Transactions that have been removed from the bins. This allows us to
-	// revert in case of an orphaned block.
-	dropped []*registeredBlock
-}
+		foundAnswer = true
+		nConf, totalNum, failNum, extraNum = 0, 0, 0, 0
+		bestNear, bestFar = curNear, curFar
+		newRange = true
+	}
+	if !foundAnswer {
+		return -1
+	}
 
-// NewFeeEstimator creates a FeeEstimator for which at most maxRollback blocks
-// can be unregistered and which returns an error unless minRegisteredBlocks
-// have been registered with it.
-func NewFeeEstimator(maxRollback, minRegisteredBlocks uint32) *FeeEstimator {
-	return &FeeEstimator{
-		maxRollback:         maxRollback,
-		minRegisteredBlocks: minRegisteredBlocks,
-		lastKnownHeight:     mining.UnminedHeight,
-		binSize:             estimateFeeBinSize,
-		maxReplacements:     estimateFeeMaxReplacements,
-		observed:            make(map[chainhash.Hash]*observedTransaction),
-		dropped:             make([]*registeredBlock, 0, maxRollback),
+	// The fee rates of the transactions aren't kept, so the average fee
+	// rate of the bucket holding the median transaction is used.
+	var txSum float64
+	for bucket := bestFar; bucket <= bestNear; bucket++ {
+		txSum += s.txCtAvg[bucket]
+	}
+	if txSum == 0 {
+		return -1
 	}
+	txSum /= 2
+	for bucket := bestFar; bucket <= bestNear; bucket++ {
+		if s.txCtAvg[bucket] < txSum {
+			txSum -= s.txCtAvg[bucket]
+			continue
+		}
+		return s.feeRateAvg[bucket] / s.txCtAvg[bucket]
+	}
+	return -1
 }
 
-// ObserveTransaction is called when a new transaction is observed in the mempool.
-func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
-	ef.mtx.Lock()
-	defer ef.mtx.Unlock()
+// serialize writes the statistics of the confirmed and failed transactions.
+// The unconfirmed transactions aren't written since they aren't tracked
+// anymore once the estimator is restored.
+func (s *txConfirmStats) serialize(w io.Writer) {
+	binary.Write(w, binary.BigEndian, s.decay)
+	binary.Write(w, binary.BigEndian, uint32(s.scale))
+	binary.Write(w, binary.BigEndian, uint32(len(s.confAvg)))
+	binary.Write(w, binary.BigEndian, uint32(len(s.buckets)))
+	binary.Write(w, binary.BigEndian, s.txCtAvg)
+	binary.Write(w, binary.BigEndian, s.feeRateAvg)
+	for i := range s.confAvg {
+		binary.Write(w, binary.BigEndian, s.confAvg[i])
+		binary.Write(w, binary.BigEndian, s.failAvg[i])
+	}
+}
 
-	// If we haven't seen a block yet we don't know when this one arrived,
-	// so we ignore it.
-	if ef.lastKnownHeight == mining.UnminedHeight {
-		return
+// deserialize reads the statistics written by serialize, which must have been
+// tracked over the same periods and buckets.
+func (s *txConfirmStats) deserialize(r io.Reader) error {
+	var decay float64
+	var scale, periods, buckets uint32
+	for _, v := range []interface{}{&decay, &scale, &periods, &buckets} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return err
+		}
 	}
+	if decay != s.decay || int(scale) != s.scale ||
+		int(periods) != len(s.confAvg) || int(buckets) != len(s.buckets) {
 
-	hash := *t.Tx.Hash()
-	if _, ok := ef.observed[hash]; !ok {
-		size := uint32(GetTxVirtualSize(t.Tx))
+		return fmt.Errorf("statistics with decay %v over %d periods of "+
+			"%d blocks and %d buckets don't match the estimator", decay,
+			periods, scale, buckets)
+	}
 
-		ef.observed[hash] = &observedTransaction{
-			hash:     hash,
-			feeRate:  NewSatoshiPerByte(btcutil.Amount(t.Fee), size),
-			observed: t.Height,
-			mined:    mining.UnminedHeight,
+	data := []interface{}{s.txCtAvg, s.feeRateAvg}
+	for i := range s.confAvg {
+		data = append(data, s.confAvg[i], s.failAvg[i])
+	}
+	for _, v := range data {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-// RegisterBlock informs the fee estimator of a new block to take into account.
-func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
-	ef.mtx.Lock()
-	defer ef.mtx.Unlock()
-
-	// The previous sorted list is invalid, so delete it.
-	ef.cached = nil
+// blockFeeStats tracks, for each fee rate bucket, the exponentially decaying
+// number of blocks which included transactions down to a fee rate in it.  It's
+// used when the pool doesn't tell how fast transactions are confirmed, such as
+// on compact state nodes, which don't accept transactions to their pool.
+type blockFeeStats struct {
+	buckets  []float64
+	decay    float64
+	blockAvg []float64
+}
 
-	height := block.Height()
-	if height != ef.lastKnownHeight+1 && ef.lastKnownHeight != mining.UnminedHeight {
-		return fmt.Errorf("intermediate block not recorded; current height is %d; new height is %d",
-			ef.lastKnownHeight, height)
+// newBlockFeeStats returns statistics of the blocks in the passed fee rate
+// buckets.
+func newBlockFeeStats(buckets []float64, decay float64) *blockFeeStats {
+	return &blockFeeStats{
+		buckets:  buckets,
+		decay:    decay,
+		blockAvg: make([]float64, len(buckets)),
 	}
+}
 
-	// Update the last known height.
-	ef.lastKnownHeight = height
-	ef.numBlocksRegistered++
-
-	// Randomly order txs in block.
-	transactions := make(map[*btcutil.Tx]struct{})
-	for _, t := range block.Transactions() {
-		transactions[t] = struct{}{}
+// record records a block which it took a fee rate in the passed bucket to be
+// included in.
+func (s *blockFeeStats) record(bucket int) {
+	for i := range s.blockAvg {
+		s.blockAvg[i] *= s.decay
 	}
+	s.blockAvg[bucket]++
+}
 
-	// Count the number of replacements we make per bin so that we don't
-	// replace too many.
-	var replacementCounts [estimateFeeDepth]int
+// estimate returns the lowest bucket fee rate which would have been included
+// in enough blocks for a transaction paying it to be confirmed within
+// confTarget blocks with the passed probability, or -1 if there aren't enough
+// blocks.
+func (s *blockFeeStats) estimate(confTarget int, successPct float64) float64 {
+	var total float64
+	for _, count := range s.blockAvg {
+		total += count
+	}
+	if total < sufficientBlocks {
+		return -1
+	}
 
-	// Keep track of which txs were dropped in case of an orphan block.
-	dropped := &registeredBlock{
-		hash:         *block.Hash(),
-		transactions: make([]*observedTransaction, 0, 100),
+	// A transaction paying a fee rate is included in each block with the
+	// probability of that block having taken at most that fee rate, so it
+	// must be included in each block with the following probability to be
+	// confirmed within confTarget blocks with the passed one.
+	blockPct := 1 - math.Pow(1-successPct, 1/float64(confTarget))
+	var included float64
+	for bucket, count := range s.blockAvg[:len(s.blockAvg)-1] {
+		included += count
+		if included/total >= blockPct {
+			return s.buckets[bucket]
+		}
 	}
+	return -1
+}
 
-	// Go through the txs in the block.
-	for t := range transactions {
-		hash := *t.Hash()
+// serialize writes the statistics of the blocks.
+func (s *blockFeeStats) serialize(w io.Writer) {
+	binary.Write(w, binary.BigEndian, s.decay)
+	binary.Write(w, binary.BigEndian, uint32(len(s.buckets)))
+	binary.Write(w, binary.BigEndian, s.blockAvg)
+}
 
-		// Have we observed this tx in the mempool?
-		o, ok := ef.observed[hash]
-		if !ok {
-			continue
+// deserialize reads the statistics written by serialize, which must have been
+// tracked over the same buckets.
+func (s *blockFeeStats) deserialize(r io.Reader) error {
+	var decay float64
+	var buckets uint32
+	for _, v := range []interface{}{&decay, &buckets} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return err
 		}
+	}
+	if decay != s.decay || int(buckets) != len(s.buckets) {
+		return fmt.Errorf("block statistics with decay %v and %d "+
+			"buckets don't match the estimator", decay, buckets)
+	}
+	return binary.Read(r, binary.BigEndian, s.blockAvg)
+}
 
-		// Put the observed tx in the oppropriate bin.
-		blocksToConfirm := height - o.observed - 1
+// blockTxFeeRate is the fee rate, in satoshis per kilobyte, and the virtual size
+// of a transaction included in a block.
+type blockTxFeeRate struct {
+	feeRate float64
+	size    int64
+}
 
-		// This shouldn't happen if the fee estimator works correctly,
-		// but return an error if it does.
-		if o.mined != mining.UnminedHeight {
-			log.Error("Estimate fee: transaction ", hash.String(), " has already been mined")
-			return errors.New("Transaction has already been mined")
+// blockInclusionFeeRate returns the fee rate a transaction had to pay to be
+// included in a block of the passed weight including transactions of the
+// passed fee rates.  It's zero when the block wasn't full, and otherwise the
+// fee rate of its cheapest transaction once the blockFeeOutliers part of them
+// is ignored.
+func blockInclusionFeeRate(txns []blockTxFeeRate, weight int64) float64 {
+	if weight < blockFullWeight || len(txns) == 0 {
+		return 0
+	}
+
+	sort.Slice(txns, func(i, j int) bool {
+		return txns[i].feeRate < txns[j].feeRate
+	})
+	var total int64
+	for _, tx := range txns {
+		total += tx.size
+	}
+	var skipped int64
+	for _, tx := range txns {
+		skipped += tx.size
+		if float64(skipped) > float64(total)*blockFeeOutliers {
+			return tx.feeRate
 		}
+	}
+	return txns[len(txns)-1].feeRate
+}
 
-		// This shouldn't happen but check just in case to avoid
-		// an out-of-bounds array index later.
-		if blocksToConfirm >= estimateFeeDepth {
-			continue
+// ublockFeeRates returns the fee rates of the transactions of the passed ublock.
+// Their fees are computed from the amounts of the outputs they spend, which are
+// given by the leaf datas of the ublock, or by the block itself for the outputs
+// it creates.  The transactions spending outputs of unknown amounts are left
+// out.
+func ublockFeeRates(ub *btcutil.UBlock) []blockTxFeeRate {
+	amounts := make(map[wire.OutPoint]int64)
+	if ud := ub.UData(); ud != nil {
+		for _, stxo := range ud.Stxos {
+			amounts[wire.OutPoint{
+				Hash:  chainhash.Hash(stxo.TxHash),
+				Index: stxo.Index,
+			}] = stxo.Amt
 		}
+	}
+	txns := ub.Block().Transactions()
+	for _, tx := range txns {
+		for i, txOut := range tx.MsgTx().TxOut {
+			amounts[wire.OutPoint{Hash: *tx.Hash(), Index: uint32(i)}] =
+				txOut.Value
+		}
+	}
 
-		// Make sure we do not replace too many transactions per min.
-		if replacementCounts[blocksToConfirm] == int(ef.maxReplacements) {
+	feeRates := make([]blockTxFeeRate, 0, len(txns))
+	for i, tx := range txns {
+		// The coinbase doesn't pay any fee.
+		if i == 0 {
 			continue
 		}
-
-		o.mined = height
-
-		replacementCounts[blocksToConfirm]++
-
-		bin := ef.bin[blocksToConfirm]
-
-		// Remove a random element and replace it with this new tx.
-		if len(bin) == int(ef.binSize) {
-			// Don't drop transactions we have just added from this same block.
-			l := int(ef.binSize) - replacementCounts[blocksToConfirm]
-			drop := rand.Intn(l)
-			dropped.transactions = append(dropped.transactions, bin[drop])
-
-			bin[drop] = bin[l-1]
-			bin[l-1] = o
-		} else {
-			bin = append(bin, o)
+		fee, ok := txFee(tx, amounts)
+		if !ok {
+			continue
 		}
-		ef.bin[blocksToConfirm] = bin
+		size := GetTxVirtualSize(tx)
+		feeRates = append(feeRates, blockTxFeeRate{
+			feeRate: float64(fee) * bytePerKb / float64(size),
+			size:    size,
+		})
 	}
+	return feeRates
+}
 
-	// Go through the mempool for txs that have been in too long.
-	for hash, o := range ef.observed {
-		if o.mined == mining.UnminedHeight && height-o.observed >= estimateFeeDepth {
-			delete(ef.observed, hash)
+// txFee returns the fee the passed transaction pays given the amounts of the
+// outputs it spends, and whether they were all known.
+func txFee(tx *btcutil.Tx, amounts map[wire.OutPoint]int64) (int64, bool) {
+	var fee int64
+	for _, txIn := range tx.MsgTx().TxIn {
+		amount, ok := amounts[txIn.PreviousOutPoint]
+		if !ok {
+			return 0, false
 		}
+		fee += amount
 	}
+	for _, txOut := range tx.MsgTx().TxOut {
+		fee -= txOut.Value
+	}
+	return fee, true
+}
+
+// trackedTx is a transaction in the pool whose confirmation is tracked.
+type trackedTx struct {
+	height  int32
+	feeRate float64
+	bucket  int
+}
+
+// FeeEstimator estimates the fee rates transactions must pay to be confirmed
+// within a number of blocks.  It tracks how many blocks the transactions
+// entering the pool take to be confirmed depending on their fee rate, with
+// exponentially decaying statistics over a short, a medium and a long horizon.
+// When the pool doesn't tell it, it falls back to the fee rates it took to be
+// included in the recent blocks.  It is safe for concurrent access.
+type FeeEstimator struct {
+	mtx sync.Mutex
+
+	// bestHeight is the height of the last block which was registered.
+	bestHeight int32
+
+	// firstRecordedHeight is the height of the first block which confirmed
+	// a tracked transaction, and historicalFirst and historicalBest are the
+	// heights of the first and last blocks of the statistics restored from
+	// an earlier session.
+	firstRecordedHeight int32
+	historicalFirst     int32
+	historicalBest      int32
+
+	buckets     []float64
+	shortStats  *txConfirmStats
+	mediumStats *txConfirmStats
+	longStats   *txConfirmStats
+
+	shortBlocks  *blockFeeStats
+	mediumBlocks *blockFeeStats
+	longBlocks   *blockFeeStats
+
+	// tracked holds the transactions in the pool whose confirmation is
+	// tracked.
+	tracked map[chainhash.Hash]trackedTx
+}
 
-	// Add dropped list to history.
-	if ef.maxRollback == 0 {
-		return nil
+// NewFeeEstimator creates a FeeEstimator without any statistics.
+func NewFeeEstimator() *FeeEstimator {
+	var buckets []float64
+	for feeRate := float64(minBucketFeeRate); feeRate <= maxBucketFeeRate; feeRate *= feeBucketSpacing {
+		buckets = append(buckets, feeRate)
 	}
+	buckets = append(buckets, math.Inf(1))
 
-	if uint32(len(ef.dropped)) == ef.maxRollback {
-		ef.dropped = append(ef.dropped[1:], dropped)
-	} else {
-		ef.dropped = append(ef.dropped, dropped)
+	return &FeeEstimator{
+		buckets: buckets,
+		shortStats: newTxConfirmStats(buckets, shortBlockPeriods,
+			shortScale, shortDecay),
+		mediumStats: newTxConfirmStats(buckets, mediumBlockPeriods,
+			mediumScale, mediumDecay),
+		longStats: newTxConfirmStats(buckets, longBlockPeriods,
+			longScale, longDecay),
+		shortBlocks:  newBlockFeeStats(buckets, shortDecay),
+		mediumBlocks: newBlockFeeStats(buckets, mediumDecay),
+		longBlocks:   newBlockFeeStats(buckets, longDecay),
+		tracked:      make(map[chainhash.Hash]trackedTx),
 	}
+}
 
-	return nil
+// txStats returns the statistics of the transactions of every horizon.
+func (ef *FeeEstimator) txStats() []*txConfirmStats {
+	return []*txConfirmStats{ef.shortStats, ef.mediumStats, ef.longStats}
 }
 
-// LastKnownHeight returns the height of the last block which was registered.
-func (ef *FeeEstimator) LastKnownHeight() int32 {
-	ef.mtx.Lock()
-	defer ef.mtx.Unlock()
+// blockStats returns the statistics of the blocks of every horizon.
+func (ef *FeeEstimator) blockStats() []*blockFeeStats {
+	return []*blockFeeStats{ef.shortBlocks, ef.mediumBlocks, ef.longBlocks}
+}
 
-	return ef.lastKnownHeight
+// bucketIndex returns the index of the bucket of the passed fee rate.
+func (ef *FeeEstimator) bucketIndex(feeRate float64) int {
+	return sort.SearchFloat64s(ef.buckets, feeRate)
 }
 
-// Rollback unregisters a recently registered block from the FeeEstimator.
-// This can be used to reverse the effect of an orphaned block on the fee
-// estimator. The maximum number of rollbacks allowed is given by
-// maxRollbacks.
-//
-// Note: not everything can be rolled back because some transactions are
-// deleted if they have been observed too long ago. That means the result
-// of Rollback won't always be exactly the same as if the last block had not
-// happened, but it should be close enough.
-func (ef *FeeEstimator) Rollback(hash *chainhash.Hash) error {
+// ObserveTransaction is called when a new transaction is observed in the
+// mempool.  Its confirmation is tracked if it entered the pool on top of the
+// last block which was registered, so that the number of blocks it takes to be
+// confirmed is known.
+func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 	ef.mtx.Lock()
 	defer ef.mtx.Unlock()
 
-	// Find this block in the stack of recent registered blocks.
-	var n int
-	for n = 1; n <= len(ef.dropped); n++ {
-		if ef.dropped[len(ef.dropped)-n].hash.IsEqual(hash) {
-			break
-		}
+	hash := *t.Tx.Hash()
+	if _, ok := ef.tracked[hash]; ok || t.Height != ef.bestHeight {
+		return
 	}
 
-	if n > len(ef.dropped) {
-		return errors.New("no such block was recently registered")
+	feeRate := float64(t.Fee) * bytePerKb / float64(GetTxVirtualSize(t.Tx))
+	bucket := ef.bucketIndex(feeRate)
+	for _, stats := range ef.txStats() {
+		stats.newTx(t.Height, bucket)
 	}
-
-	for i := 0; i < n; i++ {
-		ef.rollback()
+	ef.tracked[hash] = trackedTx{
+		height:  t.Height,
+		feeRate: feeRate,
+		bucket:  bucket,
 	}
-
-	return nil
 }
 
-// rollback rolls back the effect of the last block in the stack
-// of registered blocks.
-func (ef *FeeEstimator) rollback() {
-	// The previous sorted list is invalid, so delete it.
-	ef.cached = nil
+// RemoveTransaction is called when a transaction leaves the mempool for any
+// other reason than being included in a block, which counts as a failure to
+// be confirmed at its fee rate.
+func (ef *FeeEstimator) RemoveTransaction(hash *chainhash.Hash) {
+	ef.mtx.Lock()
+	ef.removeTx(hash, false)
+	ef.mtx.Unlock()
+}
 
-	// pop the last list of dropped txs from the stack.
-	last := len(ef.dropped) - 1
-	if last == -1 {
-		// Cannot really happen because the exported calling function
-		// only rolls back a block already known to be in the list
-		// of dropped transactions.
-		return
+// removeTx stops tracking the confirmation of the passed transaction, if it was
+// tracked, and returns it.
+//
+// This function MUST be called with the estimator lock held (for writes).
+func (ef *FeeEstimator) removeTx(hash *chainhash.Hash, inBlock bool) (trackedTx, bool) {
+	tx, ok := ef.tracked[*hash]
+	if !ok {
+		return tx, false
 	}
+	for _, stats := range ef.txStats() {
+		stats.removeTx(tx.height, ef.bestHeight, tx.bucket, inBlock)
+	}
+	delete(ef.tracked, *hash)
+	return tx, true
+}
 
-	dropped := ef.dropped[last]
-
-	// where we are in each bin as we replace txs?
-	var replacementCounters [estimateFeeDepth]int
-
-	// Go through the txs in the dropped block.
-	for _, o := range dropped.transactions {
-		// Which bin was this tx in?
-		blocksToConfirm := o.mined - o.observed - 1
-
-		bin := ef.bin[blocksToConfirm]
-
-		var counter = replacementCounters[blocksToConfirm]
-
-		// Continue to go through that bin where we left off.
-		for {
-			if counter >= len(bin) {
-				// Panic, as we have entered an unrecoverable invalid state.
-				panic(errors.New("illegal state: cannot rollback dropped transaction"))
-			}
-
-			prev := bin[counter]
-
-			if prev.mined == ef.lastKnownHeight {
-				prev.mined = mining.UnminedHeight
-
-				bin[counter] = o
-
-				counter++
-				break
-			}
-
-			counter++
+// registerBlock records the tracked transactions confirmed by a block of the
+// passed height including the passed transactions.  Blocks which aren't higher
+// than the last one registered, such as the ones connected during a
+// reorganization, still confirm the tracked transactions they include, which
+// would otherwise count as failures once they leave the pool, but the
+// statistics aren't decayed again for their height.  It returns whether the
+// block was higher than the last one registered.
+//
+// This function MUST be called with the estimator lock held (for writes).
+func (ef *FeeEstimator) registerBlock(height int32, txns []*btcutil.Tx) bool {
+	newHeight := height > ef.bestHeight
+	if newHeight {
+		ef.bestHeight = height
+		for _, stats := range ef.txStats() {
+			stats.clearCurrent(height)
+			stats.decayAverages()
 		}
-
-		replacementCounters[blocksToConfirm] = counter
 	}
 
-	// Continue going through bins to find other txs to remove
-	// which did not replace any other when they were entered.
-	for i, j := range replacementCounters {
-		for {
-			l := len(ef.bin[i])
-			if j >= l {
-				break
-			}
+	var counted int
+	for _, tx := range txns {
+		t, ok := ef.removeTx(tx.Hash(), true)
+		if !ok {
+			continue
+		}
+		blocksToConfirm := int(height - t.height)
+		if blocksToConfirm <= 0 {
+			continue
+		}
+		for _, stats := range ef.txStats() {
+			stats.record(blocksToConfirm, t.feeRate, t.bucket)
+		}
+		counted++
+	}
+	if ef.firstRecordedHeight == 0 && counted > 0 {
+		ef.firstRecordedHeight = ef.bestHeight
+	}
 
-			prev := ef.bin[i][j]
+	log.Debugf("Fee estimator: block %d confirmed %d of %d transactions "+
+		"tracked", height, counted, counted+len(ef.tracked))
+	return newHeight
+}
 
-			if prev.mined == ef.lastKnownHeight {
-				prev.mined = mining.UnminedHeight
+// RegisterBlock informs the fee estimator of a new block to take into account.
+func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) {
+	ef.mtx.Lock()
+	ef.registerBlock(block.Height(), block.Transactions())
+	ef.mtx.Unlock()
+}
 
-				newBin := append(ef.bin[i][0:j], ef.bin[i][j+1:l]...)
-				// TODO This line should prevent an unintentional memory
-				// leak but it causes a panic when it is uncommented.
-				// ef.bin[i][j] = nil
-				ef.bin[i] = newBin
+// RegisterUBlock informs the fee estimator of a new ublock to take into
+// account.  On top of the transactions it confirms, the fee rate it took to be
+// included in it is recorded, from the fees its transactions pay according to
+// the amounts of the leaves they spend, unless a block was already registered
+// at its height.
+func (ef *FeeEstimator) RegisterUBlock(ub *btcutil.UBlock) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
 
-				continue
-			}
+	block := ub.Block()
+	if !ef.registerBlock(ub.Height(), block.Transactions()) {
+		return
+	}
 
-			j++
-		}
+	feeRate := blockInclusionFeeRate(ublockFeeRates(ub),
+		blockchain.GetBlockWeight(block))
+	bucket := ef.bucketIndex(feeRate)
+	for _, stats := range ef.blockStats() {
+		stats.record(bucket)
 	}
+}
 
-	ef.dropped = ef.dropped[0:last]
+// LastKnownHeight returns the height of the last block which was registered.
+func (ef *FeeEstimator) LastKnownHeight() int32 {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
 
-	// The number of blocks the fee estimator has seen is decrimented.
-	ef.numBlocksRegistered--
-	ef.lastKnownHeight--
+	return ef.bestHeight
 }
 
-// estimateFeeSet is a set of txs that can that is sorted
-// by the fee per kb rate.
-type estimateFeeSet struct {
-	feeRate []SatoshiPerByte
-	bin     [estimateFeeDepth]uint32
+// blockSpan returns the number of blocks the statistics recorded since the
+// estimator was created or restored cover.
+func (ef *FeeEstimator) blockSpan() int32 {
+	if ef.firstRecordedHeight == 0 {
+		return 0
+	}
+	return ef.bestHeight - ef.firstRecordedHeight
 }
 
-func (b *estimateFeeSet) Len() int { return len(b.feeRate) }
+// historicalBlockSpan returns the number of blocks the statistics restored from
+// an earlier session cover, or zero when they're too old.
+func (ef *FeeEstimator) historicalBlockSpan() int32 {
+	if ef.historicalFirst == 0 ||
+		ef.bestHeight-ef.historicalBest > oldestEstimateHistory {
 
-func (b *estimateFeeSet) Less(i, j int) bool {
-	return b.feeRate[i] > b.feeRate[j]
+		return 0
+	}
+	return ef.historicalBest - ef.historicalFirst
 }
 
-func (b *estimateFeeSet) Swap(i, j int) {
-	b.feeRate[i], b.feeRate[j] = b.feeRate[j], b.feeRate[i]
+// maxUsableEstimate returns the highest confirmation target the statistics
+// cover enough blocks for.
+func (ef *FeeEstimator) maxUsableEstimate() int {
+	span := ef.blockSpan()
+	if historical := ef.historicalBlockSpan(); historical > span {
+		span = historical
+	}
+	if max := int(span / 2); max < MaxEstimateConfTarget {
+		return max
+	}
+	return MaxEstimateConfTarget
 }
 
-// estimateFee returns the estimated fee for a transaction
-// to confirm in confirmations blocks from now, given
-// the data set we have collected.
-func (b *estimateFeeSet) estimateFee(confirmations int) SatoshiPerByte {
-	if confirmations <= 0 {
-		return SatoshiPerByte(math.Inf(1))
-	}
+// estimateCombinedFee returns the fee rate estimated for confTarget by the
+// shortest horizon tracking it, or -1.  With checkShorterHorizon, the shorter
+// horizons can lower it when they estimate a lower fee rate for their highest
+// target.
+func (ef *FeeEstimator) estimateCombinedFee(confTarget int,
+	successThreshold float64, checkShorterHorizon bool) float64 {
 
-	if confirmations > estimateFeeDepth {
-		return 0
+	if confTarget < 1 || confTarget > ef.longStats.maxConfirms() {
+		return -1
 	}
 
-	// We don't have any transactions!
-	if len(b.feeRate) == 0 {
-		return 0
+	var estimate float64
+	switch {
+	case confTarget <= ef.shortStats.maxConfirms():
+		estimate = ef.shortStats.estimateMedianVal(confTarget,
+			sufficientTxsShort, successThreshold, ef.bestHeight)
+	case confTarget <= ef.mediumStats.maxConfirms():
+		estimate = ef.mediumStats.estimateMedianVal(confTarget,
+			sufficientFeeTxs, successThreshold, ef.bestHeight)
+	default:
+		estimate = ef.longStats.estimateMedianVal(confTarget,
+			sufficientFeeTxs, successThreshold, ef.bestHeight)
 	}
-
-	var min, max int = 0, 0
-	for i := 0; i < confirmations-1; i++ {
-		min += int(b.bin[i])
+	if !checkShorterHorizon {
+		return estimate
 	}
 
-	max = min + int(b.bin[confirmations-1]) - 1
-	if max < min {
-		max = min
+	if confTarget > ef.mediumStats.maxConfirms() {
+		medMax := ef.mediumStats.estimateMedianVal(
+			ef.mediumStats.maxConfirms(), sufficientFeeTxs,
+			successThreshold, ef.bestHeight)
+		if medMax > 0 && (estimate == -1 || medMax < estimate) {
+			estimate = medMax
+		}
 	}
-	feeIndex := (min + max) / 2
-	if feeIndex >= len(b.feeRate) {
-		feeIndex = len(b.feeRate) - 1
+	if confTarget > ef.shortStats.maxConfirms() {
+		shortMax := ef.shortStats.estimateMedianVal(
+			ef.shortStats.maxConfirms(), sufficientTxsShort,
+			successThreshold, ef.bestHeight)
+		if shortMax > 0 && (estimate == -1 || shortMax < estimate) {
+			estimate = shortMax
+		}
 	}
-
-	return b.feeRate[feeIndex]
+	return estimate
 }
 
-// newEstimateFeeSet creates a temporary data structure that
-// can be used to find all fee estimates.
-func (ef *FeeEstimator) newEstimateFeeSet() *estimateFeeSet {
-	set := &estimateFeeSet{}
-
-	capacity := 0
-	for i, b := range ef.bin {
-		l := len(b)
-		set.bin[i] = uint32(l)
-		capacity += l
+// estimateConservativeFee returns the highest fee rate estimated for
+// doubleTarget by the longer horizons, or -1.
+func (ef *FeeEstimator) estimateConservativeFee(doubleTarget int) float64 {
+	estimate := float64(-1)
+	if doubleTarget <= ef.shortStats.maxConfirms() {
+		estimate = ef.mediumStats.estimateMedianVal(doubleTarget,
+			sufficientFeeTxs, doubleSuccessPct, ef.bestHeight)
 	}
-
-	set.feeRate = make([]SatoshiPerByte, capacity)
-
-	i := 0
-	for _, b := range ef.bin {
-		for _, o := range b {
-			set.feeRate[i] = o.feeRate
-			i++
+	if doubleTarget <= ef.mediumStats.maxConfirms() {
+		longEstimate := ef.longStats.estimateMedianVal(doubleTarget,
+			sufficientFeeTxs, doubleSuccessPct, ef.bestHeight)
+		if longEstimate > estimate {
+			estimate = longEstimate
 		}
 	}
+	return estimate
+}
 
-	sort.Sort(set)
+// estimateBlockFee returns the fee rate estimated for confTarget from the fee
+// rates it took to be included in the recent blocks, or -1.  The economical
+// mode uses the short horizon if it registered enough blocks, while the
+// conservative one uses the highest fee rate of all horizons.
+func (ef *FeeEstimator) estimateBlockFee(confTarget int, conservative bool) float64 {
+	if !conservative {
+		estimate := ef.shortBlocks.estimate(confTarget, successPct)
+		if estimate < 0 {
+			estimate = ef.mediumBlocks.estimate(confTarget, successPct)
+		}
+		return estimate
+	}
 
-	return set
+	estimate := float64(-1)
+	for _, stats := range ef.blockStats() {
+		if e := stats.estimate(confTarget, doubleSuccessPct); e > estimate {
+			estimate = e
+		}
+	}
+	return estimate
 }
 
-// estimates returns the set of all fee estimates from 1 to estimateFeeDepth
-// confirmations from now.
-func (ef *FeeEstimator) estimates() []SatoshiPerByte {
-	set := ef.newEstimateFeeSet()
+// estimateSmartFee returns the fee rate, in satoshis per kilobyte, estimated
+// for confTarget, or -1, along with the target it was estimated for.
+//
+// This function MUST be called with the estimator lock held (for writes).
+func (ef *FeeEstimator) estimateSmartFee(confTarget int, conservative bool) (float64, int) {
+	// Transactions can't be expected to be confirmed in the next block
+	// since they may not have propagated to the miner yet.
+	if confTarget == 1 {
+		confTarget = 2
+	}
+	txTarget := confTarget
+	if maxUsable := ef.maxUsableEstimate(); txTarget > maxUsable {
+		txTarget = maxUsable
+	}
 
-	estimates := make([]SatoshiPerByte, estimateFeeDepth)
-	for i := 0; i < estimateFeeDepth; i++ {
-		estimates[i] = set.estimateFee(i + 1)
+	// The estimate is the highest of the ones for half the target, the
+	// target and twice the target, requiring higher success rates for the
+	// higher targets, and the conservative mode also requires the longer
+	// horizons to agree for twice the target.
+	if txTarget > 1 {
+		median := ef.estimateCombinedFee(txTarget/2, halfSuccessPct, true)
+		estimate := ef.estimateCombinedFee(txTarget, successPct, true)
+		if estimate > median {
+			median = estimate
+		}
+		estimate = ef.estimateCombinedFee(2*txTarget, doubleSuccessPct,
+			!conservative)
+		if estimate > median {
+			median = estimate
+		}
+		if conservative || median == -1 {
+			estimate = ef.estimateConservativeFee(2 * txTarget)
+			if estimate > median {
+				median = estimate
+			}
+		}
+		if median > 0 {
+			return median, txTarget
+		}
 	}
 
-	return estimates
+	// Fall back to the fee rates included by the recent blocks when the
+	// confirmation of transactions wasn't tracked enough.
+	if estimate := ef.estimateBlockFee(confTarget, conservative); estimate > 0 {
+		return estimate, confTarget
+	}
+	return -1, txTarget
 }
 
-// EstimateFee estimates the fee per byte to have a tx confirmed a given
-// number of blocks from now.
-func (ef *FeeEstimator) EstimateFee(numBlocks uint32) (BtcPerKilobyte, error) {
+// EstimateSmartFee estimates the fee rate a transaction must pay to be
+// confirmed within confTarget blocks.  The conservative mode requires the
+// longer horizons to agree on it, so that it lowers slower than the economical
+// mode when fee rates go down.
+//
+// The target the fee rate was estimated for is returned as well, even along
+// with an error, since it is lowered to the highest target the statistics
+// cover enough blocks for.
+func (ef *FeeEstimator) EstimateSmartFee(confTarget uint32,
+	conservative bool) (BtcPerKilobyte, uint32, error) {
+
+	if confTarget == 0 || confTarget > MaxEstimateConfTarget {
+		return -1, 0, fmt.Errorf("confirmation target must be between 1 "+
+			"and %d", MaxEstimateConfTarget)
+	}
+
 	ef.mtx.Lock()
-	defer ef.mtx.Unlock()
+	feeRate, target := ef.estimateSmartFee(int(confTarget), conservative)
+	ef.mtx.Unlock()
 
-	// If the number of registered blocks is below the minimum, return
-	// an error.
-	if ef.numBlocksRegistered < ef.minRegisteredBlocks {
-		return -1, errors.New("not enough blocks have been observed")
+	if feeRate < 0 {
+		return -1, uint32(target), errors.New("insufficient data or no " +
+			"fee rate found")
 	}
+	return BtcPerKilobyte(feeRate * btcPerSatoshi), uint32(target), nil
+}
 
+// EstimateFee estimates the fee rate a transaction must pay to be confirmed
+// within numBlocks blocks, in economical mode.
+func (ef *FeeEstimator) EstimateFee(numBlocks uint32) (BtcPerKilobyte, error) {
 	if numBlocks == 0 {
 		return -1, errors.New("cannot confirm transaction in zero blocks")
 	}
 
-	if numBlocks > estimateFeeDepth {
-		return -1, fmt.Errorf(
-			"can only estimate fees for up to %d blocks from now",
-			estimateFeeBinSize)
-	}
-
-	// If there are no cached results, generate them.
-	if ef.cached == nil {
-		ef.cached = ef.estimates()
-	}
-
-	return ef.cached[int(numBlocks)-1].ToBtcPerKb(), nil
+	feeRate, _, err := ef.EstimateSmartFee(numBlocks, false)
+	return feeRate, err
 }
 
 // In case the format for the serialized version of the FeeEstimator changes,
 // we use a version number. If the version number changes, it does not make
 // sense to try to upgrade a previous version to a new version. Instead, just
 // start fee estimation over.
-const estimateFeeSaveVersion = 1
-
-func deserializeRegisteredBlock(r io.Reader, txs map[uint32]*observedTransaction) (*registeredBlock, error) {
-	var lenTransactions uint32
-
-	rb := &registeredBlock{}
-	binary.Read(r, binary.BigEndian, &rb.hash)
-	binary.Read(r, binary.BigEndian, &lenTransactions)
-
-	rb.transactions = make([]*observedTransaction, lenTransactions)
-
-	for i := uint32(0); i < lenTransactions; i++ {
-		var index uint32
-		binary.Read(r, binary.BigEndian, &index)
-		rb.transactions[i] = txs[index]
-	}
-
-	return rb, nil
-}
+const estimateFeeSaveVersion = 2
 
 // FeeEstimatorState represents a saved FeeEstimator that can be
 // restored with data from an earlier session of the program.
 type FeeEstimatorState []byte
 
-// observedTxSet is a set of txs that can that is sorted
-// by hash. It exists for serialization purposes so that
-// a serialized state always comes out the same.
-type observedTxSet []*observedTransaction
-
-func (q observedTxSet) Len() int { return len(q) }
-
-func (q observedTxSet) Less(i, j int) bool {
-	return strings.Compare(q[i].hash.String(), q[j].hash.String()) < 0
-}
-
-func (q observedTxSet) Swap(i, j int) {
-	q[i], q[j] = q[j], q[i]
-}
-
 // Save records the current state of the FeeEstimator to a []byte that
-// can be restored later.
+// can be restored later.  The transactions in the pool aren't tracked anymore
+// once it's restored.
 func (ef *FeeEstimator) Save() FeeEstimatorState {
 	ef.mtx.Lock()
 	defer ef.mtx.Unlock()
 
-	// TODO figure out what the capacity should be.
 	w := bytes.NewBuffer(make([]byte, 0))
 
 	binary.Write(w, binary.BigEndian, uint32(estimateFeeSaveVersion))
 
-	// Insert basic parameters.
-	binary.Write(w, binary.BigEndian, &ef.maxRollback)
-	binary.Write(w, binary.BigEndian, &ef.binSize)
-	binary.Write(w, binary.BigEndian, &ef.maxReplacements)
-	binary.Write(w, binary.BigEndian, &ef.minRegisteredBlocks)
-	binary.Write(w, binary.BigEndian, &ef.lastKnownHeight)
-	binary.Write(w, binary.BigEndian, &ef.numBlocksRegistered)
-
-	// Put all the observed transactions in a sorted list.
-	var txCount uint32
-	ots := make([]*observedTransaction, len(ef.observed))
-	for hash := range ef.observed {
-		ots[txCount] = ef.observed[hash]
-		txCount++
+	// The blocks the statistics cover are the ones recorded during this
+	// session once they're at least half as many as the historical ones.
+	first, best := ef.historicalFirst, ef.historicalBest
+	if ef.blockSpan() > ef.historicalBlockSpan()/2 {
+		first, best = ef.firstRecordedHeight, ef.bestHeight
 	}
+	binary.Write(w, binary.BigEndian, ef.bestHeight)
+	binary.Write(w, binary.BigEndian, first)
+	binary.Write(w, binary.BigEndian, best)
 
-	sort.Sort(observedTxSet(ots))
-
-	txCount = 0
-	observed := make(map[*observedTransaction]uint32)
-	binary.Write(w, binary.BigEndian, uint32(len(ef.observed)))
-	for _, ot := range ots {
-		ot.Serialize(w)
-		observed[ot] = txCount
-		txCount++
+	for _, stats := range ef.txStats() {
+		stats.serialize(w)
 	}
-
-	// Save all the right bins.
-	for _, list := range ef.bin {
-
-		binary.Write(w, binary.BigEndian, uint32(len(list)))
-
-		for _, o := range list {
-			binary.Write(w, binary.BigEndian, observed[o])
-		}
+	for _, stats := range ef.blockStats() {
+		stats.serialize(w)
 	}
 
-	// Dropped transactions.
-	binary.Write(w, binary.BigEndian, uint32(len(ef.dropped)))
-	for _, registered := range ef.dropped {
-		registered.serialize(w, observed)
-	}
-
-	// Commit the tx and return.
 	return FeeEstimatorState(w.Bytes())
 }
 
@@ -690,57 +1038,26 @@ func RestoreFeeEstimator(data FeeEstimatorState) (*FeeEstimator, error) {
 		return nil, fmt.Errorf("Incorrect version: expected %d found %d", estimateFeeSaveVersion, version)
 	}
 
-	ef := &FeeEstimator{
-		observed: make(map[chainhash.Hash]*observedTransaction),
-	}
-
-	// Read basic parameters.
-	binary.Read(r, binary.BigEndian, &ef.maxRollback)
-	binary.Read(r, binary.BigEndian, &ef.binSize)
-	binary.Read(r, binary.BigEndian, &ef.maxReplacements)
-	binary.Read(r, binary.BigEndian, &ef.minRegisteredBlocks)
-	binary.Read(r, binary.BigEndian, &ef.lastKnownHeight)
-	binary.Read(r, binary.BigEndian, &ef.numBlocksRegistered)
-
-	// Read transactions.
-	var numObserved uint32
-	observed := make(map[uint32]*observedTransaction)
-	binary.Read(r, binary.BigEndian, &numObserved)
-	for i := uint32(0); i < numObserved; i++ {
-		ot, err := deserializeObservedTransaction(r)
-		if err != nil {
+	ef := NewFeeEstimator()
+	var first, best int32
+	for _, v := range []interface{}{&ef.bestHeight, &first, &best} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
 			return nil, err
 		}
-		observed[i] = ot
-		ef.observed[ot.hash] = ot
 	}
+	if first > best || best > ef.bestHeight {
+		return nil, fmt.Errorf("invalid recorded blocks %d to %d with "+
+			"best height %d", first, best, ef.bestHeight)
+	}
+	ef.historicalFirst, ef.historicalBest = first, best
 
-	// Read bins.
-	for i := 0; i < estimateFeeDepth; i++ {
-		var numTransactions uint32
-		binary.Read(r, binary.BigEndian, &numTransactions)
-		bin := make([]*observedTransaction, numTransactions)
-		for j := uint32(0); j < numTransactions; j++ {
-			var index uint32
-			binary.Read(r, binary.BigEndian, &index)
-
-			var exists bool
-			bin[j], exists = observed[index]
-			if !exists {
-				return nil, fmt.Errorf("Invalid transaction reference %d", index)
-			}
+	for _, stats := range ef.txStats() {
+		if err := stats.deserialize(r); err != nil {
+			return nil, err
 		}
-		ef.bin[i] = bin
 	}
-
-	// Read dropped transactions.
-	var numDropped uint32
-	binary.Read(r, binary.BigEndian, &numDropped)
-	ef.dropped = make([]*registeredBlock, numDropped)
-	for i := uint32(0); i < numDropped; i++ {
-		var err error
-		ef.dropped[int(i)], err = deserializeRegisteredBlock(r, observed)
-		if err != nil {
+	for _, stats := range ef.blockStats() {
+		if err := stats.deserialize(r); err != nil {
 			return nil, err
 		}
 	}
@@ -6,36 +6,17 @@ package mempool
 
 import (
 	"bytes"
-	"math/rand"
+	"math"
 	"testing"
 
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/mining"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/btcacc"
 )
 
-// newTestFeeEstimator creates a feeEstimator with some different parameters
-// for testing purposes.
-func newTestFeeEstimator(binSize, maxReplacements, maxRollback uint32) *FeeEstimator {
-	return &FeeEstimator{
-		maxRollback:         maxRollback,
-		lastKnownHeight:     0,
-		binSize:             int32(binSize),
-		minRegisteredBlocks: 0,
-		maxReplacements:     int32(maxReplacements),
-		observed:            make(map[chainhash.Hash]*observedTransaction),
-		dropped:             make([]*registeredBlock, 0, maxRollback),
-	}
-}
-
-// lastBlock is a linked list of the block hashes which have been
-// processed by the test FeeEstimator.
-type lastBlock struct {
-	hash *chainhash.Hash
-	prev *lastBlock
-}
-
 // estimateFeeTester interacts with the FeeEstimator to keep track
 // of its expected state.
 type estimateFeeTester struct {
@@ -43,382 +24,417 @@ type estimateFeeTester struct {
 	t       *testing.T
 	version int32
 	height  int32
-	last    *lastBlock
+
+	// pending holds the observed transactions by the height of the block
+	// which will include them.
+	pending map[int32][]*TxDesc
 }
 
-func (eft *estimateFeeTester) testTx(fee btcutil.Amount) *TxDesc {
+// newEstimateFeeTester returns a tester of a new FeeEstimator which registered
+// a first block.
+func newEstimateFeeTester(t *testing.T) *estimateFeeTester {
+	eft := &estimateFeeTester{
+		ef:      NewFeeEstimator(),
+		t:       t,
+		pending: make(map[int32][]*TxDesc),
+	}
+	eft.newBlock()
+	return eft
+}
+
+// testTx returns a new transaction entering the pool at the current height and
+// paying the passed fee rate in satoshis per kilobyte.
+func (eft *estimateFeeTester) testTx(feeRate int64) *TxDesc {
 	eft.version++
+	tx := btcutil.NewTx(&wire.MsgTx{
+		Version: eft.version,
+	})
 	return &TxDesc{
 		TxDesc: mining.TxDesc{
-			Tx: btcutil.NewTx(&wire.MsgTx{
-				Version: eft.version,
-			}),
+			Tx:     tx,
 			Height: eft.height,
-			Fee:    int64(fee),
+			Fee:    feeRate * GetTxVirtualSize(tx) / 1000,
 		},
-		StartingPriority: 0,
 	}
 }
 
-func expectedFeePerKilobyte(t *TxDesc) BtcPerKilobyte {
-	size := float64(t.TxDesc.Tx.MsgTx().SerializeSize())
-	fee := float64(t.TxDesc.Fee)
+// observe makes the fee estimator observe count transactions paying the passed
+// fee rate, which will be included in the block confirmBlocks blocks from now.
+// They are never included when confirmBlocks is zero.
+func (eft *estimateFeeTester) observe(feeRate int64, count int,
+	confirmBlocks int32) []*TxDesc {
 
-	return SatoshiPerByte(fee / size).ToBtcPerKb()
+	txns := make([]*TxDesc, count)
+	for i := range txns {
+		txns[i] = eft.testTx(feeRate)
+		eft.ef.ObserveTransaction(txns[i])
+	}
+	if confirmBlocks > 0 {
+		height := eft.height + confirmBlocks
+		eft.pending[height] = append(eft.pending[height], txns...)
+	}
+	return txns
 }
 
-func (eft *estimateFeeTester) newBlock(txs []*wire.MsgTx) {
+// newBlock registers the next block, which includes the pending transactions
+// confirmed at its height.
+func (eft *estimateFeeTester) newBlock() {
 	eft.height++
 
+	var txns []*wire.MsgTx
+	for _, txD := range eft.pending[eft.height] {
+		txns = append(txns, txD.Tx.MsgTx())
+	}
+	delete(eft.pending, eft.height)
+
 	block := btcutil.NewBlock(&wire.MsgBlock{
-		Transactions: txs,
+		Transactions: txns,
 	})
 	block.SetHeight(eft.height)
-
-	eft.last = &lastBlock{block.Hash(), eft.last}
-
 	eft.ef.RegisterBlock(block)
 }
 
-func (eft *estimateFeeTester) rollback() {
-	if eft.last == nil {
-		return
-	}
+// checkEstimate ensures the fee estimator estimates the passed fee rate in
+// satoshis per kilobyte for the passed target.
+func (eft *estimateFeeTester) checkEstimate(confTarget uint32, conservative bool,
+	wantFeeRate float64, wantTarget uint32) {
 
-	err := eft.ef.Rollback(eft.last.hash)
+	eft.t.Helper()
 
+	feeRate, target, err := eft.ef.EstimateSmartFee(confTarget, conservative)
 	if err != nil {
-		eft.t.Errorf("Could not rollback: %v", err)
+		eft.t.Fatalf("unable to estimate fee rate for target %d: %v",
+			confTarget, err)
+	}
+	want := wantFeeRate * btcPerSatoshi
+	if math.Abs(float64(feeRate)-want) > want*1e-9 {
+		eft.t.Fatalf("got fee rate %v for target %d, want %v", feeRate,
+			confTarget, want)
+	}
+	if target != wantTarget {
+		eft.t.Fatalf("got target %d for target %d, want %d", target,
+			confTarget, wantTarget)
 	}
-
-	eft.height--
-	eft.last = eft.last.prev
 }
 
-// TestEstimateFee tests basic functionality in the FeeEstimator.
-func TestEstimateFee(t *testing.T) {
-	ef := newTestFeeEstimator(5, 3, 1)
-	eft := estimateFeeTester{ef: ef, t: t}
+// TestEstimateSmartFee ensures the fee rates of the transactions confirmed
+// within the requested targets are estimated.
+func TestEstimateSmartFee(t *testing.T) {
+	t.Parallel()
 
-	// Try with no txs and get zero for all queries.
-	expected := BtcPerKilobyte(0.0)
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f when estimator is empty; got %f", expected, estimated)
-		}
+	eft := newEstimateFeeTester(t)
+	if _, target, err := eft.ef.EstimateSmartFee(6, false); err == nil {
+		t.Fatal("estimated a fee rate without any transaction")
+	} else if target != 0 {
+		t.Fatalf("got target %d without any transaction, want 0", target)
 	}
 
-	// Now insert a tx.
-	tx := eft.testTx(1000000)
-	ef.ObserveTransaction(tx)
-
-	// Expected should still be zero because this is still in the mempool.
-	expected = BtcPerKilobyte(0.0)
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f when estimator has one tx in mempool; got %f", expected, estimated)
-		}
+	// Transactions paying 20000 satoshis per kilobyte are confirmed in the
+	// next block, while the ones paying 5000 take ten blocks.
+	for i := 0; i < 100; i++ {
+		eft.observe(20000, 10, 1)
+		eft.observe(5000, 10, 10)
+		eft.newBlock()
 	}
 
-	// Change minRegisteredBlocks to make sure that works. Error return
-	// value expected.
-	ef.minRegisteredBlocks = 1
-	expected = BtcPerKilobyte(-1.0)
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f before any blocks have been registered; got %f", expected, estimated)
-		}
+	// The next block can't be targeted, and the highest target is half the
+	// number of blocks the transactions have been tracked over.
+	for _, conservative := range []bool{false, true} {
+		eft.checkEstimate(1, conservative, 20000, 2)
+		eft.checkEstimate(2, conservative, 20000, 2)
+		eft.checkEstimate(20, conservative, 5000, 20)
+		eft.checkEstimate(100, conservative, 5000, 49)
 	}
 
-	// Record a block with the new tx.
-	eft.newBlock([]*wire.MsgTx{tx.Tx.MsgTx()})
-	expected = expectedFeePerKilobyte(tx)
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f when one tx is binned; got %f", expected, estimated)
-		}
+	feeRate, err := eft.ef.EstimateFee(20)
+	if err != nil {
+		t.Fatalf("unable to estimate fee: %v", err)
+	}
+	if want := BtcPerKilobyte(5000 * btcPerSatoshi); math.Abs(float64(feeRate-want)) > 1e-12 {
+		t.Fatalf("got fee rate %v, want %v", feeRate, want)
 	}
 
-	// Roll back the last block; this was an orphan block.
-	ef.minRegisteredBlocks = 0
-	eft.rollback()
-	expected = BtcPerKilobyte(0.0)
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f after rolling back block; got %f", expected, estimated)
+	for _, confTarget := range []uint32{0, MaxEstimateConfTarget + 1} {
+		_, _, err := eft.ef.EstimateSmartFee(confTarget, false)
+		if err == nil {
+			t.Fatalf("estimated a fee rate for target %d", confTarget)
 		}
 	}
+}
 
-	// Record an empty block and then a block with the new tx.
-	// This test was made because of a bug that only appeared when there
-	// were no transactions in the first bin.
-	eft.newBlock([]*wire.MsgTx{})
-	eft.newBlock([]*wire.MsgTx{tx.Tx.MsgTx()})
-	expected = expectedFeePerKilobyte(tx)
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f when one tx is binned; got %f", expected, estimated)
+// TestEstimateSmartFeeFailures ensures the fee rates of transactions which
+// leave the pool without being confirmed, or which are still waiting for it,
+// aren't estimated.
+func TestEstimateSmartFeeFailures(t *testing.T) {
+	t.Parallel()
+
+	// Half of the cheap transactions are confirmed within ten blocks while
+	// the others leave the pool after 25 blocks.
+	eft := newEstimateFeeTester(t)
+	var removed [][]*TxDesc
+	for i := 0; i < 100; i++ {
+		eft.observe(20000, 10, 1)
+		eft.observe(5000, 10, 10)
+		removed = append(removed, eft.observe(5000, 10, 0))
+		if len(removed) > 25 {
+			for _, txD := range removed[0] {
+				eft.ef.RemoveTransaction(txD.Tx.Hash())
+			}
+			removed = removed[1:]
 		}
+		eft.newBlock()
 	}
-
-	// Create some more transactions.
-	txA := eft.testTx(500000)
-	txB := eft.testTx(2000000)
-	txC := eft.testTx(4000000)
-	ef.ObserveTransaction(txA)
-	ef.ObserveTransaction(txB)
-	ef.ObserveTransaction(txC)
-
-	// Record 7 empty blocks.
-	for i := 0; i < 7; i++ {
-		eft.newBlock([]*wire.MsgTx{})
+	eft.checkEstimate(20, false, 20000, 20)
+
+	// Transactions waiting in the pool longer than the target count as
+	// failures as well.
+	eft = newEstimateFeeTester(t)
+	for i := 0; i < 100; i++ {
+		eft.observe(20000, 10, 1)
+		eft.observe(5000, 10, 10)
+		eft.observe(5000, 40, 0)
+		eft.newBlock()
 	}
+	eft.checkEstimate(20, false, 20000, 20)
+}
 
-	// Mine the first tx.
-	eft.newBlock([]*wire.MsgTx{txA.Tx.MsgTx()})
+// TestEstimateFeeTracking ensures the fee estimator only tracks the
+// transactions accepted to the pool on their own until they leave it.
+func TestEstimateFeeTracking(t *testing.T) {
+	t.Parallel()
 
-	// Now the estimated amount should depend on the value
-	// of the argument to estimate fee.
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-		if i > 2 {
-			expected = expectedFeePerKilobyte(txA)
-		} else {
-			expected = expectedFeePerKilobyte(tx)
-		}
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f on round %d; got %f", expected, i, estimated)
-		}
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
 	}
-
-	// Record 5 more empty blocks.
-	for i := 0; i < 5; i++ {
-		eft.newBlock([]*wire.MsgTx{})
+	ctx := &testContext{t, harness}
+	ef := NewFeeEstimator()
+	harness.txPool.cfg.FeeEstimator = ef
+	coinbase := ctx.addCoinbaseTx(1)
+
+	// Transactions entering the pool before the fee estimator registered
+	// the tip aren't tracked.
+	parent := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 1000, false, false)
+	if len(ef.tracked) != 0 {
+		t.Fatalf("tracked %d transactions, want 0", len(ef.tracked))
 	}
-
-	// Mine the next tx.
-	eft.newBlock([]*wire.MsgTx{txB.Tx.MsgTx()})
-
-	// Now the estimated amount should depend on the value
-	// of the argument to estimate fee.
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-		if i <= 2 {
-			expected = expectedFeePerKilobyte(txB)
-		} else if i <= 8 {
-			expected = expectedFeePerKilobyte(tx)
-		} else {
-			expected = expectedFeePerKilobyte(txA)
-		}
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f on round %d; got %f", expected, i, estimated)
-		}
+	harness.txPool.RemoveTransaction(parent, true)
+
+	block := btcutil.NewBlock(&wire.MsgBlock{})
+	block.SetHeight(harness.chain.BestHeight())
+	ef.RegisterBlock(block)
+
+	// A child is accepted after its parent, but only the parent is
+	// tracked.
+	parent = ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 1000, false, false)
+	child := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 0)}, 1, 1000, false, false)
+	if _, ok := ef.tracked[*parent.Hash()]; !ok || len(ef.tracked) != 1 {
+		t.Fatalf("tracked %d transactions, want the parent only",
+			len(ef.tracked))
 	}
 
-	// Record 9 more empty blocks.
-	for i := 0; i < 10; i++ {
-		eft.newBlock([]*wire.MsgTx{})
-	}
-
-	// Mine txC.
-	eft.newBlock([]*wire.MsgTx{txC.Tx.MsgTx()})
-
-	// This should have no effect on the outcome because too
-	// many blocks have been mined for txC to be recorded.
-	for i := uint32(1); i <= estimateFeeDepth; i++ {
-		estimated, _ := ef.EstimateFee(i)
-		if i <= 2 {
-			expected = expectedFeePerKilobyte(txC)
-		} else if i <= 8 {
-			expected = expectedFeePerKilobyte(txB)
-		} else if i <= 8+6 {
-			expected = expectedFeePerKilobyte(tx)
-		} else {
-			expected = expectedFeePerKilobyte(txA)
-		}
-
-		if estimated != expected {
-			t.Errorf("Estimate fee error: expected %f on round %d; got %f", expected, i, estimated)
-		}
+	// Removing the parent and its child stops tracking the parent.
+	harness.txPool.RemoveTransaction(parent, true)
+	testPoolMembership(ctx, child, false, false)
+	if len(ef.tracked) != 0 {
+		t.Fatalf("tracked %d transactions, want 0", len(ef.tracked))
 	}
 }
 
-func (eft *estimateFeeTester) estimates() [estimateFeeDepth]BtcPerKilobyte {
+// TestEstimateFeeReorg ensures the tracked transactions included in the blocks
+// connected during a reorganization are recorded as confirmed rather than as
+// failures, without the statistics being decayed again.
+func TestEstimateFeeReorg(t *testing.T) {
+	t.Parallel()
 
-	// Generate estimates
-	var estimates [estimateFeeDepth]BtcPerKilobyte
-	for i := 0; i < estimateFeeDepth; i++ {
-		estimates[i], _ = eft.ef.EstimateFee(uint32(i + 1))
+	eft := newEstimateFeeTester(t)
+	for i := 0; i < 10; i++ {
+		eft.observe(20000, 10, 1)
+		eft.newBlock()
+	}
+	txns := eft.observe(5000, 10, 0)
+	eft.newBlock()
+	eft.newBlock()
+
+	// A block replacing the tip confirms the transactions two blocks
+	// after they entered the pool.
+	stats := eft.ef.mediumStats
+	bucket := eft.ef.bucketIndex(5000)
+	confirmed := stats.confAvg[0][bucket]
+	txCount := stats.txCtAvg[bucket]
+	var msgTxns []*wire.MsgTx
+	for _, txD := range txns {
+		msgTxns = append(msgTxns, txD.Tx.MsgTx())
+	}
+	block := btcutil.NewBlock(&wire.MsgBlock{Transactions: msgTxns})
+	block.SetHeight(eft.height)
+	eft.ef.RegisterBlock(block)
+	for _, txD := range txns {
+		eft.ef.RemoveTransaction(txD.Tx.Hash())
 	}
 
-	// Check that all estimated fee results go in descending order.
-	for i := 1; i < estimateFeeDepth; i++ {
-		if estimates[i] > estimates[i-1] {
-			eft.t.Error("Estimates not in descending order; got ",
-				estimates[i], " for estimate ", i, " and ", estimates[i-1], " for ", (i - 1))
-			panic("invalid state.")
+	if len(eft.ef.tracked) != 0 {
+		t.Fatalf("tracked %d transactions, want 0", len(eft.ef.tracked))
+	}
+	if height := eft.ef.LastKnownHeight(); height != eft.height {
+		t.Fatalf("got last known height %d, want %d", height, eft.height)
+	}
+	if got := stats.txCtAvg[bucket]; got != txCount+10 {
+		t.Fatalf("got %v confirmed transactions, want %v", got,
+			txCount+10)
+	}
+	if got := stats.confAvg[0][bucket]; got != confirmed+10 {
+		t.Fatalf("got %v transactions confirmed within the first "+
+			"period, want %v", got, confirmed+10)
+	}
+	for _, failAvg := range stats.failAvg {
+		if failAvg[bucket] != 0 {
+			t.Fatalf("got %v failures, want none", failAvg[bucket])
 		}
 	}
-
-	return estimates
 }
 
-func (eft *estimateFeeTester) round(txHistory [][]*TxDesc,
-	estimateHistory [][estimateFeeDepth]BtcPerKilobyte,
-	txPerRound, txPerBlock uint32) ([][]*TxDesc, [][estimateFeeDepth]BtcPerKilobyte) {
-
-	// generate new txs.
-	var newTxs []*TxDesc
-	for i := uint32(0); i < txPerRound; i++ {
-		newTx := eft.testTx(btcutil.Amount(rand.Intn(1000000)))
-		eft.ef.ObserveTransaction(newTx)
-		newTxs = append(newTxs, newTx)
+// TestBlockInclusionFeeRate ensures the fee rate it took to be included in a
+// block is the one of its cheapest transactions except the outliers, unless
+// it wasn't full.
+func TestBlockInclusionFeeRate(t *testing.T) {
+	t.Parallel()
+
+	txns := []blockTxFeeRate{
+		{feeRate: 30000, size: 400},
+		{feeRate: 1000, size: 40},
+		{feeRate: 5000, size: 400},
+		{feeRate: 10000, size: 200},
 	}
-
-	// Generate mempool.
-	mempool := make(map[*observedTransaction]*TxDesc)
-	for _, h := range txHistory {
-		for _, t := range h {
-			if o, exists := eft.ef.observed[*t.Tx.Hash()]; exists && o.mined == mining.UnminedHeight {
-				mempool[o] = t
-			}
-		}
+	if feeRate := blockInclusionFeeRate(txns, blockFullWeight-1); feeRate != 0 {
+		t.Fatalf("got fee rate %v for a block which isn't full, want 0",
+			feeRate)
 	}
-
-	// generate new block, with no duplicates.
-	i := uint32(0)
-	newBlockList := make([]*wire.MsgTx, 0, txPerBlock)
-	for _, t := range mempool {
-		newBlockList = append(newBlockList, t.TxDesc.Tx.MsgTx())
-		i++
-
-		if i == txPerBlock {
-			break
-		}
+	if feeRate := blockInclusionFeeRate(txns, blockFullWeight); feeRate != 5000 {
+		t.Fatalf("got fee rate %v for a full block, want 5000", feeRate)
 	}
-
-	// Register a new block.
-	eft.newBlock(newBlockList)
-
-	// return results.
-	estimates := eft.estimates()
-
-	// Return results
-	return append(txHistory, newTxs), append(estimateHistory, estimates)
 }
 
-// TestEstimateFeeRollback tests the rollback function, which undoes the
-// effect of a adding a new block.
-func TestEstimateFeeRollback(t *testing.T) {
-	txPerRound := uint32(7)
-	txPerBlock := uint32(5)
-	binSize := uint32(6)
-	maxReplacements := uint32(4)
-	stepsBack := 2
-	rounds := 30
-
-	eft := estimateFeeTester{ef: newTestFeeEstimator(binSize, maxReplacements, uint32(stepsBack)), t: t}
-	var txHistory [][]*TxDesc
-	estimateHistory := [][estimateFeeDepth]BtcPerKilobyte{eft.estimates()}
-
-	for round := 0; round < rounds; round++ {
-		// Go forward a few rounds.
-		for step := 0; step <= stepsBack; step++ {
-			txHistory, estimateHistory =
-				eft.round(txHistory, estimateHistory, txPerRound, txPerBlock)
+// TestUBlockFeeRates ensures the fees of the transactions of a ublock are
+// computed from the amounts of its leaf datas and of the outputs it creates.
+func TestUBlockFeeRates(t *testing.T) {
+	t.Parallel()
+
+	leaf := btcacc.LeafData{TxHash: btcacc.Hash{0x01}, Amt: 10000}
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{})
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, nil))
+	spendLeaf := wire.NewMsgTx(wire.TxVersion)
+	spendLeaf.AddTxIn(wire.NewTxIn(wire.NewOutPoint(
+		(*chainhash.Hash)(&leaf.TxHash), 0), nil, nil))
+	spendLeaf.AddTxOut(wire.NewTxOut(9000, nil))
+	spendLeafHash := spendLeaf.TxHash()
+	spendBlock := wire.NewMsgTx(wire.TxVersion)
+	spendBlock.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&spendLeafHash, 0),
+		nil, nil))
+	spendBlock.AddTxOut(wire.NewTxOut(8500, nil))
+	spendUnknown := wire.NewMsgTx(wire.TxVersion)
+	spendUnknown.AddTxIn(wire.NewTxIn(wire.NewOutPoint(
+		&chainhash.Hash{0x02}, 0), nil, nil))
+	spendUnknown.AddTxOut(wire.NewTxOut(1000, nil))
+
+	ub := btcutil.NewUBlock(&wire.MsgUBlock{
+		MsgBlock: wire.MsgBlock{Transactions: []*wire.MsgTx{coinbase,
+			spendLeaf, spendBlock, spendUnknown}},
+		UtreexoData: btcacc.UData{Stxos: []btcacc.LeafData{leaf}},
+	})
+	feeRates := ublockFeeRates(ub)
+	if len(feeRates) != 2 {
+		t.Fatalf("got %d fee rates, want 2", len(feeRates))
+	}
+	for i, fee := range []int64{1000, 500} {
+		size := int64(spendLeaf.SerializeSize())
+		want := blockTxFeeRate{
+			feeRate: float64(fee) * 1000 / float64(size),
+			size:    size,
 		}
-
-		// Now go back.
-		for step := 0; step < stepsBack; step++ {
-			eft.rollback()
-
-			// After rolling back, we should have the same estimated
-			// fees as before.
-			expected := estimateHistory[len(estimateHistory)-step-2]
-			estimates := eft.estimates()
-
-			// Ensure that these are both the same.
-			for i := 0; i < estimateFeeDepth; i++ {
-				if expected[i] != estimates[i] {
-					t.Errorf("Rollback value mismatch. Expected %f, got %f. ",
-						expected[i], estimates[i])
-					return
-				}
-			}
+		if feeRates[i] != want {
+			t.Fatalf("got fee rate %+v for transaction %d, want %+v",
+				feeRates[i], i, want)
 		}
-
-		// Erase history.
-		txHistory = txHistory[0 : len(txHistory)-stepsBack]
-		estimateHistory = estimateHistory[0 : len(estimateHistory)-stepsBack]
 	}
 }
 
-func (eft *estimateFeeTester) checkSaveAndRestore(
-	previousEstimates [estimateFeeDepth]BtcPerKilobyte) {
-
-	// Get the save state.
-	save := eft.ef.Save()
+// TestEstimateBlockFee ensures the fee rates are estimated from the fee rates
+// it took to be included in the recent blocks when there are no transactions
+// tracked.
+func TestEstimateBlockFee(t *testing.T) {
+	t.Parallel()
 
-	// Save and restore database.
-	var err error
-	eft.ef, err = RestoreFeeEstimator(save)
-	if err != nil {
-		eft.t.Fatalf("Could not restore database: %s", err)
+	ef := NewFeeEstimator()
+	if _, _, err := ef.EstimateSmartFee(6, false); err == nil {
+		t.Fatal("estimated a fee rate without any block")
 	}
-
-	// Save again and check that it matches the previous one.
-	redo := eft.ef.Save()
-	if !bytes.Equal(save, redo) {
-		eft.t.Fatalf("Restored states do not match: %v %v", save, redo)
+	for height := int32(1); height <= 2*sufficientBlocks; height++ {
+		ub := btcutil.NewUBlock(&wire.MsgUBlock{})
+		ub.SetHeight(height)
+		ef.RegisterUBlock(ub)
 	}
 
-	// Check that the results match.
-	newEstimates := eft.estimates()
+	// The blocks weren't full so any fee rate was included.
+	eft := &estimateFeeTester{ef: ef, t: t}
+	eft.checkEstimate(6, false, minBucketFeeRate, 6)
+	eft.checkEstimate(6, true, minBucketFeeRate, 6)
 
-	for i, prev := range previousEstimates {
-		if prev != newEstimates[i] {
-			eft.t.Error("Mismatch in estimate ", i, " after restore; got ", newEstimates[i], " but expected ", prev)
-		}
+	// A transaction paying a fee rate included by half the blocks has a
+	// high chance of being confirmed within six blocks, but not within
+	// the next one.
+	stats := newBlockFeeStats(ef.buckets, 1)
+	low, high := ef.bucketIndex(1000), ef.bucketIndex(20000)
+	for i := 0; i < 10; i++ {
+		stats.record(low)
+		stats.record(high)
+	}
+	if feeRate := stats.estimate(6, successPct); feeRate != ef.buckets[low] {
+		t.Fatalf("got fee rate %v for six blocks, want %v", feeRate,
+			ef.buckets[low])
+	}
+	if feeRate := stats.estimate(1, successPct); feeRate != ef.buckets[high] {
+		t.Fatalf("got fee rate %v for the next block, want %v", feeRate,
+			ef.buckets[high])
 	}
 }
 
-// TestSave tests saving and restoring to a []byte.
-func TestDatabase(t *testing.T) {
+// TestSaveRestore ensures a restored fee estimator estimates the same fee rates
+// as the saved one.
+func TestSaveRestore(t *testing.T) {
+	t.Parallel()
 
-	txPerRound := uint32(7)
-	txPerBlock := uint32(5)
-	binSize := uint32(6)
-	maxReplacements := uint32(4)
-	rounds := 8
-
-	eft := estimateFeeTester{ef: newTestFeeEstimator(binSize, maxReplacements, uint32(rounds)+1), t: t}
-	var txHistory [][]*TxDesc
-	estimateHistory := [][estimateFeeDepth]BtcPerKilobyte{eft.estimates()}
+	eft := newEstimateFeeTester(t)
+	for i := 0; i < 100; i++ {
+		eft.observe(20000, 10, 1)
+		eft.observe(5000, 10, 10)
+		eft.newBlock()
+	}
+	saved := eft.ef.Save()
 
-	for round := 0; round < rounds; round++ {
-		eft.checkSaveAndRestore(estimateHistory[len(estimateHistory)-1])
+	ef, err := RestoreFeeEstimator(saved)
+	if err != nil {
+		t.Fatalf("unable to restore fee estimator: %v", err)
+	}
+	eft.ef = ef
+	eft.checkEstimate(2, false, 20000, 2)
+	eft.checkEstimate(100, true, 5000, 49)
 
-		// Go forward one step.
-		txHistory, estimateHistory =
-			eft.round(txHistory, estimateHistory, txPerRound, txPerBlock)
+	// Saving it again gives the same state.
+	if !bytes.Equal(ef.Save(), saved) {
+		t.Fatal("restored fee estimator saved a different state")
 	}
 
-	// Reverse the process and try again.
-	for round := 1; round <= rounds; round++ {
-		eft.rollback()
-		eft.checkSaveAndRestore(estimateHistory[len(estimateHistory)-round-1])
+	// States of an unknown version or truncated aren't restored.
+	if _, err := RestoreFeeEstimator(saved[:len(saved)-1]); err == nil {
+		t.Fatal("restored a truncated state")
+	}
+	saved[3] = estimateFeeSaveVersion + 1
+	if _, err := RestoreFeeEstimator(saved); err == nil {
+		t.Fatal("restored a state of an unknown version")
 	}
 }
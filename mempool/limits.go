@@ -0,0 +1,334 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/heap"
+	"math"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// DefaultMaxPoolSize is the default maximum estimated memory usage in
+	// bytes of the transactions in the pool.
+	DefaultMaxPoolSize = 300 * 1000 * 1000
+
+	// DefaultExpiryTime is the default amount of time a transaction is
+	// allowed to stay in the pool before it is evicted.
+	DefaultExpiryTime = time.Hour * 336
+
+	// poolExpireScanInterval is the minimum amount of time in between scans
+	// of the pool to evict expired transactions.
+	poolExpireScanInterval = time.Minute * 5
+
+	// rollingFeeHalfLife is the time it takes the rolling minimum fee to
+	// decay by half once a block has been connected since it was last
+	// raised.  It decays faster while the pool is well under its limit.
+	rollingFeeHalfLife = time.Hour * 12
+
+	// rollingFeeUpdateInterval is the minimum amount of time in between
+	// updates of the decaying rolling minimum fee.
+	rollingFeeUpdateInterval = time.Second * 10
+
+	// This value is calculated by running the following on a 64-bit
+	// system:
+	//   unsafe.Sizeof(TxDesc{}) + unsafe.Sizeof(btcutil.Tx{}) +
+	//   unsafe.Sizeof(wire.MsgTx{})
+	// along with the two cached hashes of the btcutil.Tx and the key and
+	// value of its entry in the pool map.
+	txOverhead = 112 + 64 + 64 + 2*chainhash.HashSize + chainhash.HashSize + 8
+
+	// This value is calculated by running the following on a 64-bit
+	// system:
+	//   unsafe.Sizeof(wire.TxIn{})
+	// along with the pointer to it and the key and value of its entry in
+	// the outpoints map.
+	txInOverhead = 96 + 8 + 36 + 8
+
+	// This value is calculated by running the following on a 64-bit
+	// system:
+	//   unsafe.Sizeof(wire.TxOut{})
+	// along with the pointer to it.
+	txOutOverhead = 32 + 8
+
+	// witnessItemOverhead is the size of the slice header of a witness
+	// item.
+	witnessItemOverhead = 24
+)
+
+// txUsage returns the estimated memory usage in bytes of the passed
+// transaction once added to the pool.  It does not account for the rounding
+// of the allocator or the load factor of the maps, so it is only meant to be
+// compared against the limit set by Policy.MaxPoolSize.
+func txUsage(tx *btcutil.Tx) int64 {
+	msgTx := tx.MsgTx()
+	usage := int64(txOverhead)
+	for _, txIn := range msgTx.TxIn {
+		usage += txInOverhead + int64(len(txIn.SignatureScript))
+		for _, item := range txIn.Witness {
+			usage += witnessItemOverhead + int64(len(item))
+		}
+	}
+	for _, txOut := range msgTx.TxOut {
+		usage += txOutOverhead + int64(len(txOut.PkScript))
+	}
+	return usage
+}
+
+// minFee returns the minimum fee rate in satoshi/kB transactions must pay to be
+// accepted to the pool, which is raised when transactions are evicted because
+// the pool is full and decays once blocks are connected.  It is zero when no
+// transactions have been evicted recently, in which case only the minimum relay
+// fee applies.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) minFee() btcutil.Amount {
+	if !mp.blockSinceFeeBump || mp.rollingMinFee == 0 {
+		return btcutil.Amount(math.Ceil(mp.rollingMinFee))
+	}
+
+	now := time.Now()
+	if now.After(mp.lastRollingFeeUpdate.Add(rollingFeeUpdateInterval)) {
+		halfLife := rollingFeeHalfLife
+		maxPoolSize := mp.cfg.Policy.MaxPoolSize
+		switch {
+		case mp.totalUsage < maxPoolSize/4:
+			halfLife /= 4
+		case mp.totalUsage < maxPoolSize/2:
+			halfLife /= 2
+		}
+
+		elapsed := now.Sub(mp.lastRollingFeeUpdate)
+		mp.rollingMinFee /= math.Pow(2, float64(elapsed)/float64(halfLife))
+		mp.lastRollingFeeUpdate = now
+
+		// Stop requiring a fee over the minimum relay fee once the
+		// rolling fee has decayed to less than half of it.
+		if mp.rollingMinFee < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+			mp.rollingMinFee = 0
+			return 0
+		}
+	}
+
+	minFee := btcutil.Amount(math.Ceil(mp.rollingMinFee))
+	if minFee < mp.cfg.Policy.MinRelayTxFee {
+		minFee = mp.cfg.Policy.MinRelayTxFee
+	}
+	return minFee
+}
+
+// limitPoolSize evicts the transactions which have been in the pool for longer
+// than the expiry time, and then the packages with the lowest descendant fee
+// rate until the estimated memory usage of the pool is within its limit.  Each
+// eviction because of the limit raises the rolling minimum fee over the fee
+// rate of the evicted package.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitPoolSize() {
+	mp.expireTransactions(false)
+
+	maxPoolSize := mp.cfg.Policy.MaxPoolSize
+	if maxPoolSize <= 0 {
+		return
+	}
+	var numEvicted int
+	for mp.totalUsage > maxPoolSize && mp.evictionQueue.Len() > 0 {
+		worst := mp.evictionQueue.txns[0]
+		worstFeeRate := descendantFeeRate(worst)
+
+		// Require the transactions accepted from now on to pay more than
+		// the evicted package, so the pool doesn't keep evicting
+		// packages paying the same fee rate.
+		feeRate := worstFeeRate + float64(mp.cfg.Policy.MinRelayTxFee)
+		if feeRate > mp.rollingMinFee {
+			mp.rollingMinFee = feeRate
+			mp.blockSinceFeeBump = false
+		}
+
+		log.Debugf("Evicting transaction %v and its descendants from the "+
+			"full memory pool (fee_rate=%.0f sat/kb)", worst.Tx.Hash(),
+			worstFeeRate)
+		numEvicted += 1 + len(mp.txDescendants(worst.Tx, nil))
+		mp.removeTransaction(worst.Tx, true)
+	}
+	if numEvicted > 0 {
+		log.Debugf("Evicted %d transactions from the full memory pool, "+
+			"minimum fee rate is now %.0f sat/kb", numEvicted,
+			mp.rollingMinFee)
+	}
+}
+
+// descendantFeeRate returns the fee rate in satoshi/kB of the package made of
+// the passed transaction and its descendants in the pool.
+func descendantFeeRate(txD *TxDesc) float64 {
+	return float64(txD.DescendantFee) * 1000 / float64(txD.DescendantSize)
+}
+
+// evictionQueue is a min-heap of the transactions in the pool ordered by the
+// fee rate of the package they form with their descendants, which is the order
+// limitPoolSize evicts them in.  It implements heap.Interface, and keeps the
+// position of each transaction so it can be moved when its descendant stats
+// change.
+type evictionQueue struct {
+	txns      []*TxDesc
+	positions map[chainhash.Hash]int
+}
+
+// newEvictionQueue returns an empty eviction queue.
+func newEvictionQueue() *evictionQueue {
+	return &evictionQueue{positions: make(map[chainhash.Hash]int)}
+}
+
+// Len returns the number of transactions in the queue.  It is part of the
+// heap.Interface implementation.
+func (q *evictionQueue) Len() int {
+	return len(q.txns)
+}
+
+// Less returns whether the package of the transaction at index i pays a lower
+// fee rate than the one of the transaction at index j.  It is part of the
+// heap.Interface implementation.
+func (q *evictionQueue) Less(i, j int) bool {
+	return descendantFeeRate(q.txns[i]) < descendantFeeRate(q.txns[j])
+}
+
+// Swap swaps the transactions at the passed indices.  It is part of the
+// heap.Interface implementation.
+func (q *evictionQueue) Swap(i, j int) {
+	q.txns[i], q.txns[j] = q.txns[j], q.txns[i]
+	q.positions[*q.txns[i].Tx.Hash()] = i
+	q.positions[*q.txns[j].Tx.Hash()] = j
+}
+
+// Push appends the passed transaction descriptor to the queue.  It is part of
+// the heap.Interface implementation and must not be called directly.
+func (q *evictionQueue) Push(x interface{}) {
+	txD := x.(*TxDesc)
+	q.positions[*txD.Tx.Hash()] = len(q.txns)
+	q.txns = append(q.txns, txD)
+}
+
+// Pop removes the last transaction descriptor of the queue and returns it.  It
+// is part of the heap.Interface implementation and must not be called
+// directly.
+func (q *evictionQueue) Pop() interface{} {
+	n := len(q.txns)
+	txD := q.txns[n-1]
+	q.txns[n-1] = nil
+	q.txns = q.txns[:n-1]
+	delete(q.positions, *txD.Tx.Hash())
+	return txD
+}
+
+// add adds the passed transaction descriptor to the queue.
+func (q *evictionQueue) add(txD *TxDesc) {
+	heap.Push(q, txD)
+}
+
+// remove removes the transaction with the passed hash from the queue if
+// present.
+func (q *evictionQueue) remove(hash *chainhash.Hash) {
+	if i, ok := q.positions[*hash]; ok {
+		heap.Remove(q, i)
+	}
+}
+
+// update moves the passed transaction descriptor, whose descendant stats
+// changed, to its place in the queue.  Transactions which are not in the queue
+// are ignored.
+func (q *evictionQueue) update(txD *TxDesc) {
+	if i, ok := q.positions[*txD.Tx.Hash()]; ok {
+		heap.Fix(q, i)
+	}
+}
+
+// expireTransactions evicts the transactions which have been in the pool for
+// longer than the expiry time along with their descendants.  Unless forced,
+// the pool is only scanned once per poolExpireScanInterval.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) expireTransactions(force bool) {
+	expiryTime := mp.cfg.Policy.ExpiryTime
+	now := time.Now()
+	if expiryTime <= 0 || (!force && now.Before(mp.nextPoolExpireScan)) {
+		return
+	}
+
+	var numExpired int
+	origNumTxns := len(mp.pool)
+	for _, txD := range mp.pool {
+		if now.Sub(txD.Added) <= expiryTime {
+			continue
+		}
+
+		// The descendants of an expired transaction, which may already
+		// have been removed along with another expired transaction, are
+		// removed along with it.
+		if _, ok := mp.pool[*txD.Tx.Hash()]; ok {
+			log.Debugf("Expiring transaction %v added at %v",
+				txD.Tx.Hash(), txD.Added)
+			mp.removeTransaction(txD.Tx, true)
+			numExpired++
+		}
+	}
+	mp.nextPoolExpireScan = now.Add(poolExpireScanInterval)
+
+	if numExpired > 0 {
+		log.Debugf("Expired %d %s and %d descendants (remaining: %d)",
+			numExpired, pickNoun(numExpired, "transaction",
+				"transactions"), origNumTxns-len(mp.pool)-numExpired,
+			len(mp.pool))
+	}
+}
+
+// BlockConnected lets the pool know the passed block has been connected to the
+// main chain, once the transactions it includes have been removed from the
+// pool.  It clears the fee deltas of these transactions, allows the rolling
+// minimum fee to decay and evicts the expired transactions.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) BlockConnected(block *btcutil.Block) {
+	mp.mtx.Lock()
+	for _, tx := range block.Transactions() {
+		delete(mp.feeDeltas, *tx.Hash())
+	}
+	if !mp.blockSinceFeeBump {
+		mp.blockSinceFeeBump = true
+		mp.lastRollingFeeUpdate = time.Now()
+	}
+	mp.expireTransactions(true)
+	mp.mtx.Unlock()
+}
+
+// MinFee returns the minimum fee rate transactions must pay to be accepted to
+// the pool, which is the minimum relay fee unless transactions have recently
+// been evicted because the pool is full.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFee() btcutil.Amount {
+	mp.mtx.Lock()
+	minFee := mp.minFee()
+	mp.mtx.Unlock()
+
+	if minFee < mp.cfg.Policy.MinRelayTxFee {
+		minFee = mp.cfg.Policy.MinRelayTxFee
+	}
+	return minFee
+}
+
+// Usage returns the estimated memory usage in bytes of the transactions in the
+// main pool, which is limited by Policy.MaxPoolSize.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Usage() int64 {
+	mp.mtx.RLock()
+	usage := mp.totalUsage
+	mp.mtx.RUnlock()
+
+	return usage
+}
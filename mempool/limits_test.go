@@ -0,0 +1,222 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/heap"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestPoolSizeLimit ensures the packages paying the lowest fee rate are
+// evicted when the pool exceeds its size limit, and that the minimum fee rate
+// to enter the pool is then raised over theirs.
+func TestPoolSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+	coinbase := ctx.addCoinbaseTx(6)
+
+	// Add a parent paying no fee with a child paying for both of them, and
+	// two standalone transactions paying less than the child but more
+	// than the package.
+	parent := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 0, false, false)
+	child := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 0)}, 1, 20000, false, false)
+	low := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 1)}, 1, 9000, false, false)
+	high := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 2)}, 1, 15000, false, false)
+	if minFee := mp.MinFee(); minFee != mp.cfg.Policy.MinRelayTxFee {
+		t.Fatalf("got minimum fee %v, want %v", minFee,
+			mp.cfg.Policy.MinRelayTxFee)
+	}
+
+	// Limiting the pool to the transactions it holds doesn't evict any of
+	// them, but adding another one evicts the package paying the lowest
+	// fee rate, which isn't the parent paying no fee as its child pays for
+	// it.  The limit leaves room for the signatures of the new transaction
+	// to be a few bytes longer than the ones of the evicted one.
+	mp.cfg.Policy.MaxPoolSize = mp.Usage() + 10
+	ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(coinbase, 3)}, 1,
+		12000, false, false)
+	testPoolMembership(ctx, low, false, false)
+	for _, tx := range []*btcutil.Tx{parent, child, high} {
+		testPoolMembership(ctx, tx, false, true)
+	}
+	checkPackageStats(ctx)
+	if mp.Usage() > mp.cfg.Policy.MaxPoolSize {
+		t.Fatalf("pool usage %d is over its limit of %d", mp.Usage(),
+			mp.cfg.Policy.MaxPoolSize)
+	}
+
+	// The minimum fee rate is now over the one of the evicted transaction.
+	lowFeeRate := float64(9000) * 1000 / float64(GetTxVirtualSize(low))
+	wantMinFee := btcutil.Amount(lowFeeRate) + mp.cfg.Policy.MinRelayTxFee
+	if minFee := mp.MinFee(); minFee < wantMinFee {
+		t.Fatalf("got minimum fee %v, want at least %v", minFee,
+			wantMinFee)
+	}
+
+	// A transaction paying the same fee as the evicted one is rejected.
+	tx, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 4)}, 1, 9000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = mp.ProcessTransaction(tx, false, false, 0)
+	if err == nil {
+		t.Fatal("transaction under the minimum fee was accepted")
+	}
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("got reject code %v, want %v", code,
+			wire.RejectInsufficientFee)
+	}
+	testPoolMembership(ctx, tx, false, false)
+
+	// Lowering the limit to less than the usage of the package evicts
+	// the parent along with its child once its fee rate is the lowest.
+	mp.mtx.Lock()
+	mp.cfg.Policy.MaxPoolSize = mp.totalUsage - 1
+	mp.limitPoolSize()
+	mp.mtx.Unlock()
+	testPoolMembership(ctx, parent, false, false)
+	testPoolMembership(ctx, child, false, false)
+	testPoolMembership(ctx, high, false, true)
+	checkPackageStats(ctx)
+}
+
+// TestEvictionQueue ensures the eviction queue pops the transactions in the
+// order of their descendant fee rates as they are added, updated and removed.
+func TestEvictionQueue(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(1))
+	q := newEvictionQueue()
+	var txDescs []*TxDesc
+	for i := 0; i < 200; i++ {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+			uint32(i)), nil, nil))
+		txD := &TxDesc{
+			TxDesc:         mining.TxDesc{Tx: btcutil.NewTx(msgTx)},
+			DescendantSize: 100 + rng.Int63n(1000),
+			DescendantFee:  rng.Int63n(100000),
+		}
+		q.add(txD)
+		txDescs = append(txDescs, txD)
+	}
+
+	// Change the descendant stats of some transactions and remove others.
+	for _, txD := range txDescs[:50] {
+		txD.DescendantFee = rng.Int63n(100000)
+		txD.DescendantSize += rng.Int63n(1000)
+		q.update(txD)
+	}
+	for _, txD := range txDescs[150:] {
+		q.remove(txD.Tx.Hash())
+	}
+	q.remove(&chainhash.Hash{})
+	q.update(txDescs[199])
+
+	if q.Len() != 150 {
+		t.Fatalf("got %d queued transactions, want 150", q.Len())
+	}
+	lastFeeRate := -1.0
+	for q.Len() > 0 {
+		txD := heap.Pop(q).(*TxDesc)
+		feeRate := descendantFeeRate(txD)
+		if feeRate < lastFeeRate {
+			t.Fatalf("popped fee rate %v after %v", feeRate,
+				lastFeeRate)
+		}
+		lastFeeRate = feeRate
+	}
+	if len(q.positions) != 0 {
+		t.Fatalf("got %d positions left, want none", len(q.positions))
+	}
+}
+
+// TestRollingMinFee ensures the minimum fee rate raised by evictions only
+// decays once a block has been connected, and faster while the pool is mostly
+// empty.
+func TestRollingMinFee(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	mp := harness.txPool
+	mp.cfg.Policy.MaxPoolSize = 1000 * 1000
+	mp.rollingMinFee = 8000
+
+	// The fee doesn't decay until a block is connected.
+	mp.lastRollingFeeUpdate = time.Now().Add(-time.Hour)
+	if minFee := mp.MinFee(); minFee != 8000 {
+		t.Fatalf("got minimum fee %v, want %v", minFee, 8000)
+	}
+
+	// With the pool under a quarter of its limit, the fee halves every
+	// rollingFeeHalfLife/4.
+	mp.BlockConnected(btcutil.NewBlock(&wire.MsgBlock{}))
+	mp.lastRollingFeeUpdate = time.Now().Add(-rollingFeeHalfLife / 2)
+	if minFee := mp.MinFee(); minFee != 2000 {
+		t.Fatalf("got minimum fee %v, want %v", minFee, 2000)
+	}
+
+	// Once under half of the minimum relay fee, the fee is back to the
+	// minimum relay fee.
+	mp.lastRollingFeeUpdate = time.Now().Add(-rollingFeeHalfLife)
+	if minFee := mp.MinFee(); minFee != mp.cfg.Policy.MinRelayTxFee {
+		t.Fatalf("got minimum fee %v, want %v", minFee,
+			mp.cfg.Policy.MinRelayTxFee)
+	}
+	if mp.rollingMinFee != 0 {
+		t.Fatalf("got rolling minimum fee %v, want 0", mp.rollingMinFee)
+	}
+}
+
+// TestPoolExpiry ensures the transactions which have been in the pool for
+// longer than the expiry time are evicted along with their descendants.
+func TestPoolExpiry(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+	mp.cfg.Policy.ExpiryTime = time.Hour
+	coinbase := ctx.addCoinbaseTx(2)
+
+	parent := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 1000, false, false)
+	child := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 0)}, 1, 1000, false, false)
+	other := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 1)}, 1, 1000, false, false)
+
+	mp.pool[*parent.Hash()].Added = time.Now().Add(-2 * time.Hour)
+	mp.BlockConnected(btcutil.NewBlock(&wire.MsgBlock{}))
+	testPoolMembership(ctx, parent, false, false)
+	testPoolMembership(ctx, child, false, false)
+	testPoolMembership(ctx, other, false, true)
+	checkPackageStats(ctx)
+}
@@ -95,13 +95,20 @@ type Config struct {
 	// HashCache defines the transaction hash mid-state cache to use.
 	HashCache *txscript.HashCache
 
+	// ScriptExecCache defines the cache of the transactions whose scripts
+	// are known to be valid to use.  The transactions accepted to the pool
+	// are added to it so that their scripts are not executed again when
+	// the blocks including them are validated.  This can be nil.
+	ScriptExecCache *txscript.ScriptExecCache
+
 	// AddrIndex defines the optional address index instance to use for
 	// indexing the unconfirmed transactions in the memory pool.
 	// This can be nil if the address index is not enabled.
 	AddrIndex *indexers.AddrIndex
 
 	// FeeEstimatator provides a feeEstimator. If it is not nil, the mempool
-	// records all new transactions it observes into the feeEstimator.
+	// records all new transactions it observes into the feeEstimator, as
+	// well as the ones leaving it.
 	FeeEstimator *FeeEstimator
 }
 
@@ -148,6 +155,29 @@ type Policy struct {
 	// transactions using the Replace-By-Fee (RBF) signaling policy into
 	// the mempool.
 	RejectReplacement bool
+
+	// MaxPoolSize is the maximum estimated memory usage in bytes of the
+	// transactions in the pool.  When it is exceeded, the transactions
+	// whose packages with their descendants pay the lowest fee rate are
+	// evicted, and the minimum fee rate required to enter the pool is
+	// raised.  The limit is disabled when it is zero.
+	MaxPoolSize int64
+
+	// ExpiryTime is the amount of time a transaction is allowed to stay in
+	// the pool before it is evicted along with its descendants.  Expiry
+	// is disabled when it is zero.
+	ExpiryTime time.Duration
+
+	// MaxAncestorCount and MaxAncestorSize are the maximum number of
+	// transactions and virtual size of the package made of a transaction
+	// and its unconfirmed ancestors.  MaxDescendantCount and
+	// MaxDescendantSize are the same limits for the package made of a
+	// transaction and its descendants in the pool.  Each limit is
+	// disabled when it is zero.
+	MaxAncestorCount   int
+	MaxAncestorSize    int64
+	MaxDescendantCount int
+	MaxDescendantSize  int64
 }
 
 // TxDesc is a descriptor containing a transaction in the mempool along with
@@ -158,6 +188,14 @@ type TxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// DescendantCount, DescendantSize and DescendantFee are the number of
+	// transactions, the total virtual size and the total fee including fee
+	// deltas of the transaction along with all of its descendants in the
+	// pool.
+	DescendantCount int64
+	DescendantSize  int64
+	DescendantFee   int64
 }
 
 // orphanTx is normal transaction that references an ancestor transaction
@@ -190,6 +228,30 @@ type TxPool struct {
 	// the scan will only run when an orphan is added to the pool as opposed
 	// to on an unconditional timer.
 	nextExpireScan time.Time
+
+	// totalUsage is the estimated memory usage in bytes of the transactions
+	// in the main pool, which is kept within Policy.MaxPoolSize by evicting
+	// them in the order of evictionQueue.
+	totalUsage    int64
+	evictionQueue *evictionQueue
+
+	// rollingMinFee is the minimum fee rate in satoshi/kB required to enter
+	// the pool, which is raised when transactions are evicted to keep the
+	// pool within its size limit.  Once a block has been connected since it
+	// was last raised, as tracked by blockSinceFeeBump, it decays over
+	// time, which is last accounted for at lastRollingFeeUpdate.
+	rollingMinFee        float64
+	lastRollingFeeUpdate time.Time
+	blockSinceFeeBump    bool
+
+	// nextPoolExpireScan is the time after which the main pool will be
+	// scanned in order to evict expired transactions.
+	nextPoolExpireScan time.Time
+
+	// feeDeltas holds the fee deltas set with PrioritiseTransaction, which
+	// are kept for transactions which aren't in the pool yet until they are
+	// included in a connected block.
+	feeDeltas map[chainhash.Hash]int64
 }
 
 // Ensure the TxPool type implements the mining.TxSource interface.
@@ -486,11 +548,23 @@ func (mp *TxPool) removeTransaction(tx *btcutil.Tx, removeRedeemers bool) {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
 
-		// Mark the referenced outpoints as unspent by the pool.
+		// Update the stats of the relatives of the transaction, and
+		// mark the referenced outpoints as unspent by the pool.
+		ancestors, descendants := mp.removePackageStats(txDesc)
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		mp.evictionQueue.remove(txHash)
+		mp.totalUsage -= txUsage(tx)
+
+		// The fee estimator counts the transactions leaving the pool
+		// as failures to be confirmed, so the ones included in a block
+		// must have been registered with it beforehand.
+		if mp.cfg.FeeEstimator != nil {
+			mp.cfg.FeeEstimator.RemoveTransaction(txHash)
+		}
+		mp.refreshPackageStats(ancestors, descendants)
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -543,6 +617,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 			Height:   height,
 			Fee:      fee,
 			FeePerKB: fee * 1000 / GetTxVirtualSize(tx),
+			FeeDelta: mp.feeDeltas[*tx.Hash()],
 		},
 		StartingPriority: mining.CalcPriority(tx.MsgTx(), utxoView, height),
 	}
@@ -551,6 +626,9 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.addPackageStats(txD)
+	mp.evictionQueue.add(txD)
+	mp.totalUsage += txUsage(tx)
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -559,11 +637,6 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 		mp.cfg.AddrIndex.AddUnconfirmedTx(tx, utxoView)
 	}
 
-	// Record this tx for fee estimation if enabled.
-	if mp.cfg.FeeEstimator != nil {
-		mp.cfg.FeeEstimator.ObserveTransaction(txD)
-	}
-
 	return txD
 }
 
@@ -779,10 +852,10 @@ func (mp *TxPool) CheckSpend(op wire.OutPoint) *btcutil.Tx {
 // fetchInputUtxos loads utxo details about the input transactions referenced by
 // the passed transaction.  First, it loads the details form the viewpoint of
 // the main chain, then it adjusts them based upon the contents of the
-// transaction pool.
+// transaction pool and of the optional pending transactions of a package.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) fetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+func (mp *TxPool) fetchInputUtxos(tx *btcutil.Tx, pending *pendingTxns) (*blockchain.UtxoViewpoint, error) {
 	utxoView, err := mp.cfg.FetchUtxoView(tx)
 	if err != nil {
 		return nil, err
@@ -801,6 +874,11 @@ func (mp *TxPool) fetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, er
 			// safe to call without bounds checking here.
 			utxoView.AddTxOut(poolTxDesc.Tx, prevOut.Index,
 				mining.UnminedHeight)
+			continue
+		}
+		if pendingTx := pending.lookup(&prevOut.Hash); pendingTx != nil {
+			utxoView.AddTxOut(pendingTx, prevOut.Index,
+				mining.UnminedHeight)
 		}
 	}
 
@@ -825,35 +903,52 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash) (*btcutil.Tx, error)
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
-// validateReplacement determines whether a transaction is deemed as a valid
-// replacement of all of its conflicts according to the RBF policy. If it is
-// valid, no error is returned. Otherwise, an error is returned indicating what
-// went wrong.
+// validateReplacement determines whether the passed transactions, paying the
+// passed total fee, are deemed as a valid replacement of all of their conflicts
+// according to the RBF policy.  A single transaction replaces its conflicts on
+// its own, while the transactions of a package accepted along with their child,
+// which is the last one, replace the conflicts of all of them as a whole. If it
+// is valid, no error is returned. Otherwise, an error is returned indicating
+// what went wrong.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) validateReplacement(tx *btcutil.Tx,
+func (mp *TxPool) validateReplacement(txns []*btcutil.Tx,
 	txFee int64) (map[chainhash.Hash]*btcutil.Tx, error) {
 
+	replacement := fmt.Sprintf("replacement transaction %v",
+		txns[len(txns)-1].Hash())
+	if len(txns) > 1 {
+		replacement = fmt.Sprintf("replacement package of transaction %v",
+			txns[len(txns)-1].Hash())
+	}
+
 	// First, we'll make sure the set of conflicting transactions doesn't
 	// exceed the maximum allowed.
-	conflicts := mp.txConflicts(tx)
+	conflicts := make(map[chainhash.Hash]*btcutil.Tx)
+	for _, tx := range txns {
+		for hash, conflict := range mp.txConflicts(tx) {
+			conflicts[hash] = conflict
+		}
+	}
 	if len(conflicts) > MaxReplacementEvictions {
-		str := fmt.Sprintf("replacement transaction %v evicts more "+
-			"transactions than permitted: max is %v, evicts %v",
-			tx.Hash(), MaxReplacementEvictions, len(conflicts))
+		str := fmt.Sprintf("%s evicts more transactions than "+
+			"permitted: max is %v, evicts %v", replacement,
+			MaxReplacementEvictions, len(conflicts))
 		return nil, txRuleError(wire.RejectNonstandard, str)
 	}
 
 	// The set of conflicts (transactions we'll replace) and ancestors
 	// should not overlap, otherwise the replacement would be spending an
 	// output that no longer exists.
-	for ancestorHash := range mp.txAncestors(tx, nil) {
-		if _, ok := conflicts[ancestorHash]; !ok {
-			continue
+	for _, tx := range txns {
+		for ancestorHash := range mp.txAncestors(tx, nil) {
+			if _, ok := conflicts[ancestorHash]; !ok {
+				continue
+			}
+			str := fmt.Sprintf("%s spends parent transaction %v",
+				replacement, ancestorHash)
+			return nil, txRuleError(wire.RejectInvalid, str)
 		}
-		str := fmt.Sprintf("replacement transaction %v spends parent "+
-			"transaction %v", tx.Hash(), ancestorHash)
-		return nil, txRuleError(wire.RejectInvalid, str)
 	}
 
 	// The replacement should have a higher fee rate than each of the
@@ -865,17 +960,19 @@ func (mp *TxPool) validateReplacement(tx *btcutil.Tx,
 	// block. Requiring that the fee rate always be increased is also an
 	// easy-to-reason about way to prevent DoS attacks via replacements.
 	var (
-		txSize           = GetTxVirtualSize(tx)
-		txFeeRate        = txFee * 1000 / txSize
+		txSize           int64
 		conflictsFee     int64
 		conflictsParents = make(map[chainhash.Hash]struct{})
 	)
+	for _, tx := range txns {
+		txSize += GetTxVirtualSize(tx)
+	}
+	txFeeRate := txFee * 1000 / txSize
 	for hash, conflict := range conflicts {
 		if txFeeRate <= mp.pool[hash].FeePerKB {
-			str := fmt.Sprintf("replacement transaction %v has an "+
-				"insufficient fee rate: needs more than %v, "+
-				"has %v", tx.Hash(), mp.pool[hash].FeePerKB,
-				txFeeRate)
+			str := fmt.Sprintf("%s has an insufficient fee rate: "+
+				"needs more than %v, has %v", replacement,
+				mp.pool[hash].FeePerKB, txFeeRate)
 			return nil, txRuleError(wire.RejectInsufficientFee, str)
 		}
 
@@ -893,38 +990,68 @@ func (mp *TxPool) validateReplacement(tx *btcutil.Tx,
 	// which is determined by our minimum relay fee.
 	minFee := calcMinRequiredTxRelayFee(txSize, mp.cfg.Policy.MinRelayTxFee)
 	if txFee < conflictsFee+minFee {
-		str := fmt.Sprintf("replacement transaction %v has an "+
-			"insufficient absolute fee: needs %v, has %v",
-			tx.Hash(), conflictsFee+minFee, txFee)
+		str := fmt.Sprintf("%s has an insufficient absolute fee: "+
+			"needs %v, has %v", replacement, conflictsFee+minFee,
+			txFee)
 		return nil, txRuleError(wire.RejectInsufficientFee, str)
 	}
 
 	// Finally, it should not spend any new unconfirmed outputs, other than
 	// the ones already included in the parents of the conflicting
-	// transactions it'll replace.
-	for _, txIn := range tx.MsgTx().TxIn {
-		if _, ok := conflictsParents[txIn.PreviousOutPoint.Hash]; ok {
-			continue
-		}
-		// Confirmed outputs are valid to spend in the replacement.
-		if _, ok := mp.pool[txIn.PreviousOutPoint.Hash]; !ok {
-			continue
+	// transactions it'll replace.  The outputs of the other transactions of
+	// a package aren't in the pool yet.
+	for _, tx := range txns {
+		for _, txIn := range tx.MsgTx().TxIn {
+			if _, ok := conflictsParents[txIn.PreviousOutPoint.Hash]; ok {
+				continue
+			}
+			// Confirmed outputs are valid to spend in the
+			// replacement.
+			if _, ok := mp.pool[txIn.PreviousOutPoint.Hash]; !ok {
+				continue
+			}
+			str := fmt.Sprintf("replacement transaction spends new "+
+				"unconfirmed input %v not found in conflicting "+
+				"transactions", txIn.PreviousOutPoint)
+			return nil, txRuleError(wire.RejectInvalid, str)
 		}
-		str := fmt.Sprintf("replacement transaction spends new "+
-			"unconfirmed input %v not found in conflicting "+
-			"transactions", txIn.PreviousOutPoint)
-		return nil, txRuleError(wire.RejectInvalid, str)
 	}
 
 	return conflicts, nil
 }
 
-// maybeAcceptTransaction is the internal function which implements the public
-// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// validatedTx holds a transaction which passed the checks of
+// validateTransaction along with what is needed to add it to the pool.
+type validatedTx struct {
+	tx        *btcutil.Tx
+	utxoView  *blockchain.UtxoViewpoint
+	height    int32
+	fee       int64
+	size      int64
+	conflicts map[chainhash.Hash]*btcutil.Tx
+
+	// isReplacement is set when the transaction spends outputs already
+	// spent by transactions in the pool which signal replacement.
+	isReplacement bool
+
+	// packageFees is set when the transaction is accepted along with the
+	// other transactions of a package paying its fees.
+	packageFees bool
+}
+
+// validateTransaction performs all of the checks maybeAcceptTransaction does
+// before adding the passed transaction to the pool, without modifying the pool.
+// The optional pending transactions of a package are considered to be in the
+// pool, and when they defer their fees, the fee checks, including the ones of
+// replacements, are left to the caller, which checks the fees of the package as
+// a whole.
+//
+// The missing parents are returned instead when the transaction is an orphan.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+func (mp *TxPool) validateTransaction(tx *btcutil.Tx, isNew, rateLimit,
+	rejectDupOrphans bool, pending *pendingTxns) ([]*chainhash.Hash, *validatedTx, error) {
+
 	txHash := tx.Hash()
 
 	// If a transaction has witness data, and segwit isn't active yet, If
@@ -1024,7 +1151,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	// to this transaction.  This function also attempts to fetch the
 	// transaction itself to be used for detecting a duplicate transaction
 	// without needing to do a separate lookup.
-	utxoView, err := mp.fetchInputUtxos(tx)
+	utxoView, err := mp.fetchInputUtxos(tx, pending)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
 			return nil, nil, chainRuleError(cerr)
@@ -1134,6 +1261,67 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		return nil, nil, txRuleError(wire.RejectNonstandard, str)
 	}
 
+	// Don't allow transactions with fees too low unless the fees are
+	// checked for a whole package.
+	deferFees := pending != nil && pending.deferFees
+	if !deferFees {
+		err := mp.checkTxFees(tx, utxoView, nextBlockHeight, txFee,
+			isNew, rateLimit)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Don't allow the transaction to extend a chain of unconfirmed
+	// transactions past the package limits of the pool.  The pending
+	// transactions it depends on are added to the pool along with it.
+	limitTxns := append(pending.ancestors(tx), tx)
+	if err := mp.checkPackageLimits(limitTxns...); err != nil {
+		return nil, nil, err
+	}
+
+	// If the transaction has any conflicts and we've made it this far, then
+	// we're processing a potential replacement.
+	var conflicts map[chainhash.Hash]*btcutil.Tx
+	if isReplacement && !deferFees {
+		conflicts, err = mp.validateReplacement([]*btcutil.Tx{tx}, txFee)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Verify crypto signatures for each input and reject the transaction if
+	// any don't verify.
+	err = blockchain.ValidateTransactionScripts(tx, utxoView,
+		txscript.StandardVerifyFlags, mp.cfg.SigCache,
+		mp.cfg.HashCache, mp.cfg.ScriptExecCache)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return nil, nil, chainRuleError(cerr)
+		}
+		return nil, nil, err
+	}
+
+	return nil, &validatedTx{
+		tx:            tx,
+		utxoView:      utxoView,
+		height:        bestHeight,
+		fee:           txFee,
+		size:          GetTxVirtualSize(tx),
+		conflicts:     conflicts,
+		isReplacement: isReplacement,
+	}, nil
+}
+
+// checkTxFees returns an error if the passed transaction, which pays the passed
+// fee, doesn't pay enough to be accepted to the pool on its own.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) checkTxFees(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint,
+	nextBlockHeight int32, txFee int64, isNew, rateLimit bool) error {
+
+	txHash := tx.Hash()
+
 	// Don't allow transactions with fees too low to get into a mined block.
 	//
 	// Most miners allow a free transaction area in blocks they mine to go
@@ -1145,34 +1333,53 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	// which is more desirable.  Therefore, as long as the size of the
 	// transaction does not exceeed 1000 less than the reserved space for
 	// high-priority transactions, don't require a fee for it.
+	//
+	// The fee delta set to prioritise the transaction, if any, counts
+	// towards the fees it pays for the purpose of these checks.
 	serializedSize := GetTxVirtualSize(tx)
+	modifiedFee := txFee + mp.feeDeltas[*txHash]
 	minFee := calcMinRequiredTxRelayFee(serializedSize,
 		mp.cfg.Policy.MinRelayTxFee)
-	if serializedSize >= (DefaultBlockPrioritySize-1000) && txFee < minFee {
+	if serializedSize >= (DefaultBlockPrioritySize-1000) && modifiedFee < minFee {
 		str := fmt.Sprintf("transaction %v has %d fees which is under "+
-			"the required amount of %d", txHash, txFee,
+			"the required amount of %d", txHash, modifiedFee,
 			minFee)
-		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+		return txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	// Don't allow transactions with fees too low to enter the pool while it
+	// is full.  Transactions which are being added back to the memory pool
+	// from blocks that have been disconnected during a reorg are exempted,
+	// as the pool is limited again once they are added.
+	if isNew {
+		poolMinFee := calcMinRequiredTxRelayFee(serializedSize,
+			mp.minFee())
+		if poolMinFee > minFee && modifiedFee < poolMinFee {
+			str := fmt.Sprintf("transaction %v has %d fees which is "+
+				"under the required amount of %d to enter the "+
+				"full memory pool", txHash, modifiedFee, poolMinFee)
+			return txRuleError(wire.RejectInsufficientFee, str)
+		}
 	}
 
 	// Require that free transactions have sufficient priority to be mined
 	// in the next block.  Transactions which are being added back to the
 	// memory pool from blocks that have been disconnected during a reorg
 	// are exempted.
-	if isNew && !mp.cfg.Policy.DisableRelayPriority && txFee < minFee {
+	if isNew && !mp.cfg.Policy.DisableRelayPriority && modifiedFee < minFee {
 		currentPriority := mining.CalcPriority(tx.MsgTx(), utxoView,
 			nextBlockHeight)
 		if currentPriority <= mining.MinHighPriority {
 			str := fmt.Sprintf("transaction %v has insufficient "+
 				"priority (%g <= %g)", txHash,
 				currentPriority, mining.MinHighPriority)
-			return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+			return txRuleError(wire.RejectInsufficientFee, str)
 		}
 	}
 
 	// Free-to-relay transactions are rate limited here to prevent
 	// penny-flooding with tiny transactions as a form of attack.
-	if rateLimit && txFee < minFee {
+	if rateLimit && modifiedFee < minFee {
 		nowUnix := time.Now().Unix()
 		// Decay passed data with an exponentially decaying ~10 minute
 		// window - matches bitcoind handling.
@@ -1184,7 +1391,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		if mp.pennyTotal >= mp.cfg.Policy.FreeTxRelayLimit*10*1000 {
 			str := fmt.Sprintf("transaction %v has been rejected "+
 				"by the rate limiter due to low fees", txHash)
-			return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+			return txRuleError(wire.RejectInsufficientFee, str)
 		}
 		oldTotal := mp.pennyTotal
 
@@ -1194,43 +1401,68 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 			mp.cfg.Policy.FreeTxRelayLimit*10*1000)
 	}
 
-	// If the transaction has any conflicts and we've made it this far, then
-	// we're processing a potential replacement.
-	var conflicts map[chainhash.Hash]*btcutil.Tx
-	if isReplacement {
-		conflicts, err = mp.validateReplacement(tx, txFee)
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	// Verify crypto signatures for each input and reject the transaction if
-	// any don't verify.
-	err = blockchain.ValidateTransactionScripts(tx, utxoView,
-		txscript.StandardVerifyFlags, mp.cfg.SigCache,
-		mp.cfg.HashCache)
-	if err != nil {
-		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
-		return nil, nil, err
-	}
+	return nil
+}
 
-	// Now that we've deemed the transaction as valid, we can add it to the
-	// mempool. If it ended up replacing any transactions, we'll remove them
-	// first.
-	for _, conflict := range conflicts {
+// acceptTransaction adds the passed transaction, which passed the checks of
+// validateTransaction, to the pool after removing the transactions it
+// replaces, if any.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) acceptTransaction(v *validatedTx) *TxDesc {
+	// If the transaction ended up replacing any transactions, we'll
+	// remove them first.
+	for _, conflict := range v.conflicts {
 		log.Debugf("Replacing transaction %v (fee_rate=%v sat/kb) "+
 			"with %v (fee_rate=%v sat/kb)\n", conflict.Hash(),
-			mp.pool[*conflict.Hash()].FeePerKB, tx.Hash(),
-			txFee*1000/serializedSize)
+			mp.pool[*conflict.Hash()].FeePerKB, v.tx.Hash(),
+			v.fee*1000/v.size)
 
 		// The conflict set should already include the descendants for
 		// each one, so we don't need to remove the redeemers within
 		// this call as they'll be removed eventually.
 		mp.removeTransaction(conflict, false)
 	}
-	txD := mp.addTransaction(utxoView, tx, bestHeight, txFee)
+	txD := mp.addTransaction(v.utxoView, v.tx, v.height, v.fee)
+
+	// Record this tx for fee estimation if enabled.  The fee rate of the
+	// transactions with unconfirmed parents, or whose fees are paid by a
+	// package, doesn't tell how fast it gets them confirmed on its own.
+	if mp.cfg.FeeEstimator != nil && txD.AncestorCount == 1 &&
+		!v.packageFees {
+
+		mp.cfg.FeeEstimator.ObserveTransaction(txD)
+	}
+
+	return txD
+}
+
+// maybeAcceptTransaction is the internal function which implements the public
+// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
+// more details.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+	txHash := tx.Hash()
+	missingParents, v, err := mp.validateTransaction(tx, isNew, rateLimit,
+		rejectDupOrphans, nil)
+	if err != nil || len(missingParents) > 0 {
+		return missingParents, nil, err
+	}
+
+	// Now that we've deemed the transaction as valid, we can add it to the
+	// mempool.
+	txD := mp.acceptTransaction(v)
+
+	// Evict the transactions over the size limit of the pool, which may
+	// include the transaction itself if it pays a lower fee rate than the
+	// ones already in the pool.
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v was not accepted to the full "+
+			"memory pool", txHash)
+		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
 
 	log.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
@@ -1454,7 +1686,8 @@ func (mp *TxPool) TxHashes() []*chainhash.Hash {
 }
 
 // TxDescs returns a slice of descriptors for all the transactions in the pool.
-// The descriptors are to be treated as read only.
+// The descriptors are copies of the ones of the pool, whose package stats
+// change as transactions are added and removed.
 //
 // This function is safe for concurrent access.
 func (mp *TxPool) TxDescs() []*TxDesc {
@@ -1462,7 +1695,8 @@ func (mp *TxPool) TxDescs() []*TxDesc {
 	descs := make([]*TxDesc, len(mp.pool))
 	i := 0
 	for _, desc := range mp.pool {
-		descs[i] = desc
+		descCopy := *desc
+		descs[i] = &descCopy
 		i++
 	}
 	mp.mtx.RUnlock()
@@ -1480,7 +1714,8 @@ func (mp *TxPool) MiningDescs() []*mining.TxDesc {
 	descs := make([]*mining.TxDesc, len(mp.pool))
 	i := 0
 	for _, desc := range mp.pool {
-		descs[i] = &desc.TxDesc
+		descCopy := desc.TxDesc
+		descs[i] = &descCopy
 		i++
 	}
 	mp.mtx.RUnlock()
@@ -1506,7 +1741,7 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*btcjson.GetRawMempoolVerboseRe
 		// input transactions can't be found for some reason.
 		tx := desc.Tx
 		var currentPriority float64
-		utxos, err := mp.fetchInputUtxos(tx)
+		utxos, err := mp.fetchInputUtxos(tx, nil)
 		if err == nil {
 			currentPriority = mining.CalcPriority(tx.MsgTx(), utxos,
 				bestHeight+1)
@@ -1522,6 +1757,12 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*btcjson.GetRawMempoolVerboseRe
 			StartingPriority: desc.StartingPriority,
 			CurrentPriority:  currentPriority,
 			Depends:          make([]string, 0),
+			AncestorCount:    desc.AncestorCount,
+			AncestorSize:     desc.AncestorSize,
+			AncestorFees:     float64(desc.AncestorFee),
+			DescendantCount:  desc.DescendantCount,
+			DescendantSize:   desc.DescendantSize,
+			DescendantFees:   float64(desc.DescendantFee),
 		}
 		for _, txIn := range tx.MsgTx().TxIn {
 			hash := &txIn.PreviousOutPoint.Hash
@@ -1537,6 +1778,104 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*btcjson.GetRawMempoolVerboseRe
 	return result
 }
 
+// mempoolEntry returns the btcjson result describing the passed transaction of
+// the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) mempoolEntry(desc *TxDesc) *btcjson.GetMempoolEntryResult {
+	tx := desc.Tx
+	fee := btcutil.Amount(desc.Fee).ToBTC()
+	modifiedFee := btcutil.Amount(desc.Fee + desc.FeeDelta).ToBTC()
+	entry := &btcjson.GetMempoolEntryResult{
+		VSize:           int32(GetTxVirtualSize(tx)),
+		Size:            int32(tx.MsgTx().SerializeSize()),
+		Weight:          blockchain.GetTransactionWeight(tx),
+		Fee:             fee,
+		ModifiedFee:     modifiedFee,
+		Time:            desc.Added.Unix(),
+		Height:          int64(desc.Height),
+		DescendantCount: desc.DescendantCount,
+		DescendantSize:  desc.DescendantSize,
+		DescendantFees:  float64(desc.DescendantFee),
+		AncestorCount:   desc.AncestorCount,
+		AncestorSize:    desc.AncestorSize,
+		AncestorFees:    float64(desc.AncestorFee),
+		WTxId:           tx.WitnessHash().String(),
+		Fees: btcjson.MempoolFees{
+			Base:       fee,
+			Modified:   modifiedFee,
+			Ancestor:   btcutil.Amount(desc.AncestorFee).ToBTC(),
+			Descendant: btcutil.Amount(desc.DescendantFee).ToBTC(),
+		},
+		Depends: make([]string, 0),
+	}
+	for _, txIn := range tx.MsgTx().TxIn {
+		hash := &txIn.PreviousOutPoint.Hash
+		if mp.haveTransaction(hash) {
+			entry.Depends = append(entry.Depends, hash.String())
+		}
+	}
+
+	return entry
+}
+
+// MempoolEntry returns the btcjson result describing the transaction with the
+// passed hash, or an error if it is not in the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolEntry(txHash *chainhash.Hash) (*btcjson.GetMempoolEntryResult, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	desc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	return mp.mempoolEntry(desc), nil
+}
+
+// MempoolAncestors returns the btcjson results describing all of the
+// unconfirmed ancestors of the transaction with the passed hash keyed by their
+// hashes, or an error if it is not in the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolAncestors(txHash *chainhash.Hash) (map[string]*btcjson.GetMempoolEntryResult, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	desc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	ancestors := mp.txAncestors(desc.Tx, nil)
+	result := make(map[string]*btcjson.GetMempoolEntryResult, len(ancestors))
+	for hash := range ancestors {
+		result[hash.String()] = mp.mempoolEntry(mp.pool[hash])
+	}
+	return result, nil
+}
+
+// MempoolDescendants returns the btcjson results describing all of the
+// descendants in the pool of the transaction with the passed hash keyed by
+// their hashes, or an error if it is not in the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolDescendants(txHash *chainhash.Hash) (map[string]*btcjson.GetMempoolEntryResult, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	desc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	descendants := mp.txDescendants(desc.Tx, nil)
+	result := make(map[string]*btcjson.GetMempoolEntryResult, len(descendants))
+	for hash := range descendants {
+		result[hash.String()] = mp.mempoolEntry(mp.pool[hash])
+	}
+	return result, nil
+}
+
 // LastUpdated returns the last time a transaction was added to or removed from
 // the main pool.  It does not include the orphan pool.
 //
@@ -1549,11 +1888,14 @@ func (mp *TxPool) LastUpdated() time.Time {
 // transactions until they are mined into a block.
 func New(cfg *Config) *TxPool {
 	return &TxPool{
-		cfg:            *cfg,
-		pool:           make(map[chainhash.Hash]*TxDesc),
-		orphans:        make(map[chainhash.Hash]*orphanTx),
-		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx),
-		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
-		outpoints:      make(map[wire.OutPoint]*btcutil.Tx),
+		cfg:                *cfg,
+		pool:               make(map[chainhash.Hash]*TxDesc),
+		orphans:            make(map[chainhash.Hash]*orphanTx),
+		orphansByPrev:      make(map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx),
+		nextExpireScan:     time.Now().Add(orphanExpireScanInterval),
+		nextPoolExpireScan: time.Now().Add(poolExpireScanInterval),
+		outpoints:          make(map[wire.OutPoint]*btcutil.Tx),
+		feeDeltas:          make(map[chainhash.Hash]int64),
+		evictionQueue:      newEvictionQueue(),
 	}
 }
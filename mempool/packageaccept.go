@@ -0,0 +1,534 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// MaxPackageCount is the maximum number of transactions in a package
+	// passed to ProcessPackage.
+	MaxPackageCount = 25
+
+	// MaxPackageWeight is the maximum total weight of the transactions in
+	// a package passed to ProcessPackage.
+	MaxPackageWeight = 404000
+)
+
+// PackageTxResult describes the outcome of the validation of one of the
+// transactions of a package passed to ProcessPackage.
+type PackageTxResult struct {
+	// Tx is the transaction.
+	Tx *btcutil.Tx
+
+	// TxDesc is the descriptor of the transaction once it has been added
+	// to the pool.  It is nil when the transaction was already in the pool
+	// or was only tested.
+	TxDesc *TxDesc
+
+	// AlreadyInPool is set when the transaction was already in the pool.
+	AlreadyInPool bool
+
+	// Fee is the fee paid by the transaction and Size its virtual size.
+	Fee  int64
+	Size int64
+
+	// EffectiveFeePerKB is the fee rate, in Satoshi per 1000 virtual bytes
+	// and including the fee deltas, the transaction was accepted at.  It is
+	// the fee rate of the transactions in EffectiveIncludes as a whole,
+	// which only holds the transaction itself unless it didn't pay enough
+	// on its own.
+	EffectiveFeePerKB int64
+	EffectiveIncludes []*chainhash.Hash
+
+	// Err is the reason the transaction wasn't accepted, if any.
+	Err error
+}
+
+// PackageResult describes the outcome of the validation of a package passed to
+// ProcessPackage.
+type PackageResult struct {
+	// TxResults holds the outcome for each transaction of the package, in
+	// the order they were passed.
+	TxResults []*PackageTxResult
+
+	// Replaced holds the transactions removed from the pool because they
+	// conflicted with the transactions of the package.
+	Replaced []*btcutil.Tx
+
+	// Accepted holds the descriptors of the transactions added to the pool,
+	// followed by the ones of the orphans accepted as a result.
+	Accepted []*TxDesc
+}
+
+// pendingTxns holds the transactions of a package which have been validated,
+// but not added to the pool yet, so that the transactions spending their
+// outputs can be validated.
+type pendingTxns struct {
+	txns map[chainhash.Hash]*btcutil.Tx
+
+	// deferFees is set when the fees of the transactions validated along
+	// with the pending ones are checked for the package as a whole.
+	deferFees bool
+}
+
+// newPendingTxns returns a new empty set of pending transactions.
+func newPendingTxns(deferFees bool) *pendingTxns {
+	return &pendingTxns{
+		txns:      make(map[chainhash.Hash]*btcutil.Tx),
+		deferFees: deferFees,
+	}
+}
+
+// withDeferredFees returns a copy of the pending transactions which defers the
+// fee checks to the package as a whole.
+func (p *pendingTxns) withDeferredFees() *pendingTxns {
+	deferred := newPendingTxns(true)
+	for hash, tx := range p.txns {
+		deferred.txns[hash] = tx
+	}
+	return deferred
+}
+
+// add adds the passed validated transaction to the pending transactions.
+func (p *pendingTxns) add(tx *btcutil.Tx) {
+	p.txns[*tx.Hash()] = tx
+}
+
+// lookup returns the pending transaction with the passed hash, or nil when
+// there is none.  It may be called on a nil set.
+func (p *pendingTxns) lookup(hash *chainhash.Hash) *btcutil.Tx {
+	if p == nil {
+		return nil
+	}
+	return p.txns[*hash]
+}
+
+// ancestors returns the pending transactions the passed transaction spends the
+// outputs of, directly or through other pending transactions.  It may be called
+// on a nil set.
+func (p *pendingTxns) ancestors(tx *btcutil.Tx) []*btcutil.Tx {
+	if p == nil {
+		return nil
+	}
+
+	var ancestors []*btcutil.Tx
+	seen := make(map[chainhash.Hash]struct{})
+	stack := []*btcutil.Tx{tx}
+	for len(stack) > 0 {
+		tx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, txIn := range tx.MsgTx().TxIn {
+			hash := txIn.PreviousOutPoint.Hash
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			ancestor, ok := p.txns[hash]
+			if !ok {
+				continue
+			}
+			seen[hash] = struct{}{}
+			ancestors = append(ancestors, ancestor)
+			stack = append(stack, ancestor)
+		}
+	}
+	return ancestors
+}
+
+// checkPackage returns an error if the passed transactions don't form a package
+// ProcessPackage accepts, which is made of a child transaction coming last and
+// of some of its parents sorted so that each transaction comes after the ones
+// it spends the outputs of, without duplicate nor conflicting transactions.
+func checkPackage(txns []*btcutil.Tx) error {
+	if len(txns) == 0 {
+		return txRuleError(wire.RejectInvalid, "package is empty")
+	}
+	if len(txns) > MaxPackageCount {
+		str := fmt.Sprintf("package has too many transactions: %d > %d",
+			len(txns), MaxPackageCount)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	var weight int64
+	positions := make(map[chainhash.Hash]int, len(txns))
+	for i, tx := range txns {
+		if _, exists := positions[*tx.Hash()]; exists {
+			str := fmt.Sprintf("package contains transaction %v "+
+				"more than once", tx.Hash())
+			return txRuleError(wire.RejectInvalid, str)
+		}
+		positions[*tx.Hash()] = i
+		weight += blockchain.GetTransactionWeight(tx)
+	}
+	if weight > MaxPackageWeight {
+		str := fmt.Sprintf("package is too large: weight %d > %d",
+			weight, MaxPackageWeight)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	spent := make(map[wire.OutPoint]*btcutil.Tx)
+	for i, tx := range txns {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			if spender, exists := spent[prevOut]; exists {
+				str := fmt.Sprintf("package transactions %v and "+
+					"%v both spend output %v", spender.Hash(),
+					tx.Hash(), prevOut)
+				return txRuleError(wire.RejectInvalid, str)
+			}
+			spent[prevOut] = tx
+
+			if pos, exists := positions[prevOut.Hash]; exists && pos > i {
+				str := fmt.Sprintf("package transaction %v comes "+
+					"before its parent %v", tx.Hash(),
+					prevOut.Hash)
+				return txRuleError(wire.RejectNonstandard, str)
+			}
+		}
+	}
+
+	child := txns[len(txns)-1]
+	parents := make(map[chainhash.Hash]struct{})
+	for _, txIn := range child.MsgTx().TxIn {
+		parents[txIn.PreviousOutPoint.Hash] = struct{}{}
+	}
+	for _, tx := range txns[:len(txns)-1] {
+		if _, ok := parents[*tx.Hash()]; !ok {
+			str := fmt.Sprintf("package transaction %v is not a "+
+				"parent of the child %v", tx.Hash(), child.Hash())
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	return nil
+}
+
+// missingInputsError returns the error of a transaction of a package which
+// spends the outputs of the passed unknown parent.
+//
+// NOTE: RejectDuplicate is used to match the reject code of orphans.
+func missingInputsError(tx *btcutil.Tx, parent *chainhash.Hash) error {
+	str := fmt.Sprintf("transaction %v references outputs of unknown or "+
+		"fully-spent transaction %v", tx.Hash(), parent)
+	return txRuleError(wire.RejectDuplicate, str)
+}
+
+// isInsufficientFeeError returns whether the passed error rejects a transaction
+// because it doesn't pay enough fees.
+func isInsufficientFeeError(err error) bool {
+	code, found := extractRejectCode(err)
+	return found && code == wire.RejectInsufficientFee
+}
+
+// checkMaxFeeRate returns an error if the fee rate of the passed transaction
+// or package, described by the passed transaction, which pays the passed fee
+// for the passed virtual size, exceeds the passed maximum.  There is no maximum
+// when it is zero.
+func checkMaxFeeRate(tx *btcutil.Tx, fee, size int64, maxFeeRate btcutil.Amount) error {
+	if maxFeeRate <= 0 {
+		return nil
+	}
+	if feeRate := fee * 1000 / size; feeRate > int64(maxFeeRate) {
+		str := fmt.Sprintf("transaction %v has a fee rate of %d "+
+			"sat/kB which exceeds the maximum of %d", tx.Hash(),
+			feeRate, int64(maxFeeRate))
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+	return nil
+}
+
+// checkPackageFees returns an error if the passed transactions of a package,
+// which pay the passed fee, including the fee deltas, for the passed virtual
+// size, don't pay enough to be accepted to the pool as a whole.  Unlike single
+// transactions, packages are never free.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) checkPackageFees(txns []*btcutil.Tx, modifiedFee, size int64) error {
+	minFee := calcMinRequiredTxRelayFee(size, mp.cfg.Policy.MinRelayTxFee)
+	poolMinFee := calcMinRequiredTxRelayFee(size, mp.minFee())
+	if poolMinFee > minFee {
+		minFee = poolMinFee
+	}
+	if modifiedFee < minFee {
+		str := fmt.Sprintf("package of transaction %v has %d fees "+
+			"which is under the required amount of %d",
+			txns[len(txns)-1].Hash(), modifiedFee, minFee)
+		return txRuleError(wire.RejectInsufficientFee, str)
+	}
+	return nil
+}
+
+// acceptDeferred validates the passed transactions of a package, which don't
+// pay enough to be accepted to the pool on their own, as a whole, and adds them
+// to the pool unless only testing.  The pending transactions are considered to
+// be in the pool.  It returns the transactions they replace.
+//
+// The error of each transaction is set on failure.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) acceptDeferred(deferred []*PackageTxResult,
+	pending *pendingTxns, maxFeeRate btcutil.Amount,
+	testAccept bool) ([]*btcutil.Tx, error) {
+
+	pending = pending.withDeferredFees()
+	validated := make([]*validatedTx, 0, len(deferred))
+	txns := make([]*btcutil.Tx, 0, len(deferred))
+	var fee, modifiedFee, size int64
+	var isReplacement bool
+	for _, res := range deferred {
+		tx := res.Tx
+		missingParents, v, err := mp.validateTransaction(tx, true, false,
+			false, pending)
+		if err == nil && len(missingParents) > 0 {
+			err = missingInputsError(tx, missingParents[0])
+		}
+		if err != nil {
+			res.Err = err
+			return nil, err
+		}
+		pending.add(tx)
+
+		validated = append(validated, v)
+		txns = append(txns, tx)
+		fee += v.fee
+		modifiedFee += v.fee + mp.feeDeltas[*tx.Hash()]
+		size += v.size
+		isReplacement = isReplacement || v.isReplacement
+		res.Fee, res.Size = v.fee, v.size
+	}
+
+	err := mp.checkPackageFees(txns, modifiedFee, size)
+	if err == nil {
+		err = checkMaxFeeRate(txns[len(txns)-1], fee, size, maxFeeRate)
+	}
+
+	// Only a parent along with its child may replace transactions in the
+	// pool, as the fee rate of larger packages doesn't tell which of their
+	// transactions would be mined first, which the replacement rules rely
+	// on.
+	var conflicts map[chainhash.Hash]*btcutil.Tx
+	if err == nil && isReplacement {
+		if len(txns) > 2 {
+			str := fmt.Sprintf("package of transaction %v replaces "+
+				"transactions in the pool with more than a "+
+				"parent and its child", txns[len(txns)-1].Hash())
+			err = txRuleError(wire.RejectNonstandard, str)
+		} else {
+			conflicts, err = mp.validateReplacement(txns, fee)
+		}
+	}
+	if err != nil {
+		for _, res := range deferred {
+			res.Err = err
+		}
+		return nil, err
+	}
+
+	includes := make([]*chainhash.Hash, 0, len(txns))
+	for _, tx := range txns {
+		includes = append(includes, tx.Hash())
+	}
+	for _, res := range deferred {
+		res.EffectiveFeePerKB = modifiedFee * 1000 / size
+		res.EffectiveIncludes = includes
+	}
+	if testAccept {
+		return nil, nil
+	}
+
+	replaced := make([]*btcutil.Tx, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		log.Debugf("Replacing transaction %v (fee_rate=%v sat/kb) "+
+			"with package of %v (fee_rate=%v sat/kb)",
+			conflict.Hash(), mp.pool[*conflict.Hash()].FeePerKB,
+			txns[len(txns)-1].Hash(), fee*1000/size)
+
+		// The conflicts include their descendants already.
+		mp.removeTransaction(conflict, false)
+		replaced = append(replaced, conflict)
+	}
+	for i, v := range validated {
+		v.packageFees = true
+		deferred[i].TxDesc = mp.acceptTransaction(v)
+	}
+	return replaced, nil
+}
+
+// ProcessPackage validates the passed package, which is made of a child
+// transaction coming last and of its unconfirmed parents, so that the child can
+// pay for parents which don't pay enough fees to be accepted to the pool on
+// their own.  Unless only testing, the transactions which are valid are added
+// to the pool, along with the orphans which are no longer orphans as a result.
+//
+// Each transaction is first validated on its own, so that those paying enough
+// are accepted regardless of the others.  The ones which don't, along with the
+// ones spending their outputs, are then validated as a whole: they have to pay
+// enough fees together, and may replace transactions in the pool when they are
+// a single parent and its child, which must then pay more than the replaced
+// transactions as a whole.  The transactions which are validated after one
+// fails aren't accepted.
+//
+// The transactions paying a fee rate over the passed maximum, if not zero, are
+// rejected.
+//
+// An error is returned when the transactions don't form such a package.
+// Otherwise, the outcome for each transaction is returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ProcessPackage(txns []*btcutil.Tx, maxFeeRate btcutil.Amount,
+	testAccept bool) (*PackageResult, error) {
+
+	if err := checkPackage(txns); err != nil {
+		return nil, err
+	}
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	result := &PackageResult{
+		TxResults: make([]*PackageTxResult, 0, len(txns)),
+	}
+	pending := newPendingTxns(false)
+	deferredHashes := make(map[chainhash.Hash]struct{})
+	var deferred []*PackageTxResult
+	var failed *PackageTxResult
+	for _, tx := range txns {
+		res := &PackageTxResult{Tx: tx}
+		result.TxResults = append(result.TxResults, res)
+		if txD, exists := mp.pool[*tx.Hash()]; exists {
+			res.AlreadyInPool = true
+			res.Fee, res.Size = txD.Fee, GetTxVirtualSize(tx)
+			res.EffectiveFeePerKB = (txD.Fee + txD.FeeDelta) * 1000 /
+				res.Size
+			res.EffectiveIncludes = []*chainhash.Hash{tx.Hash()}
+			continue
+		}
+		if failed != nil {
+			continue
+		}
+
+		// A transaction spending the outputs of a transaction which
+		// doesn't pay enough on its own is deferred along with it.
+		var spendsDeferred bool
+		for _, txIn := range tx.MsgTx().TxIn {
+			hash := txIn.PreviousOutPoint.Hash
+			if _, ok := deferredHashes[hash]; ok {
+				spendsDeferred = true
+				break
+			}
+		}
+		if spendsDeferred {
+			deferred = append(deferred, res)
+			deferredHashes[*tx.Hash()] = struct{}{}
+			continue
+		}
+
+		missingParents, v, err := mp.validateTransaction(tx, true, false,
+			false, pending)
+		if err == nil && len(missingParents) > 0 {
+			err = missingInputsError(tx, missingParents[0])
+		}
+		if err == nil {
+			err = checkMaxFeeRate(tx, v.fee, v.size, maxFeeRate)
+		}
+		if isInsufficientFeeError(err) {
+			deferred = append(deferred, res)
+			deferredHashes[*tx.Hash()] = struct{}{}
+			continue
+		}
+		if err != nil {
+			res.Err = err
+			failed = res
+			continue
+		}
+
+		res.Fee, res.Size = v.fee, v.size
+		res.EffectiveFeePerKB = (v.fee + mp.feeDeltas[*tx.Hash()]) *
+			1000 / v.size
+		res.EffectiveIncludes = []*chainhash.Hash{tx.Hash()}
+		if testAccept {
+			pending.add(tx)
+			continue
+		}
+		for _, conflict := range v.conflicts {
+			result.Replaced = append(result.Replaced, conflict)
+		}
+		res.TxDesc = mp.acceptTransaction(v)
+	}
+
+	if failed == nil && len(deferred) > 0 {
+		replaced, err := mp.acceptDeferred(deferred, pending, maxFeeRate,
+			testAccept)
+		if err != nil {
+			failed = deferred[0]
+			for _, res := range deferred {
+				if res.Err != nil {
+					failed = res
+					break
+				}
+			}
+		}
+		result.Replaced = append(result.Replaced, replaced...)
+	}
+
+	// The transactions which haven't been validated, which are the only ones
+	// without an error nor an effective fee rate, aren't accepted because
+	// of the one which failed.
+	if failed != nil {
+		for _, res := range result.TxResults {
+			if res.Err != nil || res.EffectiveIncludes != nil {
+				continue
+			}
+			str := fmt.Sprintf("transaction %v was not validated "+
+				"because package transaction %v failed",
+				res.Tx.Hash(), failed.Tx.Hash())
+			res.Err = txRuleError(wire.RejectInvalid, str)
+		}
+	}
+	if testAccept {
+		return result, nil
+	}
+
+	// Evict the transactions over the size limit of the pool, which may
+	// include the transactions of the package.
+	mp.limitPoolSize()
+	for _, res := range result.TxResults {
+		if res.TxDesc == nil {
+			continue
+		}
+		if !mp.isTransactionInPool(res.Tx.Hash()) {
+			str := fmt.Sprintf("transaction %v was not accepted to "+
+				"the full memory pool", res.Tx.Hash())
+			res.TxDesc = nil
+			res.Err = txRuleError(wire.RejectInsufficientFee, str)
+			continue
+		}
+
+		// The transaction may have been an orphan before its parents
+		// were accepted along with it.
+		mp.removeOrphan(res.Tx, false)
+		result.Accepted = append(result.Accepted, res.TxDesc)
+	}
+
+	// Accept any orphan transactions that depend on the transactions of
+	// the package.
+	numAccepted := len(result.Accepted)
+	for _, txD := range result.Accepted[:numAccepted] {
+		newTxs := mp.processOrphans(txD.Tx)
+		result.Accepted = append(result.Accepted, newTxs...)
+	}
+
+	log.Debugf("Accepted %d transactions of package of %v (pool size: %v)",
+		numAccepted, txns[len(txns)-1].Hash(), len(mp.pool))
+
+	return result, nil
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// createSignedTx creates a transaction with a single output which spends the
+// passed output with the passed fee, without adding it to the pool.
+func (ctx *testContext) createSignedTx(input spendableOutput, fee btcutil.Amount,
+	signalsReplacement bool) *btcutil.Tx {
+
+	ctx.t.Helper()
+
+	tx, err := ctx.harness.CreateSignedTx([]spendableOutput{input}, 1, fee,
+		signalsReplacement)
+	if err != nil {
+		ctx.t.Fatalf("unable to create transaction: %v", err)
+	}
+	return tx
+}
+
+// processPackage processes the passed package and ensures it forms a valid
+// package.
+func (ctx *testContext) processPackage(txns []*btcutil.Tx,
+	testAccept bool) *PackageResult {
+
+	ctx.t.Helper()
+
+	result, err := ctx.harness.txPool.ProcessPackage(txns, 0, testAccept)
+	if err != nil {
+		ctx.t.Fatalf("unable to process package: %v", err)
+	}
+	if len(result.TxResults) != len(txns) {
+		ctx.t.Fatalf("got %d transaction results, want %d",
+			len(result.TxResults), len(txns))
+	}
+	return result
+}
+
+// checkRejectCode ensures the passed error rejects a transaction with the
+// passed reject code.
+func checkRejectCode(t *testing.T, err error, want wire.RejectCode) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("transaction was accepted, want reject code %v", want)
+	}
+	code, _ := extractRejectCode(err)
+	if code != want {
+		t.Fatalf("got reject code %v, want %v: %v", code, want, err)
+	}
+}
+
+// TestProcessPackage ensures a child can pay for parents which don't pay enough
+// to be accepted to the pool on their own, while parents paying enough are
+// accepted on their own and never pay for their child.
+func TestProcessPackage(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+	coinbase := ctx.addCoinbaseTx(4)
+
+	// Raise the minimum fee rate to enter the pool so that a transaction
+	// of about 200 virtual bytes needs a fee of about 2000.
+	mp.rollingMinFee = 10000
+
+	// The parent doesn't pay enough on its own, and its child is an
+	// orphan.
+	parent := ctx.createSignedTx(txOutToSpendableOut(coinbase, 0), 500,
+		false)
+	child := ctx.createSignedTx(txOutToSpendableOut(parent, 0), 5000, false)
+	_, err = mp.ProcessTransaction(parent, false, false, 0)
+	checkRejectCode(t, err, wire.RejectInsufficientFee)
+	if _, err := mp.ProcessTransaction(child, true, false, 0); err != nil {
+		t.Fatalf("unable to process orphan: %v", err)
+	}
+	testPoolMembership(ctx, child, true, false)
+
+	// Testing the package accepts both of them along with each other
+	// without adding them to the pool.
+	result := ctx.processPackage([]*btcutil.Tx{parent, child}, true)
+	for _, res := range result.TxResults {
+		if res.Err != nil {
+			t.Fatalf("transaction %v was rejected: %v", res.Tx.Hash(),
+				res.Err)
+		}
+		if len(res.EffectiveIncludes) != 2 {
+			t.Fatalf("got %d transactions included in the "+
+				"effective fee rate, want 2",
+				len(res.EffectiveIncludes))
+		}
+		if res.TxDesc != nil {
+			t.Fatalf("tested transaction %v was added to the pool",
+				res.Tx.Hash())
+		}
+	}
+	wantFeeRate := int64(5500) * 1000 /
+		(GetTxVirtualSize(parent) + GetTxVirtualSize(child))
+	if feeRate := result.TxResults[0].EffectiveFeePerKB; feeRate != wantFeeRate {
+		t.Fatalf("got effective fee rate %d, want %d", feeRate,
+			wantFeeRate)
+	}
+	testPoolMembership(ctx, parent, false, false)
+	testPoolMembership(ctx, child, true, false)
+
+	// Submitting the package adds both of them to the pool and removes
+	// the child from the orphan pool.
+	result = ctx.processPackage([]*btcutil.Tx{parent, child}, false)
+	for _, res := range result.TxResults {
+		if res.Err != nil || res.TxDesc == nil {
+			t.Fatalf("transaction %v was not accepted: %v",
+				res.Tx.Hash(), res.Err)
+		}
+	}
+	if len(result.Accepted) != 2 {
+		t.Fatalf("got %d accepted transactions, want 2",
+			len(result.Accepted))
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	checkPackageStats(ctx)
+
+	// Submitting it again finds both of them in the pool.
+	result = ctx.processPackage([]*btcutil.Tx{parent, child}, false)
+	for _, res := range result.TxResults {
+		if !res.AlreadyInPool || res.Err != nil {
+			t.Fatalf("transaction %v not found in the pool: %v",
+				res.Tx.Hash(), res.Err)
+		}
+	}
+
+	// A parent paying enough on its own is accepted, but doesn't pay for
+	// its child.
+	parent = ctx.createSignedTx(txOutToSpendableOut(coinbase, 1), 10000,
+		false)
+	child = ctx.createSignedTx(txOutToSpendableOut(parent, 0), 100, false)
+	result = ctx.processPackage([]*btcutil.Tx{parent, child}, false)
+	if err := result.TxResults[0].Err; err != nil {
+		t.Fatalf("parent was rejected: %v", err)
+	}
+	checkRejectCode(t, result.TxResults[1].Err, wire.RejectInsufficientFee)
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, false)
+
+	// A package which doesn't pay enough as a whole is rejected.
+	parent = ctx.createSignedTx(txOutToSpendableOut(coinbase, 2), 500,
+		false)
+	child = ctx.createSignedTx(txOutToSpendableOut(parent, 0), 500, false)
+	result = ctx.processPackage([]*btcutil.Tx{parent, child}, false)
+	for _, res := range result.TxResults {
+		checkRejectCode(t, res.Err, wire.RejectInsufficientFee)
+		testPoolMembership(ctx, res.Tx, false, false)
+	}
+
+	// A child spending the outputs of an unknown transaction is rejected.
+	unknown := ctx.createSignedTx(txOutToSpendableOut(coinbase, 3), 500,
+		false)
+	orphan := ctx.createSignedTx(txOutToSpendableOut(unknown, 0), 5000,
+		false)
+	result = ctx.processPackage([]*btcutil.Tx{orphan}, false)
+	checkRejectCode(t, result.TxResults[0].Err, wire.RejectDuplicate)
+
+	// A transaction paying more than the maximum fee rate is rejected.
+	tx := ctx.createSignedTx(txOutToSpendableOut(coinbase, 3), 10000, false)
+	result, err = mp.ProcessPackage([]*btcutil.Tx{tx}, 10000, false)
+	if err != nil {
+		t.Fatalf("unable to process package: %v", err)
+	}
+	checkRejectCode(t, result.TxResults[0].Err, wire.RejectNonstandard)
+	testPoolMembership(ctx, tx, false, false)
+}
+
+// TestPackageReplacement ensures a parent along with its child can replace
+// transactions in the pool when the package pays more than them as a whole.
+func TestPackageReplacement(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	coinbase := ctx.addCoinbaseTx(1)
+	conflict := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 3000, true, false)
+
+	// The parent pays less than the transaction it conflicts with, and the
+	// child doesn't make up for it.
+	parent := ctx.createSignedTx(txOutToSpendableOut(coinbase, 0), 1000,
+		true)
+	child := ctx.createSignedTx(txOutToSpendableOut(parent, 0), 2000, false)
+	result := ctx.processPackage([]*btcutil.Tx{parent, child}, false)
+	for _, res := range result.TxResults {
+		checkRejectCode(t, res.Err, wire.RejectInsufficientFee)
+	}
+	testPoolMembership(ctx, conflict, false, true)
+
+	// A child paying enough for both of them replaces the transaction.
+	child = ctx.createSignedTx(txOutToSpendableOut(parent, 0), 8000, false)
+	result = ctx.processPackage([]*btcutil.Tx{parent, child}, false)
+	for _, res := range result.TxResults {
+		if res.Err != nil {
+			t.Fatalf("transaction %v was rejected: %v", res.Tx.Hash(),
+				res.Err)
+		}
+	}
+	if len(result.Replaced) != 1 || result.Replaced[0] != conflict {
+		t.Fatalf("got %d replaced transactions, want the conflict",
+			len(result.Replaced))
+	}
+	testPoolMembership(ctx, conflict, false, false)
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	checkPackageStats(ctx)
+}
+
+// TestCheckPackage ensures packages which aren't made of a child along with its
+// parents sorted before it are rejected.
+func TestCheckPackage(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	coinbase := ctx.addCoinbaseTx(2)
+	outputs := []spendableOutput{txOutToSpendableOut(coinbase, 0),
+		txOutToSpendableOut(coinbase, 1)}
+
+	parent1 := ctx.createSignedTx(outputs[0], 1000, false)
+	parent2 := ctx.createSignedTx(outputs[1], 1000, false)
+	child, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent1, 0),
+		txOutToSpendableOut(parent2, 0)}, 1, 1000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	grandchild := ctx.createSignedTx(txOutToSpendableOut(child, 0), 1000,
+		false)
+	doubleSpend := ctx.createSignedTx(outputs[0], 2000, false)
+
+	tests := []struct {
+		name  string
+		txns  []*btcutil.Tx
+		valid bool
+	}{{
+		name:  "child with parents",
+		txns:  []*btcutil.Tx{parent1, parent2, child},
+		valid: true,
+	}, {
+		name:  "single transaction",
+		txns:  []*btcutil.Tx{parent1},
+		valid: true,
+	}, {
+		name: "empty",
+	}, {
+		name: "unsorted",
+		txns: []*btcutil.Tx{parent1, child, parent2},
+	}, {
+		name: "not a parent",
+		txns: []*btcutil.Tx{parent1, child, grandchild},
+	}, {
+		name: "duplicate",
+		txns: []*btcutil.Tx{parent1, parent1, child},
+	}, {
+		name: "conflicting",
+		txns: []*btcutil.Tx{doubleSpend, parent1, child},
+	}}
+	for _, test := range tests {
+		err := checkPackage(test.txns)
+		if test.valid && err != nil {
+			t.Fatalf("%s: package was rejected: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%s: package was accepted", test.name)
+		}
+	}
+
+	txns := make([]*btcutil.Tx, MaxPackageCount+1)
+	for i := range txns {
+		txns[i] = parent1
+	}
+	if err := checkPackage(txns); err == nil {
+		t.Fatal("package with too many transactions was accepted")
+	}
+}
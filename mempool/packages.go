@@ -0,0 +1,259 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// DefaultMaxAncestorCount is the default maximum number of
+	// transactions, including itself, in the package made of a transaction
+	// and its unconfirmed ancestors.
+	DefaultMaxAncestorCount = 25
+
+	// DefaultMaxAncestorSize is the default maximum virtual size of the
+	// package made of a transaction and its unconfirmed ancestors.
+	DefaultMaxAncestorSize = 101000
+
+	// DefaultMaxDescendantCount is the default maximum number of
+	// transactions, including itself, in the package made of a transaction
+	// and its descendants in the pool.
+	DefaultMaxDescendantCount = 25
+
+	// DefaultMaxDescendantSize is the default maximum virtual size of the
+	// package made of a transaction and its descendants in the pool.
+	DefaultMaxDescendantSize = 101000
+)
+
+// hasPoolDescendants returns whether any of the outputs of the passed
+// transaction is spent by another transaction in the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) hasPoolDescendants(tx *btcutil.Tx) bool {
+	op := wire.OutPoint{Hash: *tx.Hash()}
+	for i := range tx.MsgTx().TxOut {
+		op.Index = uint32(i)
+		if _, ok := mp.outpoints[op]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// calcAncestorStats sets the ancestor count, size and fee of the passed
+// transaction descriptor from its ancestors in the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) calcAncestorStats(txD *TxDesc) {
+	txD.AncestorCount = 1
+	txD.AncestorSize = GetTxVirtualSize(txD.Tx)
+	txD.AncestorFee = txD.Fee + txD.FeeDelta
+	for hash := range mp.txAncestors(txD.Tx, nil) {
+		ancestor := mp.pool[hash]
+		txD.AncestorCount++
+		txD.AncestorSize += GetTxVirtualSize(ancestor.Tx)
+		txD.AncestorFee += ancestor.Fee + ancestor.FeeDelta
+	}
+}
+
+// calcDescendantStats sets the descendant count, size and fee of the passed
+// transaction descriptor from its descendants in the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) calcDescendantStats(txD *TxDesc) {
+	txD.DescendantCount = 1
+	txD.DescendantSize = GetTxVirtualSize(txD.Tx)
+	txD.DescendantFee = txD.Fee + txD.FeeDelta
+	for hash := range mp.txDescendants(txD.Tx, nil) {
+		descendant := mp.pool[hash]
+		txD.DescendantCount++
+		txD.DescendantSize += GetTxVirtualSize(descendant.Tx)
+		txD.DescendantFee += descendant.Fee + descendant.FeeDelta
+	}
+}
+
+// addPackageStats sets the ancestor and descendant stats of the passed
+// transaction, which was just added to the pool, and updates the ones of the
+// transactions related to it.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) addPackageStats(txD *TxDesc) {
+	mp.calcAncestorStats(txD)
+
+	// A transaction added back to the pool after the block including it
+	// was disconnected can already have descendants in the pool, which its
+	// ancestors now have as well, unless they already did through another
+	// path, so the stats of its relatives are computed again in that case.
+	if mp.hasPoolDescendants(txD.Tx) {
+		mp.refreshPackageStats(mp.txAncestors(txD.Tx, nil),
+			mp.txDescendants(txD.Tx, nil))
+		mp.calcDescendantStats(txD)
+		return
+	}
+
+	txD.DescendantCount = 1
+	txD.DescendantSize = GetTxVirtualSize(txD.Tx)
+	txD.DescendantFee = txD.Fee + txD.FeeDelta
+	for hash := range mp.txAncestors(txD.Tx, nil) {
+		ancestor := mp.pool[hash]
+		ancestor.DescendantCount++
+		ancestor.DescendantSize += txD.DescendantSize
+		ancestor.DescendantFee += txD.DescendantFee
+		mp.evictionQueue.update(ancestor)
+	}
+}
+
+// removePackageStats updates the stats of the transactions related to the
+// passed transaction, which is being removed from the pool.  It returns the
+// ancestors and descendants whose stats must be computed again with
+// refreshPackageStats once the transaction has been removed.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) removePackageStats(txD *TxDesc) (map[chainhash.Hash]*btcutil.Tx,
+	map[chainhash.Hash]*btcutil.Tx) {
+
+	ancestors := mp.txAncestors(txD.Tx, nil)
+
+	// The descendants left in the pool by a transaction removed without
+	// them, as when it is mined, lose it and possibly its ancestors as
+	// ancestors, so the stats of its relatives are computed again in that
+	// case.
+	if mp.hasPoolDescendants(txD.Tx) {
+		return ancestors, mp.txDescendants(txD.Tx, nil)
+	}
+
+	size := GetTxVirtualSize(txD.Tx)
+	for hash := range ancestors {
+		ancestor := mp.pool[hash]
+		ancestor.DescendantCount--
+		ancestor.DescendantSize -= size
+		ancestor.DescendantFee -= txD.Fee + txD.FeeDelta
+		mp.evictionQueue.update(ancestor)
+	}
+	return nil, nil
+}
+
+// refreshPackageStats computes the descendant stats of the passed ancestors and
+// the ancestor stats of the passed descendants again.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) refreshPackageStats(ancestors,
+	descendants map[chainhash.Hash]*btcutil.Tx) {
+
+	for hash := range ancestors {
+		ancestor := mp.pool[hash]
+		mp.calcDescendantStats(ancestor)
+		mp.evictionQueue.update(ancestor)
+	}
+	for hash := range descendants {
+		mp.calcAncestorStats(mp.pool[hash])
+	}
+}
+
+// checkPackageLimits returns an error if accepting the passed transactions to
+// the pool would make the package they form with their unconfirmed ancestors,
+// or the package any of these ancestors forms with its descendants, exceed the
+// limits set by the policy of the pool.  Several transactions, which are the
+// ancestors of the last one in a package accepted along with it, are counted
+// as a single one spending all of their ancestors in the pool, which may
+// overestimate the packages they form but never underestimates them.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageLimits(txns ...*btcutil.Tx) error {
+	policy := &mp.cfg.Policy
+	tx := txns[len(txns)-1]
+	ancestors := make(map[chainhash.Hash]*btcutil.Tx)
+	var size int64
+	for _, tx := range txns {
+		for hash, ancestor := range mp.txAncestors(tx, nil) {
+			ancestors[hash] = ancestor
+		}
+		size += GetTxVirtualSize(tx)
+	}
+
+	ancestorCount := len(txns) + len(ancestors)
+	if policy.MaxAncestorCount > 0 && ancestorCount > policy.MaxAncestorCount {
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d > %d", tx.Hash(), ancestorCount,
+			policy.MaxAncestorCount)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	ancestorSize := size
+	for hash := range ancestors {
+		ancestor := mp.pool[hash]
+		ancestorSize += GetTxVirtualSize(ancestor.Tx)
+
+		if policy.MaxDescendantCount > 0 && ancestor.DescendantCount+
+			int64(len(txns)) > int64(policy.MaxDescendantCount) {
+
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"descendant count limit of %d of its unconfirmed "+
+				"ancestor %v", tx.Hash(),
+				policy.MaxDescendantCount, hash)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		if policy.MaxDescendantSize > 0 &&
+			ancestor.DescendantSize+size > policy.MaxDescendantSize {
+
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"descendant size limit of %d of its unconfirmed "+
+				"ancestor %v", tx.Hash(),
+				policy.MaxDescendantSize, hash)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+	if policy.MaxAncestorSize > 0 && ancestorSize > policy.MaxAncestorSize {
+		str := fmt.Sprintf("transaction %v has unconfirmed ancestors "+
+			"which are too large: %d > %d", tx.Hash(), ancestorSize,
+			policy.MaxAncestorSize)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	return nil
+}
+
+// PrioritiseTransaction adds the passed delta to the fee of the transaction
+// with the passed hash when it is compared to the fees of other transactions in
+// the pool, so that it is mined or evicted as if it paid that much more or
+// less.  The delta is kept for a transaction which isn't in the pool yet, until
+// it is included in a connected block.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) PrioritiseTransaction(txHash *chainhash.Hash, delta int64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	feeDelta := mp.feeDeltas[*txHash] + delta
+	if feeDelta == 0 {
+		delete(mp.feeDeltas, *txHash)
+	} else {
+		mp.feeDeltas[*txHash] = feeDelta
+	}
+
+	txD, exists := mp.pool[*txHash]
+	if !exists {
+		return
+	}
+	txD.FeeDelta += delta
+	txD.AncestorFee += delta
+	txD.DescendantFee += delta
+	mp.evictionQueue.update(txD)
+	for hash := range mp.txAncestors(txD.Tx, nil) {
+		ancestor := mp.pool[hash]
+		ancestor.DescendantFee += delta
+		mp.evictionQueue.update(ancestor)
+	}
+	for hash := range mp.txDescendants(txD.Tx, nil) {
+		mp.pool[hash].AncestorFee += delta
+	}
+	log.Debugf("Prioritised transaction %v with a fee delta of %d "+
+		"(total: %d)", txHash, delta, txD.FeeDelta)
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// checkPackageStats ensures the ancestor and descendant stats of all of the
+// transactions in the pool of the passed test context match the ones computed
+// from scratch, as well as the memory usage of the pool.
+func checkPackageStats(ctx *testContext) {
+	ctx.t.Helper()
+
+	mp := ctx.harness.txPool
+	var usage int64
+	for hash, txD := range mp.pool {
+		want := *txD
+		mp.calcAncestorStats(&want)
+		mp.calcDescendantStats(&want)
+		if txD.AncestorCount != want.AncestorCount ||
+			txD.AncestorSize != want.AncestorSize ||
+			txD.AncestorFee != want.AncestorFee {
+
+			ctx.t.Fatalf("transaction %v has ancestor count %d, size "+
+				"%d and fee %d, want %d, %d and %d", hash,
+				txD.AncestorCount, txD.AncestorSize,
+				txD.AncestorFee, want.AncestorCount,
+				want.AncestorSize, want.AncestorFee)
+		}
+		if txD.DescendantCount != want.DescendantCount ||
+			txD.DescendantSize != want.DescendantSize ||
+			txD.DescendantFee != want.DescendantFee {
+
+			ctx.t.Fatalf("transaction %v has descendant count %d, "+
+				"size %d and fee %d, want %d, %d and %d", hash,
+				txD.DescendantCount, txD.DescendantSize,
+				txD.DescendantFee, want.DescendantCount,
+				want.DescendantSize, want.DescendantFee)
+		}
+		usage += txUsage(txD.Tx)
+	}
+	if mp.totalUsage != usage {
+		ctx.t.Fatalf("pool usage is %d, want %d", mp.totalUsage, usage)
+	}
+
+	// The eviction queue holds the transactions in the pool ordered by
+	// their descendant fee rate.
+	q := mp.evictionQueue
+	if q.Len() != len(mp.pool) || len(q.positions) != len(mp.pool) {
+		ctx.t.Fatalf("eviction queue has %d transactions and %d "+
+			"positions, want %d", q.Len(), len(q.positions),
+			len(mp.pool))
+	}
+	for i, txD := range q.txns {
+		if mp.pool[*txD.Tx.Hash()] != txD {
+			ctx.t.Fatalf("eviction queue has transaction %v which "+
+				"isn't in the pool", txD.Tx.Hash())
+		}
+		if q.positions[*txD.Tx.Hash()] != i {
+			ctx.t.Fatalf("eviction queue has transaction %v at %d, "+
+				"want %d", txD.Tx.Hash(),
+				q.positions[*txD.Tx.Hash()], i)
+		}
+		if i > 0 && q.Less(i, (i-1)/2) {
+			ctx.t.Fatalf("eviction queue has transaction %v before "+
+				"its parent", txD.Tx.Hash())
+		}
+	}
+}
+
+// TestPackageStats ensures the ancestor and descendant stats of the
+// transactions in the pool are kept up to date as transactions are added to and
+// removed from it.
+func TestPackageStats(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+
+	// Create the following transactions, where B and C spend A, D spends
+	// C, and E spends B and D:
+	//
+	//       B ----
+	//     /        \
+	//   A            E
+	//     \        /
+	//       C -- D
+	a := ctx.addSignedTx(outputs[:1], 2, 1000, false, false)
+	b := ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(a, 0)}, 1,
+		2000, false, false)
+	c := ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(a, 1)}, 1,
+		3000, false, false)
+	d := ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(c, 0)}, 1,
+		4000, false, false)
+	e := ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(b, 0),
+		txOutToSpendableOut(d, 0)}, 1, 5000, false, false)
+	checkPackageStats(ctx)
+	if fee := mp.pool[*a.Hash()].DescendantFee; fee != 15000 {
+		t.Fatalf("got descendant fee %d for A, want %d", fee, 15000)
+	}
+	if count := mp.pool[*e.Hash()].AncestorCount; count != 5 {
+		t.Fatalf("got ancestor count %d for E, want %d", count, 5)
+	}
+
+	// Removing C without its descendants, as when it is mined, leaves D
+	// without a path to A, while E still descends from A through B.
+	mp.RemoveTransaction(c, false)
+	checkPackageStats(ctx)
+	if fee := mp.pool[*a.Hash()].DescendantFee; fee != 8000 {
+		t.Fatalf("got descendant fee %d for A, want %d", fee, 8000)
+	}
+	if fee := mp.pool[*d.Hash()].AncestorFee; fee != 4000 {
+		t.Fatalf("got ancestor fee %d for D, want %d", fee, 4000)
+	}
+
+	// Adding C back, as when the block including it is disconnected, makes
+	// D a descendant of A again.
+	_, _, err = mp.MaybeAcceptTransaction(c, false, false)
+	if err != nil {
+		t.Fatalf("unable to add back transaction: %v", err)
+	}
+	checkPackageStats(ctx)
+	if fee := mp.pool[*a.Hash()].DescendantFee; fee != 15000 {
+		t.Fatalf("got descendant fee %d for A, want %d", fee, 15000)
+	}
+	if fee := mp.pool[*d.Hash()].AncestorFee; fee != 8000 {
+		t.Fatalf("got ancestor fee %d for D, want %d", fee, 8000)
+	}
+
+	mp.RemoveTransaction(e, false)
+	checkPackageStats(ctx)
+	mp.RemoveTransaction(a, true)
+	checkPackageStats(ctx)
+	if len(mp.pool) != 0 {
+		t.Fatalf("pool has %d transactions, want none", len(mp.pool))
+	}
+}
+
+// TestPackageLimits ensures transactions which would make a package in the pool
+// exceed the ancestor or descendant limits of the policy are rejected.
+func TestPackageLimits(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+	coinbase := ctx.addCoinbaseTx(2)
+
+	// expectRejected ensures the passed transaction is rejected as
+	// nonstandard and not added to the pool.
+	expectRejected := func(outputs []spendableOutput, numOutputs uint32) {
+		t.Helper()
+
+		tx, err := harness.CreateSignedTx(outputs, numOutputs, 1000, false)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+		_, err = mp.ProcessTransaction(tx, false, false, 0)
+		if err == nil {
+			t.Fatal("transaction exceeding the package limits was " +
+				"accepted")
+		}
+		if code, _ := extractRejectCode(err); code != wire.RejectNonstandard {
+			t.Fatalf("got reject code %v, want %v", code,
+				wire.RejectNonstandard)
+		}
+		testPoolMembership(ctx, tx, false, false)
+	}
+
+	// Build a chain of transactions up to the ancestor count limit, after
+	// which a transaction spending the last one is rejected.
+	mp.cfg.Policy.MaxAncestorCount = 4
+	chain := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 3, 1000, false, false)
+	root := chain
+	for i := 0; i < 3; i++ {
+		chain = ctx.addSignedTx([]spendableOutput{
+			txOutToSpendableOut(chain, 0)}, 1, 1000, false, false)
+	}
+	expectRejected([]spendableOutput{txOutToSpendableOut(chain, 0)}, 1)
+	checkPackageStats(ctx)
+
+	// The root of the chain has 4 descendants including itself, so with a
+	// descendant count limit of 5 it can only get one more child.
+	mp.cfg.Policy.MaxAncestorCount = 0
+	mp.cfg.Policy.MaxDescendantCount = 5
+	ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(root, 1)}, 1,
+		1000, false, false)
+	expectRejected([]spendableOutput{txOutToSpendableOut(root, 2)}, 1)
+
+	// A transaction unrelated to the chain isn't limited by it, unless it
+	// alone exceeds the ancestor size limit.
+	mp.cfg.Policy.MaxDescendantCount = 0
+	mp.cfg.Policy.MaxAncestorSize = 100
+	expectRejected([]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1)
+	mp.cfg.Policy.MaxAncestorSize = 0
+
+	// Limit the descendant size of the root to what it already is, which
+	// rejects any new descendant.
+	mp.cfg.Policy.MaxDescendantSize = mp.pool[*root.Hash()].DescendantSize
+	expectRejected([]spendableOutput{txOutToSpendableOut(chain, 0)}, 1)
+	checkPackageStats(ctx)
+}
+
+// TestPrioritiseTransaction ensures the fee deltas set to prioritise
+// transactions are accounted for in the package stats, including the ones set
+// before the transactions are added to the pool, and cleared once the
+// transactions are mined.
+func TestPrioritiseTransaction(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+	coinbase := ctx.addCoinbaseTx(1)
+
+	parent := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 1000, false, false)
+	mp.PrioritiseTransaction(parent.Hash(), 5000)
+	checkPackageStats(ctx)
+
+	// The delta of the child is set before it is added to the pool.
+	child, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 0)}, 1, 2000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	mp.PrioritiseTransaction(child.Hash(), -500)
+	_, err = mp.ProcessTransaction(child, false, false, 0)
+	if err != nil {
+		t.Fatalf("unable to process transaction: %v", err)
+	}
+	checkPackageStats(ctx)
+
+	parentDesc := mp.pool[*parent.Hash()]
+	childDesc := mp.pool[*child.Hash()]
+	if parentDesc.Fee != 1000 || parentDesc.FeeDelta != 5000 {
+		t.Fatalf("got parent fee %d and delta %d, want %d and %d",
+			parentDesc.Fee, parentDesc.FeeDelta, 1000, 5000)
+	}
+	if childDesc.AncestorFee != 7500 || parentDesc.DescendantFee != 7500 {
+		t.Fatalf("got child ancestor fee %d and parent descendant fee "+
+			"%d, want %d", childDesc.AncestorFee,
+			parentDesc.DescendantFee, 7500)
+	}
+	entry, err := mp.MempoolEntry(child.Hash())
+	if err != nil {
+		t.Fatalf("unable to get mempool entry: %v", err)
+	}
+	if entry.ModifiedFee != btcutil.Amount(1500).ToBTC() {
+		t.Fatalf("got modified fee %v, want %v", entry.ModifiedFee,
+			btcutil.Amount(1500).ToBTC())
+	}
+
+	// Cancelling the delta of the parent brings the stats back to the
+	// actual fees.
+	mp.PrioritiseTransaction(parent.Hash(), -5000)
+	checkPackageStats(ctx)
+	if fee := mp.pool[*child.Hash()].AncestorFee; fee != 2500 {
+		t.Fatalf("got child ancestor fee %d, want %d", fee, 2500)
+	}
+
+	// The delta of the child is cleared once it is mined.
+	mp.RemoveTransaction(parent, false)
+	mp.RemoveTransaction(child, false)
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{parent.MsgTx(), child.MsgTx()},
+	})
+	mp.BlockConnected(block)
+	if len(mp.feeDeltas) != 0 {
+		t.Fatalf("got %d fee deltas left, want none", len(mp.feeDeltas))
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// mempoolSaveVersion is the version of the format the pool is saved in by
+// Save.
+const mempoolSaveVersion = 1
+
+// ErrLoadInterrupted is returned by Load when it is interrupted before all of
+// the saved transactions have been read.
+var ErrLoadInterrupted = errors.New("loading the memory pool was interrupted")
+
+// LoadStats holds the number of transactions read by Load which were accepted
+// to the pool, rejected, dropped because they had expired, or already in the
+// pool.
+type LoadStats struct {
+	Accepted    int
+	Failed      int
+	Expired     int
+	AlreadyHave int
+}
+
+// Save writes the transactions in the main pool to the passed writer along
+// with the time they were added to the pool and their fee deltas, as well as
+// the fee deltas of the transactions which aren't in the pool, so that they
+// can be restored with Load.  The transactions are written after their
+// ancestors in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Save(w io.Writer) error {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	for _, desc := range mp.pool {
+		descs = append(descs, desc)
+	}
+
+	// A transaction always has more ancestors than any of its own
+	// ancestors.
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].AncestorCount < descs[j].AncestorCount
+	})
+
+	err := binary.Write(w, binary.BigEndian, uint32(mempoolSaveVersion))
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.BigEndian, uint32(len(descs)))
+	if err != nil {
+		return err
+	}
+	for _, desc := range descs {
+		err := binary.Write(w, binary.BigEndian, desc.Added.Unix())
+		if err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.BigEndian, desc.FeeDelta)
+		if err != nil {
+			return err
+		}
+		if err := desc.Tx.MsgTx().Serialize(w); err != nil {
+			return err
+		}
+	}
+
+	var numDeltas uint32
+	for hash := range mp.feeDeltas {
+		if _, exists := mp.pool[hash]; !exists {
+			numDeltas++
+		}
+	}
+	err = binary.Write(w, binary.BigEndian, numDeltas)
+	if err != nil {
+		return err
+	}
+	for hash, delta := range mp.feeDeltas {
+		if _, exists := mp.pool[hash]; exists {
+			continue
+		}
+		if _, err := w.Write(hash[:]); err != nil {
+			return err
+		}
+		err := binary.Write(w, binary.BigEndian, delta)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load reads the transactions saved by Save from the passed reader and adds
+// them back to the pool with the time they were first added and their fee
+// deltas.  They are validated again as if they were received from a peer, and
+// those which have been in the pool for longer than the expiry time are
+// dropped.  The fee deltas of the transactions which weren't in the pool are
+// restored as well, unless a fee delta has already been set for them since.
+//
+// Load stops between transactions once the passed interrupt channel is closed,
+// in which case ErrLoadInterrupted is returned along with the transactions read
+// up to then.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(r io.Reader, interrupt <-chan struct{}) (*LoadStats, error) {
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != mempoolSaveVersion {
+		return nil, fmt.Errorf("incorrect version: expected %d found %d",
+			mempoolSaveVersion, version)
+	}
+
+	var numTxns uint32
+	if err := binary.Read(r, binary.BigEndian, &numTxns); err != nil {
+		return nil, err
+	}
+	var stats LoadStats
+	now := time.Now()
+	for i := uint32(0); i < numTxns; i++ {
+		select {
+		case <-interrupt:
+			return &stats, ErrLoadInterrupted
+		default:
+		}
+
+		var addedUnix, feeDelta int64
+		err := binary.Read(r, binary.BigEndian, &addedUnix)
+		if err != nil {
+			return &stats, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &feeDelta); err != nil {
+			return &stats, err
+		}
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return &stats, err
+		}
+
+		added := time.Unix(addedUnix, 0)
+		expiryTime := mp.cfg.Policy.ExpiryTime
+		if expiryTime > 0 && now.Sub(added) > expiryTime {
+			stats.Expired++
+			continue
+		}
+		mp.loadTransaction(btcutil.NewTx(&msgTx), added, feeDelta,
+			&stats)
+	}
+
+	var numDeltas uint32
+	if err := binary.Read(r, binary.BigEndian, &numDeltas); err != nil {
+		return &stats, err
+	}
+	for i := uint32(0); i < numDeltas; i++ {
+		var hash chainhash.Hash
+		if _, err := io.ReadFull(r, hash[:]); err != nil {
+			return &stats, err
+		}
+		var feeDelta int64
+		if err := binary.Read(r, binary.BigEndian, &feeDelta); err != nil {
+			return &stats, err
+		}
+
+		mp.mtx.Lock()
+		if _, exists := mp.feeDeltas[hash]; !exists && feeDelta != 0 {
+			mp.feeDeltas[hash] = feeDelta
+		}
+		mp.mtx.Unlock()
+	}
+
+	return &stats, nil
+}
+
+// loadTransaction adds the passed transaction read by Load back to the pool
+// with the passed time it was first added and fee delta, and accounts for the
+// outcome in the passed stats.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) loadTransaction(tx *btcutil.Tx, added time.Time, feeDelta int64, stats *LoadStats) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	txHash := tx.Hash()
+	if mp.isTransactionInPool(txHash) {
+		stats.AlreadyHave++
+		return
+	}
+	if _, exists := mp.feeDeltas[*txHash]; !exists && feeDelta != 0 {
+		mp.feeDeltas[*txHash] = feeDelta
+	}
+
+	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, false,
+		true)
+	switch {
+	case err != nil:
+		log.Debugf("Unable to load transaction %v: %v", txHash, err)
+		stats.Failed++
+
+	case len(missingParents) > 0:
+		log.Debugf("Unable to load transaction %v: spends outputs of "+
+			"unknown transactions", txHash)
+		stats.Failed++
+
+	default:
+		txD.Added = added
+		stats.Accepted++
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestSaveLoad ensures the transactions saved from the pool are added back to
+// it along with the time they were added and their fee deltas, except for the
+// expired ones.
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	mp := harness.txPool
+	mp.cfg.Policy.ExpiryTime = time.Hour
+	coinbase := ctx.addCoinbaseTx(2)
+
+	parent := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0)}, 1, 1000, false, false)
+	child := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 0)}, 1, 1000, false, false)
+	expired := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 1)}, 1, 1000, false, false)
+	mp.PrioritiseTransaction(child.Hash(), 3000)
+	unknownHash := chainhash.Hash{0x01}
+	mp.PrioritiseTransaction(&unknownHash, 2000)
+
+	// Make the parent older than the child so it would come last without
+	// being sorted after its ancestors, and have the expired transaction
+	// be older than the expiry time by the time the pool is loaded back.
+	parentAdded := time.Unix(time.Now().Add(-30*time.Minute).Unix(), 0)
+	mp.pool[*parent.Hash()].Added = parentAdded
+	mp.pool[*expired.Hash()].Added = time.Now().Add(-2 * time.Hour)
+
+	var buf bytes.Buffer
+	if err := mp.Save(&buf); err != nil {
+		t.Fatalf("unable to save pool: %v", err)
+	}
+	saved := buf.Bytes()
+
+	// Empty the pool along with the fee deltas before loading it back.
+	for _, tx := range []*btcutil.Tx{parent, expired} {
+		mp.RemoveTransaction(tx, true)
+	}
+	mp.feeDeltas = make(map[chainhash.Hash]int64)
+
+	stats, err := mp.Load(bytes.NewReader(saved), nil)
+	if err != nil {
+		t.Fatalf("unable to load pool: %v", err)
+	}
+	want := LoadStats{Accepted: 2, Expired: 1}
+	if *stats != want {
+		t.Fatalf("got load stats %+v, want %+v", *stats, want)
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	testPoolMembership(ctx, expired, false, false)
+	checkPackageStats(ctx)
+
+	if added := mp.pool[*parent.Hash()].Added; !added.Equal(parentAdded) {
+		t.Fatalf("got parent added at %v, want %v", added, parentAdded)
+	}
+	if delta := mp.pool[*child.Hash()].FeeDelta; delta != 3000 {
+		t.Fatalf("got child fee delta %d, want %d", delta, 3000)
+	}
+	if delta := mp.feeDeltas[unknownHash]; delta != 2000 {
+		t.Fatalf("got fee delta %d for unknown tx, want %d", delta, 2000)
+	}
+
+	// Loading the pool again finds the transactions already in it and
+	// doesn't add the fee deltas again.
+	stats, err = mp.Load(bytes.NewReader(saved), nil)
+	if err != nil {
+		t.Fatalf("unable to load pool: %v", err)
+	}
+	want = LoadStats{AlreadyHave: 2, Expired: 1}
+	if *stats != want {
+		t.Fatalf("got load stats %+v, want %+v", *stats, want)
+	}
+	if delta := mp.pool[*child.Hash()].FeeDelta; delta != 3000 {
+		t.Fatalf("got child fee delta %d, want %d", delta, 3000)
+	}
+
+	// A pool saved in an unknown version isn't loaded.
+	saved[3] = mempoolSaveVersion + 1
+	if _, err := mp.Load(bytes.NewReader(saved), nil); err == nil {
+		t.Fatal("pool saved in an unknown version was loaded")
+	}
+
+	// Loading is interrupted before the first transaction.
+	saved[3] = mempoolSaveVersion
+	interrupt := make(chan struct{})
+	close(interrupt)
+	_, err = mp.Load(bytes.NewReader(saved), interrupt)
+	if err != ErrLoadInterrupted {
+		t.Fatalf("got error %v, want %v", err, ErrLoadInterrupted)
+	}
+}
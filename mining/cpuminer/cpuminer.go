@@ -13,11 +13,13 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/mining"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/btcacc"
 )
 
 const (
@@ -61,6 +63,10 @@ type Config struct {
 	// blocks.  Each generated block will randomly choose one of them.
 	MiningAddrs []btcutil.Address
 
+	// SignetKeys are the keys used to sign the generated blocks when the
+	// chain is a signet.  See SignBlock for the supported challenges.
+	SignetKeys []*btcec.PrivateKey
+
 	// ProcessBlock defines the function to call with any solved blocks.
 	// It typically must run the provided block through the same set of
 	// rules and handling as any other block coming from the network.
@@ -151,8 +157,10 @@ out:
 }
 
 // submitBlock submits the passed block to network after ensuring it passes all
-// of the consensus validation rules.
-func (m *CPUMiner) submitBlock(block *btcutil.Block) bool {
+// of the consensus validation rules.  The passed utreexo accumulator proof of
+// the template the block was solved from, if any, is handed to the chain
+// first so it doesn't have to be generated again.
+func (m *CPUMiner) submitBlock(block *btcutil.Block, ud *btcacc.UData) bool {
 	m.submitBlockLock.Lock()
 	defer m.submitBlockLock.Unlock()
 
@@ -167,6 +175,7 @@ func (m *CPUMiner) submitBlock(block *btcutil.Block) bool {
 			"block %s is stale", msgBlock.Header.PrevBlock)
 		return false
 	}
+	m.g.AddPrecomputedUData(&msgBlock.Header.PrevBlock, ud)
 
 	// Process this block using the same rules as blocks coming from other
 	// nodes.  This will in turn relay it to the network like normal.
@@ -195,6 +204,22 @@ func (m *CPUMiner) submitBlock(block *btcutil.Block) bool {
 	return true
 }
 
+// signBlock signs the passed block when the chain is a signet since its
+// signature commits to the coinbase and the timestamp of the block.  It
+// returns false when the block could not be signed.
+func (m *CPUMiner) signBlock(msgBlock *wire.MsgBlock) bool {
+	if m.cfg.ChainParams.SignetChallenge == nil {
+		return true
+	}
+
+	err := SignBlock(msgBlock, m.cfg.ChainParams, m.cfg.SignetKeys)
+	if err != nil {
+		log.Errorf("Unable to sign signet block: %v", err)
+		return false
+	}
+	return true
+}
+
 // solveBlock attempts to find some combination of a nonce, extra nonce, and
 // current timestamp which makes the passed block hash to a value less than the
 // target difficulty.  The timestamp is updated periodically and the passed
@@ -233,6 +258,9 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 		// new value by regenerating the coinbase script and
 		// setting the merkle root to the new value.
 		m.g.UpdateExtraNonce(msgBlock, blockHeight, extraNonce+enOffset)
+		if !m.signBlock(msgBlock) {
+			return false
+		}
 
 		// Search through the entire nonce range for a solution while
 		// periodically checking for early quit and stale block
@@ -264,6 +292,9 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 				}
 
 				m.g.UpdateBlockTime(msgBlock)
+				if !m.signBlock(msgBlock) {
+					return false
+				}
 
 			default:
 				// Non-blocking select to fall through
@@ -356,7 +387,7 @@ out:
 		// true a solution was found, so submit the solved block.
 		if m.solveBlock(template.Block, curHeight+1, ticker, quit) {
 			block := btcutil.NewBlock(template.Block)
-			m.submitBlock(block)
+			m.submitBlock(block, template.UData)
 		}
 	}
 
@@ -610,7 +641,7 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
 		// true a solution was found, so submit the solved block.
 		if m.solveBlock(template.Block, curHeight+1, ticker, nil) {
 			block := btcutil.NewBlock(template.Block)
-			m.submitBlock(block)
+			m.submitBlock(block, template.UData)
 			blockHashes[i] = block.Hash()
 			i++
 			if i == n {
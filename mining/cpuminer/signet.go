@@ -0,0 +1,123 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package cpuminer
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// signetKeyClosure returns a key lookup for the signing functions of txscript
+// which resolves pay-to-pubkey and pay-to-pubkey-hash addresses to the
+// matching key among the passed ones.
+func signetKeyClosure(keys []*btcec.PrivateKey) txscript.KeyClosure {
+	return func(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+		for _, key := range keys {
+			compressed := key.PubKey().SerializeCompressed()
+			uncompressed := key.PubKey().SerializeUncompressed()
+
+			var want, wantUncompressed []byte
+			switch addr.(type) {
+			case *btcutil.AddressPubKey:
+				want, wantUncompressed = compressed, uncompressed
+
+			case *btcutil.AddressPubKeyHash:
+				want = btcutil.Hash160(compressed)
+				wantUncompressed = btcutil.Hash160(uncompressed)
+
+			default:
+				continue
+			}
+
+			switch {
+			case bytes.Equal(addr.ScriptAddress(), want):
+				return key, true, nil
+
+			case bytes.Equal(addr.ScriptAddress(), wantUncompressed):
+				return key, false, nil
+			}
+		}
+		return nil, false, errors.New("no signet key for address " +
+			addr.EncodeAddress())
+	}
+}
+
+// SignBlock signs the passed block for the signet defined by the passed
+// parameters with the passed keys and stores the solution in the witness
+// commitment of its coinbase as defined by BIP0325.  The merkle root of the
+// block is updated accordingly, so the block must be signed again whenever
+// its transactions, version, previous block or timestamp change.
+//
+// Bare pay-to-pubkey, pay-to-pubkey-hash and multisig challenges as well as
+// pay-to-witness-pubkey-hash challenges are signed.  Challenges of any other
+// kind get an empty solution, which is enough for challenges such as OP_TRUE.
+// In any case an error is returned if the resulting solution does not satisfy
+// the challenge.
+func SignBlock(msgBlock *wire.MsgBlock, params *chaincfg.Params, keys []*btcec.PrivateKey) error {
+	challenge := params.SignetChallenge
+	if challenge == nil {
+		return errors.New("network is not a signet")
+	}
+
+	// The solution signs the block with the push carrying it reduced to
+	// the signet header, so a placeholder replacing any previous solution
+	// has to be stored first.
+	err := blockchain.SetSignetSolution(msgBlock, nil, nil)
+	if err != nil {
+		return err
+	}
+	_, toSign, err := blockchain.CreateSignetTxs(msgBlock, challenge)
+	if err != nil {
+		return err
+	}
+	toSign.TxIn[0].SignatureScript = nil
+	toSign.TxIn[0].Witness = nil
+
+	var sigScript []byte
+	var witness wire.TxWitness
+	switch txscript.GetScriptClass(challenge) {
+	case txscript.PubKeyTy, txscript.PubKeyHashTy, txscript.MultiSigTy:
+		sigScript, err = txscript.SignTxOutput(params, toSign, 0,
+			challenge, txscript.SigHashAll, signetKeyClosure(keys),
+			nil, nil)
+		if err != nil {
+			return err
+		}
+
+	case txscript.WitnessV0PubKeyHashTy:
+		var key *btcec.PrivateKey
+		for _, k := range keys {
+			pkHash := btcutil.Hash160(k.PubKey().SerializeCompressed())
+			if bytes.Equal(pkHash, challenge[2:]) {
+				key = k
+				break
+			}
+		}
+		if key == nil {
+			return errors.New("no signet key for the challenge")
+		}
+
+		prevOutFetcher := txscript.NewCannedPrevOutputFetcher(challenge, 0)
+		sigHashes := txscript.NewTxSigHashes(toSign, prevOutFetcher)
+		witness, err = txscript.WitnessSignature(toSign, sigHashes, 0, 0,
+			challenge, txscript.SigHashAll, key, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = blockchain.SetSignetSolution(msgBlock, sigScript, witness)
+	if err != nil {
+		return err
+	}
+	return blockchain.CheckSignetSolution(msgBlock, challenge)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/btcacc"
 )
 
 const (
@@ -51,6 +52,20 @@ type TxDesc struct {
 
 	// FeePerKB is the fee the transaction pays in Satoshi per 1000 bytes.
 	FeePerKB int64
+
+	// FeeDelta is the amount added to the fee of the transaction to
+	// prioritise it when selecting transactions for inclusion in a block,
+	// as set with the prioritisetransaction RPC.  Only the actual fee is
+	// paid to the miner.
+	FeeDelta int64
+
+	// AncestorCount, AncestorSize and AncestorFee are the number of
+	// transactions, the total virtual size and the total fee including fee
+	// deltas of the transaction along with all of its unconfirmed ancestors
+	// in the source pool.
+	AncestorCount int64
+	AncestorSize  int64
+	AncestorFee   int64
 }
 
 // TxSource represents a source of transactions to consider for inclusion in
@@ -81,11 +96,29 @@ type txPrioItem struct {
 	priority float64
 	feePerKB int64
 
+	// modifiedFee is the fee of the transaction including its fee delta, and
+	// weight is its weight.
+	modifiedFee int64
+	weight      int64
+
+	// ancestorCount, ancestorFee and ancestorWeight are the number of
+	// ancestors of the transaction which haven't been included in the block
+	// yet, along with the modified fee and weight of the package made of
+	// the transaction and these ancestors.  They are only kept up to date
+	// once the block is prioritized by fees.
+	ancestorCount  int
+	ancestorFee    int64
+	ancestorWeight int64
+
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
 	// transactions in the source pool and hence must come after them in
 	// a block.
 	dependsOn map[chainhash.Hash]struct{}
+
+	// index is the index of the item in the priority queue it was last
+	// pushed onto, or -1 once it has been popped from it.
+	index int
 }
 
 // txPriorityQueueLessFunc describes a function that can be used as a compare
@@ -116,12 +149,16 @@ func (pq *txPriorityQueue) Less(i, j int) bool {
 // part of the heap.Interface implementation.
 func (pq *txPriorityQueue) Swap(i, j int) {
 	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 // Push pushes the passed item onto the priority queue.  It is part of the
 // heap.Interface implementation.
 func (pq *txPriorityQueue) Push(x interface{}) {
-	pq.items = append(pq.items, x.(*txPrioItem))
+	item := x.(*txPrioItem)
+	item.index = len(pq.items)
+	pq.items = append(pq.items, item)
 }
 
 // Pop removes the highest priority item (according to Less) from the priority
@@ -129,6 +166,7 @@ func (pq *txPriorityQueue) Push(x interface{}) {
 func (pq *txPriorityQueue) Pop() interface{} {
 	n := len(pq.items)
 	item := pq.items[n-1]
+	item.index = -1
 	pq.items[n-1] = nil
 	pq.items = pq.items[0 : n-1]
 	return item
@@ -165,6 +203,22 @@ func txPQByFee(pq *txPriorityQueue, i, j int) bool {
 	return pq.items[i].feePerKB > pq.items[j].feePerKB
 }
 
+// txPQByAncestorFee sorts a txPriorityQueue by the fees per kilobyte of the
+// packages made of the transactions and their ancestors which haven't been
+// included in the block yet, and then transaction priority.
+func txPQByAncestorFee(pq *txPriorityQueue, i, j int) bool {
+	// Using > here so that pop gives the highest fee item as opposed
+	// to the lowest.  The fee rates are compared without dividing, which
+	// could overflow when done with integers.
+	a, b := pq.items[i], pq.items[j]
+	feeRateA := float64(a.ancestorFee) * float64(b.ancestorWeight)
+	feeRateB := float64(b.ancestorFee) * float64(a.ancestorWeight)
+	if feeRateA == feeRateB {
+		return a.priority > b.priority
+	}
+	return feeRateA > feeRateB
+}
+
 // newTxPriorityQueue returns a new transaction priority queue that reserves the
 // passed amount of space for the elements.  The new priority queue uses either
 // the txPQByPriority or the txPQByFee compare function depending on the
@@ -212,11 +266,46 @@ type BlockTemplate struct {
 	// templates without a coinbase payment address.
 	ValidPayAddress bool
 
-	// WitnessCommitment is a commitment to the witness data (if any)
-	// within the block. This field will only be populted once segregated
-	// witness has been activated, and the block contains a transaction
+	// WitnessCommitment is the script of the coinbase output which commits
+	// to the witness data within the block, assuming a coinbase witness
+	// nonce of all zeroes.  This field will only be populated once
+	// segregated witness has been activated.  The coinbase of the template
+	// only carries the commitment when the block contains a transaction
 	// which has witness data.
 	WitnessCommitment []byte
+
+	// UData is the utreexo accumulator proof for the outputs spent by the
+	// block.  It is generated ahead of time by utreexo bridgenodes so that
+	// a solved block can be served to compact state nodes without waiting
+	// on proof generation, and is nil otherwise.
+	UData *btcacc.UData
+}
+
+// WitnessCommitmentScript returns the script of the coinbase output which
+// commits to the witness data of the passed transactions with a coinbase
+// witness nonce of all zeroes.  The first transaction is assumed to be the
+// coinbase, whose wtxid is defined to be all zeroes.
+func WitnessCommitmentScript(txns []*btcutil.Tx) []byte {
+	// Obtain the merkle root of a tree which consists of the wtxid of all
+	// transactions in the block.
+	witnessMerkleTree := blockchain.BuildMerkleTreeStore(txns, true)
+	witnessMerkleRoot := witnessMerkleTree[len(witnessMerkleTree)-1]
+
+	// The preimage to the witness commitment is:
+	// witnessRoot || coinbaseWitness
+	var witnessPreimage [64]byte
+	copy(witnessPreimage[:32], witnessMerkleRoot[:])
+
+	// The witness commitment itself is the double-sha256 of the witness
+	// preimage generated above.  With the commitment generated, the script
+	// for the output is: OP_RETURN OP_DATA_36 {0xaa21a9ed ||
+	// witnessCommitment}.  The leading prefix is referred to as the
+	// "witness magic bytes".
+	witnessCommitment := chainhash.DoubleHashB(witnessPreimage[:])
+	script := make([]byte, 0, len(blockchain.WitnessMagicBytes)+
+		len(witnessCommitment))
+	script = append(script, blockchain.WitnessMagicBytes...)
+	return append(script, witnessCommitment...)
 }
 
 // mergeUtxoView adds all of the entries in viewB to viewA.  The result is that
@@ -353,6 +442,7 @@ type BlkTmplGenerator struct {
 	timeSource  blockchain.MedianTimeSource
 	sigCache    *txscript.SigCache
 	hashCache   *txscript.HashCache
+	scriptCache *txscript.ScriptExecCache
 }
 
 // NewBlkTmplGenerator returns a new block template generator for the given
@@ -365,7 +455,8 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 	txSource TxSource, chain *blockchain.BlockChain,
 	timeSource blockchain.MedianTimeSource,
 	sigCache *txscript.SigCache,
-	hashCache *txscript.HashCache) *BlkTmplGenerator {
+	hashCache *txscript.HashCache,
+	scriptCache *txscript.ScriptExecCache) *BlkTmplGenerator {
 
 	return &BlkTmplGenerator{
 		policy:      policy,
@@ -375,6 +466,7 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 		timeSource:  timeSource,
 		sigCache:    sigCache,
 		hashCache:   hashCache,
+		scriptCache: scriptCache,
 	}
 }
 
@@ -395,24 +487,27 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 // higher fee per kilobyte are preferred.  Finally, the block generation related
 // policy settings are all taken into account.
 //
-// Transactions which only spend outputs from other transactions already in the
-// block chain are immediately added to a priority queue which either
-// prioritizes based on the priority (then fee per kilobyte) or the fee per
-// kilobyte (then priority) depending on whether or not the BlockPrioritySize
-// policy setting allots space for high-priority transactions.  Transactions
-// which spend outputs from other transactions in the source pool are added to a
-// dependency map so they can be added to the priority queue once the
-// transactions they depend on have been included.
+// When the BlockPrioritySize policy setting allots space for high-priority
+// transactions, transactions which only spend outputs from other transactions
+// already in the block chain are immediately added to a priority queue which
+// prioritizes based on the priority (then fee per kilobyte).  Transactions
+// which spend outputs from other transactions in the source pool are added to
+// the priority queue once the transactions they depend on have been included.
 //
 // Once the high-priority area (if configured) has been filled with
 // transactions, or the priority falls below what is considered high-priority,
-// the priority queue is updated to prioritize by fees per kilobyte (then
-// priority).
+// the remaining transactions are prioritized by the fees per kilobyte of the
+// packages they form with their ancestors which haven't been included yet (then
+// priority), and are included along with these ancestors.  This lets a
+// transaction paying a high fee get the transactions it depends on included
+// even though they pay a low fee (child pays for parent).  The fees used to
+// prioritize transactions include the fee deltas set with the
+// prioritisetransaction RPC.
 //
-// When the fees per kilobyte drop below the TxMinFreeFee policy setting, the
-// transaction will be skipped unless the BlockMinSize policy setting is
-// nonzero, in which case the block will be filled with the low-fee/free
-// transactions until the block size reaches that minimum size.
+// When the fees per kilobyte of the packages drop below the TxMinFreeFee
+// policy setting, the transaction will be skipped unless the BlockMinSize
+// policy setting is nonzero, in which case the block will be filled with the
+// low-fee/free transactions until the block size reaches that minimum size.
 //
 // Any transactions which would cause the block to exceed the BlockMaxSize
 // policy setting, exceed the maximum allowed signature operations per block, or
@@ -467,13 +562,15 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress btcutil.Address) (*Bloc
 
 	// Get the current source transactions and create a priority queue to
 	// hold the transactions which are ready for inclusion into a block
-	// along with some priority related and fee metadata.  Reserve the same
-	// number of items that are available for the priority queue.  Also,
-	// choose the initial sort order for the priority queue based on whether
-	// or not there is an area allocated for high-priority transactions.
+	// along with some priority related and fee metadata while there is an
+	// area allocated for high-priority transactions, and a package selector
+	// to choose the transactions to include along with their ancestors by
+	// fee afterwards.  Reserve the same number of items that are available
+	// for both of them.
 	sourceTxns := g.txSource.MiningDescs()
 	sortedByFee := g.policy.BlockPrioritySize == 0
-	priorityQueue := newTxPriorityQueue(len(sourceTxns), sortedByFee)
+	priorityQueue := newTxPriorityQueue(len(sourceTxns), false)
+	selector := newPackageSelector(len(sourceTxns))
 
 	// Create a slice to hold the transactions to be included in the
 	// generated block with reserved space.  Also create a utxo view to
@@ -483,13 +580,6 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress btcutil.Address) (*Bloc
 	blockTxns = append(blockTxns, coinbaseTx)
 	blockUtxos := blockchain.NewUtxoViewpoint()
 
-	// dependers is used to track transactions which depend on another
-	// transaction in the source pool.  This, in conjunction with the
-	// dependsOn map kept with each dependent transaction helps quickly
-	// determine which dependent transactions are now eligible for inclusion
-	// in the block once each transaction has been included.
-	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
-
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
 	// coinbase.  This allows the code below to simply append details about
@@ -535,7 +625,7 @@ mempoolLoop:
 		// Setup dependencies for any transactions which reference
 		// other transactions in the mempool so they can be properly
 		// ordered below.
-		prioItem := &txPrioItem{tx: tx}
+		prioItem := &txPrioItem{tx: tx, index: -1}
 		for _, txIn := range tx.MsgTx().TxIn {
 			originHash := &txIn.PreviousOutPoint.Hash
 			entry := utxos.LookupEntry(txIn.PreviousOutPoint)
@@ -551,12 +641,6 @@ mempoolLoop:
 				// The transaction is referencing another
 				// transaction in the source pool, so setup an
 				// ordering dependency.
-				deps, exists := dependers[*originHash]
-				if !exists {
-					deps = make(map[chainhash.Hash]*txPrioItem)
-					dependers[*originHash] = deps
-				}
-				deps[*prioItem.tx.Hash()] = prioItem
 				if prioItem.dependsOn == nil {
 					prioItem.dependsOn = make(
 						map[chainhash.Hash]struct{})
@@ -578,10 +662,14 @@ mempoolLoop:
 		// Calculate the fee in Satoshi/kB.
 		prioItem.feePerKB = txDesc.FeePerKB
 		prioItem.fee = txDesc.Fee
-
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
+		prioItem.modifiedFee = txDesc.Fee + txDesc.FeeDelta
+		prioItem.weight = blockchain.GetTransactionWeight(tx)
+
+		// Add the transaction to the package selector, and to the
+		// priority queue to mark it ready for inclusion in the
+		// high-priority area of the block unless it has dependencies.
+		selector.addItem(prioItem)
+		if !sortedByFee && prioItem.dependsOn == nil {
 			heap.Push(priorityQueue, prioItem)
 		}
 
@@ -592,7 +680,7 @@ mempoolLoop:
 	}
 
 	log.Tracef("Priority queue len %d, dependers len %d",
-		priorityQueue.Len(), len(dependers))
+		priorityQueue.Len(), len(selector.dependers))
 
 	// The starting block size is the size of the block header plus the max
 	// possible transaction count size, plus the size of the coinbase
@@ -614,18 +702,21 @@ mempoolLoop:
 
 	witnessIncluded := false
 
-	// Choose which transactions make it into the block.
-	for priorityQueue.Len() > 0 {
-		// Grab the highest priority (or highest fee per kilobyte
-		// depending on the sort order) transaction.
-		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+	// addTx adds the passed transaction to the block unless it would make
+	// the block invalid, in which case it returns false.  A transaction
+	// which can't be added can't be added later on either since the block
+	// only grows, so it is then removed from the package selector along
+	// with its descendants.  Otherwise, it returns the transactions which
+	// depend on it and don't have any other unsatisfied dependencies.
+	addTx := func(prioItem *txPrioItem) ([]*txPrioItem, bool) {
 		tx := prioItem.tx
 
 		switch {
 		// If segregated witness has not been activated yet, then we
 		// shouldn't include any witness transactions in the block.
 		case !segwitActive && tx.HasWitness():
-			continue
+			selector.failed(prioItem)
+			return nil, false
 
 		// Otherwise, Keep track of if we've included a transaction
 		// with witness data or not. If so, then we'll need to include
@@ -661,10 +752,10 @@ mempoolLoop:
 		}
 
 		// Grab any transactions which depend on this one.
-		deps := dependers[*tx.Hash()]
+		deps := selector.dependers[*tx.Hash()]
 
 		// Enforce maximum block size.  Also check for overflow.
-		txWeight := uint32(blockchain.GetTransactionWeight(tx))
+		txWeight := uint32(prioItem.weight)
 		blockPlusTxWeight := blockWeight + txWeight
 		if blockPlusTxWeight < blockWeight ||
 			blockPlusTxWeight >= g.policy.BlockMaxWeight {
@@ -672,7 +763,8 @@ mempoolLoop:
 			log.Tracef("Skipping tx %s because it would exceed "+
 				"the max block weight", tx.Hash())
 			logSkippedDeps(tx, deps)
-			continue
+			selector.failed(prioItem)
+			return nil, false
 		}
 
 		// Enforce maximum signature operation cost per block.  Also
@@ -683,58 +775,16 @@ mempoolLoop:
 			log.Tracef("Skipping tx %s due to error in "+
 				"GetSigOpCost: %v", tx.Hash(), err)
 			logSkippedDeps(tx, deps)
-			continue
+			selector.failed(prioItem)
+			return nil, false
 		}
 		if blockSigOpCost+int64(sigOpCost) < blockSigOpCost ||
 			blockSigOpCost+int64(sigOpCost) > blockchain.MaxBlockSigOpsCost {
 			log.Tracef("Skipping tx %s because it would "+
 				"exceed the maximum sigops per block", tx.Hash())
 			logSkippedDeps(tx, deps)
-			continue
-		}
-
-		// Skip free transactions once the block is larger than the
-		// minimum block size.
-		if sortedByFee &&
-			prioItem.feePerKB < int64(g.policy.TxMinFreeFee) &&
-			blockPlusTxWeight >= g.policy.BlockMinWeight {
-
-			log.Tracef("Skipping tx %s with feePerKB %d "+
-				"< TxMinFreeFee %d and block weight %d >= "+
-				"minBlockWeight %d", tx.Hash(), prioItem.feePerKB,
-				g.policy.TxMinFreeFee, blockPlusTxWeight,
-				g.policy.BlockMinWeight)
-			logSkippedDeps(tx, deps)
-			continue
-		}
-
-		// Prioritize by fee per kilobyte once the block is larger than
-		// the priority size or there are no more high-priority
-		// transactions.
-		if !sortedByFee && (blockPlusTxWeight >= g.policy.BlockPrioritySize ||
-			prioItem.priority <= MinHighPriority) {
-
-			log.Tracef("Switching to sort by fees per "+
-				"kilobyte blockSize %d >= BlockPrioritySize "+
-				"%d || priority %.2f <= minHighPriority %.2f",
-				blockPlusTxWeight, g.policy.BlockPrioritySize,
-				prioItem.priority, MinHighPriority)
-
-			sortedByFee = true
-			priorityQueue.SetLessFunc(txPQByFee)
-
-			// Put the transaction back into the priority queue and
-			// skip it so it is re-priortized by fees if it won't
-			// fit into the high-priority section or the priority
-			// is too low.  Otherwise this transaction will be the
-			// final one in the high-priority section, so just fall
-			// though to the code below so it is added now.
-			if blockPlusTxWeight > g.policy.BlockPrioritySize ||
-				prioItem.priority < MinHighPriority {
-
-				heap.Push(priorityQueue, prioItem)
-				continue
-			}
+			selector.failed(prioItem)
+			return nil, false
 		}
 
 		// Ensure the transaction inputs pass all of the necessary
@@ -745,16 +795,18 @@ mempoolLoop:
 			log.Tracef("Skipping tx %s due to error in "+
 				"CheckTransactionInputs: %v", tx.Hash(), err)
 			logSkippedDeps(tx, deps)
-			continue
+			selector.failed(prioItem)
+			return nil, false
 		}
 		err = blockchain.ValidateTransactionScripts(tx, blockUtxos,
 			txscript.StandardVerifyFlags, g.sigCache,
-			g.hashCache)
+			g.hashCache, g.scriptCache)
 		if err != nil {
 			log.Tracef("Skipping tx %s due to error in "+
 				"ValidateTransactionScripts: %v", tx.Hash(), err)
 			logSkippedDeps(tx, deps)
-			continue
+			selector.failed(prioItem)
+			return nil, false
 		}
 
 		// Spend the transaction inputs in the block utxo view and add
@@ -773,18 +825,98 @@ mempoolLoop:
 		txFees = append(txFees, prioItem.fee)
 		txSigOpCosts = append(txSigOpCosts, int64(sigOpCost))
 
-		log.Tracef("Adding tx %s (priority %.2f, feePerKB %.2f)",
+		log.Tracef("Adding tx %s (priority %.2f, feePerKB %d)",
 			prioItem.tx.Hash(), prioItem.priority, prioItem.feePerKB)
 
+		return selector.included(prioItem), true
+	}
+
+	// Choose which transactions make it into the high-priority area of the
+	// block, if any.
+	for !sortedByFee && priorityQueue.Len() > 0 {
+		// Grab the highest priority transaction.
+		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+
+		// Prioritize by fee per kilobyte once the block is larger than
+		// the priority size or there are no more high-priority
+		// transactions.
+		blockPlusTxWeight := blockWeight + uint32(prioItem.weight)
+		if blockPlusTxWeight >= g.policy.BlockPrioritySize ||
+			prioItem.priority <= MinHighPriority {
+
+			log.Tracef("Switching to sort by fees per "+
+				"kilobyte blockSize %d >= BlockPrioritySize "+
+				"%d || priority %.2f <= minHighPriority %.2f",
+				blockPlusTxWeight, g.policy.BlockPrioritySize,
+				prioItem.priority, MinHighPriority)
+
+			sortedByFee = true
+
+			// Leave the transaction to be selected by fees if it
+			// won't fit into the high-priority section or the
+			// priority is too low.  Otherwise this transaction will
+			// be the final one in the high-priority section, so
+			// just fall though to the code below so it is added
+			// now.
+			if blockPlusTxWeight > g.policy.BlockPrioritySize ||
+				prioItem.priority < MinHighPriority {
+
+				break
+			}
+		}
+
 		// Add transactions which depend on this one (and also do not
 		// have any other unsatisified dependencies) to the priority
 		// queue.
+		deps, ok := addTx(prioItem)
+		if !ok {
+			continue
+		}
 		for _, item := range deps {
-			// Add the transaction to the priority queue if there
-			// are no more dependencies after this one.
-			delete(item.dependsOn, *tx.Hash())
-			if len(item.dependsOn) == 0 {
-				heap.Push(priorityQueue, item)
+			heap.Push(priorityQueue, item)
+		}
+	}
+
+	// Choose which of the remaining transactions make it into the block
+	// along with their ancestors by the fee per kilobyte of these packages.
+	selector.start()
+	for pkg := selector.next(); pkg != nil; pkg = selector.next() {
+		prioItem := pkg[len(pkg)-1]
+		tx := prioItem.tx
+
+		// Skip packages which would exceed the maximum block size.
+		// Their transactions may still be included in smaller packages.
+		// Also check for overflow.
+		blockPlusPkgWeight := int64(blockWeight) + prioItem.ancestorWeight
+		if blockPlusPkgWeight >= int64(g.policy.BlockMaxWeight) {
+			log.Tracef("Skipping tx %s because it would exceed "+
+				"the max block weight along with its %d "+
+				"ancestors", tx.Hash(), prioItem.ancestorCount)
+			continue
+		}
+
+		// Skip free packages once the block is larger than the minimum
+		// block size.
+		feePerKB := ancestorFeePerKB(prioItem)
+		if feePerKB < int64(g.policy.TxMinFreeFee) &&
+			blockPlusPkgWeight >= int64(g.policy.BlockMinWeight) {
+
+			log.Tracef("Skipping tx %s with ancestor feePerKB %d "+
+				"< TxMinFreeFee %d and block weight %d >= "+
+				"minBlockWeight %d", tx.Hash(), feePerKB,
+				g.policy.TxMinFreeFee, blockPlusPkgWeight,
+				g.policy.BlockMinWeight)
+			continue
+		}
+
+		// Add the transactions of the package, each after its
+		// ancestors.  Should one of them fail to be added, the
+		// transactions of the package depending on it can't be added
+		// either, while the ones which have already been added are
+		// valid on their own.
+		for _, item := range pkg {
+			if _, ok := addTx(item); !ok {
+				break
 			}
 		}
 	}
@@ -798,42 +930,28 @@ mempoolLoop:
 	coinbaseTx.MsgTx().TxOut[0].Value += totalFees
 	txFees[0] = -totalFees
 
-	// If segwit is active and we included transactions with witness data,
-	// then we'll need to include a commitment to the witness data in an
-	// OP_RETURN output within the coinbase transaction.
+	// Once segwit is active, calculate the witness commitment of the block
+	// so it can be handed to miners which build their own coinbase.  If we
+	// included transactions with witness data, then we'll also need to
+	// include the commitment in an OP_RETURN output within the coinbase
+	// transaction.  Signet blocks always need one since it carries their
+	// signature.
 	var witnessCommitment []byte
-	if witnessIncluded {
+	isSignet := g.chainParams.SignetChallenge != nil
+	if segwitActive || isSignet {
+		witnessCommitment = WitnessCommitmentScript(blockTxns)
+	}
+	if witnessIncluded || isSignet {
 		// The witness of the coinbase transaction MUST be exactly 32-bytes
 		// of all zeroes.
 		var witnessNonce [blockchain.CoinbaseWitnessDataLen]byte
 		coinbaseTx.MsgTx().TxIn[0].Witness = wire.TxWitness{witnessNonce[:]}
 
-		// Next, obtain the merkle root of a tree which consists of the
-		// wtxid of all transactions in the block. The coinbase
-		// transaction will have a special wtxid of all zeroes.
-		witnessMerkleTree := blockchain.BuildMerkleTreeStore(blockTxns,
-			true)
-		witnessMerkleRoot := witnessMerkleTree[len(witnessMerkleTree)-1]
-
-		// The preimage to the witness commitment is:
-		// witnessRoot || coinbaseWitness
-		var witnessPreimage [64]byte
-		copy(witnessPreimage[:32], witnessMerkleRoot[:])
-		copy(witnessPreimage[32:], witnessNonce[:])
-
-		// The witness commitment itself is the double-sha256 of the
-		// witness preimage generated above. With the commitment
-		// generated, the witness script for the output is: OP_RETURN
-		// OP_DATA_36 {0xaa21a9ed || witnessCommitment}. The leading
-		// prefix is referred to as the "witness magic bytes".
-		witnessCommitment = chainhash.DoubleHashB(witnessPreimage[:])
-		witnessScript := append(blockchain.WitnessMagicBytes, witnessCommitment...)
-
 		// Finally, create the OP_RETURN carrying witness commitment
 		// output as an additional output within the coinbase.
 		commitmentOutput := &wire.TxOut{
 			Value:    0,
-			PkScript: witnessScript,
+			PkScript: witnessCommitment,
 		}
 		coinbaseTx.MsgTx().TxOut = append(coinbaseTx.MsgTx().TxOut,
 			commitmentOutput)
@@ -873,13 +991,27 @@ mempoolLoop:
 
 	// Finally, perform a full check on the created block against the chain
 	// consensus rules to ensure it properly connects to the current best
-	// chain with no issues.
+	// chain with no issues.  The signet solution is added by the signer once
+	// the template has been created, so it can't be checked here.
 	block := btcutil.NewBlock(&msgBlock)
 	block.SetHeight(nextBlockHeight)
-	if err := g.chain.CheckConnectBlockTemplate(block); err != nil {
+	err = g.chain.CheckConnectBlockTemplate(block, blockchain.BFNoSignetCheck)
+	if err != nil {
 		return nil, err
 	}
 
+	// Generate the accumulator proof for the outputs spent by the block
+	// now when this is a utreexo bridgenode rather than once it is mined.
+	// This is only an optimization, so the template is still returned
+	// without a proof when it can't be generated, such as when the blocks
+	// connected before it are still pending script validation.
+	ud, err := g.chain.PrecomputeUData(block, blockUtxos)
+	if err != nil {
+		log.Debugf("Unable to precompute the accumulator proof of the "+
+			"block template: %v", err)
+		ud = nil
+	}
+
 	log.Debugf("Created new block template (%d transactions, %d in "+
 		"fees, %d signature operations cost, %d weight, target difficulty "+
 		"%064x)", len(msgBlock.Transactions), totalFees, blockSigOpCost,
@@ -892,6 +1024,7 @@ mempoolLoop:
 		Height:            nextBlockHeight,
 		ValidPayAddress:   payToAddress != nil,
 		WitnessCommitment: witnessCommitment,
+		UData:             ud,
 	}, nil
 }
 
@@ -958,6 +1091,19 @@ func (g *BlkTmplGenerator) BestSnapshot() *blockchain.BestState {
 	return g.chain.BestSnapshot()
 }
 
+// AddPrecomputedUData remembers the passed utreexo accumulator proof of a block
+// template building on the block with the passed hash, so that it is not
+// generated again when a block solved from the template is connected and can
+// be served to utreexo compact state nodes right away.  It is typically called
+// with the UData of the template before submitting the solved block.
+//
+// This function is safe for concurrent access.
+func (g *BlkTmplGenerator) AddPrecomputedUData(prevHash *chainhash.Hash,
+	ud *btcacc.UData) {
+
+	g.chain.AddPrecomputedUData(prevHash, ud)
+}
+
 // TxSource returns the associated transaction source.
 //
 // This function is safe for concurrent access.
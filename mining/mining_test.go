@@ -5,10 +5,15 @@
 package mining
 
 import (
+	"bytes"
 	"container/heap"
 	"math/rand"
 	"testing"
 
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 )
 
@@ -108,3 +113,67 @@ func TestTxFeePrioHeap(t *testing.T) {
 		highest = prioItem
 	}
 }
+
+// TestWitnessCommitmentScript ensures the witness commitment script commits to
+// the witness data of the transactions of a block whose coinbase carries it
+// along with a witness nonce of all zeroes.
+func TestWitnessCommitmentScript(t *testing.T) {
+	t.Parallel()
+
+	coinbaseScript, err := standardCoinbaseScript(1, 0)
+	if err != nil {
+		t.Fatalf("unable to create coinbase script: %v", err)
+	}
+	coinbase, err := createCoinbaseTx(&chaincfg.RegressionNetParams,
+		coinbaseScript, 1, nil)
+	if err != nil {
+		t.Fatalf("unable to create coinbase: %v", err)
+	}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		Witness:          wire.TxWitness{{0x01, 0x02}},
+	})
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	txns := []*btcutil.Tx{coinbase, btcutil.NewTx(msgTx)}
+
+	script := WitnessCommitmentScript(txns)
+	if len(script) != blockchain.CoinbaseWitnessPkScriptLength ||
+		!bytes.HasPrefix(script, blockchain.WitnessMagicBytes) {
+
+		t.Fatalf("got malformed witness commitment script %x", script)
+	}
+
+	// The coinbase of the block carries the commitment along with the
+	// witness nonce.
+	var witnessNonce [blockchain.CoinbaseWitnessDataLen]byte
+	coinbase.MsgTx().TxIn[0].Witness = wire.TxWitness{witnessNonce[:]}
+	coinbase.MsgTx().AddTxOut(wire.NewTxOut(0, script))
+	block := &wire.MsgBlock{Transactions: []*wire.MsgTx{coinbase.MsgTx(),
+		msgTx}}
+	err = blockchain.ValidateWitnessCommitment(btcutil.NewBlock(block))
+	if err != nil {
+		t.Fatalf("witness commitment rejected: %v", err)
+	}
+
+	// The commitment doesn't hold once the witness data changes, while it
+	// doesn't depend on the coinbase, whose wtxid is all zeroes.
+	msgTx.TxIn[0].Witness = wire.TxWitness{{0x01, 0x03}}
+	err = blockchain.ValidateWitnessCommitment(btcutil.NewBlock(block))
+	if err == nil {
+		t.Fatal("witness commitment accepted for other witness data")
+	}
+	coinbase.MsgTx().TxIn[0].SignatureScript = []byte{0x51, 0x51}
+	changed := WitnessCommitmentScript([]*btcutil.Tx{
+		btcutil.NewTx(coinbase.MsgTx()), btcutil.NewTx(msgTx)})
+	if bytes.Equal(changed, script) {
+		t.Fatal("witness commitment script doesn't depend on the " +
+			"witness data")
+	}
+	msgTx.TxIn[0].Witness = wire.TxWitness{{0x01, 0x02}}
+	same := WitnessCommitmentScript([]*btcutil.Tx{
+		btcutil.NewTx(coinbase.MsgTx()), btcutil.NewTx(msgTx)})
+	if !bytes.Equal(same, script) {
+		t.Fatal("witness commitment script depends on the coinbase")
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2014-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// packageSelector selects the transactions to include in a block by ancestor
+// fee rate, which is the fee rate of the package made of a transaction along
+// with its ancestors which haven't been included in the block yet.  This lets
+// a transaction paying a high fee get the ancestors paying a low fee it depends
+// on included along with it (child pays for parent), while a transaction
+// paying a high fee which depends on ancestors paying a low fee is only
+// included once the package as a whole is worth it.
+type packageSelector struct {
+	// items holds the transactions which can still be included in the
+	// block, which excludes the ones which have already been included and
+	// the ones which can't be included.
+	items map[chainhash.Hash]*txPrioItem
+
+	// dependers maps each transaction to the transactions in the source
+	// pool which spend its outputs.
+	dependers map[chainhash.Hash]map[chainhash.Hash]*txPrioItem
+
+	// queue holds the transactions sorted by ancestor fee rate once the
+	// selection has been started.
+	queue *txPriorityQueue
+}
+
+// newPackageSelector returns a new package selector with room for the passed
+// number of transactions.  Transactions are added to it along with their
+// dependencies before the selection is started.
+func newPackageSelector(reserve int) *packageSelector {
+	return &packageSelector{
+		items:     make(map[chainhash.Hash]*txPrioItem, reserve),
+		dependers: make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem),
+	}
+}
+
+// addItem adds the passed transaction, which spends the outputs of the
+// transactions in the source pool referenced by its dependsOn map, to the
+// transactions to select from.
+func (s *packageSelector) addItem(item *txPrioItem) {
+	hash := *item.tx.Hash()
+	s.items[hash] = item
+	for originHash := range item.dependsOn {
+		deps, exists := s.dependers[originHash]
+		if !exists {
+			deps = make(map[chainhash.Hash]*txPrioItem)
+			s.dependers[originHash] = deps
+		}
+		deps[hash] = item
+	}
+}
+
+// ancestors returns the ancestors of the passed transaction which haven't been
+// included in the block yet.  It returns false when any of them can't be
+// included, in which case neither can the transaction.
+func (s *packageSelector) ancestors(item *txPrioItem) (map[chainhash.Hash]*txPrioItem, bool) {
+	ancestors := make(map[chainhash.Hash]*txPrioItem)
+	stack := []*txPrioItem{item}
+	for len(stack) > 0 {
+		item := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for hash := range item.dependsOn {
+			if _, exists := ancestors[hash]; exists {
+				continue
+			}
+			ancestor, exists := s.items[hash]
+			if !exists {
+				return nil, false
+			}
+			ancestors[hash] = ancestor
+			stack = append(stack, ancestor)
+		}
+	}
+	return ancestors, true
+}
+
+// descendants returns the descendants of the passed transaction which can still
+// be included in the block.
+func (s *packageSelector) descendants(item *txPrioItem) map[chainhash.Hash]*txPrioItem {
+	descendants := make(map[chainhash.Hash]*txPrioItem)
+	stack := []*txPrioItem{item}
+	for len(stack) > 0 {
+		item := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for hash := range s.dependers[*item.tx.Hash()] {
+			if _, exists := descendants[hash]; exists {
+				continue
+			}
+			descendant, exists := s.items[hash]
+			if !exists {
+				continue
+			}
+			descendants[hash] = descendant
+			stack = append(stack, descendant)
+		}
+	}
+	return descendants
+}
+
+// calcAncestorStats sets the number of ancestors along with the fee and weight
+// of the package made of the passed transaction and its ancestors which haven't
+// been included in the block yet.  It returns false when the transaction can't
+// be included.
+func (s *packageSelector) calcAncestorStats(item *txPrioItem) bool {
+	ancestors, ok := s.ancestors(item)
+	if !ok {
+		return false
+	}
+	item.ancestorCount = len(ancestors)
+	item.ancestorFee = item.modifiedFee
+	item.ancestorWeight = item.weight
+	for _, ancestor := range ancestors {
+		item.ancestorFee += ancestor.modifiedFee
+		item.ancestorWeight += ancestor.weight
+	}
+	return true
+}
+
+// start sorts the transactions to select from by ancestor fee rate.  The
+// transactions which already have been included in the block, or which can't
+// be included, MUST have been removed with included and failed beforehand.
+func (s *packageSelector) start() {
+	s.queue = &txPriorityQueue{
+		items: make([]*txPrioItem, 0, len(s.items)),
+	}
+	s.queue.SetLessFunc(txPQByAncestorFee)
+	for hash, item := range s.items {
+		if !s.calcAncestorStats(item) {
+			delete(s.items, hash)
+			continue
+		}
+		heap.Push(s.queue, item)
+	}
+}
+
+// next returns the package made of the transaction with the highest ancestor
+// fee rate along with its ancestors which haven't been included in the block
+// yet, sorted so that each transaction comes after its ancestors, or nil once
+// there are no more transactions to select.  The transaction is considered
+// again when the package changes because some of these ancestors are included
+// on their own.
+func (s *packageSelector) next() []*txPrioItem {
+	for s.queue.Len() > 0 {
+		item := heap.Pop(s.queue).(*txPrioItem)
+		ancestors, ok := s.ancestors(item)
+		if !ok {
+			log.Tracef("Skipping tx %s because it depends on a "+
+				"transaction which can't be included",
+				item.tx.Hash())
+			delete(s.items, *item.tx.Hash())
+			continue
+		}
+
+		// A transaction always has more ancestors than any of its own
+		// ancestors.
+		pkg := make([]*txPrioItem, 0, len(ancestors)+1)
+		for _, ancestor := range ancestors {
+			pkg = append(pkg, ancestor)
+		}
+		sort.Slice(pkg, func(i, j int) bool {
+			return pkg[i].ancestorCount < pkg[j].ancestorCount
+		})
+		return append(pkg, item)
+	}
+	return nil
+}
+
+// included removes the passed transaction, which has been included in the
+// block, from the transactions to select from and from the dependencies of the
+// transactions spending it.  It returns these transactions which are left
+// without any dependencies, and updates the ancestor fee rates of the
+// descendants of the transaction once the selection has been started.
+func (s *packageSelector) included(item *txPrioItem) []*txPrioItem {
+	hash := *item.tx.Hash()
+	s.remove(item)
+
+	var ready []*txPrioItem
+	for depHash, dep := range s.dependers[hash] {
+		delete(dep.dependsOn, hash)
+		if _, exists := s.items[depHash]; exists && len(dep.dependsOn) == 0 {
+			ready = append(ready, dep)
+		}
+	}
+	if s.queue == nil {
+		return ready
+	}
+
+	for _, descendant := range s.descendants(item) {
+		if !s.calcAncestorStats(descendant) {
+			continue
+		}
+		if descendant.index >= 0 {
+			heap.Fix(s.queue, descendant.index)
+		} else {
+			heap.Push(s.queue, descendant)
+		}
+	}
+	return ready
+}
+
+// failed removes the passed transaction, which can't be included in the block,
+// from the transactions to select from.  Its descendants can't be included
+// either.
+func (s *packageSelector) failed(item *txPrioItem) {
+	s.remove(item)
+}
+
+// remove removes the passed transaction from the transactions to select from.
+func (s *packageSelector) remove(item *txPrioItem) {
+	delete(s.items, *item.tx.Hash())
+	if s.queue != nil && item.index >= 0 {
+		heap.Remove(s.queue, item.index)
+	}
+}
+
+// ancestorFeePerKB returns the fee in Satoshi per 1000 virtual bytes the
+// package made of the passed transaction and its ancestors which haven't been
+// included in the block yet pays.
+func ancestorFeePerKB(item *txPrioItem) int64 {
+	return item.ancestorFee * 1000 * blockchain.WitnessScaleFactor /
+		item.ancestorWeight
+}
+
+// TopTxns returns the hashes of the passed transactions which fit in the first
+// maxWeight weight units of a block template, picking them along with their
+// ancestors by ancestor fee rate the same way the fee-sorted part of templates
+// is filled.  Transactions spending outputs of transactions which aren't among
+// the passed ones are assumed to spend confirmed outputs, and nothing is
+// validated against the chain, so it is much cheaper than generating a
+// template when only the ranking of the transactions is of interest.
+func TopTxns(descs []*TxDesc, maxWeight int64) map[chainhash.Hash]struct{} {
+	known := make(map[chainhash.Hash]struct{}, len(descs))
+	for _, txDesc := range descs {
+		known[*txDesc.Tx.Hash()] = struct{}{}
+	}
+
+	selector := newPackageSelector(len(descs))
+	for _, txDesc := range descs {
+		prioItem := &txPrioItem{
+			tx:          txDesc.Tx,
+			fee:         txDesc.Fee,
+			feePerKB:    txDesc.FeePerKB,
+			modifiedFee: txDesc.Fee + txDesc.FeeDelta,
+			weight:      blockchain.GetTransactionWeight(txDesc.Tx),
+			index:       -1,
+		}
+		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
+			originHash := txIn.PreviousOutPoint.Hash
+			if _, ok := known[originHash]; !ok {
+				continue
+			}
+			if prioItem.dependsOn == nil {
+				prioItem.dependsOn = make(map[chainhash.Hash]struct{})
+			}
+			prioItem.dependsOn[originHash] = struct{}{}
+		}
+		selector.addItem(prioItem)
+	}
+
+	top := make(map[chainhash.Hash]struct{})
+	var weight int64
+	selector.start()
+	for pkg := selector.next(); pkg != nil; pkg = selector.next() {
+		// Skip packages which don't fit.  Their transactions may still
+		// be picked in smaller packages.
+		if weight+pkg[len(pkg)-1].ancestorWeight > maxWeight {
+			continue
+		}
+		for _, prioItem := range pkg {
+			weight += prioItem.weight
+			top[*prioItem.tx.Hash()] = struct{}{}
+			selector.included(prioItem)
+		}
+	}
+	return top
+}
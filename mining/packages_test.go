@@ -0,0 +1,446 @@
+// Copyright (c) 2014-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// newTestPrioItem returns a new priority item for a unique transaction with the
+// passed weight paying the passed fee, which spends outputs of the passed
+// parents.
+func newTestPrioItem(id uint32, weight, fee int64, parents ...*txPrioItem) *txPrioItem {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.LockTime = id
+	item := &txPrioItem{
+		tx:          btcutil.NewTx(msgTx),
+		fee:         fee,
+		feePerKB:    fee * 1000 * 4 / weight,
+		modifiedFee: fee,
+		weight:      weight,
+		index:       -1,
+	}
+	for _, parent := range parents {
+		if item.dependsOn == nil {
+			item.dependsOn = make(map[chainhash.Hash]struct{})
+		}
+		item.dependsOn[*parent.tx.Hash()] = struct{}{}
+	}
+	return item
+}
+
+// fakeTxSource is a transaction source holding the passed mining descriptors.
+type fakeTxSource struct {
+	descs []*TxDesc
+}
+
+// LastUpdated returns the zero time.  It is part of the TxSource interface.
+func (s *fakeTxSource) LastUpdated() time.Time {
+	return time.Time{}
+}
+
+// MiningDescs returns the mining descriptors of the source.  It is part of the
+// TxSource interface.
+func (s *fakeTxSource) MiningDescs() []*TxDesc {
+	return s.descs
+}
+
+// HaveTransaction returns whether the source holds the transaction with the
+// passed hash.  It is part of the TxSource interface.
+func (s *fakeTxSource) HaveTransaction(hash *chainhash.Hash) bool {
+	for _, desc := range s.descs {
+		if desc.Tx.Hash().IsEqual(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// addDesc adds a mining descriptor for the passed transaction paying the passed
+// fee to the source.  The ancestor stats are left for the generator to compute.
+func (s *fakeTxSource) addDesc(tx *btcutil.Tx, fee int64) {
+	vsize := blockchain.GetTransactionWeight(tx) /
+		blockchain.WitnessScaleFactor
+	s.descs = append(s.descs, &TxDesc{
+		Tx:       tx,
+		Fee:      fee,
+		FeePerKB: fee * 1000 / vsize,
+	})
+}
+
+// newTestGenerator returns a block template generator over a regression test
+// chain whose coinbases mature after a block, which draws its transactions
+// from the returned source, along with a function to tear it down.
+func newTestGenerator(t *testing.T) (*BlkTmplGenerator, *fakeTxSource, func()) {
+	t.Helper()
+
+	dataDir, err := ioutil.TempDir("", "mining")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	params := chaincfg.RegressionNetParams
+	params.CoinbaseMaturity = 1
+	db, err := database.Create("ffldb", filepath.Join(dataDir, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dataDir)
+	}
+
+	timeSource := blockchain.NewMedianTime()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:               db,
+		UtxoCacheMaxSize: 10 * 1024 * 1024,
+		ChainParams:      &params,
+		TimeSource:       timeSource,
+		DataDir:          dataDir,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	policy := &Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize,
+		TxMinFreeFee:   1000,
+	}
+	source := &fakeTxSource{}
+	g := NewBlkTmplGenerator(policy, &params, source, chain, timeSource,
+		txscript.NewSigCache(100), txscript.NewHashCache(100),
+		txscript.NewScriptExecCache(100))
+	return g, source, teardown
+}
+
+// mineTestTemplate solves a template of the passed generator and connects it
+// to the chain, and returns the template.
+func mineTestTemplate(t *testing.T, g *BlkTmplGenerator) *BlockTemplate {
+	t.Helper()
+
+	template, err := g.NewBlockTemplate(nil)
+	if err != nil {
+		t.Fatalf("unable to create block template: %v", err)
+	}
+	header := &template.Block.Header
+	for blockchain.CheckHeaderProofOfWork(header,
+		g.chainParams.PowLimit) != nil {
+
+		header.Nonce++
+	}
+	_, _, err = g.chain.ProcessBlock(btcutil.NewBlock(template.Block),
+		blockchain.BFNone)
+	if err != nil {
+		t.Fatalf("unable to process block: %v", err)
+	}
+	return template
+}
+
+// newTestSpend returns a transaction spending the passed output, paying to an
+// anyone-can-spend script, which is unique thanks to the passed id.
+func newTestSpend(prevOut wire.OutPoint, value int64, id uint32) *btcutil.Tx {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(value, []byte{txscript.OP_TRUE}))
+	msgTx.LockTime = id
+	return btcutil.NewTx(msgTx)
+}
+
+// TestPackageSelection ensures block templates include transactions along with
+// their ancestors by ancestor fee rate, so children paying for their parents
+// get them included ahead of transactions paying more than the parents alone,
+// and that the fees of the template are the ones actually paid.
+func TestPackageSelection(t *testing.T) {
+	t.Parallel()
+
+	g, source, teardown := newTestGenerator(t)
+	defer teardown()
+
+	// Fund 60 outputs from the coinbase of the first block.
+	const numParents, numOthers = 20, 40
+	const parentFee, childFee, otherFee = 200, 10000, 2000
+	const value = 50000000
+	coinbase := mineTestTemplate(t, g).Block.Transactions[0]
+	coinbaseHash := coinbase.TxHash()
+	funding := wire.NewMsgTx(wire.TxVersion)
+	funding.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&coinbaseHash, 0), nil,
+		nil))
+	for i := 0; i < numParents+numOthers; i++ {
+		funding.AddTxOut(wire.NewTxOut(value, []byte{txscript.OP_TRUE}))
+	}
+	fundingFee := coinbase.TxOut[0].Value -
+		(numParents+numOthers)*value
+	source.addDesc(btcutil.NewTx(funding), fundingFee)
+	template := mineTestTemplate(t, g)
+	if len(template.Block.Transactions) != 2 {
+		t.Fatalf("funding transaction not mined")
+	}
+	if template.Fees[0] != -fundingFee || template.Fees[1] != fundingFee {
+		t.Fatalf("got fees %v, want %d for the funding transaction",
+			template.Fees, fundingFee)
+	}
+
+	// Each parent pays a low fee while its child pays for both of them,
+	// and the other transactions pay more than the parents on their own.
+	source.descs = nil
+	fundingHash := funding.TxHash()
+	var parents, children, others []*btcutil.Tx
+	for i := uint32(0); i < numParents+numOthers; i++ {
+		prevOut := wire.OutPoint{Hash: fundingHash, Index: i}
+		if i >= numParents {
+			other := newTestSpend(prevOut, value-otherFee, i)
+			source.addDesc(other, otherFee)
+			others = append(others, other)
+			continue
+		}
+		parent := newTestSpend(prevOut, value-parentFee, i)
+		child := newTestSpend(wire.OutPoint{Hash: *parent.Hash()},
+			value-parentFee-childFee, i)
+		source.addDesc(parent, parentFee)
+		source.addDesc(child, childFee)
+		parents = append(parents, parent)
+		children = append(children, child)
+	}
+
+	// Leave room for 40 of the 80 transactions, which all have the same
+	// weight.
+	nextHeight := g.BestSnapshot().Height + 1
+	coinbaseScript, err := standardCoinbaseScript(nextHeight, 0)
+	if err != nil {
+		t.Fatalf("unable to create coinbase script: %v", err)
+	}
+	templateCoinbase, err := createCoinbaseTx(g.chainParams,
+		coinbaseScript, nextHeight, nil)
+	if err != nil {
+		t.Fatalf("unable to create coinbase: %v", err)
+	}
+	txWeight := blockchain.GetTransactionWeight(parents[0])
+	g.policy.BlockMaxWeight = uint32(blockHeaderOverhead*
+		blockchain.WitnessScaleFactor +
+		blockchain.GetTransactionWeight(templateCoinbase) +
+		40*txWeight + 1)
+
+	// All of the packages pay more than the other transactions, so they
+	// fill the whole block with each parent coming before its child.
+	// Selecting the transactions by their own fee rate would only have
+	// collected the fees of the other transactions.
+	template, err = g.NewBlockTemplate(nil)
+	if err != nil {
+		t.Fatalf("unable to create block template: %v", err)
+	}
+	wantFees := int64(numParents * (parentFee + childFee))
+	if wantFees <= numOthers*otherFee {
+		t.Fatalf("packages pay %d, not more than the %d paid by the "+
+			"other transactions", wantFees, numOthers*otherFee)
+	}
+	checkTemplateFees(t, template, wantFees)
+	if len(template.Block.Transactions) != 41 {
+		t.Fatalf("got %d transactions, want 41",
+			len(template.Block.Transactions))
+	}
+	positions := make(map[chainhash.Hash]int)
+	for i, msgTx := range template.Block.Transactions {
+		positions[msgTx.TxHash()] = i
+	}
+	for i := range parents {
+		parentPos, ok := positions[*parents[i].Hash()]
+		if !ok {
+			t.Fatalf("parent %d not included", i)
+		}
+		childPos, ok := positions[*children[i].Hash()]
+		if !ok || childPos < parentPos {
+			t.Fatalf("child %d not included after its parent", i)
+		}
+	}
+
+	// A fee delta making the other transactions pay more than the
+	// packages gets them included instead, while the fees of the template
+	// remain the ones actually paid.
+	for _, desc := range source.descs {
+		if desc.Fee == otherFee {
+			desc.FeeDelta = 20000
+		}
+	}
+	template, err = g.NewBlockTemplate(nil)
+	if err != nil {
+		t.Fatalf("unable to create block template: %v", err)
+	}
+	checkTemplateFees(t, template, numOthers*otherFee)
+	for i, other := range others {
+		if _, ok := positions[*other.Hash()]; ok {
+			t.Fatalf("other transaction %d included in the first "+
+				"template", i)
+		}
+	}
+	for _, msgTx := range template.Block.Transactions[1:] {
+		if msgTx.TxIn[0].PreviousOutPoint.Index < numParents ||
+			msgTx.TxIn[0].PreviousOutPoint.Hash != fundingHash {
+
+			t.Fatalf("got transaction %v, want only the other "+
+				"transactions", msgTx.TxHash())
+		}
+	}
+}
+
+// checkTemplateFees ensures the fees of the transactions of the passed
+// template add up to the passed total, which the coinbase collects.
+func checkTemplateFees(t *testing.T, template *BlockTemplate, want int64) {
+	t.Helper()
+
+	if len(template.Fees) != len(template.Block.Transactions) {
+		t.Fatalf("got %d fees for %d transactions", len(template.Fees),
+			len(template.Block.Transactions))
+	}
+	var total int64
+	for _, fee := range template.Fees[1:] {
+		total += fee
+	}
+	if total != want || template.Fees[0] != -want {
+		t.Fatalf("got fees %d and coinbase fee %d, want %d", total,
+			-template.Fees[0], want)
+	}
+	subsidy := blockchain.CalcBlockSubsidy(template.Height,
+		&chaincfg.RegressionNetParams)
+	if got := template.Block.Transactions[0].TxOut[0].Value; got != subsidy+want {
+		t.Fatalf("got coinbase value %d, want %d", got, subsidy+want)
+	}
+}
+
+// TestPackageSelectorUpdate ensures the ancestor fee rates of the transactions
+// are updated as their ancestors are included, and that transactions depending
+// on ones which can't be included are never selected.
+func TestPackageSelectorUpdate(t *testing.T) {
+	t.Parallel()
+
+	// The child pays for its parent and grandparent, which pay a low fee.
+	// The orphan depends on a transaction which fails.
+	grandparent := newTestPrioItem(0, 400, 100)
+	parent := newTestPrioItem(1, 400, 100, grandparent)
+	child := newTestPrioItem(2, 400, 5000, parent)
+	other := newTestPrioItem(3, 400, 1000)
+	failing := newTestPrioItem(4, 400, 8000)
+	orphan := newTestPrioItem(5, 400, 7000, failing)
+
+	s := newPackageSelector(6)
+	for _, item := range []*txPrioItem{grandparent, parent, child, other,
+		failing, orphan} {
+
+		s.addItem(item)
+	}
+	s.start()
+
+	// The failing transaction has the highest ancestor fee rate and fails,
+	// so the orphan is skipped.
+	pkg := s.next()
+	if len(pkg) != 1 || pkg[0] != failing {
+		t.Fatalf("got package of %d txns, want the failing tx", len(pkg))
+	}
+	s.failed(failing)
+
+	// The child comes next along with its ancestors as the package pays
+	// 5200 for 1200 weight, more than the 1000 for 400 weight of the other
+	// transaction.
+	pkg = s.next()
+	if len(pkg) != 3 || pkg[0] != grandparent || pkg[1] != parent ||
+		pkg[2] != child {
+
+		t.Fatalf("got package of %d txns, want the child along with "+
+			"its ancestors", len(pkg))
+	}
+
+	// Including the grandparent alone leaves the child with a package
+	// paying 5100 for 800 weight, which is selected again.
+	s.included(grandparent)
+	if child.ancestorFee != 5100 || child.ancestorWeight != 800 ||
+		child.ancestorCount != 1 {
+
+		t.Fatalf("got child ancestor fee %d, weight %d and count %d, "+
+			"want 5100, 800 and 1", child.ancestorFee,
+			child.ancestorWeight, child.ancestorCount)
+	}
+	pkg = s.next()
+	if len(pkg) != 2 || pkg[0] != parent || pkg[1] != child {
+		t.Fatalf("got package of %d txns, want the child along with "+
+			"its parent", len(pkg))
+	}
+	s.included(parent)
+	s.included(child)
+
+	// The other transaction is the last one, as the orphan is never
+	// selected.
+	if pkg := s.next(); len(pkg) != 1 || pkg[0] != other {
+		t.Fatalf("got package of %d txns, want the other tx", len(pkg))
+	}
+	s.included(other)
+	if pkg := s.next(); pkg != nil {
+		t.Fatalf("got package of %d txns, want none", len(pkg))
+	}
+}
+
+// TestTopTxns ensures the transactions picked as the top of a block template
+// are the ones package selection picks first, so children paying for their
+// parents get them picked ahead of transactions paying more than the parents
+// alone, and that packages which don't fit are skipped.
+func TestTopTxns(t *testing.T) {
+	t.Parallel()
+
+	var source fakeTxSource
+	parent := newTestSpend(wire.OutPoint{Index: 0}, 1000, 0)
+	child := newTestSpend(wire.OutPoint{Hash: *parent.Hash()}, 900, 1)
+	other := newTestSpend(wire.OutPoint{Index: 1}, 1000, 2)
+	cheap := newTestSpend(wire.OutPoint{Index: 2}, 1000, 3)
+	source.addDesc(parent, 100)
+	source.addDesc(child, 10000)
+	source.addDesc(other, 2000)
+	source.addDesc(cheap, 500)
+	weight := blockchain.GetTransactionWeight(parent)
+
+	tests := []struct {
+		name      string
+		maxWeight int64
+		want      []*btcutil.Tx
+	}{{
+		name:      "child along with its parent first",
+		maxWeight: 3 * weight,
+		want:      []*btcutil.Tx{parent, child, other},
+	}, {
+		name:      "package too large",
+		maxWeight: weight,
+		want:      []*btcutil.Tx{other},
+	}, {
+		name:      "all",
+		maxWeight: 4 * weight,
+		want:      []*btcutil.Tx{parent, child, other, cheap},
+	}}
+	for _, test := range tests {
+		top := TopTxns(source.MiningDescs(), test.maxWeight)
+		if len(top) != len(test.want) {
+			t.Fatalf("%s: got %d transactions, want %d", test.name,
+				len(top), len(test.want))
+		}
+		for _, tx := range test.want {
+			if _, ok := top[*tx.Hash()]; !ok {
+				t.Fatalf("%s: transaction %v not in the top",
+					test.name, tx.Hash())
+			}
+		}
+	}
+}
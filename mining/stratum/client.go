@@ -0,0 +1,465 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// maxRequestSize is the maximum size in bytes of a request line.
+	maxRequestSize = 4096
+
+	// sendQueueSize is the number of messages which can be queued for a
+	// connection before it is considered too slow and disconnected.
+	sendQueueSize = 32
+
+	// idleTimeout is the duration after which a connection which sent no
+	// requests is disconnected.
+	idleTimeout = 10 * time.Minute
+
+	// writeTimeout is the duration after which a write to a connection is
+	// considered failed.
+	writeTimeout = 30 * time.Second
+
+	// maxRetargetFactor is the maximum factor the share difficulty is
+	// changed by in one adjustment.
+	maxRetargetFactor = 4
+
+	// minRetargetChange is the minimum relative change of the share
+	// difficulty for it to be adjusted.
+	minRetargetChange = 0.1
+)
+
+// Error codes of the responses to the stratum requests.
+const (
+	errOther         = 20
+	errStaleJob      = 21
+	errDuplicate     = 22
+	errLowDifficulty = 23
+	errUnauthorized  = 24
+	errNotSubscribed = 25
+)
+
+// requestError is an error of a response to a stratum request.  It is
+// serialized as an array of its code, its message and a traceback, which is
+// always null.
+type requestError struct {
+	Code    int
+	Message string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *requestError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// MarshalJSON serializes the error as an array of its code, its message and a
+// null traceback.
+func (e *requestError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+// newError returns an error with the passed code and message.
+func newError(code int, message string) *requestError {
+	return &requestError{Code: code, Message: message}
+}
+
+// request is a request sent by a stratum client.
+type request struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// response is the response to a request.
+type response struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *requestError `json:"error"`
+}
+
+// notification is a notification sent to a stratum client.
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// client is a connection to the stratum server.
+type client struct {
+	server      *Server
+	conn        net.Conn
+	extraNonce1 [extraNonce1Size]byte
+	sendQueue   chan []byte
+	quit        chan struct{}
+	quitOnce    sync.Once
+
+	mtx        sync.Mutex
+	subscribed bool
+	authorized bool
+	working    bool
+	worker     string
+	difficulty float64
+
+	// jobSeq is the sequence number of the last job sent to the client and
+	// jobDiffs holds the share difficulties of the jobs it was sent in the
+	// order of jobOrder.
+	jobSeq   uint64
+	jobDiffs map[string]float64
+	jobOrder []string
+
+	// lastRetarget is when the share difficulty was last adjusted and
+	// shares is the number of shares accepted since.
+	lastRetarget time.Time
+	shares       int
+}
+
+// newClient returns a client for the passed connection with the passed extra
+// nonce.
+func newClient(s *Server, conn net.Conn, extraNonce1 uint32) *client {
+	c := &client{
+		server:       s,
+		conn:         conn,
+		sendQueue:    make(chan []byte, sendQueueSize),
+		quit:         make(chan struct{}),
+		difficulty:   s.cfg.StartDifficulty,
+		jobDiffs:     make(map[string]float64),
+		lastRetarget: time.Now(),
+	}
+	binary.BigEndian.PutUint32(c.extraNonce1[:], extraNonce1)
+	return c
+}
+
+// disconnect disconnects the client.  It is safe to call more than once.
+func (c *client) disconnect() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+		c.conn.Close()
+	})
+}
+
+// send queues the passed message to be sent to the client.  The client is
+// disconnected when its queue is full.
+func (c *client) send(msg interface{}) {
+	serialized, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("Failed to marshal stratum message: %v", err)
+		return
+	}
+	serialized = append(serialized, '\n')
+
+	select {
+	case c.sendQueue <- serialized:
+	case <-c.quit:
+	default:
+		log.Warnf("Disconnecting slow stratum connection from %s",
+			c.conn.RemoteAddr())
+		c.disconnect()
+	}
+}
+
+// outHandler writes the queued messages to the connection.  It must be run as
+// a goroutine.
+func (c *client) outHandler() {
+	for {
+		select {
+		case msg := <-c.sendQueue:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := c.conn.Write(msg); err != nil {
+				log.Debugf("Failed to write to stratum connection "+
+					"from %s: %v", c.conn.RemoteAddr(), err)
+				c.disconnect()
+				return
+			}
+
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// inHandler reads and handles the requests of the client until it is
+// disconnected or sends a malformed request.
+func (c *client) inHandler() {
+	reader := bufio.NewReaderSize(c.conn, maxRequestSize)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			log.Warnf("Stratum request from %s is too long",
+				c.conn.RemoteAddr())
+			return
+		}
+		if err != nil {
+			return
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Warnf("Malformed stratum request from %s: %v",
+				c.conn.RemoteAddr(), err)
+			return
+		}
+
+		result, rerr, ready := c.handleRequest(&req)
+		c.send(&response{ID: req.ID, Result: result, Error: rerr})
+		if ready {
+			c.sendWork()
+		}
+	}
+}
+
+// handleRequest handles the passed request and returns its result or error.
+// It also returns whether the client just became both subscribed and
+// authorized, and thus needs to be sent its difficulty and a job.
+func (c *client) handleRequest(req *request) (interface{}, *requestError, bool) {
+	switch req.Method {
+	case "mining.subscribe":
+		c.mtx.Lock()
+		ready := !c.subscribed && c.authorized
+		c.subscribed = true
+		c.mtx.Unlock()
+
+		// The extra nonce of the client doubles as its subscription id
+		// since it is unique.
+		id := hex.EncodeToString(c.extraNonce1[:])
+		return []interface{}{
+			[]interface{}{
+				[]string{"mining.set_difficulty", id},
+				[]string{"mining.notify", id},
+			},
+			id,
+			extraNonce2Size,
+		}, nil, ready
+
+	case "mining.authorize":
+		var params []string
+		if err := json.Unmarshal(req.Params, &params); err != nil ||
+			len(params) < 1 {
+
+			return nil, newError(errOther, "invalid parameters"), false
+		}
+
+		c.mtx.Lock()
+		ready := c.subscribed && !c.authorized
+		c.authorized = true
+		c.worker = params[0]
+		c.mtx.Unlock()
+		log.Debugf("Stratum worker %s authorized from %s", params[0],
+			c.conn.RemoteAddr())
+		return true, nil, ready
+
+	case "mining.submit":
+		if err := c.handleSubmit(req.Params); err != nil {
+			return nil, err, false
+		}
+		return true, nil, false
+	}
+
+	return nil, newError(errOther, fmt.Sprintf("unsupported method %q",
+		req.Method)), false
+}
+
+// sendWork sends the share difficulty and the current job to the client, after
+// which it is sent the new jobs as well.
+func (c *client) sendWork() {
+	c.mtx.Lock()
+	c.working = true
+	c.send(&notification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{c.difficulty},
+	})
+	c.mtx.Unlock()
+
+	if j := c.server.current(); j != nil {
+		c.sendJob(j, true)
+	}
+}
+
+// sendJob sends the passed job to the client if it was sent its share
+// difficulty and wasn't sent a newer job already.  The share difficulty is
+// adjusted first when it is due.
+func (c *client) sendJob(j *job, cleanJobs bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.working || j.seq <= c.jobSeq {
+		return
+	}
+	c.retarget(time.Now(), false)
+
+	// The jobs which are no longer valid are forgotten.
+	if cleanJobs {
+		c.jobDiffs = make(map[string]float64)
+		c.jobOrder = c.jobOrder[:0]
+	}
+	c.jobSeq = j.seq
+	c.jobDiffs[j.id] = c.difficulty
+	c.jobOrder = append(c.jobOrder, j.id)
+	if len(c.jobOrder) > maxJobs {
+		delete(c.jobDiffs, c.jobOrder[0])
+		c.jobOrder = c.jobOrder[1:]
+	}
+
+	c.send(&notification{
+		Method: "mining.notify",
+		Params: j.notifyParams(cleanJobs),
+	})
+}
+
+// retarget adjusts the share difficulty of the client when it is due, so its
+// shares arrive at the target share interval, and sends the new difficulty to
+// the client.  It is due once the retarget interval passed, or when share is
+// set and the client submitted twice as many shares as expected in the
+// interval.  The new difficulty applies to the jobs sent afterwards.
+//
+// This function MUST be called with the client lock held.
+func (c *client) retarget(now time.Time, share bool) {
+	cfg := &c.server.cfg
+	elapsed := now.Sub(c.lastRetarget)
+	maxShares := int(2 * cfg.RetargetInterval / cfg.TargetShareInterval)
+	if elapsed < cfg.RetargetInterval && (!share || c.shares < maxShares) {
+		return
+	}
+
+	factor := 1 / float64(maxRetargetFactor)
+	if c.shares > 0 {
+		factor = cfg.TargetShareInterval.Seconds() * float64(c.shares) /
+			elapsed.Seconds()
+	}
+	factor = math.Min(math.Max(factor, 1/float64(maxRetargetFactor)),
+		maxRetargetFactor)
+	difficulty := math.Max(c.difficulty*factor, cfg.MinDifficulty)
+	if cfg.MaxDifficulty > 0 {
+		difficulty = math.Min(difficulty, cfg.MaxDifficulty)
+	}
+	c.lastRetarget = now
+	c.shares = 0
+
+	if math.Abs(difficulty-c.difficulty) < c.difficulty*minRetargetChange {
+		return
+	}
+	log.Debugf("Adjusting share difficulty of stratum worker %s from %v "+
+		"to %v", c.worker, c.difficulty, difficulty)
+	c.difficulty = difficulty
+	c.send(&notification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{difficulty},
+	})
+}
+
+// parseHexUint32 parses the passed big endian hex encoded 32-bit integer.
+func parseHexUint32(s string) (uint32, error) {
+	if len(s) != 8 {
+		return 0, fmt.Errorf("%q is not 8 hex characters", s)
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	return uint32(n), err
+}
+
+// handleSubmit handles a mining.submit request.  It validates the share and
+// submits the block when it meets the network target.
+func (c *client) handleSubmit(rawParams json.RawMessage) *requestError {
+	var params []string
+	if err := json.Unmarshal(rawParams, &params); err != nil ||
+		len(params) < 5 {
+
+		return newError(errOther, "invalid parameters")
+	}
+	extraNonce2, err := hex.DecodeString(params[2])
+	if err != nil || len(extraNonce2) != extraNonce2Size {
+		return newError(errOther, "invalid extranonce2")
+	}
+	ntime, err := parseHexUint32(params[3])
+	if err != nil {
+		return newError(errOther, "invalid ntime")
+	}
+	nonce, err := parseHexUint32(params[4])
+	if err != nil {
+		return newError(errOther, "invalid nonce")
+	}
+
+	c.mtx.Lock()
+	subscribed, authorized := c.subscribed, c.authorized
+	worker := c.worker
+	difficulty, sent := c.jobDiffs[params[1]]
+	c.mtx.Unlock()
+	if !subscribed {
+		return newError(errNotSubscribed, "not subscribed")
+	}
+	if !authorized {
+		return newError(errUnauthorized, "unauthorized worker")
+	}
+
+	// Shares of jobs which were invalidated or built on a block which is no
+	// longer the tip are stale.
+	s := c.server
+	j := s.lookupJob(params[1])
+	if j == nil || !sent {
+		return newError(errStaleJob, "job not found")
+	}
+	best := s.g.BestSnapshot()
+	if !j.block.Header.PrevBlock.IsEqual(&best.Hash) {
+		return newError(errStaleJob, "stale job")
+	}
+	timestamp := time.Unix(int64(ntime), 0)
+	if timestamp.Before(j.minTime) ||
+		timestamp.After(time.Now().Add(maxTimeOffset)) {
+
+		return newError(errOther, "ntime out of range")
+	}
+
+	var key shareKey
+	copy(key.extraNonce[:], c.extraNonce1[:])
+	copy(key.extraNonce[extraNonce1Size:], extraNonce2)
+	key.ntime, key.nonce = ntime, nonce
+
+	header := j.header(key.extraNonce[:], ntime, nonce)
+	hash := header.BlockHash()
+	hashNum := blockchain.HashToBig(&hash)
+	if hashNum.Cmp(shareTarget(difficulty)) > 0 {
+		return newError(errLowDifficulty, "low difficulty share")
+	}
+	if !s.addShare(j, key) {
+		return newError(errDuplicate, "duplicate share")
+	}
+
+	c.mtx.Lock()
+	c.shares++
+	c.retarget(time.Now(), true)
+	c.mtx.Unlock()
+
+	if hashNum.Cmp(j.target) > 0 {
+		return nil
+	}
+	msgBlock, err := j.solvedBlock(key.extraNonce[:], &header)
+	if err != nil {
+		log.Errorf("Failed to assemble block of stratum share: %v", err)
+		return newError(errOther, err.Error())
+	}
+	err = s.submitBlock(btcutil.NewBlock(msgBlock), j.udata, worker)
+	if err != nil {
+		return newError(errOther, fmt.Sprintf("block rejected: %v", err))
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+const (
+	// extraNonce1Size is the size in bytes of the extra nonce assigned to
+	// each connection.
+	extraNonce1Size = 4
+
+	// extraNonce2Size is the size in bytes of the extra nonce the miners
+	// roll themselves.
+	extraNonce2Size = 4
+
+	// extraNonceSize is the size in bytes of the whole extra nonce in the
+	// coinbase script.
+	extraNonceSize = extraNonce1Size + extraNonce2Size
+
+	// extraNoncePlaceholder is the extra nonce the coinbase of the template
+	// is created with.  Its minimal encoding is exactly extraNonceSize bytes,
+	// so the coinbase can be split around it and the miners can fill in any
+	// extra nonce of the same size.
+	extraNoncePlaceholder = uint64(1) << 56
+
+	// maxTimeOffset is how far in the future the time of a share may be.
+	// It is the same as the limit for the timestamps of blocks.
+	maxTimeOffset = 2 * time.Hour
+)
+
+var (
+	// diff1Target is the target of a share with a difficulty of 1.
+	diff1Target = blockchain.CompactToBig(0x1d00ffff)
+
+	// maxTarget is the largest possible target, which every hash meets.
+	maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256),
+		big.NewInt(1))
+)
+
+// shareTarget returns the target a share of the passed difficulty has to
+// meet.
+func shareTarget(difficulty float64) *big.Int {
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1Target),
+		big.NewFloat(difficulty)).Int(nil)
+	if target.Cmp(maxTarget) > 0 {
+		return maxTarget
+	}
+	return target
+}
+
+// shareKey identifies a share of a job so duplicates can be rejected.
+type shareKey struct {
+	extraNonce [extraNonceSize]byte
+	ntime      uint32
+	nonce      uint32
+}
+
+// job is the work sent to the miners with a mining.notify notification.  The
+// coinbase of its block is split around the extra nonce, so the miners only
+// need the serialized coinbase halves and the merkle branch of the coinbase
+// to create block headers of their own.
+type job struct {
+	seq       uint64
+	id        string
+	height    int32
+	block     *wire.MsgBlock
+	coinbase1 []byte
+	coinbase2 []byte
+	branch    []chainhash.Hash
+	target    *big.Int
+	minTime   time.Time
+
+	// txSourceUpdated is when the transaction source was last updated as
+	// of the creation of the template of the job.
+	txSourceUpdated time.Time
+
+	// udata is the utreexo accumulator proof of the template of the job,
+	// if any.
+	udata *btcacc.UData
+
+	// submitted holds the shares already submitted for the job.
+	submitted map[shareKey]struct{}
+}
+
+// newJob returns a job with the passed sequence number for the passed block
+// template.  The coinbase of the template is changed to contain the extra nonce
+// placeholder, so the template must not be used for anything else.
+func newJob(seq uint64, g *mining.BlkTmplGenerator,
+	template *mining.BlockTemplate, txSourceUpdated time.Time) (*job, error) {
+
+	msgBlock := template.Block
+	err := g.UpdateExtraNonce(msgBlock, template.Height, extraNoncePlaceholder)
+	if err != nil {
+		return nil, err
+	}
+
+	// The extra nonce immediately follows the height in the coinbase
+	// script and is pushed as data of extraNonceSize bytes.  The script
+	// follows the version, the input count and the previous outpoint of
+	// the only input in the serialized coinbase.
+	coinbase := msgBlock.Transactions[0]
+	heightScript, err := txscript.NewScriptBuilder().AddInt64(
+		int64(template.Height)).Script()
+	if err != nil {
+		return nil, err
+	}
+	sigScriptLen := len(coinbase.TxIn[0].SignatureScript)
+	offset := 4 + wire.VarIntSerializeSize(uint64(len(coinbase.TxIn))) +
+		chainhash.HashSize + 4 +
+		wire.VarIntSerializeSize(uint64(sigScriptLen)) +
+		len(heightScript) + 1
+
+	var buf bytes.Buffer
+	buf.Grow(coinbase.SerializeSizeStripped())
+	if err := coinbase.SerializeNoWitness(&buf); err != nil {
+		return nil, err
+	}
+	serialized := buf.Bytes()
+	var placeholder [extraNonceSize]byte
+	binary.LittleEndian.PutUint64(placeholder[:], extraNoncePlaceholder)
+	if len(serialized) < offset+extraNonceSize ||
+		!bytes.Equal(serialized[offset:offset+extraNonceSize],
+			placeholder[:]) {
+
+		return nil, fmt.Errorf("unable to locate the extra nonce in "+
+			"the coinbase script %x", coinbase.TxIn[0].SignatureScript)
+	}
+
+	return &job{
+		seq:             seq,
+		id:              fmt.Sprintf("%x", seq),
+		height:          template.Height,
+		block:           msgBlock,
+		coinbase1:       serialized[:offset],
+		coinbase2:       serialized[offset+extraNonceSize:],
+		branch:          merkleBranch(msgBlock.Transactions),
+		target:          blockchain.CompactToBig(msgBlock.Header.Bits),
+		minTime:         g.BestSnapshot().MedianTime.Add(time.Second),
+		txSourceUpdated: txSourceUpdated,
+		udata:           template.UData,
+		submitted:       make(map[shareKey]struct{}),
+	}, nil
+}
+
+// refreshed returns a copy of the job with the passed sequence number and the
+// time of its header updated to the current time.  The shares of the job remain
+// valid.
+func (j *job) refreshed(seq uint64, g *mining.BlkTmplGenerator) (*job, error) {
+	msgBlock := *j.block
+	if err := g.UpdateBlockTime(&msgBlock); err != nil {
+		return nil, err
+	}
+
+	refreshed := *j
+	refreshed.seq = seq
+	refreshed.id = fmt.Sprintf("%x", seq)
+	refreshed.block = &msgBlock
+	refreshed.target = blockchain.CompactToBig(msgBlock.Header.Bits)
+	refreshed.submitted = make(map[shareKey]struct{})
+	return &refreshed, nil
+}
+
+// merkleBranch returns the hashes needed to calculate the merkle root of the
+// passed transactions from the hash of the first one, which is the coinbase.
+func merkleBranch(txns []*wire.MsgTx) []chainhash.Hash {
+	level := make([]chainhash.Hash, len(txns))
+	for i := 1; i < len(txns); i++ {
+		level[i] = txns[i].TxHash()
+	}
+
+	var branch []chainhash.Hash
+	for len(level) > 1 {
+		branch = append(branch, level[1])
+
+		// Duplicate the last hash of levels with an odd number of
+		// hashes as the merkle tree does.
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]chainhash.Hash, len(level)/2)
+		for i := 1; i < len(next); i++ {
+			next[i] = *blockchain.HashMerkleBranches(&level[2*i],
+				&level[2*i+1])
+		}
+		level = next
+	}
+	return branch
+}
+
+// coinbase returns the serialized coinbase of the job, without its witness,
+// with the passed extra nonce.
+func (j *job) coinbase(extraNonce []byte) []byte {
+	serialized := make([]byte, 0, len(j.coinbase1)+extraNonceSize+
+		len(j.coinbase2))
+	serialized = append(serialized, j.coinbase1...)
+	serialized = append(serialized, extraNonce...)
+	return append(serialized, j.coinbase2...)
+}
+
+// header returns the block header of the job for the passed extra nonce,
+// time and nonce.
+func (j *job) header(extraNonce []byte, ntime, nonce uint32) wire.BlockHeader {
+	merkleRoot := chainhash.DoubleHashH(j.coinbase(extraNonce))
+	for i := range j.branch {
+		merkleRoot = *blockchain.HashMerkleBranches(&merkleRoot,
+			&j.branch[i])
+	}
+
+	header := j.block.Header
+	header.MerkleRoot = merkleRoot
+	header.Timestamp = time.Unix(int64(ntime), 0)
+	header.Nonce = nonce
+	return header
+}
+
+// solvedBlock returns the block of the job with the passed extra nonce and
+// solved header.
+func (j *job) solvedBlock(extraNonce []byte, header *wire.BlockHeader) (*wire.MsgBlock, error) {
+	var coinbase wire.MsgTx
+	err := coinbase.DeserializeNoWitness(bytes.NewReader(
+		j.coinbase(extraNonce)))
+	if err != nil {
+		return nil, err
+	}
+	coinbase.TxIn[0].Witness = j.block.Transactions[0].TxIn[0].Witness
+
+	msgBlock := wire.NewMsgBlock(header)
+	msgBlock.Transactions = make([]*wire.MsgTx, 0, len(j.block.Transactions))
+	msgBlock.Transactions = append(msgBlock.Transactions, &coinbase)
+	msgBlock.Transactions = append(msgBlock.Transactions,
+		j.block.Transactions[1:]...)
+	return msgBlock, nil
+}
+
+// notifyParams returns the parameters of the mining.notify notification of
+// the job.
+func (j *job) notifyParams(cleanJobs bool) []interface{} {
+	branch := make([]string, 0, len(j.branch))
+	for i := range j.branch {
+		branch = append(branch, hex.EncodeToString(j.branch[i][:]))
+	}
+
+	// The previous block hash is sent with the byte order of each of its
+	// 32-bit words swapped, and the other fields of the header as big
+	// endian.
+	header := &j.block.Header
+	var prevHash [chainhash.HashSize]byte
+	for i := 0; i < chainhash.HashSize; i += 4 {
+		for k := 0; k < 4; k++ {
+			prevHash[i+k] = header.PrevBlock[i+3-k]
+		}
+	}
+
+	return []interface{}{
+		j.id,
+		hex.EncodeToString(prevHash[:]),
+		hex.EncodeToString(j.coinbase1),
+		hex.EncodeToString(j.coinbase2),
+		branch,
+		fmt.Sprintf("%08x", uint32(header.Version)),
+		fmt.Sprintf("%08x", header.Bits),
+		fmt.Sprintf("%08x", uint32(header.Timestamp.Unix())),
+		cleanJobs,
+	}
+}
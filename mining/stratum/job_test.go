@@ -0,0 +1,137 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestMerkleBranch ensures the merkle root calculated from the hash of the
+// first transaction and its merkle branch is the merkle root of the
+// transactions for all kinds of tree shapes.
+func TestMerkleBranch(t *testing.T) {
+	t.Parallel()
+
+	for numTxns := 1; numTxns <= 17; numTxns++ {
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+		for i := 0; i < numTxns; i++ {
+			tx := wire.NewMsgTx(wire.TxVersion)
+			tx.LockTime = uint32(i)
+			msgBlock.AddTransaction(tx)
+		}
+
+		block := btcutil.NewBlock(msgBlock)
+		merkles := blockchain.BuildMerkleTreeStore(block.Transactions(),
+			false)
+		want := merkles[len(merkles)-1]
+
+		root := msgBlock.Transactions[0].TxHash()
+		branch := merkleBranch(msgBlock.Transactions)
+		for i := range branch {
+			root = *blockchain.HashMerkleBranches(&root, &branch[i])
+		}
+		if !root.IsEqual(want) {
+			t.Errorf("merkle root of %d transactions from branch: "+
+				"got %v, want %v", numTxns, root, want)
+		}
+	}
+}
+
+// TestShareTarget ensures the share targets of difficulties are calculated
+// relative to the target of difficulty 1 and capped at the largest target.
+func TestShareTarget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		difficulty float64
+		want       *big.Int
+	}{
+		{1, diff1Target},
+		{2, new(big.Int).Rsh(diff1Target, 1)},
+		{1.0 / 16, new(big.Int).Lsh(diff1Target, 4)},
+		{1e-12, maxTarget},
+	}
+	for _, test := range tests {
+		got := shareTarget(test.difficulty)
+		if got.Cmp(test.want) != 0 {
+			t.Errorf("share target of difficulty %v: got %x, want "+
+				"%x", test.difficulty, got, test.want)
+		}
+	}
+}
+
+// TestJobCoinbase ensures the coinbase of a job with any extra nonce is the
+// coinbase of the template with the extra nonce in place of the placeholder,
+// and that its solved blocks keep the witness of the coinbase.
+func TestJobCoinbase(t *testing.T) {
+	h := newTestHarness(t, nil)
+	defer h.teardown()
+
+	template, err := h.generator.NewBlockTemplate(h.payAddr)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate: %v", err)
+	}
+	j, err := newJob(1, h.generator, template, h.txSource.LastUpdated())
+	if err != nil {
+		t.Fatalf("newJob: %v", err)
+	}
+
+	// The header of the placeholder extra nonce matches the template.
+	var placeholder [extraNonceSize]byte
+	placeholder[extraNonceSize-1] = 1
+	header := j.header(placeholder[:],
+		uint32(template.Block.Header.Timestamp.Unix()), 0)
+	if header.BlockHash() != template.Block.Header.BlockHash() {
+		t.Fatalf("header with the placeholder extra nonce does not " +
+			"match the template")
+	}
+
+	extraNonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	header = j.header(extraNonce, uint32(header.Timestamp.Unix()), 5)
+	msgBlock, err := j.solvedBlock(extraNonce, &header)
+	if err != nil {
+		t.Fatalf("solvedBlock: %v", err)
+	}
+	block := btcutil.NewBlock(msgBlock)
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	if !merkles[len(merkles)-1].IsEqual(&header.MerkleRoot) {
+		t.Fatalf("merkle root of the solved block does not match its " +
+			"header")
+	}
+	coinbase := msgBlock.Transactions[0]
+	sigScript := coinbase.TxIn[0].SignatureScript
+	if !bytes.Contains(sigScript, extraNonce) {
+		t.Fatalf("coinbase script %x does not contain the extra nonce",
+			sigScript)
+	}
+	wantWitness := template.Block.Transactions[0].TxIn[0].Witness
+	if len(coinbase.TxIn[0].Witness) != len(wantWitness) {
+		t.Fatalf("coinbase witness not kept: got %d items, want %d",
+			len(coinbase.TxIn[0].Witness), len(wantWitness))
+	}
+	if _, err := blockchain.ExtractCoinbaseHeight(btcutil.NewTx(coinbase)); err != nil {
+		t.Fatalf("coinbase height: %v", err)
+	}
+
+	// A copy of the job with a refreshed time keeps its coinbase and
+	// merkle branch.
+	refreshed, err := j.refreshed(2, h.generator)
+	if err != nil {
+		t.Fatalf("refreshed: %v", err)
+	}
+	if refreshed.id != "2" || refreshed.block.Header.PrevBlock !=
+		j.block.Header.PrevBlock || chainhash.DoubleHashH(
+		refreshed.coinbase(extraNonce)) != coinbase.TxHash() {
+
+		t.Fatalf("refreshed job does not match the job")
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+const (
+	// defaultTargetShareInterval is the default interval at which each
+	// connection should submit shares.
+	defaultTargetShareInterval = 10 * time.Second
+
+	// defaultRetargetInterval is the default interval at which the share
+	// difficulty of each connection is adjusted.
+	defaultRetargetInterval = 90 * time.Second
+
+	// defaultJobUpdateInterval is the default interval at which the memory
+	// pool is checked for changes which warrant a new job.
+	defaultJobUpdateInterval = 30 * time.Second
+
+	// maxJobAge is the age of the time of the current job after which a
+	// copy of it with an updated time is sent even when nothing else
+	// changed.
+	maxJobAge = time.Minute
+
+	// maxJobs is the maximum number of jobs of the current chain tip which
+	// shares are accepted for.
+	maxJobs = 16
+)
+
+// Config is a descriptor containing the stratum server configuration.
+type Config struct {
+	// ChainParams identifies which chain parameters the stratum server is
+	// associated with.
+	ChainParams *chaincfg.Params
+
+	// BlockTemplateGenerator identifies the instance to use in order to
+	// generate the block templates the jobs are created from.
+	BlockTemplateGenerator *mining.BlkTmplGenerator
+
+	// Chain is the chain the stratum server subscribes to in order to send
+	// new jobs when a block is connected.
+	Chain *blockchain.BlockChain
+
+	// MiningAddrs is a list of payment addresses to use for the generated
+	// blocks.  Each template randomly chooses one of them.
+	MiningAddrs []btcutil.Address
+
+	// ProcessBlock defines the function to call with any solved blocks.
+	// It typically must run the provided block through the same set of
+	// rules and handling as any other block coming from the network.
+	ProcessBlock func(*btcutil.Block, blockchain.BehaviorFlags) (bool, error)
+
+	// IsCurrent defines the function to use to obtain whether or not the
+	// block chain is current.  No jobs are sent while it is not since any
+	// solved blocks would be on a side chain.
+	IsCurrent func() bool
+
+	// Listeners defines a slice of listeners for which the stratum server
+	// will take ownership of and accept connections.
+	Listeners []net.Listener
+
+	// StartDifficulty is the share difficulty of new connections.
+	StartDifficulty float64
+
+	// MinDifficulty and MaxDifficulty bound the share difficulty of the
+	// connections.  A maximum of zero means there is no maximum.
+	MinDifficulty float64
+	MaxDifficulty float64
+
+	// TargetShareInterval is the interval at which the share difficulty of
+	// each connection is adjusted to have it submit shares.
+	TargetShareInterval time.Duration
+
+	// RetargetInterval is the interval at which the share difficulty of
+	// each connection is adjusted.
+	RetargetInterval time.Duration
+
+	// JobUpdateInterval is the interval at which the memory pool is checked
+	// for changes which warrant a new job.
+	JobUpdateInterval time.Duration
+}
+
+// Server provides a Stratum v1 mining server which hands out jobs created from
+// the block templates of the block template generator and submits the solved
+// blocks.
+type Server struct {
+	started  int32
+	shutdown int32
+
+	cfg Config
+	g   *mining.BlkTmplGenerator
+
+	// nextExtraNonce1 is the extra nonce assigned to the next connection.
+	// It must be accessed atomically.
+	nextExtraNonce1 uint32
+
+	// jobSeq is the sequence number of the last job.  It is only accessed
+	// by the job handler.
+	jobSeq uint64
+
+	mtx        sync.Mutex
+	jobs       map[string]*job
+	jobOrder   []string
+	currentJob *job
+	clients    map[*client]struct{}
+
+	submitBlockLock sync.Mutex
+	blockConnected  chan struct{}
+	wg              sync.WaitGroup
+	quit            chan struct{}
+}
+
+// handleBlockchainNotification signals the job handler to send a new job when
+// a block is connected to the main chain.  Creating the template is left to
+// the job handler since the chain is locked while notifications are sent.
+func (s *Server) handleBlockchainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.NTBlockConnected {
+		return
+	}
+
+	select {
+	case s.blockConnected <- struct{}{}:
+	default:
+	}
+}
+
+// jobHandler creates the jobs and sends them to the connections.  New jobs
+// which invalidate all of the previous ones are sent when a block is
+// connected, and new jobs which don't when the memory pool changed or the
+// time of the current job is old.  It must be run as a goroutine.
+func (s *Server) jobHandler() {
+	ticker := time.NewTicker(s.cfg.JobUpdateInterval)
+	defer ticker.Stop()
+
+	s.updateJob(true)
+out:
+	for {
+		select {
+		case <-s.blockConnected:
+			s.updateJob(true)
+
+		case <-ticker.C:
+			s.updateJob(false)
+
+		case <-s.quit:
+			break out
+		}
+	}
+
+	s.wg.Done()
+	log.Tracef("Stratum job handler done")
+}
+
+// updateJob creates and sends a new job if the current one is outdated.  The
+// new job invalidates the previous ones when clean is set or the chain tip
+// changed.
+//
+// This function MUST only be called from the job handler.
+func (s *Server) updateJob(clean bool) {
+	best := s.g.BestSnapshot()
+	if best.Height != 0 && !s.cfg.IsCurrent() {
+		log.Debugf("Not sending stratum jobs while the chain is not " +
+			"current")
+		return
+	}
+
+	s.mtx.Lock()
+	current := s.currentJob
+	s.mtx.Unlock()
+
+	seq := s.jobSeq + 1
+
+	var j *job
+	var err error
+	txSourceUpdated := s.g.TxSource().LastUpdated()
+	switch {
+	case clean || current == nil ||
+		!current.block.Header.PrevBlock.IsEqual(&best.Hash):
+
+		clean = true
+		j, err = s.newTemplateJob(seq, txSourceUpdated)
+
+	case txSourceUpdated.After(current.txSourceUpdated):
+		j, err = s.newTemplateJob(seq, txSourceUpdated)
+
+	case time.Since(current.block.Header.Timestamp) >= maxJobAge:
+		j, err = current.refreshed(seq, s.g)
+
+	default:
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to create stratum job: %v", err)
+		return
+	}
+	s.jobSeq = seq
+
+	s.mtx.Lock()
+	if clean {
+		s.jobs = make(map[string]*job)
+		s.jobOrder = s.jobOrder[:0]
+	}
+	s.jobs[j.id] = j
+	s.jobOrder = append(s.jobOrder, j.id)
+	if len(s.jobOrder) > maxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = s.jobOrder[1:]
+	}
+	s.currentJob = j
+	for c := range s.clients {
+		c.sendJob(j, clean)
+	}
+	s.mtx.Unlock()
+
+	log.Debugf("New stratum job %s at height %d (%d transactions, clean "+
+		"%v)", j.id, j.height, len(j.block.Transactions), clean)
+}
+
+// newTemplateJob returns a job with the passed sequence number for a new block
+// template.
+func (s *Server) newTemplateJob(seq uint64, txSourceUpdated time.Time) (*job, error) {
+	// Choose a payment address at random.
+	payToAddr := s.cfg.MiningAddrs[rand.Intn(len(s.cfg.MiningAddrs))]
+
+	template, err := s.g.NewBlockTemplate(payToAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newJob(seq, s.g, template, txSourceUpdated)
+}
+
+// current returns the current job, or nil when there is no job yet.
+func (s *Server) current() *job {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.currentJob
+}
+
+// lookupJob returns the job with the passed id, or nil when it is not known
+// or was invalidated.
+func (s *Server) lookupJob(id string) *job {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.jobs[id]
+}
+
+// addShare records the passed share of the job and returns false when it was
+// already submitted.
+func (s *Server) addShare(j *job, key shareKey) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := j.submitted[key]; ok {
+		return false
+	}
+	j.submitted[key] = struct{}{}
+	return true
+}
+
+// submitBlock submits the passed solved block to the chain and returns why it
+// was rejected, if it was.  The passed utreexo accumulator proof of the
+// template of the block, if any, is handed to the chain first so it doesn't
+// have to be generated again.
+func (s *Server) submitBlock(block *btcutil.Block, ud *btcacc.UData,
+	worker string) error {
+
+	s.submitBlockLock.Lock()
+	defer s.submitBlockLock.Unlock()
+
+	s.g.AddPrecomputedUData(&block.MsgBlock().Header.PrevBlock, ud)
+
+	// Process this block using the same rules as blocks coming from other
+	// nodes.  This will in turn relay it to the network like normal.
+	isOrphan, err := s.cfg.ProcessBlock(block, blockchain.BFNone)
+	if err != nil {
+		// Anything other than a rule violation is an unexpected error,
+		// so log that error as an internal error.
+		if _, ok := err.(blockchain.RuleError); !ok {
+			log.Errorf("Unexpected error while processing block "+
+				"submitted via stratum: %v", err)
+			return err
+		}
+
+		log.Debugf("Block submitted via stratum rejected: %v", err)
+		return err
+	}
+	if isOrphan {
+		log.Debugf("Block submitted via stratum is an orphan")
+		return errors.New("block is an orphan")
+	}
+
+	// The block was accepted.
+	coinbaseTx := block.MsgBlock().Transactions[0].TxOut[0]
+	log.Infof("Block submitted via stratum by %s accepted (hash %s, "+
+		"amount %v)", worker, block.Hash(),
+		btcutil.Amount(coinbaseTx.Value))
+	return nil
+}
+
+// listenHandler accepts the connections of the passed listener.  It must be
+// run as a goroutine.
+func (s *Server) listenHandler(listener net.Listener) {
+	log.Infof("Stratum server listening on %s", listener.Addr())
+	for atomic.LoadInt32(&s.shutdown) == 0 {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Only log the error if not forcibly shutting down.
+			if atomic.LoadInt32(&s.shutdown) == 0 {
+				log.Errorf("Can't accept connection: %v", err)
+			}
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+	s.wg.Done()
+	log.Tracef("Stratum listener done for %s", listener.Addr())
+}
+
+// handleConn serves a stratum connection until it is disconnected.  It must be
+// run as a goroutine.
+func (s *Server) handleConn(conn net.Conn) {
+	c := newClient(s, conn, atomic.AddUint32(&s.nextExtraNonce1, 1))
+	log.Debugf("New stratum connection from %s (extranonce1 %x)",
+		conn.RemoteAddr(), c.extraNonce1)
+
+	s.mtx.Lock()
+	s.clients[c] = struct{}{}
+	s.mtx.Unlock()
+
+	go c.outHandler()
+	c.inHandler()
+
+	s.mtx.Lock()
+	delete(s.clients, c)
+	s.mtx.Unlock()
+
+	c.disconnect()
+	log.Debugf("Stratum connection from %s disconnected",
+		conn.RemoteAddr())
+	s.wg.Done()
+}
+
+// Start begins accepting stratum connections and sending jobs to them.
+func (s *Server) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting stratum server")
+	for _, listener := range s.cfg.Listeners {
+		s.wg.Add(1)
+		go s.listenHandler(listener)
+	}
+	s.wg.Add(1)
+	go s.jobHandler()
+}
+
+// Stop stops the stratum server and disconnects all of its connections.  It
+// blocks until they are all disconnected.
+func (s *Server) Stop() error {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		log.Infof("Stratum server is already in the process of " +
+			"shutting down")
+		return nil
+	}
+
+	log.Warnf("Stratum server shutting down")
+	for _, listener := range s.cfg.Listeners {
+		err := listener.Close()
+		if err != nil {
+			log.Errorf("Problem shutting down stratum: %v", err)
+			return err
+		}
+	}
+	close(s.quit)
+
+	s.mtx.Lock()
+	for c := range s.clients {
+		c.disconnect()
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+	log.Infof("Stratum server shutdown complete")
+	return nil
+}
+
+// New returns a new stratum server for the passed configuration.  Use Start
+// to begin accepting connections.
+func New(cfg *Config) *Server {
+	s := &Server{
+		cfg:             *cfg,
+		g:               cfg.BlockTemplateGenerator,
+		nextExtraNonce1: rand.Uint32(),
+		jobs:            make(map[string]*job),
+		clients:         make(map[*client]struct{}),
+		blockConnected:  make(chan struct{}, 1),
+		quit:            make(chan struct{}),
+	}
+	if s.cfg.StartDifficulty <= 0 {
+		s.cfg.StartDifficulty = 1
+	}
+	if s.cfg.MinDifficulty <= 0 || s.cfg.MinDifficulty > s.cfg.StartDifficulty {
+		s.cfg.MinDifficulty = s.cfg.StartDifficulty
+	}
+	if s.cfg.TargetShareInterval <= 0 {
+		s.cfg.TargetShareInterval = defaultTargetShareInterval
+	}
+	if s.cfg.RetargetInterval <= 0 {
+		s.cfg.RetargetInterval = defaultRetargetInterval
+	}
+	if s.cfg.JobUpdateInterval <= 0 {
+		s.cfg.JobUpdateInterval = defaultJobUpdateInterval
+	}
+
+	s.cfg.Chain.Subscribe(s.handleBlockchainNotification)
+	return s
+}
@@ -0,0 +1,641 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// testTxSource is an empty transaction source whose last update time can be
+// changed to simulate changes of the memory pool.
+type testTxSource struct {
+	mtx         sync.Mutex
+	lastUpdated time.Time
+}
+
+// LastUpdated returns the last time the source was updated.
+func (s *testTxSource) LastUpdated() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.lastUpdated
+}
+
+// MiningDescs returns no transactions.
+func (s *testTxSource) MiningDescs() []*mining.TxDesc {
+	return nil
+}
+
+// HaveTransaction returns false since the source has no transactions.
+func (s *testTxSource) HaveTransaction(hash *chainhash.Hash) bool {
+	return false
+}
+
+// update simulates a change of the source.
+func (s *testTxSource) update() {
+	s.mtx.Lock()
+	s.lastUpdated = s.lastUpdated.Add(time.Second)
+	s.mtx.Unlock()
+}
+
+// testHarness is a simnet chain with a block template generator and a stratum
+// server backed by them.
+type testHarness struct {
+	t         *testing.T
+	chain     *blockchain.BlockChain
+	generator *mining.BlkTmplGenerator
+	txSource  *testTxSource
+	payAddr   btcutil.Address
+	server    *Server
+	addr      string
+	teardown  func()
+}
+
+// newTestHarness returns a harness for a new simnet chain.  The stratum server
+// is created with the passed configuration, which is completed with the chain
+// and the listener, and started unless it is nil.
+func newTestHarness(t *testing.T, cfg *Config) *testHarness {
+	t.Helper()
+
+	dataDir, err := ioutil.TempDir("", "stratum")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	params := &chaincfg.SimNetParams
+	db, err := database.Create("ffldb", filepath.Join(dataDir, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	h := &testHarness{t: t}
+	h.teardown = func() {
+		if h.server != nil {
+			h.server.Stop()
+		}
+		db.Close()
+		os.RemoveAll(dataDir)
+	}
+
+	timeSource := blockchain.NewMedianTime()
+	h.chain, err = blockchain.New(&blockchain.Config{
+		DB:               db,
+		UtxoCacheMaxSize: 10 * 1024 * 1024,
+		ChainParams:      params,
+		TimeSource:       timeSource,
+		DataDir:          dataDir,
+	})
+	if err != nil {
+		h.teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	h.payAddr, err = btcutil.NewAddressPubKeyHash(make([]byte, 20), params)
+	if err != nil {
+		h.teardown()
+		t.Fatalf("unable to create address: %v", err)
+	}
+	h.txSource = &testTxSource{lastUpdated: time.Now()}
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight,
+		BlockMaxSize:   1000000,
+	}
+	h.generator = mining.NewBlkTmplGenerator(&policy, params, h.txSource,
+		h.chain, timeSource, nil, nil, nil)
+	if cfg == nil {
+		return h
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.teardown()
+		t.Fatalf("unable to listen: %v", err)
+	}
+	h.addr = listener.Addr().String()
+	cfg.ChainParams = params
+	cfg.BlockTemplateGenerator = h.generator
+	cfg.Chain = h.chain
+	cfg.MiningAddrs = []btcutil.Address{h.payAddr}
+	cfg.ProcessBlock = func(block *btcutil.Block,
+		flags blockchain.BehaviorFlags) (bool, error) {
+
+		_, isOrphan, err := h.chain.ProcessBlock(block, flags)
+		return isOrphan, err
+	}
+	cfg.IsCurrent = func() bool { return true }
+	cfg.Listeners = []net.Listener{listener}
+	h.server = New(cfg)
+	h.server.Start()
+	return h
+}
+
+// testMessage is a response or notification received by the test client.
+type testMessage struct {
+	ID     *int              `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []interface{}     `json:"error"`
+}
+
+// testJob is a job received by the test client.
+type testJob struct {
+	id        string
+	prevHash  chainhash.Hash
+	coinbase1 []byte
+	coinbase2 []byte
+	branch    []chainhash.Hash
+	version   uint32
+	bits      uint32
+	ntime     uint32
+	clean     bool
+}
+
+// testClient is a minimal stratum client which decodes the jobs it is sent
+// and builds block headers from them the way mining software does.
+type testClient struct {
+	t             *testing.T
+	conn          net.Conn
+	nextID        int
+	extraNonce1   []byte
+	messages      chan *testMessage
+	notifications []*testMessage
+}
+
+// newTestClient connects a test client to the passed address.
+func newTestClient(t *testing.T, addr string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	c := &testClient{
+		t:        t,
+		conn:     conn,
+		messages: make(chan *testMessage, 100),
+	}
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var msg testMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				t.Errorf("malformed message %s: %v",
+					scanner.Text(), err)
+				break
+			}
+			c.messages <- &msg
+		}
+		close(c.messages)
+	}()
+	return c
+}
+
+// next returns the next message received by the client.
+func (c *testClient) next() *testMessage {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("connection closed")
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatalf("timeout waiting for message")
+	}
+	return nil
+}
+
+// call sends a request and returns its response.  The notifications received
+// in the meantime are queued.  It returns the error code of the response, or
+// zero when it succeeded.
+func (c *testClient) call(method string, params ...interface{}) (json.RawMessage, int) {
+	c.t.Helper()
+
+	c.nextID++
+	req, err := json.Marshal(map[string]interface{}{
+		"id":     c.nextID,
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		c.t.Fatalf("unable to marshal request: %v", err)
+	}
+	if _, err := c.conn.Write(append(req, '\n')); err != nil {
+		c.t.Fatalf("unable to send request: %v", err)
+	}
+	for {
+		msg := c.next()
+		if msg.ID == nil || *msg.ID != c.nextID {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if msg.Error != nil {
+			return nil, int(msg.Error[0].(float64))
+		}
+		return msg.Result, 0
+	}
+}
+
+// notification returns the next notification of the passed method.
+func (c *testClient) notification(method string) *testMessage {
+	c.t.Helper()
+
+	for {
+		var msg *testMessage
+		if len(c.notifications) > 0 {
+			msg = c.notifications[0]
+			c.notifications = c.notifications[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Method == method {
+			return msg
+		}
+	}
+}
+
+// difficulty returns the difficulty of the next mining.set_difficulty
+// notification.
+func (c *testClient) difficulty() float64 {
+	c.t.Helper()
+
+	var difficulty float64
+	msg := c.notification("mining.set_difficulty")
+	if err := json.Unmarshal(msg.Params[0], &difficulty); err != nil {
+		c.t.Fatalf("malformed difficulty: %v", err)
+	}
+	return difficulty
+}
+
+// job decodes the next mining.notify notification.
+func (c *testClient) job() *testJob {
+	c.t.Helper()
+
+	msg := c.notification("mining.notify")
+	var id, prevHash, coinbase1, coinbase2, version, bits, ntime string
+	var branch []string
+	var clean bool
+	fields := []interface{}{&id, &prevHash, &coinbase1, &coinbase2,
+		&branch, &version, &bits, &ntime, &clean}
+	if len(msg.Params) != len(fields) {
+		c.t.Fatalf("mining.notify has %d params", len(msg.Params))
+	}
+	for i, field := range fields {
+		if err := json.Unmarshal(msg.Params[i], field); err != nil {
+			c.t.Fatalf("malformed mining.notify param %d: %v", i,
+				err)
+		}
+	}
+
+	j := &testJob{id: id, clean: clean}
+	prevHashBytes := c.decodeHex(prevHash)
+	for i := 0; i < chainhash.HashSize; i += 4 {
+		for k := 0; k < 4; k++ {
+			j.prevHash[i+k] = prevHashBytes[i+3-k]
+		}
+	}
+	j.coinbase1 = c.decodeHex(coinbase1)
+	j.coinbase2 = c.decodeHex(coinbase2)
+	for _, hash := range branch {
+		var h chainhash.Hash
+		copy(h[:], c.decodeHex(hash))
+		j.branch = append(j.branch, h)
+	}
+	j.version = binary.BigEndian.Uint32(c.decodeHex(version))
+	j.bits = binary.BigEndian.Uint32(c.decodeHex(bits))
+	j.ntime = binary.BigEndian.Uint32(c.decodeHex(ntime))
+	return j
+}
+
+// decodeHex decodes the passed hex string.
+func (c *testClient) decodeHex(s string) []byte {
+	c.t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		c.t.Fatalf("malformed hex %q: %v", s, err)
+	}
+	return b
+}
+
+// subscribe subscribes and authorizes the client and returns the difficulty
+// and the job it is sent.
+func (c *testClient) subscribe() (float64, *testJob) {
+	c.t.Helper()
+
+	result, code := c.call("mining.subscribe", "test/1.0")
+	if code != 0 {
+		c.t.Fatalf("mining.subscribe failed with %d", code)
+	}
+	var subscription []json.RawMessage
+	if err := json.Unmarshal(result, &subscription); err != nil ||
+		len(subscription) != 3 {
+
+		c.t.Fatalf("malformed mining.subscribe result %s", result)
+	}
+	var extraNonce1 string
+	var extraNonce2Len int
+	json.Unmarshal(subscription[1], &extraNonce1)
+	json.Unmarshal(subscription[2], &extraNonce2Len)
+	c.extraNonce1 = c.decodeHex(extraNonce1)
+	if len(c.extraNonce1) != extraNonce1Size ||
+		extraNonce2Len != extraNonce2Size {
+
+		c.t.Fatalf("unexpected extra nonces %s and %d", extraNonce1,
+			extraNonce2Len)
+	}
+
+	if _, code := c.call("mining.authorize", "worker", "x"); code != 0 {
+		c.t.Fatalf("mining.authorize failed with %d", code)
+	}
+	return c.difficulty(), c.job()
+}
+
+// header returns the block header of the passed job for the passed extra
+// nonce and nonce.
+func (c *testClient) header(j *testJob, extraNonce2 []byte, nonce uint32) *wire.BlockHeader {
+	var coinbase []byte
+	coinbase = append(coinbase, j.coinbase1...)
+	coinbase = append(coinbase, c.extraNonce1...)
+	coinbase = append(coinbase, extraNonce2...)
+	coinbase = append(coinbase, j.coinbase2...)
+	merkleRoot := chainhash.DoubleHashH(coinbase)
+	for i := range j.branch {
+		merkleRoot = *blockchain.HashMerkleBranches(&merkleRoot,
+			&j.branch[i])
+	}
+
+	return &wire.BlockHeader{
+		Version:    int32(j.version),
+		PrevBlock:  j.prevHash,
+		MerkleRoot: merkleRoot,
+		Timestamp:  time.Unix(int64(j.ntime), 0),
+		Bits:       j.bits,
+		Nonce:      nonce,
+	}
+}
+
+// solve returns the first nonce from the passed one whose header hash meets
+// the block target of the job when block is set, or doesn't when it is not.
+func (c *testClient) solve(j *testJob, extraNonce2 []byte, nonce uint32, block bool) uint32 {
+	target := blockchain.CompactToBig(j.bits)
+	for ; ; nonce++ {
+		hash := c.header(j, extraNonce2, nonce).BlockHash()
+		if (blockchain.HashToBig(&hash).Cmp(target) <= 0) == block {
+			return nonce
+		}
+	}
+}
+
+// submit submits a share and returns the error code of the response.
+func (c *testClient) submit(j *testJob, extraNonce2 []byte, nonce uint32) int {
+	c.t.Helper()
+
+	_, code := c.call("mining.submit", "worker", j.id,
+		hex.EncodeToString(extraNonce2), fmt.Sprintf("%08x", j.ntime),
+		fmt.Sprintf("%08x", nonce))
+	return code
+}
+
+// TestStratumMining ensures a stratum client is able to mine blocks with the
+// jobs it is sent and that shares are validated.
+func TestStratumMining(t *testing.T) {
+	h := newTestHarness(t, &Config{StartDifficulty: 1e-12})
+	defer h.teardown()
+
+	c := newTestClient(t, h.addr)
+	defer c.conn.Close()
+
+	difficulty, j := c.subscribe()
+	if difficulty != 1e-12 {
+		t.Fatalf("unexpected start difficulty %v", difficulty)
+	}
+	genesis := h.chain.BestSnapshot().Hash
+	if !j.clean || j.prevHash != genesis {
+		t.Fatalf("first job does not build on the genesis block")
+	}
+
+	// A share which doesn't meet the block target is accepted without
+	// changing the chain, and can't be submitted again.
+	extraNonce2 := []byte{0, 0, 0, 1}
+	nonce := c.solve(j, extraNonce2, 0, false)
+	if code := c.submit(j, extraNonce2, nonce); code != 0 {
+		t.Fatalf("share rejected with %d", code)
+	}
+	if code := c.submit(j, extraNonce2, nonce); code != errDuplicate {
+		t.Fatalf("duplicate share: got %d, want %d", code,
+			errDuplicate)
+	}
+	if h.chain.BestSnapshot().Height != 0 {
+		t.Fatalf("share which doesn't meet the block target connected")
+	}
+
+	// Malformed shares and shares of unknown jobs are rejected.
+	if code := c.submit(j, []byte{1}, nonce); code != errOther {
+		t.Fatalf("short extranonce2: got %d, want %d", code, errOther)
+	}
+	unknown := *j
+	unknown.id = "ffff"
+	if code := c.submit(&unknown, extraNonce2, nonce); code != errStaleJob {
+		t.Fatalf("unknown job: got %d, want %d", code, errStaleJob)
+	}
+
+	// A share which meets the block target is submitted as a block, which
+	// results in a new job which invalidates the previous ones.
+	nonce = c.solve(j, extraNonce2, nonce+1, true)
+	if code := c.submit(j, extraNonce2, nonce); code != 0 {
+		t.Fatalf("block share rejected with %d", code)
+	}
+	best := h.chain.BestSnapshot()
+	if best.Height != 1 || best.Hash != c.header(j, extraNonce2,
+		nonce).BlockHash() {
+
+		t.Fatalf("block share was not connected")
+	}
+	next := c.job()
+	if !next.clean || next.prevHash != best.Hash {
+		t.Fatalf("new job does not build on the new block")
+	}
+	nonce = c.solve(j, extraNonce2, nonce+1, false)
+	if code := c.submit(j, extraNonce2, nonce); code != errStaleJob {
+		t.Fatalf("stale share: got %d, want %d", code, errStaleJob)
+	}
+
+	// The new job can be mined as well.
+	nonce = c.solve(next, extraNonce2, 0, true)
+	if code := c.submit(next, extraNonce2, nonce); code != 0 {
+		t.Fatalf("block share rejected with %d", code)
+	}
+	if h.chain.BestSnapshot().Height != 2 {
+		t.Fatalf("second block share was not connected")
+	}
+}
+
+// TestStratumShareValidation ensures shares are rejected from connections
+// which aren't subscribed and authorized and when they don't meet the share
+// difficulty.
+func TestStratumShareValidation(t *testing.T) {
+	h := newTestHarness(t, &Config{StartDifficulty: 1})
+	defer h.teardown()
+
+	unsubscribed := newTestClient(t, h.addr)
+	defer unsubscribed.conn.Close()
+	_, code := unsubscribed.call("mining.submit", "worker", "1",
+		"00000000", "00000000", "00000000")
+	if code != errNotSubscribed {
+		t.Fatalf("unsubscribed share: got %d, want %d", code,
+			errNotSubscribed)
+	}
+	if _, code := unsubscribed.call("mining.unknown"); code != errOther {
+		t.Fatalf("unknown method: got %d, want %d", code, errOther)
+	}
+
+	c := newTestClient(t, h.addr)
+	defer c.conn.Close()
+	difficulty, j := c.subscribe()
+	if difficulty != 1 {
+		t.Fatalf("unexpected start difficulty %v", difficulty)
+	}
+
+	// Each connection is assigned its own extra nonce.
+	other := newTestClient(t, h.addr)
+	defer other.conn.Close()
+	other.subscribe()
+	if bytes.Equal(c.extraNonce1, other.extraNonce1) {
+		t.Fatalf("connections share the extra nonce %x", c.extraNonce1)
+	}
+
+	// Find a nonce which doesn't meet difficulty 1.
+	extraNonce2 := []byte{0, 0, 0, 1}
+	var nonce uint32
+	for ; ; nonce++ {
+		hash := c.header(j, extraNonce2, nonce).BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(diff1Target) > 0 {
+			break
+		}
+	}
+	if code := c.submit(j, extraNonce2, nonce); code != errLowDifficulty {
+		t.Fatalf("low difficulty share: got %d, want %d", code,
+			errLowDifficulty)
+	}
+
+	// Shares with a time before the median time of the chain are rejected.
+	early := *j
+	early.ntime = uint32(h.chain.BestSnapshot().MedianTime.Unix())
+	if code := c.submit(&early, extraNonce2, nonce); code != errOther {
+		t.Fatalf("early share: got %d, want %d", code, errOther)
+	}
+}
+
+// TestStratumVardiff ensures the share difficulty of a connection submitting
+// shares faster than the target interval is increased.
+func TestStratumVardiff(t *testing.T) {
+	h := newTestHarness(t, &Config{
+		StartDifficulty:     1e-12,
+		TargetShareInterval: time.Second,
+		RetargetInterval:    5 * time.Second,
+	})
+	defer h.teardown()
+
+	c := newTestClient(t, h.addr)
+	defer c.conn.Close()
+	difficulty, j := c.subscribe()
+
+	// Twice the number of shares expected in the retarget interval are
+	// submitted right away, so the difficulty is increased by the maximum
+	// factor.
+	extraNonce2 := []byte{0, 0, 0, 1}
+	var nonce uint32
+	for i := 0; i < 10; i++ {
+		nonce = c.solve(j, extraNonce2, nonce, false)
+		if code := c.submit(j, extraNonce2, nonce); code != 0 {
+			t.Fatalf("share %d rejected with %d", i, code)
+		}
+		nonce++
+	}
+	got := c.difficulty()
+	if want := difficulty * maxRetargetFactor; got != want {
+		t.Fatalf("adjusted difficulty: got %v, want %v", got, want)
+	}
+
+	// The new difficulty applies to the jobs sent afterwards.
+	h.server.blockConnected <- struct{}{}
+	next := c.job()
+	sc := c.serverClient(h)
+	sc.mtx.Lock()
+	jobDifficulty := sc.jobDiffs[next.id]
+	sc.mtx.Unlock()
+	if jobDifficulty != got {
+		t.Fatalf("difficulty of the new job: got %v, want %v",
+			jobDifficulty, got)
+	}
+}
+
+// serverClient returns the server side of the test client.
+func (c *testClient) serverClient(h *testHarness) *client {
+	c.t.Helper()
+
+	h.server.mtx.Lock()
+	defer h.server.mtx.Unlock()
+	for sc := range h.server.clients {
+		if bytes.Equal(sc.extraNonce1[:], c.extraNonce1) {
+			return sc
+		}
+	}
+	c.t.Fatalf("connection not found")
+	return nil
+}
+
+// TestStratumMempoolJobs ensures a new job which doesn't invalidate the
+// previous ones is sent when the memory pool changes.
+func TestStratumMempoolJobs(t *testing.T) {
+	h := newTestHarness(t, &Config{
+		StartDifficulty:   1e-12,
+		JobUpdateInterval: 50 * time.Millisecond,
+	})
+	defer h.teardown()
+
+	c := newTestClient(t, h.addr)
+	defer c.conn.Close()
+	_, j := c.subscribe()
+
+	h.txSource.update()
+	next := c.job()
+	if next.clean || next.id == j.id || next.prevHash != j.prevHash {
+		t.Fatalf("unexpected job after a memory pool change: %+v", next)
+	}
+
+	// Shares of both jobs are accepted.
+	extraNonce2 := []byte{0, 0, 0, 1}
+	nonce := c.solve(j, extraNonce2, 0, false)
+	if code := c.submit(j, extraNonce2, nonce); code != 0 {
+		t.Fatalf("share of the previous job rejected with %d", code)
+	}
+	nonce = c.solve(next, extraNonce2, 0, false)
+	if code := c.submit(next, extraNonce2, nonce); code != 0 {
+		t.Fatalf("share of the new job rejected with %d", code)
+	}
+}
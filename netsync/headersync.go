@@ -0,0 +1,754 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	peerpkg "github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// headerCommitPeriod is the number of headers between each of the
+	// one bit commitments stored while presyncing the header chain of a
+	// peer.
+	headerCommitPeriod = 600
+
+	// redownloadBufferSize is the number of redownloaded headers that are
+	// held back until the commitments following them have been checked.
+	// A peer that serves a different chain during the redownload than it
+	// did during the presync has to match every commitment in the buffer
+	// to get any of its headers stored, which only succeeds with a
+	// probability of 2^-24.
+	redownloadBufferSize = headerCommitPeriod * 24
+
+	// maxHeadersSyncPeers is the maximum number of peers header chains are
+	// synced from at the same time.
+	maxHeadersSyncPeers = 8
+
+	// antiDoSWorkBlocks is the number of blocks worth of work below the
+	// tip of the best chain a header chain is allowed to have before its
+	// headers are stored.  This permits syncing short forks of the best
+	// chain once it has more work than the minimum chain work.
+	antiDoSWorkBlocks = 144
+
+	// maxTimeOffset is the maximum amount of time a block timestamp may be
+	// ahead of the current time.  It matches the limit enforced by the
+	// blockchain package.
+	maxTimeOffset = 2 * time.Hour
+)
+
+// headersSyncPhase identifies the phases of syncing the header chain of a
+// peer.
+type headersSyncPhase int
+
+const (
+	// headersPresync is the phase during which the headers of a peer are
+	// validated and their work accumulated without storing them.  Only a
+	// commitment to one header out of every headerCommitPeriod is kept.
+	headersPresync headersSyncPhase = iota
+
+	// headersRedownload is the phase during which the headers are
+	// downloaded a second time and checked against the commitments made
+	// during the presync.
+	headersRedownload
+
+	// headersSynced is the phase after the redownloaded headers reached
+	// the required work.  Any further headers are stored right away.
+	headersSynced
+
+	// headersDone is the phase after the header chain of the peer has
+	// been synced completely or was found to have too little work.
+	headersDone
+)
+
+// headerNode is a node in the index of the headers synced from peers past the
+// final checkpoint.
+type headerNode struct {
+	hash    chainhash.Hash
+	parent  *headerNode
+	height  int32
+	workSum *big.Int
+}
+
+// headerCursor tracks the tip of a header chain while it is validated.
+type headerCursor struct {
+	hash    chainhash.Hash
+	height  int32
+	bits    uint32
+	workSum *big.Int
+}
+
+// connect ensures the passed header extends the chain the cursor points to
+// with a valid proof of work and a permitted difficulty transition and then
+// advances the cursor to it.
+func (c *headerCursor) connect(header *wire.BlockHeader, params *chaincfg.Params) error {
+	if header.PrevBlock != c.hash {
+		return fmt.Errorf("header %v does not connect to %v",
+			header.BlockHash(), c.hash)
+	}
+
+	height := c.height + 1
+	if !blockchain.PermittedDifficultyTransition(params, height, c.bits,
+		header.Bits) {
+
+		return fmt.Errorf("header %v at height %d has an unexpected "+
+			"difficulty of %08x", header.BlockHash(), height,
+			header.Bits)
+	}
+	err := blockchain.CheckHeaderProofOfWork(header, params.PowLimit)
+	if err != nil {
+		return err
+	}
+
+	c.hash = header.BlockHash()
+	c.height = height
+	c.bits = header.Bits
+	c.workSum = new(big.Int).Add(c.workSum, blockchain.CalcWork(header.Bits))
+	return nil
+}
+
+// headersSyncState tracks syncing the header chain of a single peer past the
+// final checkpoint.
+//
+// Storing every header a peer sends would allow it to exhaust our memory with
+// a long chain of cheap low difficulty headers.  Instead, the headers are
+// first presynced: they are validated and their work accumulated while only a
+// salted one bit commitment of every headerCommitPeriod-th header is kept.
+// Only once the chain is shown to have the required work are the headers
+// downloaded again, checked against the commitments and stored.
+type headersSyncState struct {
+	params  *chaincfg.Params
+	minWork *big.Int
+	phase   headersSyncPhase
+
+	// requested is set while a getheaders request to the peer is
+	// outstanding and lastRequest is the time it was sent.
+	requested   bool
+	lastRequest time.Time
+
+	// lowWork is set when the header chain of the peer ended before it
+	// reached the required work.
+	lowWork bool
+
+	// start is the block in the main chain the header chain of the peer
+	// forks from.  It is nil until the first headers have been received.
+	start *headerCursor
+
+	// The following fields are used for the commitments made during the
+	// presync.
+	salt           [32]byte
+	commitOffset   int32
+	commitments    []bool
+	maxCommitments int
+
+	presync     *headerCursor
+	redownload  *headerCursor
+	commitIndex int
+	buffer      []*wire.BlockHeader
+
+	// tip is the last header of the peer stored in the header index.
+	tip *headerNode
+}
+
+// newHeadersSyncState returns a new header sync state for a peer whose header
+// chain must have at least minWork cumulative work before it is stored.
+func newHeadersSyncState(params *chaincfg.Params, minWork *big.Int) *headersSyncState {
+	s := &headersSyncState{
+		params:  params,
+		minWork: minWork,
+	}
+
+	// The salt and the offset of the commitments are random so a peer
+	// can't predict which headers are committed to or their commitments.
+	var offset [4]byte
+	rand.Read(s.salt[:])
+	rand.Read(offset[:])
+	s.commitOffset = int32(binary.LittleEndian.Uint32(offset[:]) %
+		headerCommitPeriod)
+
+	return s
+}
+
+// begin sets the block of the main chain the header chain of the peer forks
+// from.  It must be called before any headers are processed.
+func (s *headersSyncState) begin(hash *chainhash.Hash, height int32,
+	bits uint32, workSum *big.Int, timestamp time.Time) {
+
+	s.start = &headerCursor{
+		hash:    *hash,
+		height:  height,
+		bits:    bits,
+		workSum: workSum,
+	}
+	presync := *s.start
+	s.presync = &presync
+
+	// Block timestamps must exceed the median time of the previous blocks,
+	// so a valid chain can have at most 6 blocks per second since the
+	// start.  This bounds the memory used for the commitments.
+	elapsed := time.Since(timestamp) + maxTimeOffset
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	s.maxCommitments = int(6 * int64(elapsed/time.Second) / headerCommitPeriod)
+}
+
+// commitment returns the salted one bit commitment to the passed header hash.
+func (s *headersSyncState) commitment(hash *chainhash.Hash) bool {
+	var buf [len(s.salt) + chainhash.HashSize]byte
+	copy(buf[:], s.salt[:])
+	copy(buf[len(s.salt):], hash[:])
+	sum := sha256.Sum256(buf[:])
+	return sum[0]&1 == 1
+}
+
+// processHeaders processes the headers the peer sent in response to the last
+// request and returns the headers that are ready to be stored.  full indicates
+// whether the peer sent the maximum number of headers per message which means
+// it likely has more.  An error is returned when the headers are invalid or
+// don't match the commitments the peer made during the presync.
+func (s *headersSyncState) processHeaders(headers []*wire.BlockHeader, full bool) ([]*wire.BlockHeader, error) {
+	switch s.phase {
+	case headersPresync:
+		return nil, s.processPresync(headers, full)
+
+	case headersRedownload, headersSynced:
+		return s.processRedownload(headers, full)
+	}
+
+	return nil, nil
+}
+
+// processPresync validates the headers received during the presync and makes
+// the commitments to them.
+func (s *headersSyncState) processPresync(headers []*wire.BlockHeader, full bool) error {
+	for _, header := range headers {
+		if err := s.presync.connect(header, s.params); err != nil {
+			return err
+		}
+		if s.presync.height%headerCommitPeriod != s.commitOffset {
+			continue
+		}
+
+		s.commitments = append(s.commitments,
+			s.commitment(&s.presync.hash))
+		if len(s.commitments) > s.maxCommitments {
+			return fmt.Errorf("header chain exceeds the maximum "+
+				"possible length at height %d",
+				s.presync.height)
+		}
+	}
+
+	switch {
+	// Start the redownload from the beginning once the chain has enough
+	// work.  Any remaining headers of the message are simply redownloaded
+	// along with the others.
+	case s.presync.workSum.Cmp(s.minWork) >= 0:
+		redownload := *s.start
+		s.redownload = &redownload
+		s.phase = headersRedownload
+
+	// The chain ended without reaching the required work.
+	case !full:
+		s.lowWork = true
+		s.phase = headersDone
+		s.commitments = nil
+	}
+
+	return nil
+}
+
+// processRedownload checks the redownloaded headers against the commitments
+// made during the presync and releases them once enough following headers
+// have been checked or the chain reached the required work.
+func (s *headersSyncState) processRedownload(headers []*wire.BlockHeader, full bool) ([]*wire.BlockHeader, error) {
+	var released []*wire.BlockHeader
+	for _, header := range headers {
+		if err := s.redownload.connect(header, s.params); err != nil {
+			return nil, err
+		}
+		if s.phase == headersSynced {
+			released = append(released, header)
+			continue
+		}
+
+		if s.redownload.height%headerCommitPeriod == s.commitOffset {
+			if s.commitIndex >= len(s.commitments) {
+				return nil, fmt.Errorf("header %v at height %d "+
+					"exceeds the presynced chain",
+					s.redownload.hash, s.redownload.height)
+			}
+			want := s.commitments[s.commitIndex]
+			if s.commitment(&s.redownload.hash) != want {
+				return nil, fmt.Errorf("header %v at height %d "+
+					"does not match the presynced chain",
+					s.redownload.hash, s.redownload.height)
+			}
+			s.commitIndex++
+		}
+
+		s.buffer = append(s.buffer, header)
+		if s.redownload.workSum.Cmp(s.minWork) >= 0 {
+			released = append(released, s.buffer...)
+			s.buffer = nil
+			s.commitments = nil
+			s.phase = headersSynced
+			continue
+		}
+		if len(s.buffer) > redownloadBufferSize {
+			released = append(released, s.buffer[0])
+			s.buffer = s.buffer[1:]
+		}
+	}
+
+	if !full {
+		// The chain ended before the redownload reached the required
+		// work which means the peer no longer serves the chain it
+		// presynced.
+		if s.phase == headersRedownload {
+			s.lowWork = true
+			s.buffer = nil
+			s.commitments = nil
+		}
+		s.phase = headersDone
+	}
+
+	return released, nil
+}
+
+// locatorHash returns the hash the next getheaders request to the peer should
+// start from.
+func (s *headersSyncState) locatorHash() *chainhash.Hash {
+	switch s.phase {
+	case headersPresync:
+		return &s.presync.hash
+
+	case headersRedownload, headersSynced:
+		return &s.redownload.hash
+	}
+
+	return nil
+}
+
+// abandon stops the header sync and releases the memory held by it.
+func (s *headersSyncState) abandon() {
+	s.phase = headersDone
+	s.commitments = nil
+	s.buffer = nil
+	s.tip = nil
+}
+
+// shouldSyncHeaders returns whether the header chains of the peers should be
+// synced before downloading any blocks.  This is the case past the final
+// checkpoint when the chain is not current yet.  Regression test mode does not
+// support the headers-first approach.
+func (sm *SyncManager) shouldSyncHeaders() bool {
+	return sm.nextCheckpoint == nil && !sm.headersSynced &&
+		sm.chainParams != &chaincfg.RegressionNetParams &&
+		!sm.chain.IsCurrent()
+}
+
+// headersSyncMinWork returns the work a header chain must have before its
+// headers are stored.  It is the greater of the minimum chain work and the
+// work of the best chain less antiDoSWorkBlocks blocks worth of work.
+func (sm *SyncManager) headersSyncMinWork() *big.Int {
+	minWork := new(big.Int)
+	best := sm.chain.BestSnapshot()
+	if workSum, err := sm.chain.ChainWork(&best.Hash); err == nil {
+		nearTip := new(big.Int).Mul(blockchain.CalcWork(best.Bits),
+			big.NewInt(antiDoSWorkBlocks))
+		if workSum.Cmp(nearTip) > 0 {
+			minWork.Sub(workSum, nearTip)
+		}
+	}
+
+	if sm.minChainWork != nil && sm.minChainWork.Cmp(minWork) > 0 {
+		minWork.Set(sm.minChainWork)
+	}
+	return minWork
+}
+
+// startHeadersSync starts syncing the header chains of the candidate peers
+// that claim to have more blocks than we do, up to maxHeadersSyncPeers at a
+// time, and downloads the blocks of the best header chain synced so far.
+func (sm *SyncManager) startHeadersSync() {
+	if !sm.headersSyncMode {
+		log.Infof("Syncing header chains with a minimum chain work "+
+			"of %064x", sm.headersSyncMinWork())
+	}
+	sm.headersSyncMode = true
+	sm.headersFirstMode = true
+
+	segwitActive, err := sm.chain.IsDeploymentActive(chaincfg.DeploymentSegwit)
+	if err != nil {
+		log.Errorf("Unable to query for segwit soft-fork state: %v", err)
+		return
+	}
+
+	var active int
+	for _, state := range sm.peerStates {
+		hs := state.headersSync
+		if hs != nil && hs.phase != headersDone {
+			active++
+		}
+	}
+
+	best := sm.chain.BestSnapshot()
+	for peer, state := range sm.peerStates {
+		if active >= maxHeadersSyncPeers {
+			break
+		}
+		if !state.syncCandidate || state.headersSync != nil ||
+			peer.LastBlock() <= best.Height {
+			continue
+		}
+		if segwitActive && !peer.IsWitnessEnabled() {
+			continue
+		}
+
+		locator, err := sm.chain.LatestBlockLocator()
+		if err != nil {
+			log.Errorf("Failed to get block locator for the "+
+				"latest block: %v", err)
+			return
+		}
+		state.headersSync = newHeadersSyncState(sm.chainParams,
+			sm.headersSyncMinWork())
+		sm.pushHeadersSyncRequest(peer, state.headersSync, locator)
+		active++
+
+		log.Infof("Syncing headers from peer %s", peer.Addr())
+	}
+
+	sm.selectHeaderChain()
+}
+
+// pushHeadersSyncRequest requests the headers following the passed locator
+// from the peer.  The request is queued directly rather than through
+// PushGetHeadersMsg since the redownload intentionally repeats an earlier
+// request which would otherwise be filtered as a duplicate.
+func (sm *SyncManager) pushHeadersSyncRequest(peer *peerpkg.Peer,
+	hs *headersSyncState, locator blockchain.BlockLocator) {
+
+	msg := wire.NewMsgGetHeaders()
+	for _, hash := range locator {
+		if err := msg.AddBlockLocatorHash(hash); err != nil {
+			log.Warnf("Failed to build getheaders message for "+
+				"peer %s: %v", peer.Addr(), err)
+			return
+		}
+	}
+	peer.QueueMessage(msg, nil)
+
+	hs.requested = true
+	hs.lastRequest = time.Now()
+}
+
+// handleHeadersSyncMsg handles headers messages received in response to the
+// header sync requests made past the final checkpoint.
+func (sm *SyncManager) handleHeadersSyncMsg(peer *peerpkg.Peer,
+	state *peerSyncState, headers []*wire.BlockHeader) {
+
+	hs := state.headersSync
+	if hs == nil || !hs.requested {
+		log.Warnf("Got %d unrequested headers from %s -- "+
+			"disconnecting", len(headers), peer.Addr())
+		peer.Disconnect()
+		return
+	}
+	hs.requested = false
+
+	// Nothing more to do when the header sync was abandoned while the
+	// request was outstanding.
+	if hs.phase == headersDone {
+		return
+	}
+
+	// The first headers the peer sends determine where its header chain
+	// forks from the main chain.  No headers at all mean the peer doesn't
+	// know of any blocks past our best chain.
+	if hs.start == nil {
+		if len(headers) == 0 {
+			log.Debugf("Peer %s has no headers past our best "+
+				"chain", peer.Addr())
+			hs.phase = headersDone
+			sm.selectHeaderChain()
+			return
+		}
+
+		if err := sm.beginHeadersSync(hs, &headers[0].PrevBlock); err != nil {
+			log.Warnf("Headers from peer %s do not connect to the "+
+				"main chain: %v -- disconnecting", peer.Addr(),
+				err)
+			peer.Disconnect()
+			return
+		}
+	}
+
+	prevPhase := hs.phase
+	full := len(headers) == wire.MaxBlockHeadersPerMsg
+	released, err := hs.processHeaders(headers, full)
+	if err != nil {
+		log.Warnf("Received invalid headers from peer %s: %v -- "+
+			"disconnecting", peer.Addr(), err)
+		peer.Disconnect()
+		return
+	}
+	sm.addSyncedHeaders(hs, released)
+
+	if prevPhase == headersPresync && hs.phase == headersRedownload {
+		log.Infof("Header chain of peer %s reached the required work "+
+			"at height %d -- redownloading headers", peer.Addr(),
+			hs.presync.height)
+	}
+
+	if hs.phase == headersDone {
+		if hs.lowWork {
+			log.Infof("Header chain of peer %s does not have the "+
+				"required work -- ignoring it", peer.Addr())
+		} else if hs.tip != nil {
+			log.Infof("Synced headers to height %d from peer %s",
+				hs.tip.height, peer.Addr())
+		}
+		sm.selectHeaderChain()
+		return
+	}
+
+	locator := blockchain.BlockLocator([]*chainhash.Hash{hs.locatorHash()})
+	sm.pushHeadersSyncRequest(peer, hs, locator)
+}
+
+// beginHeadersSync sets the block of the main chain the header chain of the
+// peer forks from and adds it to the header index.
+func (sm *SyncManager) beginHeadersSync(hs *headersSyncState, hash *chainhash.Hash) error {
+	height, err := sm.chain.BlockHeightByHash(hash)
+	if err != nil {
+		return err
+	}
+	header, err := sm.chain.HeaderByHash(hash)
+	if err != nil {
+		return err
+	}
+	workSum, err := sm.chain.ChainWork(hash)
+	if err != nil {
+		return err
+	}
+
+	hs.begin(hash, height, header.Bits, workSum, header.Timestamp)
+	if _, exists := sm.headerIndex[*hash]; !exists {
+		sm.headerIndex[*hash] = &headerNode{
+			hash:    *hash,
+			height:  height,
+			workSum: workSum,
+		}
+	}
+	return nil
+}
+
+// addSyncedHeaders adds the released headers of a peer to the header index and
+// updates the tip of the header chain of the peer.  The headers must connect
+// to the chain already in the index which is guaranteed by the header sync.
+func (sm *SyncManager) addSyncedHeaders(hs *headersSyncState, headers []*wire.BlockHeader) {
+	for _, header := range headers {
+		hash := header.BlockHash()
+		node, exists := sm.headerIndex[hash]
+		if !exists {
+			parent := sm.headerIndex[header.PrevBlock]
+			node = &headerNode{
+				hash:   hash,
+				parent: parent,
+				height: parent.height + 1,
+				workSum: new(big.Int).Add(parent.workSum,
+					blockchain.CalcWork(header.Bits)),
+			}
+			sm.headerIndex[hash] = node
+		}
+		hs.tip = node
+	}
+}
+
+// selectHeaderChain chooses the header chain with the most cumulative work
+// among the peers whose header sync has completed and downloads its blocks from
+// the peer that served it.  The download only switches to another chain when
+// that chain has strictly more work.  Header sync mode is left once no peer is
+// syncing headers anymore and none has a better chain than the best chain.
+func (sm *SyncManager) selectHeaderChain() {
+	var bestPeer *peerpkg.Peer
+	var bestTip *headerNode
+	var syncing, synced, lowWork bool
+	for peer, state := range sm.peerStates {
+		hs := state.headersSync
+		if hs == nil {
+			continue
+		}
+		if hs.phase != headersDone {
+			syncing = true
+			continue
+		}
+		if hs.lowWork {
+			lowWork = true
+			continue
+		}
+		synced = true
+		if hs.tip == nil {
+			continue
+		}
+
+		cmp := 1
+		if bestTip != nil {
+			cmp = hs.tip.workSum.Cmp(bestTip.workSum)
+		}
+		if cmp > 0 || (cmp == 0 && peer == sm.syncPeer) {
+			bestPeer, bestTip = peer, hs.tip
+		}
+	}
+
+	best := sm.chain.BestSnapshot()
+	workSum, err := sm.chain.ChainWork(&best.Hash)
+	if err != nil {
+		log.Errorf("Unable to get the work of the best chain: %v", err)
+		return
+	}
+	if bestTip == nil || bestTip.workSum.Cmp(workSum) <= 0 {
+		switch {
+		case syncing:
+		case synced:
+			log.Infof("No peer has a header chain with more work " +
+				"than the best chain -- switching to normal mode")
+			sm.finishHeadersSync()
+			sm.resumeNormalSync()
+		case lowWork:
+			log.Warnf("No peer has a header chain with the " +
+				"required work")
+		}
+		return
+	}
+
+	// Keep downloading the current header chain unless the best one has
+	// more work.
+	if sm.syncPeer != nil && sm.headersTarget != nil &&
+		bestTip.workSum.Cmp(sm.headersTarget.workSum) <= 0 {
+		return
+	}
+
+	if sm.syncPeer != nil && sm.syncPeer != bestPeer {
+		if state, exists := sm.peerStates[sm.syncPeer]; exists {
+			sm.clearRequestedState(state)
+		}
+	}
+
+	// Build the list of headers from the block after the fork point with
+	// the main chain to the tip of the best header chain.
+	sm.headerList.Init()
+	for node := bestTip; node != nil; node = node.parent {
+		if sm.chain.MainChainHasBlock(&node.hash) {
+			break
+		}
+		sm.headerList.PushFront(&HeaderNode{
+			Height: node.height,
+			Hash:   &node.hash,
+		})
+	}
+	firstNode := sm.headerList.Front().Value.(*HeaderNode)
+
+	log.Infof("Downloading blocks %d to %d of the header chain with the "+
+		"most work from peer %s", firstNode.Height, bestTip.height,
+		bestPeer.Addr())
+
+	sm.startHeader = sm.headerList.Front()
+	sm.headersTarget = bestTip
+	sm.headersFirstMode = true
+	sm.syncPeer = bestPeer
+	sm.requestedBlocks = make(map[chainhash.Hash]struct{})
+	sm.lastProgressTime = time.Now()
+	sm.progressLogger.SetLastLogTime(time.Now())
+	if sm.utreexoCSN {
+		sm.fetchHeaderUBlocks()
+	} else {
+		sm.fetchHeaderBlocks()
+	}
+}
+
+// finishHeadersSync leaves header sync mode and releases the header index.
+func (sm *SyncManager) finishHeadersSync() {
+	sm.headersSyncMode = false
+	sm.headersSynced = true
+	sm.headersFirstMode = false
+	sm.headerList.Init()
+	sm.startHeader = nil
+	sm.headersTarget = nil
+	sm.headerIndex = make(map[chainhash.Hash]*headerNode)
+	for _, state := range sm.peerStates {
+		if state.headersSync != nil {
+			state.headersSync.abandon()
+		}
+	}
+}
+
+// resumeNormalSync continues syncing in normal mode after leaving header sync
+// mode by requesting the blocks past the best chain from the sync peer, or by
+// choosing a new sync peer when there is none.
+func (sm *SyncManager) resumeNormalSync() {
+	if sm.syncPeer == nil {
+		sm.startSync()
+		return
+	}
+
+	locator, err := sm.chain.LatestBlockLocator()
+	if err != nil {
+		log.Errorf("Failed to get block locator for the latest "+
+			"block: %v", err)
+		return
+	}
+	if sm.utreexoCSN {
+		err = sm.syncPeer.PushGetUBlocksMsg(locator, &zeroHash)
+	} else {
+		err = sm.syncPeer.PushGetBlocksMsg(locator, &zeroHash)
+	}
+	if err != nil {
+		log.Warnf("Failed to request blocks from peer %s: %v",
+			sm.syncPeer.Addr(), err)
+	}
+}
+
+// handleHeadersSyncStalls disconnects peers which haven't responded to a
+// header sync request within maxStallDuration.
+func (sm *SyncManager) handleHeadersSyncStalls() {
+	for peer, state := range sm.peerStates {
+		hs := state.headersSync
+		if hs == nil || !hs.requested || hs.phase == headersDone {
+			continue
+		}
+		if time.Since(hs.lastRequest) > maxStallDuration {
+			log.Infof("Peer %s stalled syncing headers -- "+
+				"disconnecting", peer.Addr())
+			peer.Disconnect()
+		}
+	}
+}
+
+// headersFirstFinalHash returns the hash of the final header of the list of
+// headers being fetched in headers-first mode.  This is the next checkpoint
+// or, past the final checkpoint, the tip of the header chain being
+// downloaded.
+func (sm *SyncManager) headersFirstFinalHash() *chainhash.Hash {
+	if sm.nextCheckpoint != nil {
+		return sm.nextCheckpoint.Hash
+	}
+	if sm.headersTarget != nil {
+		return &sm.headersTarget.hash
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// makeHeaders returns a chain of numHeaders valid simnet headers on top of the
+// genesis block.  The version is used to create distinct chains.
+func makeHeaders(t *testing.T, numHeaders int, version int32) []*wire.BlockHeader {
+	t.Helper()
+
+	params := &chaincfg.SimNetParams
+	prevHash := *params.GenesisHash
+	timestamp := params.GenesisBlock.Header.Timestamp
+	headers := make([]*wire.BlockHeader, 0, numHeaders)
+	for i := 0; i < numHeaders; i++ {
+		timestamp = timestamp.Add(time.Second)
+		header := &wire.BlockHeader{
+			Version:   version,
+			PrevBlock: prevHash,
+			Timestamp: timestamp,
+			Bits:      params.PowLimitBits,
+		}
+		for blockchain.CheckHeaderProofOfWork(header, params.PowLimit) != nil {
+			header.Nonce++
+		}
+		headers = append(headers, header)
+		prevHash = header.BlockHash()
+	}
+	return headers
+}
+
+// chainWork returns the work of the genesis block plus the work of the first
+// numHeaders of the passed headers.
+func chainWork(headers []*wire.BlockHeader, numHeaders int) *big.Int {
+	params := &chaincfg.SimNetParams
+	work := blockchain.CalcWork(params.GenesisBlock.Header.Bits)
+	for _, header := range headers[:numHeaders] {
+		work.Add(work, blockchain.CalcWork(header.Bits))
+	}
+	return work
+}
+
+// newTestHeadersSyncState returns a header sync state for a simnet header chain
+// forking from the genesis block.
+func newTestHeadersSyncState(minWork *big.Int, timestamp time.Time) *headersSyncState {
+	params := &chaincfg.SimNetParams
+	s := newHeadersSyncState(params, minWork)
+	s.begin(params.GenesisHash, 0, params.PowLimitBits,
+		blockchain.CalcWork(params.GenesisBlock.Header.Bits), timestamp)
+	return s
+}
+
+// syncHeaders drives the passed header sync state with a peer serving the
+// presync chain during the presync and the redownload chain afterwards.  It
+// returns the released headers along with any error.
+func syncHeaders(s *headersSyncState, presyncChain,
+	redownloadChain []*wire.BlockHeader) ([]*wire.BlockHeader, error) {
+
+	serve := func(chain []*wire.BlockHeader, from *chainhash.Hash) []*wire.BlockHeader {
+		start := 0
+		if *from != *chaincfg.SimNetParams.GenesisHash {
+			for i, header := range chain {
+				if header.BlockHash() == *from {
+					start = i + 1
+					break
+				}
+			}
+		}
+		end := start + wire.MaxBlockHeadersPerMsg
+		if end > len(chain) {
+			end = len(chain)
+		}
+		return chain[start:end]
+	}
+
+	var released []*wire.BlockHeader
+	for s.phase != headersDone {
+		chain := redownloadChain
+		if s.phase == headersPresync {
+			chain = presyncChain
+		}
+		headers := serve(chain, s.locatorHash())
+		full := len(headers) == wire.MaxBlockHeadersPerMsg
+		r, err := s.processHeaders(headers, full)
+		if err != nil {
+			return released, err
+		}
+		released = append(released, r...)
+	}
+	return released, nil
+}
+
+// TestHeadersSync ensures the header chain of an honest peer with enough work
+// is presynced, redownloaded and released in full.
+func TestHeadersSync(t *testing.T) {
+	t.Parallel()
+
+	const numHeaders = redownloadBufferSize + headerCommitPeriod
+	headers := makeHeaders(t, numHeaders, 1)
+
+	s := newTestHeadersSyncState(chainWork(headers, numHeaders), time.Now())
+	released, err := syncHeaders(s, headers, headers)
+	if err != nil {
+		t.Fatalf("syncHeaders: unexpected error: %v", err)
+	}
+	if s.lowWork {
+		t.Fatalf("syncHeaders: chain unexpectedly has too little work")
+	}
+	if len(released) != numHeaders {
+		t.Fatalf("syncHeaders: released %d headers, want %d",
+			len(released), numHeaders)
+	}
+	for i, header := range released {
+		if header != headers[i] {
+			t.Fatalf("syncHeaders: mismatched header #%d", i)
+		}
+	}
+}
+
+// TestHeadersSyncLowWork ensures no headers are released for a header chain
+// that doesn't reach the required work.
+func TestHeadersSyncLowWork(t *testing.T) {
+	t.Parallel()
+
+	const numHeaders = wire.MaxBlockHeadersPerMsg + 10
+	headers := makeHeaders(t, numHeaders, 1)
+
+	minWork := chainWork(headers, numHeaders)
+	minWork.Add(minWork, big.NewInt(1))
+	s := newTestHeadersSyncState(minWork, time.Now())
+	released, err := syncHeaders(s, headers, headers)
+	if err != nil {
+		t.Fatalf("syncHeaders: unexpected error: %v", err)
+	}
+	if !s.lowWork {
+		t.Fatalf("syncHeaders: chain unexpectedly has enough work")
+	}
+	if len(released) != 0 {
+		t.Fatalf("syncHeaders: released %d headers, want 0",
+			len(released))
+	}
+}
+
+// TestHeadersSyncCommitmentMismatch ensures a peer serving a different chain
+// during the redownload than during the presync is detected before any of the
+// headers of the different chain are released.
+func TestHeadersSyncCommitmentMismatch(t *testing.T) {
+	t.Parallel()
+
+	const numHeaders = redownloadBufferSize + headerCommitPeriod
+	presyncChain := makeHeaders(t, numHeaders, 1)
+	redownloadChain := makeHeaders(t, numHeaders, 2)
+
+	s := newTestHeadersSyncState(chainWork(presyncChain, numHeaders),
+		time.Now())
+	released, err := syncHeaders(s, presyncChain, redownloadChain)
+	if err == nil {
+		t.Fatalf("syncHeaders: expected commitment mismatch")
+	}
+	if len(released) != 0 {
+		t.Fatalf("syncHeaders: released %d headers of the wrong chain",
+			len(released))
+	}
+}
+
+// TestHeadersSyncMaxCommitments ensures a header chain which is longer than
+// possible given the time since its start is rejected.
+func TestHeadersSyncMaxCommitments(t *testing.T) {
+	t.Parallel()
+
+	headers := makeHeaders(t, headerCommitPeriod, 1)
+
+	// No commitments are possible for a chain starting at the maximum time
+	// in the future.
+	s := newTestHeadersSyncState(chainWork(headers, headerCommitPeriod),
+		time.Now().Add(maxTimeOffset+time.Minute))
+	if _, err := syncHeaders(s, headers, headers); err == nil {
+		t.Fatalf("syncHeaders: expected error for too many commitments")
+	}
+}
+
+// TestHeadersSyncInvalidHeaders ensures headers that don't connect or don't
+// have valid proof of work are rejected.
+func TestHeadersSyncInvalidHeaders(t *testing.T) {
+	t.Parallel()
+
+	headers := makeHeaders(t, 10, 1)
+
+	// Remove a header from the middle of the chain.
+	disconnected := append([]*wire.BlockHeader{}, headers[:5]...)
+	disconnected = append(disconnected, headers[6:]...)
+
+	// Use difficulty bits which encode a negative target.
+	badPoW := *headers[5]
+	badPoW.Bits = 0x207fffff + 1
+	highHash := append([]*wire.BlockHeader{}, headers[:5]...)
+	highHash = append(highHash, &badPoW)
+
+	tests := []struct {
+		name    string
+		headers []*wire.BlockHeader
+	}{
+		{"disconnected", disconnected},
+		{"bad proof of work", highHash},
+	}
+	for _, test := range tests {
+		minWork := chainWork(headers, len(headers))
+		s := newTestHeadersSyncState(minWork, time.Now())
+		_, err := s.processHeaders(test.headers, false)
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
@@ -5,6 +5,8 @@
 package netsync
 
 import (
+	"math/big"
+
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -25,6 +27,8 @@ type PeerNotifier interface {
 	RelayInventory(invVect *wire.InvVect, data interface{})
 
 	TransactionConfirmed(tx *btcutil.Tx)
+
+	Misbehaving(peer *peer.Peer, persistent, transient uint32, reason string)
 }
 
 // Config is a configuration struct used to initialize a new SyncManager.
@@ -37,6 +41,11 @@ type Config struct {
 	DisableCheckpoints bool
 	MaxPeers           int
 
+	// MinChainWork is the minimum cumulative work a header chain must
+	// have before its headers are stored while syncing past the final
+	// checkpoint.
+	MinChainWork *big.Int
+
 	UtreexoCSN            bool
 	UtreexoMN             bool
 	UtreexoWN             bool
@@ -7,6 +7,7 @@ package netsync
 import (
 	"container/list"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"net"
 	"sync"
@@ -48,6 +49,25 @@ const (
 	// stallSampleInterval the interval at which we will check to see if our
 	// sync has stalled.
 	stallSampleInterval = 30 * time.Second
+
+	// banScoreUtreexoProofTargets is the persistent ban score increase for
+	// a peer which sent a ublock with malformed utreexo proof targets.
+	banScoreUtreexoProofTargets = 100
+
+	// banScoreUtreexoLeafDataMismatch is the persistent ban score increase
+	// for a peer which sent a ublock with leaf datas that don't match the
+	// block or the proof.
+	banScoreUtreexoLeafDataMismatch = 100
+
+	// banScoreUtreexoRootsMismatch is the persistent ban score increase for
+	// a peer which sent a ublock with a proof that doesn't match the roots
+	// of the accumulator.
+	banScoreUtreexoRootsMismatch = 50
+
+	// banScoreOrphanUBlock is the transient ban score increase for a peer
+	// which sent a ublock whose parent is unknown.  It only bans peers that
+	// flood orphan ublocks since the score decays over time.
+	banScoreOrphanUBlock = 10
 )
 
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
@@ -176,6 +196,7 @@ type peerSyncState struct {
 	requestedTxns       map[chainhash.Hash]struct{}
 	requestedBlocks     map[chainhash.Hash]struct{}
 	requestedBlocksLock sync.RWMutex
+	headersSync         *headersSyncState
 }
 
 // limitAdd is a helper function for maps that require a maximum limit by
@@ -421,6 +442,15 @@ type SyncManager struct {
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
 
+	// The following fields are used to sync the header chains of peers
+	// past the final checkpoint and download the blocks of the one with
+	// the most work.
+	minChainWork    *big.Int
+	headersSyncMode bool
+	headersSynced   bool
+	headerIndex     map[chainhash.Hash]*headerNode
+	headersTarget   *headerNode
+
 	utreexoCSN            bool
 	utreexoMN             bool
 	utreexoWN             bool
@@ -600,6 +630,14 @@ func (sm *SyncManager) startSync() {
 		bestPeer = equalPeers[rand.Intn(len(equalPeers))]
 	}
 
+	// Past the final checkpoint, sync the header chains of the candidate
+	// peers first and download the blocks of the chain with the most
+	// work rather than trusting a single peer.
+	if sm.headersSyncMode || (sm.shouldSyncHeaders() && len(higherPeers) > 0) {
+		sm.startHeadersSync()
+		return
+	}
+
 	// Start syncing from the best peer if one was selected.
 	if bestPeer != nil {
 		// Clear the requestedBlocks if the sync peer changes, otherwise
@@ -731,6 +769,8 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 			sm.newSyncNum++
 		}
 		sm.startSync()
+	} else if isSyncCandidate && sm.headersSyncMode {
+		sm.startHeadersSync()
 	}
 }
 
@@ -743,6 +783,11 @@ func (sm *SyncManager) handleStallSample() {
 		return
 	}
 
+	// Disconnect peers that stalled syncing their header chains.
+	if sm.headersSyncMode {
+		sm.handleHeadersSyncStalls()
+	}
+
 	// If we don't have an active sync peer, exit early.
 	if sm.syncPeer == nil {
 		return
@@ -806,9 +851,21 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 	sm.clearRequestedState(state)
 
 	if peer == sm.syncPeer {
+		// The blocks from the peer whose scripts are still being
+		// validated are connected since the blocks following them
+		// won't arrive.
+		if err := sm.chain.ConnectPendingBlocks(); err != nil {
+			log.Warnf("Failed to connect the pending blocks from "+
+				"%s: %v", peer, err)
+			rejectPendingBlock(peer, wire.CmdBlock, err)
+		}
+
 		// Update the sync peer. The server has already disconnected the
 		// peer before signaling to the sync manager.
 		sm.updateSyncPeer(false)
+	} else if sm.headersSyncMode && state.headersSync != nil {
+		// Sync headers from another peer in its place.
+		sm.startHeadersSync()
 	}
 }
 
@@ -943,6 +1000,20 @@ func (sm *SyncManager) current() bool {
 	return true
 }
 
+// pipelineScripts returns whether the scripts of a block received from the
+// peer with the passed state can be validated in the background while the block
+// before it is connected.  This is only the case during the initial block
+// download while more blocks are in flight from the peer, since the pending
+// block is connected when the next one is processed.
+func (sm *SyncManager) pipelineScripts(state *peerSyncState,
+	isCheckpointBlock bool) bool {
+
+	if isCheckpointBlock || len(state.requestedBlocks) == 0 {
+		return false
+	}
+	return sm.headersFirstMode || !sm.current()
+}
+
 // handleBlockMsg handles block messages from all peers.
 func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	peer := bmsg.peer
@@ -988,8 +1059,12 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		if firstNodeEl != nil {
 			firstNode := firstNodeEl.Value.(*HeaderNode)
 			if blockHash.IsEqual(firstNode.Hash) {
-				behaviorFlags |= blockchain.BFFastAdd
-				if firstNode.Hash.IsEqual(sm.nextCheckpoint.Hash) {
+				// Blocks past the final checkpoint are fully
+				// validated.
+				if sm.nextCheckpoint != nil {
+					behaviorFlags |= blockchain.BFFastAdd
+				}
+				if firstNode.Hash.IsEqual(sm.headersFirstFinalHash()) {
 					isCheckpointBlock = true
 				} else {
 					sm.headerList.Remove(firstNodeEl)
@@ -1003,10 +1078,21 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	// The scripts of the block are validated while the next one is
+	// processed when possible.
+	if sm.pipelineScripts(state, isCheckpointBlock) {
+		behaviorFlags |= blockchain.BFPipelineScripts
+	}
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
 	_, isOrphan, err := sm.chain.ProcessBlock(bmsg.block, behaviorFlags)
 	if err != nil {
+		// The error may be the one of a block pending script
+		// validation the block descends from, which is reported
+		// against that block as well.
+		rejectPendingBlock(peer, wire.CmdBlock, err)
+
 		// When the error is a rule error, it means the block was simply
 		// rejected as opposed to something actually going wrong, so log
 		// it as such.  Otherwise, something really did go wrong, so log
@@ -1123,29 +1209,47 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	// there is a next checkpoint, get the next round of headers by asking
 	// for headers starting from the block after this one up to the next
 	// checkpoint.
-	prevHeight := sm.nextCheckpoint.Height
-	prevHash := sm.nextCheckpoint.Hash
-	sm.nextCheckpoint = sm.findNextHeaderCheckpoint(prevHeight)
 	if sm.nextCheckpoint != nil {
-		locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
-		err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
-		if err != nil {
-			log.Warnf("Failed to send getheaders message to "+
-				"peer %s: %v", peer.Addr(), err)
+		prevHeight := sm.nextCheckpoint.Height
+		prevHash := sm.nextCheckpoint.Hash
+		sm.nextCheckpoint = sm.findNextHeaderCheckpoint(prevHeight)
+		if sm.nextCheckpoint != nil {
+			locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
+			err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
+			if err != nil {
+				log.Warnf("Failed to send getheaders message to "+
+					"peer %s: %v", peer.Addr(), err)
+				return
+			}
+			log.Infof("Downloading headers for blocks %d to %d from "+
+				"peer %s", prevHeight+1, sm.nextCheckpoint.Height,
+				sm.syncPeer.Addr())
 			return
 		}
-		log.Infof("Downloading headers for blocks %d to %d from "+
-			"peer %s", prevHeight+1, sm.nextCheckpoint.Height,
-			sm.syncPeer.Addr())
-		return
 	}
 
-	// This is headers-first mode, the block is a checkpoint, and there are
-	// no more checkpoints, so switch to normal mode by requesting blocks
-	// from the block after this one up to the end of the chain (zero hash).
+	// This is headers-first mode, the block is the final one of the header
+	// list, and there are no more checkpoints.  When still behind, sync
+	// the header chains of the peers past the final checkpoint.
+	// Otherwise, switch to normal mode by requesting blocks from the block
+	// after this one up to the end of the chain (zero hash).
 	sm.headersFirstMode = false
 	sm.headerList.Init()
-	log.Infof("Reached the final checkpoint -- switching to normal mode")
+	switch {
+	case sm.headersSyncMode:
+		sm.finishHeadersSync()
+		log.Infof("Downloaded the header chain with the most work -- " +
+			"switching to normal mode")
+
+	case sm.shouldSyncHeaders():
+		log.Infof("Reached the final checkpoint -- syncing headers")
+		sm.syncPeer = nil
+		sm.startSync()
+		return
+
+	default:
+		log.Infof("Reached the final checkpoint -- switching to normal mode")
+	}
 	locator := blockchain.BlockLocator([]*chainhash.Hash{blockHash})
 	err = peer.PushGetBlocksMsg(locator, &zeroHash)
 	if err != nil {
@@ -1155,6 +1259,57 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	}
 }
 
+// pendingBlockReject returns the hash of the block pending script validation
+// which turned out to be invalid when the passed error, returned by the
+// processing of a later block, is a PendingBlockError, along with the reject
+// code and reason to report it with.  The last return value is false for other
+// errors.
+func pendingBlockReject(err error) (*chainhash.Hash, wire.RejectCode, string, bool) {
+	perr, ok := err.(blockchain.PendingBlockError)
+	if !ok {
+		return nil, 0, "", false
+	}
+	code, reason := mempool.ErrToRejectErr(perr.Err)
+	return &perr.Hash, code, reason, true
+}
+
+// rejectPendingBlock reports the block pending script validation identified by
+// the passed error, if any, as rejected to the peer.  Blocks are only left
+// pending while they are downloaded from the sync peer and are connected when
+// it goes away, so the peer which sent the later block sent the pending one as
+// well.
+func rejectPendingBlock(peer *peerpkg.Peer, command string, err error) {
+	hash, code, reason, ok := pendingBlockReject(err)
+	if !ok {
+		return
+	}
+	log.Infof("Rejected pending block %v from %s: %v", hash, peer, reason)
+	peer.PushRejectMsg(command, code, reason, hash, false)
+}
+
+// utreexoProofBanScore returns the persistent ban score increase for a peer
+// which sent a ublock that was rejected with the passed error.  It is zero for
+// errors that aren't caused by an invalid utreexo proof.
+func utreexoProofBanScore(err error) uint32 {
+	rerr, ok := err.(blockchain.RuleError)
+	if !ok {
+		return 0
+	}
+
+	switch rerr.ErrorCode {
+	case blockchain.ErrUtreexoProofTargets:
+		return banScoreUtreexoProofTargets
+
+	case blockchain.ErrUtreexoLeafDataMismatch:
+		return banScoreUtreexoLeafDataMismatch
+
+	case blockchain.ErrUtreexoRootsMismatch:
+		return banScoreUtreexoRootsMismatch
+	}
+
+	return 0
+}
+
 // TODO kcalvinalvin: It's really mostly the same procedure with a regular block
 // This isn't the prettiest way
 func (sm *SyncManager) handleUBlockMsg(ubmsg *ublockMsg) {
@@ -1195,8 +1350,12 @@ func (sm *SyncManager) handleUBlockMsg(ubmsg *ublockMsg) {
 		if firstNodeEl != nil {
 			firstNode := firstNodeEl.Value.(*HeaderNode)
 			if blockHash.IsEqual(firstNode.Hash) {
-				behaviorFlags |= blockchain.BFFastAdd
-				if firstNode.Hash.IsEqual(sm.nextCheckpoint.Hash) {
+				// Blocks past the final checkpoint are fully
+				// validated.
+				if sm.nextCheckpoint != nil {
+					behaviorFlags |= blockchain.BFFastAdd
+				}
+				if firstNode.Hash.IsEqual(sm.headersFirstFinalHash()) {
 					isCheckpointBlock = true
 				} else {
 					sm.headerList.Remove(firstNodeEl)
@@ -1210,10 +1369,21 @@ func (sm *SyncManager) handleUBlockMsg(ubmsg *ublockMsg) {
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	// The scripts of the block are validated while the next one is
+	// processed when possible.
+	if sm.pipelineScripts(state, isCheckpointBlock) {
+		behaviorFlags |= blockchain.BFPipelineScripts
+	}
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
 	_, isOrphan, err := sm.chain.ProcessUBlock(ubmsg.ublock, behaviorFlags)
 	if err != nil {
+		// The error may be the one of a ublock pending script
+		// validation the ublock descends from, which is reported
+		// against that ublock as well.
+		rejectPendingBlock(peer, wire.CmdUBlock, err)
+
 		// When the error is a rule error, it means the block was simply
 		// rejected as opposed to something actually going wrong, so log
 		// it as such.  Otherwise, something really did go wrong, so log
@@ -1234,6 +1404,14 @@ func (sm *SyncManager) handleUBlockMsg(ubmsg *ublockMsg) {
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
 		peer.PushRejectMsg(wire.CmdUBlock, code, reason, blockHash, false)
+
+		// Raise the ban score of the peer when the utreexo proof of
+		// the ublock is invalid.
+		if persistent := utreexoProofBanScore(err); persistent > 0 {
+			go sm.peerNotifier.Misbehaving(peer, persistent, 0,
+				fmt.Sprintf("sent invalid ublock %v: %v",
+					blockHash, err))
+		}
 		return
 	}
 
@@ -1270,6 +1448,11 @@ func (sm *SyncManager) handleUBlockMsg(ubmsg *ublockMsg) {
 
 	// Request the parents for the orphan block from the peer that sent it.
 	if isOrphan {
+		// Orphan ublocks aren't kept and each one results in a request
+		// for its parents, so prevent peers from flooding them.
+		go sm.peerNotifier.Misbehaving(peer, 0, banScoreOrphanUBlock,
+			fmt.Sprintf("sent orphan ublock %v", blockHash))
+
 		// We've just received an orphan block from a peer. In order
 		// to update the height of the peer, we try to extract the
 		// block height from the scriptSig of the coinbase transaction.
@@ -1357,29 +1540,47 @@ func (sm *SyncManager) handleUBlockMsg(ubmsg *ublockMsg) {
 	// there is a next checkpoint, get the next round of headers by asking
 	// for headers starting from the block after this one up to the next
 	// checkpoint.
-	prevHeight := sm.nextCheckpoint.Height
-	prevHash := sm.nextCheckpoint.Hash
-	sm.nextCheckpoint = sm.findNextHeaderCheckpoint(prevHeight)
 	if sm.nextCheckpoint != nil {
-		locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
-		err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
-		if err != nil {
-			log.Warnf("Failed to send getheaders message to "+
-				"peer %s: %v", peer.Addr(), err)
+		prevHeight := sm.nextCheckpoint.Height
+		prevHash := sm.nextCheckpoint.Hash
+		sm.nextCheckpoint = sm.findNextHeaderCheckpoint(prevHeight)
+		if sm.nextCheckpoint != nil {
+			locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
+			err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
+			if err != nil {
+				log.Warnf("Failed to send getheaders message to "+
+					"peer %s: %v", peer.Addr(), err)
+				return
+			}
+			log.Infof("Downloading headers for ublocks %d to %d from "+
+				"peer %s", prevHeight+1, sm.nextCheckpoint.Height,
+				sm.syncPeer.Addr())
 			return
 		}
-		log.Infof("Downloading headers for ublocks %d to %d from "+
-			"peer %s", prevHeight+1, sm.nextCheckpoint.Height,
-			sm.syncPeer.Addr())
-		return
 	}
 
-	// This is headers-first mode, the block is a checkpoint, and there are
-	// no more checkpoints, so switch to normal mode by requesting blocks
-	// from the block after this one up to the end of the chain (zero hash).
+	// This is headers-first mode, the block is the final one of the header
+	// list, and there are no more checkpoints.  When still behind, sync
+	// the header chains of the peers past the final checkpoint.
+	// Otherwise, switch to normal mode by requesting blocks from the block
+	// after this one up to the end of the chain (zero hash).
 	sm.headersFirstMode = false
 	sm.headerList.Init()
-	log.Infof("Reached the final checkpoint -- switching to normal mode")
+	switch {
+	case sm.headersSyncMode:
+		sm.finishHeadersSync()
+		log.Infof("Downloaded the header chain with the most work -- " +
+			"switching to normal mode")
+
+	case sm.shouldSyncHeaders():
+		log.Infof("Reached the final checkpoint -- syncing headers")
+		sm.syncPeer = nil
+		sm.startSync()
+		return
+
+	default:
+		log.Infof("Reached the final checkpoint -- switching to normal mode")
+	}
 	locator := blockchain.BlockLocator([]*chainhash.Hash{blockHash})
 	err = peer.PushGetUBlocksMsg(locator, &zeroHash)
 	if err != nil {
@@ -1724,14 +1925,23 @@ func (sm *SyncManager) handleOnlyHeadersMsg(hmsg *headersMsg) bool {
 // requested when performing a headers-first sync.
 func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 	peer := hmsg.peer
-	_, exists := sm.peerStates[peer]
+	state, exists := sm.peerStates[peer]
 	if !exists {
 		log.Warnf("Received headers message from unknown peer %s", peer)
 		return
 	}
 
-	// The remote peer is misbehaving if we didn't request headers.
+	// Headers past the final checkpoint are synced from several peers at
+	// once and handled separately.
 	msg := hmsg.headers
+	if sm.headersSyncMode || (state.headersSync != nil &&
+		state.headersSync.requested) {
+
+		sm.handleHeadersSyncMsg(peer, state, msg.Headers)
+		return
+	}
+
+	// The remote peer is misbehaving if we didn't request headers.
 	numHeaders := len(msg.Headers)
 	if !sm.headersFirstMode {
 		log.Warnf("Got %d unrequested headers from %s -- "+
@@ -1994,6 +2204,15 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 		// for the peer.
 		peer.AddKnownInventory(iv)
 
+		// A utreexo CSN can only download blocks from peers which
+		// serve utreexo proofs.
+		isTx := iv.Type == wire.InvTypeTx || iv.Type == wire.InvTypeWitnessTx
+		if sm.utreexoCSN && !isTx &&
+			peer.Services()&wire.SFNodeUtreexo != wire.SFNodeUtreexo {
+
+			continue
+		}
+
 		// Ignore inventory when we're in headers-first mode.
 		if sm.headersFirstMode {
 			continue
@@ -2656,11 +2875,11 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 	// A block has been connected to the main block chain.
 	case blockchain.NTBlockConnected:
 		var ok bool
-		//var ublock *btcutil.UBlock
+		var ublock *btcutil.UBlock
 		var block *btcutil.Block
 
 		if sm.utreexoCSN {
-			_, ok = notification.Data.(*btcutil.UBlock)
+			ublock, ok = notification.Data.(*btcutil.UBlock)
 		} else {
 			block, ok = notification.Data.(*btcutil.Block)
 		}
@@ -2669,6 +2888,19 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			break
 		}
 
+		// Register the block with the fee estimator, if it exists,
+		// before its transactions are removed from the transaction pool
+		// so that they count as confirmed.  Compact state nodes don't
+		// accept transactions to their pool, so the fee estimator falls
+		// back to the fee rates paid by the transactions of the ublock.
+		if sm.feeEstimator != nil {
+			if sm.utreexoCSN {
+				sm.feeEstimator.RegisterUBlock(ublock)
+			} else {
+				sm.feeEstimator.RegisterBlock(block)
+			}
+		}
+
 		// Remove all of the transactions (except the coinbase) in the
 		// connected block from the transaction pool.  Secondly, remove any
 		// transactions which are now double spends as a result of these
@@ -2685,20 +2917,7 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 				acceptedTxs := sm.txMemPool.ProcessOrphans(tx)
 				sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 			}
-
-			// Register block with the fee estimator, if it exists.
-			if sm.feeEstimator != nil {
-				err := sm.feeEstimator.RegisterBlock(block)
-
-				// If an error is somehow generated then the fee estimator
-				// has entered an invalid state. Since it doesn't know how
-				// to recover, create a new one.
-				if err != nil {
-					sm.feeEstimator = mempool.NewFeeEstimator(
-						mempool.DefaultEstimateFeeMaxRollback,
-						mempool.DefaultEstimateFeeMinRegisteredBlocks)
-				}
-			}
+			sm.txMemPool.BlockConnected(block)
 		}
 
 	// A block has been disconnected from the main block chain.
@@ -2721,11 +2940,6 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 				sm.txMemPool.RemoveTransaction(tx, true)
 			}
 		}
-
-		// Rollback previous block recorded by the fee estimator.
-		if sm.feeEstimator != nil {
-			sm.feeEstimator.Rollback(block.Hash())
-		}
 	}
 }
 
@@ -2976,6 +3190,8 @@ func New(config *Config) (*SyncManager, error) {
 		progressLogger:        newBlockProgressLogger("Processed", log),
 		msgChan:               make(chan interface{}, config.MaxPeers*3),
 		headerList:            list.New(),
+		minChainWork:          config.MinChainWork,
+		headerIndex:           make(map[chainhash.Hash]*headerNode),
 		quit:                  make(chan struct{}),
 		newSyncPeer:           make(chan struct{}),
 		feeEstimator:          config.FeeEstimator,
@@ -2985,6 +3201,10 @@ func New(config *Config) (*SyncManager, error) {
 		utreexoRootVerifyMode: config.UtreexoRootVerifyMode,
 	}
 
+	if sm.minChainWork == nil {
+		sm.minChainWork = sm.chainParams.MinimumChainWork
+	}
+
 	best := sm.chain.BestSnapshot()
 	if !config.DisableCheckpoints {
 		// Initialize the next checkpoint based on the current height.
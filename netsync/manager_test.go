@@ -0,0 +1,335 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mempool"
+	peerpkg "github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/mit-dci/utreexo/accumulator"
+	"github.com/mit-dci/utreexo/btcacc"
+)
+
+// misbehavior houses a ban score increase reported to the peer notifier.
+type misbehavior struct {
+	peer       *peerpkg.Peer
+	persistent uint32
+	transient  uint32
+}
+
+// mockPeerNotifier is a peer notifier which reports ban score increases on a
+// channel.
+type mockPeerNotifier struct {
+	misbehaviors chan misbehavior
+}
+
+func (m *mockPeerNotifier) AnnounceNewTransactions(newTxs []*mempool.TxDesc) {}
+
+func (m *mockPeerNotifier) UpdatePeerHeights(latestBlkHash *chainhash.Hash,
+	latestHeight int32, updateSource *peerpkg.Peer) {
+}
+
+func (m *mockPeerNotifier) RelayInventory(invVect *wire.InvVect, data interface{}) {}
+
+func (m *mockPeerNotifier) TransactionConfirmed(tx *btcutil.Tx) {}
+
+func (m *mockPeerNotifier) Misbehaving(peer *peerpkg.Peer, persistent,
+	transient uint32, reason string) {
+
+	m.misbehaviors <- misbehavior{peer, persistent, transient}
+}
+
+// newTestCSNSyncManager returns a sync manager for a utreexo compact state
+// node on simnet along with the peer notifier it reports to and a function to
+// tear it down.
+func newTestCSNSyncManager(t *testing.T) (*SyncManager, *mockPeerNotifier, func()) {
+	t.Helper()
+
+	dataDir, err := ioutil.TempDir("", "netsync")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	params := &chaincfg.SimNetParams
+	db, err := database.Create("ffldb", filepath.Join(dataDir, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dataDir)
+	}
+
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:               db,
+		UtxoCacheMaxSize: 10 * 1024 * 1024,
+		ChainParams:      params,
+		TimeSource:       blockchain.NewMedianTime(),
+		UtreexoCSN:       true,
+		DataDir:          dataDir,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	notifier := &mockPeerNotifier{misbehaviors: make(chan misbehavior, 1)}
+	sm, err := New(&Config{
+		PeerNotifier:       notifier,
+		Chain:              chain,
+		ChainParams:        params,
+		DisableCheckpoints: true,
+		MaxPeers:           8,
+		UtreexoCSN:         true,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create sync manager: %v", err)
+	}
+	return sm, notifier, teardown
+}
+
+// solveTestBlock returns a simnet block on top of the passed previous block
+// with a coinbase at the passed height followed by the passed transactions.
+func solveTestBlock(t *testing.T, prevHash *chainhash.Hash, prevTime time.Time,
+	height int32, txns ...*wire.MsgTx) *wire.MsgBlock {
+
+	t.Helper()
+
+	params := &chaincfg.SimNetParams
+	script, err := txscript.NewScriptBuilder().AddInt64(int64(height)).
+		AddInt64(0).Script()
+	if err != nil {
+		t.Fatalf("unable to create coinbase script: %v", err)
+	}
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: script,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(blockchain.CalcBlockSubsidy(height,
+		params), []byte{txscript.OP_TRUE}))
+
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   4,
+			PrevBlock: *prevHash,
+			Timestamp: prevTime.Add(time.Second),
+			Bits:      params.PowLimitBits,
+		},
+		Transactions: append([]*wire.MsgTx{coinbase}, txns...),
+	}
+	merkles := blockchain.BuildMerkleTreeStore(
+		btcutil.NewBlock(block).Transactions(), false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	for blockchain.CheckHeaderProofOfWork(&block.Header, params.PowLimit) != nil {
+		block.Header.Nonce++
+	}
+	return block
+}
+
+// TestHandleUBlockMsgMisbehavior ensures peers which send ublocks with invalid
+// utreexo proofs or orphan ublocks have their ban score raised for the class
+// of the failure while peers sending valid ublocks don't.
+func TestHandleUBlockMsgMisbehavior(t *testing.T) {
+	sm, notifier, teardown := newTestCSNSyncManager(t)
+	defer teardown()
+
+	peer := peerpkg.NewInboundPeer(&peerpkg.Config{})
+	sm.peerStates[peer] = &peerSyncState{
+		requestedTxns:   make(map[chainhash.Hash]struct{}),
+		requestedBlocks: make(map[chainhash.Hash]struct{}),
+	}
+
+	// sendUBlock hands the ublock to the sync manager as if it was
+	// requested from and sent by the peer and returns the reported ban
+	// score increase, if any.
+	sendUBlock := func(block *wire.MsgBlock, ud btcacc.UData) *misbehavior {
+		ublock := btcutil.NewUBlock(&wire.MsgUBlock{
+			MsgBlock:    *block,
+			UtreexoData: ud,
+		})
+		sm.peerStates[peer].requestedBlocks[*ublock.Hash()] = struct{}{}
+		sm.handleUBlockMsg(&ublockMsg{ublock: ublock, peer: peer})
+
+		select {
+		case m := <-notifier.misbehaviors:
+			if m.peer != peer {
+				t.Fatalf("ban score raised for the wrong peer")
+			}
+			return &m
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+
+	params := &chaincfg.SimNetParams
+	genesisTime := params.GenesisBlock.Header.Timestamp
+
+	// A valid ublock which adds the coinbase output to the accumulator.
+	block1 := solveTestBlock(t, params.GenesisHash, genesisTime, 1)
+	if m := sendUBlock(block1, btcacc.UData{
+		Height:  1,
+		TxoTTLs: []int32{0},
+	}); m != nil {
+		t.Fatalf("valid ublock: ban score raised by %d/%d",
+			m.persistent, m.transient)
+	}
+	block1Hash := block1.BlockHash()
+	if best := sm.chain.BestSnapshot(); best.Hash != block1Hash {
+		t.Fatalf("valid ublock not connected: best block is %v",
+			best.Hash)
+	}
+
+	// The leaf data of the coinbase output of the first block.
+	coinbaseHash := block1.Transactions[0].TxHash()
+	coinbaseOut := block1.Transactions[0].TxOut[0]
+	leafData := btcacc.LeafData{
+		BlockHash: block1Hash,
+		TxHash:    btcacc.Hash(coinbaseHash),
+		Index:     0,
+		Height:    1,
+		Coinbase:  true,
+		Amt:       coinbaseOut.Value,
+		PkScript:  coinbaseOut.PkScript,
+	}
+	spendTx := wire.NewMsgTx(1)
+	spendTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&coinbaseHash, 0), nil,
+		nil))
+	spendTx.AddTxOut(wire.NewTxOut(coinbaseOut.Value, []byte{txscript.OP_TRUE}))
+
+	// The block of a rejected ublock is known afterwards, so each ublock
+	// spending the coinbase output uses a distinct block.
+	spendBlock := func(n int) *wire.MsgBlock {
+		prevTime := block1.Header.Timestamp.Add(time.Duration(n) *
+			time.Second)
+		return solveTestBlock(t, &block1Hash, prevTime, 2, spendTx)
+	}
+
+	// Leaf data which pays a different amount hashes to a leaf that isn't
+	// in the accumulator.
+	badLeafData := leafData
+	badLeafData.Amt++
+
+	orphan := solveTestBlock(t, &chainhash.Hash{0x01}, genesisTime, 2)
+
+	tests := []struct {
+		name       string
+		block      *wire.MsgBlock
+		ud         btcacc.UData
+		persistent uint32
+		transient  uint32
+	}{{
+		name:  "missing leaf data",
+		block: spendBlock(0),
+		ud: btcacc.UData{
+			Height:  2,
+			TxoTTLs: []int32{0, 0},
+		},
+		persistent: banScoreUtreexoLeafDataMismatch,
+	}, {
+		name:  "missing targets",
+		block: spendBlock(1),
+		ud: btcacc.UData{
+			Height:  2,
+			Stxos:   []btcacc.LeafData{leafData},
+			TxoTTLs: []int32{0, 0},
+		},
+		persistent: banScoreUtreexoProofTargets,
+	}, {
+		name:  "leaf data not hashing to the leaf",
+		block: spendBlock(2),
+		ud: btcacc.UData{
+			Height: 2,
+			AccProof: accumulator.BatchProof{
+				Targets: []uint64{0},
+				Proof:   []accumulator.Hash{leafData.LeafHash()},
+			},
+			Stxos:   []btcacc.LeafData{badLeafData},
+			TxoTTLs: []int32{0, 0},
+		},
+		persistent: banScoreUtreexoLeafDataMismatch,
+	}, {
+		name:  "proof against other roots",
+		block: spendBlock(3),
+		ud: btcacc.UData{
+			Height: 2,
+			AccProof: accumulator.BatchProof{
+				Targets: []uint64{0},
+				Proof:   []accumulator.Hash{badLeafData.LeafHash()},
+			},
+			Stxos:   []btcacc.LeafData{badLeafData},
+			TxoTTLs: []int32{0, 0},
+		},
+		persistent: banScoreUtreexoRootsMismatch,
+	}, {
+		name:      "orphan ublock",
+		block:     orphan,
+		ud:        btcacc.UData{Height: 2, TxoTTLs: []int32{0}},
+		transient: banScoreOrphanUBlock,
+	}}
+
+	for _, test := range tests {
+		m := sendUBlock(test.block, test.ud)
+		if m == nil {
+			t.Errorf("%s: ban score not raised", test.name)
+			continue
+		}
+		if m.persistent != test.persistent || m.transient != test.transient {
+			t.Errorf("%s: ban score raised by %d/%d, want %d/%d",
+				test.name, m.persistent, m.transient,
+				test.persistent, test.transient)
+		}
+	}
+}
+
+// TestPendingBlockReject ensures the failure of a block pending script
+// validation is reported against the hash of that block with the reject code of
+// the rule it violated, while other errors are not reported.
+func TestPendingBlockReject(t *testing.T) {
+	pendingHash := chainhash.Hash{0x01}
+	err := blockchain.PendingBlockError{
+		Hash:   pendingHash,
+		Height: 2,
+		Err: blockchain.RuleError{
+			ErrorCode:   blockchain.ErrScriptValidation,
+			Description: "script failed",
+		},
+	}
+	hash, code, reason, ok := pendingBlockReject(err)
+	if !ok {
+		t.Fatal("pending block error not reported")
+	}
+	if *hash != pendingHash {
+		t.Fatalf("got hash %v, want %v", hash, pendingHash)
+	}
+	if code != wire.RejectInvalid || reason != err.Err.Error() {
+		t.Fatalf("got reject code %v and reason %q, want %v and %q",
+			code, reason, wire.RejectInvalid, err.Err.Error())
+	}
+
+	_, _, _, ok = pendingBlockReject(blockchain.RuleError{
+		ErrorCode: blockchain.ErrInvalidAncestorBlock,
+	})
+	if ok {
+		t.Fatal("rule error reported as a pending block error")
+	}
+}
@@ -55,6 +55,14 @@ var simNetParams = params{
 	rpcPort: "18556",
 }
 
+// sigNetParams contains parameters specific to the default signet test
+// network (wire.SigNet).  Custom signets selected with the signetchallenge
+// option use the same RPC port.
+var sigNetParams = params{
+	Params:  &chaincfg.SigNetParams,
+	rpcPort: "38332",
+}
+
 // netName returns the name used when referring to a bitcoin network.  At the
 // time of writing, btcd currently places blocks for testnet version 3 in the
 // data and log directory "testnet", which does not match the Name field of the
@@ -8,6 +8,7 @@ package peer
 import (
 	"bytes"
 	"container/list"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +22,7 @@ import (
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/v2transport"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/go-socks/socks"
 	"github.com/davecgh/go-spew/spew"
@@ -282,6 +284,13 @@ type Config struct {
 	// TrickleInterval is the duration of the ticker which trickles down the
 	// inventory to a peer.
 	TrickleInterval time.Duration
+
+	// UseV2Transport specifies that the BIP0324 v2 encrypted transport
+	// should be used with the remote peer.  Inbound peers which turn out to
+	// use the v1 transport are still accepted while outbound peers which
+	// fail the v2 handshake are flagged so the caller can reconnect using
+	// the v1 transport.  See V2HandshakeFailed.
+	UseV2Transport bool
 }
 
 // minUint32 is a helper function to return the minimum of two uint32s.
@@ -386,6 +395,8 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	Transport      string
+	SessionID      string
 }
 
 // HashFunc is a function which returns a block hash, height and error
@@ -437,6 +448,18 @@ type Peer struct {
 
 	conn net.Conn
 
+	// connReader is the reader messages are read from.  It is normally the
+	// connection itself, however it is replaced when an inbound peer falls
+	// back to the v1 transport so the bytes consumed while detecting the
+	// transport are not lost.
+	connReader io.Reader
+
+	// v2Transport houses the state of the BIP0324 v2 transport.  It is nil
+	// when the v1 transport is used.  It is set during protocol
+	// negotiation and never modified afterwards.
+	v2Transport       *v2transport.Transport
+	v2HandshakeFailed int32
+
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
 	addr    string
@@ -476,6 +499,7 @@ type Peer struct {
 	lastPingNonce      uint64    // Set to nonce if we have a pending ping.
 	lastPingTime       time.Time // Time we sent last ping.
 	lastPingMicros     int64     // Time for last ping to return.
+	minPingMicros      int64     // Lowest time for a ping to return.
 
 	stallControl  chan stallControlMsg
 	outputQueue   chan outMsg
@@ -531,6 +555,14 @@ func (p *Peer) AddKnownInventory(invVect *wire.InvVect) {
 	p.knownInventory.Add(invVect)
 }
 
+// HasKnownInventory returns whether the passed inventory is in the cache of
+// known inventory for the peer, in which case it is not sent to the peer.
+//
+// This function is safe for concurrent access.
+func (p *Peer) HasKnownInventory(invVect *wire.InvVect) bool {
+	return p.knownInventory.Contains(invVect)
+}
+
 // StatsSnapshot returns a snapshot of the current peer flags and statistics.
 //
 // This function is safe for concurrent access.
@@ -543,6 +575,13 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 	userAgent := p.userAgent
 	services := p.services
 	protocolVersion := p.advertisedProtoVer
+	transport := "v1"
+	var sessionID string
+	if p.v2Transport != nil {
+		transport = "v2"
+		id := p.v2Transport.SessionID()
+		sessionID = hex.EncodeToString(id[:])
+	}
 	p.flagsMtx.Unlock()
 
 	// Get a copy of all relevant flags and stats.
@@ -564,6 +603,8 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		LastPingNonce:  p.lastPingNonce,
 		LastPingMicros: p.lastPingMicros,
 		LastPingTime:   p.lastPingTime,
+		Transport:      transport,
+		SessionID:      sessionID,
 	}
 
 	p.statsMtx.RUnlock()
@@ -674,6 +715,18 @@ func (p *Peer) LastPingMicros() int64 {
 	return lastPingMicros
 }
 
+// MinPingMicros returns the lowest ping micros observed for the remote peer or
+// zero when no ping has returned yet.
+//
+// This function is safe for concurrent access.
+func (p *Peer) MinPingMicros() int64 {
+	p.statsMtx.RLock()
+	minPingMicros := p.minPingMicros
+	p.statsMtx.RUnlock()
+
+	return minPingMicros
+}
+
 // VersionKnown returns the whether or not the version of a peer is known
 // locally.
 //
@@ -819,6 +872,28 @@ func (p *Peer) IsWitnessEnabled() bool {
 	return witnessEnabled
 }
 
+// V2Transport returns true if the BIP0324 v2 encrypted transport is in use
+// with the peer.
+//
+// This function is safe for concurrent access.
+func (p *Peer) V2Transport() bool {
+	p.flagsMtx.Lock()
+	v2 := p.v2Transport != nil
+	p.flagsMtx.Unlock()
+
+	return v2
+}
+
+// V2HandshakeFailed returns true if the peer is an outbound peer for which the
+// v2 transport handshake failed before any bytes were received.  This is a
+// strong indication the remote peer only supports the v1 transport, so the
+// caller should reconnect using it.
+//
+// This function is safe for concurrent access.
+func (p *Peer) V2HandshakeFailed() bool {
+	return atomic.LoadInt32(&p.v2HandshakeFailed) != 0
+}
+
 // PushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.  This function is useful over manually sending the message via
 // QueueMessage since it automatically limits the addresses to the maximum
@@ -1054,6 +1129,9 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 			p.lastPingMicros = time.Since(p.lastPingTime).Nanoseconds()
 			p.lastPingMicros /= 1000 // convert to usec.
 			p.lastPingNonce = 0
+			if p.minPingMicros == 0 || p.lastPingMicros < p.minPingMicros {
+				p.minPingMicros = p.lastPingMicros
+			}
 		}
 		p.statsMtx.Unlock()
 	}
@@ -1061,8 +1139,17 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *Peer) readMessage(encoding wire.MessageEncoding) (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageWithEncodingN(p.conn,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	var n int
+	var msg wire.Message
+	var buf []byte
+	var err error
+	if p.v2Transport != nil {
+		n, msg, buf, err = p.v2Transport.ReadMessage(p.connReader,
+			p.ProtocolVersion(), encoding)
+	} else {
+		n, msg, buf, err = wire.ReadMessageWithEncodingN(p.connReader,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	}
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
@@ -1124,8 +1211,15 @@ func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 	}))
 
 	// Write the message to the peer.
-	n, err := wire.WriteMessageWithEncodingN(p.conn, msg,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	var n int
+	var err error
+	if p.v2Transport != nil {
+		n, err = p.v2Transport.WriteMessage(p.conn, msg,
+			p.ProtocolVersion(), enc)
+	} else {
+		n, err = wire.WriteMessageWithEncodingN(p.conn, msg,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
@@ -2209,6 +2303,64 @@ func (p *Peer) writeLocalVersionMsg() error {
 	return p.writeMessage(localVerMsg, wire.LatestEncoding)
 }
 
+// countingConn wraps the connection of a peer to keep track of the bytes sent
+// and received while negotiating the transport.
+type countingConn struct {
+	p *Peer
+}
+
+// Read reads from the peer connection and adds the bytes read to the total.
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.p.conn.Read(b)
+	atomic.AddUint64(&c.p.bytesReceived, uint64(n))
+	return n, err
+}
+
+// Write writes to the peer connection and adds the bytes written to the total.
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.p.conn.Write(b)
+	atomic.AddUint64(&c.p.bytesSent, uint64(n))
+	return n, err
+}
+
+// negotiateTransport performs the BIP0324 v2 transport handshake with the
+// remote peer.  Inbound peers which are detected to use the v1 transport are
+// switched over to it transparently.  Outbound peers which fail the handshake
+// before sending any data are flagged so the caller can retry using the v1
+// transport.
+func (p *Peer) negotiateTransport() error {
+	transport, err := v2transport.NewTransport(p.cfg.ChainParams.Net,
+		!p.inbound)
+	if err != nil {
+		return err
+	}
+
+	err = transport.Handshake(countingConn{p})
+	switch {
+	case err == nil:
+		p.flagsMtx.Lock()
+		p.v2Transport = transport
+		p.flagsMtx.Unlock()
+		log.Debugf("Negotiated v2 transport with peer %s", p)
+		return nil
+
+	case err == v2transport.ErrUseV1Transport && p.inbound:
+		// Replay the bytes consumed while detecting the transport in
+		// front of the connection.  They are accounted for again when
+		// the version message is read.
+		prefix := transport.V1Prefix()
+		atomic.AddUint64(&p.bytesReceived, ^uint64(len(prefix)-1))
+		p.connReader = io.MultiReader(bytes.NewReader(prefix), p.conn)
+		log.Debugf("Peer %s is using the v1 transport", p)
+		return nil
+	}
+
+	if !p.inbound && atomic.LoadUint64(&p.bytesReceived) == 0 {
+		atomic.StoreInt32(&p.v2HandshakeFailed, 1)
+	}
+	return fmt.Errorf("v2 transport handshake failed: %v", err)
+}
+
 // negotiateInboundProtocol performs the negotiation protocol for an inbound
 // peer. The events should occur in the following order, otherwise an error is
 // returned:
@@ -2264,6 +2416,12 @@ func (p *Peer) start() error {
 
 	negotiateErr := make(chan error, 1)
 	go func() {
+		if p.cfg.UseV2Transport {
+			if err := p.negotiateTransport(); err != nil {
+				negotiateErr <- err
+				return
+			}
+		}
 		if p.inbound {
 			negotiateErr <- p.negotiateInboundProtocol()
 		} else {
@@ -2304,6 +2462,7 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}
 
 	p.conn = conn
+	p.connReader = conn
 	p.timeConnected = time.Now()
 
 	if p.inbound {
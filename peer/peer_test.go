@@ -381,6 +381,132 @@ func TestPeerConnection(t *testing.T) {
 	}
 }
 
+// TestPeerV2Transport tests connections between inbound and outbound peers
+// using the BIP0324 v2 transport as well as the fallback to v1 for peers that
+// don't support it.
+func TestPeerV2Transport(t *testing.T) {
+	verack := make(chan struct{}, 4)
+	pong := make(chan struct{}, 1)
+	v1Cfg := peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnPong: func(p *peer.Peer, msg *wire.MsgPong) {
+				pong <- struct{}{}
+			},
+			OnWrite: func(p *peer.Peer, bytesWritten int, msg wire.Message,
+				err error) {
+				if _, ok := msg.(*wire.MsgVerAck); ok {
+					verack <- struct{}{}
+				}
+			},
+		},
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0",
+		ChainParams:      &chaincfg.MainNetParams,
+		Services:         wire.SFNodeNetwork | wire.SFNodeWitness,
+		TrickleInterval:  time.Second * 10,
+	}
+	v2Cfg := v1Cfg
+	v2Cfg.UseV2Transport = true
+
+	waitVerAcks := func() error {
+		for i := 0; i < 4; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second * 5):
+				return errors.New("verack timeout")
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		inCfg      *peer.Config
+		outCfg     *peer.Config
+		wantV2     bool
+		wantFailed bool
+	}{
+		{"v2 both sides", &v2Cfg, &v2Cfg, true, false},
+		{"v1 outbound to v2 inbound", &v2Cfg, &v1Cfg, false, false},
+		{"v2 outbound to v1 inbound", &v1Cfg, &v2Cfg, false, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for _, test := range tests {
+		inConn, outConn := pipe(
+			&conn{raddr: "10.0.0.1:8333"},
+			&conn{raddr: "10.0.0.2:8333"},
+		)
+		inPeer := peer.NewInboundPeer(test.inCfg)
+		inPeer.AssociateConnection(inConn)
+
+		outPeer, err := peer.NewOutboundPeer(test.outCfg, "10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		outPeer.AssociateConnection(outConn)
+
+		if test.wantFailed {
+			// The v1 peer disconnects upon receiving the v2 key
+			// which must be detected as a failed v2 handshake.
+			outPeer.WaitForDisconnect()
+			inPeer.Disconnect()
+			inPeer.WaitForDisconnect()
+			if !outPeer.V2HandshakeFailed() {
+				t.Errorf("%s: V2HandshakeFailed - got false, "+
+					"want true", test.name)
+			}
+			continue
+		}
+
+		if err := waitVerAcks(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for _, p := range []*peer.Peer{inPeer, outPeer} {
+			if p.V2Transport() != test.wantV2 {
+				t.Errorf("%s: V2Transport - got %v, want %v",
+					test.name, p.V2Transport(), test.wantV2)
+			}
+			if p.V2HandshakeFailed() {
+				t.Errorf("%s: V2HandshakeFailed - got true, "+
+					"want false", test.name)
+			}
+		}
+		inStats := inPeer.StatsSnapshot()
+		outStats := outPeer.StatsSnapshot()
+		if inStats.SessionID != outStats.SessionID {
+			t.Errorf("%s: mismatched session ids - %s != %s",
+				test.name, inStats.SessionID, outStats.SessionID)
+		}
+		if test.wantV2 && inStats.Transport != "v2" {
+			t.Errorf("%s: wrong transport - got %s, want v2",
+				test.name, inStats.Transport)
+		}
+
+		// Ensure messages flow after the handshake.
+		outPeer.QueueMessage(wire.NewMsgPing(1), nil)
+		select {
+		case <-pong:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: pong timeout", test.name)
+		}
+		if inPeer.BytesReceived() != outPeer.BytesSent() {
+			t.Errorf("%s: mismatched byte counts - received %d, "+
+				"sent %d", test.name, inPeer.BytesReceived(),
+				outPeer.BytesSent())
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+		inPeer.WaitForDisconnect()
+		outPeer.WaitForDisconnect()
+	}
+}
+
 // TestPeerListeners tests that the peer listeners are called as expected.
 func TestPeerListeners(t *testing.T) {
 	verack := make(chan struct{}, 1)
@@ -695,7 +821,13 @@ func TestOutboundPeer(t *testing.T) {
 
 	// Should be noops as the peer could not connect.
 	p.QueueInventory(fakeInv)
+	if p.HasKnownInventory(fakeInv) {
+		t.Fatal("inventory known before it was added")
+	}
 	p.AddKnownInventory(fakeInv)
+	if !p.HasKnownInventory(fakeInv) {
+		t.Fatal("added inventory not known")
+	}
 	p.QueueInventory(fakeInv)
 
 	fakeMsg := wire.NewMsgVerAck()
@@ -0,0 +1,271 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// combiner encapsulates the role 'Combiner' as specified in BIP174; it merges
+// the key-value pairs of several PSBTs that describe the same transaction,
+// for example after each of them was handed to a different signer.
+
+import (
+	"bytes"
+)
+
+// Combine merges the passed packets into a new packet holding the union of
+// their key-value pairs. All packets must have the same version and describe
+// the same unsigned transaction, otherwise ErrNotCombinable is returned. If
+// two packets carry different values for the same key, the value of the
+// packet that comes first is kept. The passed packets are not modified.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, ErrInvalidPsbtFormat
+	}
+
+	// Start out from a deep copy of the first packet so none of the
+	// packets passed in end up sharing state with the result.
+	var b bytes.Buffer
+	if err := packets[0].Serialize(&b); err != nil {
+		return nil, err
+	}
+	combined, err := NewFromRawBytes(&b, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packets[1:] {
+		if !sameTransaction(combined, p) {
+			return nil, ErrNotCombinable
+		}
+
+		combined.Unknowns = mergeUnknowns(combined.Unknowns, p.Unknowns)
+		for i := range combined.Inputs {
+			combined.Inputs[i].merge(&p.Inputs[i])
+		}
+		for i := range combined.Outputs {
+			combined.Outputs[i].merge(&p.Outputs[i])
+		}
+	}
+
+	// Merging may have added locktime requirements to the inputs of a
+	// version 2 PSBT.
+	lockTime, err := combined.DetermineLockTime()
+	if err != nil {
+		return nil, err
+	}
+	combined.UnsignedTx.LockTime = lockTime
+
+	if err := combined.SanityCheck(); err != nil {
+		return nil, err
+	}
+
+	return combined, nil
+}
+
+// sameTransaction returns true if the two packets describe the same unsigned
+// transaction. The locktime of version 2 packets is derived from their inputs,
+// so it is not compared for them.
+func sameTransaction(p1, p2 *Packet) bool {
+	tx1, tx2 := p1.UnsignedTx, p2.UnsignedTx
+	switch {
+	case p1.Version != p2.Version:
+		return false
+
+	case len(p1.Inputs) != len(tx1.TxIn) || len(p2.Inputs) != len(tx2.TxIn):
+		return false
+
+	case len(p1.Outputs) != len(tx1.TxOut) ||
+		len(p2.Outputs) != len(tx2.TxOut):
+
+		return false
+
+	case tx1.Version != tx2.Version:
+		return false
+
+	case p1.Version == PsbtVersion0 && tx1.LockTime != tx2.LockTime:
+		return false
+
+	case VerifyInputPrevOutpointsEqual(tx1.TxIn, tx2.TxIn) != nil:
+		return false
+
+	case VerifyOutputsEqual(tx1.TxOut, tx2.TxOut) != nil:
+		return false
+	}
+
+	for i, txIn := range tx1.TxIn {
+		if txIn.Sequence != tx2.TxIn[i].Sequence {
+			return false
+		}
+	}
+
+	return true
+}
+
+// merge adds the key-value pairs of other that are missing from the input.
+func (pi *PInput) merge(other *PInput) {
+	if pi.NonWitnessUtxo == nil {
+		pi.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if pi.WitnessUtxo == nil {
+		pi.WitnessUtxo = other.WitnessUtxo
+	}
+	if pi.SighashType == 0 {
+		pi.SighashType = other.SighashType
+	}
+	if pi.RedeemScript == nil {
+		pi.RedeemScript = other.RedeemScript
+	}
+	if pi.WitnessScript == nil {
+		pi.WitnessScript = other.WitnessScript
+	}
+	if pi.FinalScriptSig == nil {
+		pi.FinalScriptSig = other.FinalScriptSig
+	}
+	if pi.FinalScriptWitness == nil {
+		pi.FinalScriptWitness = other.FinalScriptWitness
+	}
+	if pi.TaprootKeySpendSig == nil {
+		pi.TaprootKeySpendSig = other.TaprootKeySpendSig
+	}
+	if pi.TaprootInternalKey == nil {
+		pi.TaprootInternalKey = other.TaprootInternalKey
+	}
+	if pi.TaprootMerkleRoot == nil {
+		pi.TaprootMerkleRoot = other.TaprootMerkleRoot
+	}
+	if pi.RequiredTimeLocktime == 0 {
+		pi.RequiredTimeLocktime = other.RequiredTimeLocktime
+	}
+	if pi.RequiredHeightLocktime == 0 {
+		pi.RequiredHeightLocktime = other.RequiredHeightLocktime
+	}
+
+	for _, sig := range other.PartialSigs {
+		if !containsPartialSig(pi.PartialSigs, sig) {
+			pi.PartialSigs = append(pi.PartialSigs, sig)
+		}
+	}
+	for _, d := range other.Bip32Derivation {
+		if !containsBip32Derivation(pi.Bip32Derivation, d) {
+			pi.Bip32Derivation = append(pi.Bip32Derivation, d)
+		}
+	}
+	for _, sig := range other.TaprootScriptSpendSig {
+		found := false
+		for _, x := range pi.TaprootScriptSpendSig {
+			if x.EqualKey(sig) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pi.TaprootScriptSpendSig = append(
+				pi.TaprootScriptSpendSig, sig,
+			)
+		}
+	}
+	for _, leaf := range other.TaprootLeafScript {
+		found := false
+		for _, x := range pi.TaprootLeafScript {
+			if bytes.Equal(x.ControlBlock, leaf.ControlBlock) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pi.TaprootLeafScript = append(pi.TaprootLeafScript, leaf)
+		}
+	}
+	pi.TaprootBip32Derivation = mergeTaprootBip32Derivations(
+		pi.TaprootBip32Derivation, other.TaprootBip32Derivation,
+	)
+	pi.Unknowns = mergeUnknowns(pi.Unknowns, other.Unknowns)
+}
+
+// merge adds the key-value pairs of other that are missing from the output.
+func (po *POutput) merge(other *POutput) {
+	if po.RedeemScript == nil {
+		po.RedeemScript = other.RedeemScript
+	}
+	if po.WitnessScript == nil {
+		po.WitnessScript = other.WitnessScript
+	}
+	if po.TaprootInternalKey == nil {
+		po.TaprootInternalKey = other.TaprootInternalKey
+	}
+	if po.TaprootTapTree == nil {
+		po.TaprootTapTree = other.TaprootTapTree
+	}
+
+	for _, d := range other.Bip32Derivation {
+		if !containsBip32Derivation(po.Bip32Derivation, d) {
+			po.Bip32Derivation = append(po.Bip32Derivation, d)
+		}
+	}
+	po.TaprootBip32Derivation = mergeTaprootBip32Derivations(
+		po.TaprootBip32Derivation, other.TaprootBip32Derivation,
+	)
+	po.Unknowns = mergeUnknowns(po.Unknowns, other.Unknowns)
+}
+
+// containsPartialSig returns true if sigs holds a signature for the public key
+// of sig.
+func containsPartialSig(sigs []*PartialSig, sig *PartialSig) bool {
+	for _, x := range sigs {
+		if bytes.Equal(x.PubKey, sig.PubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsBip32Derivation returns true if derivations holds a derivation for
+// the public key of d.
+func containsBip32Derivation(derivations []*Bip32Derivation,
+	d *Bip32Derivation) bool {
+
+	for _, x := range derivations {
+		if bytes.Equal(x.PubKey, d.PubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTaprootBip32Derivations returns the union of the two lists of taproot
+// derivations, keyed by their x-only public key.
+func mergeTaprootBip32Derivations(derivations,
+	other []*TaprootBip32Derivation) []*TaprootBip32Derivation {
+
+	for _, d := range other {
+		found := false
+		for _, x := range derivations {
+			if bytes.Equal(x.XOnlyPubKey, d.XOnlyPubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			derivations = append(derivations, d)
+		}
+	}
+	return derivations
+}
+
+// mergeUnknowns returns the union of the two lists of unknown key-value pairs,
+// keyed by their full key.
+func mergeUnknowns(unknowns, other []*Unknown) []*Unknown {
+	for _, u := range other {
+		found := false
+		for _, x := range unknowns {
+			if bytes.Equal(x.Key, u.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknowns = append(unknowns, u)
+		}
+	}
+	return unknowns
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TestCombine ensures the combiner merges the key-value pairs of packets for
+// the same transaction and refuses packets for different ones.
+func TestCombine(t *testing.T) {
+	key1, key2 := testPrivKey(1), testPrivKey(2)
+
+	packet1 := newSpendingPacket(t, p2wpkhScript(t, key1), 1000)
+	packet1.Inputs[0].Bip32Derivation = []*Bip32Derivation{{
+		PubKey:               key1.PubKey().SerializeCompressed(),
+		MasterKeyFingerprint: 1,
+		Bip32Path:            []uint32{0x80000000},
+	}}
+	packet1.Unknowns = []*Unknown{{Key: []byte{0xfc, 0x01}, Value: []byte{1}}}
+
+	packet2 := newSpendingPacket(t, p2wpkhScript(t, key1), 1000)
+	packet2.Inputs[0].WitnessUtxo = nil
+	packet2.Inputs[0].SighashType = 1
+	packet2.Inputs[0].Bip32Derivation = []*Bip32Derivation{{
+		PubKey:               key2.PubKey().SerializeCompressed(),
+		MasterKeyFingerprint: 2,
+		Bip32Path:            []uint32{0x80000001},
+	}}
+	packet2.Outputs[0].RedeemScript = []byte{0x51}
+	packet2.Unknowns = []*Unknown{
+		{Key: []byte{0xfc, 0x01}, Value: []byte{2}},
+		{Key: []byte{0xfc, 0x02}, Value: []byte{3}},
+	}
+
+	combined, err := Combine(packet1, packet2)
+	if err != nil {
+		t.Fatalf("unable to combine packets: %v", err)
+	}
+
+	pInput := combined.Inputs[0]
+	if pInput.WitnessUtxo == nil || pInput.SighashType != 1 {
+		t.Fatalf("single value input fields not merged")
+	}
+	if len(pInput.Bip32Derivation) != 2 {
+		t.Fatalf("expected 2 derivations, got %d",
+			len(pInput.Bip32Derivation))
+	}
+	if !bytes.Equal(combined.Outputs[0].RedeemScript, []byte{0x51}) {
+		t.Fatalf("output fields not merged")
+	}
+
+	// The first packet wins for conflicting values of the same key.
+	if len(combined.Unknowns) != 2 ||
+		!bytes.Equal(combined.Unknowns[0].Value, []byte{1}) {
+
+		t.Fatalf("unexpected global unknowns %v", combined.Unknowns)
+	}
+
+	// Combining is commutative in the set of keys.
+	reversed, err := Combine(packet2, packet1)
+	if err != nil {
+		t.Fatalf("unable to combine packets: %v", err)
+	}
+	if len(reversed.Inputs[0].Bip32Derivation) != 2 {
+		t.Fatalf("reverse combination lost derivations")
+	}
+
+	// Packets for a different transaction or of a different version
+	// cannot be combined.
+	other := newSpendingPacket(t, p2wpkhScript(t, key1), 1000)
+	other.UnsignedTx.TxIn[0].Sequence = 0
+	if _, err := Combine(packet1, other); err != ErrNotCombinable {
+		t.Fatalf("expected ErrNotCombinable, got %v", err)
+	}
+
+	other = newSpendingPacket(t, p2wpkhScript(t, key1), 1000)
+	other.UnsignedTx.TxOut = append(
+		other.UnsignedTx.TxOut, wire.NewTxOut(1, nil),
+	)
+	other.Outputs = append(other.Outputs, POutput{})
+	if _, err := Combine(packet1, other); err != ErrNotCombinable {
+		t.Fatalf("expected ErrNotCombinable, got %v", err)
+	}
+
+	other = newSpendingPacket(t, p2wpkhScript(t, key1), 1000)
+	other.Version = PsbtVersion2
+	if _, err := Combine(packet1, other); err != ErrNotCombinable {
+		t.Fatalf("expected ErrNotCombinable, got %v", err)
+	}
+}
+
+// TestCombineV2Locktime ensures combining version 2 packets recomputes the
+// locktime from the merged input requirements.
+func TestCombineV2Locktime(t *testing.T) {
+	packet1 := newTestPacketV2(t, 0)
+	packet1.Inputs[0].RequiredHeightLocktime = 100
+
+	packet2 := newTestPacketV2(t, 0)
+	packet2.Inputs[1].RequiredHeightLocktime = 200
+
+	combined, err := Combine(packet1, packet2)
+	if err != nil {
+		t.Fatalf("unable to combine packets: %v", err)
+	}
+	if combined.UnsignedTx.LockTime != 200 {
+		t.Fatalf("expected locktime 200, got %d",
+			combined.UnsignedTx.LockTime)
+	}
+}
@@ -0,0 +1,811 @@
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package psbt is an implementation of Partially Signed Bitcoin
+// Transactions (PSBT). The format is defined in BIP 174:
+// https://github.com/bitcoin/bips/blob/master/bip-0174.mediawiki
+//
+// Both version 0 packets and the version 2 packets defined in BIP 370:
+// https://github.com/bitcoin/bips/blob/master/bip-0370.mediawiki
+// are supported. In either case the unsigned transaction is available as
+// Packet.UnsignedTx so the roles implemented by this package do not need to
+// care about the version of the packet they operate on.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// psbtMagicLength is the length of the magic bytes used to signal the start of
+// a serialized PSBT packet.
+const psbtMagicLength = 5
+
+var (
+	// psbtMagic is the separator.
+	psbtMagic = [psbtMagicLength]byte{0x70,
+		0x73, 0x62, 0x74, 0xff, // = "psbt" + 0xff sep
+	}
+)
+
+// MaxPsbtValueLength is the size of the largest transaction serialization
+// that could be passed in a NonWitnessUtxo field. This is definitely
+// less than 4M.
+const MaxPsbtValueLength = 4000000
+
+// MaxPsbtKeyLength is the length of the largest key that we'll successfully
+// deserialize from the wire. Anything more will return ErrInvalidKeyData.
+const MaxPsbtKeyLength = 10000
+
+const (
+	// PsbtVersion0 is the original PSBT version defined in BIP 174 which
+	// carries the full unsigned transaction in the global section.
+	PsbtVersion0 uint32 = 0
+
+	// PsbtVersion2 is the PSBT version defined in BIP 370 which carries
+	// the transaction fields in the global, input and output sections
+	// instead.
+	PsbtVersion2 uint32 = 2
+)
+
+var (
+
+	// ErrInvalidPsbtFormat is a generic error for any situation in which a
+	// provided Psbt serialization does not conform to the rules of BIP174.
+	ErrInvalidPsbtFormat = errors.New("Invalid PSBT serialization format")
+
+	// ErrDuplicateKey indicates that a passed Psbt serialization is invalid
+	// due to having the same key repeated in the same key-value pair.
+	ErrDuplicateKey = errors.New("Invalid Psbt due to duplicate key")
+
+	// ErrInvalidKeyData indicates that a key-value pair in the PSBT
+	// serialization contains data in the key which is not valid.
+	ErrInvalidKeyData = errors.New("Invalid key data")
+
+	// ErrInvalidMagicBytes indicates that a passed Psbt serialization is
+	// invalid due to having incorrect magic bytes.
+	ErrInvalidMagicBytes = errors.New("Invalid Psbt due to incorrect " +
+		"magic bytes")
+
+	// ErrInvalidRawTxSigned indicates that the raw serialized transaction
+	// in the global section of the passed Psbt serialization is invalid
+	// because it contains scriptSigs/witnesses (i.e. is fully or partially
+	// signed), which is not allowed by BIP174.
+	ErrInvalidRawTxSigned = errors.New("Invalid Psbt, raw transaction " +
+		"must be unsigned.")
+
+	// ErrInvalidPrevOutNonWitnessTransaction indicates that the transaction
+	// hash (i.e. SHA256^2) of the fully serialized previous transaction
+	// provided in the NonWitnessUtxo key-value field doesn't match the
+	// prevout hash in the UnsignedTx field in the PSBT itself.
+	ErrInvalidPrevOutNonWitnessTransaction = errors.New("Prevout hash " +
+		"does not match the provided non-witness utxo serialization")
+
+	// ErrInvalidSignatureForInput indicates that the signature the user is
+	// trying to append to the PSBT is invalid, either because it does
+	// not correspond to the previous transaction hash, or redeem script,
+	// or witness script.
+	// NOTE this does not include ECDSA signature checking.
+	ErrInvalidSignatureForInput = errors.New("Signature does not " +
+		"correspond to this input")
+
+	// ErrInputAlreadyFinalized indicates that the PSBT passed to a
+	// Finalizer already contains the finalized scriptSig or witness.
+	ErrInputAlreadyFinalized = errors.New("Cannot finalize PSBT, " +
+		"finalized scriptSig or scriptWitnes already exists")
+
+	// ErrIncompletePSBT indicates that the Extractor object
+	// was unable to successfully extract the passed Psbt struct because
+	// it is not complete
+	ErrIncompletePSBT = errors.New("PSBT cannot be extracted as it is " +
+		"incomplete")
+
+	// ErrNotFinalizable indicates that the PSBT struct does not have
+	// sufficient data (e.g. signatures) for finalization
+	ErrNotFinalizable = errors.New("PSBT is not finalizable")
+
+	// ErrInvalidSigHashFlags indicates that a signature added to the PSBT
+	// uses Sighash flags that are not in accordance with the requirement
+	// according to the entry in PsbtInSighashType, or otherwise not the
+	// default value (SIGHASH_ALL)
+	ErrInvalidSigHashFlags = errors.New("Invalid Sighash Flags")
+
+	// ErrUnsupportedScriptType indicates that the redeem script or
+	// script witness given is not supported by this codebase, or is
+	// otherwise not valid.
+	ErrUnsupportedScriptType = errors.New("Unsupported script type")
+
+	// ErrUnsupportedVersion indicates that the PSBT declares a version
+	// this package does not know how to handle.
+	ErrUnsupportedVersion = errors.New("Unsupported PSBT version")
+
+	// ErrInvalidLocktime indicates that the required locktimes of the
+	// inputs of a version 2 PSBT cannot all be satisfied at once.
+	ErrInvalidLocktime = errors.New("Inputs of the PSBT have " +
+		"incompatible locktime requirements")
+
+	// ErrNotCombinable indicates that the PSBTs passed to the combiner do
+	// not describe the same unsigned transaction.
+	ErrNotCombinable = errors.New("PSBTs do not refer to the same " +
+		"transaction")
+)
+
+// Unknown is a struct encapsulating a key-value pair for which the key type is
+// unknown by this package; these fields are allowed in both the 'Global' and
+// the 'Input' section of a PSBT.
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// Packet is the actual psbt representation. It is a set of 1 + N + M
+// key-value pair lists, 1 global, defining the unsigned transaction structure
+// with N inputs and M outputs.  These key-value pairs can contain scripts,
+// signatures, key derivations and other transaction-defining data.
+type Packet struct {
+	// UnsignedTx is the decoded unsigned transaction for this PSBT.
+	UnsignedTx *wire.MsgTx // Deserialization of unsigned tx
+
+	// Inputs contains all the information needed to properly sign this
+	// target input within the above transaction.
+	Inputs []PInput
+
+	// Outputs contains all information required to spend any outputs
+	// produced by this PSBT.
+	Outputs []POutput
+
+	// Unknowns are the set of custom types (global only) within this PSBT.
+	Unknowns []*Unknown
+
+	// Version is the PSBT version of the packet, either PsbtVersion0 or
+	// PsbtVersion2.
+	Version uint32
+
+	// FallbackLocktime is the locktime of a version 2 PSBT transaction
+	// if none of its inputs require one. Nil means the field is absent
+	// and a locktime of 0 is used.
+	FallbackLocktime *uint32
+
+	// TxModifiable is the bitfield of a version 2 PSBT signalling which
+	// parts of the transaction may still be modified.
+	TxModifiable uint8
+}
+
+// validateUnsignedTx returns true if the transaction is unsigned.  Note that
+// more basic sanity requirements, such as the presence of inputs and outputs,
+// is implicitly checked in the call to MsgTx.Deserialize().
+func validateUnsignedTX(tx *wire.MsgTx) bool {
+	for _, tin := range tx.TxIn {
+		if len(tin.SignatureScript) != 0 || len(tin.Witness) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewFromUnsignedTx creates a new Psbt struct, without any signatures (i.e.
+// only the global section is non-empty) using the passed unsigned transaction.
+func NewFromUnsignedTx(tx *wire.MsgTx) (*Packet, error) {
+	if !validateUnsignedTX(tx) {
+		return nil, ErrInvalidRawTxSigned
+	}
+
+	inSlice := make([]PInput, len(tx.TxIn))
+	outSlice := make([]POutput, len(tx.TxOut))
+	unknownSlice := make([]*Unknown, 0)
+
+	return &Packet{
+		UnsignedTx: tx,
+		Inputs:     inSlice,
+		Outputs:    outSlice,
+		Unknowns:   unknownSlice,
+	}, nil
+}
+
+// NewFromRawBytes returns a new instance of a Packet struct created by reading
+// from a byte slice. If the format is invalid, an error is returned. If the
+// argument b64 is true, the passed byte slice is decoded from base64 encoding
+// before processing.
+//
+// NOTE: To create a Packet from one's own data, rather than reading in a
+// serialization from a counterparty, one should use a psbt.New.
+func NewFromRawBytes(r io.Reader, b64 bool) (*Packet, error) {
+	// If the PSBT is encoded in bas64, then we'll create a new wrapper
+	// reader that'll allow us to incrementally decode the contents of the
+	// io.Reader.
+	if b64 {
+		based64EncodedReader := r
+		r = base64.NewDecoder(base64.StdEncoding, based64EncodedReader)
+	}
+
+	// The Packet struct does not store the fixed magic bytes, but they
+	// must be present or the serialization must be explicitly rejected.
+	var magic [5]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != psbtMagic {
+		return nil, ErrInvalidMagicBytes
+	}
+
+	// Next we parse the GLOBAL section. A version 0 PSBT carries the
+	// whole unsigned transaction while a version 2 PSBT only carries its
+	// version, locktime and the number of inputs and outputs here.
+	globals, err := readGlobals(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var numInputs, numOutputs int
+	switch globals.version {
+	case PsbtVersion0:
+		if globals.unsignedTx == nil || globals.hasV2Fields() {
+			return nil, ErrInvalidPsbtFormat
+		}
+		numInputs = len(globals.unsignedTx.TxIn)
+		numOutputs = len(globals.unsignedTx.TxOut)
+
+	case PsbtVersion2:
+		if globals.unsignedTx != nil || globals.txVersion == nil ||
+			globals.inputCount == nil || globals.outputCount == nil {
+
+			return nil, ErrInvalidPsbtFormat
+		}
+		if *globals.txVersion < 2 {
+			return nil, ErrInvalidPsbtFormat
+		}
+		numInputs = int(*globals.inputCount)
+		numOutputs = int(*globals.outputCount)
+
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+
+	// Next we parse the INPUT section.
+	inSlice := make([]PInput, numInputs)
+	for i := range inSlice {
+		input := PInput{}
+		err = input.deserialize(r)
+		if err != nil {
+			return nil, err
+		}
+
+		inSlice[i] = input
+	}
+
+	// Next we parse the OUTPUT section.
+	outSlice := make([]POutput, numOutputs)
+	for i := range outSlice {
+		output := POutput{}
+		err = output.deserialize(r)
+		if err != nil {
+			return nil, err
+		}
+
+		outSlice[i] = output
+	}
+
+	// Populate the new Packet object.
+	newPsbt := Packet{
+		UnsignedTx:       globals.unsignedTx,
+		Inputs:           inSlice,
+		Outputs:          outSlice,
+		Unknowns:         globals.unknowns,
+		Version:          globals.version,
+		FallbackLocktime: globals.fallbackLocktime,
+		TxModifiable:     globals.txModifiable,
+	}
+
+	// For version 2 packets the unsigned transaction is assembled from
+	// the per input and output fields, while version 0 packets must not
+	// contain them at all.
+	if globals.version == PsbtVersion2 {
+		newPsbt.UnsignedTx, err = newPsbt.buildUnsignedTx(
+			*globals.txVersion,
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range inSlice {
+			if inSlice[i].hasV2Fields() {
+				return nil, ErrInvalidPsbtFormat
+			}
+		}
+		for i := range outSlice {
+			if outSlice[i].hasV2Fields() {
+				return nil, ErrInvalidPsbtFormat
+			}
+		}
+	}
+
+	// Extended sanity checking is applied here to make sure the
+	// externally-passed Packet follows all the rules.
+	if err = newPsbt.SanityCheck(); err != nil {
+		return nil, err
+	}
+
+	return &newPsbt, nil
+}
+
+// globalFields holds the known key-value pairs of the global section of a
+// serialized PSBT.
+type globalFields struct {
+	unsignedTx       *wire.MsgTx
+	version          uint32
+	txVersion        *int32
+	fallbackLocktime *uint32
+	inputCount       *uint64
+	outputCount      *uint64
+	txModifiable     uint8
+	unknowns         []*Unknown
+
+	hasVersion      bool
+	hasTxModifiable bool
+}
+
+// hasV2Fields returns true if any of the global fields that are only allowed
+// in version 2 PSBTs were read.
+func (g *globalFields) hasV2Fields() bool {
+	return g.txVersion != nil || g.fallbackLocktime != nil ||
+		g.inputCount != nil || g.outputCount != nil ||
+		g.hasTxModifiable
+}
+
+// readGlobals reads the global section of a PSBT up to and including its
+// separator byte.
+func readGlobals(r io.Reader) (*globalFields, error) {
+	var g globalFields
+	for {
+		keyCode, keyData, err := getKey(r)
+		if err != nil {
+			return nil, ErrInvalidPsbtFormat
+		}
+		if keyCode == -1 {
+			break
+		}
+
+		value, err := wire.ReadVarBytes(
+			r, 0, MaxPsbtValueLength, "PSBT value",
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		switch GlobalType(keyCode) {
+		case UnsignedTxType:
+			if g.unsignedTx != nil {
+				return nil, ErrDuplicateKey
+			}
+			if keyData != nil {
+				return nil, ErrInvalidPsbtFormat
+			}
+
+			// BIP-0174 states: "The transaction must be in the old
+			// serialization format (without witnesses)."
+			msgTx := wire.NewMsgTx(2)
+			err = msgTx.DeserializeNoWitness(bytes.NewReader(value))
+			if err != nil {
+				return nil, err
+			}
+			if !validateUnsignedTX(msgTx) {
+				return nil, ErrInvalidRawTxSigned
+			}
+			g.unsignedTx = msgTx
+			continue
+
+		case TxVersionType:
+			if g.txVersion != nil {
+				return nil, ErrDuplicateKey
+			}
+			if keyData != nil || len(value) != 4 {
+				return nil, ErrInvalidKeyData
+			}
+			txVersion := int32(binary.LittleEndian.Uint32(value))
+			g.txVersion = &txVersion
+			continue
+
+		case FallbackLocktimeType:
+			if g.fallbackLocktime != nil {
+				return nil, ErrDuplicateKey
+			}
+			if keyData != nil || len(value) != 4 {
+				return nil, ErrInvalidKeyData
+			}
+			locktime := binary.LittleEndian.Uint32(value)
+			g.fallbackLocktime = &locktime
+			continue
+
+		case InputCountType, OutputCountType:
+			count := &g.inputCount
+			if GlobalType(keyCode) == OutputCountType {
+				count = &g.outputCount
+			}
+			if *count != nil {
+				return nil, ErrDuplicateKey
+			}
+			if keyData != nil {
+				return nil, ErrInvalidKeyData
+			}
+			n, err := wire.ReadVarInt(bytes.NewReader(value), 0)
+			if err != nil || wire.VarIntSerializeSize(n) !=
+				len(value) {
+
+				return nil, ErrInvalidKeyData
+			}
+
+			// Every input and output takes at least a separator
+			// byte, which bounds the count by the packet size.
+			if n > MaxPsbtValueLength {
+				return nil, ErrInvalidKeyData
+			}
+			*count = &n
+			continue
+
+		case TxModifiableType:
+			if g.hasTxModifiable {
+				return nil, ErrDuplicateKey
+			}
+			if keyData != nil || len(value) != 1 {
+				return nil, ErrInvalidKeyData
+			}
+			g.txModifiable = value[0]
+			g.hasTxModifiable = true
+			continue
+
+		case VersionType:
+			if g.hasVersion {
+				return nil, ErrDuplicateKey
+			}
+			if keyData != nil || len(value) != 4 {
+				return nil, ErrInvalidKeyData
+			}
+			g.version = binary.LittleEndian.Uint32(value)
+			g.hasVersion = true
+			continue
+		}
+
+		// Anything else, including global xpubs, is carried along as
+		// an unknown.
+		keyintanddata := []byte{byte(keyCode)}
+		keyintanddata = append(keyintanddata, keyData...)
+
+		for _, x := range g.unknowns {
+			if bytes.Equal(x.Key, keyintanddata) {
+				return nil, ErrDuplicateKey
+			}
+		}
+
+		g.unknowns = append(g.unknowns, &Unknown{
+			Key:   keyintanddata,
+			Value: value,
+		})
+	}
+
+	return &g, nil
+}
+
+// buildUnsignedTx assembles the unsigned transaction of a version 2 PSBT from
+// the fields read into its inputs and outputs.
+func (p *Packet) buildUnsignedTx(txVersion int32) (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx(txVersion)
+	for i := range p.Inputs {
+		pInput := &p.Inputs[i]
+		if pInput.prevTxid == nil || pInput.outputIndex == nil {
+			return nil, ErrInvalidPsbtFormat
+		}
+
+		sequence := uint32(wire.MaxTxInSequenceNum)
+		if pInput.sequence != nil {
+			sequence = *pInput.sequence
+		}
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  *pInput.prevTxid,
+				Index: *pInput.outputIndex,
+			},
+			Sequence: sequence,
+		})
+
+		// The transaction is now the canonical home of these fields.
+		pInput.prevTxid = nil
+		pInput.outputIndex = nil
+		pInput.sequence = nil
+	}
+	for i := range p.Outputs {
+		pOutput := &p.Outputs[i]
+		if pOutput.amount == nil || pOutput.script == nil {
+			return nil, ErrInvalidPsbtFormat
+		}
+
+		tx.AddTxOut(wire.NewTxOut(*pOutput.amount, pOutput.script))
+
+		pOutput.amount = nil
+		pOutput.script = nil
+	}
+
+	p.UnsignedTx = tx
+	lockTime, err := p.DetermineLockTime()
+	if err != nil {
+		return nil, err
+	}
+	tx.LockTime = lockTime
+
+	return tx, nil
+}
+
+// DetermineLockTime computes the locktime of the transaction described by a
+// version 2 PSBT following the rules of BIP 370: if no input requires a
+// locktime the fallback locktime is used. Otherwise the greatest required
+// height is used if every input with a requirement allows a height based
+// locktime, failing that the greatest required time is used if every such
+// input allows a time based locktime. For version 0 packets the locktime of
+// the unsigned transaction is returned unchanged.
+func (p *Packet) DetermineLockTime() (uint32, error) {
+	if p.Version != PsbtVersion2 {
+		return p.UnsignedTx.LockTime, nil
+	}
+
+	var (
+		maxHeight, maxTime     uint32
+		anyRequired            bool
+		allowHeight, allowTime = true, true
+	)
+	for _, pInput := range p.Inputs {
+		hasHeight := pInput.RequiredHeightLocktime != 0
+		hasTime := pInput.RequiredTimeLocktime != 0
+		if !hasHeight && !hasTime {
+			continue
+		}
+		anyRequired = true
+
+		if hasHeight {
+			if pInput.RequiredHeightLocktime > maxHeight {
+				maxHeight = pInput.RequiredHeightLocktime
+			}
+		} else {
+			allowHeight = false
+		}
+		if hasTime {
+			if pInput.RequiredTimeLocktime > maxTime {
+				maxTime = pInput.RequiredTimeLocktime
+			}
+		} else {
+			allowTime = false
+		}
+	}
+
+	switch {
+	case !anyRequired:
+		if p.FallbackLocktime != nil {
+			return *p.FallbackLocktime, nil
+		}
+		return 0, nil
+
+	case allowHeight:
+		return maxHeight, nil
+
+	case allowTime:
+		return maxTime, nil
+
+	default:
+		return 0, ErrInvalidLocktime
+	}
+}
+
+// Serialize creates a binary serialization of the referenced Packet struct
+// with lexicographical ordering (by key) of the subsections.
+func (p *Packet) Serialize(w io.Writer) error {
+	// First we write out the precise set of magic bytes that identify a
+	// valid PSBT transaction.
+	if _, err := w.Write(psbtMagic[:]); err != nil {
+		return err
+	}
+
+	if err := p.serializeGlobals(w); err != nil {
+		return err
+	}
+
+	// Unknown is a special case; we don't have a key type, only a key and
+	// a value field
+	for _, kv := range p.Unknowns {
+		err := serializeKVpair(w, kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// With that our global section is done, so we'll write out the
+	// separator.
+	separator := []byte{0x00}
+	if _, err := w.Write(separator); err != nil {
+		return err
+	}
+
+	for i, pInput := range p.Inputs {
+		var txIn *wire.TxIn
+		if p.Version == PsbtVersion2 {
+			txIn = p.UnsignedTx.TxIn[i]
+		}
+		err := pInput.serialize(w, txIn)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(separator); err != nil {
+			return err
+		}
+	}
+
+	for i, pOutput := range p.Outputs {
+		var txOut *wire.TxOut
+		if p.Version == PsbtVersion2 {
+			txOut = p.UnsignedTx.TxOut[i]
+		}
+		err := pOutput.serialize(w, txOut)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(separator); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serializeGlobals writes the known global key-value pairs of the packet. A
+// version 0 PSBT holds the unsigned transaction itself, while a version 2 PSBT
+// describes it through its version, locktime and input and output counts.
+func (p *Packet) serializeGlobals(w io.Writer) error {
+	switch p.Version {
+	case PsbtVersion0:
+		// Next we prep to write out the unsigned transaction by first
+		// serializing it into an intermediate buffer.
+		serializedTx := bytes.NewBuffer(
+			make([]byte, 0, p.UnsignedTx.SerializeSize()),
+		)
+		err := p.UnsignedTx.SerializeNoWitness(serializedTx)
+		if err != nil {
+			return err
+		}
+
+		// Now that we have the serialized transaction, we'll write it
+		// out to the proper global type.
+		return serializeKVPairWithType(
+			w, uint8(UnsignedTxType), nil, serializedTx.Bytes(),
+		)
+
+	case PsbtVersion2:
+		// The per input and output fields are written from the
+		// unsigned transaction, so it must line up with them.
+		if len(p.UnsignedTx.TxIn) != len(p.Inputs) ||
+			len(p.UnsignedTx.TxOut) != len(p.Outputs) {
+
+			return ErrInvalidPsbtFormat
+		}
+
+	default:
+		return ErrUnsupportedVersion
+	}
+
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(p.UnsignedTx.Version))
+	err := serializeKVPairWithType(w, uint8(TxVersionType), nil, buf[:])
+	if err != nil {
+		return err
+	}
+
+	if p.FallbackLocktime != nil {
+		binary.LittleEndian.PutUint32(buf[:], *p.FallbackLocktime)
+		err := serializeKVPairWithType(
+			w, uint8(FallbackLocktimeType), nil, buf[:],
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	var count bytes.Buffer
+	err = wire.WriteVarInt(&count, 0, uint64(len(p.Inputs)))
+	if err != nil {
+		return err
+	}
+	err = serializeKVPairWithType(
+		w, uint8(InputCountType), nil, count.Bytes(),
+	)
+	if err != nil {
+		return err
+	}
+
+	count.Reset()
+	err = wire.WriteVarInt(&count, 0, uint64(len(p.Outputs)))
+	if err != nil {
+		return err
+	}
+	err = serializeKVPairWithType(
+		w, uint8(OutputCountType), nil, count.Bytes(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if p.TxModifiable != 0 {
+		err := serializeKVPairWithType(
+			w, uint8(TxModifiableType), nil,
+			[]byte{p.TxModifiable},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	binary.LittleEndian.PutUint32(buf[:], p.Version)
+	return serializeKVPairWithType(w, uint8(VersionType), nil, buf[:])
+}
+
+// B64Encode returns the base64 encoding of the serialization of
+// the current PSBT, or an error if the encoding fails.
+func (p *Packet) B64Encode() (string, error) {
+	var b bytes.Buffer
+	if err := p.Serialize(&b); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(b.Bytes()), nil
+}
+
+// IsComplete returns true only if all of the inputs are
+// finalized; this is particularly important in that it decides
+// whether the final extraction to a network serialized signed
+// transaction will be possible.
+func (p *Packet) IsComplete() bool {
+	for i := 0; i < len(p.UnsignedTx.TxIn); i++ {
+		if !isFinalized(p, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// SanityCheck checks conditions on a PSBT to ensure that it obeys the
+// rules of BIP174, and returns true if so, false if not.
+func (p *Packet) SanityCheck() error {
+	if !validateUnsignedTX(p.UnsignedTx) {
+		return ErrInvalidRawTxSigned
+	}
+
+	for _, tin := range p.Inputs {
+		if !tin.IsSane() {
+			return ErrInvalidPsbtFormat
+		}
+	}
+
+	return nil
+}
+
+// GetTxFee returns the transaction fee.  An error is returned if a transaction
+// input does not contain any UTXO information.
+func (p *Packet) GetTxFee() (btcutil.Amount, error) {
+	sumInputs, err := SumUtxoInputValues(p)
+	if err != nil {
+		return 0, err
+	}
+
+	var sumOutputs int64
+	for _, txOut := range p.UnsignedTx.TxOut {
+		sumOutputs += txOut.Value
+	}
+
+	fee := sumInputs - sumOutputs
+	return btcutil.Amount(fee), nil
+}
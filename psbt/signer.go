@@ -0,0 +1,401 @@
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// signer encapsulates the role 'Signer' as specified in BIP174; it controls
+// the insertion of signatures; the Sign() function will attempt to insert
+// signatures using Updater.addPartialSignature, after first ensuring the Psbt
+// is in the correct state.
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// SignOutcome is a enum-like value that expresses the outcome of a call to the
+// Sign method.
+type SignOutcome int
+
+const (
+	// SignSuccesful indicates that the partial signature was successfully
+	// attached.
+	SignSuccesful = 0
+
+	// SignFinalized  indicates that this input is already finalized, so the
+	// provided signature was *not* attached
+	SignFinalized = 1
+
+	// SignInvalid indicates that the provided signature data was not valid.
+	// In this case an error will also be returned.
+	SignInvalid = -1
+)
+
+// Sign allows the caller to sign a PSBT at a particular input; they
+// must provide a signature and a pubkey, both as byte slices; they can also
+// optionally provide both witnessScript and/or redeemScript, otherwise these
+// arguments must be set as nil (and in that case, they must already be present
+// in the PSBT if required for signing to succeed).
+//
+// This serves as a wrapper around Updater.addPartialSignature; it ensures that
+// the redeemScript and witnessScript are updated as needed (note that the
+// Updater is allowed to add redeemScripts and witnessScripts independently,
+// before signing), and ensures that the right form of utxo field
+// (NonWitnessUtxo or WitnessUtxo) is included in the input so that signature
+// insertion (and then finalization) can take place.
+func (u *Updater) Sign(inIndex int, sig []byte, pubKey []byte,
+	redeemScript []byte, witnessScript []byte) (SignOutcome, error) {
+
+	if isFinalized(u.Upsbt, inIndex) {
+		return SignFinalized, nil
+	}
+
+	// Add the witnessScript to the PSBT in preparation.  If it already
+	// exists, it will be overwritten.
+	if witnessScript != nil {
+		err := u.AddInWitnessScript(witnessScript, inIndex)
+		if err != nil {
+			return SignInvalid, err
+		}
+	}
+
+	// Add the redeemScript to the PSBT in preparation.  If it already
+	// exists, it will be overwritten.
+	if redeemScript != nil {
+		err := u.AddInRedeemScript(redeemScript, inIndex)
+		if err != nil {
+			return SignInvalid, err
+		}
+	}
+
+	// At this point, the PSBT must have the requisite witnessScript or
+	// redeemScript fields for signing to succeed.
+	//
+	// Case 1: if witnessScript is present, it must be of type witness;
+	// if not, signature insertion will of course fail.
+	pInput := u.Upsbt.Inputs[inIndex]
+	switch {
+	case pInput.WitnessScript != nil:
+		if pInput.WitnessUtxo == nil {
+			err := nonWitnessToWitness(u.Upsbt, inIndex)
+			if err != nil {
+				return SignInvalid, err
+			}
+		}
+
+		err := u.addPartialSignature(inIndex, sig, pubKey)
+		if err != nil {
+			return SignInvalid, err
+		}
+
+	// Case 2: no witness script, only redeem script; can be legacy p2sh or
+	// p2sh-wrapped p2wkh.
+	case pInput.RedeemScript != nil:
+		// We only need to decide if the input is witness, and we don't
+		// rely on the witnessutxo/nonwitnessutxo in the PSBT, instead
+		// we check the redeemScript content.
+		if txscript.IsWitnessProgram(pInput.RedeemScript) {
+			if pInput.WitnessUtxo == nil {
+				err := nonWitnessToWitness(u.Upsbt, inIndex)
+				if err != nil {
+					return SignInvalid, err
+				}
+			}
+		}
+
+		// If it is not a valid witness program, we here assume that
+		// the provided WitnessUtxo/NonWitnessUtxo field was correct.
+		err := u.addPartialSignature(inIndex, sig, pubKey)
+		if err != nil {
+			return SignInvalid, err
+		}
+
+	// Case 3: Neither provided only works for native p2wkh, or non-segwit
+	// non-p2sh. To check if it's segwit, check the scriptPubKey of the
+	// output.
+	default:
+		if pInput.WitnessUtxo == nil {
+			txIn := u.Upsbt.UnsignedTx.TxIn[inIndex]
+			outIndex := txIn.PreviousOutPoint.Index
+			script := pInput.NonWitnessUtxo.TxOut[outIndex].PkScript
+
+			if txscript.IsWitnessProgram(script) {
+				err := nonWitnessToWitness(u.Upsbt, inIndex)
+				if err != nil {
+					return SignInvalid, err
+				}
+			}
+		}
+
+		err := u.addPartialSignature(inIndex, sig, pubKey)
+		if err != nil {
+			return SignInvalid, err
+		}
+	}
+
+	return SignSuccesful, nil
+}
+
+// nonWitnessToWitness extracts the TxOut from the existing NonWitnessUtxo
+// field in the given PSBT input and sets it as type witness by adding a
+// WitnessUtxo field. See https://github.com/bitcoin/bitcoin/pull/14197.
+func nonWitnessToWitness(p *Packet, inIndex int) error {
+	outIndex := p.UnsignedTx.TxIn[inIndex].PreviousOutPoint.Index
+	txout := p.Inputs[inIndex].NonWitnessUtxo.TxOut[outIndex]
+
+	// For segwit v0 it is unsafe to only rely on the witness UTXO, see
+	// https://github.com/bitcoin/bitcoin/pull/19215, so the NonWitnessUtxo
+	// is kept.  Taproot signatures commit to the amounts of all of the
+	// spent outputs, so it is no longer needed for segwit v1.
+	if txscript.IsPayToTaproot(txout.PkScript) {
+		p.Inputs[inIndex].NonWitnessUtxo = nil
+	}
+
+	u := Updater{
+		Upsbt: p,
+	}
+
+	return u.AddInWitnessUtxo(txout, inIndex)
+}
+
+// SignWithKey produces the signatures the given private key can contribute to
+// the input at inIndex and attaches them to the PSBT, acting as a complete
+// Signer rather than relying on the caller to compute the signature as Sign
+// does. The previous output of the input must be known, and for taproot
+// inputs the previous outputs of all inputs must be known.
+//
+// ECDSA signatures are produced for p2pkh, p2wpkh, p2sh and p2wsh inputs
+// (including nested segwit) using the redeem and witness scripts already
+// present in the input. For taproot inputs a key path signature is produced
+// if the key is the internal key of the output, tweaked by the input's merkle
+// root, and a script path signature is produced for every leaf script of the
+// input that contains the key. If the key cannot sign for the input
+// ErrInvalidSignatureForInput is returned.
+func (u *Updater) SignWithKey(inIndex int,
+	privKey *btcec.PrivateKey) (SignOutcome, error) {
+
+	if inIndex < 0 || inIndex >= len(u.Upsbt.Inputs) {
+		return SignInvalid, ErrInvalidPsbtFormat
+	}
+	if isFinalized(u.Upsbt, inIndex) {
+		return SignFinalized, nil
+	}
+
+	tx := u.Upsbt.UnsignedTx
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		if prevOut := inputUtxo(u.Upsbt, i); prevOut != nil {
+			prevOuts[txIn.PreviousOutPoint] = prevOut
+		}
+	}
+	prevOut, ok := prevOuts[tx.TxIn[inIndex].PreviousOutPoint]
+	if !ok {
+		return SignInvalid, ErrInvalidPsbtFormat
+	}
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+
+	if txscript.IsPayToTaproot(prevOut.PkScript) {
+		if len(prevOuts) != len(tx.TxIn) {
+			return SignInvalid, ErrInvalidPsbtFormat
+		}
+		err := u.signTaproot(inIndex, privKey, sigHashes, fetcher)
+		if err != nil {
+			return SignInvalid, err
+		}
+
+		return SignSuccesful, nil
+	}
+
+	pInput := &u.Upsbt.Inputs[inIndex]
+	hashType := pInput.SighashType
+	if hashType == 0 {
+		hashType = txscript.SigHashAll
+	}
+
+	// Work out the script that is committed to by the signature and
+	// whether the input is spent through the witness.
+	script := prevOut.PkScript
+	if txscript.IsPayToScriptHash(script) {
+		if pInput.RedeemScript == nil {
+			return SignInvalid, ErrInvalidPsbtFormat
+		}
+		script = pInput.RedeemScript
+	}
+	isWitness := txscript.IsWitnessProgram(script)
+	if txscript.IsPayToWitnessScriptHash(script) {
+		if pInput.WitnessScript == nil {
+			return SignInvalid, ErrInvalidPsbtFormat
+		}
+		script = pInput.WitnessScript
+	}
+
+	// Use the serialization of the public key the script refers to,
+	// preferring the compressed one.
+	pubKey := privKey.PubKey().SerializeCompressed()
+	if !scriptContainsKey(script, pubKey) {
+		pubKey = privKey.PubKey().SerializeUncompressed()
+		if isWitness || !scriptContainsKey(script, pubKey) {
+			return SignInvalid, ErrInvalidSignatureForInput
+		}
+	}
+
+	var (
+		sig []byte
+		err error
+	)
+	if isWitness {
+		sig, err = txscript.RawTxInWitnessSignature(
+			tx, sigHashes, inIndex, prevOut.Value, script,
+			hashType, privKey,
+		)
+	} else {
+		sig, err = txscript.RawTxInSignature(
+			tx, inIndex, script, hashType, privKey,
+		)
+	}
+	if err != nil {
+		return SignInvalid, err
+	}
+
+	return u.Sign(inIndex, sig, pubKey, nil, nil)
+}
+
+// signTaproot adds the key path and script path signatures the given key can
+// produce for the taproot input at inIndex.
+func (u *Updater) signTaproot(inIndex int, privKey *btcec.PrivateKey,
+	sigHashes *txscript.TxSigHashes,
+	fetcher txscript.PrevOutputFetcher) error {
+
+	var (
+		tx       = u.Upsbt.UnsignedTx
+		pInput   = &u.Upsbt.Inputs[inIndex]
+		hashType = pInput.SighashType
+		xOnlyKey = schnorr.SerializePubKey(privKey.PubKey())
+		signed   bool
+	)
+
+	// serializeSig appends the sighash type to a signature unless the
+	// default one is used.
+	serializeSig := func(sig *schnorr.Signature) []byte {
+		sigBytes := sig.Serialize()
+		if hashType != txscript.SigHashDefault {
+			sigBytes = append(sigBytes, byte(hashType))
+		}
+		return sigBytes
+	}
+
+	if bytes.Equal(pInput.TaprootInternalKey, xOnlyKey) {
+		tweakedKey, err := txscript.TweakTaprootPrivKey(
+			privKey, pInput.TaprootMerkleRoot,
+		)
+		if err != nil {
+			return err
+		}
+		sigHash, err := txscript.CalcTaprootSignatureHash(
+			sigHashes, hashType, tx, inIndex, fetcher,
+		)
+		if err != nil {
+			return err
+		}
+		sig, err := schnorr.Sign(tweakedKey, sigHash)
+		if err != nil {
+			return err
+		}
+
+		pInput.TaprootKeySpendSig = serializeSig(sig)
+		signed = true
+	}
+
+	for _, leafScript := range pInput.TaprootLeafScript {
+		if !scriptContainsKey(leafScript.Script, xOnlyKey) {
+			continue
+		}
+
+		tapLeaf := txscript.TapLeaf{
+			LeafVersion: leafScript.LeafVersion,
+			Script:      leafScript.Script,
+		}
+		sigHash, err := txscript.CalcTapscriptSignatureHash(
+			sigHashes, hashType, tx, inIndex, fetcher, tapLeaf,
+		)
+		if err != nil {
+			return err
+		}
+		sig, err := schnorr.Sign(privKey, sigHash)
+		if err != nil {
+			return err
+		}
+
+		leafHash := tapLeaf.TapHash()
+		scriptSig := &TaprootScriptSpendSig{
+			XOnlyPubKey: xOnlyKey,
+			LeafHash:    leafHash[:],
+			Signature:   sig.Serialize(),
+			SigHash:     hashType,
+		}
+		signed = true
+
+		// Replace any earlier signature for the same key and leaf.
+		replaced := false
+		for i, existing := range pInput.TaprootScriptSpendSig {
+			if existing.EqualKey(scriptSig) {
+				pInput.TaprootScriptSpendSig[i] = scriptSig
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			pInput.TaprootScriptSpendSig = append(
+				pInput.TaprootScriptSpendSig, scriptSig,
+			)
+		}
+	}
+
+	if !signed {
+		return ErrInvalidSignatureForInput
+	}
+
+	return nil
+}
+
+// inputUtxo returns the previous output spent by the input at inIndex, taken
+// from either its witness or non-witness UTXO field, or nil if neither is set.
+func inputUtxo(p *Packet, inIndex int) *wire.TxOut {
+	pInput := &p.Inputs[inIndex]
+	if pInput.WitnessUtxo != nil {
+		return pInput.WitnessUtxo
+	}
+	if pInput.NonWitnessUtxo != nil {
+		outIndex := p.UnsignedTx.TxIn[inIndex].PreviousOutPoint.Index
+		if int(outIndex) < len(pInput.NonWitnessUtxo.TxOut) {
+			return pInput.NonWitnessUtxo.TxOut[outIndex]
+		}
+	}
+
+	return nil
+}
+
+// scriptContainsKey returns true if the script pushes either the given public
+// key or its hash160.
+func scriptContainsKey(script, pubKey []byte) bool {
+	pushes, err := txscript.PushedData(script)
+	if err != nil {
+		return false
+	}
+
+	keyHash := btcutil.Hash160(pubKey)
+	for _, push := range pushes {
+		if bytes.Equal(push, pubKey) || bytes.Equal(push, keyHash) {
+			return true
+		}
+	}
+
+	return false
+}
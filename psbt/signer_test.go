@@ -0,0 +1,338 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// testPrivKey deterministically derives a private key from the given seed.
+func testPrivKey(seed byte) *btcec.PrivateKey {
+	keyBytes := chainhash.HashB([]byte{seed})
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
+	return privKey
+}
+
+// mustScript builds the script of the passed builder, failing the test on
+// error.
+func mustScript(t *testing.T, builder *txscript.ScriptBuilder) []byte {
+	t.Helper()
+
+	script, err := builder.Script()
+	if err != nil {
+		t.Fatalf("unable to build script: %v", err)
+	}
+	return script
+}
+
+// p2wpkhScript returns the pay-to-witness-pubkey-hash script of the key.
+func p2wpkhScript(t *testing.T, key *btcec.PrivateKey) []byte {
+	pubKeyHash := btcutil.Hash160(key.PubKey().SerializeCompressed())
+	return mustScript(t, txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(pubKeyHash))
+}
+
+// multiSigScript returns a bare 2-of-2 multisig script of the two keys.
+func multiSigScript(t *testing.T, key1, key2 *btcec.PrivateKey) []byte {
+	return mustScript(t, txscript.NewScriptBuilder().
+		AddOp(txscript.OP_2).
+		AddData(key1.PubKey().SerializeCompressed()).
+		AddData(key2.PubKey().SerializeCompressed()).
+		AddOp(txscript.OP_2).
+		AddOp(txscript.OP_CHECKMULTISIG))
+}
+
+// newSpendingPacket returns a packet spending a single output of the given
+// value and script to an OP_TRUE output, with the witness UTXO already filled
+// in.
+func newSpendingPacket(t *testing.T, pkScript []byte, value int64) *Packet {
+	t.Helper()
+
+	prevOut := wire.NewOutPoint(&chainhash.Hash{0x01}, 3)
+	txOut := wire.NewTxOut(value-1000, []byte{txscript.OP_TRUE})
+	packet, err := New(
+		[]*wire.OutPoint{prevOut}, []*wire.TxOut{txOut}, 2, 0,
+		[]uint32{wire.MaxTxInSequenceNum},
+	)
+	if err != nil {
+		t.Fatalf("unable to create packet: %v", err)
+	}
+	packet.Inputs[0].WitnessUtxo = wire.NewTxOut(value, pkScript)
+
+	return packet
+}
+
+// finalizeAndVerify finalizes and extracts the packet and executes the
+// scripts of every input of the resulting transaction.
+func finalizeAndVerify(t *testing.T, packet *Packet) *wire.MsgTx {
+	t.Helper()
+
+	if err := MaybeFinalizeAll(packet); err != nil {
+		t.Fatalf("unable to finalize packet: %v", err)
+	}
+	tx, err := Extract(packet)
+	if err != nil {
+		t.Fatalf("unable to extract transaction: %v", err)
+	}
+
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for i, txIn := range tx.TxIn {
+		prevOuts[txIn.PreviousOutPoint] = inputUtxo(packet, i)
+	}
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i, txIn := range tx.TxIn {
+		prevOut := prevOuts[txIn.PreviousOutPoint]
+		vm, err := txscript.NewEngine(
+			prevOut.PkScript, tx, i, txscript.StandardVerifyFlags,
+			nil, sigHashes, prevOut.Value, fetcher,
+		)
+		if err != nil {
+			t.Fatalf("unable to create engine for input %d: %v",
+				i, err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Fatalf("input %d failed to validate: %v", i, err)
+		}
+	}
+
+	return tx
+}
+
+// TestSignWithKeySegwitV0 ensures that SignWithKey produces valid signatures
+// for legacy and segwit v0 inputs.
+func TestSignWithKeySegwitV0(t *testing.T) {
+	key := testPrivKey(1)
+	pubKey := key.PubKey().SerializeCompressed()
+
+	p2pkh := mustScript(t, txscript.NewScriptBuilder().
+		AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(pubKey)).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG))
+	p2wpkh := p2wpkhScript(t, key)
+	p2shP2wpkh := mustScript(t, txscript.NewScriptBuilder().
+		AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(p2wpkh)).
+		AddOp(txscript.OP_EQUAL))
+
+	tests := []struct {
+		name         string
+		pkScript     []byte
+		redeemScript []byte
+		nonWitness   bool
+	}{{
+		name:       "p2pkh",
+		pkScript:   p2pkh,
+		nonWitness: true,
+	}, {
+		name:     "p2wpkh",
+		pkScript: p2wpkh,
+	}, {
+		name:         "p2sh-p2wpkh",
+		pkScript:     p2shP2wpkh,
+		redeemScript: p2wpkh,
+	}}
+
+	for _, test := range tests {
+		packet := newSpendingPacket(t, test.pkScript, 100000)
+		packet.Inputs[0].RedeemScript = test.redeemScript
+
+		// Legacy inputs need the full previous transaction, so
+		// replace the witness UTXO with one.
+		if test.nonWitness {
+			prevTx := wire.NewMsgTx(2)
+			prevTx.AddTxOut(packet.Inputs[0].WitnessUtxo)
+			prevHash := prevTx.TxHash()
+			packet.UnsignedTx.TxIn[0].PreviousOutPoint =
+				*wire.NewOutPoint(&prevHash, 0)
+			packet.Inputs[0].WitnessUtxo = nil
+			packet.Inputs[0].NonWitnessUtxo = prevTx
+		}
+
+		u := Updater{Upsbt: packet}
+		outcome, err := u.SignWithKey(0, key)
+		if err != nil || outcome != SignSuccesful {
+			t.Fatalf("%s: unable to sign: %v (%v)", test.name,
+				err, outcome)
+		}
+		if len(packet.Inputs[0].PartialSigs) != 1 ||
+			!bytes.Equal(packet.Inputs[0].PartialSigs[0].PubKey,
+				pubKey) {
+
+			t.Fatalf("%s: partial signature not attached",
+				test.name)
+		}
+
+		finalizeAndVerify(t, packet)
+
+		// Signing a finalized input is a no-op.
+		outcome, err = u.SignWithKey(0, key)
+		if err != nil || outcome != SignFinalized {
+			t.Fatalf("%s: expected finalized outcome, got %v (%v)",
+				test.name, outcome, err)
+		}
+	}
+
+	// A key that does not appear in the script must be refused.
+	packet := newSpendingPacket(t, p2wpkh, 100000)
+	u := Updater{Upsbt: packet}
+	_, err := u.SignWithKey(0, testPrivKey(2))
+	if err != ErrInvalidSignatureForInput {
+		t.Fatalf("expected ErrInvalidSignatureForInput, got %v", err)
+	}
+
+	// An input without UTXO information cannot be signed.
+	packet.Inputs[0].WitnessUtxo = nil
+	_, err = u.SignWithKey(0, key)
+	if err != ErrInvalidPsbtFormat {
+		t.Fatalf("expected ErrInvalidPsbtFormat, got %v", err)
+	}
+}
+
+// TestSignWithKeyMultiSigCombine signs a 2-of-2 p2wsh input with each key in
+// its own copy of the packet and combines the two before finalizing.
+func TestSignWithKeyMultiSigCombine(t *testing.T) {
+	key1, key2 := testPrivKey(1), testPrivKey(2)
+	witnessScript := multiSigScript(t, key1, key2)
+	scriptHash := sha256.Sum256(witnessScript)
+	pkScript := mustScript(t, txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(scriptHash[:]))
+
+	packet := newSpendingPacket(t, pkScript, 500000)
+	packet.Inputs[0].WitnessScript = witnessScript
+
+	var b bytes.Buffer
+	if err := packet.Serialize(&b); err != nil {
+		t.Fatalf("unable to serialize packet: %v", err)
+	}
+	packetCopy, err := NewFromRawBytes(&b, false)
+	if err != nil {
+		t.Fatalf("unable to parse packet: %v", err)
+	}
+
+	for _, signer := range []struct {
+		packet *Packet
+		key    *btcec.PrivateKey
+	}{{packet, key1}, {packetCopy, key2}} {
+		u := Updater{Upsbt: signer.packet}
+		if _, err := u.SignWithKey(0, signer.key); err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		if _, err := MaybeFinalize(signer.packet, 0); err == nil {
+			t.Fatalf("finalized with a single signature")
+		}
+	}
+
+	combined, err := Combine(packet, packetCopy)
+	if err != nil {
+		t.Fatalf("unable to combine packets: %v", err)
+	}
+	if len(combined.Inputs[0].PartialSigs) != 2 {
+		t.Fatalf("expected 2 partial signatures, got %d",
+			len(combined.Inputs[0].PartialSigs))
+	}
+	if len(packet.Inputs[0].PartialSigs) != 1 {
+		t.Fatalf("combining modified its input")
+	}
+
+	finalizeAndVerify(t, combined)
+}
+
+// TestSignWithKeyTaproot ensures that SignWithKey produces valid key path and
+// script path signatures for taproot inputs.
+func TestSignWithKeyTaproot(t *testing.T) {
+	internalKey := testPrivKey(1)
+	leafKey := testPrivKey(2)
+
+	leafScript := mustScript(t, txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(leafKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIG))
+	otherScript := []byte{txscript.OP_RETURN}
+	leaves := []txscript.TapLeaf{
+		txscript.NewBaseTapLeaf(leafScript),
+		txscript.NewBaseTapLeaf(otherScript),
+	}
+	merkleRoot, proofs := txscript.AssembleTaprootScriptTree(leaves...)
+	outputKey, err := txscript.ComputeTaprootOutputKey(
+		internalKey.PubKey(), merkleRoot[:],
+	)
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create output script: %v", err)
+	}
+
+	newPacket := func() *Packet {
+		packet := newSpendingPacket(t, pkScript, 200000)
+		packet.Inputs[0].TaprootInternalKey = schnorr.SerializePubKey(
+			internalKey.PubKey(),
+		)
+		packet.Inputs[0].TaprootMerkleRoot = merkleRoot[:]
+		return packet
+	}
+
+	// The key path is spent by the internal key, tweaked with the root of
+	// the script tree.
+	packet := newPacket()
+	u := Updater{Upsbt: packet}
+	if _, err := u.SignWithKey(0, internalKey); err != nil {
+		t.Fatalf("unable to sign key path: %v", err)
+	}
+	if len(packet.Inputs[0].TaprootKeySpendSig) != schnorr.SignatureSize {
+		t.Fatalf("key spend signature not attached")
+	}
+	tx := finalizeAndVerify(t, packet)
+	if len(tx.TxIn[0].Witness) != 1 {
+		t.Fatalf("expected key path witness, got %d elements",
+			len(tx.TxIn[0].Witness))
+	}
+
+	// The script path is spent by the key committed to in the first leaf.
+	controlBlock := txscript.ControlBlock{
+		InternalKey:     internalKey.PubKey(),
+		OutputKeyYIsOdd: outputKey.Y.Bit(0) == 1,
+		LeafVersion:     txscript.BaseLeafVersion,
+		InclusionProof:  proofs[0],
+	}
+	controlBlockBytes := controlBlock.ToBytes()
+
+	packet = newPacket()
+	packet.Inputs[0].TaprootLeafScript = []*TaprootTapLeafScript{{
+		ControlBlock: controlBlockBytes,
+		Script:       leafScript,
+		LeafVersion:  txscript.BaseLeafVersion,
+	}}
+	u = Updater{Upsbt: packet}
+	if _, err := u.SignWithKey(0, leafKey); err != nil {
+		t.Fatalf("unable to sign script path: %v", err)
+	}
+	if len(packet.Inputs[0].TaprootScriptSpendSig) != 1 {
+		t.Fatalf("script spend signature not attached")
+	}
+	tx = finalizeAndVerify(t, packet)
+	if len(tx.TxIn[0].Witness) != 3 {
+		t.Fatalf("expected script path witness, got %d elements",
+			len(tx.TxIn[0].Witness))
+	}
+
+	// A key that is neither the internal key nor part of a leaf cannot
+	// sign.
+	packet = newPacket()
+	u = Updater{Upsbt: packet}
+	_, err = u.SignWithKey(0, testPrivKey(3))
+	if err != ErrInvalidSignatureForInput {
+		t.Fatalf("expected ErrInvalidSignatureForInput, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+)
+
+const (
+	// rebroadcastInitialDelay is the time to wait after startup before the
+	// first round of rebroadcasts.
+	rebroadcastInitialDelay = 5 * time.Minute
+
+	// rebroadcastMaxInterval is the maximum time between two rounds of
+	// rebroadcasts.  The time until the next round is picked at random so
+	// the announcements don't reveal which transactions were submitted
+	// locally.
+	rebroadcastMaxInterval = 30 * time.Minute
+
+	// rebroadcastTopWeight is the weight of the top of a block template
+	// a transaction has to be in to be rebroadcast.  Transactions below it
+	// would not be mined soon either way, so announcing them again only
+	// wastes the bandwidth of the peers.
+	rebroadcastTopWeight = blockchain.MaxBlockWeight * 3 / 4
+)
+
+// rebroadcastTx houses a transaction submitted through the RPC server along
+// with when and to which peers it was announced.  The peers map holds the
+// round of rebroadcasts each peer was last announced the transaction in.
+type rebroadcastTx struct {
+	txDesc        *mempool.TxDesc
+	added         time.Time
+	lastAnnounced time.Time
+	inTemplate    bool
+	peers         map[int32]uint64
+}
+
+// txRebroadcaster keeps track of the transactions submitted through the RPC
+// server which have not made it into a block yet so they can be announced to
+// the peers that have not seen them.  Each transaction is announced at most
+// once per peer in each round of rebroadcasts.  It is safe for concurrent
+// access.
+type txRebroadcaster struct {
+	mtx    sync.Mutex
+	expiry time.Duration
+	round  uint64
+	txns   map[chainhash.Hash]*rebroadcastTx
+}
+
+// rebroadcastTxSnapshot is a snapshot of the rebroadcast state of a tracked
+// transaction.  Expires is the zero time when the transaction never expires.
+type rebroadcastTxSnapshot struct {
+	Hash          chainhash.Hash
+	Added         time.Time
+	LastAnnounced time.Time
+	Expires       time.Time
+	InTemplate    bool
+	Peers         []int32
+}
+
+// newTxRebroadcaster returns a transaction rebroadcaster which stops tracking
+// transactions once they have been tracked for longer than expiry.  An expiry
+// of zero tracks transactions until they are mined or leave the mempool.
+func newTxRebroadcaster(expiry time.Duration) *txRebroadcaster {
+	return &txRebroadcaster{
+		expiry: expiry,
+		txns:   make(map[chainhash.Hash]*rebroadcastTx),
+	}
+}
+
+// add starts tracking the passed transaction.  Adding a transaction which is
+// already tracked keeps its announcements and expiry.
+func (r *txRebroadcaster) add(txD *mempool.TxDesc, now time.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	hash := *txD.Tx.Hash()
+	if rtx, ok := r.txns[hash]; ok {
+		rtx.txDesc = txD
+		return
+	}
+	r.txns[hash] = &rebroadcastTx{
+		txDesc:     txD,
+		added:      now,
+		inTemplate: true,
+		peers:      make(map[int32]uint64),
+	}
+}
+
+// remove stops tracking the transaction with the passed hash if present.
+func (r *txRebroadcaster) remove(hash *chainhash.Hash) {
+	r.mtx.Lock()
+	delete(r.txns, *hash)
+	r.mtx.Unlock()
+}
+
+// count returns the number of tracked transactions.
+func (r *txRebroadcaster) count() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return len(r.txns)
+}
+
+// removePeer forgets the announcements to the peer with the passed id, which
+// must be called once the peer disconnects.
+func (r *txRebroadcaster) removePeer(peerID int32) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, rtx := range r.txns {
+		delete(rtx.peers, peerID)
+	}
+}
+
+// markAnnounced records that the transaction with the passed hash is about to
+// be announced to the peer with the passed id.  It returns false when the
+// transaction is tracked and was already announced to the peer in the current
+// round of rebroadcasts, in which case it must not be announced again.
+func (r *txRebroadcaster) markAnnounced(hash *chainhash.Hash, peerID int32,
+	now time.Time) bool {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rtx, ok := r.txns[*hash]
+	if !ok {
+		return true
+	}
+	if round, ok := rtx.peers[peerID]; ok && round == r.round {
+		return false
+	}
+	rtx.peers[peerID] = r.round
+	rtx.lastAnnounced = now
+	return true
+}
+
+// due starts a new round of rebroadcasts and returns the tracked transactions
+// to announce again, which may be announced once more to every peer.  Only the
+// transactions in the passed top of the block template are returned.
+// Transactions that are no longer in the mempool according to inPool are no
+// longer tracked, and neither are the ones which have been tracked for longer
+// than the expiry, whose hashes are returned.
+func (r *txRebroadcaster) due(now time.Time, top map[chainhash.Hash]struct{},
+	inPool func(*chainhash.Hash) bool) ([]*mempool.TxDesc, []chainhash.Hash) {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.round++
+	var txns []*mempool.TxDesc
+	var expired []chainhash.Hash
+	for hash, rtx := range r.txns {
+		hash := hash
+		if !inPool(&hash) {
+			delete(r.txns, hash)
+			continue
+		}
+		if r.expiry > 0 && now.Sub(rtx.added) >= r.expiry {
+			expired = append(expired, hash)
+			delete(r.txns, hash)
+			continue
+		}
+
+		_, rtx.inTemplate = top[hash]
+		if rtx.inTemplate {
+			txns = append(txns, rtx.txDesc)
+		}
+	}
+	return txns, expired
+}
+
+// snapshot returns a snapshot of the tracked transactions ordered by the time
+// they were added.
+func (r *txRebroadcaster) snapshot() []rebroadcastTxSnapshot {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	snaps := make([]rebroadcastTxSnapshot, 0, len(r.txns))
+	for hash, rtx := range r.txns {
+		snap := rebroadcastTxSnapshot{
+			Hash:          hash,
+			Added:         rtx.added,
+			LastAnnounced: rtx.lastAnnounced,
+			InTemplate:    rtx.inTemplate,
+			Peers:         make([]int32, 0, len(rtx.peers)),
+		}
+		if r.expiry > 0 {
+			snap.Expires = rtx.added.Add(r.expiry)
+		}
+		for id := range rtx.peers {
+			snap.Peers = append(snap.Peers, id)
+		}
+		sort.Slice(snap.Peers, func(i, j int) bool {
+			return snap.Peers[i] < snap.Peers[j]
+		})
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		if snaps[i].Added.Equal(snaps[j].Added) {
+			return snaps[i].Hash.String() < snaps[j].Hash.String()
+		}
+		return snaps[i].Added.Before(snaps[j].Added)
+	})
+	return snaps
+}
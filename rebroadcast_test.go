@@ -0,0 +1,148 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// rebroadcastTestTx returns a transaction descriptor for a unique transaction
+// spending the passed output index.
+func rebroadcastTestTx(index uint32) *mempool.TxDesc {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, index),
+		nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	return &mempool.TxDesc{TxDesc: mining.TxDesc{Tx: btcutil.NewTx(msgTx)}}
+}
+
+// TestTxRebroadcaster ensures transactions are announced once per peer in each
+// round of rebroadcasts, are only rebroadcast while they are in the top of the
+// block template, and are no longer tracked once they leave the mempool or
+// expire, while disconnected peers are forgotten.
+func TestTxRebroadcaster(t *testing.T) {
+	t.Parallel()
+
+	const expiry = time.Hour
+	start := time.Unix(1600000000, 0)
+	r := newTxRebroadcaster(expiry)
+	tx1, tx2, tx3 := rebroadcastTestTx(0), rebroadcastTestTx(1),
+		rebroadcastTestTx(2)
+	r.add(tx1, start)
+	r.add(tx2, start.Add(time.Minute))
+	r.add(tx3, start.Add(2*time.Minute))
+
+	// Untracked transactions can always be announced.
+	other := rebroadcastTestTx(3).Tx.Hash()
+	if !r.markAnnounced(other, 1, start) || !r.markAnnounced(other, 1, start) {
+		t.Fatal("announcement of an untracked transaction refused")
+	}
+
+	// Tracked transactions are announced once per peer in a round.
+	announced := start.Add(3 * time.Minute)
+	if !r.markAnnounced(tx1.Tx.Hash(), 1, announced) {
+		t.Fatal("first announcement to peer 1 refused")
+	}
+	if r.markAnnounced(tx1.Tx.Hash(), 1, announced) {
+		t.Fatal("second announcement to peer 1 allowed")
+	}
+	if !r.markAnnounced(tx1.Tx.Hash(), 2, announced) {
+		t.Fatal("first announcement to peer 2 refused")
+	}
+
+	// Adding a tracked transaction again keeps its announcements.
+	r.add(tx1, announced)
+	if r.markAnnounced(tx1.Tx.Hash(), 2, announced) {
+		t.Fatal("announcement allowed after adding the transaction again")
+	}
+
+	// Only the transactions in the top of the template are due and the
+	// ones that left the mempool are no longer tracked.
+	top := map[chainhash.Hash]struct{}{*tx1.Tx.Hash(): {}}
+	inPool := func(hash *chainhash.Hash) bool {
+		return !hash.IsEqual(tx3.Tx.Hash())
+	}
+	due, expired := r.due(start.Add(10*time.Minute), top, inPool)
+	if len(due) != 1 || due[0] != tx1 || len(expired) != 0 {
+		t.Fatalf("got %d due transactions, want only the first", len(due))
+	}
+	if r.count() != 2 {
+		t.Fatalf("got %d tracked transactions, want 2", r.count())
+	}
+
+	snaps := r.snapshot()
+	want := []rebroadcastTxSnapshot{{
+		Hash:          *tx1.Tx.Hash(),
+		Added:         start,
+		LastAnnounced: announced,
+		Expires:       start.Add(expiry),
+		InTemplate:    true,
+		Peers:         []int32{1, 2},
+	}, {
+		Hash:    *tx2.Tx.Hash(),
+		Added:   start.Add(time.Minute),
+		Expires: start.Add(time.Minute + expiry),
+		Peers:   []int32{},
+	}}
+	if !reflect.DeepEqual(snaps, want) {
+		t.Fatalf("got snapshot %+v, want %+v", snaps, want)
+	}
+
+	// The due transactions can be announced once more to every peer in the
+	// new round.
+	reannounced := start.Add(11 * time.Minute)
+	if !r.markAnnounced(tx1.Tx.Hash(), 1, reannounced) {
+		t.Fatal("announcement to peer 1 refused in a new round")
+	}
+	if r.markAnnounced(tx1.Tx.Hash(), 1, reannounced) {
+		t.Fatal("second announcement to peer 1 allowed in a new round")
+	}
+
+	// Disconnected peers are forgotten.
+	r.removePeer(1)
+	snaps = r.snapshot()
+	if !reflect.DeepEqual(snaps[0].Peers, []int32{2}) ||
+		!snaps[0].LastAnnounced.Equal(reannounced) {
+
+		t.Fatalf("got peers %v announced at %v, want only peer 2 "+
+			"announced at %v", snaps[0].Peers,
+			snaps[0].LastAnnounced, reannounced)
+	}
+
+	// Transactions are no longer tracked once they expire.
+	top[*tx2.Tx.Hash()] = struct{}{}
+	due, expired = r.due(start.Add(expiry+30*time.Second), top, inPool)
+	if len(due) != 1 || due[0] != tx2 {
+		t.Fatalf("got %d due transactions, want only the second",
+			len(due))
+	}
+	if len(expired) != 1 || expired[0] != *tx1.Tx.Hash() {
+		t.Fatalf("got expired transactions %v, want only the first",
+			expired)
+	}
+	r.remove(tx2.Tx.Hash())
+	if r.count() != 0 {
+		t.Fatalf("got %d tracked transactions, want none", r.count())
+	}
+
+	// A zero expiry tracks transactions until they leave the mempool.
+	r = newTxRebroadcaster(0)
+	r.add(tx1, start)
+	due, _ = r.due(start.Add(365*24*time.Hour), top, inPool)
+	if len(due) != 1 {
+		t.Fatalf("got %d due transactions, want 1", len(due))
+	}
+	if snaps := r.snapshot(); !snaps[0].Expires.IsZero() {
+		t.Fatalf("got expiry %v, want none", snaps[0].Expires)
+	}
+}
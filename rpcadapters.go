@@ -6,6 +6,7 @@ package main
 
 import (
 	"sync/atomic"
+	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -61,6 +62,16 @@ func (p *rpcPeer) FeeFilter() int64 {
 	return atomic.LoadInt64(&(*serverPeer)(p).feeFilter)
 }
 
+// ByteCounters returns snapshots of the bytes sent to and received from the
+// peer per message type.
+//
+// This function is safe for concurrent access and is part of the rpcserverPeer
+// interface implementation.
+func (p *rpcPeer) ByteCounters() (byteCountersSnapshot, byteCountersSnapshot) {
+	sp := (*serverPeer)(p)
+	return sp.sentCounters.snapshot(), sp.recvCounters.snapshot()
+}
+
 // rpcConnManager provides a connection manager for use with the RPC server and
 // implements the rpcserverConnManager interface.
 type rpcConnManager struct {
@@ -164,6 +175,24 @@ func (cm *rpcConnManager) NetTotals() (uint64, uint64) {
 	return cm.server.NetTotals()
 }
 
+// ByteCounters returns snapshots of the bytes sent to and received from all
+// peers per message type.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) ByteCounters() (byteCountersSnapshot, byteCountersSnapshot) {
+	return cm.server.sentCounters.snapshot(),
+		cm.server.recvCounters.snapshot()
+}
+
+// UploadTarget returns a snapshot of the state of the upload target.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) UploadTarget() uploadTargetSnapshot {
+	return cm.server.uploadTarget.snapshot(time.Now())
+}
+
 // ConnectedPeers returns an array consisting of all connected peers.
 //
 // This function is safe for concurrent access and is part of the
@@ -217,6 +246,15 @@ func (cm *rpcConnManager) AddRebroadcastInventory(iv *wire.InvVect, data interfa
 	cm.server.AddRebroadcastInventory(iv, data)
 }
 
+// RebroadcastInfo returns snapshots of the rebroadcast state of the
+// transactions submitted through the RPC server which haven't been mined yet.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) RebroadcastInfo() []rebroadcastTxSnapshot {
+	return cm.server.rebroadcaster.snapshot()
+}
+
 // RelayTransactions generates and relays inventory vectors for all of the
 // passed transactions to all connected peers.
 func (cm *rpcConnManager) RelayTransactions(txns []*mempool.TxDesc) {
@@ -286,3 +324,12 @@ func (b *rpcSyncMgr) SyncPeerID() int32 {
 func (b *rpcSyncMgr) LocateHeaders(locators []*chainhash.Hash, hashStop *chainhash.Hash) []wire.BlockHeader {
 	return b.server.chain.LocateHeaders(locators, hashStop)
 }
+
+// FetchUBlock requests the ublock with the passed hash from the peers serving
+// utreexo proofs and returns it without processing it.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverSyncManager interface implementation.
+func (b *rpcSyncMgr) FetchUBlock(hash *chainhash.Hash) (*btcutil.UBlock, error) {
+	return b.server.FetchUBlock(hash)
+}
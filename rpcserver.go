@@ -10,6 +10,7 @@ import (
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -21,6 +22,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -34,14 +36,18 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/descriptors"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/mining"
 	"github.com/btcsuite/btcd/mining/cpuminer"
 	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/psbt"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/btcsuite/websocket"
+	"github.com/mit-dci/utreexo/btcacc"
 )
 
 // API version constants
@@ -101,6 +107,13 @@ var (
 	// declared here to avoid the overhead of creating the slice on every
 	// invocation for constant data.
 	gbtCapabilities = []string{"proposal"}
+
+	// gbtUnforcedRules are the versionbits deployments which change the
+	// block template in ways clients need to understand in order to build
+	// valid blocks.  They are only signalled on behalf of clients which
+	// explicitly support them.  It is declared here to avoid the overhead
+	// of creating the map on every invocation for constant data.
+	gbtUnforcedRules = map[string]struct{}{"segwit": {}}
 )
 
 // Errors
@@ -127,51 +140,70 @@ type commandHandler func(*rpcServer, interface{}, <-chan struct{}) (interface{},
 // a dependency loop.
 var rpcHandlers map[string]commandHandler
 var rpcHandlersBeforeInit = map[string]commandHandler{
-	"addnode":              handleAddNode,
-	"createrawtransaction": handleCreateRawTransaction,
-	"debuglevel":           handleDebugLevel,
-	"decoderawtransaction": handleDecodeRawTransaction,
-	"decodescript":         handleDecodeScript,
-	"estimatefee":          handleEstimateFee,
-	"generate":             handleGenerate,
-	"getaddednodeinfo":     handleGetAddedNodeInfo,
-	"getbestblock":         handleGetBestBlock,
-	"getbestblockhash":     handleGetBestBlockHash,
-	"getblock":             handleGetBlock,
-	"getblockchaininfo":    handleGetBlockChainInfo,
-	"getblockcount":        handleGetBlockCount,
-	"getblockhash":         handleGetBlockHash,
-	"getblockheader":       handleGetBlockHeader,
-	"getblocktemplate":     handleGetBlockTemplate,
-	"getcfilter":           handleGetCFilter,
-	"getcfilterheader":     handleGetCFilterHeader,
-	"getconnectioncount":   handleGetConnectionCount,
-	"getcurrentnet":        handleGetCurrentNet,
-	"getdifficulty":        handleGetDifficulty,
-	"getgenerate":          handleGetGenerate,
-	"gethashespersec":      handleGetHashesPerSec,
-	"getheaders":           handleGetHeaders,
-	"getinfo":              handleGetInfo,
-	"getmempoolinfo":       handleGetMempoolInfo,
-	"getmininginfo":        handleGetMiningInfo,
-	"getnettotals":         handleGetNetTotals,
-	"getnetworkhashps":     handleGetNetworkHashPS,
-	"getnodeaddresses":     handleGetNodeAddresses,
-	"getpeerinfo":          handleGetPeerInfo,
-	"getrawmempool":        handleGetRawMempool,
-	"getrawtransaction":    handleGetRawTransaction,
-	"gettxout":             handleGetTxOut,
+	"addnode":               handleAddNode,
+	"combinepsbt":           handleCombinePsbt,
+	"createpsbt":            handleCreatePsbt,
+	"createrawtransaction":  handleCreateRawTransaction,
+	"debuglevel":            handleDebugLevel,
+	"debugscript":           handleDebugScript,
+	"decodepsbt":            handleDecodePsbt,
+	"decoderawtransaction":  handleDecodeRawTransaction,
+	"decodescript":          handleDecodeScript,
+	"deriveaddresses":       handleDeriveAddresses,
+	"estimatefee":           handleEstimateFee,
+	"estimatesmartfee":      handleEstimateSmartFee,
+	"finalizepsbt":          handleFinalizePsbt,
+	"generate":              handleGenerate,
+	"getaddednodeinfo":      handleGetAddedNodeInfo,
+	"getbestblock":          handleGetBestBlock,
+	"getbestblockhash":      handleGetBestBlockHash,
+	"getblock":              handleGetBlock,
+	"getblockchaininfo":     handleGetBlockChainInfo,
+	"getblockcount":         handleGetBlockCount,
+	"getblockhash":          handleGetBlockHash,
+	"getblockheader":        handleGetBlockHeader,
+	"getblocktemplate":      handleGetBlockTemplate,
+	"getcfilter":            handleGetCFilter,
+	"getcfilterheader":      handleGetCFilterHeader,
+	"getconnectioncount":    handleGetConnectionCount,
+	"getcurrentnet":         handleGetCurrentNet,
+	"getdescriptorinfo":     handleGetDescriptorInfo,
+	"getdifficulty":         handleGetDifficulty,
+	"getgenerate":           handleGetGenerate,
+	"gethashespersec":       handleGetHashesPerSec,
+	"getheaders":            handleGetHeaders,
+	"getinfo":               handleGetInfo,
+	"getmempoolancestors":   handleGetMempoolAncestors,
+	"getmempooldescendants": handleGetMempoolDescendants,
+	"getmempoolentry":       handleGetMempoolEntry,
+	"getmempoolinfo":        handleGetMempoolInfo,
+	"getmininginfo":         handleGetMiningInfo,
+	"getnettotals":          handleGetNetTotals,
+	"getnetworkhashps":      handleGetNetworkHashPS,
+	"getnodeaddresses":      handleGetNodeAddresses,
+	"getpeerinfo":           handleGetPeerInfo,
+	"getrawmempool":         handleGetRawMempool,
+	"getrawtransaction":     handleGetRawTransaction,
+	"getrebroadcastinfo":    handleGetRebroadcastInfo,
+	"gettxout":              handleGetTxOut,
 	//"getttl":                 handleGetTTL,
 	"help":                   handleHelp,
+	"loadmempool":            handleLoadMempool,
 	"node":                   handleNode,
 	"ping":                   handlePing,
+	"prioritisetransaction":  handlePrioritiseTransaction,
+	"savemempool":            handleSaveMempool,
+	"scantxoutset":           handleScanTxOutSet,
 	"searchrawtransactions":  handleSearchRawTransactions,
 	"sendrawtransaction":     handleSendRawTransaction,
 	"setgenerate":            handleSetGenerate,
 	"signmessagewithprivkey": handleSignMessageWithPrivKey,
 	"stop":                   handleStop,
 	"submitblock":            handleSubmitBlock,
+	"submitpackage":          handleSubmitPackage,
+	"testmempoolaccept":      handleTestMempoolAccept,
 	"uptime":                 handleUptime,
+	"utxoupdatepsbt":         handleUtxoUpdatePsbt,
 	"validateaddress":        handleValidateAddress,
 	"verifychain":            handleVerifyChain,
 	"verifymessage":          handleVerifyMessage,
@@ -230,7 +262,6 @@ var rpcAskWallet = map[string]struct{}{
 var rpcUnimplemented = map[string]struct{}{
 	"estimatepriority": {},
 	"getchaintips":     {},
-	"getmempoolentry":  {},
 	"getnetworkinfo":   {},
 	"getwork":          {},
 	"invalidateblock":  {},
@@ -254,10 +285,17 @@ var rpcLimited = map[string]struct{}{
 	"help": {},
 
 	// HTTP/S-only commands
+	"combinepsbt":           {},
+	"createpsbt":            {},
 	"createrawtransaction":  {},
+	"debugscript":           {},
+	"decodepsbt":            {},
 	"decoderawtransaction":  {},
 	"decodescript":          {},
+	"deriveaddresses":       {},
 	"estimatefee":           {},
+	"estimatesmartfee":      {},
+	"finalizepsbt":          {},
 	"getbestblock":          {},
 	"getbestblockhash":      {},
 	"getblock":              {},
@@ -267,18 +305,26 @@ var rpcLimited = map[string]struct{}{
 	"getcfilter":            {},
 	"getcfilterheader":      {},
 	"getcurrentnet":         {},
+	"getdescriptorinfo":     {},
 	"getdifficulty":         {},
 	"getheaders":            {},
 	"getinfo":               {},
+	"getmempoolancestors":   {},
+	"getmempooldescendants": {},
+	"getmempoolentry":       {},
 	"getnettotals":          {},
 	"getnetworkhashps":      {},
 	"getrawmempool":         {},
 	"getrawtransaction":     {},
 	"gettxout":              {},
+	"scantxoutset":          {},
 	"searchrawtransactions": {},
 	"sendrawtransaction":    {},
 	"submitblock":           {},
+	"submitpackage":         {},
+	"testmempoolaccept":     {},
 	"uptime":                {},
+	"utxoupdatepsbt":        {},
 	"validateaddress":       {},
 	"verifymessage":         {},
 	"version":               {},
@@ -327,6 +373,14 @@ func rpcNoTxInfoError(txHash *chainhash.Hash) *btcjson.RPCError {
 			txHash))
 }
 
+// rpcNotInMempoolError is a convenience function for returning a nicely
+// formatted RPC error which indicates the provided transaction is not in the
+// memory pool.
+func rpcNotInMempoolError() *btcjson.RPCError {
+	return btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey,
+		"Transaction not in mempool")
+}
+
 // gbtWorkState houses state that is used in between multiple RPC invocations to
 // getblocktemplate.
 type gbtWorkState struct {
@@ -511,6 +565,229 @@ func messageToHex(msg wire.Message) (string, error) {
 	return hex.EncodeToString(buf.Bytes()), nil
 }
 
+// decodePsbt parses the passed base64-encoded PSBT and converts any failure to
+// an RPC error.
+func decodePsbt(b64Psbt string) (*psbt.Packet, error) {
+	packet, err := psbt.NewFromRawBytes(strings.NewReader(b64Psbt), true)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCDeserialization,
+			Message: "TX decode failed: " + err.Error(),
+		}
+	}
+	return packet, nil
+}
+
+// encodePsbt returns the base64 encoding of the passed PSBT.
+func encodePsbt(packet *psbt.Packet) (string, error) {
+	b64Psbt, err := packet.B64Encode()
+	if err != nil {
+		context := "Failed to encode PSBT"
+		return "", internalRPCError(err.Error(), context)
+	}
+	return b64Psbt, nil
+}
+
+// handleCombinePsbt handles combinepsbt commands.
+func handleCombinePsbt(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.CombinePsbtCmd)
+
+	if len(c.Psbts) == 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "At least one PSBT is required",
+		}
+	}
+	packets := make([]*psbt.Packet, 0, len(c.Psbts))
+	for _, b64Psbt := range c.Psbts {
+		packet, err := decodePsbt(b64Psbt)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+	}
+
+	combined, err := psbt.Combine(packets...)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Unable to combine PSBTs: " + err.Error(),
+		}
+	}
+	return encodePsbt(combined)
+}
+
+// createPsbtTxOut returns the transaction output described by an address or
+// "data" key and its value in the outputs of a createpsbt command.
+func createPsbtTxOut(key string, value interface{},
+	params *chaincfg.Params) (*wire.TxOut, error) {
+
+	// Data outputs carry the hex-encoded data in a provably unspendable
+	// output.
+	if key == "data" {
+		hexStr, ok := value.(string)
+		if !ok {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCType,
+				Message: "Data must be a hexadecimal string",
+			}
+		}
+		data, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, rpcDecodeHexError(hexStr)
+		}
+		pkScript, err := txscript.NullDataScript(data)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid data output: " + err.Error(),
+			}
+		}
+		return wire.NewTxOut(0, pkScript), nil
+	}
+
+	// Ensure amount is in the valid range for monetary amounts.
+	amount, ok := value.(float64)
+	if !ok || amount <= 0 ||
+		amount*btcutil.SatoshiPerBitcoin > btcutil.MaxSatoshi {
+
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCType,
+			Message: "Invalid amount",
+		}
+	}
+
+	// Decode the provided address and ensure the network encoded with the
+	// address matches the network the server is currently on.
+	addr, err := btcutil.DecodeAddress(key, params)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address or key: " + err.Error(),
+		}
+	}
+	if !addr.IsForNet(params) {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address: " + key +
+				" is for the wrong network",
+		}
+	}
+
+	// Unlike createrawtransaction, any address type which can be paid to
+	// is accepted since the signers are given all they need in the PSBT.
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address or key: " + err.Error(),
+		}
+	}
+
+	// Convert the amount to satoshi.
+	satoshi, err := btcutil.NewAmount(amount)
+	if err != nil {
+		context := "Failed to convert amount"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	return wire.NewTxOut(int64(satoshi), pkScript), nil
+}
+
+// handleCreatePsbt handles createpsbt commands.
+func handleCreatePsbt(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.CreatePsbtCmd)
+
+	var lockTime uint32
+	if c.Locktime != nil {
+		lockTime = *c.Locktime
+	}
+	replaceable := c.Replaceable != nil && *c.Replaceable
+
+	// The default sequence number of the inputs signals replaceability as
+	// defined by BIP 125 when requested and otherwise only ensures the
+	// locktime, if any, is enforced.
+	defaultSequence := uint32(wire.MaxTxInSequenceNum)
+	switch {
+	case replaceable:
+		defaultSequence = mempool.MaxRBFSequence
+	case lockTime != 0:
+		defaultSequence = wire.MaxTxInSequenceNum - 1
+	}
+
+	inputs := make([]*wire.OutPoint, 0, len(c.Inputs))
+	sequences := make([]uint32, 0, len(c.Inputs))
+	for _, input := range c.Inputs {
+		txHash, err := chainhash.NewHashFromStr(input.Txid)
+		if err != nil {
+			return nil, rpcDecodeHexError(input.Txid)
+		}
+
+		sequence := defaultSequence
+		if input.Sequence != 0 {
+			if replaceable && input.Sequence > mempool.MaxRBFSequence {
+				return nil, &btcjson.RPCError{
+					Code: btcjson.ErrRPCInvalidParameter,
+					Message: "Invalid parameter combination: " +
+						"sequence number contradicts " +
+						"replaceable option",
+				}
+			}
+			sequence = input.Sequence
+		}
+
+		inputs = append(inputs, wire.NewOutPoint(txHash, input.Vout))
+		sequences = append(sequences, sequence)
+	}
+
+	// Add the outputs in the order they were given.  Outputs given in the
+	// same object are added in the order of their keys so the result
+	// doesn't depend on the map iteration order.
+	params := s.cfg.ChainParams
+	var outputs []*wire.TxOut
+	for _, output := range c.Outputs {
+		keys := make([]string, 0, len(output))
+		for key := range output {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			txOut, err := createPsbtTxOut(key, output[key], params)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, txOut)
+		}
+	}
+
+	psbtVersion := psbt.PsbtVersion0
+	if c.PsbtVersion != nil {
+		psbtVersion = *c.PsbtVersion
+	}
+
+	var packet *psbt.Packet
+	var err error
+	switch psbtVersion {
+	case psbt.PsbtVersion0:
+		packet, err = psbt.New(inputs, outputs, 2, lockTime, sequences)
+	case psbt.PsbtVersion2:
+		packet, err = psbt.NewV2(inputs, outputs, 2, lockTime, sequences)
+	default:
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Unsupported PSBT version %d",
+				psbtVersion),
+		}
+	}
+	if err != nil {
+		context := "Failed to create PSBT"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	return encodePsbt(packet)
+}
+
 // handleCreateRawTransaction handles createrawtransaction commands.
 func handleCreateRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.CreateRawTransactionCmd)
@@ -637,6 +914,131 @@ func handleDebugLevel(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return "Done.", nil
 }
 
+// hexStack formats the passed script stack as a slice of hex-encoded strings
+// to be used in a JSON response.
+func hexStack(stack [][]byte) []string {
+	result := make([]string, 0, len(stack))
+	for _, item := range stack {
+		result = append(result, hex.EncodeToString(item))
+	}
+	return result
+}
+
+// traceStepToJSON converts the passed script execution step into the form
+// returned by the debugscript command.
+func traceStepToJSON(step *txscript.TraceStep) btcjson.DebugScriptStep {
+	jsonStep := btcjson.DebugScriptStep{
+		Script:      step.ScriptIdx,
+		Index:       step.OpcodeIdx,
+		Opcode:      step.Opcode,
+		Executed:    step.Executed,
+		StackBefore: hexStack(step.StackBefore),
+		Stack:       hexStack(step.Stack),
+		AltStack:    hexStack(step.AltStack),
+		CondStack:   append([]int{}, step.CondStack...),
+		NumOps:      step.NumOps,
+	}
+	for _, check := range step.SigChecks {
+		jsonStep.SigChecks = append(jsonStep.SigChecks,
+			btcjson.DebugScriptSigCheck{
+				PubKey:    hex.EncodeToString(check.PubKey),
+				Signature: hex.EncodeToString(check.Signature),
+				HashType:  uint32(check.HashType),
+				SigHash:   hex.EncodeToString(check.SigHash),
+				Valid:     check.Valid,
+			})
+	}
+	if step.Err != nil {
+		jsonStep.Error = step.Err.Error()
+	}
+	return jsonStep
+}
+
+// handleDebugScript implements the debugscript command.
+func handleDebugScript(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.DebugScriptCmd)
+
+	// Deserialize the transaction.
+	hexStr := c.HexTx
+	if len(hexStr)%2 != 0 {
+		hexStr = "0" + hexStr
+	}
+	serializedTx, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, rpcDecodeHexError(hexStr)
+	}
+	var mtx wire.MsgTx
+	err = mtx.Deserialize(bytes.NewReader(serializedTx))
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCDeserialization,
+			Message: "TX decode failed: " + err.Error(),
+		}
+	}
+	if c.InputIndex >= uint32(len(mtx.TxIn)) {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Input index %d is out of range "+
+				"[0, %d)", c.InputIndex, len(mtx.TxIn)),
+		}
+	}
+
+	// Compact state nodes only know the spent outputs proven by the
+	// passed utreexo data.  All of them are needed to compute the
+	// signature hashes of taproot spends.
+	var utxos map[wire.OutPoint]*wire.TxOut
+	if s.utreexoCSN {
+		utxos, err = fetchUtxosFromUData(s, c.UData)
+	} else {
+		utxos, err = fetchTxUtxos(s, &mtx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	outpoint := mtx.TxIn[c.InputIndex].PreviousOutPoint
+	prevOut, ok := utxos[outpoint]
+	if !ok {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCNoTxInfo,
+			Message: fmt.Sprintf("Output %v spent by input %d not "+
+				"found", outpoint, c.InputIndex),
+		}
+	}
+
+	flags := txscript.StandardVerifyFlags
+	result := &btcjson.DebugScriptResult{
+		TxID:         mtx.TxHash().String(),
+		Vin:          c.InputIndex,
+		Amount:       btcutil.Amount(prevOut.Value).ToBTC(),
+		ScriptPubKey: hex.EncodeToString(prevOut.PkScript),
+		Flags:        uint32(flags),
+		Steps:        []btcjson.DebugScriptStep{},
+	}
+
+	// Scripts which are rejected before any opcode is executed, such as
+	// those spending outputs with malformed witnesses, are reported with
+	// an empty trace.
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(utxos)
+	sigHashes := txscript.NewTxSigHashes(&mtx, prevOutFetcher)
+	vm, err := txscript.NewEngine(prevOut.PkScript, &mtx,
+		int(c.InputIndex), flags, nil, sigHashes, prevOut.Value,
+		prevOutFetcher)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	vm.SetTracer(txscript.TraceFunc(func(step *txscript.TraceStep) {
+		result.Steps = append(result.Steps, traceStepToJSON(step))
+	}))
+	if err := vm.Execute(); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+
+	result.Valid = true
+	return result, nil
+}
+
 // witnessToHex formats the passed witness stack as a slice of hex-encoded
 // strings to be used in a JSON response.
 func witnessToHex(witness wire.TxWitness) []string {
@@ -777,6 +1179,337 @@ func createTxRawResult(chainParams *chaincfg.Params, mtx *wire.MsgTx,
 	return txReply, nil
 }
 
+// sigHashTypeString returns the name of the passed signature hash type in the
+// format used by the reference implementation.
+func sigHashTypeString(hashType txscript.SigHashType) string {
+	var str string
+	switch hashType &^ txscript.SigHashAnyOneCanPay {
+	case txscript.SigHashDefault:
+		if hashType == txscript.SigHashDefault {
+			return "DEFAULT"
+		}
+		return strconv.Itoa(int(hashType))
+	case txscript.SigHashAll:
+		str = "ALL"
+	case txscript.SigHashNone:
+		str = "NONE"
+	case txscript.SigHashSingle:
+		str = "SINGLE"
+	default:
+		return strconv.Itoa(int(hashType))
+	}
+
+	if hashType&txscript.SigHashAnyOneCanPay != 0 {
+		str += "|ANYONECANPAY"
+	}
+	return str
+}
+
+// bip32PathString returns the passed BIP32 derivation path in the
+// "m/0'/1/2" notation.
+func bip32PathString(path []uint32) string {
+	var str strings.Builder
+	str.WriteString("m")
+	for _, index := range path {
+		if index >= hdkeychain.HardenedKeyStart {
+			fmt.Fprintf(&str, "/%d'", index-hdkeychain.HardenedKeyStart)
+			continue
+		}
+		fmt.Fprintf(&str, "/%d", index)
+	}
+	return str.String()
+}
+
+// fingerprintString returns the hex encoding of the passed master key
+// fingerprint in the byte order it is serialized in.
+func fingerprintString(fingerprint uint32) string {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], fingerprint)
+	return hex.EncodeToString(b[:])
+}
+
+// createScriptPubKeyResult returns the decoded form of the passed public key
+// script.
+func createScriptPubKeyResult(pkScript []byte,
+	params *chaincfg.Params) btcjson.ScriptPubKeyResult {
+
+	// The disassembled string will contain [error] inline if the script
+	// doesn't fully parse, so ignore the error here.
+	disbuf, _ := txscript.DisasmString(pkScript)
+
+	// Ignore the error here since an error means the script couldn't parse
+	// and there is no additional information about it anyways.
+	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(
+		pkScript, params,
+	)
+	addresses := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = addr.EncodeAddress()
+	}
+
+	return btcjson.ScriptPubKeyResult{
+		Asm:       disbuf,
+		Hex:       hex.EncodeToString(pkScript),
+		ReqSigs:   int32(reqSigs),
+		Type:      scriptClass.String(),
+		Addresses: addresses,
+	}
+}
+
+// createPsbtScriptResult returns the decoded form of the passed redeem or
+// witness script of a PSBT input or output, or nil when there is none.
+func createPsbtScriptResult(script []byte) *btcjson.PsbtScriptResult {
+	if script == nil {
+		return nil
+	}
+
+	// The disassembled string will contain [error] inline if the script
+	// doesn't fully parse, so ignore the error here.
+	disbuf, _ := txscript.DisasmString(script)
+
+	return &btcjson.PsbtScriptResult{
+		Asm:  disbuf,
+		Hex:  hex.EncodeToString(script),
+		Type: txscript.GetScriptClass(script).String(),
+	}
+}
+
+// createPsbtUnknownMap returns the passed unknown key-value pairs as a map of
+// hex-encoded keys to hex-encoded values.
+func createPsbtUnknownMap(unknowns []*psbt.Unknown) map[string]string {
+	unknownMap := make(map[string]string, len(unknowns))
+	for _, unknown := range unknowns {
+		unknownMap[hex.EncodeToString(unknown.Key)] =
+			hex.EncodeToString(unknown.Value)
+	}
+	return unknownMap
+}
+
+// createPsbtBip32DerivResults returns the decoded form of the passed BIP32
+// derivation paths.
+func createPsbtBip32DerivResults(
+	derivations []*psbt.Bip32Derivation) []btcjson.PsbtBip32DerivResult {
+
+	results := make([]btcjson.PsbtBip32DerivResult, 0, len(derivations))
+	for _, d := range derivations {
+		results = append(results, btcjson.PsbtBip32DerivResult{
+			PubKey:            hex.EncodeToString(d.PubKey),
+			MasterFingerprint: fingerprintString(d.MasterKeyFingerprint),
+			Path:              bip32PathString(d.Bip32Path),
+		})
+	}
+	return results
+}
+
+// createPsbtTaprootBip32DerivResults returns the decoded form of the passed
+// taproot BIP32 derivation paths.
+func createPsbtTaprootBip32DerivResults(
+	derivations []*psbt.TaprootBip32Derivation) []btcjson.PsbtTaprootBip32DerivResult {
+
+	results := make([]btcjson.PsbtTaprootBip32DerivResult, 0,
+		len(derivations))
+	for _, d := range derivations {
+		leafHashes := make([]string, len(d.LeafHashes))
+		for i, leafHash := range d.LeafHashes {
+			leafHashes[i] = hex.EncodeToString(leafHash)
+		}
+		results = append(results, btcjson.PsbtTaprootBip32DerivResult{
+			PubKey:            hex.EncodeToString(d.XOnlyPubKey),
+			MasterFingerprint: fingerprintString(d.MasterKeyFingerprint),
+			Path:              bip32PathString(d.Bip32Path),
+			LeafHashes:        leafHashes,
+		})
+	}
+	return results
+}
+
+// createPsbtInputResult returns the decoded form of the passed PSBT input.
+func createPsbtInputResult(pInput *psbt.PInput,
+	params *chaincfg.Params) (*btcjson.PsbtInputResult, error) {
+
+	result := btcjson.PsbtInputResult{
+		RedeemScript:           createPsbtScriptResult(pInput.RedeemScript),
+		WitnessScript:          createPsbtScriptResult(pInput.WitnessScript),
+		Bip32Derivs:            createPsbtBip32DerivResults(pInput.Bip32Derivation),
+		TaprootKeyPathSig:      hex.EncodeToString(pInput.TaprootKeySpendSig),
+		TaprootBip32Derivs:     createPsbtTaprootBip32DerivResults(pInput.TaprootBip32Derivation),
+		TaprootInternalKey:     hex.EncodeToString(pInput.TaprootInternalKey),
+		TaprootMerkleRoot:      hex.EncodeToString(pInput.TaprootMerkleRoot),
+		RequiredTimeLocktime:   pInput.RequiredTimeLocktime,
+		RequiredHeightLocktime: pInput.RequiredHeightLocktime,
+		Unknown:                createPsbtUnknownMap(pInput.Unknowns),
+	}
+
+	if tx := pInput.NonWitnessUtxo; tx != nil {
+		result.NonWitnessUtxo = &btcjson.TxRawDecodeResult{
+			Txid:     tx.TxHash().String(),
+			Version:  tx.Version,
+			Locktime: tx.LockTime,
+			Vin:      createVinList(tx),
+			Vout:     createVoutList(tx, params, nil),
+		}
+	}
+	if txOut := pInput.WitnessUtxo; txOut != nil {
+		result.WitnessUtxo = &btcjson.PsbtWitnessUtxoResult{
+			Amount:       btcutil.Amount(txOut.Value).ToBTC(),
+			ScriptPubKey: createScriptPubKeyResult(txOut.PkScript, params),
+		}
+	}
+	if len(pInput.PartialSigs) > 0 {
+		result.PartialSignatures = make(map[string]string,
+			len(pInput.PartialSigs))
+		for _, sig := range pInput.PartialSigs {
+			result.PartialSignatures[hex.EncodeToString(sig.PubKey)] =
+				hex.EncodeToString(sig.Signature)
+		}
+	}
+	if pInput.SighashType != 0 {
+		result.Sighash = sigHashTypeString(pInput.SighashType)
+	}
+	if pInput.FinalScriptSig != nil {
+		// The disassembled string will contain [error] inline if the
+		// script doesn't fully parse, so ignore the error here.
+		disbuf, _ := txscript.DisasmString(pInput.FinalScriptSig)
+		result.FinalScriptSig = &btcjson.ScriptSig{
+			Asm: disbuf,
+			Hex: hex.EncodeToString(pInput.FinalScriptSig),
+		}
+	}
+	if pInput.FinalScriptWitness != nil {
+		witness, err := psbt.ReadTxWitness(pInput.FinalScriptWitness)
+		if err != nil {
+			return nil, err
+		}
+		result.FinalScriptWitness = witnessToHex(witness)
+	}
+
+	for _, sig := range pInput.TaprootScriptSpendSig {
+		signature := sig.Signature
+		if sig.SigHash != txscript.SigHashDefault {
+			signature = append(signature[:len(signature):len(signature)],
+				byte(sig.SigHash))
+		}
+		result.TaprootScriptPathSigs = append(result.TaprootScriptPathSigs,
+			btcjson.PsbtTaprootScriptSigResult{
+				PubKey:   hex.EncodeToString(sig.XOnlyPubKey),
+				LeafHash: hex.EncodeToString(sig.LeafHash),
+				Sig:      hex.EncodeToString(signature),
+			})
+	}
+
+	// Leaf scripts are listed once along with all of the control blocks
+	// which commit to them.
+	for _, leaf := range pInput.TaprootLeafScript {
+		script := hex.EncodeToString(leaf.Script)
+		controlBlock := hex.EncodeToString(leaf.ControlBlock)
+
+		found := false
+		for i := range result.TaprootScripts {
+			s := &result.TaprootScripts[i]
+			if s.Script == script && s.LeafVer == uint8(leaf.LeafVersion) {
+				s.ControlBlocks = append(s.ControlBlocks, controlBlock)
+				found = true
+				break
+			}
+		}
+		if !found {
+			result.TaprootScripts = append(result.TaprootScripts,
+				btcjson.PsbtTaprootScriptResult{
+					Script:        script,
+					LeafVer:       uint8(leaf.LeafVersion),
+					ControlBlocks: []string{controlBlock},
+				})
+		}
+	}
+
+	return &result, nil
+}
+
+// createPsbtOutputResult returns the decoded form of the passed PSBT output.
+func createPsbtOutputResult(pOutput *psbt.POutput) (*btcjson.PsbtOutputResult, error) {
+	result := btcjson.PsbtOutputResult{
+		RedeemScript:       createPsbtScriptResult(pOutput.RedeemScript),
+		WitnessScript:      createPsbtScriptResult(pOutput.WitnessScript),
+		Bip32Derivs:        createPsbtBip32DerivResults(pOutput.Bip32Derivation),
+		TaprootInternalKey: hex.EncodeToString(pOutput.TaprootInternalKey),
+		TaprootBip32Derivs: createPsbtTaprootBip32DerivResults(pOutput.TaprootBip32Derivation),
+		Unknown:            createPsbtUnknownMap(pOutput.Unknowns),
+	}
+
+	if pOutput.TaprootTapTree != nil {
+		leaves, err := psbt.ParseTapTree(pOutput.TaprootTapTree)
+		if err != nil {
+			return nil, err
+		}
+		for _, leaf := range leaves {
+			result.TaprootTree = append(result.TaprootTree,
+				btcjson.PsbtTaprootTreeLeafResult{
+					Depth:   leaf.Depth,
+					LeafVer: uint8(leaf.Leaf.LeafVersion),
+					Script:  hex.EncodeToString(leaf.Leaf.Script),
+				})
+		}
+	}
+
+	return &result, nil
+}
+
+// handleDecodePsbt handles decodepsbt commands.
+func handleDecodePsbt(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.DecodePsbtCmd)
+
+	packet, err := decodePsbt(c.Psbt)
+	if err != nil {
+		return nil, err
+	}
+
+	params := s.cfg.ChainParams
+	mtx := packet.UnsignedTx
+	reply := btcjson.DecodePsbtResult{
+		Tx: btcjson.TxRawDecodeResult{
+			Txid:     mtx.TxHash().String(),
+			Version:  mtx.Version,
+			Locktime: mtx.LockTime,
+			Vin:      createVinList(mtx),
+			Vout:     createVoutList(mtx, params, nil),
+		},
+		PsbtVersion: packet.Version,
+		Unknown:     createPsbtUnknownMap(packet.Unknowns),
+		Inputs:      make([]btcjson.PsbtInputResult, 0, len(packet.Inputs)),
+		Outputs:     make([]btcjson.PsbtOutputResult, 0, len(packet.Outputs)),
+	}
+	for i := range packet.Inputs {
+		result, err := createPsbtInputResult(&packet.Inputs[i], params)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCDeserialization,
+				Message: fmt.Sprintf("Input %d decode failed: %v",
+					i, err),
+			}
+		}
+		reply.Inputs = append(reply.Inputs, *result)
+	}
+	for i := range packet.Outputs {
+		result, err := createPsbtOutputResult(&packet.Outputs[i])
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCDeserialization,
+				Message: fmt.Sprintf("Output %d decode failed: %v",
+					i, err),
+			}
+		}
+		reply.Outputs = append(reply.Outputs, *result)
+	}
+
+	// The fee is only known when all of the spent outputs are.
+	if fee, err := packet.GetTxFee(); err == nil {
+		feeBTC := fee.ToBTC()
+		reply.Fee = &feeBTC
+	}
+
+	return reply, nil
+}
+
 // handleDecodeRawTransaction handles decoderawtransaction commands.
 func handleDecodeRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.DecodeRawTransactionCmd)
@@ -858,6 +1591,98 @@ func handleDecodeScript(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 	return reply, nil
 }
 
+// maxDeriveAddresses is the maximum number of addresses a single
+// deriveaddresses command may derive.
+const maxDeriveAddresses = 1000000
+
+// descriptorRange returns the inclusive range of indices requested by the
+// passed descriptor range parameter.  A single value n requests the range
+// [0, n].
+func descriptorRange(r *btcjson.DescriptorRange) (uint32, uint32, error) {
+	var begin, end int
+	switch v := r.Value.(type) {
+	case int:
+		end = v
+
+	case []int:
+		if len(v) != 2 {
+			return 0, 0, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+				"Range must be specified as end or as [begin,end]")
+		}
+		begin, end = v[0], v[1]
+
+	default:
+		return 0, 0, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Range must be specified as end or as [begin,end]")
+	}
+
+	switch {
+	case begin < 0 || end < 0:
+		return 0, 0, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Range should be greater or equal than 0")
+
+	case begin > end:
+		return 0, 0, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Range specified as [begin,end] must not have begin after end")
+
+	case int64(end) >= hdkeychain.HardenedKeyStart:
+		return 0, 0, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"End of range is too high")
+
+	case end-begin >= maxDeriveAddresses:
+		return 0, 0, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Range is too large")
+	}
+	return uint32(begin), uint32(end), nil
+}
+
+// handleDeriveAddresses handles deriveaddresses commands.
+func handleDeriveAddresses(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.DeriveAddressesCmd)
+
+	desc, err := descriptors.Parse(c.Descriptor, s.cfg.ChainParams, true)
+	if err != nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey,
+			err.Error())
+	}
+
+	var begin, end uint32
+	switch {
+	case desc.IsRange() && c.Range == nil:
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Range must be specified for a ranged descriptor")
+
+	case !desc.IsRange() && c.Range != nil:
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Range should not be specified for an un-ranged descriptor")
+
+	case c.Range != nil:
+		begin, end, err = descriptorRange(c.Range)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addresses := make(btcjson.DeriveAddressesResult, 0, end-begin+1)
+	for index := begin; ; index++ {
+		addr, err := desc.Address(index)
+		if err == descriptors.ErrNoAddress {
+			return nil, btcjson.NewRPCError(
+				btcjson.ErrRPCInvalidAddressOrKey,
+				"Descriptor does not have a corresponding address")
+		}
+		if err != nil {
+			return nil, btcjson.NewRPCError(
+				btcjson.ErrRPCInvalidAddressOrKey, err.Error())
+		}
+		addresses = append(addresses, addr)
+		if index == end {
+			break
+		}
+	}
+	return addresses, nil
+}
+
 // handleEstimateFee handles estimatefee commands.
 func handleEstimateFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.EstimateFeeCmd)
@@ -875,9 +1700,108 @@ func handleEstimateFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	if err != nil {
 		return -1.0, err
 	}
-
-	// Convert to satoshis per kb.
-	return float64(feeRate), nil
+
+	// Convert to satoshis per kb.
+	return float64(feeRate), nil
+}
+
+// handleEstimateSmartFee handles estimatesmartfee commands.
+func handleEstimateSmartFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.EstimateSmartFeeCmd)
+
+	if s.cfg.FeeEstimator == nil {
+		return nil, errors.New("Fee estimation disabled")
+	}
+
+	if c.ConfTarget < 1 || c.ConfTarget > mempool.MaxEstimateConfTarget {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid conf_target, must be "+
+				"between 1 and %d", mempool.MaxEstimateConfTarget),
+		}
+	}
+
+	// The conservative mode is the default one.
+	conservative := true
+	if c.EstimateMode != nil {
+		mode := strings.ToUpper(string(*c.EstimateMode))
+		switch btcjson.EstimateSmartFeeMode(mode) {
+		case btcjson.EstimateModeEconomical:
+			conservative = false
+		case btcjson.EstimateModeUnset, btcjson.EstimateModeConservative:
+		default:
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid estimate_mode parameter",
+			}
+		}
+	}
+
+	feeRate, blocks, err := s.cfg.FeeEstimator.EstimateSmartFee(
+		uint32(c.ConfTarget), conservative)
+	result := &btcjson.EstimateSmartFeeResult{Blocks: int64(blocks)}
+	if err != nil {
+		result.Errors = []string{err.Error()}
+		return result, nil
+	}
+
+	// A transaction must pay at least the minimum fee rate of the pool to
+	// be relayed at all.
+	rate := float64(feeRate)
+	if minFee := s.cfg.TxMemPool.MinFee().ToBTC(); rate < minFee {
+		rate = minFee
+	}
+	result.FeeRate = &rate
+	return result, nil
+}
+
+// handleFinalizePsbt handles finalizepsbt commands.
+func handleFinalizePsbt(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.FinalizePsbtCmd)
+
+	packet, err := decodePsbt(c.Psbt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Finalize as many inputs as possible.  Inputs which are still missing
+	// signatures just leave the PSBT incomplete.
+	for i := range packet.Inputs {
+		_, err := psbt.MaybeFinalize(packet, i)
+		if err != nil && err != psbt.ErrNotFinalizable {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCInvalidParameter,
+				Message: fmt.Sprintf("Unable to finalize input "+
+					"%d: %v", i, err),
+			}
+		}
+	}
+
+	complete := packet.IsComplete()
+	if complete && (c.Extract == nil || *c.Extract) {
+		tx, err := psbt.Extract(packet)
+		if err != nil {
+			context := "Failed to extract transaction"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		txHex, err := messageToHex(tx)
+		if err != nil {
+			return nil, err
+		}
+		return btcjson.FinalizePsbtResult{
+			Hex:      txHex,
+			Complete: true,
+		}, nil
+	}
+
+	b64Psbt, err := encodePsbt(packet)
+	if err != nil {
+		return nil, err
+	}
+	return btcjson.FinalizePsbtResult{
+		Psbt:     b64Psbt,
+		Complete: complete,
+	}, nil
 }
 
 // handleGenerate handles generate commands.
@@ -1164,6 +2088,28 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	return blockReply, nil
 }
 
+// deploymentName returns the human readable name of the passed versionbits
+// deployment as used by the RPC server.
+func deploymentName(deployment int) (string, error) {
+	switch deployment {
+	case chaincfg.DeploymentTestDummy:
+		return "dummy", nil
+
+	case chaincfg.DeploymentCSV:
+		return "csv", nil
+
+	case chaincfg.DeploymentSegwit:
+		return "segwit", nil
+
+	case chaincfg.DeploymentTaproot:
+		return "taproot", nil
+
+	default:
+		return "", fmt.Errorf("Unknown deployment %v detected",
+			deployment)
+	}
+}
+
 // softForkStatus converts a ThresholdState state into a human readable string
 // corresponding to the particular state.
 func softForkStatus(state blockchain.ThresholdState) (string, error) {
@@ -1243,22 +2189,11 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 	for deployment, deploymentDetails := range params.Deployments {
 		// Map the integer deployment ID into a human readable
 		// fork-name.
-		var forkName string
-		switch deployment {
-		case chaincfg.DeploymentTestDummy:
-			forkName = "dummy"
-
-		case chaincfg.DeploymentCSV:
-			forkName = "csv"
-
-		case chaincfg.DeploymentSegwit:
-			forkName = "segwit"
-
-		default:
+		forkName, err := deploymentName(deployment)
+		if err != nil {
 			return nil, &btcjson.RPCError{
-				Code: btcjson.ErrRPCInternal.Code,
-				Message: fmt.Sprintf("Unknown deployment %v "+
-					"detected", deployment),
+				Code:    btcjson.ErrRPCInternal.Code,
+				Message: err.Error(),
 			}
 		}
 
@@ -1495,6 +2430,21 @@ func (state *gbtWorkState) NotifyMempoolTx(lastUpdated time.Time) {
 	}()
 }
 
+// templateUData returns the utreexo accumulator proof of the current block
+// template when it builds on the block with the passed hash, and nil otherwise.
+//
+// This function is safe for concurrent access.
+func (state *gbtWorkState) templateUData(prevHash *chainhash.Hash) *btcacc.UData {
+	state.Lock()
+	defer state.Unlock()
+
+	template := state.template
+	if template == nil || !template.Block.Header.PrevBlock.IsEqual(prevHash) {
+		return nil
+	}
+	return template.UData
+}
+
 // templateUpdateChan returns a channel that will be closed once the block
 // template associated with the passed previous hash and last generated time
 // is stale.  The function will return existing channels for duplicate
@@ -1658,12 +2608,65 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 	return nil
 }
 
+// gbtVersionBits returns the rules of the versionbits deployments which are
+// active for the next block and the deployments which are available to be
+// signalled in it along with their bits.  The passed block version is returned
+// with the bits of the available deployments the client doesn't support
+// cleared.  An error is returned when an active deployment requires support
+// the client doesn't claim as per BIP0009.
+func gbtVersionBits(s *rpcServer, version int32, clientRules map[string]struct{}) ([]string, map[string]uint8, int32, error) {
+	rules := make([]string, 0, len(s.cfg.ChainParams.Deployments))
+	vbAvailable := make(map[string]uint8)
+	for deployment, details := range s.cfg.ChainParams.Deployments {
+		name, err := deploymentName(deployment)
+		if err != nil {
+			return nil, nil, 0, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInternal.Code,
+				Message: err.Error(),
+			}
+		}
+		state, err := s.cfg.Chain.ThresholdState(uint32(deployment))
+		if err != nil {
+			context := "Failed to obtain deployment status"
+			return nil, nil, 0, internalRPCError(err.Error(), context)
+		}
+
+		_, unforced := gbtUnforcedRules[name]
+		_, supported := clientRules[name]
+		switch state {
+		case blockchain.ThresholdStarted, blockchain.ThresholdLockedIn:
+			vbAvailable[name] = details.BitNumber
+			if unforced && !supported {
+				version &^= 1 << details.BitNumber
+			}
+
+		case blockchain.ThresholdActive:
+			if !unforced {
+				rules = append(rules, name)
+				continue
+			}
+			if !supported {
+				return nil, nil, 0, &btcjson.RPCError{
+					Code: btcjson.ErrRPCInvalidParameter,
+					Message: fmt.Sprintf("Support for '%s' "+
+						"rule requires explicit client "+
+						"support", name),
+				}
+			}
+			rules = append(rules, "!"+name)
+		}
+	}
+
+	return rules, vbAvailable, version, nil
+}
+
 // blockTemplateResult returns the current block template associated with the
 // state as a btcjson.GetBlockTemplateResult that is ready to be encoded to JSON
-// and returned to the caller.
+// and returned to the caller.  The versionbits fields of the result are
+// negotiated with the passed rules supported by the client.
 //
 // This function MUST be called with the state locked.
-func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld *bool) (*btcjson.GetBlockTemplateResult, error) {
+func (state *gbtWorkState) blockTemplateResult(s *rpcServer, useCoinbaseValue bool, clientRules map[string]struct{}, submitOld *bool) (*btcjson.GetBlockTemplateResult, error) {
 	// Ensure the timestamps are still in valid range for the template.
 	// This should really only ever happen if the local clock is changed
 	// after the template is generated, but it's important to avoid serving
@@ -1735,6 +2738,13 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		transactions = append(transactions, resultTx)
 	}
 
+	// Negotiate the versionbits deployments with the client.
+	rules, vbAvailable, version, err := gbtVersionBits(s, header.Version,
+		clientRules)
+	if err != nil {
+		return nil, err
+	}
+
 	// Generate the block template reply.  Note that following mutations are
 	// implied by the included or omission of fields:
 	//  Including MinTime -> time/decrement
@@ -1750,7 +2760,10 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		SigOpLimit:   blockchain.MaxBlockSigOpsCost,
 		SizeLimit:    wire.MaxBlockPayload,
 		Transactions: transactions,
-		Version:      header.Version,
+		Version:      version,
+		Rules:        rules,
+		VbAvailable:  vbAvailable,
+		VbRequired:   0,
 		LongPollID:   templateID,
 		SubmitOld:    submitOld,
 		Target:       targetDifficulty,
@@ -1760,8 +2773,8 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		NonceRange:   gbtNonceRange,
 		Capabilities: gbtCapabilities,
 	}
-	// If the generated block template includes transactions with witness
-	// data, then include the witness commitment in the GBT result.
+	// Once segwit is active, include the script of the witness commitment
+	// output in the GBT result for clients which build their own coinbase.
 	if template.WitnessCommitment != nil {
 		reply.DefaultWitnessCommitment = hex.EncodeToString(template.WitnessCommitment)
 	}
@@ -1814,7 +2827,7 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 // has passed without finding a solution.
 //
 // See https://en.bitcoin.it/wiki/BIP_0022 for more details.
-func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbaseValue bool, closeChan <-chan struct{}) (interface{}, error) {
+func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbaseValue bool, clientRules map[string]struct{}, closeChan <-chan struct{}) (interface{}, error) {
 	state := s.gbtWorkState
 	state.Lock()
 	// The state unlock is intentionally not deferred here since it needs to
@@ -1830,7 +2843,8 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 	// the caller is invalid.
 	prevHash, lastGenerated, err := decodeTemplateID(longPollID)
 	if err != nil {
-		result, err := state.blockTemplateResult(useCoinbaseValue, nil)
+		result, err := state.blockTemplateResult(s, useCoinbaseValue,
+			clientRules, nil)
 		if err != nil {
 			state.Unlock()
 			return nil, err
@@ -1851,8 +2865,8 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 		// old block template depending on whether or not a solution has
 		// already been found and added to the block chain.
 		submitOld := prevHash.IsEqual(prevTemplateHash)
-		result, err := state.blockTemplateResult(useCoinbaseValue,
-			&submitOld)
+		result, err := state.blockTemplateResult(s, useCoinbaseValue,
+			clientRules, &submitOld)
 		if err != nil {
 			state.Unlock()
 			return nil, err
@@ -1892,7 +2906,8 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 	// block template depending on whether or not a solution has already
 	// been found and added to the block chain.
 	submitOld := prevHash.IsEqual(&state.template.Block.Header.PrevBlock)
-	result, err := state.blockTemplateResult(useCoinbaseValue, &submitOld)
+	result, err := state.blockTemplateResult(s, useCoinbaseValue, clientRules,
+		&submitOld)
 	if err != nil {
 		return nil, err
 	}
@@ -1910,9 +2925,16 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateRequest, closeChan <-chan struct{}) (interface{}, error) {
 	// Extract the relevant passed capabilities and restrict the result to
 	// either a coinbase value or a coinbase transaction object depending on
-	// the request.  Default to only providing a coinbase value.
+	// the request.  Default to only providing a coinbase value.  The rules
+	// supported by the client are negotiated with the versionbits
+	// deployments when the result is created.
 	useCoinbaseValue := true
+	clientRules := make(map[string]struct{})
 	if request != nil {
+		for _, rule := range request.Rules {
+			clientRules[rule] = struct{}{}
+		}
+
 		var hasCoinbaseValue, hasCoinbaseTxn bool
 		for _, capability := range request.Capabilities {
 			switch capability {
@@ -1966,7 +2988,7 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 	// be replaced with a new one.
 	if request != nil && request.LongPollID != "" {
 		return handleGetBlockTemplateLongPoll(s, request.LongPollID,
-			useCoinbaseValue, closeChan)
+			useCoinbaseValue, clientRules, closeChan)
 	}
 
 	// Protect concurrent access when updating block templates.
@@ -1983,7 +3005,7 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 	if err := state.updateBlockTemplate(s, useCoinbaseValue); err != nil {
 		return nil, err
 	}
-	return state.blockTemplateResult(useCoinbaseValue, nil)
+	return state.blockTemplateResult(s, useCoinbaseValue, clientRules, nil)
 }
 
 // chainErrToGBTErrString converts an error returned from btcchain to a string
@@ -2083,7 +3105,7 @@ func chainErrToGBTErrString(err error) string {
 	case blockchain.ErrInvalidAncestorBlock:
 		return "bad-prevblk"
 	case blockchain.ErrPrevBlockNotBest:
-		return "inconclusive-not-best-prvblk"
+		return "inconclusive-not-best-prevblk"
 	}
 
 	return "rejected: " + err.Error()
@@ -2125,14 +3147,34 @@ func handleGetBlockTemplateProposal(s *rpcServer, request *btcjson.TemplateReque
 	}
 	block := btcutil.NewBlock(&msgBlock)
 
-	// Ensure the block is building from the expected previous block.
+	// Report blocks which are already known along with what is known about
+	// their validity.
+	blockHash := block.Hash()
+	switch {
+	case s.cfg.Chain.MainChainHasBlock(blockHash):
+		return "duplicate", nil
+	case s.cfg.Chain.IsKnownInvalid(blockHash):
+		return "duplicate-invalid", nil
+	}
+	haveBlock, err := s.cfg.Chain.HaveBlock(blockHash)
+	if err != nil {
+		context := "Failed to look up block proposal"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	if haveBlock {
+		return "duplicate-inconclusive", nil
+	}
+
+	// Ensure the block is building from the expected previous block.  The
+	// proposal can't be judged otherwise since it is only checked against
+	// the current tip.
 	expectedPrevHash := s.cfg.Chain.BestSnapshot().Hash
 	prevHash := &block.MsgBlock().Header.PrevBlock
 	if !expectedPrevHash.IsEqual(prevHash) {
-		return "bad-prevblk", nil
+		return "inconclusive-not-best-prevblk", nil
 	}
 
-	if err := s.cfg.Chain.CheckConnectBlockTemplate(block); err != nil {
+	if err := s.cfg.Chain.CheckConnectBlockTemplate(block, blockchain.BFNone); err != nil {
 		if _, ok := err.(blockchain.RuleError); !ok {
 			errStr := fmt.Sprintf("Failed to process block proposal: %v", err)
 			rpcsLog.Error(errStr)
@@ -2246,6 +3288,36 @@ func handleGetCurrentNet(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 	return s.cfg.ChainParams.Net, nil
 }
 
+// handleGetDescriptorInfo handles getdescriptorinfo commands.
+func handleGetDescriptorInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetDescriptorInfoCmd)
+
+	desc, err := descriptors.Parse(c.Descriptor, s.cfg.ChainParams, false)
+	if err != nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey,
+			err.Error())
+	}
+
+	// The checksum is that of the descriptor as given, which may contain
+	// private keys, rather than of its canonical form.
+	payload := c.Descriptor
+	if idx := strings.IndexByte(payload, '#'); idx >= 0 {
+		payload = payload[:idx]
+	}
+	checksum, err := descriptors.Checksum(payload)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "")
+	}
+
+	return &btcjson.GetDescriptorInfoResult{
+		Descriptor:     desc.String(),
+		Checksum:       checksum,
+		IsRange:        desc.IsRange(),
+		IsSolvable:     desc.IsSolvable(),
+		HasPrivateKeys: desc.HasPrivateKeys(),
+	}, nil
+}
+
 // handleGetDifficulty implements the getdifficulty command.
 func handleGetDifficulty(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	best := s.cfg.Chain.BestSnapshot()
@@ -2322,6 +3394,70 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 	return ret, nil
 }
 
+// mempoolPackageResult returns the result of the getmempoolancestors and
+// getmempooldescendants commands from the passed package of transactions,
+// which is an array of their hashes unless the verbose flag is set.
+func mempoolPackageResult(pkg map[string]*btcjson.GetMempoolEntryResult, verbose *bool) interface{} {
+	if verbose != nil && *verbose {
+		return pkg
+	}
+
+	hashStrings := make([]string, 0, len(pkg))
+	for hash := range pkg {
+		hashStrings = append(hashStrings, hash)
+	}
+	sort.Strings(hashStrings)
+	return hashStrings
+}
+
+// handleGetMempoolAncestors implements the getmempoolancestors command.
+func handleGetMempoolAncestors(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolAncestorsCmd)
+
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+	ancestors, err := s.cfg.TxMemPool.MempoolAncestors(txHash)
+	if err != nil {
+		return nil, rpcNotInMempoolError()
+	}
+
+	return mempoolPackageResult(ancestors, c.Verbose), nil
+}
+
+// handleGetMempoolDescendants implements the getmempooldescendants command.
+func handleGetMempoolDescendants(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolDescendantsCmd)
+
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+	descendants, err := s.cfg.TxMemPool.MempoolDescendants(txHash)
+	if err != nil {
+		return nil, rpcNotInMempoolError()
+	}
+
+	return mempoolPackageResult(descendants, c.Verbose), nil
+}
+
+// handleGetMempoolEntry implements the getmempoolentry command.
+func handleGetMempoolEntry(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolEntryCmd)
+
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+	entry, err := s.cfg.TxMemPool.MempoolEntry(txHash)
+	if err != nil {
+		return nil, rpcNotInMempoolError()
+	}
+
+	return entry, nil
+}
+
 // handleGetMempoolInfo implements the getmempoolinfo command.
 func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	mempoolTxns := s.cfg.TxMemPool.TxDescs()
@@ -2332,8 +3468,12 @@ func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct
 	}
 
 	ret := &btcjson.GetMempoolInfoResult{
-		Size:  int64(len(mempoolTxns)),
-		Bytes: numBytes,
+		Size:          int64(len(mempoolTxns)),
+		Bytes:         numBytes,
+		Usage:         s.cfg.TxMemPool.Usage(),
+		MaxMempool:    int64(cfg.MaxMempoolMB) * 1000 * 1000,
+		MempoolMinFee: s.cfg.TxMemPool.MinFee().ToBTC(),
+		MinRelayTxFee: cfg.minRelayTxFee.ToBTC(),
 	}
 
 	return ret, nil
@@ -2378,10 +3518,26 @@ func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 // handleGetNetTotals implements the getnettotals command.
 func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	totalBytesRecv, totalBytesSent := s.cfg.ConnMgr.NetTotals()
+	sent, recv := s.cfg.ConnMgr.ByteCounters()
+	target := s.cfg.ConnMgr.UploadTarget()
 	reply := &btcjson.GetNetTotalsResult{
-		TotalBytesRecv: totalBytesRecv,
-		TotalBytesSent: totalBytesSent,
-		TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		TotalBytesRecv:  totalBytesRecv,
+		TotalBytesSent:  totalBytesSent,
+		TimeMillis:      time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		BytesSentPerMsg: sent.PerMsg,
+		BytesRecvPerMsg: recv.PerMsg,
+		BlockBytesSent:  sent.BlockBytes,
+		ProofBytesSent:  sent.ProofBytes,
+		BlockBytesRecv:  recv.BlockBytes,
+		ProofBytesRecv:  recv.ProofBytes,
+		UploadTarget: &btcjson.GetNetTotalsUploadTarget{
+			TimeFrame:             int64(uploadTargetTimeframe / time.Second),
+			Target:                target.Target,
+			TargetReached:         target.TargetReached,
+			ServeHistoricalBlocks: target.ServeHistoricalBlocks,
+			BytesLeftInCycle:      target.BytesLeftInCycle,
+			TimeLeftInCycle:       int64(target.TimeLeftInCycle / time.Second),
+		},
 	}
 	return reply, nil
 }
@@ -2521,27 +3677,36 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	infos := make([]*btcjson.GetPeerInfoResult, 0, len(peers))
 	for _, p := range peers {
 		statsSnap := p.ToPeer().StatsSnapshot()
+		sent, recv := p.ByteCounters()
 		info := &btcjson.GetPeerInfoResult{
-			ID:             statsSnap.ID,
-			Addr:           statsSnap.Addr,
-			AddrLocal:      p.ToPeer().LocalAddr().String(),
-			Services:       fmt.Sprintf("%08d", uint64(statsSnap.Services)),
-			RelayTxes:      !p.IsTxRelayDisabled(),
-			LastSend:       statsSnap.LastSend.Unix(),
-			LastRecv:       statsSnap.LastRecv.Unix(),
-			BytesSent:      statsSnap.BytesSent,
-			BytesRecv:      statsSnap.BytesRecv,
-			ConnTime:       statsSnap.ConnTime.Unix(),
-			PingTime:       float64(statsSnap.LastPingMicros),
-			TimeOffset:     statsSnap.TimeOffset,
-			Version:        statsSnap.Version,
-			SubVer:         statsSnap.UserAgent,
-			Inbound:        statsSnap.Inbound,
-			StartingHeight: statsSnap.StartingHeight,
-			CurrentHeight:  statsSnap.LastBlock,
-			BanScore:       int32(p.BanScore()),
-			FeeFilter:      p.FeeFilter(),
-			SyncNode:       statsSnap.ID == syncPeerID,
+			ID:              statsSnap.ID,
+			Addr:            statsSnap.Addr,
+			AddrLocal:       p.ToPeer().LocalAddr().String(),
+			Services:        fmt.Sprintf("%08d", uint64(statsSnap.Services)),
+			RelayTxes:       !p.IsTxRelayDisabled(),
+			LastSend:        statsSnap.LastSend.Unix(),
+			LastRecv:        statsSnap.LastRecv.Unix(),
+			BytesSent:       statsSnap.BytesSent,
+			BytesRecv:       statsSnap.BytesRecv,
+			ConnTime:        statsSnap.ConnTime.Unix(),
+			PingTime:        float64(statsSnap.LastPingMicros),
+			TimeOffset:      statsSnap.TimeOffset,
+			Version:         statsSnap.Version,
+			SubVer:          statsSnap.UserAgent,
+			Inbound:         statsSnap.Inbound,
+			StartingHeight:  statsSnap.StartingHeight,
+			CurrentHeight:   statsSnap.LastBlock,
+			BanScore:        int32(p.BanScore()),
+			FeeFilter:       p.FeeFilter(),
+			SyncNode:        statsSnap.ID == syncPeerID,
+			Transport:       statsSnap.Transport,
+			SessionID:       statsSnap.SessionID,
+			BytesSentPerMsg: sent.PerMsg,
+			BytesRecvPerMsg: recv.PerMsg,
+			BlockBytesSent:  sent.BlockBytes,
+			ProofBytesSent:  sent.ProofBytes,
+			BlockBytesRecv:  recv.BlockBytes,
+			ProofBytesRecv:  recv.ProofBytes,
 		}
 		if p.ToPeer().LastPingNonce() != 0 {
 			wait := float64(time.Since(statsSnap.LastPingTime).Nanoseconds())
@@ -2692,6 +3857,28 @@ func handleGetRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan str
 	return *rawTxn, nil
 }
 
+// handleGetRebroadcastInfo implements the getrebroadcastinfo command.
+func handleGetRebroadcastInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	snaps := s.cfg.ConnMgr.RebroadcastInfo()
+	infos := make([]btcjson.GetRebroadcastInfoResult, 0, len(snaps))
+	for _, snap := range snaps {
+		info := btcjson.GetRebroadcastInfoResult{
+			TxID:       snap.Hash.String(),
+			Time:       snap.Added.Unix(),
+			InTemplate: snap.InTemplate,
+			Peers:      snap.Peers,
+		}
+		if !snap.LastAnnounced.IsZero() {
+			info.LastAnnounced = snap.LastAnnounced.Unix()
+		}
+		if !snap.Expires.IsZero() {
+			info.Expires = snap.Expires.Unix()
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
 // handleGetTxOut handles gettxout commands.
 func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.GetTxOutCmd)
@@ -2759,95 +3946,377 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 			return nil, nil
 		}
 
-		best := s.cfg.Chain.BestSnapshot()
-		bestBlockHash = best.Hash.String()
-		confirmations = 1 + best.Height - entry.BlockHeight()
-		value = entry.Amount()
-		pkScript = entry.PkScript()
-		isCoinbase = entry.IsCoinBase()
-	}
+		best := s.cfg.Chain.BestSnapshot()
+		bestBlockHash = best.Hash.String()
+		confirmations = 1 + best.Height - entry.BlockHeight()
+		value = entry.Amount()
+		pkScript = entry.PkScript()
+		isCoinbase = entry.IsCoinBase()
+	}
+
+	// Disassemble script into single line printable format.
+	// The disassembled string will contain [error] inline if the script
+	// doesn't fully parse, so ignore the error here.
+	disbuf, _ := txscript.DisasmString(pkScript)
+
+	// Get further info about the script.
+	// Ignore the error here since an error means the script couldn't parse
+	// and there is no additional information about it anyways.
+	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(pkScript,
+		s.cfg.ChainParams)
+	addresses := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = addr.EncodeAddress()
+	}
+
+	txOutReply := &btcjson.GetTxOutResult{
+		BestBlock:     bestBlockHash,
+		Confirmations: int64(confirmations),
+		Value:         btcutil.Amount(value).ToBTC(),
+		ScriptPubKey: btcjson.ScriptPubKeyResult{
+			Asm:       disbuf,
+			Hex:       hex.EncodeToString(pkScript),
+			ReqSigs:   int32(reqSigs),
+			Type:      scriptClass.String(),
+			Addresses: addresses,
+		},
+		Coinbase: isCoinbase,
+	}
+	return txOutReply, nil
+}
+
+// handleHelp implements the help command.
+func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.HelpCmd)
+
+	// Provide a usage overview of all commands when no specific command
+	// was specified.
+	var command string
+	if c.Command != nil {
+		command = *c.Command
+	}
+	if command == "" {
+		usage, err := s.helpCacher.rpcUsage(false)
+		if err != nil {
+			context := "Failed to generate RPC usage"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		return usage, nil
+	}
+
+	// Check that the command asked for is supported and implemented.  Only
+	// search the main list of handlers since help should not be provided
+	// for commands that are unimplemented or related to wallet
+	// functionality.
+	if _, ok := rpcHandlers[command]; !ok {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Unknown command: " + command,
+		}
+	}
+
+	// Get the help for the command.
+	help, err := s.helpCacher.rpcMethodHelp(command)
+	if err != nil {
+		context := "Failed to generate help"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return help, nil
+}
+
+// handlePing implements the ping command.
+func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	// Ask server to ping \o_
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return nil, internalRPCError("Not sending ping - failed to "+
+			"generate nonce: "+err.Error(), "")
+	}
+	s.cfg.ConnMgr.BroadcastMessage(wire.NewMsgPing(nonce))
+
+	return nil, nil
+}
+
+// defaultScanRange is the range of indexes ranged descriptors are expanded at
+// by the scantxoutset command when none is given, as for Bitcoin Core.
+const defaultScanRange = 1000
+
+// scanTxOutSetState houses the state of the scan started by the scantxoutset
+// command.  Only a single scan may run at a time.
+type scanTxOutSetState struct {
+	sync.Mutex
+	inProgress bool
+	progress   float64
+	abort      chan struct{}
+}
+
+// scanTxOutSetScripts returns the output scripts the passed scan objects of a
+// scantxoutset command describe, mapped to the descriptors they were derived
+// from.  Besides descriptors, the scan objects may be plain addresses.
+func scanTxOutSetScripts(s *rpcServer, scanObjects []btcjson.ScanObject) (map[string]string, error) {
+	scripts := make(map[string]string)
+	for _, scanObject := range scanObjects {
+		desc, err := descriptors.Parse(scanObject.Desc,
+			s.cfg.ChainParams, false)
+		if err != nil {
+			addr, addrErr := btcutil.DecodeAddress(scanObject.Desc,
+				s.cfg.ChainParams)
+			if addrErr != nil || !addr.IsForNet(s.cfg.ChainParams) {
+				return nil, btcjson.NewRPCError(
+					btcjson.ErrRPCInvalidAddressOrKey,
+					err.Error())
+			}
+			desc, err = descriptors.Parse("addr("+scanObject.Desc+")",
+				s.cfg.ChainParams, false)
+			if err != nil {
+				return nil, btcjson.NewRPCError(
+					btcjson.ErrRPCInvalidAddressOrKey,
+					err.Error())
+			}
+		}
+
+		var begin, end uint32
+		switch {
+		case !desc.IsRange() && scanObject.Range != nil:
+			return nil, btcjson.NewRPCError(
+				btcjson.ErrRPCInvalidParameter,
+				"Range should not be specified for an "+
+					"un-ranged descriptor")
+
+		case scanObject.Range != nil:
+			begin, end, err = descriptorRange(scanObject.Range)
+			if err != nil {
+				return nil, err
+			}
+
+		case desc.IsRange():
+			end = defaultScanRange - 1
+		}
+
+		expanded, err := desc.Expand(begin, end)
+		if err != nil {
+			return nil, btcjson.NewRPCError(
+				btcjson.ErrRPCInvalidAddressOrKey, err.Error())
+		}
+		for _, script := range expanded {
+			scripts[string(script)] = desc.String()
+		}
+	}
+	return scripts, nil
+}
+
+// handleLoadMempool implements the loadmempool command.
+func handleLoadMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	stats, err := loadMempool(s.cfg.TxMemPool, closeChan)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Unable to load the memory pool: " + err.Error(),
+		}
+	}
+
+	return &btcjson.LoadMempoolResult{
+		Succeeded:    stats.Accepted,
+		Failed:       stats.Failed,
+		Expired:      stats.Expired,
+		AlreadyThere: stats.AlreadyHave,
+	}, nil
+}
+
+// handlePrioritiseTransaction implements the prioritisetransaction command.
+func handlePrioritiseTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.PrioritiseTransactionCmd)
+
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+	if c.PriorityDelta != 0 {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: "Priority is no longer supported, the " +
+				"priority delta must be 0",
+		}
+	}
+
+	s.cfg.TxMemPool.PrioritiseTransaction(txHash, c.FeeDelta)
+	return true, nil
+}
+
+// handleSaveMempool implements the savemempool command.
+func handleSaveMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	path, err := saveMempool(s.cfg.TxMemPool)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Unable to save the memory pool: " + err.Error(),
+		}
+	}
+
+	return &btcjson.SaveMempoolResult{Filename: path}, nil
+}
+
+// handleScanTxOutSet implements the scantxoutset command.
+func handleScanTxOutSet(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ScanTxOutSetCmd)
+
+	state := &s.scanTxOutSet
+	switch c.Action {
+	case "status":
+		state.Lock()
+		defer state.Unlock()
+		if !state.inProgress {
+			return nil, nil
+		}
+		return &btcjson.ScanTxOutSetStatusResult{
+			Progress: state.progress * 100,
+		}, nil
+
+	case "abort":
+		state.Lock()
+		defer state.Unlock()
+		if !state.inProgress {
+			return false, nil
+		}
+		select {
+		case <-state.abort:
+		default:
+			close(state.abort)
+		}
+		return true, nil
+
+	case "start":
 
-	// Disassemble script into single line printable format.
-	// The disassembled string will contain [error] inline if the script
-	// doesn't fully parse, so ignore the error here.
-	disbuf, _ := txscript.DisasmString(pkScript)
+	default:
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			fmt.Sprintf("Invalid action '%s'", c.Action))
+	}
 
-	// Get further info about the script.
-	// Ignore the error here since an error means the script couldn't parse
-	// and there is no additional information about it anyways.
-	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(pkScript,
-		s.cfg.ChainParams)
-	addresses := make([]string, len(addrs))
-	for i, addr := range addrs {
-		addresses[i] = addr.EncodeAddress()
+	if c.ScanObjects == nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"scanobjects argument is required for the start action")
+	}
+	scripts, err := scanTxOutSetScripts(s, *c.ScanObjects)
+	if err != nil {
+		return nil, err
 	}
 
-	txOutReply := &btcjson.GetTxOutResult{
-		BestBlock:     bestBlockHash,
-		Confirmations: int64(confirmations),
-		Value:         btcutil.Amount(value).ToBTC(),
-		ScriptPubKey: btcjson.ScriptPubKeyResult{
-			Asm:       disbuf,
-			Hex:       hex.EncodeToString(pkScript),
-			ReqSigs:   int32(reqSigs),
-			Type:      scriptClass.String(),
-			Addresses: addresses,
-		},
-		Coinbase: isCoinbase,
+	var startHeight int32
+	if c.StartHeight != nil {
+		startHeight = *c.StartHeight
+	}
+	best := s.cfg.Chain.BestSnapshot()
+	if startHeight < 0 || startHeight > best.Height {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			fmt.Sprintf("Start height %d is out of range [0, %d]",
+				startHeight, best.Height))
 	}
-	return txOutReply, nil
-}
 
-// handleHelp implements the help command.
-func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
-	c := cmd.(*btcjson.HelpCmd)
+	state.Lock()
+	if state.inProgress {
+		state.Unlock()
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter,
+			"Scan already in progress, use action \"abort\" or "+
+				"\"status\"")
+	}
+	state.inProgress = true
+	state.progress = 0
+	state.abort = make(chan struct{})
+	abort := state.abort
+	state.Unlock()
+	defer func() {
+		state.Lock()
+		state.inProgress = false
+		state.Unlock()
+	}()
 
-	// Provide a usage overview of all commands when no specific command
-	// was specified.
-	var command string
-	if c.Command != nil {
-		command = *c.Command
+	match := func(pkScript []byte) bool {
+		_, ok := scripts[string(pkScript)]
+		return ok
 	}
-	if command == "" {
-		usage, err := s.helpCacher.rpcUsage(false)
-		if err != nil {
-			context := "Failed to generate RPC usage"
-			return nil, internalRPCError(err.Error(), context)
-		}
-		return usage, nil
+	progress := func(p float64) {
+		state.Lock()
+		state.progress = p
+		state.Unlock()
 	}
 
-	// Check that the command asked for is supported and implemented.  Only
-	// search the main list of handlers since help should not be provided
-	// for commands that are unimplemented or related to wallet
-	// functionality.
-	if _, ok := rpcHandlers[command]; !ok {
-		return nil, &btcjson.RPCError{
-			Code:    btcjson.ErrRPCInvalidParameter,
-			Message: "Unknown command: " + command,
+	// Compact state nodes don't keep the utxo set, so they find the
+	// outputs in the blocks from the start height on instead.
+	var result *blockchain.UtxoScanResult
+	if s.utreexoCSN {
+		result, err = s.cfg.Chain.ScanUtreexoLeaves(startHeight,
+			s.cfg.SyncMgr.FetchUBlock, match, abort, progress)
+	} else {
+		result, err = s.cfg.Chain.ScanUtxoSet(match, abort, progress)
+	}
+	if err != nil {
+		select {
+		case <-abort:
+			return &btcjson.ScanTxOutSetResult{
+				Success:  false,
+				Unspents: []btcjson.ScanTxOutSetUnspent{},
+			}, nil
+		default:
 		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCMisc,
+			"Unable to scan the utxo set: "+err.Error())
 	}
 
-	// Get the help for the command.
-	help, err := s.helpCacher.rpcMethodHelp(command)
-	if err != nil {
-		context := "Failed to generate help"
-		return nil, internalRPCError(err.Error(), context)
+	// Report the outputs in the order they were created in.
+	sort.Slice(result.Entries, func(i, j int) bool {
+		a, b := &result.Entries[i], &result.Entries[j]
+		if a.Entry.BlockHeight() != b.Entry.BlockHeight() {
+			return a.Entry.BlockHeight() < b.Entry.BlockHeight()
+		}
+		if a.OutPoint.Hash != b.OutPoint.Hash {
+			return bytes.Compare(a.OutPoint.Hash[:],
+				b.OutPoint.Hash[:]) < 0
+		}
+		return a.OutPoint.Index < b.OutPoint.Index
+	})
+
+	var total btcutil.Amount
+	unspents := make([]btcjson.ScanTxOutSetUnspent, 0, len(result.Entries))
+	for _, found := range result.Entries {
+		amount := btcutil.Amount(found.Entry.Amount())
+		total += amount
+
+		unspent := btcjson.ScanTxOutSetUnspent{
+			TxID:         found.OutPoint.Hash.String(),
+			Vout:         found.OutPoint.Index,
+			ScriptPubKey: hex.EncodeToString(found.Entry.PkScript()),
+			Desc:         scripts[string(found.Entry.PkScript())],
+			Amount:       amount.ToBTC(),
+			Coinbase:     found.Entry.IsCoinBase(),
+			Height:       found.Entry.BlockHeight(),
+		}
+		if found.Proof != nil {
+			hashes := make([]string, 0, len(found.Proof.Proof))
+			for _, hash := range found.Proof.Proof {
+				hashes = append(hashes, hex.EncodeToString(hash[:]))
+			}
+			unspent.Proof = &btcjson.UtreexoProofResult{
+				Targets: found.Proof.Targets,
+				Hashes:  hashes,
+			}
+		}
+		unspents = append(unspents, unspent)
 	}
-	return help, nil
-}
 
-// handlePing implements the ping command.
-func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
-	// Ask server to ping \o_
-	nonce, err := wire.RandomUint64()
-	if err != nil {
-		return nil, internalRPCError("Not sending ping - failed to "+
-			"generate nonce: "+err.Error(), "")
+	var roots []string
+	for _, root := range result.Roots {
+		roots = append(roots, root.String())
 	}
-	s.cfg.ConnMgr.BroadcastMessage(wire.NewMsgPing(nonce))
 
-	return nil, nil
+	return &btcjson.ScanTxOutSetResult{
+		Success:     true,
+		TxOuts:      result.Scanned,
+		Height:      result.Height,
+		BestBlock:   result.Hash.String(),
+		Unspents:    unspents,
+		TotalAmount: total.ToBTC(),
+		Roots:       roots,
+	}, nil
 }
 
 // retrievedTx represents a transaction that was either loaded from the
@@ -3419,6 +4888,14 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 		return nil, internalRPCError(errStr, "")
 	}
 
+	// Keep track of all the sendrawtransaction request txns so that they
+	// can be rebroadcast if they don't make their way into a block.  This
+	// is done before relaying them so the peers they are first announced
+	// to are recorded.
+	txD := acceptedTxs[0]
+	iv := wire.NewInvVect(wire.InvTypeTx, txD.Tx.Hash())
+	s.cfg.ConnMgr.AddRebroadcastInventory(iv, txD)
+
 	// Generate and relay inventory vectors for all newly accepted
 	// transactions into the memory pool due to the original being
 	// accepted.
@@ -3428,12 +4905,6 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 	// newly accepted transactions.
 	s.NotifyNewTransactions(acceptedTxs)
 
-	// Keep track of all the sendrawtransaction request txns so that they
-	// can be rebroadcast if they don't make their way into a block.
-	txD := acceptedTxs[0]
-	iv := wire.NewInvVect(wire.InvTypeTx, txD.Tx.Hash())
-	s.cfg.ConnMgr.AddRebroadcastInventory(iv, txD)
-
 	return tx.Hash().String(), nil
 }
 
@@ -3550,6 +5021,13 @@ func handleSubmitBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 		}
 	}
 
+	// Hand the accumulator proof of the template the block was most likely
+	// solved from to the chain so utreexo bridgenodes don't have to
+	// generate it again.  It is ignored when the block spends other outputs.
+	prevHash := &block.MsgBlock().Header.PrevBlock
+	s.cfg.Chain.AddPrecomputedUData(prevHash,
+		s.gbtWorkState.templateUData(prevHash))
+
 	// Process this block using the same rules as blocks coming from other
 	// nodes.  This will in turn relay it to the network like normal.
 	_, err = s.cfg.SyncMgr.SubmitBlock(block, blockchain.BFNone)
@@ -3561,11 +5039,325 @@ func handleSubmitBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return nil, nil
 }
 
+// decodePackage decodes the passed hex-encoded transactions of a package passed
+// to the testmempoolaccept and submitpackage commands, along with the passed
+// maximum fee rate in BTC/kvB.
+func decodePackage(rawTxns []string, maxFeeRate *float64) ([]*btcutil.Tx, btcutil.Amount, error) {
+	if len(rawTxns) == 0 || len(rawTxns) > mempool.MaxPackageCount {
+		return nil, 0, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Array must contain between 1 and "+
+				"%d transactions", mempool.MaxPackageCount),
+		}
+	}
+
+	txns := make([]*btcutil.Tx, 0, len(rawTxns))
+	for _, hexStr := range rawTxns {
+		if len(hexStr)%2 != 0 {
+			hexStr = "0" + hexStr
+		}
+		serializedTx, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, 0, rpcDecodeHexError(hexStr)
+		}
+		var msgTx wire.MsgTx
+		err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return nil, 0, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCDeserialization,
+				Message: "TX decode failed: " + err.Error(),
+			}
+		}
+		txns = append(txns, btcutil.NewTx(&msgTx))
+	}
+
+	var feeRate btcutil.Amount
+	if maxFeeRate != nil {
+		var err error
+		feeRate, err = btcutil.NewAmount(*maxFeeRate)
+		if err != nil || feeRate < 0 {
+			return nil, 0, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid maximum fee rate",
+			}
+		}
+	}
+
+	return txns, feeRate, nil
+}
+
+// mempoolAcceptFees returns the fees of the passed transaction of a package
+// processed by the memory pool, where the passed map gives the witness hashes
+// of the transactions of the package.
+func mempoolAcceptFees(res *mempool.PackageTxResult,
+	wtxids map[chainhash.Hash]string) *btcjson.MempoolAcceptFees {
+
+	includes := make([]string, 0, len(res.EffectiveIncludes))
+	for _, hash := range res.EffectiveIncludes {
+		includes = append(includes, wtxids[*hash])
+	}
+	return &btcjson.MempoolAcceptFees{
+		Base:              btcutil.Amount(res.Fee).ToBTC(),
+		EffectiveFeeRate:  btcutil.Amount(res.EffectiveFeePerKB).ToBTC(),
+		EffectiveIncludes: includes,
+	}
+}
+
+// handleSubmitPackage implements the submitpackage command.
+func handleSubmitPackage(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.SubmitPackageCmd)
+	txns, maxFeeRate, err := decodePackage(c.RawTxns, c.MaxFeeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgResult, err := s.cfg.TxMemPool.ProcessPackage(txns, maxFeeRate, false)
+	if err != nil {
+		rpcsLog.Debugf("Rejected package of transaction %v: %v",
+			txns[len(txns)-1].Hash(), err)
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCTxError,
+			Message: "Package rejected: " + err.Error(),
+		}
+	}
+
+	wtxids := make(map[chainhash.Hash]string, len(txns))
+	for _, tx := range txns {
+		wtxids[*tx.Hash()] = tx.WitnessHash().String()
+	}
+	result := &btcjson.SubmitPackageResult{
+		PackageMsg: "success",
+		TxResults: make(map[string]*btcjson.SubmitPackageTxResult,
+			len(txns)),
+		ReplacedTransactions: make([]string, 0,
+			len(pkgResult.Replaced)),
+	}
+	for _, res := range pkgResult.TxResults {
+		txResult := &btcjson.SubmitPackageTxResult{
+			Txid: res.Tx.Hash().String(),
+		}
+		result.TxResults[res.Tx.WitnessHash().String()] = txResult
+		if res.Err != nil {
+			rpcsLog.Debugf("Rejected transaction %v: %v",
+				res.Tx.Hash(), res.Err)
+			result.PackageMsg = "transaction failed"
+			txResult.Error = res.Err.Error()
+			continue
+		}
+
+		// The transaction in the pool may differ in its witness.
+		if res.AlreadyInPool {
+			poolTx, err := s.cfg.TxMemPool.FetchTransaction(res.Tx.Hash())
+			if err == nil && !poolTx.WitnessHash().IsEqual(
+				res.Tx.WitnessHash()) {
+
+				txResult.OtherWtxid = poolTx.WitnessHash().String()
+			}
+		}
+		txResult.Vsize = res.Size
+		txResult.Fees = mempoolAcceptFees(res, wtxids)
+	}
+	for _, tx := range pkgResult.Replaced {
+		result.ReplacedTransactions = append(result.ReplacedTransactions,
+			tx.Hash().String())
+	}
+
+	// Keep track of the transactions of the package so that they can be
+	// rebroadcast if they don't make their way into a block.
+	for _, res := range pkgResult.TxResults {
+		if res.TxDesc == nil {
+			continue
+		}
+		iv := wire.NewInvVect(wire.InvTypeTx, res.Tx.Hash())
+		s.cfg.ConnMgr.AddRebroadcastInventory(iv, res.TxDesc)
+	}
+
+	// Generate and relay inventory vectors for all newly accepted
+	// transactions, and notify both websocket and getblocktemplate long
+	// poll clients of them.
+	if len(pkgResult.Accepted) > 0 {
+		s.cfg.ConnMgr.RelayTransactions(pkgResult.Accepted)
+		s.NotifyNewTransactions(pkgResult.Accepted)
+	}
+
+	return result, nil
+}
+
+// handleTestMempoolAccept implements the testmempoolaccept command.
+func handleTestMempoolAccept(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.TestMempoolAcceptCmd)
+	txns, maxFeeRate, err := decodePackage(c.RawTxns, c.MaxFeeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	wtxids := make(map[chainhash.Hash]string, len(txns))
+	results := make([]*btcjson.TestMempoolAcceptResult, 0, len(txns))
+	for _, tx := range txns {
+		wtxids[*tx.Hash()] = tx.WitnessHash().String()
+		results = append(results, &btcjson.TestMempoolAcceptResult{
+			Txid:  tx.Hash().String(),
+			Wtxid: tx.WitnessHash().String(),
+		})
+	}
+
+	// Report the error on each transaction when they don't form a package.
+	pkgResult, err := s.cfg.TxMemPool.ProcessPackage(txns, maxFeeRate, true)
+	if err != nil {
+		for _, result := range results {
+			result.PackageError = err.Error()
+		}
+		return results, nil
+	}
+
+	for i, res := range pkgResult.TxResults {
+		result := results[i]
+		switch {
+		case res.Err != nil:
+			result.RejectReason = res.Err.Error()
+
+		case res.AlreadyInPool:
+			result.RejectReason = "transaction already in the memory pool"
+
+		default:
+			result.Allowed = true
+			result.Vsize = res.Size
+			result.Fees = mempoolAcceptFees(res, wtxids)
+		}
+	}
+
+	return results, nil
+}
+
 // handleUptime implements the uptime command.
 func handleUptime(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	return time.Now().Unix() - s.cfg.StartupTime, nil
 }
 
+// fetchTxUtxos returns the outputs spent by the inputs of the passed
+// transaction which are either in the UTXO set or created by a transaction in
+// the mempool.  Outputs which can't be found are left out.
+func fetchTxUtxos(s *rpcServer,
+	tx *wire.MsgTx) (map[wire.OutPoint]*wire.TxOut, error) {
+
+	utxos := make(map[wire.OutPoint]*wire.TxOut)
+	for _, txIn := range tx.TxIn {
+		outpoint := txIn.PreviousOutPoint
+
+		// Outputs of unconfirmed transactions come from the mempool.
+		tx, err := s.cfg.TxMemPool.FetchTransaction(&outpoint.Hash)
+		if err == nil {
+			txOuts := tx.MsgTx().TxOut
+			if outpoint.Index < uint32(len(txOuts)) {
+				utxos[outpoint] = txOuts[outpoint.Index]
+			}
+			continue
+		}
+
+		entry, err := s.cfg.Chain.FetchUtxoEntry(outpoint)
+		if err != nil {
+			context := "Failed to fetch utxo"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		if entry == nil || entry.IsSpent() {
+			continue
+		}
+		utxos[outpoint] = wire.NewTxOut(entry.Amount(), entry.PkScript())
+	}
+
+	return utxos, nil
+}
+
+// fetchUtxosFromUData returns the outputs proven by the passed hex-encoded
+// utreexo data after verifying the proof against the utreexo accumulator.  It
+// is used by compact state nodes which don't keep the UTXO set.
+func fetchUtxosFromUData(s *rpcServer,
+	udataHex *string) (map[wire.OutPoint]*wire.TxOut, error) {
+
+	if udataHex == nil {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: "Utreexo data proving the spent outputs is " +
+				"required by compact state nodes",
+		}
+	}
+	serializedUData, err := hex.DecodeString(*udataHex)
+	if err != nil {
+		return nil, rpcDecodeHexError(*udataHex)
+	}
+	var ud btcacc.UData
+	err = ud.Deserialize(bytes.NewReader(serializedUData))
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCDeserialization,
+			Message: "Utreexo data decode failed: " + err.Error(),
+		}
+	}
+	if err := s.cfg.Chain.VerifyUData(&ud); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCVerify,
+			Message: "Invalid utreexo data: " + err.Error(),
+		}
+	}
+
+	utxos := make(map[wire.OutPoint]*wire.TxOut, len(ud.Stxos))
+	for _, ld := range ud.Stxos {
+		outpoint := wire.OutPoint{
+			Hash:  chainhash.Hash(ld.TxHash),
+			Index: ld.Index,
+		}
+		utxos[outpoint] = wire.NewTxOut(ld.Amt, ld.PkScript)
+	}
+
+	return utxos, nil
+}
+
+// handleUtxoUpdatePsbt handles utxoupdatepsbt commands.
+func handleUtxoUpdatePsbt(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.UtxoUpdatePsbtCmd)
+
+	packet, err := decodePsbt(c.Psbt)
+	if err != nil {
+		return nil, err
+	}
+
+	var utxos map[wire.OutPoint]*wire.TxOut
+	if s.utreexoCSN {
+		utxos, err = fetchUtxosFromUData(s, c.UData)
+	} else {
+		utxos, err = fetchTxUtxos(s, packet.UnsignedTx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, txIn := range packet.UnsignedTx.TxIn {
+		pInput := &packet.Inputs[i]
+		txOut, ok := utxos[txIn.PreviousOutPoint]
+		if pInput.WitnessUtxo != nil || !ok {
+			continue
+		}
+
+		// Only outputs which may be spent with a witness get a witness
+		// UTXO.  Signers of legacy inputs need the full previous
+		// transaction, which the UTXO set doesn't have.
+		if !txscript.IsWitnessProgram(txOut.PkScript) &&
+			!txscript.IsPayToScriptHash(txOut.PkScript) {
+
+			continue
+		}
+		pInput.WitnessUtxo = txOut
+	}
+
+	if err := packet.SanityCheck(); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Unable to update PSBT: " + err.Error(),
+		}
+	}
+	return encodePsbt(packet)
+}
+
 // handleValidateAddress implements the validateaddress command.
 func handleValidateAddress(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.ValidateAddressCmd)
@@ -3765,6 +5557,7 @@ type rpcServer struct {
 	requestProcessShutdown chan struct{}
 	quit                   chan int
 	utreexoCSN             bool
+	scanTxOutSet           scanTxOutSetState
 }
 
 // httpStatusLine returns a response Status-Line (RFC 2616 Section 6.1)
@@ -4280,6 +6073,10 @@ type rpcserverPeer interface {
 	// FeeFilter returns the requested current minimum fee rate for which
 	// transactions should be announced.
 	FeeFilter() int64
+
+	// ByteCounters returns snapshots of the bytes sent to and received
+	// from the peer per message type.
+	ByteCounters() (byteCountersSnapshot, byteCountersSnapshot)
 }
 
 // rpcserverConnManager represents a connection manager for use with the RPC
@@ -4322,6 +6119,13 @@ type rpcserverConnManager interface {
 	// network for all peers.
 	NetTotals() (uint64, uint64)
 
+	// ByteCounters returns snapshots of the bytes sent to and received
+	// from all peers per message type.
+	ByteCounters() (byteCountersSnapshot, byteCountersSnapshot)
+
+	// UploadTarget returns a snapshot of the state of the upload target.
+	UploadTarget() uploadTargetSnapshot
+
 	// ConnectedPeers returns an array consisting of all connected peers.
 	ConnectedPeers() []rpcserverPeer
 
@@ -4338,6 +6142,11 @@ type rpcserverConnManager interface {
 	// in a block.
 	AddRebroadcastInventory(iv *wire.InvVect, data interface{})
 
+	// RebroadcastInfo returns snapshots of the rebroadcast state of the
+	// transactions submitted through the RPC server which haven't been
+	// mined yet.
+	RebroadcastInfo() []rebroadcastTxSnapshot
+
 	// RelayTransactions generates and relays inventory vectors for all of
 	// the passed transactions to all connected peers.
 	RelayTransactions(txns []*mempool.TxDesc)
@@ -4372,6 +6181,10 @@ type rpcserverSyncManager interface {
 	// current tip is reached, up to a max of wire.MaxBlockHeadersPerMsg
 	// hashes.
 	LocateHeaders(locators []*chainhash.Hash, hashStop *chainhash.Hash) []wire.BlockHeader
+
+	// FetchUBlock requests the ublock with the passed hash from the peers
+	// serving utreexo proofs and returns it without processing it.
+	FetchUBlock(hash *chainhash.Hash) (*btcutil.UBlock, error)
 }
 
 // rpcserverConfig is a descriptor containing the RPC server configuration.
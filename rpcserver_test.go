@@ -0,0 +1,349 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+)
+
+// gbtTestWindow is the number of blocks in the threshold state windows of the
+// chains of the getblocktemplate tests.
+const gbtTestWindow = 4
+
+// The subsystem loggers can't be used before the log rotator is initialized,
+// which the tests don't do.
+func init() {
+	setLogLevels("off")
+}
+
+// emptyTxSource is a transaction source without any transaction.
+type emptyTxSource struct{}
+
+// LastUpdated returns the zero time.  It is part of the mining.TxSource
+// interface.
+func (emptyTxSource) LastUpdated() time.Time {
+	return time.Time{}
+}
+
+// MiningDescs returns no descriptors.  It is part of the mining.TxSource
+// interface.
+func (emptyTxSource) MiningDescs() []*mining.TxDesc {
+	return nil
+}
+
+// HaveTransaction returns false.  It is part of the mining.TxSource interface.
+func (emptyTxSource) HaveTransaction(hash *chainhash.Hash) bool {
+	return false
+}
+
+// newGBTTestServer returns an RPC server over a regression test chain with the
+// passed deployments, whose threshold states are computed over windows of
+// gbtTestWindow blocks, along with a generator of block templates without
+// transactions extending it and a function to tear them down.
+func newGBTTestServer(t *testing.T,
+	deployments [chaincfg.DefinedDeployments]chaincfg.ConsensusDeployment) (
+	*rpcServer, *mining.BlkTmplGenerator, func()) {
+
+	t.Helper()
+
+	dataDir, err := ioutil.TempDir("", "rpcserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	params := chaincfg.RegressionNetParams
+	params.Deployments = deployments
+	params.MinerConfirmationWindow = gbtTestWindow
+	params.RuleChangeActivationThreshold = gbtTestWindow - 1
+	db, err := database.Create("ffldb", filepath.Join(dataDir, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dataDir)
+	}
+
+	timeSource := blockchain.NewMedianTime()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:               db,
+		UtxoCacheMaxSize: 10 * 1024 * 1024,
+		ChainParams:      &params,
+		TimeSource:       timeSource,
+		DataDir:          dataDir,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	policy := &mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize,
+	}
+	g := mining.NewBlkTmplGenerator(policy, &params, emptyTxSource{}, chain,
+		timeSource, txscript.NewSigCache(100),
+		txscript.NewHashCache(100), txscript.NewScriptExecCache(100))
+	s := &rpcServer{cfg: rpcserverConfig{
+		Chain:       chain,
+		ChainParams: &params,
+	}}
+	return s, g, teardown
+}
+
+// newGBTTestBlock returns a new block template of the passed generator with the
+// passed extra nonce.
+func newGBTTestBlock(t *testing.T, g *mining.BlkTmplGenerator,
+	extraNonce uint64) *btcutil.Block {
+
+	t.Helper()
+
+	template, err := g.NewBlockTemplate(nil)
+	if err != nil {
+		t.Fatalf("unable to create block template: %v", err)
+	}
+	err = g.UpdateExtraNonce(template.Block, template.Height, extraNonce)
+	if err != nil {
+		t.Fatalf("unable to update extra nonce: %v", err)
+	}
+	return btcutil.NewBlock(template.Block)
+}
+
+// processGBTTestBlock processes the passed block, whose proof of work is not
+// checked, and returns the error processing it.
+func processGBTTestBlock(s *rpcServer, block *btcutil.Block) error {
+	_, _, err := s.cfg.Chain.ProcessBlock(block, blockchain.BFNoPoWCheck)
+	return err
+}
+
+// proposeGBTTestBlock returns the result of proposing the passed block.
+func proposeGBTTestBlock(t *testing.T, s *rpcServer,
+	block *btcutil.Block) interface{} {
+
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := block.MsgBlock().Serialize(&buf); err != nil {
+		t.Fatalf("unable to serialize block: %v", err)
+	}
+	result, err := handleGetBlockTemplateProposal(s, &btcjson.TemplateRequest{
+		Mode: "proposal",
+		Data: hex.EncodeToString(buf.Bytes()),
+	})
+	if err != nil {
+		t.Fatalf("unable to propose block: %v", err)
+	}
+	return result
+}
+
+// TestGBTVersionBits ensures the versionbits deployments are negotiated with
+// the rules supported by getblocktemplate clients as per BIP0009.
+func TestGBTVersionBits(t *testing.T) {
+	t.Parallel()
+
+	// The dummy deployment never starts, csv is always active, and segwit
+	// and taproot are started once the first window has passed.
+	var deployments [chaincfg.DefinedDeployments]chaincfg.ConsensusDeployment
+	deployments[chaincfg.DeploymentTestDummy] = chaincfg.ConsensusDeployment{
+		BitNumber:  28,
+		StartTime:  math.MaxInt64,
+		ExpireTime: math.MaxInt64,
+	}
+	deployments[chaincfg.DeploymentCSV] = chaincfg.ConsensusDeployment{
+		BitNumber:    0,
+		AlwaysActive: true,
+	}
+	deployments[chaincfg.DeploymentSegwit] = chaincfg.ConsensusDeployment{
+		BitNumber:  1,
+		ExpireTime: math.MaxInt64,
+	}
+	deployments[chaincfg.DeploymentTaproot] = chaincfg.ConsensusDeployment{
+		BitNumber:  2,
+		ExpireTime: math.MaxInt64,
+	}
+	s, g, teardown := newGBTTestServer(t, deployments)
+	defer teardown()
+	for i := 0; i < gbtTestWindow; i++ {
+		if err := processGBTTestBlock(s, newGBTTestBlock(t, g, 0)); err != nil {
+			t.Fatalf("unable to process block: %v", err)
+		}
+	}
+
+	// The bit of segwit, which changes the block template, is only left
+	// set for clients which support it, unlike the one of taproot.
+	const version = 0x20000000 | 1<<1 | 1<<2
+	tests := []struct {
+		name        string
+		clientRules map[string]struct{}
+		wantVersion int32
+	}{{
+		name:        "no rules",
+		wantVersion: 0x20000000 | 1<<2,
+	}, {
+		name:        "segwit",
+		clientRules: map[string]struct{}{"segwit": {}},
+		wantVersion: version,
+	}}
+	for _, test := range tests {
+		rules, vbAvailable, gotVersion, err := gbtVersionBits(s,
+			version, test.clientRules)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(rules, []string{"csv"}) {
+			t.Fatalf("%s: got rules %v, want csv", test.name, rules)
+		}
+		wantAvailable := map[string]uint8{"segwit": 1, "taproot": 2}
+		if !reflect.DeepEqual(vbAvailable, wantAvailable) {
+			t.Fatalf("%s: got available deployments %v, want %v",
+				test.name, vbAvailable, wantAvailable)
+		}
+		if gotVersion != test.wantVersion {
+			t.Fatalf("%s: got version %#x, want %#x", test.name,
+				gotVersion, test.wantVersion)
+		}
+	}
+}
+
+// TestGBTSegwitRule ensures getblocktemplate requires clients to support the
+// active segwit deployment, which is reported as a rule which can't be ignored.
+func TestGBTSegwitRule(t *testing.T) {
+	t.Parallel()
+
+	var deployments [chaincfg.DefinedDeployments]chaincfg.ConsensusDeployment
+	for i := range deployments {
+		deployments[i] = chaincfg.ConsensusDeployment{
+			BitNumber:  uint8(i),
+			StartTime:  math.MaxInt64,
+			ExpireTime: math.MaxInt64,
+		}
+	}
+	deployments[chaincfg.DeploymentSegwit] = chaincfg.ConsensusDeployment{
+		BitNumber:    1,
+		AlwaysActive: true,
+	}
+	s, _, teardown := newGBTTestServer(t, deployments)
+	defer teardown()
+
+	_, _, _, err := gbtVersionBits(s, 0x20000000, nil)
+	rpcErr, ok := err.(*btcjson.RPCError)
+	if !ok || rpcErr.Code != btcjson.ErrRPCInvalidParameter {
+		t.Fatalf("got error %v, want %v", err,
+			btcjson.ErrRPCInvalidParameter)
+	}
+
+	rules, vbAvailable, _, err := gbtVersionBits(s, 0x20000000,
+		map[string]struct{}{"segwit": {}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(rules, []string{"!segwit"}) {
+		t.Fatalf("got rules %v, want !segwit", rules)
+	}
+	if len(vbAvailable) != 0 {
+		t.Fatalf("got available deployments %v, want none", vbAvailable)
+	}
+}
+
+// TestGBTProposalDuplicates ensures block proposals which are already known
+// are reported along with what is known about their validity, and that the
+// ones which don't build on the tip are inconclusive.
+func TestGBTProposalDuplicates(t *testing.T) {
+	t.Parallel()
+
+	s, g, teardown := newGBTTestServer(t,
+		chaincfg.RegressionNetParams.Deployments)
+	defer teardown()
+	chain := s.cfg.Chain
+
+	// Two blocks compete at the same height, with the first one remaining
+	// the tip, while a third one is never processed.
+	tip := newGBTTestBlock(t, g, 0)
+	side := newGBTTestBlock(t, g, 1)
+	stale := newGBTTestBlock(t, g, 2)
+	for _, block := range []*btcutil.Block{tip, side} {
+		if err := processGBTTestBlock(s, block); err != nil {
+			t.Fatalf("unable to process block: %v", err)
+		}
+	}
+
+	// A block paying more than its subsidy is known to be invalid once
+	// processed.
+	invalid := newGBTTestBlock(t, g, 0)
+	invalid.MsgBlock().Transactions[0].TxOut[0].Value++
+	err := g.UpdateExtraNonce(invalid.MsgBlock(),
+		g.BestSnapshot().Height+1, 0)
+	if err != nil {
+		t.Fatalf("unable to update extra nonce: %v", err)
+	}
+	invalid = btcutil.NewBlock(invalid.MsgBlock())
+	err = processGBTTestBlock(s, invalid)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrBadCoinbaseValue {
+
+		t.Fatalf("got error %v, want %v", err,
+			blockchain.ErrBadCoinbaseValue)
+	}
+	if !chain.IsKnownInvalid(invalid.Hash()) {
+		t.Fatal("invalid block not known to be invalid")
+	}
+	for _, block := range []*btcutil.Block{tip, side, stale} {
+		if chain.IsKnownInvalid(block.Hash()) {
+			t.Fatalf("block %v known to be invalid", block.Hash())
+		}
+	}
+
+	tests := []struct {
+		name  string
+		block *btcutil.Block
+		want  interface{}
+	}{{
+		name:  "tip",
+		block: tip,
+		want:  "duplicate",
+	}, {
+		name:  "invalid",
+		block: invalid,
+		want:  "duplicate-invalid",
+	}, {
+		name:  "side chain",
+		block: side,
+		want:  "duplicate-inconclusive",
+	}, {
+		name:  "not building on the tip",
+		block: stale,
+		want:  "inconclusive-not-best-prevblk",
+	}, {
+		name:  "new",
+		block: newGBTTestBlock(t, g, 3),
+		want:  nil,
+	}}
+	for _, test := range tests {
+		result := proposeGBTTestBlock(t, s, test.block)
+		if result != test.want {
+			t.Fatalf("%s: got result %v, want %v", test.name, result,
+				test.want)
+		}
+	}
+}
@@ -29,6 +29,43 @@ var helpDescsEnUS = map[string]string{
 	"debuglevel--result0":    "The string 'Done.'",
 	"debuglevel--result1":    "The list of subsystems",
 
+	// DebugScriptCmd help.
+	"debugscript--synopsis": "Executes the scripts of an input of a transaction and returns the trace of each executed opcode.\n" +
+		"The spent output is looked up in the UTXO set and the memory pool, or in the passed utreexo data by compact state nodes.",
+	"debugscript-hextx":      "Serialized, hex-encoded transaction",
+	"debugscript-inputindex": "The index of the input to execute the scripts of",
+	"debugscript-udata":      "The hex-encoded utreexo data proving the spent outputs (compact state nodes only)",
+
+	// DebugScriptResult help.
+	"debugscriptresult-txid":         "The hash of the transaction",
+	"debugscriptresult-vin":          "The index of the executed input",
+	"debugscriptresult-amount":       "The amount of the spent output in BTC",
+	"debugscriptresult-scriptpubkey": "The hex-encoded public key script of the spent output",
+	"debugscriptresult-flags":        "The script verification flags the scripts were executed with",
+	"debugscriptresult-steps":        "The opcodes executed in order",
+	"debugscriptresult-valid":        "Whether the scripts executed successfully",
+	"debugscriptresult-error":        "The error the execution failed with",
+
+	// DebugScriptStep help.
+	"debugscriptstep-script":      "The index of the script of the opcode (0 is the signature script, 1 the public key script, followed by the redeem or witness script)",
+	"debugscriptstep-index":       "The index of the opcode in its script",
+	"debugscriptstep-opcode":      "The disassembled opcode",
+	"debugscriptstep-executed":    "Whether the opcode was executed rather than skipped by a conditional",
+	"debugscriptstep-stackbefore": "The hex-encoded data stack before the opcode, top element last",
+	"debugscriptstep-stack":       "The hex-encoded data stack after the opcode, top element last",
+	"debugscriptstep-altstack":    "The hex-encoded alternate stack after the opcode, top element last",
+	"debugscriptstep-condstack":   "The condition stack after the opcode (0 false, 1 true, 2 skipped)",
+	"debugscriptstep-numops":      "The number of non-push operations of the script counted so far",
+	"debugscriptstep-sigchecks":   "The signatures checked by the opcode, including a taproot key path signature",
+	"debugscriptstep-error":       "The error the opcode failed with",
+
+	// DebugScriptSigCheck help.
+	"debugscriptsigcheck-pubkey":    "The hex-encoded public key",
+	"debugscriptsigcheck-signature": "The hex-encoded signature without its hash type",
+	"debugscriptsigcheck-hashtype":  "The hash type of the signature",
+	"debugscriptsigcheck-sighash":   "The hex-encoded signature hash",
+	"debugscriptsigcheck-valid":     "Whether the signature is valid",
+
 	// AddNodeCmd help.
 	"addnode--synopsis": "Attempts to add or remove a persistent peer.",
 	"addnode-addr":      "IP address and port of the peer to operate on",
@@ -44,6 +81,30 @@ var helpDescsEnUS = map[string]string{
 	"transactioninput-txid": "The hash of the input transaction",
 	"transactioninput-vout": "The specific output of the input transaction to redeem",
 
+	// PsbtInput help.
+	"psbtinput-txid":     "The hash of the input transaction",
+	"psbtinput-vout":     "The specific output of the input transaction to redeem",
+	"psbtinput-sequence": "The sequence number of the input, zero selects the default",
+
+	// CombinePsbtCmd help.
+	"combinepsbt--synopsis": "Combines the key-value pairs of several PSBTs for the same transaction into one PSBT.\n" +
+		"Values from PSBTs earlier in the list take precedence over conflicting values from later ones.",
+	"combinepsbt-psbts":    "The base64-encoded PSBTs to combine",
+	"combinepsbt--result0": "The base64-encoded combined PSBT",
+
+	// CreatePsbtCmd help.
+	"createpsbt--synopsis": "Returns a new PSBT spending the provided inputs and sending to the provided outputs.\n" +
+		"The PSBT holds no information about the inputs, the utxoupdatepsbt command can be used to add it.",
+	"createpsbt-inputs":         "The inputs to the transaction",
+	"createpsbt-outputs":        "The outputs of the transaction in order, each either a destination address and amount or a data output",
+	"createpsbt-outputs--key":   "address or \"data\"",
+	"createpsbt-outputs--value": "n.nnn or \"hex\"",
+	"createpsbt-outputs--desc":  "The destination address as the key and the amount in BTC as the value, or \"data\" as the key and the hex-encoded data to embed as the value",
+	"createpsbt-locktime":       "Locktime value; a non-zero value will also locktime-activate the inputs",
+	"createpsbt-replaceable":    "Signal replaceability as defined by BIP 125 in the default sequence numbers of the inputs",
+	"createpsbt-psbtversion":    "The PSBT version to create, either 0 (BIP 174) or 2 (BIP 370)",
+	"createpsbt--result0":       "The base64-encoded PSBT",
+
 	// CreateRawTransactionCmd help.
 	"createrawtransaction--synopsis": "Returns a new transaction spending the provided inputs and sending to the provided addresses.\n" +
 		"The transaction inputs are not signed in the created transaction.\n" +
@@ -100,6 +161,94 @@ var helpDescsEnUS = map[string]string{
 	"txrawdecoderesult-vin":      "The transaction inputs as JSON objects",
 	"txrawdecoderesult-vout":     "The transaction outputs as JSON objects",
 
+	// PsbtScriptResult help.
+	"psbtscriptresult-asm":  "Disassembly of the script",
+	"psbtscriptresult-hex":  "Hex-encoded bytes of the script",
+	"psbtscriptresult-type": "The type of the script (e.g. 'witness_v0_keyhash')",
+
+	// PsbtWitnessUtxoResult help.
+	"psbtwitnessutxoresult-amount":       "The amount of the spent output in BTC",
+	"psbtwitnessutxoresult-scriptPubKey": "The public key script of the spent output as a JSON object",
+
+	// PsbtBip32DerivResult help.
+	"psbtbip32derivresult-pubkey":             "The hex-encoded public key",
+	"psbtbip32derivresult-master_fingerprint": "The hex-encoded fingerprint of the master key",
+	"psbtbip32derivresult-path":               "The derivation path of the public key",
+
+	// PsbtTaprootBip32DerivResult help.
+	"psbttaprootbip32derivresult-pubkey":             "The hex-encoded x-only public key",
+	"psbttaprootbip32derivresult-master_fingerprint": "The hex-encoded fingerprint of the master key",
+	"psbttaprootbip32derivresult-path":               "The derivation path of the public key",
+	"psbttaprootbip32derivresult-leaf_hashes":        "The hashes of the leaves the public key is used in",
+
+	// PsbtTaprootScriptSigResult help.
+	"psbttaprootscriptsigresult-pubkey":    "The hex-encoded x-only public key the signature is for",
+	"psbttaprootscriptsigresult-leaf_hash": "The hash of the leaf the signature is for",
+	"psbttaprootscriptsigresult-sig":       "The hex-encoded signature",
+
+	// PsbtTaprootScriptResult help.
+	"psbttaprootscriptresult-script":         "The hex-encoded leaf script",
+	"psbttaprootscriptresult-leaf_ver":       "The leaf version of the script",
+	"psbttaprootscriptresult-control_blocks": "The hex-encoded control blocks for the script",
+
+	// PsbtTaprootTreeLeafResult help.
+	"psbttaproottreeleafresult-depth":    "The depth of the leaf in the tree",
+	"psbttaproottreeleafresult-leaf_ver": "The leaf version of the script",
+	"psbttaproottreeleafresult-script":   "The hex-encoded leaf script",
+
+	// PsbtInputResult help.
+	"psbtinputresult-non_witness_utxo":          "The full transaction of the spent output, for non-segwit inputs",
+	"psbtinputresult-witness_utxo":              "The spent output, for segwit inputs",
+	"psbtinputresult-partial_signatures":        "The partial signatures of the input",
+	"psbtinputresult-partial_signatures--key":   "pubkey",
+	"psbtinputresult-partial_signatures--value": "signature",
+	"psbtinputresult-partial_signatures--desc":  "The hex-encoded public key as the key and the hex-encoded signature as the value",
+	"psbtinputresult-sighash":                   "The signature hash type to sign with",
+	"psbtinputresult-redeem_script":             "The redeem script of the input",
+	"psbtinputresult-witness_script":            "The witness script of the input",
+	"psbtinputresult-bip32_derivs":              "The BIP32 derivation paths of the public keys of the input",
+	"psbtinputresult-final_scriptSig":           "The final signature script of the input",
+	"psbtinputresult-final_scriptwitness":       "The final witness of the input encoded as a string array of its items",
+	"psbtinputresult-taproot_key_path_sig":      "The hex-encoded taproot key path signature",
+	"psbtinputresult-taproot_script_path_sigs":  "The taproot script path signatures",
+	"psbtinputresult-taproot_scripts":           "The taproot leaf scripts along with their control blocks",
+	"psbtinputresult-taproot_bip32_derivs":      "The BIP32 derivation paths of the taproot public keys of the input",
+	"psbtinputresult-taproot_internal_key":      "The hex-encoded taproot internal key",
+	"psbtinputresult-taproot_merkle_root":       "The hex-encoded taproot merkle root",
+	"psbtinputresult-required_time_locktime":    "The minimum time based locktime required by the input (PSBT version 2 only)",
+	"psbtinputresult-required_height_locktime":  "The minimum height based locktime required by the input (PSBT version 2 only)",
+	"psbtinputresult-unknown":                   "The unknown key-value pairs of the input",
+	"psbtinputresult-unknown--key":              "key",
+	"psbtinputresult-unknown--value":            "value",
+	"psbtinputresult-unknown--desc":             "The hex-encoded key as the key and the hex-encoded value as the value",
+
+	// PsbtOutputResult help.
+	"psbtoutputresult-redeem_script":        "The redeem script of the output",
+	"psbtoutputresult-witness_script":       "The witness script of the output",
+	"psbtoutputresult-bip32_derivs":         "The BIP32 derivation paths of the public keys of the output",
+	"psbtoutputresult-taproot_internal_key": "The hex-encoded taproot internal key",
+	"psbtoutputresult-taproot_tree":         "The leaves of the taproot tree in depth-first order",
+	"psbtoutputresult-taproot_bip32_derivs": "The BIP32 derivation paths of the taproot public keys of the output",
+	"psbtoutputresult-unknown":              "The unknown key-value pairs of the output",
+	"psbtoutputresult-unknown--key":         "key",
+	"psbtoutputresult-unknown--value":       "value",
+	"psbtoutputresult-unknown--desc":        "The hex-encoded key as the key and the hex-encoded value as the value",
+
+	// DecodePsbtResult help.
+	"decodepsbtresult-tx":             "The unsigned transaction as a JSON object",
+	"decodepsbtresult-psbt_version":   "The version of the PSBT",
+	"decodepsbtresult-unknown":        "The unknown global key-value pairs",
+	"decodepsbtresult-unknown--key":   "key",
+	"decodepsbtresult-unknown--value": "value",
+	"decodepsbtresult-unknown--desc":  "The hex-encoded key as the key and the hex-encoded value as the value",
+	"decodepsbtresult-inputs":         "The inputs of the PSBT as JSON objects",
+	"decodepsbtresult-outputs":        "The outputs of the PSBT as JSON objects",
+	"decodepsbtresult-fee":            "The transaction fee in BTC (only when the amounts of all spent outputs are known)",
+
+	// DecodePsbtCmd help.
+	"decodepsbt--synopsis": "Returns a JSON object representing the provided base64-encoded PSBT.",
+	"decodepsbt-psbt":      "The base64-encoded PSBT",
+
 	// DecodeRawTransactionCmd help.
 	"decoderawtransaction--synopsis": "Returns a JSON object representing the provided serialized, hex-encoded transaction.",
 	"decoderawtransaction-hextx":     "Serialized, hex-encoded transaction",
@@ -115,6 +264,15 @@ var helpDescsEnUS = map[string]string{
 	"decodescript--synopsis": "Returns a JSON object with information about the provided hex-encoded script.",
 	"decodescript-hexscript": "Hex-encoded script",
 
+	// DeriveAddressesCmd help.
+	"deriveaddresses--synopsis":  "Derives one or more addresses corresponding to an output descriptor.",
+	"deriveaddresses-descriptor": "The descriptor, which must carry a checksum",
+	"deriveaddresses-range":      "The end or the [begin,end] range to derive, required for ranged descriptors and not allowed for others",
+	"deriveaddresses--result0":   "The derived addresses",
+
+	// DescriptorRange help.
+	"descriptorrange-value": "The end of the range as a number or the range as a [begin,end] array",
+
 	// EstimateFeeCmd help.
 	"estimatefee--synopsis": "Estimate the fee per kilobyte in satoshis " +
 		"required for a transaction to be mined before a certain number of " +
@@ -124,6 +282,35 @@ var helpDescsEnUS = map[string]string{
 	"estimatefee--result0": "Estimated fee per kilobyte in satoshis for a block to " +
 		"be mined in the next NumBlocks blocks.",
 
+	// EstimateSmartFeeCmd help.
+	"estimatesmartfee--synopsis": "Estimate the fee per kilobyte in bitcoins " +
+		"required for a transaction to be confirmed within a number of blocks, " +
+		"from how fast the transactions entering the memory pool are confirmed, " +
+		"or from the fee rates included by the recent blocks on compact state nodes.",
+	"estimatesmartfee-conftarget": "The number of blocks the transaction should " +
+		"be confirmed within (1 to 1008).",
+	"estimatesmartfee-estimatemode": "The estimate mode: ECONOMICAL, or " +
+		"CONSERVATIVE, which reacts slower to fee rates going down (UNSET " +
+		"is CONSERVATIVE)",
+
+	// EstimateSmartFeeResult help.
+	"estimatesmartfeeresult-feerate": "The estimated fee rate in bitcoins per kilobyte, " +
+		"which is at least the minimum fee rate of the memory pool",
+	"estimatesmartfeeresult-errors": "The errors encountered during the estimation",
+	"estimatesmartfeeresult-blocks": "The number of blocks the fee rate was estimated " +
+		"for, which is lower than the requested one when not enough blocks were tracked",
+
+	// FinalizePsbtResult help.
+	"finalizepsbtresult-psbt":     "The base64-encoded PSBT (only when the transaction isn't extracted)",
+	"finalizepsbtresult-hex":      "The hex-encoded network serialized transaction (only when it is extracted)",
+	"finalizepsbtresult-complete": "Whether or not all inputs of the transaction are finalized",
+
+	// FinalizePsbtCmd help.
+	"finalizepsbt--synopsis": "Finalizes the inputs of a PSBT which have all required signatures.\n" +
+		"When all inputs are finalized the network serialized transaction may be extracted.",
+	"finalizepsbt-psbt":    "The base64-encoded PSBT",
+	"finalizepsbt-extract": "Return the network serialized transaction instead of the PSBT when all inputs are finalized",
+
 	// GenerateCmd help
 	"generate--synopsis": "Generates a set number of blocks (simnet or regtest only) and returns a JSON\n" +
 		" array of their hashes.",
@@ -335,7 +522,13 @@ var helpDescsEnUS = map[string]string{
 	"getblocktemplateresult-noncerange":                 "Two concatenated hex-encoded big-endian 32-bit integers which represent the valid ranges of nonces the miner may scan",
 	"getblocktemplateresult-capabilities":               "List of server capabilities including 'proposal' to indicate support for block proposals",
 	"getblocktemplateresult-reject-reason":              "Reason the proposal was invalid as-is (only applies to proposal responses)",
-	"getblocktemplateresult-default_witness_commitment": "The witness commitment itself. Will be populated if the block has witness data",
+	"getblocktemplateresult-default_witness_commitment": "Hex-encoded script of the coinbase output which commits to the witness data of the template transactions; populated once segwit is active",
+	"getblocktemplateresult-rules":                      "The versionbits deployments active for the block; a '!' prefix means the client must support the rule",
+	"getblocktemplateresult-vbavailable":                "The versionbits deployments which may be signalled in the block version",
+	"getblocktemplateresult-vbavailable--key":           "name",
+	"getblocktemplateresult-vbavailable--value":         "bit",
+	"getblocktemplateresult-vbavailable--desc":          "The bit number of the deployment",
+	"getblocktemplateresult-vbrequired":                 "Bit mask of the versionbits the block version must have set",
 	"getblocktemplateresult-weightlimit":                "The current limit on the max allowed weight of a block",
 
 	// GetBlockTemplateCmd help.
@@ -367,6 +560,17 @@ var helpDescsEnUS = map[string]string{
 	"getcurrentnet--synopsis": "Get bitcoin network the server is running on.",
 	"getcurrentnet--result0":  "The network identifer",
 
+	// GetDescriptorInfoCmd help.
+	"getdescriptorinfo--synopsis":  "Analyses an output descriptor.",
+	"getdescriptorinfo-descriptor": "The descriptor, with or without a checksum",
+
+	// GetDescriptorInfoResult help.
+	"getdescriptorinforesult-descriptor":     "The descriptor in canonical form, without private keys",
+	"getdescriptorinforesult-checksum":       "The checksum of the input descriptor",
+	"getdescriptorinforesult-isrange":        "Whether the descriptor is ranged",
+	"getdescriptorinforesult-issolvable":     "Whether the descriptor is solvable",
+	"getdescriptorinforesult-hasprivatekeys": "Whether the input descriptor contained at least one private key",
+
 	// GetDifficultyCmd help.
 	"getdifficulty--synopsis": "Returns the proof-of-work difficulty as a multiple of the minimum difficulty.",
 	"getdifficulty--result0":  "The difficulty",
@@ -418,12 +622,66 @@ var helpDescsEnUS = map[string]string{
 	// GetInfoCmd help.
 	"getinfo--synopsis": "Returns a JSON object containing various state info.",
 
+	// GetMempoolAncestorsCmd help.
+	"getmempoolancestors--synopsis":       "Returns all of the in-mempool ancestors of a transaction in the memory pool.",
+	"getmempoolancestors-txid":            "The hash of the transaction",
+	"getmempoolancestors-verbose":         "Returns JSON objects keyed by transaction hash when true or an array of transaction hashes when false",
+	"getmempoolancestors--condition0":     "verbose=false",
+	"getmempoolancestors--condition1":     "verbose=true",
+	"getmempoolancestors--result0":        "Array of transaction hashes",
+	"getmempoolancestors--result1--desc":  "Memory pool entries keyed by transaction hash",
+	"getmempoolancestors--result1--key":   "Transaction hash",
+	"getmempoolancestors--result1--value": "Object containing the memory pool data about the transaction",
+
+	// GetMempoolDescendantsCmd help.
+	"getmempooldescendants--synopsis":       "Returns all of the in-mempool descendants of a transaction in the memory pool.",
+	"getmempooldescendants-txid":            "The hash of the transaction",
+	"getmempooldescendants-verbose":         "Returns JSON objects keyed by transaction hash when true or an array of transaction hashes when false",
+	"getmempooldescendants--condition0":     "verbose=false",
+	"getmempooldescendants--condition1":     "verbose=true",
+	"getmempooldescendants--result0":        "Array of transaction hashes",
+	"getmempooldescendants--result1--desc":  "Memory pool entries keyed by transaction hash",
+	"getmempooldescendants--result1--key":   "Transaction hash",
+	"getmempooldescendants--result1--value": "Object containing the memory pool data about the transaction",
+
+	// GetMempoolEntryCmd help.
+	"getmempoolentry--synopsis": "Returns memory pool data about a transaction in the memory pool.",
+	"getmempoolentry-txid":      "The hash of the transaction",
+
+	// GetMempoolEntryResult help.
+	"getmempoolentryresult-vsize":           "The virtual size of the transaction",
+	"getmempoolentryresult-size":            "Transaction size in bytes",
+	"getmempoolentryresult-weight":          "The transaction's weight (between vsize*4-3 and vsize*4)",
+	"getmempoolentryresult-fee":             "Transaction fee in bitcoins",
+	"getmempoolentryresult-modifiedfee":     "Transaction fee in bitcoins with the fee deltas used for mining priority",
+	"getmempoolentryresult-time":            "Local time transaction entered pool in seconds since 1 Jan 1970 GMT",
+	"getmempoolentryresult-height":          "Block height when transaction entered the pool",
+	"getmempoolentryresult-descendantcount": "Number of in-mempool descendant transactions, including this one",
+	"getmempoolentryresult-descendantsize":  "Virtual size of in-mempool descendants, including this one",
+	"getmempoolentryresult-descendantfees":  "Fees of in-mempool descendants, including this one, in satoshis",
+	"getmempoolentryresult-ancestorcount":   "Number of in-mempool ancestor transactions, including this one",
+	"getmempoolentryresult-ancestorsize":    "Virtual size of in-mempool ancestors, including this one",
+	"getmempoolentryresult-ancestorfees":    "Fees of in-mempool ancestors, including this one, in satoshis",
+	"getmempoolentryresult-wtxid":           "Hash of the serialized transaction, including witness data",
+	"getmempoolentryresult-fees":            "Fees of the transaction and its package in bitcoins",
+	"getmempoolentryresult-depends":         "Unconfirmed transactions used as inputs for this transaction",
+
+	// MempoolFees help.
+	"mempoolfees-base":       "Transaction fee in bitcoins",
+	"mempoolfees-modified":   "Transaction fee in bitcoins with the fee deltas used for mining priority",
+	"mempoolfees-ancestor":   "Fees of in-mempool ancestors, including this one, in bitcoins",
+	"mempoolfees-descendant": "Fees of in-mempool descendants, including this one, in bitcoins",
+
 	// GetMempoolInfoCmd help.
 	"getmempoolinfo--synopsis": "Returns memory pool information",
 
 	// GetMempoolInfoResult help.
-	"getmempoolinforesult-bytes": "Size in bytes of the mempool",
-	"getmempoolinforesult-size":  "Number of transactions in the mempool",
+	"getmempoolinforesult-bytes":         "Size in bytes of the mempool",
+	"getmempoolinforesult-size":          "Number of transactions in the mempool",
+	"getmempoolinforesult-usage":         "Estimated memory usage in bytes of the mempool",
+	"getmempoolinforesult-maxmempool":    "Maximum estimated memory usage in bytes of the mempool (0 when unlimited)",
+	"getmempoolinforesult-mempoolminfee": "Minimum fee rate in BTC/kB for a transaction to be accepted, which rises over the minimum relay fee while the mempool is full",
+	"getmempoolinforesult-minrelaytxfee": "Minimum fee rate in BTC/kB for a transaction to be relayed",
 
 	// GetMiningInfoResult help.
 	"getmininginforesult-blocks":             "Height of the latest best block",
@@ -452,9 +710,30 @@ var helpDescsEnUS = map[string]string{
 	"getnettotals--synopsis": "Returns a JSON object containing network traffic statistics.",
 
 	// GetNetTotalsResult help.
-	"getnettotalsresult-totalbytesrecv": "Total bytes received",
-	"getnettotalsresult-totalbytessent": "Total bytes sent",
-	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
+	"getnettotalsresult-totalbytesrecv":           "Total bytes received",
+	"getnettotalsresult-totalbytessent":           "Total bytes sent",
+	"getnettotalsresult-timemillis":               "Number of milliseconds since 1 Jan 1970 GMT",
+	"getnettotalsresult-bytessent_per_msg":        "Total bytes sent per message type",
+	"getnettotalsresult-bytessent_per_msg--key":   "command",
+	"getnettotalsresult-bytessent_per_msg--value": "n",
+	"getnettotalsresult-bytessent_per_msg--desc":  "Bytes sent for messages of the command, with *other* for messages that failed to be sent",
+	"getnettotalsresult-bytesrecv_per_msg":        "Total bytes received per message type",
+	"getnettotalsresult-bytesrecv_per_msg--key":   "command",
+	"getnettotalsresult-bytesrecv_per_msg--value": "n",
+	"getnettotalsresult-bytesrecv_per_msg--desc":  "Bytes received for messages of the command, with *other* for messages that failed to be read",
+	"getnettotalsresult-blockbytessent":           "Total bytes of blocks sent, excluding their utreexo proofs",
+	"getnettotalsresult-proofbytessent":           "Total bytes of utreexo proofs sent as part of ublocks",
+	"getnettotalsresult-blockbytesrecv":           "Total bytes of blocks received, excluding their utreexo proofs",
+	"getnettotalsresult-proofbytesrecv":           "Total bytes of utreexo proofs received as part of ublocks",
+	"getnettotalsresult-uploadtarget":             "The state of the upload target set by --maxuploadtarget",
+
+	// GetNetTotalsUploadTarget help.
+	"getnettotalsuploadtarget-timeframe":               "Length of a measuring cycle in seconds",
+	"getnettotalsuploadtarget-target":                  "Target in bytes per cycle or 0 when there is no limit",
+	"getnettotalsuploadtarget-target_reached":          "Whether the target was reached during the current cycle",
+	"getnettotalsuploadtarget-serve_historical_blocks": "Whether historical blocks and ublocks are still served to peers that aren't whitelisted",
+	"getnettotalsuploadtarget-bytes_left_in_cycle":     "Bytes left before the target is reached in the current cycle",
+	"getnettotalsuploadtarget-time_left_in_cycle":      "Seconds left in the current cycle",
 
 	// GetNodeAddressesResult help.
 	"getnodeaddressesresult-time":     "Timestamp in seconds since epoch (Jan 1 1970 GMT) keeping track of when the node was last seen",
@@ -468,27 +747,41 @@ var helpDescsEnUS = map[string]string{
 	"getnodeaddresses--result0":  "List of node addresses",
 
 	// GetPeerInfoResult help.
-	"getpeerinforesult-id":             "A unique node ID",
-	"getpeerinforesult-addr":           "The ip address and port of the peer",
-	"getpeerinforesult-addrlocal":      "Local address",
-	"getpeerinforesult-services":       "Services bitmask which represents the services supported by the peer",
-	"getpeerinforesult-relaytxes":      "Peer has requested transactions be relayed to it",
-	"getpeerinforesult-lastsend":       "Time the last message was received in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-lastrecv":       "Time the last message was sent in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-bytessent":      "Total bytes sent",
-	"getpeerinforesult-bytesrecv":      "Total bytes received",
-	"getpeerinforesult-conntime":       "Time the connection was made in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-timeoffset":     "The time offset of the peer",
-	"getpeerinforesult-pingtime":       "Number of microseconds the last ping took",
-	"getpeerinforesult-pingwait":       "Number of microseconds a queued ping has been waiting for a response",
-	"getpeerinforesult-version":        "The protocol version of the peer",
-	"getpeerinforesult-subver":         "The user agent of the peer",
-	"getpeerinforesult-inbound":        "Whether or not the peer is an inbound connection",
-	"getpeerinforesult-startingheight": "The latest block height the peer knew about when the connection was established",
-	"getpeerinforesult-currentheight":  "The current height of the peer",
-	"getpeerinforesult-banscore":       "The ban score",
-	"getpeerinforesult-feefilter":      "The requested minimum fee a transaction must have to be announced to the peer",
-	"getpeerinforesult-syncnode":       "Whether or not the peer is the sync peer",
+	"getpeerinforesult-id":                       "A unique node ID",
+	"getpeerinforesult-addr":                     "The ip address and port of the peer",
+	"getpeerinforesult-addrlocal":                "Local address",
+	"getpeerinforesult-services":                 "Services bitmask which represents the services supported by the peer",
+	"getpeerinforesult-relaytxes":                "Peer has requested transactions be relayed to it",
+	"getpeerinforesult-lastsend":                 "Time the last message was received in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-lastrecv":                 "Time the last message was sent in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-bytessent":                "Total bytes sent",
+	"getpeerinforesult-bytesrecv":                "Total bytes received",
+	"getpeerinforesult-conntime":                 "Time the connection was made in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-timeoffset":               "The time offset of the peer",
+	"getpeerinforesult-pingtime":                 "Number of microseconds the last ping took",
+	"getpeerinforesult-pingwait":                 "Number of microseconds a queued ping has been waiting for a response",
+	"getpeerinforesult-version":                  "The protocol version of the peer",
+	"getpeerinforesult-subver":                   "The user agent of the peer",
+	"getpeerinforesult-inbound":                  "Whether or not the peer is an inbound connection",
+	"getpeerinforesult-startingheight":           "The latest block height the peer knew about when the connection was established",
+	"getpeerinforesult-currentheight":            "The current height of the peer",
+	"getpeerinforesult-banscore":                 "The ban score",
+	"getpeerinforesult-feefilter":                "The requested minimum fee a transaction must have to be announced to the peer",
+	"getpeerinforesult-syncnode":                 "Whether or not the peer is the sync peer",
+	"getpeerinforesult-transport_protocol_type":  "The transport protocol used with the peer (v1 or v2)",
+	"getpeerinforesult-session_id":               "The BIP0324 v2 transport session ID in hex or an empty string for v1 peers",
+	"getpeerinforesult-bytessent_per_msg":        "Bytes sent to the peer per message type",
+	"getpeerinforesult-bytessent_per_msg--key":   "command",
+	"getpeerinforesult-bytessent_per_msg--value": "n",
+	"getpeerinforesult-bytessent_per_msg--desc":  "Bytes sent for messages of the command, with *other* for messages that failed to be sent",
+	"getpeerinforesult-bytesrecv_per_msg":        "Bytes received from the peer per message type",
+	"getpeerinforesult-bytesrecv_per_msg--key":   "command",
+	"getpeerinforesult-bytesrecv_per_msg--value": "n",
+	"getpeerinforesult-bytesrecv_per_msg--desc":  "Bytes received for messages of the command, with *other* for messages that failed to be read",
+	"getpeerinforesult-blockbytessent":           "Bytes of blocks sent to the peer, excluding their utreexo proofs",
+	"getpeerinforesult-proofbytessent":           "Bytes of utreexo proofs sent to the peer as part of ublocks",
+	"getpeerinforesult-blockbytesrecv":           "Bytes of blocks received from the peer, excluding their utreexo proofs",
+	"getpeerinforesult-proofbytesrecv":           "Bytes of utreexo proofs received from the peer as part of ublocks",
 
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
@@ -503,6 +796,12 @@ var helpDescsEnUS = map[string]string{
 	"getrawmempoolverboseresult-depends":          "Unconfirmed transactions used as inputs for this transaction",
 	"getrawmempoolverboseresult-vsize":            "The virtual size of a transaction",
 	"getrawmempoolverboseresult-weight":           "The transaction's weight (between vsize*4-3 and vsize*4)",
+	"getrawmempoolverboseresult-ancestorcount":    "Number of in-mempool ancestor transactions, including this one",
+	"getrawmempoolverboseresult-ancestorsize":     "Virtual size of in-mempool ancestors, including this one",
+	"getrawmempoolverboseresult-ancestorfees":     "Fees of in-mempool ancestors, including this one, in satoshis",
+	"getrawmempoolverboseresult-descendantcount":  "Number of in-mempool descendant transactions, including this one",
+	"getrawmempoolverboseresult-descendantsize":   "Virtual size of in-mempool descendants, including this one",
+	"getrawmempoolverboseresult-descendantfees":   "Fees of in-mempool descendants, including this one, in satoshis",
 
 	// GetRawMempoolCmd help.
 	"getrawmempool--synopsis":   "Returns information about all of the transactions currently in the memory pool.",
@@ -519,6 +818,18 @@ var helpDescsEnUS = map[string]string{
 	"getrawtransaction--condition1": "verbose=true",
 	"getrawtransaction--result0":    "Hex-encoded bytes of the serialized transaction",
 
+	// GetRebroadcastInfoResult help.
+	"getrebroadcastinforesult-txid":          "The hash of the transaction",
+	"getrebroadcastinforesult-time":          "Local time the transaction was submitted in seconds since 1 Jan 1970 GMT",
+	"getrebroadcastinforesult-lastannounced": "Local time the transaction was last announced to a peer in seconds since 1 Jan 1970 GMT, or 0 if it was never announced",
+	"getrebroadcastinforesult-expires":       "Local time the transaction is no longer rebroadcast in seconds since 1 Jan 1970 GMT, or 0 if it never expires",
+	"getrebroadcastinforesult-intemplate":    "Whether or not the transaction was in the top of the block template as of the last round of rebroadcasts",
+	"getrebroadcastinforesult-peers":         "The ids of the peers the transaction was announced to",
+
+	// GetRebroadcastInfoCmd help.
+	"getrebroadcastinfo--synopsis": "Returns the rebroadcast state of the transactions submitted through the RPC server which haven't been mined yet.\n" +
+		"They are announced again at random intervals to the peers they weren't announced to while they are in the top of the block template, until they expire.",
+
 	// GetTxOutResult help.
 	"gettxoutresult-bestblock":     "The block hash that contains the transaction output",
 	"gettxoutresult-confirmations": "The number of confirmations",
@@ -541,10 +852,74 @@ var helpDescsEnUS = map[string]string{
 	"help--result0":    "List of commands",
 	"help--result1":    "Help for specified command",
 
+	// LoadMempoolCmd help.
+	"loadmempool--synopsis": "Adds the transactions of the memory pool saved to the data directory back to the memory pool, validating them again.",
+
+	// LoadMempoolResult help.
+	"loadmempoolresult-succeeded":    "Number of transactions accepted to the memory pool",
+	"loadmempoolresult-failed":       "Number of transactions which failed to be accepted to the memory pool",
+	"loadmempoolresult-expired":      "Number of transactions dropped because they had been in the memory pool for longer than the expiry time",
+	"loadmempoolresult-alreadythere": "Number of transactions which were already in the memory pool",
+
 	// PingCmd help.
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
 
+	// PrioritiseTransactionCmd help.
+	"prioritisetransaction--synopsis": "Accepts the transaction into mined blocks at a higher (or lower) priority, by adding a fee delta to its fee when it is compared to the fees of other transactions.\n" +
+		"The fee delta isn't paid to the miner, and is kept for a transaction which isn't in the memory pool yet until it is mined.",
+	"prioritisetransaction-txid":          "The hash of the transaction",
+	"prioritisetransaction-prioritydelta": "No longer supported, must be 0",
+	"prioritisetransaction-feedelta":      "The fee value in satoshis to add to (or subtract from, if negative) the fee of the transaction",
+	"prioritisetransaction--result0":      "Always true",
+
+	// SaveMempoolCmd help.
+	"savemempool--synopsis": "Saves the transactions of the memory pool to the data directory, along with the fee deltas set to prioritise transactions.",
+
+	// SaveMempoolResult help.
+	"savemempoolresult-filename": "The path of the file the memory pool was saved to",
+
+	// ScanTxOutSetCmd help.
+	"scantxoutset--synopsis": "Scans the unspent transaction output set for the outputs paying to the passed descriptors or addresses.\n" +
+		"Utreexo compact state nodes don't keep the set, so they scan the blocks from the start height on, fetching them from peers serving utreexo proofs, and return the outputs along with their proofs against the current accumulator.",
+	"scantxoutset-action":      "The action to take: 'start' a scan, 'abort' the scan in progress or query the 'status' of the scan in progress",
+	"scantxoutset-scanobjects": "The output descriptors or addresses to scan for, required for the start action",
+	"scantxoutset-startheight": "The height of the first block whose outputs are scanned by utreexo compact state nodes",
+	"scantxoutset--condition0": "action=start",
+	"scantxoutset--condition1": "action=status and a scan is in progress",
+	"scantxoutset--condition2": "action=abort",
+	"scantxoutset--result2":    "Whether a scan was in progress to abort",
+
+	// ScanObject help.
+	"scanobject-desc":  "An output descriptor or an address",
+	"scanobject-range": "The end or the [begin,end] range to expand a ranged descriptor at, 1000 indexes by default",
+
+	// ScanTxOutSetResult help.
+	"scantxoutsetresult-success":      "Whether the scan was completed",
+	"scantxoutsetresult-txouts":       "The number of unspent transaction outputs scanned",
+	"scantxoutsetresult-height":       "The height of the block the scan reflects the state of",
+	"scantxoutsetresult-bestblock":    "The hash of the block the scan reflects the state of",
+	"scantxoutsetresult-unspents":     "The unspent transaction outputs found",
+	"scantxoutsetresult-total_amount": "The total amount of the outputs found in BTC",
+	"scantxoutsetresult-roots":        "The utreexo accumulator roots the proofs commit to (utreexo compact state nodes only)",
+
+	// ScanTxOutSetUnspent help.
+	"scantxoutsetunspent-txid":         "The hash of the transaction of the output",
+	"scantxoutsetunspent-vout":         "The index of the output",
+	"scantxoutsetunspent-scriptPubKey": "The hex-encoded public key script of the output",
+	"scantxoutsetunspent-desc":         "The descriptor the output matched",
+	"scantxoutsetunspent-amount":       "The amount of the output in BTC",
+	"scantxoutsetunspent-coinbase":     "Whether the output was created by a coinbase transaction",
+	"scantxoutsetunspent-height":       "The height of the block the output was created in",
+	"scantxoutsetunspent-proof":        "The proof of the output against the utreexo accumulator (utreexo compact state nodes only)",
+
+	// UtreexoProofResult help.
+	"utreexoproofresult-targets": "The positions of the proven leaves in the accumulator",
+	"utreexoproofresult-hashes":  "The hex-encoded hashes of the proof, ordered by their positions",
+
+	// ScanTxOutSetStatusResult help.
+	"scantxoutsetstatusresult-progress": "The percentage of the scan that is done",
+
 	// SearchRawTransactionsCmd help.
 	"searchrawtransactions--synopsis": "Returns raw data for transactions involving the passed address.\n" +
 		"Returned transactions are pulled from both the database, and transactions currently in the mempool.\n" +
@@ -595,6 +970,55 @@ var helpDescsEnUS = map[string]string{
 	"submitblock--condition1": "Block rejected",
 	"submitblock--result1":    "The reason the block was rejected",
 
+	// MempoolAcceptFees help.
+	"mempoolacceptfees-base":               "The fee paid by the transaction in BTC",
+	"mempoolacceptfees-effective-feerate":  "The fee rate in BTC/kvB the transaction was accepted at, including the fee deltas, which is the one of the transactions in effective-includes as a whole",
+	"mempoolacceptfees-effective-includes": "The witness hashes of the transactions whose fees and sizes make up the effective fee rate",
+
+	// SubmitPackageTxResult help.
+	"submitpackagetxresult-txid":        "The hash of the transaction",
+	"submitpackagetxresult-other-wtxid": "The witness hash of the transaction with the same hash but a different witness already in the memory pool, if any",
+	"submitpackagetxresult-vsize":       "The virtual size of the transaction",
+	"submitpackagetxresult-fees":        "The fees of the transaction",
+	"submitpackagetxresult-error":       "The reason the transaction was rejected, if any",
+
+	// SubmitPackageResult help.
+	"submitpackageresult-package_msg":           "The string 'success' when all of the transactions are in the memory pool, 'transaction failed' otherwise",
+	"submitpackageresult-tx-results":            "The results of the transactions",
+	"submitpackageresult-tx-results--key":       "wtxid",
+	"submitpackageresult-tx-results--value":     "Object containing the result of the transaction",
+	"submitpackageresult-tx-results--desc":      "The result of each transaction keyed by witness hash",
+	"submitpackageresult-replaced-transactions": "The hashes of the transactions removed from the memory pool because they conflicted with the package",
+
+	// SubmitPackageCmd help.
+	"submitpackage--synopsis": "Submits a package made of a child transaction coming last and of its unconfirmed parents to the memory pool and relays the transactions accepted.\n" +
+		"Each transaction is accepted on its own when it pays enough, while the ones which don't, along with the ones spending their outputs, are accepted when they pay enough as a whole.",
+	"submitpackage-rawtxns":    "The serialized, hex-encoded transactions of the package, sorted so that each one comes after its parents",
+	"submitpackage-maxfeerate": "The maximum fee rate in BTC/kvB the transactions may pay, 0 for no limit",
+
+	// TestMempoolAcceptResult help.
+	"testmempoolacceptresult-txid":          "The hash of the transaction",
+	"testmempoolacceptresult-wtxid":         "The witness hash of the transaction",
+	"testmempoolacceptresult-package-error": "The reason the transactions don't form a valid package, if so",
+	"testmempoolacceptresult-allowed":       "Whether the transaction would be accepted to the memory pool",
+	"testmempoolacceptresult-vsize":         "The virtual size of the transaction",
+	"testmempoolacceptresult-fees":          "The fees of the transaction, if allowed",
+	"testmempoolacceptresult-reject-reason": "The reason the transaction would be rejected, if so",
+
+	// TestMempoolAcceptCmd help.
+	"testmempoolaccept--synopsis": "Returns whether the transactions would be accepted to the memory pool, without adding them.\n" +
+		"Several transactions must form a package as accepted by submitpackage, and are tested the same way.",
+	"testmempoolaccept-rawtxns":    "The serialized, hex-encoded transaction, or transactions of a package",
+	"testmempoolaccept-maxfeerate": "The maximum fee rate in BTC/kvB the transactions may pay, 0 for no limit",
+
+	// UtxoUpdatePsbtCmd help.
+	"utxoupdatepsbt--synopsis": "Adds the spent outputs of segwit inputs to a PSBT.\n" +
+		"Full nodes look the outputs up in the UTXO set and mempool.\n" +
+		"Compact state nodes take them from the provided utreexo data after verifying it against the accumulator.",
+	"utxoupdatepsbt-psbt":     "The base64-encoded PSBT",
+	"utxoupdatepsbt-udata":    "The hex-encoded utreexo data proving the spent outputs (compact state nodes only)",
+	"utxoupdatepsbt--result0": "The base64-encoded updated PSBT",
+
 	// ValidateAddressResult help.
 	"validateaddresschainresult-isvalid":         "Whether or not the address is valid",
 	"validateaddresschainresult-address":         "The bitcoin address (only when isvalid is true)",
@@ -713,11 +1137,18 @@ var helpDescsEnUS = map[string]string{
 // pointer to the type (or nil to indicate no return value).
 var rpcResultTypes = map[string][]interface{}{
 	"addnode":                nil,
+	"combinepsbt":            {(*string)(nil)},
+	"createpsbt":             {(*string)(nil)},
 	"createrawtransaction":   {(*string)(nil)},
 	"debuglevel":             {(*string)(nil), (*string)(nil)},
+	"debugscript":            {(*btcjson.DebugScriptResult)(nil)},
+	"decodepsbt":             {(*btcjson.DecodePsbtResult)(nil)},
 	"decoderawtransaction":   {(*btcjson.TxRawDecodeResult)(nil)},
 	"decodescript":           {(*btcjson.DecodeScriptResult)(nil)},
+	"deriveaddresses":        {(*[]string)(nil)},
 	"estimatefee":            {(*float64)(nil)},
+	"estimatesmartfee":       {(*btcjson.EstimateSmartFeeResult)(nil)},
+	"finalizepsbt":           {(*btcjson.FinalizePsbtResult)(nil)},
 	"generate":               {(*[]string)(nil)},
 	"getaddednodeinfo":       {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
 	"getbestblock":           {(*btcjson.GetBestBlockResult)(nil)},
@@ -732,11 +1163,15 @@ var rpcResultTypes = map[string][]interface{}{
 	"getcfilterheader":       {(*string)(nil)},
 	"getconnectioncount":     {(*int32)(nil)},
 	"getcurrentnet":          {(*uint32)(nil)},
+	"getdescriptorinfo":      {(*btcjson.GetDescriptorInfoResult)(nil)},
 	"getdifficulty":          {(*float64)(nil)},
 	"getgenerate":            {(*bool)(nil)},
 	"gethashespersec":        {(*float64)(nil)},
 	"getheaders":             {(*[]string)(nil)},
 	"getinfo":                {(*btcjson.InfoChainResult)(nil)},
+	"getmempoolancestors":    {(*[]string)(nil), (*map[string]btcjson.GetMempoolEntryResult)(nil)},
+	"getmempooldescendants":  {(*[]string)(nil), (*map[string]btcjson.GetMempoolEntryResult)(nil)},
+	"getmempoolentry":        {(*btcjson.GetMempoolEntryResult)(nil)},
 	"getmempoolinfo":         {(*btcjson.GetMempoolInfoResult)(nil)},
 	"getmininginfo":          {(*btcjson.GetMiningInfoResult)(nil)},
 	"getnettotals":           {(*btcjson.GetNetTotalsResult)(nil)},
@@ -745,17 +1180,25 @@ var rpcResultTypes = map[string][]interface{}{
 	"getpeerinfo":            {(*[]btcjson.GetPeerInfoResult)(nil)},
 	"getrawmempool":          {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
 	"getrawtransaction":      {(*string)(nil), (*btcjson.TxRawResult)(nil)},
+	"getrebroadcastinfo":     {(*[]btcjson.GetRebroadcastInfoResult)(nil)},
 	"gettxout":               {(*btcjson.GetTxOutResult)(nil)},
 	"node":                   nil,
 	"help":                   {(*string)(nil), (*string)(nil)},
+	"loadmempool":            {(*btcjson.LoadMempoolResult)(nil)},
 	"ping":                   nil,
+	"prioritisetransaction":  {(*bool)(nil)},
+	"savemempool":            {(*btcjson.SaveMempoolResult)(nil)},
+	"scantxoutset":           {(*btcjson.ScanTxOutSetResult)(nil), (*btcjson.ScanTxOutSetStatusResult)(nil), (*bool)(nil)},
 	"searchrawtransactions":  {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":     {(*string)(nil)},
 	"setgenerate":            nil,
 	"signmessagewithprivkey": {(*string)(nil)},
 	"stop":                   {(*string)(nil)},
 	"submitblock":            {nil, (*string)(nil)},
+	"submitpackage":          {(*btcjson.SubmitPackageResult)(nil)},
+	"testmempoolaccept":      {(*[]btcjson.TestMempoolAcceptResult)(nil)},
 	"uptime":                 {(*int64)(nil)},
+	"utxoupdatepsbt":         {(*string)(nil)},
 	"validateaddress":        {(*btcjson.ValidateAddressChainResult)(nil)},
 	"verifychain":            {(*bool)(nil)},
 	"verifymessage":          {(*bool)(nil)},
@@ -6,14 +6,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -32,6 +36,7 @@ import (
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/mining"
 	"github.com/btcsuite/btcd/mining/cpuminer"
+	"github.com/btcsuite/btcd/mining/stratum"
 	"github.com/btcsuite/btcd/netsync"
 	"github.com/btcsuite/btcd/peer"
 	"github.com/btcsuite/btcd/txscript"
@@ -58,6 +63,20 @@ const (
 	// retries when connecting to persistent peers.  It is adjusted by the
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
+
+	// ublockFetchTimeout is the time to wait for a peer to respond to a
+	// request for a ublock made with FetchUBlock before asking the next
+	// peer.
+	ublockFetchTimeout = time.Second * 30
+
+	// v1OnlyAddrExpiry is the time an address which failed the v2
+	// transport handshake keeps being connected to using the v1 transport
+	// before the v2 transport is tried again.
+	v1OnlyAddrExpiry = time.Hour * 24
+
+	// maxV1OnlyAddrs is the maximum number of addresses which failed the
+	// v2 transport handshake to remember.
+	maxV1OnlyAddrs = 1000
 )
 
 var (
@@ -124,14 +143,6 @@ type broadcastMsg struct {
 	excludePeers []*serverPeer
 }
 
-// broadcastInventoryAdd is a type used to declare that the InvVect it contains
-// needs to be added to the rebroadcast map
-type broadcastInventoryAdd relayMsg
-
-// broadcastInventoryDel is a type used to declare that the InvVect it contains
-// needs to be removed from the rebroadcast map
-type broadcastInventoryDel *wire.InvVect
-
 // relayMsg packages an inventory vector along with the newly discovered
 // inventory so the relay has access to that information.
 type relayMsg struct {
@@ -159,6 +170,7 @@ type peerState struct {
 	persistentPeers map[int32]*serverPeer
 	banned          map[string]time.Time
 	outboundGroups  map[string]int
+	outboundBridges int
 }
 
 // Count returns the count of all known peers.
@@ -205,34 +217,33 @@ type server struct {
 	shutdown      int32
 	shutdownSched int32
 	startupTime   int64
-
-	msgBlockSizeSent uint64
-	udataSizeSent    uint64
-
-	chainParams          *chaincfg.Params
-	addrManager          *addrmgr.AddrManager
-	connManager          *connmgr.ConnManager
-	sigCache             *txscript.SigCache
-	hashCache            *txscript.HashCache
-	rpcServer            *rpcServer
-	syncManager          *netsync.SyncManager
-	chain                *blockchain.BlockChain
-	txMemPool            *mempool.TxPool
-	cpuMiner             *cpuminer.CPUMiner
-	modifyRebroadcastInv chan interface{}
-	newPeers             chan *serverPeer
-	donePeers            chan *serverPeer
-	banPeers             chan *serverPeer
-	query                chan interface{}
-	relayInv             chan relayMsg
-	broadcast            chan broadcastMsg
-	peerHeightsUpdate    chan updatePeerHeightsMsg
-	wg                   sync.WaitGroup
-	quit                 chan struct{}
-	nat                  NAT
-	db                   database.DB
-	timeSource           blockchain.MedianTimeSource
-	services             wire.ServiceFlag
+	mempoolLoaded int32
+
+	chainParams       *chaincfg.Params
+	addrManager       *addrmgr.AddrManager
+	connManager       *connmgr.ConnManager
+	sigCache          *txscript.SigCache
+	hashCache         *txscript.HashCache
+	scriptCache       *txscript.ScriptExecCache
+	rpcServer         *rpcServer
+	syncManager       *netsync.SyncManager
+	chain             *blockchain.BlockChain
+	txMemPool         *mempool.TxPool
+	cpuMiner          *cpuminer.CPUMiner
+	stratumServer     *stratum.Server
+	newPeers          chan *serverPeer
+	donePeers         chan *serverPeer
+	banPeers          chan *serverPeer
+	query             chan interface{}
+	relayInv          chan relayMsg
+	broadcast         chan broadcastMsg
+	peerHeightsUpdate chan updatePeerHeightsMsg
+	wg                sync.WaitGroup
+	quit              chan struct{}
+	nat               NAT
+	db                database.DB
+	timeSource        blockchain.MedianTimeSource
+	services          wire.ServiceFlag
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -258,19 +269,58 @@ type server struct {
 	// agentWhitelist is a list of whitelisted user agent substrings, no
 	// whitelisting will be applied if the list is empty or nil.
 	agentWhitelist []string
+
+	// v1OnlyAddrs houses the addresses of outbound peers which failed the
+	// v2 transport handshake along with when they failed it.  Further
+	// connections to them use the v1 transport until the entries expire.
+	v1OnlyAddrs    map[string]time.Time
+	v1OnlyAddrsMtx sync.Mutex
+
+	// netGroupKey is a secret key used to choose the network groups of the
+	// inbound peers which are protected from eviction.
+	netGroupKey [32]byte
+
+	// sentCounters and recvCounters keep track of the bytes sent to and
+	// received from all peers per message type.
+	sentCounters byteCounters
+	recvCounters byteCounters
+
+	// uploadTarget keeps the bytes sent per day under the configured
+	// maximum upload target.
+	uploadTarget *uploadTarget
+
+	// rebroadcaster keeps track of the transactions submitted through the
+	// RPC server until they are mined.
+	rebroadcaster *txRebroadcaster
+
+	// ublockRequests houses the outstanding requests made with FetchUBlock
+	// keyed by the hash of the requested ublock.  The ublocks they are
+	// answered with are handed to the requester instead of the sync
+	// manager.
+	ublockRequests    map[chainhash.Hash]*ublockRequest
+	ublockRequestsMtx sync.Mutex
+}
+
+// ublockRequest is an outstanding request for a ublock made with FetchUBlock.
+type ublockRequest struct {
+	peer  *serverPeer
+	reply chan *btcutil.UBlock
 }
 
 // serverPeer extends the peer to maintain state shared by the server and
 // the blockmanager.
 type serverPeer struct {
 	// The following variables must only be used atomically
-	feeFilter int64
+	feeFilter     int64
+	lastBlockTime int64 // Unix time the peer last relayed a new block.
+	lastTxTime    int64 // Unix time the peer last relayed a new tx.
 
 	*peer.Peer
 
 	connReq        *connmgr.ConnReq
 	server         *server
 	persistent     bool
+	blockRelayOnly bool
 	continueHash   *chainhash.Hash
 	relayMtx       sync.Mutex
 	onlyUBlockMtx  sync.Mutex
@@ -282,6 +332,8 @@ type serverPeer struct {
 	addressesMtx   sync.RWMutex
 	knownAddresses map[string]struct{}
 	banScore       connmgr.DynamicBanScore
+	sentCounters   byteCounters
+	recvCounters   byteCounters
 	quit           chan struct{}
 	// The following chans are used to sync blockmanager and server.
 	txProcessed    chan struct{}
@@ -462,8 +514,12 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 	// Reject outbound peers that are not full nodes.
 	wantServices := wire.SFNodeNetwork
 
-	// Add utreexo bridgenode if we're a utreexoCSN
-	if sp.server.services&wire.SFNodeUtreexoCSN == wire.SFNodeUtreexoCSN {
+	// Add utreexo bridgenode if we're a utreexoCSN.  Other full nodes are
+	// accepted as well once the minimum number of outbound bridges is
+	// connected so the CSN is never left without a source of proofs.
+	if sp.server.services&wire.SFNodeUtreexoCSN == wire.SFNodeUtreexoCSN &&
+		!isInbound && sp.server.OutboundBridgeCount() < cfg.MinUtreexoBridges {
+
 		wantServices |= wire.SFNodeUtreexo
 	}
 	if !isInbound && !hasServices(msg.Services, wantServices) {
@@ -501,8 +557,9 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 	sp.server.timeSource.AddTimeSample(sp.Addr(), msg.Timestamp)
 
 	// Choose whether or not to relay transactions before a filter command
-	// is received.
-	sp.setDisableRelayTx(msg.DisableRelayTx)
+	// is received.  Transactions are never relayed to block relay only
+	// peers.
+	sp.setDisableRelayTx(msg.DisableRelayTx || sp.blockRelayOnly)
 
 	// don't serve regular blocks to CSNs
 	if isInbound && hasServices(msg.Services, wire.SFNodeUtreexoCSN) {
@@ -579,6 +636,14 @@ func (sp *serverPeer) OnTx(_ *peer.Peer, msg *wire.MsgTx) {
 		return
 	}
 
+	// Block relay only peers were told not to send transactions.
+	if sp.blockRelayOnly {
+		peerLog.Infof("Peer %v sent tx %v over a block relay only "+
+			"connection -- disconnecting", sp, msg.TxHash())
+		sp.Disconnect()
+		return
+	}
+
 	// Add the transaction to the known inventory for the peer.
 	// Convert the raw MsgTx to a btcutil.Tx which provides some convenience
 	// methods and things such as hash caching.
@@ -591,8 +656,25 @@ func (sp *serverPeer) OnTx(_ *peer.Peer, msg *wire.MsgTx) {
 	// processed and known good or bad.  This helps prevent a malicious peer
 	// from queuing up a bunch of bad transactions before disconnecting (or
 	// being disconnected) and wasting memory.
+	txMemPool := sp.server.txMemPool
+	haveTx := txMemPool.IsTransactionInPool(tx.Hash())
 	sp.server.syncManager.QueueTx(tx, sp.Peer, sp.txProcessed)
 	<-sp.txProcessed
+
+	// Remember when the peer last relayed a new transaction that was
+	// accepted to the mempool in order to protect it from eviction.
+	if !haveTx && txMemPool.IsTransactionInPool(tx.Hash()) {
+		atomic.StoreInt64(&sp.lastTxTime, time.Now().Unix())
+	}
+}
+
+// markNewBlock records the time the peer relayed a block which was not known
+// before and which is now part of the main chain.  Such peers are protected
+// from eviction.
+func (sp *serverPeer) markNewBlock(hash *chainhash.Hash, hadBlock bool) {
+	if !hadBlock && sp.server.chain.MainChainHasBlock(hash) {
+		atomic.StoreInt64(&sp.lastBlockTime, time.Now().Unix())
+	}
 }
 
 // OnBlock is invoked when a peer receives a block bitcoin message.  It
@@ -617,14 +699,21 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	// reference implementation processes blocks in the same
 	// thread and therefore blocks further messages until
 	// the bitcoin block has been fully processed.
+	hadBlock, _ := sp.server.chain.HaveBlock(block.Hash())
 	sp.server.syncManager.QueueBlock(block, sp.Peer, sp.blockProcessed)
 	<-sp.blockProcessed
+	sp.markNewBlock(block.Hash(), hadBlock)
 }
 
 func (sp *serverPeer) OnUBlock(_ *peer.Peer, msg *wire.MsgUBlock, buf []byte) {
 	// convenience methods and things such as hash caching.
 	ublock := btcutil.NewUBlockFromBlockAndBytes(msg, buf)
 
+	// Ublocks requested with FetchUBlock are not processed.
+	if sp.server.deliverUBlock(sp, ublock) {
+		return
+	}
+
 	// Add the block to the known inventory for the peer.
 	iv := wire.NewInvVect(wire.InvTypeBlock, ublock.Hash())
 	sp.AddKnownInventory(iv)
@@ -640,8 +729,10 @@ func (sp *serverPeer) OnUBlock(_ *peer.Peer, msg *wire.MsgUBlock, buf []byte) {
 	// reference implementation processes blocks in the same
 	// thread and therefore blocks further messages until
 	// the bitcoin block has been fully processed.
+	hadBlock, _ := sp.server.chain.HaveBlock(ublock.Hash())
 	sp.server.syncManager.QueueUBlock(ublock, sp.Peer, sp.blockProcessed)
 	<-sp.blockProcessed
+	sp.markNewBlock(ublock.Hash(), hadBlock)
 }
 
 // OnInv is invoked when a peer receives an inv bitcoin message and is
@@ -649,7 +740,7 @@ func (sp *serverPeer) OnUBlock(_ *peer.Peer, msg *wire.MsgUBlock, buf []byte) {
 // accordingly.  We pass the message down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
+	if !cfg.BlocksOnly && !sp.blockRelayOnly {
 		if len(msg.InvList) > 0 {
 			sp.server.syncManager.QueueInv(msg, sp.Peer)
 		}
@@ -660,7 +751,7 @@ func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
 	for _, invVect := range msg.InvList {
 		if invVect.Type == wire.InvTypeTx {
 			peerLog.Tracef("Ignoring tx %v in inv from %v -- "+
-				"blocksonly enabled", invVect.Hash, sp)
+				"transaction relay disabled", invVect.Hash, sp)
 			if sp.ProtocolVersion() >= wire.BIP0037Version {
 				peerLog.Infof("Peer %v is announcing "+
 					"transactions -- disconnecting", sp)
@@ -1361,6 +1452,14 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 		return
 	}
 
+	// Ignore addresses from block relay only peers since addresses are
+	// not relayed over those connections.
+	if sp.blockRelayOnly {
+		peerLog.Debugf("Ignoring addr message from block relay only "+
+			"peer %v", sp)
+		return
+	}
+
 	// Ignore old style addresses which don't include a timestamp.
 	if sp.ProtocolVersion() < wire.NetAddressTimeVersion {
 		return
@@ -1401,15 +1500,26 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 }
 
 // OnRead is invoked when a peer receives a message and it is used to update
-// the bytes received by the server.
+// the bytes received by the server and the peer.
 func (sp *serverPeer) OnRead(_ *peer.Peer, bytesRead int, msg wire.Message, err error) {
 	sp.server.AddBytesReceived(uint64(bytesRead))
+	if err != nil {
+		msg = nil
+	}
+	sp.recvCounters.add(msg, bytesRead)
+	sp.server.recvCounters.add(msg, bytesRead)
 }
 
 // OnWrite is invoked when a peer sends a message and it is used to update
-// the bytes sent by the server.
+// the bytes sent by the server and the peer.
 func (sp *serverPeer) OnWrite(_ *peer.Peer, bytesWritten int, msg wire.Message, err error) {
 	sp.server.AddBytesSent(uint64(bytesWritten))
+	if err != nil {
+		msg = nil
+	}
+	sp.sentCounters.add(msg, bytesWritten)
+	sp.server.sentCounters.add(msg, bytesWritten)
+	sp.server.uploadTarget.addBytes(uint64(bytesWritten), time.Now())
 }
 
 // OnNotFound is invoked when a peer sends a notfound message.
@@ -1475,14 +1585,21 @@ func randomUint16Number(max uint16) uint16 {
 }
 
 // AddRebroadcastInventory adds 'iv' to the list of inventories to be
-// rebroadcasted at random intervals until they show up in a block.
+// rebroadcasted at random intervals until they show up in a block.  Only
+// transactions are rebroadcast.
 func (s *server) AddRebroadcastInventory(iv *wire.InvVect, data interface{}) {
 	// Ignore if shutting down.
 	if atomic.LoadInt32(&s.shutdown) != 0 {
 		return
 	}
 
-	s.modifyRebroadcastInv <- broadcastInventoryAdd{invVect: iv, data: data}
+	txD, ok := data.(*mempool.TxDesc)
+	if iv.Type != wire.InvTypeTx || !ok {
+		srvrLog.Warnf("Unable to rebroadcast %v: underlying data is "+
+			"not a *mempool.TxDesc: %T", iv, data)
+		return
+	}
+	s.rebroadcaster.add(txD, time.Now())
 }
 
 // RemoveRebroadcastInventory removes 'iv' from the list of items to be
@@ -1493,7 +1610,7 @@ func (s *server) RemoveRebroadcastInventory(iv *wire.InvVect) {
 		return
 	}
 
-	s.modifyRebroadcastInv <- broadcastInventoryDel(iv)
+	s.rebroadcaster.remove(&iv.Hash)
 }
 
 // relayTransactions generates and relays inventory vectors for all of the
@@ -1533,6 +1650,23 @@ func (s *server) TransactionConfirmed(tx *btcutil.Tx) {
 	s.RemoveRebroadcastInventory(iv)
 }
 
+// Misbehaving increases the ban score of the passed peer by the passed
+// persistent and transient values, banning and disconnecting it once the score
+// exceeds the ban threshold.  It is safe for concurrent access.
+func (s *server) Misbehaving(p *peer.Peer, persistent, transient uint32, reason string) {
+	replyChan := make(chan *serverPeer)
+	select {
+	case s.query <- getServerPeerMsg{peer: p, reply: replyChan}:
+	case <-s.quit:
+		return
+	}
+
+	// The peer might have disconnected already.
+	if sp := <-replyChan; sp != nil {
+		sp.addBanScore(persistent, transient, reason)
+	}
+}
+
 // pushTxMsg sends a tx message for the provided transaction hash to the
 // connected peer.  An error is returned if the transaction hash is not known.
 func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
@@ -1562,10 +1696,44 @@ func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<-
 	return nil
 }
 
+// checkHistoricalBlock returns an error and disconnects the peer when it
+// requests a historical block after the upload target was reached.
+// Whitelisted peers are always served.
+func (s *server) checkHistoricalBlock(sp *serverPeer, hash *chainhash.Hash) error {
+	if sp.isWhitelisted {
+		return nil
+	}
+	now := time.Now()
+	if s.uploadTarget.servesHistoricalBlocks(now) {
+		return nil
+	}
+	header, err := s.chain.HeaderByHash(hash)
+	if err != nil {
+		return nil
+	}
+	if now.Sub(header.Timestamp) < historicalBlockAge {
+		return nil
+	}
+
+	peerLog.Infof("Upload target reached -- disconnecting peer %v "+
+		"requesting historical block %v", sp, hash)
+	sp.Disconnect()
+	return fmt.Errorf("upload target reached for historical block %v",
+		hash)
+}
+
 // pushBlockMsg sends a block message for the provided block hash to the
 // connected peer.  An error is returned if the block hash is not known.
 func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
 	waitChan <-chan struct{}, encoding wire.MessageEncoding) error {
+	// Don't serve historical blocks once the upload target is reached.
+	if err := s.checkHistoricalBlock(sp, hash); err != nil {
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
 	// Fetch the raw block bytes from the database.
 	var blockBytes []byte
 	err := sp.server.db.View(func(dbTx database.Tx) error {
@@ -1693,6 +1861,14 @@ func (s *server) pushMerkleBlockMsg(sp *serverPeer, hash *chainhash.Hash,
 // component fails.
 func (s *server) pushUBlockMsg(sp *serverPeer, hash *chainhash.Hash,
 	doneChan chan<- struct{}, waitChan <-chan struct{}, encoding wire.MessageEncoding) error {
+	// Don't serve historical ublocks once the upload target is reached.
+	if err := s.checkHistoricalBlock(sp, hash); err != nil {
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
 	// Fetch the raw block bytes from the database.
 	var blockBytes []byte
 	err := sp.server.db.View(func(dbTx database.Tx) error {
@@ -1748,12 +1924,6 @@ func (s *server) pushUBlockMsg(sp *serverPeer, hash *chainhash.Hash,
 		return err
 	}
 
-	//udSize := uint64(ud.SerializeSizeVarInt())
-	//msgBlockSize := uint64(msgBlock.SerializeSize())
-
-	//atomic.AddUint64(&s.udataSizeSent, udSize)
-	//atomic.AddUint64(&s.msgBlockSizeSent, msgBlockSize)
-
 	// Create ublock
 	ublock := wire.MsgUBlock{
 		MsgBlock:    msgBlock,
@@ -1862,19 +2032,11 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 
 	// TODO: Check for max peers from a single IP.
 
-	// Limit max number of total peers.
+	// Limit max number of total peers.  Room is made for the new peer by
+	// evicting an inbound peer when one isn't protected from eviction.
 	if state.Count() >= cfg.MaxPeers {
-		if sp.onlyUBlock {
-			for _, peer := range state.inboundPeers {
-				if !peer.onlyUBlock {
-					srvrLog.Infof("Max peers reached [%d] but peer is UtreexoCSN"+
-						"- disconnecting a non UtreexoCSN peer %s",
-						cfg.MaxPeers, peer)
-					peer.Disconnect()
-					break
-				}
-			}
-		} else {
+		evicted := s.evictInboundPeer(state, sp)
+		if evicted == nil {
 			srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
 				cfg.MaxPeers, sp)
 			sp.Disconnect()
@@ -1882,6 +2044,8 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 			// they should be rescheduled.
 			return false
 		}
+		srvrLog.Infof("Max peers reached [%d] - evicted inbound peer %s "+
+			"in favor of peer %s", cfg.MaxPeers, evicted, sp)
 	}
 
 	// Add the new peer and start it.
@@ -1890,6 +2054,9 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		state.inboundPeers[sp.ID()] = sp
 	} else {
 		state.outboundGroups[addrmgr.GroupKey(sp.NA())]++
+		if hasServices(sp.Services(), wire.SFNodeUtreexo) {
+			state.outboundBridges++
+		}
 		if sp.persistent {
 			state.persistentPeers[sp.ID()] = sp
 		} else {
@@ -1910,12 +2077,15 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	// remote peer for outbound connections. This is skipped when running on
 	// the simulation test network since it is only intended to connect to
 	// specified peers and actively avoids advertising and connecting to
-	// discovered peers.
+	// discovered peers.  Addresses are not exchanged with block relay only
+	// peers.
 	if !cfg.SimNet && !sp.Inbound() {
 		// Advertise the local address when the server accepts incoming
 		// connections and it believes itself to be close to the best
 		// known tip.
-		if !cfg.DisableListen && s.syncManager.IsCurrent() {
+		if !cfg.DisableListen && !sp.blockRelayOnly &&
+			s.syncManager.IsCurrent() {
+
 			// Get address that best matches.
 			lna := s.addrManager.GetBestLocalAddress(sp.NA())
 			if addrmgr.IsRoutable(lna) {
@@ -1929,7 +2099,9 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		// more and the peer has a protocol version new enough to
 		// include a timestamp with addresses.
 		hasTimestamp := sp.ProtocolVersion() >= wire.NetAddressTimeVersion
-		if s.addrManager.NeedMoreAddresses() && hasTimestamp {
+		if s.addrManager.NeedMoreAddresses() && hasTimestamp &&
+			!sp.blockRelayOnly {
+
 			sp.QueueMessage(wire.NewMsgGetAddr(), nil)
 		}
 
@@ -1940,6 +2112,37 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	return true
 }
 
+// evictInboundPeer disconnects an inbound peer to make room for the passed new
+// peer and returns it, or returns nil when every inbound peer is protected from
+// eviction.  Whitelisted peers are never evicted, and a new utreexo CSN evicts
+// peers which are not CSNs first.  It is invoked from the peerHandler
+// goroutine.
+func (s *server) evictInboundPeer(state *peerState, sp *serverPeer) *serverPeer {
+	var candidates, nonCSNs []*evictionCandidate
+	for _, peer := range state.inboundPeers {
+		if peer.isWhitelisted {
+			continue
+		}
+		c := newEvictionCandidate(peer, s.netGroupKey[:])
+		candidates = append(candidates, c)
+		if !peer.wantsOnlyUBlocks() {
+			nonCSNs = append(nonCSNs, c)
+		}
+	}
+	if sp.wantsOnlyUBlocks() && len(nonCSNs) > 0 {
+		candidates = nonCSNs
+	}
+
+	c := selectPeerToEvict(candidates)
+	if c == nil {
+		return nil
+	}
+	evicted := state.inboundPeers[c.id]
+	delete(state.inboundPeers, c.id)
+	evicted.Disconnect()
+	return evicted
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It is
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
@@ -1955,18 +2158,41 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	// Regardless of whether the peer was found in our list, we'll inform
 	// our connection manager about the disconnection. This can happen if we
 	// process a peer's `done` message before its `add`.
+	//
+	// Outbound peers which failed the v2 transport handshake without
+	// sending anything most likely only support the v1 transport, so they
+	// are retried right away using it.  Persistent peers are retried by
+	// the connection manager.
 	if !sp.Inbound() {
+		v2Failed := sp.V2HandshakeFailed()
+		if v2Failed {
+			srvrLog.Debugf("Retrying peer %s using the v1 transport", sp)
+			s.markV1OnlyAddr(sp.connReq.Addr.String())
+		}
 		if sp.persistent {
 			s.connManager.Disconnect(sp.connReq.ID())
 		} else {
 			s.connManager.Remove(sp.connReq.ID())
-			go s.connManager.NewConnReq()
+			if v2Failed {
+				go s.connManager.Connect(&connmgr.ConnReq{
+					Addr:           sp.connReq.Addr,
+					Permanent:      false,
+					BlockRelayOnly: sp.connReq.BlockRelayOnly,
+				})
+			} else {
+				go s.replaceConnReq(sp.connReq)
+			}
 		}
 	}
 
+	s.rebroadcaster.removePeer(sp.ID())
+
 	if _, ok := list[sp.ID()]; ok {
 		if !sp.Inbound() && sp.VersionKnown() {
 			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			if hasServices(sp.Services(), wire.SFNodeUtreexo) {
+				state.outboundBridges--
+			}
 		}
 		delete(list, sp.ID())
 		srvrLog.Debugf("Removed peer %s", sp)
@@ -1996,9 +2222,17 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 			return
 		}
 
-		// don't relay regular blocks to utreexoCSNs
+		// don't relay regular blocks to utreexoCSNs.  Bridgenodes
+		// announce them as ublocks instead since their proofs are
+		// ready to be served as soon as they are connected.
 		if msg.invVect.Type == wire.InvTypeBlock &&
 			sp.wantsOnlyUBlocks() {
+
+			if cfg.Utreexo {
+				iv := wire.NewInvVect(wire.InvTypeUBlock,
+					&msg.invVect.Hash)
+				sp.QueueInventory(iv)
+			}
 			return
 		}
 
@@ -2053,6 +2287,20 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 					return
 				}
 			}
+
+			// Don't announce a transaction submitted through the
+			// RPC server to the same peer more than once per
+			// round of rebroadcasts.  Peers already known to have
+			// it are skipped before recording the announcement
+			// since the inventory would not be queued for them.
+			if sp.HasKnownInventory(msg.invVect) {
+				return
+			}
+			if !s.rebroadcaster.markAnnounced(&msg.invVect.Hash,
+				sp.ID(), time.Now()) {
+
+				return
+			}
 		}
 
 		// Queue the inventory to be relayed with the next batch.
@@ -2093,10 +2341,19 @@ type getOutboundGroup struct {
 	reply chan int
 }
 
+type getOutboundBridgeCountMsg struct {
+	reply chan int
+}
+
 type getAddedNodesMsg struct {
 	reply chan []*serverPeer
 }
 
+type getServerPeerMsg struct {
+	peer  *peer.Peer
+	reply chan *serverPeer
+}
+
 type disconnectNodeMsg struct {
 	cmp   func(*serverPeer) bool
 	reply chan error
@@ -2185,6 +2442,19 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		} else {
 			msg.reply <- 0
 		}
+	case getOutboundBridgeCountMsg:
+		msg.reply <- state.outboundBridges
+
+	// Request the server peer of a peer.
+	case getServerPeerMsg:
+		var found *serverPeer
+		state.forAllPeers(func(sp *serverPeer) {
+			if sp.Peer == msg.peer {
+				found = sp
+			}
+		})
+		msg.reply <- found
+
 	// Request a list of the persistent (added) peers.
 	case getAddedNodesMsg:
 		// Respond with a slice of the relevant peers.
@@ -2292,12 +2562,57 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentComments: cfg.UserAgentComments,
 		ChainParams:       sp.server.chainParams,
 		Services:          sp.server.services,
-		DisableRelayTx:    cfg.BlocksOnly,
+		DisableRelayTx:    cfg.BlocksOnly || sp.blockRelayOnly,
 		ProtocolVersion:   peer.MaxProtocolVersion,
 		TrickleInterval:   cfg.TrickleInterval,
+		UseV2Transport:    cfg.V2Transport,
 	}
 }
 
+// isV1OnlyAddr returns whether the passed address previously failed the v2
+// transport handshake and should be connected to using the v1 transport.
+func (s *server) isV1OnlyAddr(addr string) bool {
+	s.v1OnlyAddrsMtx.Lock()
+	defer s.v1OnlyAddrsMtx.Unlock()
+
+	failed, ok := s.v1OnlyAddrs[addr]
+	if ok && time.Since(failed) >= v1OnlyAddrExpiry {
+		delete(s.v1OnlyAddrs, addr)
+		return false
+	}
+	return ok
+}
+
+// markV1OnlyAddr records that the passed address failed the v2 transport
+// handshake so future connections to it use the v1 transport.  Once the
+// maximum number of addresses is reached, the expired ones are forgotten,
+// followed by the oldest one if none expired.
+func (s *server) markV1OnlyAddr(addr string) {
+	s.v1OnlyAddrsMtx.Lock()
+	defer s.v1OnlyAddrsMtx.Unlock()
+
+	now := time.Now()
+	if _, ok := s.v1OnlyAddrs[addr]; !ok &&
+		len(s.v1OnlyAddrs) >= maxV1OnlyAddrs {
+
+		var oldestAddr string
+		var oldest time.Time
+		for a, failed := range s.v1OnlyAddrs {
+			if now.Sub(failed) >= v1OnlyAddrExpiry {
+				delete(s.v1OnlyAddrs, a)
+				continue
+			}
+			if oldestAddr == "" || failed.Before(oldest) {
+				oldestAddr, oldest = a, failed
+			}
+		}
+		if len(s.v1OnlyAddrs) >= maxV1OnlyAddrs {
+			delete(s.v1OnlyAddrs, oldestAddr)
+		}
+	}
+	s.v1OnlyAddrs[addr] = now
+}
+
 // inboundPeerConnected is invoked by the connection manager when a new inbound
 // connection is established.  It initializes a new inbound server peer
 // instance, associates it with the connection, and starts a goroutine to wait
@@ -2317,14 +2632,19 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	sp.blockRelayOnly = c.BlockRelayOnly
+	peerCfg := newPeerConfig(sp)
+	if peerCfg.UseV2Transport && s.isV1OnlyAddr(c.Addr.String()) {
+		peerCfg.UseV2Transport = false
+	}
+	p, err := peer.NewOutboundPeer(peerCfg, c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
 		if c.Permanent {
 			s.connManager.Disconnect(c.ID())
 		} else {
 			s.connManager.Remove(c.ID())
-			go s.connManager.NewConnReq()
+			go s.replaceConnReq(c)
 		}
 		return
 	}
@@ -2335,6 +2655,17 @@ func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	go s.peerDoneHandler(sp)
 }
 
+// replaceConnReq makes a new connection request of the same kind as the passed
+// non-permanent connection request which was removed, so the block relay only
+// connections keep their own slots.
+func (s *server) replaceConnReq(c *connmgr.ConnReq) {
+	if c.BlockRelayOnly {
+		s.connManager.NewBlockRelayOnlyConnReq()
+		return
+	}
+	s.connManager.NewConnReq()
+}
+
 // peerDoneHandler handles peer disconnects by notifiying the server that it's
 // done along with other performing other desirable cleanup.
 func (s *server) peerDoneHandler(sp *serverPeer) {
@@ -2692,6 +3023,95 @@ func (s *server) OutboundGroupCount(key string) int {
 	return <-replyChan
 }
 
+// OutboundBridgeCount returns the number of connected outbound peers which
+// serve utreexo proofs.
+func (s *server) OutboundBridgeCount() int {
+	replyChan := make(chan int)
+	select {
+	case s.query <- getOutboundBridgeCountMsg{reply: replyChan}:
+		return <-replyChan
+	case <-s.quit:
+		return 0
+	}
+}
+
+// FetchUBlock requests the ublock with the passed hash from the connected peers
+// which serve utreexo proofs, one at a time until one of them answers, and
+// returns it without processing it.
+//
+// This function is safe for concurrent access.
+func (s *server) FetchUBlock(hash *chainhash.Hash) (*btcutil.UBlock, error) {
+	replyChan := make(chan []*serverPeer)
+	select {
+	case s.query <- getPeersMsg{reply: replyChan}:
+	case <-s.quit:
+		return nil, errors.New("server is shutting down")
+	}
+	peers := <-replyChan
+
+	for _, sp := range peers {
+		if !hasServices(sp.Services(), wire.SFNodeUtreexo) {
+			continue
+		}
+
+		req := &ublockRequest{
+			peer:  sp,
+			reply: make(chan *btcutil.UBlock, 1),
+		}
+		s.ublockRequestsMtx.Lock()
+		if _, ok := s.ublockRequests[*hash]; ok {
+			s.ublockRequestsMtx.Unlock()
+			return nil, fmt.Errorf("ublock %v is already being "+
+				"fetched", hash)
+		}
+		s.ublockRequests[*hash] = req
+		s.ublockRequestsMtx.Unlock()
+
+		gdmsg := wire.NewMsgGetData()
+		gdmsg.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessUBlock, hash))
+		sp.QueueMessage(gdmsg, nil)
+
+		select {
+		case ublock := <-req.reply:
+			return ublock, nil
+
+		case <-time.After(ublockFetchTimeout):
+			srvrLog.Debugf("Peer %s did not serve ublock %v in "+
+				"time", sp, hash)
+
+		case <-sp.quit:
+
+		case <-s.quit:
+			s.ublockRequestsMtx.Lock()
+			delete(s.ublockRequests, *hash)
+			s.ublockRequestsMtx.Unlock()
+			return nil, errors.New("server is shutting down")
+		}
+
+		s.ublockRequestsMtx.Lock()
+		delete(s.ublockRequests, *hash)
+		s.ublockRequestsMtx.Unlock()
+	}
+
+	return nil, fmt.Errorf("no peer served ublock %v", hash)
+}
+
+// deliverUBlock hands the passed ublock received from the passed peer to the
+// FetchUBlock request it answers.  It returns whether there was such a
+// request.
+func (s *server) deliverUBlock(sp *serverPeer, ublock *btcutil.UBlock) bool {
+	s.ublockRequestsMtx.Lock()
+	defer s.ublockRequestsMtx.Unlock()
+
+	req, ok := s.ublockRequests[*ublock.Hash()]
+	if !ok || req.peer != sp {
+		return false
+	}
+	delete(s.ublockRequests, *ublock.Hash())
+	req.reply <- ublock
+	return true
+}
+
 // AddBytesSent adds the passed number of bytes to the total bytes sent counter
 // for the server.  It is safe for concurrent access.
 func (s *server) AddBytesSent(bytesSent uint64) {
@@ -2723,41 +3143,24 @@ func (s *server) UpdatePeerHeights(latestBlkHash *chainhash.Hash, latestHeight i
 	}
 }
 
-// rebroadcastHandler keeps track of user submitted inventories that we have
-// sent out but have not yet made it into a block. We periodically rebroadcast
-// them in case our peers restarted or otherwise lost track of them.
+// rebroadcastHandler periodically announces the transactions submitted through
+// the RPC server which have not made it into a block yet to the peers again, in
+// case they restarted, connected after the transactions were first announced,
+// or dropped them.  Only the transactions which are still in the top of the
+// block template are rebroadcast.
 func (s *server) rebroadcastHandler() {
-	// Wait 5 min before first tx rebroadcast.
-	timer := time.NewTimer(5 * time.Minute)
-	pendingInvs := make(map[wire.InvVect]interface{})
+	timer := time.NewTimer(rebroadcastInitialDelay)
 
 out:
 	for {
 		select {
-		case riv := <-s.modifyRebroadcastInv:
-			switch msg := riv.(type) {
-			// Incoming InvVects are added to our map of RPC txs.
-			case broadcastInventoryAdd:
-				pendingInvs[*msg.invVect] = msg.data
-
-			// When an InvVect has been added to a block, we can
-			// now remove it, if it was present.
-			case broadcastInventoryDel:
-				delete(pendingInvs, *msg)
-			}
-
 		case <-timer.C:
-			// Any inventory we have has not made it into a block
-			// yet. We periodically resubmit them until they have.
-			for iv, data := range pendingInvs {
-				ivCopy := iv
-				s.RelayInventory(&ivCopy, data)
-			}
+			s.rebroadcastTransactions()
 
 			// Process at a random time up to 30mins (in seconds)
 			// in the future.
-			timer.Reset(time.Second *
-				time.Duration(randomUint16Number(1800)))
+			timer.Reset(time.Second * time.Duration(randomUint16Number(
+				uint16(rebroadcastMaxInterval/time.Second))))
 
 		case <-s.quit:
 			break out
@@ -2765,18 +3168,30 @@ out:
 	}
 
 	timer.Stop()
+	s.wg.Done()
+}
 
-	// Drain channels before exiting so nothing is left waiting around
-	// to send.
-cleanup:
-	for {
-		select {
-		case <-s.modifyRebroadcastInv:
-		default:
-			break cleanup
-		}
+// rebroadcastTransactions relays the tracked transactions which are in the top
+// of a block template, as ranked by the package selection of the mempool
+// transactions.  Each transaction is announced again to every peer.
+func (s *server) rebroadcastTransactions() {
+	if s.rebroadcaster.count() == 0 {
+		return
 	}
-	s.wg.Done()
+
+	top := mining.TopTxns(s.txMemPool.MiningDescs(), rebroadcastTopWeight)
+	txns, expired := s.rebroadcaster.due(time.Now(), top,
+		s.txMemPool.IsTransactionInPool)
+	for _, hash := range expired {
+		srvrLog.Infof("Stopped rebroadcasting transaction %v which was "+
+			"not mined after %v", hash, s.rebroadcaster.expiry)
+	}
+	if len(txns) == 0 {
+		return
+	}
+
+	srvrLog.Debugf("Rebroadcasting %d transactions", len(txns))
+	s.relayTransactions(txns)
 }
 
 // Start begins accepting connections from peers.
@@ -2815,6 +3230,38 @@ func (s *server) Start(utreexoRootToVerify *chaincfg.UtreexoRootHint) {
 	if cfg.Generate {
 		s.cpuMiner.Start()
 	}
+
+	// Start the stratum server if it's enabled.
+	if s.stratumServer != nil {
+		s.stratumServer.Start()
+	}
+
+	// Load the memory pool saved on the last shutdown in the background
+	// since all of its transactions are validated again.  Compact state
+	// nodes don't keep a memory pool.
+	if !cfg.NoPersistMempool && !cfg.UtreexoCSN {
+		s.wg.Add(1)
+		go s.mempoolLoadHandler()
+	}
+}
+
+// mempoolLoadHandler loads the memory pool saved to the data directory on the
+// last shutdown, if any.  The memory pool is only saved again on shutdown once
+// it has been loaded, so the saved transactions aren't lost when shutting down
+// before then.
+//
+// It must be run as a goroutine.
+func (s *server) mempoolLoadHandler() {
+	defer s.wg.Done()
+
+	_, err := loadMempool(s.txMemPool, s.quit)
+	if err == mempool.ErrLoadInterrupted {
+		return
+	}
+	if err != nil && !os.IsNotExist(err) {
+		srvrLog.Warnf("Unable to load the memory pool: %v", err)
+	}
+	atomic.StoreInt32(&s.mempoolLoaded, 1)
 }
 
 // Start begins accepting connections from peers.
@@ -2866,14 +3313,20 @@ func (s *server) Stop() error {
 		return nil
 	}
 
-	srvrLog.Infof("msgBlockSizeSent is %d. udataSizeSent is %d",
-		s.msgBlockSizeSent, s.udataSizeSent)
+	sent := s.sentCounters.snapshot()
+	srvrLog.Infof("Sent %d bytes of blocks and %d bytes of utreexo proofs",
+		sent.BlockBytes, sent.ProofBytes)
 
 	srvrLog.Warnf("Server shutting down")
 
 	// Stop the CPU miner if needed
 	s.cpuMiner.Stop()
 
+	// Shutdown the stratum server if it's enabled.
+	if s.stratumServer != nil {
+		s.stratumServer.Stop()
+	}
+
 	// Shutdown the RPC server if it's not disabled.
 	if !cfg.DisableRPC {
 		s.rpcServer.Stop()
@@ -2890,11 +3343,125 @@ func (s *server) Stop() error {
 		})
 	}
 
+	if cfg.PersistSigCache {
+		saveSigCache(s.sigCache)
+	}
+
+	if atomic.LoadInt32(&s.mempoolLoaded) != 0 {
+		path, err := saveMempool(s.txMemPool)
+		if err != nil {
+			srvrLog.Warnf("Unable to save the memory pool: %v", err)
+		} else {
+			srvrLog.Infof("Saved the memory pool to %s", path)
+		}
+	}
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return nil
 }
 
+// sigCacheFilename is the name of the file in the data directory the
+// signature cache is saved to when the --persistsigcache option is set.
+const sigCacheFilename = "sigcache.dat"
+
+// loadSigCache restores the entries of the passed signature cache saved to the
+// data directory on the last shutdown, if any.  Failing to do so only means the
+// cache starts out empty, so errors are logged rather than returned.
+func loadSigCache(sigCache *txscript.SigCache) {
+	path := filepath.Join(cfg.DataDir, sigCacheFilename)
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			srvrLog.Warnf("Unable to open signature cache file %s: %v",
+				path, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	if err := sigCache.Load(bufio.NewReader(f)); err != nil {
+		srvrLog.Warnf("Unable to load signature cache from %s: %v", path,
+			err)
+		return
+	}
+	srvrLog.Infof("Loaded signature cache from %s", path)
+}
+
+// saveSigCache saves the entries of the passed signature cache to the data
+// directory.
+func saveSigCache(sigCache *txscript.SigCache) {
+	path, err := saveDataFile(sigCacheFilename, sigCache.Save)
+	if err != nil {
+		srvrLog.Warnf("Unable to save signature cache to %s: %v", path,
+			err)
+		return
+	}
+	srvrLog.Infof("Saved signature cache to %s", path)
+}
+
+// mempoolFilename is the name of the file in the data directory the memory
+// pool is saved to unless the --nopersistmempool option is set.
+const mempoolFilename = "mempool.dat"
+
+// loadMempool adds the transactions of the memory pool saved to the data
+// directory back to the passed memory pool, until the passed interrupt channel
+// is closed.  An error satisfying os.IsNotExist is returned when no memory
+// pool has been saved.
+func loadMempool(txMemPool *mempool.TxPool, interrupt <-chan struct{}) (*mempool.LoadStats, error) {
+	path := filepath.Join(cfg.DataDir, mempoolFilename)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats, err := txMemPool.Load(bufio.NewReader(f), interrupt)
+	if stats != nil {
+		srvrLog.Infof("Loaded the memory pool from %s: %d accepted, %d "+
+			"failed, %d expired, %d already in the pool", path,
+			stats.Accepted, stats.Failed, stats.Expired,
+			stats.AlreadyHave)
+	}
+	return stats, err
+}
+
+// saveMempool saves the transactions of the passed memory pool to the data
+// directory and returns the path of the file.
+func saveMempool(txMemPool *mempool.TxPool) (string, error) {
+	return saveDataFile(mempoolFilename, txMemPool.Save)
+}
+
+// saveDataFile saves a file with the passed name to the data directory with the
+// passed function and returns its path.  The file is written to a temporary
+// file first, which then replaces the previous one, so an interrupted save
+// leaves the last snapshot intact.
+func saveDataFile(name string, save func(w io.Writer) error) (string, error) {
+	path := filepath.Join(cfg.DataDir, name)
+	tmpPath := path + ".new"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return path, err
+	}
+
+	w := bufio.NewWriter(f)
+	err = save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return path, err
+	}
+	return path, nil
+}
+
 // WaitForShutdown blocks until the main listener and peer handlers are stopped.
 func (s *server) WaitForShutdown() {
 	s.wg.Wait()
@@ -3087,6 +3654,28 @@ func setupRPCListeners() ([]net.Listener, error) {
 	return listeners, nil
 }
 
+// setupStratumListeners returns a slice of listeners that are configured for
+// use with the stratum server depending on the configuration settings for
+// listen addresses.
+func setupStratumListeners() ([]net.Listener, error) {
+	netAddrs, err := parseListeners(cfg.StratumListeners)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, len(netAddrs))
+	for _, addr := range netAddrs {
+		listener, err := net.Listen(addr.Network(), addr.String())
+		if err != nil {
+			minrLog.Warnf("Can't listen on %s: %v", addr, err)
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
 // newServer returns a new btcd server configured to listen on addr for the
 // bitcoin network type specified by chainParams.  Use start to begin accepting
 // connections from peers.
@@ -3136,27 +3725,49 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		srvrLog.Infof("User-agent whitelist %s", agentWhitelist)
 	}
 
+	// The signature cache is left disabled unless it is persisted, so the
+	// memory use and the validation of nodes which don't opt into it are
+	// unchanged.
+	var sigCacheMaxSize uint
+	if cfg.PersistSigCache {
+		sigCacheMaxSize = cfg.SigCacheMaxSize
+	}
+
 	s := server{
-		chainParams:          chainParams,
-		addrManager:          amgr,
-		newPeers:             make(chan *serverPeer, cfg.MaxPeers),
-		donePeers:            make(chan *serverPeer, cfg.MaxPeers),
-		banPeers:             make(chan *serverPeer, cfg.MaxPeers),
-		query:                make(chan interface{}),
-		relayInv:             make(chan relayMsg, cfg.MaxPeers),
-		broadcast:            make(chan broadcastMsg, cfg.MaxPeers),
-		quit:                 make(chan struct{}),
-		modifyRebroadcastInv: make(chan interface{}),
-		peerHeightsUpdate:    make(chan updatePeerHeightsMsg),
-		nat:                  nat,
-		db:                   db,
-		timeSource:           blockchain.NewMedianTime(),
-		services:             services,
-		sigCache:             txscript.NewSigCache(0),
-		hashCache:            txscript.NewHashCache(cfg.SigCacheMaxSize),
-		cfCheckptCaches:      make(map[wire.FilterType][]cfHeaderKV),
-		agentBlacklist:       agentBlacklist,
-		agentWhitelist:       agentWhitelist,
+		chainParams:       chainParams,
+		addrManager:       amgr,
+		newPeers:          make(chan *serverPeer, cfg.MaxPeers),
+		donePeers:         make(chan *serverPeer, cfg.MaxPeers),
+		banPeers:          make(chan *serverPeer, cfg.MaxPeers),
+		query:             make(chan interface{}),
+		relayInv:          make(chan relayMsg, cfg.MaxPeers),
+		broadcast:         make(chan broadcastMsg, cfg.MaxPeers),
+		quit:              make(chan struct{}),
+		peerHeightsUpdate: make(chan updatePeerHeightsMsg),
+		nat:               nat,
+		db:                db,
+		timeSource:        blockchain.NewMedianTime(),
+		services:          services,
+		sigCache:          txscript.NewSigCache(sigCacheMaxSize),
+		hashCache:         txscript.NewHashCache(cfg.SigCacheMaxSize),
+		scriptCache:       txscript.NewScriptExecCache(cfg.ScriptCacheMaxSize),
+		cfCheckptCaches:   make(map[wire.FilterType][]cfHeaderKV),
+		agentBlacklist:    agentBlacklist,
+		agentWhitelist:    agentWhitelist,
+		v1OnlyAddrs:       make(map[string]time.Time),
+		uploadTarget:      newUploadTarget(cfg.MaxUploadTarget * 1024 * 1024),
+		rebroadcaster:     newTxRebroadcaster(time.Duration(cfg.RebroadcastExpiry) * time.Hour),
+		ublockRequests:    make(map[chainhash.Hash]*ublockRequest),
+	}
+
+	if cfg.PersistSigCache {
+		loadSigCache(s.sigCache)
+	}
+
+	// Generate the secret key used to choose the network groups of the
+	// inbound peers which are protected from eviction.
+	if _, err := rand.Read(s.netGroupKey[:]); err != nil {
+		return nil, err
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -3232,6 +3843,7 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		SigCache:              s.sigCache,
 		IndexManager:          indexManager,
 		HashCache:             s.hashCache,
+		ScriptExecCache:       s.scriptCache,
 		Utreexo:               cfg.Utreexo,
 		UtreexoInRam:          cfg.UtreexoInRam,
 		DataDir:               cfg.DataDir,
@@ -3273,12 +3885,11 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		})
 	}
 
-	// If no feeEstimator has been found, or if the one that has been found
-	// is behind somehow, create a new one and start over.
-	if s.feeEstimator == nil || s.feeEstimator.LastKnownHeight() != s.chain.BestSnapshot().Height {
-		s.feeEstimator = mempool.NewFeeEstimator(
-			mempool.DefaultEstimateFeeMaxRollback,
-			mempool.DefaultEstimateFeeMinRegisteredBlocks)
+	// If no feeEstimator has been found, create a new one.  One that is
+	// behind keeps its statistics, which it stops relying on once they're
+	// too old.
+	if s.feeEstimator == nil {
+		s.feeEstimator = mempool.NewFeeEstimator()
 	}
 
 	txC := mempool.Config{
@@ -3292,6 +3903,12 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 			MinRelayTxFee:        cfg.minRelayTxFee,
 			MaxTxVersion:         2,
 			RejectReplacement:    cfg.RejectReplacement,
+			MaxPoolSize:          int64(cfg.MaxMempoolMB) * 1000 * 1000,
+			ExpiryTime:           time.Duration(cfg.MempoolExpiryHours) * time.Hour,
+			MaxAncestorCount:     mempool.DefaultMaxAncestorCount,
+			MaxAncestorSize:      mempool.DefaultMaxAncestorSize,
+			MaxDescendantCount:   mempool.DefaultMaxDescendantCount,
+			MaxDescendantSize:    mempool.DefaultMaxDescendantSize,
 		},
 		ChainParams:    chainParams,
 		FetchUtxoView:  s.chain.FetchUtxoView,
@@ -3303,6 +3920,7 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		IsDeploymentActive: s.chain.IsDeploymentActive,
 		SigCache:           s.sigCache,
 		HashCache:          s.hashCache,
+		ScriptExecCache:    s.scriptCache,
 		AddrIndex:          s.addrIndex,
 		FeeEstimator:       s.feeEstimator,
 	}
@@ -3315,6 +3933,7 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		ChainParams:           s.chainParams,
 		DisableCheckpoints:    cfg.DisableCheckpoints,
 		MaxPeers:              cfg.MaxPeers,
+		MinChainWork:          cfg.minChainWork,
 		UtreexoMN:             cfg.UtreexoMainNode,
 		UtreexoWN:             cfg.UtreexoWorker,
 		UtreexoCSN:            cfg.UtreexoCSN,
@@ -3340,16 +3959,40 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	}
 	blockTemplateGenerator := mining.NewBlkTmplGenerator(&policy,
 		s.chainParams, s.txMemPool, s.chain, s.timeSource,
-		s.sigCache, s.hashCache)
+		s.sigCache, s.hashCache, s.scriptCache)
 	s.cpuMiner = cpuminer.New(&cpuminer.Config{
 		ChainParams:            chainParams,
 		BlockTemplateGenerator: blockTemplateGenerator,
 		MiningAddrs:            cfg.miningAddrs,
+		SignetKeys:             cfg.signetKeys,
 		ProcessBlock:           s.syncManager.ProcessBlock,
 		ConnectedCount:         s.ConnectedCount,
 		IsCurrent:              s.syncManager.IsCurrent,
 	})
 
+	// Setup the stratum server if any stratum listeners were specified.
+	if len(cfg.StratumListeners) > 0 {
+		stratumListeners, err := setupStratumListeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(stratumListeners) == 0 {
+			return nil, errors.New("STRATUM: No valid listen address")
+		}
+
+		s.stratumServer = stratum.New(&stratum.Config{
+			ChainParams:            chainParams,
+			BlockTemplateGenerator: blockTemplateGenerator,
+			Chain:                  s.chain,
+			MiningAddrs:            cfg.miningAddrs,
+			ProcessBlock:           s.syncManager.ProcessBlock,
+			IsCurrent:              s.syncManager.IsCurrent,
+			Listeners:              stratumListeners,
+			StartDifficulty:        cfg.StratumDifficulty,
+			MinDifficulty:          cfg.StratumMinDifficulty,
+		})
+	}
+
 	// Only setup a function to return new addresses to connect to when
 	// not running in connect-only mode.  The simulation network is always
 	// in connect-only mode since it is only intended to connect to
@@ -3359,12 +4002,26 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	var newAddressFunc func() (net.Addr, error)
 	if !cfg.SimNet && len(cfg.ConnectPeers) == 0 {
 		newAddressFunc = func() (net.Addr, error) {
+			// A utreexo CSN only connects to bridges until the
+			// minimum number of them is connected.
+			needBridge := s.services&wire.SFNodeUtreexoCSN ==
+				wire.SFNodeUtreexoCSN &&
+				s.OutboundBridgeCount() < cfg.MinUtreexoBridges
+
 			for tries := 0; tries < 100; tries++ {
 				addr := s.addrManager.GetAddress()
 				if addr == nil {
 					break
 				}
 
+				// Skip addresses which are not known to serve
+				// utreexo proofs while bridges are needed.
+				if needBridge && !hasServices(
+					addr.NetAddress().Services, wire.SFNodeUtreexo) {
+
+					continue
+				}
+
 				// Address will not be invalid, local or unroutable
 				// because addrmanager rejects those on addition.
 				// Just check that we don't already have an address
@@ -3399,19 +4056,25 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		}
 	}
 
-	// Create a connection manager.
+	// Create a connection manager.  Block relay only connections are made
+	// in addition to the regular outbound connections.
 	targetOutbound := defaultTargetOutbound
 	if cfg.MaxPeers < targetOutbound {
 		targetOutbound = cfg.MaxPeers
 	}
+	targetBlockRelayOnly := cfg.BlockRelayOnlyPeers
+	if cfg.MaxPeers-targetOutbound < targetBlockRelayOnly {
+		targetBlockRelayOnly = cfg.MaxPeers - targetOutbound
+	}
 	cmgr, err := connmgr.New(&connmgr.Config{
-		Listeners:      listeners,
-		OnAccept:       s.inboundPeerConnected,
-		RetryDuration:  connectionRetryInterval,
-		TargetOutbound: uint32(targetOutbound),
-		Dial:           btcdDial,
-		OnConnection:   s.outboundPeerConnected,
-		GetNewAddress:  newAddressFunc,
+		Listeners:            listeners,
+		OnAccept:             s.inboundPeerConnected,
+		RetryDuration:        connectionRetryInterval,
+		TargetOutbound:       uint32(targetOutbound),
+		TargetBlockRelayOnly: uint32(targetBlockRelayOnly),
+		Dial:                 btcdDial,
+		OnConnection:         s.outboundPeerConnected,
+		GetNewAddress:        newAddressFunc,
 	})
 	if err != nil {
 		return nil, err
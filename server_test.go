@@ -0,0 +1,68 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestV1OnlyAddrs ensures the addresses which failed the v2 transport
+// handshake expire and are bounded in number.
+func TestV1OnlyAddrs(t *testing.T) {
+	t.Parallel()
+
+	s := &server{v1OnlyAddrs: make(map[string]time.Time)}
+	s.markV1OnlyAddr("1.2.3.4:8333")
+	if !s.isV1OnlyAddr("1.2.3.4:8333") {
+		t.Fatal("address not marked as v1 only")
+	}
+	if s.isV1OnlyAddr("5.6.7.8:8333") {
+		t.Fatal("unknown address marked as v1 only")
+	}
+
+	// Expired addresses are tried with the v2 transport again.
+	s.v1OnlyAddrs["1.2.3.4:8333"] = time.Now().Add(-v1OnlyAddrExpiry)
+	if s.isV1OnlyAddr("1.2.3.4:8333") {
+		t.Fatal("expired address still marked as v1 only")
+	}
+	if len(s.v1OnlyAddrs) != 0 {
+		t.Fatalf("got %d addresses, want none", len(s.v1OnlyAddrs))
+	}
+
+	// Once full, the expired addresses are forgotten first and then the
+	// oldest one.
+	now := time.Now()
+	for i := 0; i < maxV1OnlyAddrs; i++ {
+		addr := fmt.Sprintf("10.0.%d.%d:8333", i/256, i%256)
+		s.v1OnlyAddrs[addr] = now.Add(-time.Duration(i) * time.Minute)
+	}
+	s.v1OnlyAddrs["10.0.0.1:8333"] = now.Add(-v1OnlyAddrExpiry)
+	s.v1OnlyAddrs["10.0.0.2:8333"] = now.Add(-v1OnlyAddrExpiry)
+	s.markV1OnlyAddr("1.2.3.4:8333")
+	if len(s.v1OnlyAddrs) != maxV1OnlyAddrs-1 {
+		t.Fatalf("got %d addresses, want %d", len(s.v1OnlyAddrs),
+			maxV1OnlyAddrs-1)
+	}
+	s.markV1OnlyAddr("5.6.7.8:8333")
+	s.markV1OnlyAddr("9.10.11.12:8333")
+	if len(s.v1OnlyAddrs) != maxV1OnlyAddrs {
+		t.Fatalf("got %d addresses, want %d", len(s.v1OnlyAddrs),
+			maxV1OnlyAddrs)
+	}
+	oldest := fmt.Sprintf("10.0.%d.%d:8333", (maxV1OnlyAddrs-1)/256,
+		(maxV1OnlyAddrs-1)%256)
+	if _, ok := s.v1OnlyAddrs[oldest]; ok {
+		t.Fatal("oldest address kept once full")
+	}
+	for _, addr := range []string{"1.2.3.4:8333", "5.6.7.8:8333",
+		"9.10.11.12:8333"} {
+
+		if !s.isV1OnlyAddr(addr) {
+			t.Fatalf("address %s not marked as v1 only", addr)
+		}
+	}
+}
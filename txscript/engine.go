@@ -13,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 )
 
@@ -95,6 +97,25 @@ const (
 	// operation whose public key isn't serialized in a compressed format
 	// non-standard.
 	ScriptVerifyWitnessPubKeyType
+
+	// ScriptVerifyTaproot defines whether or not to verify a transaction
+	// output using the new taproot validation rules.  This is BIP0341 and
+	// BIP0342.
+	ScriptVerifyTaproot
+
+	// ScriptVerifyDiscourageUpgradeableTaprootVersion defines whether or
+	// not to consider any taproot leaf version other than the base
+	// tapscript version as non-standard.
+	ScriptVerifyDiscourageUpgradeableTaprootVersion
+
+	// ScriptVerifyDiscourageOpSuccess defines whether or not to consider
+	// tapscripts which contain an OP_SUCCESS opcode as non-standard.
+	ScriptVerifyDiscourageOpSuccess
+
+	// ScriptVerifyDiscourageUpgradeablePubkeyType defines whether or not to
+	// consider signature checks against public keys of unknown types within
+	// tapscripts as non-standard.
+	ScriptVerifyDiscourageUpgradeablePubkeyType
 )
 
 const (
@@ -112,6 +133,14 @@ const (
 	// payToWitnessScriptHashDataSize is the size of the witness program's
 	// data push for a pay-to-witness-script-hash output.
 	payToWitnessScriptHashDataSize = 32
+
+	// payToTaprootDataSize is the size of the witness program of a
+	// pay-to-taproot output, which is the x-only output key.
+	payToTaprootDataSize = 32
+
+	// TaprootWitnessVersion is the witness version of taproot outputs as
+	// defined by BIP0341.
+	TaprootWitnessVersion = 1
 )
 
 // halforder is used to tame ECDSA malleability (see BIP0062).
@@ -145,13 +174,24 @@ type Engine struct {
 	// since transaction scripts are often executed more than once from various
 	// contexts (e.g. new block templates, when transactions are first seen
 	// prior to being mined, part of full block verification, etc).
-	flags     ScriptFlags
-	tx        wire.MsgTx
-	txIdx     int
-	version   uint16
-	bip16     bool
-	sigCache  *SigCache
-	hashCache *TxSigHashes
+	//
+	// prevOutFetcher supplies the outputs spent by the transaction, which
+	// are committed to by taproot signature hashes.
+	//
+	// schnorrBatch, when set, collects the Schnorr signatures checked by
+	// the engine instead of verifying them one at a time.
+	//
+	// tracer, when set, is reported each opcode executed by the engine.
+	flags          ScriptFlags
+	tx             wire.MsgTx
+	txIdx          int
+	version        uint16
+	bip16          bool
+	sigCache       *SigCache
+	hashCache      *TxSigHashes
+	prevOutFetcher PrevOutputFetcher
+	schnorrBatch   *schnorr.BatchVerifier
+	tracer         Tracer
 
 	// The following fields handle keeping track of the current execution state
 	// of the engine.
@@ -187,6 +227,12 @@ type Engine struct {
 	//
 	// numOps tracks the total number of non-push operations in a script and is
 	// primarily used to enforce maximum limits.
+	//
+	// taprootCtx houses the state of a taproot spend.  It is only set once a
+	// taproot witness program is being verified.
+	//
+	// traceSigChecks collects the signatures checked by the current step
+	// for its trace.
 	scripts         [][]byte
 	scriptIdx       int
 	opcodeIdx       int
@@ -200,6 +246,8 @@ type Engine struct {
 	witnessVersion  int
 	witnessProgram  []byte
 	inputAmount     int64
+	taprootCtx      *taprootExecutionCtx
+	traceSigChecks  []TraceSigCheck
 }
 
 // hasFlag returns whether the script engine instance has the passed flag set.
@@ -355,9 +403,11 @@ func (vm *Engine) executeOpcode(op *opcode, data []byte) error {
 	}
 
 	// Note that this includes OP_RESERVED which counts as a push operation.
+	// Tapscripts aren't subject to the operation limit since their
+	// signature checks are limited by their signature operations budget.
 	if op.value > OP_16 {
 		vm.numOps++
-		if vm.numOps > MaxOpsPerScript {
+		if vm.numOps > MaxOpsPerScript && !vm.isTapscript() {
 			str := fmt.Sprintf("exceeded max operation limit of %d",
 				MaxOpsPerScript)
 			return scriptError(ErrTooManyOperations, str)
@@ -406,9 +456,22 @@ func (vm *Engine) isWitnessVersionActive(version uint) bool {
 	return vm.witnessProgram != nil && uint(vm.witnessVersion) == version
 }
 
+// isTapscript returns true if a tapscript revealed by a taproot script path
+// spend is being executed.
+func (vm *Engine) isTapscript() bool {
+	return vm.taprootCtx != nil && vm.taprootCtx.tapLeafHash != nil
+}
+
 // verifyWitnessProgram validates the stored witness program using the passed
 // witness as input.
 func (vm *Engine) verifyWitnessProgram(witness [][]byte) error {
+	if vm.isWitnessVersionActive(TaprootWitnessVersion) &&
+		len(vm.witnessProgram) == payToTaprootDataSize && !vm.bip16 &&
+		vm.hasFlag(ScriptVerifyTaproot) {
+
+		return vm.verifyTaprootWitnessProgram(witness)
+	}
+
 	if vm.isWitnessVersionActive(0) {
 		switch len(vm.witnessProgram) {
 		case payToWitnessPubKeyHashDataSize: // P2WKH
@@ -517,6 +580,217 @@ func (vm *Engine) verifyWitnessProgram(witness [][]byte) error {
 	return nil
 }
 
+// verifyTaprootWitnessProgram validates the stored taproot witness program
+// using the passed witness as input as defined by BIP0341.  Key path spends
+// are verified outright, while the revealed tapscript of script path spends is
+// set up to be executed next.
+func (vm *Engine) verifyTaprootWitnessProgram(witness [][]byte) error {
+	// The witness stack MUST NOT be empty.
+	if len(witness) == 0 {
+		return scriptError(ErrWitnessProgramEmpty, "witness program "+
+			"empty passed empty witness")
+	}
+
+	// The signature operations budget of a tapscript depends on the size
+	// of the entire witness, including the annex and the control block.
+	vm.taprootCtx = newTaprootExecutionCtx(wire.TxWitness(witness).
+		SerializeSize())
+
+	// Remove the annex, if any, which isn't interpreted but is committed
+	// to by signatures.
+	if isAnnexedWitness(witness) {
+		vm.taprootCtx.annex = witness[len(witness)-1]
+		witness = witness[:len(witness)-1]
+	}
+
+	// A single remaining element is a signature for the output key, which
+	// is the key path spend.
+	if len(witness) == 1 {
+		err := vm.verifyTaprootKeySpend(witness[0])
+		if err != nil {
+			return err
+		}
+
+		vm.taprootCtx.mustSucceed = true
+		return nil
+	}
+
+	// Otherwise, the script path is being spent, so the last two elements
+	// are the revealed script and the control block which must prove the
+	// script is committed to by the output key.
+	ctrlBlockBytes := witness[len(witness)-1]
+	witnessScript := witness[len(witness)-2]
+	ctrlBlock, err := ParseControlBlock(ctrlBlockBytes)
+	if err != nil {
+		return err
+	}
+	err = VerifyTaprootLeafCommitment(ctrlBlock, vm.witnessProgram,
+		witnessScript)
+	if err != nil {
+		return err
+	}
+
+	// Leaf versions other than the base tapscript version are reserved for
+	// future soft forks, so spends of them are valid.
+	if ctrlBlock.LeafVersion != BaseLeafVersion {
+		if vm.hasFlag(ScriptVerifyDiscourageUpgradeableTaprootVersion) {
+			str := fmt.Sprintf("taproot leaf version %#x is reserved "+
+				"for soft-fork upgrades", ctrlBlock.LeafVersion)
+			return scriptError(ErrDiscourageUpgradeableTaprootVersion,
+				str)
+		}
+
+		vm.taprootCtx.mustSucceed = true
+		return nil
+	}
+
+	// Tapscripts containing an OP_SUCCESS opcode are valid irrespective
+	// of their execution, or even whether they parse after it.
+	if scriptHasOpSuccess(witnessScript) {
+		if vm.hasFlag(ScriptVerifyDiscourageOpSuccess) {
+			str := "tapscript contains an OP_SUCCESS opcode reserved " +
+				"for soft-fork upgrades"
+			return scriptError(ErrDiscourageOpSuccess, str)
+		}
+
+		vm.taprootCtx.mustSucceed = true
+		return nil
+	}
+
+	// With all the validity checks passed, assert that the script parses
+	// without failure.
+	if err := checkScriptParses(vm.version, witnessScript); err != nil {
+		return err
+	}
+
+	// The initial stack of the tapscript is subject to the same limits as
+	// the stack during its execution.
+	stack := witness[:len(witness)-2]
+	if len(stack) > MaxStackSize {
+		str := fmt.Sprintf("tapscript initial stack size %d > max "+
+			"allowed %d", len(stack), MaxStackSize)
+		return scriptError(ErrStackOverflow, str)
+	}
+	for _, witElement := range stack {
+		if len(witElement) > MaxScriptElementSize {
+			str := fmt.Sprintf("element size %d exceeds max "+
+				"allowed size %d", len(witElement),
+				MaxScriptElementSize)
+			return scriptError(ErrElementTooBig, str)
+		}
+	}
+
+	// Use the remaining witness as the stack, and set the tapscript to be
+	// the next script executed.
+	tapLeafHash := NewBaseTapLeaf(witnessScript).TapHash()
+	vm.taprootCtx.tapLeafHash = tapLeafHash[:]
+	vm.scripts = append(vm.scripts, witnessScript)
+	vm.SetStack(stack)
+
+	return nil
+}
+
+// verifyTaprootKeySpend verifies the passed signature of a taproot key path
+// spend against the output key of the stored witness program.
+func (vm *Engine) verifyTaprootKeySpend(rawSig []byte) error {
+	hash, sigBytes, err := vm.calcTaprootSigHash(rawSig)
+	if err != nil {
+		return err
+	}
+
+	valid := vm.verifySchnorrSig(hash, sigBytes, vm.witnessProgram)
+	vm.traceSigCheck(vm.witnessProgram, sigBytes, taprootSigHashType(rawSig),
+		hash, valid)
+	if !valid {
+		str := "invalid taproot key path signature"
+		return scriptError(ErrTaprootSigInvalid, str)
+	}
+	return nil
+}
+
+// calcTaprootSigHash returns the signature hash committed to by the passed
+// non-empty taproot signature along with the signature without its hash type.
+// Signatures are 64 bytes when they use the default hash type, and 65 bytes
+// with an explicit hash type otherwise.
+func (vm *Engine) calcTaprootSigHash(rawSig []byte) ([]byte, []byte, error) {
+	hashType := SigHashDefault
+	sigBytes := rawSig
+	switch len(rawSig) {
+	case 64:
+	case 65:
+		// The default hash type MUST NOT be explicitly encoded.
+		hashType = SigHashType(rawSig[64])
+		sigBytes = rawSig[:64]
+		if hashType == SigHashDefault {
+			str := "taproot signature explicitly encodes the " +
+				"default hash type"
+			return nil, nil, scriptError(ErrInvalidSigHashType, str)
+		}
+	default:
+		str := fmt.Sprintf("taproot signature of size %d is not 64 "+
+			"or 65 bytes", len(rawSig))
+		return nil, nil, scriptError(ErrInvalidTaprootSigLen, str)
+	}
+
+	if !isValidTaprootSigHash(hashType) {
+		str := fmt.Sprintf("invalid taproot hash type %#x", hashType)
+		return nil, nil, scriptError(ErrInvalidSigHashType, str)
+	}
+
+	opts := &taprootSigHashOptions{
+		annex:       vm.taprootCtx.annex,
+		tapLeafHash: vm.taprootCtx.tapLeafHash,
+		codeSepPos:  vm.taprootCtx.codeSepPos,
+	}
+	hash, err := calcTaprootSignatureHashRaw(vm.hashCache, hashType,
+		&vm.tx, vm.txIdx, vm.prevOutFetcher, opts)
+	if err != nil {
+		str := fmt.Sprintf("unable to calculate taproot signature "+
+			"hash: %v", err)
+		return nil, nil, scriptError(ErrInvalidSigHashType, str)
+	}
+
+	return hash, sigBytes, nil
+}
+
+// verifySchnorrSig returns whether or not the passed 64-byte signature is a
+// valid BIP0340 signature of the passed hash for the passed x-only public key,
+// making use of the signature cache when available.  Well-formed signatures
+// are queued to the Schnorr batch verifier, when the engine has one, and
+// reported as valid.
+func (vm *Engine) verifySchnorrSig(hash, sigBytes, pkBytes []byte) bool {
+	var sigHash chainhash.Hash
+	copy(sigHash[:], hash)
+	if vm.sigCache != nil && vm.sigCache.Exists(sigHash, sigBytes, pkBytes) {
+		return true
+	}
+
+	pubKey, err := schnorr.ParsePubKey(pkBytes)
+	if err != nil {
+		return false
+	}
+	signature, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return false
+	}
+
+	// Defer the verification to the batch when there is one.  This is
+	// sound since a Schnorr signature which fails verification always
+	// fails the script, so the script is invalid whenever the batch is.
+	if vm.schnorrBatch != nil {
+		vm.schnorrBatch.Add(signature, hash, pubKey)
+		return true
+	}
+	if !signature.Verify(hash, pubKey) {
+		return false
+	}
+
+	if vm.sigCache != nil {
+		vm.sigCache.Add(sigHash, sigBytes, pkBytes)
+	}
+	return true
+}
+
 // DisasmPC returns the string for the disassembly of the opcode that will be
 // next to execute when Step is called.
 func (vm *Engine) DisasmPC() (string, error) {
@@ -593,10 +867,18 @@ func (vm *Engine) CheckErrorCondition(finalScript bool) error {
 			"error check when script unfinished")
 	}
 
-	// If we're in version zero witness execution mode, and this was the
-	// final script, then the stack MUST be clean in order to maintain
-	// compatibility with BIP16.
-	if finalScript && vm.isWitnessVersionActive(0) && vm.dstack.Depth() != 1 {
+	// Taproot spends which were verified without executing a script, such
+	// as key path spends, are valid irrespective of the stack.
+	if finalScript && vm.taprootCtx != nil && vm.taprootCtx.mustSucceed {
+		return nil
+	}
+
+	// If we're in version zero witness execution mode or executing a
+	// tapscript, and this was the final script, then the stack MUST be
+	// clean in order to maintain compatibility with BIP16.
+	if finalScript && (vm.isWitnessVersionActive(0) || vm.isTapscript()) &&
+		vm.dstack.Depth() != 1 {
+
 		return scriptError(ErrEvalFalse, "witness program must "+
 			"have clean stack")
 	}
@@ -665,10 +947,23 @@ func (vm *Engine) Step() (done bool, err error) {
 		return true, scriptError(ErrInvalidProgramCounter, str)
 	}
 
+	// Report the opcode to the tracer once the step is complete, including
+	// the move to the next script when it is the last of its script.
+	var step *TraceStep
+	if vm.tracer != nil {
+		step = vm.beginTraceStep()
+		defer func() {
+			vm.endTraceStep(step, err)
+		}()
+	}
+
 	// Execute the opcode while taking into account several things such as
 	// disabled opcodes, illegal opcodes, maximum allowed operations per script,
 	// maximum script element sizes, and conditionals.
 	err = vm.executeOpcode(vm.tokenizer.op, vm.tokenizer.Data())
+	if step != nil {
+		vm.snapshotTraceStep(step)
+	}
 	if err != nil {
 		return true, err
 	}
@@ -1089,6 +1384,15 @@ func setStack(stack *stack, data [][]byte) {
 	}
 }
 
+// SetSchnorrBatchVerifier makes the engine queue the Schnorr signatures it
+// checks while executing taproot spends to the passed batch verifier rather
+// than verifying them immediately, treating them as valid.  The caller must
+// verify the batch once the engine completes and consider the script invalid
+// if the batch is.
+func (vm *Engine) SetSchnorrBatchVerifier(batch *schnorr.BatchVerifier) {
+	vm.schnorrBatch = batch
+}
+
 // GetStack returns the contents of the primary stack as an array. where the
 // last item in the array is the top of the stack.
 func (vm *Engine) GetStack() [][]byte {
@@ -1116,8 +1420,13 @@ func (vm *Engine) SetAltStack(data [][]byte) {
 // NewEngine returns a new script engine for the provided public key script,
 // transaction, and input index.  The flags modify the behavior of the script
 // engine according to the description provided by each flag.
+//
+// The passed fetcher supplies the outputs spent by the transaction, which are
+// required to verify taproot spends.  It may be nil when the flag to verify
+// taproot spends isn't set.
 func NewEngine(scriptPubKey []byte, tx *wire.MsgTx, txIdx int, flags ScriptFlags,
-	sigCache *SigCache, hashCache *TxSigHashes, inputAmount int64) (*Engine, error) {
+	sigCache *SigCache, hashCache *TxSigHashes, inputAmount int64,
+	prevOutFetcher PrevOutputFetcher) (*Engine, error) {
 	const scriptVersion = 0
 
 	// The provided transaction input index must refer to a valid input.
@@ -1148,7 +1457,7 @@ func NewEngine(scriptPubKey []byte, tx *wire.MsgTx, txIdx int, flags ScriptFlags
 	// when it should be. The same goes for segwit which will pull in
 	// additional scripts for execution from the witness stack.
 	vm := Engine{flags: flags, sigCache: sigCache, hashCache: hashCache,
-		inputAmount: inputAmount}
+		inputAmount: inputAmount, prevOutFetcher: prevOutFetcher}
 	if vm.hasFlag(ScriptVerifyCleanStack) && (!vm.hasFlag(ScriptBip16) &&
 		!vm.hasFlag(ScriptVerifyWitness)) {
 		return nil, scriptError(ErrInvalidFlags,
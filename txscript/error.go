@@ -343,6 +343,62 @@ const (
 	// serialized in a compressed format.
 	ErrWitnessPubKeyType
 
+	// ----------------------------
+	// Failures related to taproot.
+	// ----------------------------
+
+	// ErrDiscourageOpSuccess is returned if
+	// ScriptVerifyDiscourageOpSuccess is set and a tapscript contains an
+	// OP_SUCCESS opcode.
+	ErrDiscourageOpSuccess
+
+	// ErrDiscourageUpgradeableTaprootVersion is returned if
+	// ScriptVerifyDiscourageUpgradeableTaprootVersion is set and a taproot
+	// script path spend reveals a leaf with an unknown leaf version.
+	ErrDiscourageUpgradeableTaprootVersion
+
+	// ErrDiscourageUpgradeablePubKeyType is returned if
+	// ScriptVerifyDiscourageUpgradeablePubkeyType is set and a tapscript
+	// signature check uses a public key which isn't 32 bytes.
+	ErrDiscourageUpgradeablePubKeyType
+
+	// ErrTapscriptCheckMultisig is returned if OP_CHECKMULTISIG or
+	// OP_CHECKMULTISIGVERIFY is executed within a tapscript.
+	ErrTapscriptCheckMultisig
+
+	// ErrTaprootPubkeyIsEmpty is returned if a tapscript signature check
+	// uses an empty public key.
+	ErrTaprootPubkeyIsEmpty
+
+	// ErrTaprootMaxSigOps is returned if the signature checks of a
+	// tapscript exceed its signature operations budget.
+	ErrTaprootMaxSigOps
+
+	// ErrInvalidTaprootSigLen is returned if a taproot signature isn't 64
+	// or 65 bytes long.
+	ErrInvalidTaprootSigLen
+
+	// ErrTaprootSigInvalid is returned if a non-empty taproot signature
+	// fails to verify.
+	ErrTaprootSigInvalid
+
+	// ErrControlBlockTooSmall is returned if a taproot control block is
+	// smaller than the size of its internal key and leaf version.
+	ErrControlBlockTooSmall
+
+	// ErrControlBlockTooLarge is returned if a taproot control block
+	// commits to a merkle path deeper than 128 nodes.
+	ErrControlBlockTooLarge
+
+	// ErrControlBlockInvalidLength is returned if the merkle path of a
+	// taproot control block isn't a multiple of 32 bytes.
+	ErrControlBlockInvalidLength
+
+	// ErrTaprootMerkleProofInvalid is returned if the revealed leaf and
+	// control block of a taproot script path spend don't commit to the
+	// output key of the witness program.
+	ErrTaprootMerkleProofInvalid
+
 	// numErrorCodes is the maximum error code number used in tests.  This
 	// entry MUST be the last entry in the enum.
 	numErrorCodes
@@ -350,71 +406,83 @@ const (
 
 // Map of ErrorCode values back to their constant names for pretty printing.
 var errorCodeStrings = map[ErrorCode]string{
-	ErrInternal:                           "ErrInternal",
-	ErrInvalidFlags:                       "ErrInvalidFlags",
-	ErrInvalidIndex:                       "ErrInvalidIndex",
-	ErrUnsupportedAddress:                 "ErrUnsupportedAddress",
-	ErrNotMultisigScript:                  "ErrNotMultisigScript",
-	ErrTooManyRequiredSigs:                "ErrTooManyRequiredSigs",
-	ErrTooMuchNullData:                    "ErrTooMuchNullData",
-	ErrUnsupportedScriptVersion:           "ErrUnsupportedScriptVersion",
-	ErrEarlyReturn:                        "ErrEarlyReturn",
-	ErrEmptyStack:                         "ErrEmptyStack",
-	ErrEvalFalse:                          "ErrEvalFalse",
-	ErrScriptUnfinished:                   "ErrScriptUnfinished",
-	ErrInvalidProgramCounter:              "ErrInvalidProgramCounter",
-	ErrScriptTooBig:                       "ErrScriptTooBig",
-	ErrElementTooBig:                      "ErrElementTooBig",
-	ErrTooManyOperations:                  "ErrTooManyOperations",
-	ErrStackOverflow:                      "ErrStackOverflow",
-	ErrInvalidPubKeyCount:                 "ErrInvalidPubKeyCount",
-	ErrInvalidSignatureCount:              "ErrInvalidSignatureCount",
-	ErrNumberTooBig:                       "ErrNumberTooBig",
-	ErrVerify:                             "ErrVerify",
-	ErrEqualVerify:                        "ErrEqualVerify",
-	ErrNumEqualVerify:                     "ErrNumEqualVerify",
-	ErrCheckSigVerify:                     "ErrCheckSigVerify",
-	ErrCheckMultiSigVerify:                "ErrCheckMultiSigVerify",
-	ErrDisabledOpcode:                     "ErrDisabledOpcode",
-	ErrReservedOpcode:                     "ErrReservedOpcode",
-	ErrMalformedPush:                      "ErrMalformedPush",
-	ErrInvalidStackOperation:              "ErrInvalidStackOperation",
-	ErrUnbalancedConditional:              "ErrUnbalancedConditional",
-	ErrMinimalData:                        "ErrMinimalData",
-	ErrInvalidSigHashType:                 "ErrInvalidSigHashType",
-	ErrSigTooShort:                        "ErrSigTooShort",
-	ErrSigTooLong:                         "ErrSigTooLong",
-	ErrSigInvalidSeqID:                    "ErrSigInvalidSeqID",
-	ErrSigInvalidDataLen:                  "ErrSigInvalidDataLen",
-	ErrSigMissingSTypeID:                  "ErrSigMissingSTypeID",
-	ErrSigMissingSLen:                     "ErrSigMissingSLen",
-	ErrSigInvalidSLen:                     "ErrSigInvalidSLen",
-	ErrSigInvalidRIntID:                   "ErrSigInvalidRIntID",
-	ErrSigZeroRLen:                        "ErrSigZeroRLen",
-	ErrSigNegativeR:                       "ErrSigNegativeR",
-	ErrSigTooMuchRPadding:                 "ErrSigTooMuchRPadding",
-	ErrSigInvalidSIntID:                   "ErrSigInvalidSIntID",
-	ErrSigZeroSLen:                        "ErrSigZeroSLen",
-	ErrSigNegativeS:                       "ErrSigNegativeS",
-	ErrSigTooMuchSPadding:                 "ErrSigTooMuchSPadding",
-	ErrSigHighS:                           "ErrSigHighS",
-	ErrNotPushOnly:                        "ErrNotPushOnly",
-	ErrSigNullDummy:                       "ErrSigNullDummy",
-	ErrPubKeyType:                         "ErrPubKeyType",
-	ErrCleanStack:                         "ErrCleanStack",
-	ErrNullFail:                           "ErrNullFail",
-	ErrDiscourageUpgradableNOPs:           "ErrDiscourageUpgradableNOPs",
-	ErrNegativeLockTime:                   "ErrNegativeLockTime",
-	ErrUnsatisfiedLockTime:                "ErrUnsatisfiedLockTime",
-	ErrWitnessProgramEmpty:                "ErrWitnessProgramEmpty",
-	ErrWitnessProgramMismatch:             "ErrWitnessProgramMismatch",
-	ErrWitnessProgramWrongLength:          "ErrWitnessProgramWrongLength",
-	ErrWitnessMalleated:                   "ErrWitnessMalleated",
-	ErrWitnessMalleatedP2SH:               "ErrWitnessMalleatedP2SH",
-	ErrWitnessUnexpected:                  "ErrWitnessUnexpected",
-	ErrMinimalIf:                          "ErrMinimalIf",
-	ErrWitnessPubKeyType:                  "ErrWitnessPubKeyType",
-	ErrDiscourageUpgradableWitnessProgram: "ErrDiscourageUpgradableWitnessProgram",
+	ErrInternal:                            "ErrInternal",
+	ErrInvalidFlags:                        "ErrInvalidFlags",
+	ErrInvalidIndex:                        "ErrInvalidIndex",
+	ErrUnsupportedAddress:                  "ErrUnsupportedAddress",
+	ErrNotMultisigScript:                   "ErrNotMultisigScript",
+	ErrTooManyRequiredSigs:                 "ErrTooManyRequiredSigs",
+	ErrTooMuchNullData:                     "ErrTooMuchNullData",
+	ErrUnsupportedScriptVersion:            "ErrUnsupportedScriptVersion",
+	ErrEarlyReturn:                         "ErrEarlyReturn",
+	ErrEmptyStack:                          "ErrEmptyStack",
+	ErrEvalFalse:                           "ErrEvalFalse",
+	ErrScriptUnfinished:                    "ErrScriptUnfinished",
+	ErrInvalidProgramCounter:               "ErrInvalidProgramCounter",
+	ErrScriptTooBig:                        "ErrScriptTooBig",
+	ErrElementTooBig:                       "ErrElementTooBig",
+	ErrTooManyOperations:                   "ErrTooManyOperations",
+	ErrStackOverflow:                       "ErrStackOverflow",
+	ErrInvalidPubKeyCount:                  "ErrInvalidPubKeyCount",
+	ErrInvalidSignatureCount:               "ErrInvalidSignatureCount",
+	ErrNumberTooBig:                        "ErrNumberTooBig",
+	ErrVerify:                              "ErrVerify",
+	ErrEqualVerify:                         "ErrEqualVerify",
+	ErrNumEqualVerify:                      "ErrNumEqualVerify",
+	ErrCheckSigVerify:                      "ErrCheckSigVerify",
+	ErrCheckMultiSigVerify:                 "ErrCheckMultiSigVerify",
+	ErrDisabledOpcode:                      "ErrDisabledOpcode",
+	ErrReservedOpcode:                      "ErrReservedOpcode",
+	ErrMalformedPush:                       "ErrMalformedPush",
+	ErrInvalidStackOperation:               "ErrInvalidStackOperation",
+	ErrUnbalancedConditional:               "ErrUnbalancedConditional",
+	ErrMinimalData:                         "ErrMinimalData",
+	ErrInvalidSigHashType:                  "ErrInvalidSigHashType",
+	ErrSigTooShort:                         "ErrSigTooShort",
+	ErrSigTooLong:                          "ErrSigTooLong",
+	ErrSigInvalidSeqID:                     "ErrSigInvalidSeqID",
+	ErrSigInvalidDataLen:                   "ErrSigInvalidDataLen",
+	ErrSigMissingSTypeID:                   "ErrSigMissingSTypeID",
+	ErrSigMissingSLen:                      "ErrSigMissingSLen",
+	ErrSigInvalidSLen:                      "ErrSigInvalidSLen",
+	ErrSigInvalidRIntID:                    "ErrSigInvalidRIntID",
+	ErrSigZeroRLen:                         "ErrSigZeroRLen",
+	ErrSigNegativeR:                        "ErrSigNegativeR",
+	ErrSigTooMuchRPadding:                  "ErrSigTooMuchRPadding",
+	ErrSigInvalidSIntID:                    "ErrSigInvalidSIntID",
+	ErrSigZeroSLen:                         "ErrSigZeroSLen",
+	ErrSigNegativeS:                        "ErrSigNegativeS",
+	ErrSigTooMuchSPadding:                  "ErrSigTooMuchSPadding",
+	ErrSigHighS:                            "ErrSigHighS",
+	ErrNotPushOnly:                         "ErrNotPushOnly",
+	ErrSigNullDummy:                        "ErrSigNullDummy",
+	ErrPubKeyType:                          "ErrPubKeyType",
+	ErrCleanStack:                          "ErrCleanStack",
+	ErrNullFail:                            "ErrNullFail",
+	ErrDiscourageUpgradableNOPs:            "ErrDiscourageUpgradableNOPs",
+	ErrNegativeLockTime:                    "ErrNegativeLockTime",
+	ErrUnsatisfiedLockTime:                 "ErrUnsatisfiedLockTime",
+	ErrWitnessProgramEmpty:                 "ErrWitnessProgramEmpty",
+	ErrWitnessProgramMismatch:              "ErrWitnessProgramMismatch",
+	ErrWitnessProgramWrongLength:           "ErrWitnessProgramWrongLength",
+	ErrWitnessMalleated:                    "ErrWitnessMalleated",
+	ErrWitnessMalleatedP2SH:                "ErrWitnessMalleatedP2SH",
+	ErrWitnessUnexpected:                   "ErrWitnessUnexpected",
+	ErrMinimalIf:                           "ErrMinimalIf",
+	ErrWitnessPubKeyType:                   "ErrWitnessPubKeyType",
+	ErrDiscourageUpgradableWitnessProgram:  "ErrDiscourageUpgradableWitnessProgram",
+	ErrDiscourageOpSuccess:                 "ErrDiscourageOpSuccess",
+	ErrDiscourageUpgradeableTaprootVersion: "ErrDiscourageUpgradeableTaprootVersion",
+	ErrDiscourageUpgradeablePubKeyType:     "ErrDiscourageUpgradeablePubKeyType",
+	ErrTapscriptCheckMultisig:              "ErrTapscriptCheckMultisig",
+	ErrTaprootPubkeyIsEmpty:                "ErrTaprootPubkeyIsEmpty",
+	ErrTaprootMaxSigOps:                    "ErrTaprootMaxSigOps",
+	ErrInvalidTaprootSigLen:                "ErrInvalidTaprootSigLen",
+	ErrTaprootSigInvalid:                   "ErrTaprootSigInvalid",
+	ErrControlBlockTooSmall:                "ErrControlBlockTooSmall",
+	ErrControlBlockTooLarge:                "ErrControlBlockTooLarge",
+	ErrControlBlockInvalidLength:           "ErrControlBlockInvalidLength",
+	ErrTaprootMerkleProofInvalid:           "ErrTaprootMerkleProofInvalid",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -427,10 +495,10 @@ func (e ErrorCode) String() string {
 
 // Error identifies a script-related error.  It is used to indicate three
 // classes of errors:
-// 1) Script execution failures due to violating one of the many requirements
-//    imposed by the script engine or evaluating to false
-// 2) Improper API usage by callers
-// 3) Internal consistency check failures
+//  1. Script execution failures due to violating one of the many requirements
+//     imposed by the script engine or evaluating to false
+//  2. Improper API usage by callers
+//  3. Internal consistency check failures
 //
 // The caller can use type assertions on the returned errors to access the
 // ErrorCode field to ascertain the specific reason for the error.  As an
@@ -82,6 +82,18 @@ func TestErrorCodeStringer(t *testing.T) {
 		{ErrMinimalIf, "ErrMinimalIf"},
 		{ErrWitnessPubKeyType, "ErrWitnessPubKeyType"},
 		{ErrDiscourageUpgradableWitnessProgram, "ErrDiscourageUpgradableWitnessProgram"},
+		{ErrDiscourageOpSuccess, "ErrDiscourageOpSuccess"},
+		{ErrDiscourageUpgradeableTaprootVersion, "ErrDiscourageUpgradeableTaprootVersion"},
+		{ErrDiscourageUpgradeablePubKeyType, "ErrDiscourageUpgradeablePubKeyType"},
+		{ErrTapscriptCheckMultisig, "ErrTapscriptCheckMultisig"},
+		{ErrTaprootPubkeyIsEmpty, "ErrTaprootPubkeyIsEmpty"},
+		{ErrTaprootMaxSigOps, "ErrTaprootMaxSigOps"},
+		{ErrInvalidTaprootSigLen, "ErrInvalidTaprootSigLen"},
+		{ErrTaprootSigInvalid, "ErrTaprootSigInvalid"},
+		{ErrControlBlockTooSmall, "ErrControlBlockTooSmall"},
+		{ErrControlBlockTooLarge, "ErrControlBlockTooLarge"},
+		{ErrControlBlockInvalidLength, "ErrControlBlockInvalidLength"},
+		{ErrTaprootMerkleProofInvalid, "ErrTaprootMerkleProofInvalid"},
 		{0xffff, "Unknown ErrorCode (65535)"},
 	}
 
@@ -11,24 +11,140 @@ import (
 	"github.com/btcsuite/btcd/wire"
 )
 
-// TxSigHashes houses the partial set of sighashes introduced within BIP0143.
-// This partial set of sighashes may be re-used within each input across a
-// transaction when validating all inputs. As a result, validation complexity
-// for SigHashAll can be reduced by a polynomial factor.
+// PrevOutputFetcher is an interface used to supply the sighash cache and the
+// script engine with the previous output spent by an input.  The taproot
+// signature hashes commit to the amounts and public key scripts of all of the
+// outputs a transaction spends, so they can't be calculated from the
+// transaction alone.
+type PrevOutputFetcher interface {
+	// FetchPrevOutput returns the previous output referenced by the passed
+	// outpoint, or nil when it isn't known.
+	FetchPrevOutput(wire.OutPoint) *wire.TxOut
+}
+
+// CannedPrevOutputFetcher is an implementation of PrevOutputFetcher that
+// returns the same output for any outpoint.  It is only suitable for
+// transactions which spend a single output.
+type CannedPrevOutputFetcher struct {
+	pkScript []byte
+	amt      int64
+}
+
+// NewCannedPrevOutputFetcher returns a new instance of a
+// CannedPrevOutputFetcher that returns an output with the passed public key
+// script and amount for any outpoint.
+func NewCannedPrevOutputFetcher(pkScript []byte, amt int64) *CannedPrevOutputFetcher {
+	return &CannedPrevOutputFetcher{
+		pkScript: pkScript,
+		amt:      amt,
+	}
+}
+
+// FetchPrevOutput returns the canned output.
+//
+// This is part of the PrevOutputFetcher interface.
+func (c *CannedPrevOutputFetcher) FetchPrevOutput(wire.OutPoint) *wire.TxOut {
+	return wire.NewTxOut(c.amt, c.pkScript)
+}
+
+// A compile-time assertion to ensure that CannedPrevOutputFetcher implements
+// the PrevOutputFetcher interface.
+var _ PrevOutputFetcher = (*CannedPrevOutputFetcher)(nil)
+
+// MultiPrevOutFetcher is an implementation of PrevOutputFetcher backed by a
+// map of outpoints to the outputs they reference.
+type MultiPrevOutFetcher struct {
+	prevOuts map[wire.OutPoint]*wire.TxOut
+}
+
+// NewMultiPrevOutFetcher returns a new instance of a MultiPrevOutFetcher
+// backed by the passed map, which may be nil.
+func NewMultiPrevOutFetcher(prevOuts map[wire.OutPoint]*wire.TxOut) *MultiPrevOutFetcher {
+	if prevOuts == nil {
+		prevOuts = make(map[wire.OutPoint]*wire.TxOut)
+	}
+
+	return &MultiPrevOutFetcher{
+		prevOuts: prevOuts,
+	}
+}
+
+// FetchPrevOutput returns the output referenced by the passed outpoint, or nil
+// when it isn't known.
+//
+// This is part of the PrevOutputFetcher interface.
+func (m *MultiPrevOutFetcher) FetchPrevOutput(op wire.OutPoint) *wire.TxOut {
+	return m.prevOuts[op]
+}
+
+// AddPrevOut adds the output referenced by the passed outpoint to the fetcher.
+func (m *MultiPrevOutFetcher) AddPrevOut(op wire.OutPoint, txOut *wire.TxOut) {
+	m.prevOuts[op] = txOut
+}
+
+// A compile-time assertion to ensure that MultiPrevOutFetcher implements the
+// PrevOutputFetcher interface.
+var _ PrevOutputFetcher = (*MultiPrevOutFetcher)(nil)
+
+// TxSigHashes houses the partial set of sighashes introduced within BIP0143
+// and BIP0341.  This partial set of sighashes may be re-used within each input
+// across a transaction when validating all inputs. As a result, validation
+// complexity for SigHashAll can be reduced by a polynomial factor.
+//
+// The BIP0341 midstates are single SHA256 hashes and are only calculated for
+// transactions which spend at least one taproot output.
 type TxSigHashes struct {
 	HashPrevOuts chainhash.Hash
 	HashSequence chainhash.Hash
 	HashOutputs  chainhash.Hash
+
+	HashPrevOutsV1     chainhash.Hash
+	HashSequenceV1     chainhash.Hash
+	HashOutputsV1      chainhash.Hash
+	HashInputAmountsV1 chainhash.Hash
+	HashInputScriptsV1 chainhash.Hash
 }
 
 // NewTxSigHashes computes, and returns the cached sighashes of the given
-// transaction.
-func NewTxSigHashes(tx *wire.MsgTx) *TxSigHashes {
-	return &TxSigHashes{
-		HashPrevOuts: calcHashPrevOuts(tx),
-		HashSequence: calcHashSequence(tx),
-		HashOutputs:  calcHashOutputs(tx),
+// transaction.  The passed fetcher supplies the outputs spent by the
+// transaction for the taproot midstates and may be nil when the transaction
+// doesn't spend any taproot outputs.
+func NewTxSigHashes(tx *wire.MsgTx, prevOutFetcher PrevOutputFetcher) *TxSigHashes {
+	// The BIP0143 midstates are the double SHA256 of the same data the
+	// BIP0341 midstates are the single SHA256 of, so the latter are always
+	// calculated and the former derived from them.
+	hashPrevOutsV1 := calcHashPrevOutsV1(tx)
+	hashSequenceV1 := calcHashSequenceV1(tx)
+	hashOutputsV1 := calcHashOutputsV1(tx)
+	sigHashes := &TxSigHashes{
+		HashPrevOuts: chainhash.HashH(hashPrevOutsV1[:]),
+		HashSequence: chainhash.HashH(hashSequenceV1[:]),
+		HashOutputs:  chainhash.HashH(hashOutputsV1[:]),
+	}
+
+	if prevOutFetcher == nil || !spendsTaprootOutput(tx, prevOutFetcher) {
+		return sigHashes
+	}
+
+	sigHashes.HashPrevOutsV1 = hashPrevOutsV1
+	sigHashes.HashSequenceV1 = hashSequenceV1
+	sigHashes.HashOutputsV1 = hashOutputsV1
+	sigHashes.HashInputAmountsV1 = calcHashInputAmountsV1(tx, prevOutFetcher)
+	sigHashes.HashInputScriptsV1 = calcHashInputScriptsV1(tx, prevOutFetcher)
+
+	return sigHashes
+}
+
+// spendsTaprootOutput returns whether or not any of the outputs spent by the
+// passed transaction are native taproot outputs.
+func spendsTaprootOutput(tx *wire.MsgTx, prevOutFetcher PrevOutputFetcher) bool {
+	for _, txIn := range tx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		if prevOut != nil && isPayToTaprootScript(prevOut.PkScript) {
+			return true
+		}
 	}
+	return false
 }
 
 // HashCache houses a set of partial sighashes keyed by txid. The set of partial
@@ -51,10 +167,12 @@ func NewHashCache(maxSize uint) *HashCache {
 }
 
 // AddSigHashes computes, then adds the partial sighashes for the passed
-// transaction.
-func (h *HashCache) AddSigHashes(tx *wire.MsgTx) {
+// transaction.  The passed fetcher supplies the outputs spent by the
+// transaction as described by NewTxSigHashes.
+func (h *HashCache) AddSigHashes(tx *wire.MsgTx, prevOutFetcher PrevOutputFetcher) {
+	sigHashes := NewTxSigHashes(tx, prevOutFetcher)
 	h.Lock()
-	h.sigHashes[tx.TxHash()] = NewTxSigHashes(tx)
+	h.sigHashes[tx.TxHash()] = sigHashes
 	h.Unlock()
 }
 
@@ -78,7 +78,7 @@ func TestHashCacheAddContainsHashes(t *testing.T) {
 	// With the transactions generated, we'll add each of them to the hash
 	// cache.
 	for _, tx := range txns {
-		cache.AddSigHashes(tx)
+		cache.AddSigHashes(tx, nil)
 	}
 
 	// Next, we'll ensure that each of the transactions inserted into the
@@ -119,10 +119,10 @@ func TestHashCacheAddGet(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unable to generate tx: %v", err)
 	}
-	sigHashes := NewTxSigHashes(randTx)
+	sigHashes := NewTxSigHashes(randTx, nil)
 
 	// Next, add the transaction to the hash cache.
-	cache.AddSigHashes(randTx)
+	cache.AddSigHashes(randTx, nil)
 
 	// The transaction inserted into the cache above should be found.
 	txid := randTx.TxHash()
@@ -158,7 +158,7 @@ func TestHashCachePurge(t *testing.T) {
 		}
 	}
 	for _, tx := range txns {
-		cache.AddSigHashes(tx)
+		cache.AddSigHashes(tx, nil)
 	}
 
 	// Once all the transactions have been inserted, we'll purge them from
@@ -16,6 +16,7 @@ import (
 	"golang.org/x/crypto/ripemd160"
 
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 )
@@ -225,7 +226,7 @@ const (
 	OP_NOP8                = 0xb7 // 183
 	OP_NOP9                = 0xb8 // 184
 	OP_NOP10               = 0xb9 // 185
-	OP_UNKNOWN186          = 0xba // 186
+	OP_CHECKSIGADD         = 0xba // 186
 	OP_UNKNOWN187          = 0xbb // 187
 	OP_UNKNOWN188          = 0xbc // 188
 	OP_UNKNOWN189          = 0xbd // 189
@@ -510,8 +511,10 @@ var opcodeArray = [256]opcode{
 	OP_NOP9:  {OP_NOP9, "OP_NOP9", 1, opcodeNop},
 	OP_NOP10: {OP_NOP10, "OP_NOP10", 1, opcodeNop},
 
+	// Tapscript opcodes.
+	OP_CHECKSIGADD: {OP_CHECKSIGADD, "OP_CHECKSIGADD", 1, opcodeCheckSigAdd},
+
 	// Undefined opcodes.
-	OP_UNKNOWN186: {OP_UNKNOWN186, "OP_UNKNOWN186", 1, opcodeInvalid},
 	OP_UNKNOWN187: {OP_UNKNOWN187, "OP_UNKNOWN187", 1, opcodeInvalid},
 	OP_UNKNOWN188: {OP_UNKNOWN188, "OP_UNKNOWN188", 1, opcodeInvalid},
 	OP_UNKNOWN189: {OP_UNKNOWN189, "OP_UNKNOWN189", 1, opcodeInvalid},
@@ -741,18 +744,21 @@ func opcodeNop(op *opcode, data []byte, vm *Engine) error {
 // of nuisance malleability, post-segwit for version 0 witness programs, we now
 // require the following: for OP_IF and OP_NOT_IF, the top stack item MUST
 // either be an empty byte slice, or [0x01]. Otherwise, the item at the top of
-// the stack will be popped and interpreted as a boolean.
+// the stack will be popped and interpreted as a boolean.  The policy is a
+// consensus rule for tapscripts, so it is always enforced for them.
 func popIfBool(vm *Engine) (bool, error) {
-	// When not in witness execution mode, not executing a v0 witness
-	// program, or the minimal if flag isn't set pop the top stack item as
-	// a normal bool.
-	if !vm.isWitnessVersionActive(0) || !vm.hasFlag(ScriptVerifyMinimalIf) {
+	// When not executing a tapscript and either not in witness execution
+	// mode, not executing a v0 witness program, or the minimal if flag
+	// isn't set pop the top stack item as a normal bool.
+	if !vm.isTapscript() && (!vm.isWitnessVersionActive(0) ||
+		!vm.hasFlag(ScriptVerifyMinimalIf)) {
+
 		return vm.dstack.PopBool()
 	}
 
-	// At this point, a v0 witness program is being executed and the minimal
-	// if flag is set, so enforce additional constraints on the top stack
-	// item.
+	// At this point, a v0 witness program with the minimal if flag set or a
+	// tapscript is being executed, so enforce additional constraints on the
+	// top stack item.
 	so, err := vm.dstack.PopByteArray()
 	if err != nil {
 		return false, err
@@ -1840,9 +1846,16 @@ func opcodeHash256(op *opcode, data []byte, vm *Engine) error {
 // opcodeCodeSeparator stores the current script offset as the most recently
 // seen OP_CODESEPARATOR which is used during signature checking.
 //
+// Tapscript signatures instead commit to the opcode position of the most
+// recently executed OP_CODESEPARATOR, which is stored in the taproot execution
+// context.
+//
 // This opcode does not change the contents of the data stack.
 func opcodeCodeSeparator(op *opcode, data []byte, vm *Engine) error {
 	vm.lastCodeSep = int(vm.tokenizer.ByteIndex())
+	if vm.isTapscript() {
+		vm.taprootCtx.codeSepPos = uint32(vm.opcodeIdx)
+	}
 	return nil
 }
 
@@ -1871,6 +1884,16 @@ func opcodeCheckSig(op *opcode, data []byte, vm *Engine) error {
 		return err
 	}
 
+	// Tapscripts verify schnorr signatures with their own semantics.
+	if vm.isTapscript() {
+		valid, err := vm.checkTapscriptSig(fullSigBytes, pkBytes)
+		if err != nil {
+			return err
+		}
+		vm.dstack.PushBool(valid)
+		return nil
+	}
+
 	// The signature actually needs needs to be longer than this, but at
 	// least 1 byte is needed for the hash type below.  The full length is
 	// checked depending on the script flags and upon parsing the signature.
@@ -1913,7 +1936,7 @@ func opcodeCheckSig(op *opcode, data []byte, vm *Engine) error {
 		if vm.hashCache != nil {
 			sigHashes = vm.hashCache
 		} else {
-			sigHashes = NewTxSigHashes(&vm.tx)
+			sigHashes = NewTxSigHashes(&vm.tx, vm.prevOutFetcher)
 		}
 
 		hash, err = calcWitnessSignatureHashRaw(subScript, sigHashes, hashType,
@@ -1946,6 +1969,7 @@ func opcodeCheckSig(op *opcode, data []byte, vm *Engine) error {
 	} else {
 		pubKey, err := btcec.ParsePubKey(pkBytes, btcec.S256())
 		if err != nil {
+			vm.traceSigCheck(pkBytes, sigBytes, hashType, hash, false)
 			vm.dstack.PushBool(false)
 			return nil
 		}
@@ -1959,6 +1983,7 @@ func opcodeCheckSig(op *opcode, data []byte, vm *Engine) error {
 			signature, err = btcec.ParseSignature(sigBytes, btcec.S256())
 		}
 		if err != nil {
+			vm.traceSigCheck(pkBytes, sigBytes, hashType, hash, false)
 			vm.dstack.PushBool(false)
 			return nil
 		}
@@ -1976,6 +2001,7 @@ func opcodeCheckSig(op *opcode, data []byte, vm *Engine) error {
 		}
 	}
 
+	vm.traceSigCheck(pkBytes, sigBytes, hashType, hash, valid)
 	if !valid && vm.hasFlag(ScriptVerifyNullFail) && len(sigBytes) > 0 {
 		str := "signature not empty on failed checksig"
 		return scriptError(ErrNullFail, str)
@@ -1998,6 +2024,93 @@ func opcodeCheckSigVerify(op *opcode, data []byte, vm *Engine) error {
 	return err
 }
 
+// checkTapscriptSig performs a signature check within a tapscript as defined by
+// BIP0342 and returns whether or not the passed signature is non-empty.  Empty
+// signatures are permitted and simply fail, while non-empty signatures for
+// 32-byte public keys MUST be valid.  Public keys of other non-zero sizes are
+// reserved for soft-fork upgrades, so their signatures succeed unconditionally.
+func (vm *Engine) checkTapscriptSig(sigBytes, pkBytes []byte) (bool, error) {
+	// Every non-empty signature consumes a part of the signature operations
+	// budget of the tapscript.
+	success := len(sigBytes) != 0
+	if success {
+		if err := vm.taprootCtx.tallySigOp(); err != nil {
+			return false, err
+		}
+	}
+
+	switch {
+	case len(pkBytes) == 0:
+		str := "tapscript signature check with an empty public key"
+		return false, scriptError(ErrTaprootPubkeyIsEmpty, str)
+
+	case len(pkBytes) == schnorr.PubKeyBytesLen:
+		if !success {
+			break
+		}
+		hash, schnorrSig, err := vm.calcTaprootSigHash(sigBytes)
+		if err != nil {
+			return false, err
+		}
+		valid := vm.verifySchnorrSig(hash, schnorrSig, pkBytes)
+		vm.traceSigCheck(pkBytes, schnorrSig, taprootSigHashType(sigBytes),
+			hash, valid)
+		if !valid {
+			str := "invalid tapscript signature"
+			return false, scriptError(ErrTaprootSigInvalid, str)
+		}
+
+	default:
+		if vm.hasFlag(ScriptVerifyDiscourageUpgradeablePubkeyType) {
+			str := fmt.Sprintf("tapscript public key of size %d is "+
+				"reserved for soft-fork upgrades", len(pkBytes))
+			return false, scriptError(ErrDiscourageUpgradeablePubKeyType,
+				str)
+		}
+	}
+
+	return success, nil
+}
+
+// opcodeCheckSigAdd treats the top 3 items on the stack as a signature, an
+// integer and a public key and replaces them with the integer incremented by
+// one if the signature is non-empty.  The signature is checked like the
+// signatures of OP_CHECKSIG within tapscripts, so non-empty signatures must be
+// valid.  It allows threshold policies to be expressed without
+// OP_CHECKMULTISIG, which is disabled in tapscripts.
+//
+// The opcode is only defined within tapscripts and is invalid otherwise.
+//
+// Stack transformation: [... signature n pubkey] -> [... n+success]
+func opcodeCheckSigAdd(op *opcode, data []byte, vm *Engine) error {
+	if !vm.isTapscript() {
+		return opcodeInvalid(op, data, vm)
+	}
+
+	pkBytes, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return err
+	}
+	n, err := vm.dstack.PopInt()
+	if err != nil {
+		return err
+	}
+	sigBytes, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return err
+	}
+
+	success, err := vm.checkTapscriptSig(sigBytes, pkBytes)
+	if err != nil {
+		return err
+	}
+	if success {
+		n++
+	}
+	vm.dstack.PushInt(n)
+	return nil
+}
+
 // parsedSigInfo houses a raw signature along with its parsed form and a flag
 // for whether or not it has already been parsed.  It is used to prevent parsing
 // the same signature multiple times when verifying a multisig.
@@ -2024,9 +2137,17 @@ type parsedSigInfo struct {
 // See the opcodeCheckSigVerify documentation for more details about the process
 // for verifying each signature.
 //
+// The opcode is disabled within tapscripts.
+//
 // Stack transformation:
 // [... dummy [sig ...] numsigs [pubkey ...] numpubkeys] -> [... bool]
 func opcodeCheckMultiSig(op *opcode, data []byte, vm *Engine) error {
+	if vm.isTapscript() {
+		str := fmt.Sprintf("%s is disabled in tapscripts, use "+
+			"OP_CHECKSIGADD instead", op.name)
+		return scriptError(ErrTapscriptCheckMultisig, str)
+	}
+
 	numKeys, err := vm.dstack.PopInt()
 	if err != nil {
 		return err
@@ -2194,7 +2315,7 @@ func opcodeCheckMultiSig(op *opcode, data []byte, vm *Engine) error {
 			if vm.hashCache != nil {
 				sigHashes = vm.hashCache
 			} else {
-				sigHashes = NewTxSigHashes(&vm.tx)
+				sigHashes = NewTxSigHashes(&vm.tx, vm.prevOutFetcher)
 			}
 
 			hash, err = calcWitnessSignatureHashRaw(script, sigHashes, hashType,
@@ -2224,6 +2345,8 @@ func opcodeCheckMultiSig(op *opcode, data []byte, vm *Engine) error {
 			// Parse the pubkey.
 			parsedPubKey, err := btcec.ParsePubKey(pubKey, btcec.S256())
 			if err != nil {
+				vm.traceSigCheck(pubKey, signature, hashType, hash,
+					false)
 				continue
 			}
 
@@ -2241,6 +2364,7 @@ func opcodeCheckMultiSig(op *opcode, data []byte, vm *Engine) error {
 			}
 		}
 
+		vm.traceSigCheck(pubKey, signature, hashType, hash, valid)
 		if valid {
 			// PubKey verified, move on to the next signature.
 			signatureIdx++
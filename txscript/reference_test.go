@@ -6,15 +6,19 @@ package txscript
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
@@ -192,6 +196,14 @@ func parseScriptFlags(flagStr string) (ScriptFlags, error) {
 			flags |= ScriptVerifyMinimalIf
 		case "WITNESS_PUBKEYTYPE":
 			flags |= ScriptVerifyWitnessPubKeyType
+		case "TAPROOT":
+			flags |= ScriptVerifyTaproot
+		case "DISCOURAGE_UPGRADABLE_TAPROOT_VERSION":
+			flags |= ScriptVerifyDiscourageUpgradeableTaprootVersion
+		case "DISCOURAGE_OP_SUCCESS":
+			flags |= ScriptVerifyDiscourageOpSuccess
+		case "DISCOURAGE_UPGRADABLE_PUBKEYTYPE":
+			flags |= ScriptVerifyDiscourageUpgradeablePubkeyType
 		default:
 			return flags, fmt.Errorf("invalid flag: %s", flag)
 		}
@@ -285,6 +297,27 @@ func parseExpectedResult(expected string) ([]ErrorCode, error) {
 		return []ErrorCode{ErrWitnessUnexpected}, nil
 	case "WITNESS_PUBKEYTYPE":
 		return []ErrorCode{ErrWitnessPubKeyType}, nil
+	case "SCHNORR_SIG":
+		return []ErrorCode{ErrTaprootSigInvalid}, nil
+	case "SCHNORR_SIG_SIZE":
+		return []ErrorCode{ErrInvalidTaprootSigLen}, nil
+	case "SCHNORR_SIG_HASHTYPE":
+		return []ErrorCode{ErrInvalidSigHashType}, nil
+	case "TAPSCRIPT_VALIDATION_WEIGHT":
+		return []ErrorCode{ErrTaprootMaxSigOps}, nil
+	case "TAPSCRIPT_CHECKMULTISIG":
+		return []ErrorCode{ErrTapscriptCheckMultisig}, nil
+	case "TAPSCRIPT_MINIMALIF":
+		return []ErrorCode{ErrMinimalIf}, nil
+	case "TAPROOT_WRONG_CONTROL_SIZE":
+		return []ErrorCode{ErrControlBlockTooSmall,
+			ErrControlBlockTooLarge, ErrControlBlockInvalidLength}, nil
+	case "DISCOURAGE_OP_SUCCESS":
+		return []ErrorCode{ErrDiscourageOpSuccess}, nil
+	case "DISCOURAGE_UPGRADABLE_TAPROOT_VERSION":
+		return []ErrorCode{ErrDiscourageUpgradeableTaprootVersion}, nil
+	case "DISCOURAGE_UPGRADABLE_PUBKEYTYPE":
+		return []ErrorCode{ErrDiscourageUpgradeablePubKeyType}, nil
 	}
 
 	return nil, fmt.Errorf("unrecognized expected result in test data: %v",
@@ -443,8 +476,10 @@ func testScripts(t *testing.T, tests [][]interface{}, useSigCache bool) {
 		// used, then create a new engine to execute the scripts.
 		tx := createSpendingTx(witness, scriptSig, scriptPubKey,
 			int64(inputAmt))
-		vm, err := NewEngine(scriptPubKey, tx, 0, flags, sigCache, nil,
+		prevOutFetcher := NewCannedPrevOutputFetcher(scriptPubKey,
 			int64(inputAmt))
+		vm, err := NewEngine(scriptPubKey, tx, 0, flags, sigCache, nil,
+			int64(inputAmt), prevOutFetcher)
 		if err == nil {
 			err = vm.Execute()
 		}
@@ -640,6 +675,12 @@ testloop:
 			prevOuts[*wire.NewOutPoint(prevhash, idx)] = v
 		}
 
+		prevOutFetcher := NewMultiPrevOutFetcher(nil)
+		for op, prevOut := range prevOuts {
+			prevOutFetcher.AddPrevOut(op, wire.NewTxOut(
+				prevOut.inputVal, prevOut.pkScript))
+		}
+
 		for k, txin := range tx.MsgTx().TxIn {
 			prevOut, ok := prevOuts[txin.PreviousOutPoint]
 			if !ok {
@@ -651,7 +692,7 @@ testloop:
 			// input fails the transaction has failed. (some of the
 			// test txns have good inputs, too..
 			vm, err := NewEngine(prevOut.pkScript, tx.MsgTx(), k,
-				flags, nil, nil, prevOut.inputVal)
+				flags, nil, nil, prevOut.inputVal, prevOutFetcher)
 			if err != nil {
 				continue testloop
 			}
@@ -795,6 +836,12 @@ testloop:
 			prevOuts[*wire.NewOutPoint(prevhash, idx)] = v
 		}
 
+		prevOutFetcher := NewMultiPrevOutFetcher(nil)
+		for op, prevOut := range prevOuts {
+			prevOutFetcher.AddPrevOut(op, wire.NewTxOut(
+				prevOut.inputVal, prevOut.pkScript))
+		}
+
 		for k, txin := range tx.MsgTx().TxIn {
 			prevOut, ok := prevOuts[txin.PreviousOutPoint]
 			if !ok {
@@ -803,7 +850,7 @@ testloop:
 				continue testloop
 			}
 			vm, err := NewEngine(prevOut.pkScript, tx.MsgTx(), k,
-				flags, nil, nil, prevOut.inputVal)
+				flags, nil, nil, prevOut.inputVal, prevOutFetcher)
 			if err != nil {
 				t.Errorf("test (%d:%v:%d) failed to create "+
 					"script: %v", i, test, k, err)
@@ -878,3 +925,497 @@ func TestCalcSignatureHash(t *testing.T) {
 		}
 	}
 }
+
+// taprootVectorTree is a script tree of the BIP0341 wallet test vectors, which
+// is either a leaf or a pair of script trees.
+type taprootVectorTree struct {
+	leaf     *TapLeaf
+	children [2]*taprootVectorTree
+}
+
+// parseTaprootVectorTree parses a script tree from the format used in the
+// BIP0341 wallet test vectors, where leaves are objects and inner nodes are
+// arrays of two script trees.
+func parseTaprootVectorTree(raw json.RawMessage) (*taprootVectorTree, error) {
+	var children []json.RawMessage
+	if err := json.Unmarshal(raw, &children); err == nil {
+		if len(children) != 2 {
+			return nil, fmt.Errorf("script tree node has %d children",
+				len(children))
+		}
+		var tree taprootVectorTree
+		for i, child := range children {
+			childTree, err := parseTaprootVectorTree(child)
+			if err != nil {
+				return nil, err
+			}
+			tree.children[i] = childTree
+		}
+		return &tree, nil
+	}
+
+	var leaf struct {
+		Script      string `json:"script"`
+		LeafVersion uint8  `json:"leafVersion"`
+	}
+	if err := json.Unmarshal(raw, &leaf); err != nil {
+		return nil, err
+	}
+	script, err := hex.DecodeString(leaf.Script)
+	if err != nil {
+		return nil, err
+	}
+	return &taprootVectorTree{leaf: &TapLeaf{
+		LeafVersion: TapscriptLeafVersion(leaf.LeafVersion),
+		Script:      script,
+	}}, nil
+}
+
+// walk returns the root hash of the script tree and calls the passed function
+// with each leaf, in depth first order, along with its merkle path.
+func (t *taprootVectorTree) walk(f func(leaf TapLeaf, path []byte)) chainhash.Hash {
+	if t.leaf != nil {
+		f(*t.leaf, nil)
+		return t.leaf.TapHash()
+	}
+
+	// The merkle path of a leaf is that within its subtree followed by
+	// the hash of the sibling of the subtree.
+	var leaves []TapLeaf
+	var paths [][]byte
+	var hashes [2]chainhash.Hash
+	var counts [2]int
+	for i, child := range t.children {
+		hashes[i] = child.walk(func(leaf TapLeaf, path []byte) {
+			leaves = append(leaves, leaf)
+			paths = append(paths, path)
+		})
+		counts[i] = len(leaves)
+	}
+	for i := range leaves {
+		sibling := hashes[1]
+		if i >= counts[0] {
+			sibling = hashes[0]
+		}
+		f(leaves[i], append(paths[i], sibling[:]...))
+	}
+	return tapBranchHash(hashes[0][:], hashes[1][:])
+}
+
+// TestTaprootWalletVectors ensures the output keys, control blocks, signature
+// hashes and signatures of the BIP0341 wallet test vectors are reproduced.
+// Since these are computed independently of this package, they catch errors
+// the tests signing with the same signature hash code they verify can't.
+func TestTaprootWalletVectors(t *testing.T) {
+	file, err := ioutil.ReadFile("data/bip341_wallet_vectors.json")
+	if err != nil {
+		t.Fatalf("TestTaprootWalletVectors: %v\n", err)
+	}
+
+	var vectors struct {
+		ScriptPubKey []struct {
+			Given struct {
+				InternalPubkey string          `json:"internalPubkey"`
+				ScriptTree     json.RawMessage `json:"scriptTree"`
+			} `json:"given"`
+			Intermediary struct {
+				LeafHashes    []string `json:"leafHashes"`
+				MerkleRoot    *string  `json:"merkleRoot"`
+				TweakedPubkey string   `json:"tweakedPubkey"`
+			} `json:"intermediary"`
+			Expected struct {
+				ScriptPubKey            string   `json:"scriptPubKey"`
+				ScriptPathControlBlocks []string `json:"scriptPathControlBlocks"`
+			} `json:"expected"`
+		} `json:"scriptPubKey"`
+		KeyPathSpending []struct {
+			Given struct {
+				RawUnsignedTx string `json:"rawUnsignedTx"`
+				UtxosSpent    []struct {
+					ScriptPubKey string `json:"scriptPubKey"`
+					AmountSats   int64  `json:"amountSats"`
+				} `json:"utxosSpent"`
+			} `json:"given"`
+			Intermediary struct {
+				HashAmounts       string `json:"hashAmounts"`
+				HashOutputs       string `json:"hashOutputs"`
+				HashPrevouts      string `json:"hashPrevouts"`
+				HashScriptPubkeys string `json:"hashScriptPubkeys"`
+				HashSequences     string `json:"hashSequences"`
+			} `json:"intermediary"`
+			InputSpending []struct {
+				Given struct {
+					TxinIndex       int     `json:"txinIndex"`
+					InternalPrivkey string  `json:"internalPrivkey"`
+					MerkleRoot      *string `json:"merkleRoot"`
+					HashType        uint8   `json:"hashType"`
+				} `json:"given"`
+				Intermediary struct {
+					SigHash string `json:"sigHash"`
+				} `json:"intermediary"`
+				Expected struct {
+					Witness []string `json:"witness"`
+				} `json:"expected"`
+			} `json:"inputSpending"`
+		} `json:"keyPathSpending"`
+	}
+	if err := json.Unmarshal(file, &vectors); err != nil {
+		t.Fatalf("TestTaprootWalletVectors couldn't Unmarshal: %v", err)
+	}
+
+	for i, test := range vectors.ScriptPubKey {
+		internalKey, err := schnorr.ParsePubKey(hexToBytes(
+			test.Given.InternalPubkey))
+		if err != nil {
+			t.Errorf("scriptPubKey #%d: bad internal key: %v", i, err)
+			continue
+		}
+
+		// Compute the root of the script tree, if any, along with the
+		// control block of each of its leaves.
+		var scriptRoot []byte
+		var leaves []TapLeaf
+		var leafHashes []string
+		var ctrlBlocks []*ControlBlock
+		if test.Given.ScriptTree != nil &&
+			string(test.Given.ScriptTree) != "null" {
+
+			tree, err := parseTaprootVectorTree(test.Given.ScriptTree)
+			if err != nil {
+				t.Errorf("scriptPubKey #%d: bad script tree: %v",
+					i, err)
+				continue
+			}
+			root := tree.walk(func(leaf TapLeaf, path []byte) {
+				leaves = append(leaves, leaf)
+				leafHash := leaf.TapHash()
+				leafHashes = append(leafHashes,
+					hex.EncodeToString(leafHash[:]))
+				ctrlBlocks = append(ctrlBlocks, &ControlBlock{
+					InternalKey:    internalKey,
+					LeafVersion:    leaf.LeafVersion,
+					InclusionProof: path,
+				})
+			})
+			scriptRoot = root[:]
+		}
+		if len(test.Intermediary.LeafHashes) != 0 &&
+			!reflect.DeepEqual(leafHashes, test.Intermediary.LeafHashes) {
+
+			t.Errorf("scriptPubKey #%d: got leaf hashes %v, want %v",
+				i, leafHashes, test.Intermediary.LeafHashes)
+		}
+		if test.Intermediary.MerkleRoot != nil &&
+			hex.EncodeToString(scriptRoot) != *test.Intermediary.MerkleRoot {
+
+			t.Errorf("scriptPubKey #%d: got merkle root %x, want %s",
+				i, scriptRoot, *test.Intermediary.MerkleRoot)
+		}
+
+		outputKey, err := ComputeTaprootOutputKey(internalKey, scriptRoot)
+		if err != nil {
+			t.Errorf("scriptPubKey #%d: unable to compute output "+
+				"key: %v", i, err)
+			continue
+		}
+		tweakedKey := schnorr.SerializePubKey(outputKey)
+		if hex.EncodeToString(tweakedKey) != test.Intermediary.TweakedPubkey {
+			t.Errorf("scriptPubKey #%d: got tweaked key %x, want %s",
+				i, tweakedKey, test.Intermediary.TweakedPubkey)
+		}
+		pkScript, err := PayToTaprootScript(outputKey)
+		if err != nil {
+			t.Errorf("scriptPubKey #%d: unable to create script: %v",
+				i, err)
+			continue
+		}
+		if hex.EncodeToString(pkScript) != test.Expected.ScriptPubKey {
+			t.Errorf("scriptPubKey #%d: got script %x, want %s", i,
+				pkScript, test.Expected.ScriptPubKey)
+		}
+
+		// The control blocks must serialize as expected and prove the
+		// inclusion of their leaf in the output key.
+		if len(ctrlBlocks) != len(test.Expected.ScriptPathControlBlocks) {
+			t.Errorf("scriptPubKey #%d: got %d control blocks, want "+
+				"%d", i, len(ctrlBlocks),
+				len(test.Expected.ScriptPathControlBlocks))
+			continue
+		}
+		for j, ctrlBlock := range ctrlBlocks {
+			ctrlBlock.OutputKeyYIsOdd = outputKey.Y.Bit(0) == 1
+			want := test.Expected.ScriptPathControlBlocks[j]
+			if got := hex.EncodeToString(ctrlBlock.ToBytes()); got != want {
+				t.Errorf("scriptPubKey #%d: got control block #%d "+
+					"%s, want %s", i, j, got, want)
+				continue
+			}
+			parsed, err := ParseControlBlock(hexToBytes(want))
+			if err != nil {
+				t.Errorf("scriptPubKey #%d: unable to parse control "+
+					"block #%d: %v", i, j, err)
+				continue
+			}
+			err = VerifyTaprootLeafCommitment(parsed, tweakedKey,
+				leaves[j].Script)
+			if err != nil {
+				t.Errorf("scriptPubKey #%d: control block #%d "+
+					"rejected: %v", i, j, err)
+			}
+		}
+	}
+
+	for i, test := range vectors.KeyPathSpending {
+		var tx wire.MsgTx
+		rawTx := hexToBytes(test.Given.RawUnsignedTx)
+		if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+			t.Errorf("keyPathSpending #%d: bad transaction: %v", i, err)
+			continue
+		}
+		if len(test.Given.UtxosSpent) != len(tx.TxIn) {
+			t.Errorf("keyPathSpending #%d: got %d spent outputs for "+
+				"%d inputs", i, len(test.Given.UtxosSpent),
+				len(tx.TxIn))
+			continue
+		}
+		prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+		for j, utxo := range test.Given.UtxosSpent {
+			prevOuts[tx.TxIn[j].PreviousOutPoint] = wire.NewTxOut(
+				utxo.AmountSats, hexToBytes(utxo.ScriptPubKey))
+		}
+		prevOutFetcher := NewMultiPrevOutFetcher(prevOuts)
+		sigHashes := NewTxSigHashes(&tx, prevOutFetcher)
+
+		midstates := []struct {
+			name string
+			got  chainhash.Hash
+			want string
+		}{
+			{"amounts", sigHashes.HashInputAmountsV1, test.Intermediary.HashAmounts},
+			{"outputs", sigHashes.HashOutputsV1, test.Intermediary.HashOutputs},
+			{"prevouts", sigHashes.HashPrevOutsV1, test.Intermediary.HashPrevouts},
+			{"scripts", sigHashes.HashInputScriptsV1, test.Intermediary.HashScriptPubkeys},
+			{"sequences", sigHashes.HashSequenceV1, test.Intermediary.HashSequences},
+		}
+		for _, midstate := range midstates {
+			if hex.EncodeToString(midstate.got[:]) != midstate.want {
+				t.Errorf("keyPathSpending #%d: got hash of %s %x, "+
+					"want %s", i, midstate.name, midstate.got[:],
+					midstate.want)
+			}
+		}
+
+		for _, input := range test.InputSpending {
+			idx := input.Given.TxinIndex
+			hashType := SigHashType(input.Given.HashType)
+			sigHash, err := CalcTaprootSignatureHash(sigHashes,
+				hashType, &tx, idx, prevOutFetcher)
+			if err != nil {
+				t.Errorf("keyPathSpending #%d input %d: unable to "+
+					"compute sighash: %v", i, idx, err)
+				continue
+			}
+			if hex.EncodeToString(sigHash) != input.Intermediary.SigHash {
+				t.Errorf("keyPathSpending #%d input %d: got sighash "+
+					"%x, want %s", i, idx, sigHash,
+					input.Intermediary.SigHash)
+			}
+
+			// The signatures of the vectors are created with all
+			// zero auxiliary randomness.
+			var scriptRoot []byte
+			if input.Given.MerkleRoot != nil {
+				scriptRoot = hexToBytes(*input.Given.MerkleRoot)
+			}
+			privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(),
+				hexToBytes(input.Given.InternalPrivkey))
+			tweakedKey, err := TweakTaprootPrivKey(privKey, scriptRoot)
+			if err != nil {
+				t.Errorf("keyPathSpending #%d input %d: unable to "+
+					"tweak key: %v", i, idx, err)
+				continue
+			}
+			sig, err := schnorr.Sign(tweakedKey, sigHash,
+				schnorr.CustomNonce([32]byte{}))
+			if err != nil {
+				t.Errorf("keyPathSpending #%d input %d: unable to "+
+					"sign: %v", i, idx, err)
+				continue
+			}
+			witnessSig := sig.Serialize()
+			if hashType != SigHashDefault {
+				witnessSig = append(witnessSig, byte(hashType))
+			}
+			want := input.Expected.Witness
+			if len(want) != 1 || hex.EncodeToString(witnessSig) != want[0] {
+				t.Errorf("keyPathSpending #%d input %d: got witness "+
+					"%x, want %v", i, idx, witnessSig, want)
+			}
+			tx.TxIn[idx].Witness = wire.TxWitness{witnessSig}
+		}
+
+		// Finally, the signed inputs must pass the script engine.
+		for _, input := range test.InputSpending {
+			idx := input.Given.TxinIndex
+			prevOut := prevOuts[tx.TxIn[idx].PreviousOutPoint]
+			vm, err := NewEngine(prevOut.PkScript, &tx, idx,
+				StandardVerifyFlags, nil, sigHashes, prevOut.Value,
+				prevOutFetcher)
+			if err == nil {
+				err = vm.Execute()
+			}
+			if err != nil {
+				t.Errorf("keyPathSpending #%d input %d: signed input "+
+					"rejected: %v", i, idx, err)
+			}
+		}
+	}
+}
+
+// scriptAssetsFlags are the consensus flag combinations the script assets tests
+// are run with, which are all combinations where taproot implies witness and
+// witness implies pay-to-script-hash.
+func scriptAssetsFlags() []ScriptFlags {
+	consensusFlags := []ScriptFlags{
+		ScriptBip16, ScriptVerifyDERSignatures, ScriptStrictMultiSig,
+		ScriptVerifyCheckLockTimeVerify, ScriptVerifyCheckSequenceVerify,
+		ScriptVerifyWitness, ScriptVerifyTaproot,
+	}
+
+	var allFlags []ScriptFlags
+	for i := 0; i < 1<<len(consensusFlags); i++ {
+		var flags ScriptFlags
+		for j, flag := range consensusFlags {
+			if i&(1<<j) != 0 {
+				flags |= flag
+			}
+		}
+		if flags&ScriptVerifyWitness != 0 && flags&ScriptBip16 == 0 {
+			continue
+		}
+		if flags&ScriptVerifyTaproot != 0 && flags&ScriptVerifyWitness == 0 {
+			continue
+		}
+		allFlags = append(allFlags, flags)
+	}
+	return allFlags
+}
+
+// parseScriptAssetsTxOut parses a serialized transaction output, which is its
+// amount followed by its public key script with a var int length prefix.
+func parseScriptAssetsTxOut(b []byte) (*wire.TxOut, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("transaction output of %d bytes", len(b))
+	}
+	amount := int64(binary.LittleEndian.Uint64(b[:8]))
+	pkScript, err := wire.ReadVarBytes(bytes.NewReader(b[8:]), 0,
+		wire.MaxMessagePayload, "pkScript")
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewTxOut(amount, pkScript), nil
+}
+
+// TestScriptAssets ensures the tests in script_assets_test.json, which are in
+// the format Bitcoin Core generates from its taproot functional tests, pass and
+// fail as expected.  The file Bitcoin Core generates is tens of megabytes large,
+// so the data directory holds a trimmed set covering the key path hash types,
+// the annex, control blocks, CHECKSIGADD, the signature operations budget,
+// OP_SUCCESSx and unknown leaf versions and public key types.  The full file
+// may be put in its place to run all of them.
+func TestScriptAssets(t *testing.T) {
+	file, err := ioutil.ReadFile("data/script_assets_test.json")
+	if err != nil {
+		t.Fatalf("TestScriptAssets: %v\n", err)
+	}
+
+	type scriptAssetsSpend struct {
+		ScriptSig string   `json:"scriptSig"`
+		Witness   []string `json:"witness"`
+	}
+	var tests []struct {
+		Tx       string             `json:"tx"`
+		Prevouts []string           `json:"prevouts"`
+		Index    int                `json:"index"`
+		Flags    string             `json:"flags"`
+		Comment  string             `json:"comment"`
+		Success  *scriptAssetsSpend `json:"success"`
+		Failure  *scriptAssetsSpend `json:"failure"`
+		Final    bool               `json:"final"`
+	}
+	if err := json.Unmarshal(file, &tests); err != nil {
+		t.Fatalf("TestScriptAssets couldn't Unmarshal: %v", err)
+	}
+
+	allFlags := scriptAssetsFlags()
+	for i, test := range tests {
+		var tx wire.MsgTx
+		err := tx.Deserialize(bytes.NewReader(hexToBytes(test.Tx)))
+		if err != nil {
+			t.Errorf("test #%d (%s): bad transaction: %v", i,
+				test.Comment, err)
+			continue
+		}
+		if len(test.Prevouts) != len(tx.TxIn) || test.Index >= len(tx.TxIn) {
+			t.Errorf("test #%d (%s): bad prevouts or index", i,
+				test.Comment)
+			continue
+		}
+		prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+		for j, rawPrevOut := range test.Prevouts {
+			prevOut, err := parseScriptAssetsTxOut(hexToBytes(rawPrevOut))
+			if err != nil {
+				t.Errorf("test #%d (%s): bad prevout: %v", i,
+					test.Comment, err)
+				continue
+			}
+			prevOuts[tx.TxIn[j].PreviousOutPoint] = prevOut
+		}
+		prevOutFetcher := NewMultiPrevOutFetcher(prevOuts)
+		prevOut := prevOuts[tx.TxIn[test.Index].PreviousOutPoint]
+		testFlags, err := parseScriptFlags(test.Flags)
+		if err != nil {
+			t.Errorf("test #%d (%s): %v", i, test.Comment, err)
+			continue
+		}
+
+		// verify executes the input with the passed spend and flags.
+		verify := func(spend *scriptAssetsSpend, flags ScriptFlags) error {
+			txIn := tx.TxIn[test.Index]
+			txIn.SignatureScript = hexToBytes(spend.ScriptSig)
+			txIn.Witness = nil
+			for _, item := range spend.Witness {
+				txIn.Witness = append(txIn.Witness, hexToBytes(item))
+			}
+			sigHashes := NewTxSigHashes(&tx, prevOutFetcher)
+			vm, err := NewEngine(prevOut.PkScript, &tx, test.Index,
+				flags, nil, sigHashes, prevOut.Value, prevOutFetcher)
+			if err != nil {
+				return err
+			}
+			return vm.Execute()
+		}
+
+		// The success spend must pass with every subset of the flags of
+		// the test, or all flags for final tests, while the failure
+		// spend must fail with every superset of them.
+		for _, flags := range allFlags {
+			if test.Success != nil &&
+				(test.Final || flags&testFlags == flags) {
+
+				if err := verify(test.Success, flags); err != nil {
+					t.Errorf("test #%d (%s): success spend "+
+						"failed with flags %#x: %v", i,
+						test.Comment, flags, err)
+				}
+			}
+			if test.Failure != nil && flags&testFlags == testFlags {
+				if err := verify(test.Failure, flags); err == nil {
+					t.Errorf("test #%d (%s): failure spend "+
+						"passed with flags %#x", i,
+						test.Comment, flags)
+				}
+			}
+		}
+	}
+}
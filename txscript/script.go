@@ -26,6 +26,7 @@ type SigHashType uint32
 
 // Hash type bits from the end of a signature.
 const (
+	SigHashDefault      SigHashType = 0x0
 	SigHashOld          SigHashType = 0x0
 	SigHashAll          SigHashType = 0x1
 	SigHashNone         SigHashType = 0x2
@@ -90,6 +91,12 @@ func IsPayToWitnessPubKeyHash(script []byte) bool {
 	return isWitnessPubKeyHashScript(script)
 }
 
+// IsPayToTaproot returns true if the passed script is in the standard
+// pay-to-taproot (P2TR) format, false otherwise.
+func IsPayToTaproot(script []byte) bool {
+	return isPayToTaprootScript(script)
+}
+
 // IsWitnessProgram returns true if the passed script is a valid witness
 // program which is encoded according to the passed witness program version. A
 // witness program must be a small integer (from 0-16), followed by 2-40 bytes
@@ -292,13 +299,16 @@ func removeOpcodeByData(script []byte, dataToRemove []byte) []byte {
 	return result
 }
 
-// calcHashPrevOuts calculates a single hash of all the previous outputs
+// calcHashPrevOutsV1 calculates a single hash of all the previous outputs
 // (txid:index) referenced within the passed transaction. This calculated hash
 // can be re-used when validating all inputs spending segwit outputs, with a
 // signature hash type of SigHashAll. This allows validation to re-use previous
 // hashing computation, reducing the complexity of validating SigHashAll inputs
 // from  O(N^2) to O(N).
-func calcHashPrevOuts(tx *wire.MsgTx) chainhash.Hash {
+//
+// The returned hash is the single SHA256 midstate used by BIP0341.  The BIP0143
+// midstate is the SHA256 of it.
+func calcHashPrevOutsV1(tx *wire.MsgTx) chainhash.Hash {
 	var b bytes.Buffer
 	for _, in := range tx.TxIn {
 		// First write out the 32-byte transaction ID one of whose
@@ -312,16 +322,19 @@ func calcHashPrevOuts(tx *wire.MsgTx) chainhash.Hash {
 		b.Write(buf[:])
 	}
 
-	return chainhash.DoubleHashH(b.Bytes())
+	return chainhash.HashH(b.Bytes())
 }
 
-// calcHashSequence computes an aggregated hash of each of the sequence numbers
-// within the inputs of the passed transaction. This single hash can be re-used
-// when validating all inputs spending segwit outputs, which include signatures
-// using the SigHashAll sighash type. This allows validation to re-use previous
-// hashing computation, reducing the complexity of validating SigHashAll inputs
-// from O(N^2) to O(N).
-func calcHashSequence(tx *wire.MsgTx) chainhash.Hash {
+// calcHashSequenceV1 computes an aggregated hash of each of the sequence
+// numbers within the inputs of the passed transaction. This single hash can be
+// re-used when validating all inputs spending segwit outputs, which include
+// signatures using the SigHashAll sighash type. This allows validation to
+// re-use previous hashing computation, reducing the complexity of validating
+// SigHashAll inputs from O(N^2) to O(N).
+//
+// The returned hash is the single SHA256 midstate used by BIP0341.  The BIP0143
+// midstate is the SHA256 of it.
+func calcHashSequenceV1(tx *wire.MsgTx) chainhash.Hash {
 	var b bytes.Buffer
 	for _, in := range tx.TxIn {
 		var buf [4]byte
@@ -329,21 +342,63 @@ func calcHashSequence(tx *wire.MsgTx) chainhash.Hash {
 		b.Write(buf[:])
 	}
 
-	return chainhash.DoubleHashH(b.Bytes())
+	return chainhash.HashH(b.Bytes())
 }
 
-// calcHashOutputs computes a hash digest of all outputs created by the
+// calcHashOutputsV1 computes a hash digest of all outputs created by the
 // transaction encoded using the wire format. This single hash can be re-used
 // when validating all inputs spending witness programs, which include
 // signatures using the SigHashAll sighash type. This allows computation to be
 // cached, reducing the total hashing complexity from O(N^2) to O(N).
-func calcHashOutputs(tx *wire.MsgTx) chainhash.Hash {
+//
+// The returned hash is the single SHA256 midstate used by BIP0341.  The BIP0143
+// midstate is the SHA256 of it.
+func calcHashOutputsV1(tx *wire.MsgTx) chainhash.Hash {
 	var b bytes.Buffer
 	for _, out := range tx.TxOut {
 		wire.WriteTxOut(&b, 0, 0, out)
 	}
 
-	return chainhash.DoubleHashH(b.Bytes())
+	return chainhash.HashH(b.Bytes())
+}
+
+// calcHashInputAmountsV1 computes the BIP0341 hash of the amounts of all of
+// the outputs spent by the passed transaction.  Unknown outputs are treated as
+// having an amount of zero, which results in an invalid signature hash rather
+// than an error.
+func calcHashInputAmountsV1(tx *wire.MsgTx, prevOutFetcher PrevOutputFetcher) chainhash.Hash {
+	var b bytes.Buffer
+	for _, in := range tx.TxIn {
+		var amt int64
+		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+		if prevOut != nil {
+			amt = prevOut.Value
+		}
+
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(amt))
+		b.Write(buf[:])
+	}
+
+	return chainhash.HashH(b.Bytes())
+}
+
+// calcHashInputScriptsV1 computes the BIP0341 hash of the public key scripts
+// of all of the outputs spent by the passed transaction, each serialized with
+// a var int length prefix.
+func calcHashInputScriptsV1(tx *wire.MsgTx, prevOutFetcher PrevOutputFetcher) chainhash.Hash {
+	var b bytes.Buffer
+	for _, in := range tx.TxIn {
+		var pkScript []byte
+		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+		if prevOut != nil {
+			pkScript = prevOut.PkScript
+		}
+
+		wire.WriteVarBytes(&b, 0, pkScript)
+	}
+
+	return chainhash.HashH(b.Bytes())
 }
 
 // calcWitnessSignatureHash computes the sighash digest of a transaction's
@@ -474,6 +529,204 @@ func CalcWitnessSigHash(script []byte, sigHashes *TxSigHashes, hType SigHashType
 	return calcWitnessSignatureHashRaw(script, sigHashes, hType, tx, idx, amt)
 }
 
+// taprootSigHashOptions houses the data committed to by a taproot signature
+// hash which depends on how the input is spent rather than the transaction.
+type taprootSigHashOptions struct {
+	// annex is the annex of the witness, if any, including its tag.
+	annex []byte
+
+	// tapLeafHash is the leaf hash of the executed tapscript.  It is nil
+	// for key path spends.
+	tapLeafHash []byte
+
+	// codeSepPos is the opcode position of the last executed
+	// OP_CODESEPARATOR of the tapscript.
+	codeSepPos uint32
+}
+
+// isValidTaprootSigHash returns whether or not the passed hash type is one of
+// the hash types defined by BIP0341.
+func isValidTaprootSigHash(hashType SigHashType) bool {
+	switch hashType {
+	case SigHashDefault, SigHashAll, SigHashNone, SigHashSingle,
+		SigHashAll | SigHashAnyOneCanPay,
+		SigHashNone | SigHashAnyOneCanPay,
+		SigHashSingle | SigHashAnyOneCanPay:
+
+		return true
+	}
+	return false
+}
+
+// calcTaprootSignatureHashRaw computes the sighash digest of a transaction's
+// taproot input as defined in BIP0341:
+// https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki.
+// Like BIP0143 signature hashes, it makes use of the pre-calculated sighash
+// fragments stored within the passed TxSigHashes.  Unlike them, signatures
+// commit to the amounts and public key scripts of all spent outputs, which are
+// supplied by the passed fetcher.
+func calcTaprootSignatureHashRaw(sigHashes *TxSigHashes, hType SigHashType,
+	tx *wire.MsgTx, idx int, prevOutFetcher PrevOutputFetcher,
+	opts *taprootSigHashOptions) ([]byte, error) {
+
+	// As a sanity check, ensure the passed input index for the transaction
+	// is valid.
+	if idx > len(tx.TxIn)-1 {
+		return nil, fmt.Errorf("idx %d but %d txins", idx, len(tx.TxIn))
+	}
+	if !isValidTaprootSigHash(hType) {
+		return nil, fmt.Errorf("invalid taproot sighash type %#x", hType)
+	}
+	if prevOutFetcher == nil {
+		return nil, fmt.Errorf("taproot sighash requires the outputs " +
+			"spent by the transaction")
+	}
+
+	// SigHashSingle requires an output at the same index as the input.
+	outputType := hType & SigHashSingle
+	if outputType == SigHashSingle && idx >= len(tx.TxOut) {
+		return nil, fmt.Errorf("sighash single with idx %d but %d "+
+			"txouts", idx, len(tx.TxOut))
+	}
+
+	// The midstates are only calculated for transactions which spend a
+	// taproot output, so calculate them here when the passed ones lack
+	// them.
+	var zeroHash chainhash.Hash
+	if sigHashes == nil || sigHashes.HashInputScriptsV1 == zeroHash {
+		sigHashes = NewTxSigHashes(tx, prevOutFetcher)
+	}
+
+	// The signature message starts with the epoch, followed by the hash
+	// type, the transaction's version and the transaction's locktime.
+	var sigMsg bytes.Buffer
+	sigMsg.WriteByte(0x00)
+	sigMsg.WriteByte(byte(hType))
+	var bVersion [4]byte
+	binary.LittleEndian.PutUint32(bVersion[:], uint32(tx.Version))
+	sigMsg.Write(bVersion[:])
+	var bLockTime [4]byte
+	binary.LittleEndian.PutUint32(bLockTime[:], tx.LockTime)
+	sigMsg.Write(bLockTime[:])
+
+	// Unless anyone can pay is active, commit to all of the inputs of the
+	// transaction along with the outputs they spend.
+	anyoneCanPay := hType&SigHashAnyOneCanPay != 0
+	if !anyoneCanPay {
+		sigMsg.Write(sigHashes.HashPrevOutsV1[:])
+		sigMsg.Write(sigHashes.HashInputAmountsV1[:])
+		sigMsg.Write(sigHashes.HashInputScriptsV1[:])
+		sigMsg.Write(sigHashes.HashSequenceV1[:])
+	}
+
+	// Unless the sighash is single or none, commit to all of the outputs
+	// of the transaction.
+	if outputType != SigHashSingle && outputType != SigHashNone {
+		sigMsg.Write(sigHashes.HashOutputsV1[:])
+	}
+
+	// Next, write out the spend type, which encodes whether the script
+	// path is being spent and whether an annex is present.
+	var spendType byte
+	if opts.tapLeafHash != nil {
+		spendType |= 2
+	}
+	if opts.annex != nil {
+		spendType |= 1
+	}
+	sigMsg.WriteByte(spendType)
+
+	// Commit to the input being signed, which is either the full input
+	// along with the output it spends when anyone can pay is active, or
+	// its index otherwise.
+	txIn := tx.TxIn[idx]
+	if anyoneCanPay {
+		prevOut := prevOutFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		if prevOut == nil {
+			return nil, fmt.Errorf("unable to fetch output %v spent "+
+				"by input %d", txIn.PreviousOutPoint, idx)
+		}
+
+		sigMsg.Write(txIn.PreviousOutPoint.Hash[:])
+		var bIndex [4]byte
+		binary.LittleEndian.PutUint32(bIndex[:], txIn.PreviousOutPoint.Index)
+		sigMsg.Write(bIndex[:])
+		var bAmount [8]byte
+		binary.LittleEndian.PutUint64(bAmount[:], uint64(prevOut.Value))
+		sigMsg.Write(bAmount[:])
+		wire.WriteVarBytes(&sigMsg, 0, prevOut.PkScript)
+		var bSequence [4]byte
+		binary.LittleEndian.PutUint32(bSequence[:], txIn.Sequence)
+		sigMsg.Write(bSequence[:])
+	} else {
+		var bIndex [4]byte
+		binary.LittleEndian.PutUint32(bIndex[:], uint32(idx))
+		sigMsg.Write(bIndex[:])
+	}
+
+	// Commit to the annex, if any, which is hashed along with its var int
+	// length prefix.
+	if opts.annex != nil {
+		var b bytes.Buffer
+		wire.WriteVarBytes(&b, 0, opts.annex)
+		sigMsg.Write(chainhash.HashB(b.Bytes()))
+	}
+
+	// Commit to the output at the same index as the input when the sighash
+	// is single.
+	if outputType == SigHashSingle {
+		var b bytes.Buffer
+		wire.WriteTxOut(&b, 0, 0, tx.TxOut[idx])
+		sigMsg.Write(chainhash.HashB(b.Bytes()))
+	}
+
+	// Finally, script path spends commit to the executed leaf, the key
+	// version and the position of the last executed OP_CODESEPARATOR.
+	if opts.tapLeafHash != nil {
+		sigMsg.Write(opts.tapLeafHash)
+		sigMsg.WriteByte(0x00)
+		var bCodeSepPos [4]byte
+		binary.LittleEndian.PutUint32(bCodeSepPos[:], opts.codeSepPos)
+		sigMsg.Write(bCodeSepPos[:])
+	}
+
+	sigHash := chainhash.TaggedHash(tapSighashTag, sigMsg.Bytes())
+	return sigHash[:], nil
+}
+
+// tapSighashTag is the BIP0341 tag of the signature hash of taproot inputs.
+var tapSighashTag = []byte("TapSighash")
+
+// CalcTaprootSignatureHash computes the sighash digest of a taproot key path
+// spend of the specified input of the target transaction observing the desired
+// sig hash type.  The passed fetcher must supply the outputs spent by all of
+// the inputs of the transaction.
+func CalcTaprootSignatureHash(sigHashes *TxSigHashes, hType SigHashType,
+	tx *wire.MsgTx, idx int, prevOutFetcher PrevOutputFetcher) ([]byte, error) {
+
+	opts := &taprootSigHashOptions{}
+	return calcTaprootSignatureHashRaw(sigHashes, hType, tx, idx,
+		prevOutFetcher, opts)
+}
+
+// CalcTapscriptSignatureHash computes the sighash digest of a taproot script
+// path spend of the specified input of the target transaction executing the
+// passed leaf, observing the desired sig hash type.  The digest commits to no
+// OP_CODESEPARATOR having been executed.  The passed fetcher must supply the
+// outputs spent by all of the inputs of the transaction.
+func CalcTapscriptSignatureHash(sigHashes *TxSigHashes, hType SigHashType,
+	tx *wire.MsgTx, idx int, prevOutFetcher PrevOutputFetcher,
+	tapLeaf TapLeaf) ([]byte, error) {
+
+	tapLeafHash := tapLeaf.TapHash()
+	opts := &taprootSigHashOptions{
+		tapLeafHash: tapLeafHash[:],
+		codeSepPos:  blankCodeSepValue,
+	}
+	return calcTaprootSignatureHashRaw(sigHashes, hType, tx, idx,
+		prevOutFetcher, opts)
+}
+
 // shallowCopyTx creates a shallow copy of the transaction for use when
 // calculating the signature hash.  It is used over the Copy method on the
 // transaction itself since that is a deep copy and therefore does more work and
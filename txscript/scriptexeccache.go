@@ -0,0 +1,78 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ScriptExecCache implements a cache of the transactions whose scripts have
+// all been executed successfully, keyed by their witness hash, with a
+// randomized entry eviction policy.  It allows the scripts of a transaction
+// already validated on its acceptance to the mempool to be skipped when the
+// block including it is validated.
+//
+// Each entry records the script flags the transaction was validated with.
+// Since every script flag only adds restrictions to script execution, a
+// transaction validated with a set of flags is also valid under any subset of
+// them.
+type ScriptExecCache struct {
+	sync.RWMutex
+	validTxs   map[chainhash.Hash]ScriptFlags
+	maxEntries uint
+}
+
+// NewScriptExecCache returns a new instance of ScriptExecCache holding up to
+// 'maxEntries' entries.  Random entries are evicted to make room for new
+// entries that would cause the number of entries in the cache to exceed the
+// max.
+func NewScriptExecCache(maxEntries uint) *ScriptExecCache {
+	return &ScriptExecCache{
+		validTxs:   make(map[chainhash.Hash]ScriptFlags, maxEntries),
+		maxEntries: maxEntries,
+	}
+}
+
+// Exists returns true if the scripts of the transaction with witness hash
+// 'wtxid' were found to be valid with all of the passed flags.
+//
+// NOTE: This function is safe for concurrent access.
+func (c *ScriptExecCache) Exists(wtxid chainhash.Hash, flags ScriptFlags) bool {
+	c.RLock()
+	validFlags, ok := c.validTxs[wtxid]
+	c.RUnlock()
+
+	return ok && validFlags&flags == flags
+}
+
+// Add records the scripts of the transaction with witness hash 'wtxid' as
+// valid with the passed flags.  In the event that the cache is 'full', an
+// existing entry is randomly chosen to be evicted in order to make space for
+// the new entry.
+//
+// NOTE: This function is safe for concurrent access.
+func (c *ScriptExecCache) Add(wtxid chainhash.Hash, flags ScriptFlags) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.maxEntries == 0 {
+		return
+	}
+
+	// Replace any existing entry for the transaction.  Otherwise, if
+	// adding this new entry will put us over the max number of allowed
+	// entries, then evict an entry, relying on the random starting point
+	// of Go's map iteration as the SigCache does.
+	_, ok := c.validTxs[wtxid]
+	if !ok && uint(len(c.validTxs)+1) > c.maxEntries {
+		for wtxid := range c.validTxs {
+			delete(c.validTxs, wtxid)
+			break
+		}
+	}
+	c.validTxs[wtxid] = flags
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// TestScriptExecCache ensures transactions added to the script execution cache
+// are only found for subsets of the flags they were added with, and that the
+// cache does not grow beyond its size.
+func TestScriptExecCache(t *testing.T) {
+	const maxEntries = 5
+	cache := NewScriptExecCache(maxEntries)
+
+	wtxid := chainhash.Hash{0x01}
+	flags := ScriptBip16 | ScriptVerifyWitness | ScriptVerifyTaproot
+	if cache.Exists(wtxid, flags) {
+		t.Fatal("transaction found in an empty cache")
+	}
+	cache.Add(wtxid, flags)
+
+	tests := []struct {
+		flags ScriptFlags
+		want  bool
+	}{
+		{flags, true},
+		{ScriptBip16 | ScriptVerifyWitness, true},
+		{0, true},
+		{flags | ScriptVerifyCleanStack, false},
+		{ScriptVerifyCleanStack, false},
+	}
+	for i, test := range tests {
+		if got := cache.Exists(wtxid, test.flags); got != test.want {
+			t.Errorf("test %d: got %v, want %v", i, got, test.want)
+		}
+	}
+	if cache.Exists(chainhash.Hash{0x02}, 0) {
+		t.Fatal("unknown transaction found in the cache")
+	}
+
+	for i := 0; i < maxEntries*2; i++ {
+		cache.Add(chainhash.Hash{0x10, byte(i)}, flags)
+	}
+	if len(cache.validTxs) != maxEntries {
+		t.Fatalf("cache has %d entries, want %d", len(cache.validTxs),
+			maxEntries)
+	}
+
+	// A cache with no entries never records any transaction.
+	empty := NewScriptExecCache(0)
+	empty.Add(wtxid, flags)
+	if empty.Exists(wtxid, flags) {
+		t.Fatal("transaction found in a cache of size 0")
+	}
+}
@@ -6,11 +6,21 @@ package txscript
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"sync"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 )
 
+// sigCacheSnapshotVersion is the version of the serialized snapshot written by
+// SigCache.Save.
+const sigCacheSnapshotVersion = 1
+
 // sigCacheEntry represents an entry in the SigCache. Entries within the
 // SigCache are keyed according to the sigHash of the signature. In the
 // scenario of a cache-hit (according to the sigHash), an additional comparison
@@ -31,10 +41,21 @@ type sigCacheEntry struct {
 // Secondly, usage of the SigCache introduces a signature verification
 // optimization which speeds up the validation of transactions within a block,
 // if they've already been seen and verified within the mempool.
+//
+// The contents of a SigCache may be persisted across restarts with Save and
+// Load. Since the signatures and public keys are not needed to look up an
+// entry, a snapshot only holds a salted hash committing to each of them, and
+// the entries restored from it are kept apart from the ones added since.
 type SigCache struct {
 	sync.RWMutex
 	validSigs  map[chainhash.Hash]sigCacheEntry
 	maxEntries uint
+
+	// salt is mixed into the commitments written by Save so that the
+	// contents of a snapshot can't be predicted by a third party, and
+	// restoredSigs holds the commitments read by Load.
+	salt         [32]byte
+	restoredSigs map[chainhash.Hash]struct{}
 }
 
 // NewSigCache creates and initializes a new instance of SigCache. Its sole
@@ -43,10 +64,33 @@ type SigCache struct {
 // to make room for new entries that would cause the number of entries in the
 // cache to exceed the max.
 func NewSigCache(maxEntries uint) *SigCache {
-	return &SigCache{
+	s := &SigCache{
 		validSigs:  make(map[chainhash.Hash]sigCacheEntry, maxEntries),
 		maxEntries: maxEntries,
 	}
+
+	// A failure to read random bytes leaves the salt zeroed, which only
+	// makes the commitments written by Save predictable.
+	_, _ = rand.Read(s.salt[:])
+	return s
+}
+
+// commitment returns the salted hash committing to an entry of 'sig' over
+// 'sigHash' for public key 'pubKey', which is how entries are identified in a
+// snapshot.
+//
+// This function MUST be called with the cache lock held (for reads).
+func (s *SigCache) commitment(sigHash chainhash.Hash, sig, pubKey []byte) chainhash.Hash {
+	h := sha256.New()
+	h.Write(s.salt[:])
+	h.Write(sigHash[:])
+	h.Write([]byte{byte(len(sig))})
+	h.Write(sig)
+	h.Write(pubKey)
+
+	var commitment chainhash.Hash
+	copy(commitment[:], h.Sum(nil))
+	return commitment
 }
 
 // Exists returns true if an existing entry of 'sig' over 'sigHash' for public
@@ -56,12 +100,19 @@ func NewSigCache(maxEntries uint) *SigCache {
 // unless there exists a writer, adding an entry to the SigCache.
 func (s *SigCache) Exists(sigHash chainhash.Hash, sig, pubKey []byte) bool {
 	s.RLock()
+	defer s.RUnlock()
+
 	entry, ok := s.validSigs[sigHash]
-	s.RUnlock()
+	if ok && bytes.Equal(entry.pubKey, pubKey) && bytes.Equal(entry.sig, sig) {
+		return true
+	}
 
-	//return ok && entry.pubKey.IsEqual(pubKey) && entry.sig.IsEqual(sig)
-	return ok && bytes.Equal(entry.pubKey, pubKey) &&
-		bytes.Equal(entry.sig, sig)
+	// Fall back to the entries restored from a snapshot.
+	if len(s.restoredSigs) == 0 {
+		return false
+	}
+	_, ok = s.restoredSigs[s.commitment(sigHash, sig, pubKey)]
+	return ok
 }
 
 // Add adds an entry for a signature over 'sigHash' under public key 'pubKey'
@@ -80,20 +131,29 @@ func (s *SigCache) Add(sigHash chainhash.Hash, sig, pubKey []byte) {
 	}
 
 	// If adding this new entry will put us over the max number of allowed
-	// entries, then evict an entry.
-	if uint(len(s.validSigs)+1) > s.maxEntries {
-		// Remove a random entry from the map. Relying on the random
-		// starting point of Go's map iteration. It's worth noting that
-		// the random iteration starting point is not 100% guaranteed
-		// by the spec, however most Go compilers support it.
-		// Ultimately, the iteration order isn't important here because
-		// in order to manipulate which items are evicted, an adversary
-		// would need to be able to execute preimage attacks on the
-		// hashing function in order to start eviction at a specific
-		// entry.
-		for sigEntry := range s.validSigs {
-			delete(s.validSigs, sigEntry)
-			break
+	// entries, then evict an entry.  The entries restored from a snapshot
+	// are evicted first since they are the oldest.
+	if uint(len(s.validSigs)+len(s.restoredSigs)+1) > s.maxEntries {
+		if len(s.restoredSigs) > 0 {
+			for commitment := range s.restoredSigs {
+				delete(s.restoredSigs, commitment)
+				break
+			}
+		} else {
+			// Remove a random entry from the map. Relying on the
+			// random starting point of Go's map iteration. It's
+			// worth noting that the random iteration starting point
+			// is not 100% guaranteed by the spec, however most Go
+			// compilers support it.  Ultimately, the iteration order
+			// isn't important here because in order to manipulate
+			// which items are evicted, an adversary would need to
+			// be able to execute preimage attacks on the hashing
+			// function in order to start eviction at a specific
+			// entry.
+			for sigEntry := range s.validSigs {
+				delete(s.validSigs, sigEntry)
+				break
+			}
 		}
 	}
 	s.validSigs[sigHash] = sigCacheEntry{
@@ -101,3 +161,89 @@ func (s *SigCache) Add(sigHash chainhash.Hash, sig, pubKey []byte) {
 		pubKey: pubKey,
 	}
 }
+
+// Save writes a snapshot of the entries of the signature cache to 'w', which
+// can be restored with Load.  Only salted commitments to the entries are
+// written, so the snapshot does not reveal the cached signatures.
+//
+// NOTE: This function is safe for concurrent access.
+func (s *SigCache) Save(w io.Writer) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	var version [4]byte
+	binary.LittleEndian.PutUint32(version[:], sigCacheSnapshotVersion)
+	if _, err := w.Write(version[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(s.salt[:]); err != nil {
+		return err
+	}
+	count := uint64(len(s.validSigs) + len(s.restoredSigs))
+	if err := wire.WriteVarInt(w, 0, count); err != nil {
+		return err
+	}
+	for sigHash, entry := range s.validSigs {
+		commitment := s.commitment(sigHash, entry.sig, entry.pubKey)
+		if _, err := w.Write(commitment[:]); err != nil {
+			return err
+		}
+	}
+	for commitment := range s.restoredSigs {
+		if _, err := w.Write(commitment[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load restores the entries of a snapshot written by Save from 'r', replacing
+// the entries restored by any previous call.  No more entries than the
+// maximum size of the cache are restored.
+//
+// NOTE: This function is safe for concurrent access.
+func (s *SigCache) Load(r io.Reader) error {
+	var version [4]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return err
+	}
+	if v := binary.LittleEndian.Uint32(version[:]); v != sigCacheSnapshotVersion {
+		return fmt.Errorf("unsupported signature cache snapshot "+
+			"version %d", v)
+	}
+	var salt [32]byte
+	if _, err := io.ReadFull(r, salt[:]); err != nil {
+		return err
+	}
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+	if count > uint64(s.maxEntries) {
+		count = uint64(s.maxEntries)
+	}
+
+	restoredSigs := make(map[chainhash.Hash]struct{}, count)
+	for i := uint64(0); i < count; i++ {
+		var commitment chainhash.Hash
+		if _, err := io.ReadFull(r, commitment[:]); err != nil {
+			return err
+		}
+		restoredSigs[commitment] = struct{}{}
+	}
+
+	// The entries added since the cache was created are committed to with
+	// the salt of the snapshot from now on, so they are written along with
+	// the restored ones by the next call to Save.
+	s.Lock()
+	s.salt = salt
+	s.restoredSigs = restoredSigs
+	for len(s.validSigs)+len(s.restoredSigs) > int(s.maxEntries) {
+		for commitment := range s.restoredSigs {
+			delete(s.restoredSigs, commitment)
+			break
+		}
+	}
+	s.Unlock()
+	return nil
+}
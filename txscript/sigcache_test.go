@@ -5,6 +5,7 @@
 package txscript
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
 
@@ -138,3 +139,81 @@ func TestSigCacheAddMaxEntriesZeroOrNegative(t *testing.T) {
 			"been added", len(sigCache.validSigs))
 	}
 }
+
+// TestSigCacheSaveLoad tests that the entries of a signature cache saved to a
+// snapshot are found in another cache the snapshot is loaded into, and that no
+// more entries than the size of the cache are loaded.
+func TestSigCacheSaveLoad(t *testing.T) {
+	const numEntries = 10
+	sigCache := NewSigCache(numEntries)
+	type triplet struct {
+		msg         chainhash.Hash
+		sig, pubKey []byte
+	}
+	var entries []triplet
+	for i := 0; i < numEntries; i++ {
+		msg, sig, key, err := genRandomSig()
+		if err != nil {
+			t.Fatalf("unable to generate random signature test data")
+		}
+		entry := triplet{*msg, sig.Serialize(), key.SerializeCompressed()}
+		sigCache.Add(entry.msg, entry.sig, entry.pubKey)
+		entries = append(entries, entry)
+	}
+
+	var buf bytes.Buffer
+	if err := sigCache.Save(&buf); err != nil {
+		t.Fatalf("Save: unexpected error: %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	// All of the entries should be found after loading the snapshot, and
+	// saving the restored cache should produce the same snapshot entries.
+	restored := NewSigCache(numEntries)
+	if err := restored.Load(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	for i, entry := range entries {
+		if !restored.Exists(entry.msg, entry.sig, entry.pubKey) {
+			t.Fatalf("entry %d not found in the restored sigcache", i)
+		}
+	}
+	if restored.Exists(entries[0].msg, entries[1].sig, entries[0].pubKey) {
+		t.Fatal("mismatched signature found in the restored sigcache")
+	}
+
+	// Adding a new entry to the full cache should evict a restored one.
+	msg, sig, key, err := genRandomSig()
+	if err != nil {
+		t.Fatalf("unable to generate random signature test data")
+	}
+	restored.Add(*msg, sig.Serialize(), key.SerializeCompressed())
+	if len(restored.validSigs)+len(restored.restoredSigs) != numEntries {
+		t.Fatalf("restored sigcache has %d entries, want %d",
+			len(restored.validSigs)+len(restored.restoredSigs),
+			numEntries)
+	}
+	if !restored.Exists(*msg, sig.Serialize(), key.SerializeCompressed()) {
+		t.Fatal("new entry not found in the restored sigcache")
+	}
+
+	// A smaller cache should only load as many entries as it can hold.
+	small := NewSigCache(numEntries / 2)
+	if err := small.Load(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if len(small.restoredSigs) != numEntries/2 {
+		t.Fatalf("small sigcache has %d entries, want %d",
+			len(small.restoredSigs), numEntries/2)
+	}
+
+	// Snapshots with an unknown version or truncated entries are rejected.
+	badVersion := append([]byte{0xff}, snapshot[1:]...)
+	if err := NewSigCache(numEntries).Load(bytes.NewReader(badVersion)); err == nil {
+		t.Fatal("Load: expected an error for an unknown version")
+	}
+	truncated := snapshot[:len(snapshot)-1]
+	if err := NewSigCache(numEntries).Load(bytes.NewReader(truncated)); err == nil {
+		t.Fatal("Load: expected an error for a truncated snapshot")
+	}
+}
@@ -42,7 +42,11 @@ const (
 		ScriptVerifyWitness |
 		ScriptVerifyDiscourageUpgradeableWitnessProgram |
 		ScriptVerifyMinimalIf |
-		ScriptVerifyWitnessPubKeyType
+		ScriptVerifyWitnessPubKeyType |
+		ScriptVerifyTaproot |
+		ScriptVerifyDiscourageUpgradeableTaprootVersion |
+		ScriptVerifyDiscourageOpSuccess |
+		ScriptVerifyDiscourageUpgradeablePubkeyType
 )
 
 // ScriptClass is an enumeration for the list of standard types of script.
@@ -387,6 +391,27 @@ func isWitnessScriptHashScript(script []byte) bool {
 	return extractWitnessScriptHash(script) != nil
 }
 
+// extractWitnessV1KeyBytes extracts the x-only output key from the passed
+// script if it is a pay-to-taproot script.  It will return nil otherwise.
+func extractWitnessV1KeyBytes(script []byte) []byte {
+	// A pay-to-taproot script is of the form:
+	//   OP_1 OP_DATA_32 <32-byte-key>
+	if len(script) == 34 &&
+		script[0] == OP_1 &&
+		script[1] == OP_DATA_32 {
+
+		return script[2:34]
+	}
+
+	return nil
+}
+
+// isPayToTaprootScript returns whether or not the passed script is a
+// pay-to-taproot script.
+func isPayToTaprootScript(script []byte) bool {
+	return extractWitnessV1KeyBytes(script) != nil
+}
+
 // extractWitnessProgramInfo returns the version and program if the passed
 // script constitutes a valid witness program. The alst return value indicates
 // whether or not the script is a valid witness program.
@@ -0,0 +1,435 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TapscriptLeafVersion represents the leaf version of a taproot script leaf.
+type TapscriptLeafVersion uint8
+
+const (
+	// BaseLeafVersion is the leaf version of the tapscript leaves defined
+	// by BIP0342.
+	BaseLeafVersion TapscriptLeafVersion = 0xc0
+)
+
+const (
+	// TaprootAnnexTag is the first byte of the optional annex which may be
+	// the final element of a taproot witness.
+	TaprootAnnexTag = 0x50
+
+	// TaprootLeafMask is the mask applied to the first byte of a control
+	// block to obtain the leaf version.  The remaining bit is the parity of
+	// the y coordinate of the output key.
+	TaprootLeafMask = 0xfe
+
+	// ControlBlockBaseSize is the size of a control block without any merkle
+	// path nodes, which is the leaf version byte and the internal key.
+	ControlBlockBaseSize = 33
+
+	// ControlBlockNodeSize is the size of each node of the merkle path of a
+	// control block.
+	ControlBlockNodeSize = 32
+
+	// ControlBlockMaxNodeCount is the maximum depth of a taproot script
+	// tree, and therefore the maximum number of nodes in a merkle path.
+	ControlBlockMaxNodeCount = 128
+
+	// ControlBlockMaxSize is the maximum size of a control block.
+	ControlBlockMaxSize = ControlBlockBaseSize +
+		ControlBlockNodeSize*ControlBlockMaxNodeCount
+
+	// sigOpsDelta is the amount the signature operations budget of a
+	// tapscript is decremented by each signature check with a non-empty
+	// signature.
+	sigOpsDelta = 50
+)
+
+var (
+	// tapLeafTag, tapBranchTag and tapTweakTag are the BIP0341 tags of the
+	// hashes committing to a script leaf, an inner node of a script tree
+	// and the tweak of an output key respectively.
+	tapLeafTag   = []byte("TapLeaf")
+	tapBranchTag = []byte("TapBranch")
+	tapTweakTag  = []byte("TapTweak")
+)
+
+// TapLeaf is a leaf of a taproot script tree, which is a script along with its
+// leaf version.
+type TapLeaf struct {
+	// LeafVersion is the leaf version of the script.
+	LeafVersion TapscriptLeafVersion
+
+	// Script is the script of the leaf.
+	Script []byte
+}
+
+// NewBaseTapLeaf returns a new tapscript leaf for the passed script with the
+// base leaf version.
+func NewBaseTapLeaf(script []byte) TapLeaf {
+	return TapLeaf{
+		LeafVersion: BaseLeafVersion,
+		Script:      script,
+	}
+}
+
+// TapHash returns the hash of the leaf, which is the tagged hash of its leaf
+// version followed by its script serialized with a var int length prefix.
+func (t TapLeaf) TapHash() chainhash.Hash {
+	var b bytes.Buffer
+	b.WriteByte(byte(t.LeafVersion))
+	wire.WriteVarBytes(&b, 0, t.Script)
+	return *chainhash.TaggedHash(tapLeafTag, b.Bytes())
+}
+
+// tapBranchHash returns the hash of an inner node of a script tree with the
+// passed children.  The children are sorted before hashing so the order of the
+// branches isn't committed to.
+func tapBranchHash(l, r []byte) chainhash.Hash {
+	if bytes.Compare(l, r) > 0 {
+		l, r = r, l
+	}
+	return *chainhash.TaggedHash(tapBranchTag, l, r)
+}
+
+// AssembleTaprootScriptTree returns the merkle root of a script tree which has
+// the passed leaves at equal depth, pairing them left to right and promoting
+// an unpaired node unchanged.  Along with the root, the merkle path proving the
+// inclusion of each leaf is returned in the order of the passed leaves.
+func AssembleTaprootScriptTree(leaves ...TapLeaf) (chainhash.Hash, [][]byte) {
+	if len(leaves) == 0 {
+		return chainhash.Hash{}, nil
+	}
+
+	// Each level tracks the hash of every node along with the indexes of
+	// the leaves below it so the inclusion proofs can be extended as the
+	// tree is built.
+	type node struct {
+		hash   chainhash.Hash
+		leaves []int
+	}
+	proofs := make([][]byte, len(leaves))
+	level := make([]node, 0, len(leaves))
+	for i, leaf := range leaves {
+		level = append(level, node{hash: leaf.TapHash(), leaves: []int{i}})
+	}
+	for len(level) > 1 {
+		next := make([]node, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			l, r := level[i], level[i+1]
+			for _, idx := range l.leaves {
+				proofs[idx] = append(proofs[idx], r.hash[:]...)
+			}
+			for _, idx := range r.leaves {
+				proofs[idx] = append(proofs[idx], l.hash[:]...)
+			}
+			next = append(next, node{
+				hash:   tapBranchHash(l.hash[:], r.hash[:]),
+				leaves: append(l.leaves, r.leaves...),
+			})
+		}
+		level = next
+	}
+
+	return level[0].hash, proofs
+}
+
+// tapTweak returns the BIP0341 tweak of the passed x-only internal key and
+// script tree root.  An error is returned in the negligibly unlikely case the
+// tweak isn't less than the group order.
+func tapTweak(internalKey []byte, scriptRoot []byte) (*big.Int, error) {
+	tweakHash := chainhash.TaggedHash(tapTweakTag, internalKey, scriptRoot)
+	tweak := new(big.Int).SetBytes(tweakHash[:])
+	if tweak.Cmp(btcec.S256().N) >= 0 {
+		return nil, fmt.Errorf("taproot tweak is >= group order")
+	}
+	return tweak, nil
+}
+
+// ComputeTaprootOutputKey returns the taproot output key committing to the
+// passed internal key and script tree root as specified by BIP0341.  The
+// internal key is used with an even y coordinate, and the script root is empty
+// for outputs which can only be spent via the key path.
+func ComputeTaprootOutputKey(internalKey *btcec.PublicKey,
+	scriptRoot []byte) (*btcec.PublicKey, error) {
+
+	// P = lift_x(int(pk)) and Q = P + int(t)G.
+	internalKeyBytes := schnorr.SerializePubKey(internalKey)
+	evenKey, err := schnorr.ParsePubKey(internalKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	tweak, err := tapTweak(internalKeyBytes, scriptRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	curve := btcec.S256()
+	tx, ty := curve.ScalarBaseMult(tweak.Bytes())
+	qx, qy := curve.Add(evenKey.X, evenKey.Y, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return nil, fmt.Errorf("taproot output key is the point at " +
+			"infinity")
+	}
+
+	return &btcec.PublicKey{Curve: curve, X: qx, Y: qy}, nil
+}
+
+// ComputeTaprootKeyNoScript returns the taproot output key for the passed
+// internal key that commits to an unspendable script path.
+func ComputeTaprootKeyNoScript(internalKey *btcec.PublicKey) (*btcec.PublicKey, error) {
+	return ComputeTaprootOutputKey(internalKey, nil)
+}
+
+// TweakTaprootPrivKey returns the private key which signs for the taproot
+// output key committing to the public key of the passed private key and the
+// passed script tree root.
+func TweakTaprootPrivKey(privKey *btcec.PrivateKey,
+	scriptRoot []byte) (*btcec.PrivateKey, error) {
+
+	curve := btcec.S256()
+	pubKey := privKey.PubKey()
+	tweak, err := tapTweak(schnorr.SerializePubKey(pubKey), scriptRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// The internal key is used with an even y coordinate, so the private
+	// key is negated first when that of its public key is odd.
+	d := new(big.Int).Set(privKey.D)
+	if pubKey.Y.Bit(0) == 1 {
+		d.Sub(curve.N, d)
+	}
+	d.Add(d, tweak)
+	d.Mod(d, curve.N)
+	if d.Sign() == 0 {
+		return nil, fmt.Errorf("tweaked taproot private key is zero")
+	}
+
+	var dBytes [32]byte
+	d.FillBytes(dBytes[:])
+	tweakedKey, _ := btcec.PrivKeyFromBytes(curve, dBytes[:])
+	return tweakedKey, nil
+}
+
+// PayToTaprootScript returns a new script paying to the passed taproot output
+// key.
+func PayToTaprootScript(outputKey *btcec.PublicKey) ([]byte, error) {
+	return NewScriptBuilder().AddOp(OP_1).
+		AddData(schnorr.SerializePubKey(outputKey)).Script()
+}
+
+// ControlBlock is the parsed form of the control block revealed by a taproot
+// script path spend.  It proves the revealed script is committed to by the
+// output key of the spent output.
+type ControlBlock struct {
+	// InternalKey is the internal key of the output, which always has an
+	// even y coordinate.
+	InternalKey *btcec.PublicKey
+
+	// OutputKeyYIsOdd is the parity of the y coordinate of the output key.
+	OutputKeyYIsOdd bool
+
+	// LeafVersion is the leaf version of the revealed script.
+	LeafVersion TapscriptLeafVersion
+
+	// InclusionProof is the merkle path from the revealed leaf to the root
+	// of the script tree, which is a series of 32-byte hashes.
+	InclusionProof []byte
+}
+
+// ParseControlBlock parses a serialized control block, which is a byte
+// holding the leaf version and the output key parity, followed by the x-only
+// internal key and the merkle path.
+func ParseControlBlock(ctrlBlock []byte) (*ControlBlock, error) {
+	switch {
+	case len(ctrlBlock) < ControlBlockBaseSize:
+		str := fmt.Sprintf("control block of size %d is smaller than the "+
+			"minimum of %d", len(ctrlBlock), ControlBlockBaseSize)
+		return nil, scriptError(ErrControlBlockTooSmall, str)
+
+	case len(ctrlBlock) > ControlBlockMaxSize:
+		str := fmt.Sprintf("control block of size %d is larger than the "+
+			"maximum of %d", len(ctrlBlock), ControlBlockMaxSize)
+		return nil, scriptError(ErrControlBlockTooLarge, str)
+
+	case (len(ctrlBlock)-ControlBlockBaseSize)%ControlBlockNodeSize != 0:
+		str := fmt.Sprintf("control block merkle path of size %d is not a "+
+			"multiple of %d", len(ctrlBlock)-ControlBlockBaseSize,
+			ControlBlockNodeSize)
+		return nil, scriptError(ErrControlBlockInvalidLength, str)
+	}
+
+	internalKey, err := schnorr.ParsePubKey(ctrlBlock[1:ControlBlockBaseSize])
+	if err != nil {
+		str := fmt.Sprintf("control block internal key is invalid: %v",
+			err)
+		return nil, scriptError(ErrTaprootMerkleProofInvalid, str)
+	}
+
+	return &ControlBlock{
+		InternalKey:     internalKey,
+		OutputKeyYIsOdd: ctrlBlock[0]&^TaprootLeafMask == 1,
+		LeafVersion:     TapscriptLeafVersion(ctrlBlock[0] & TaprootLeafMask),
+		InclusionProof:  ctrlBlock[ControlBlockBaseSize:],
+	}, nil
+}
+
+// ToBytes returns the serialized form of the control block.
+func (c *ControlBlock) ToBytes() []byte {
+	b := make([]byte, 0, ControlBlockBaseSize+len(c.InclusionProof))
+	leafByte := byte(c.LeafVersion)
+	if c.OutputKeyYIsOdd {
+		leafByte |= 1
+	}
+	b = append(b, leafByte)
+	b = append(b, schnorr.SerializePubKey(c.InternalKey)...)
+	return append(b, c.InclusionProof...)
+}
+
+// RootHash returns the root of the script tree obtained by hashing the leaf of
+// the passed script up the merkle path of the control block.
+func (c *ControlBlock) RootHash(revealedScript []byte) chainhash.Hash {
+	leaf := TapLeaf{LeafVersion: c.LeafVersion, Script: revealedScript}
+	node := leaf.TapHash()
+	for i := 0; i < len(c.InclusionProof); i += ControlBlockNodeSize {
+		sibling := c.InclusionProof[i : i+ControlBlockNodeSize]
+		node = tapBranchHash(node[:], sibling)
+	}
+	return node
+}
+
+// VerifyTaprootLeafCommitment returns an error when the passed control block
+// and revealed script don't commit to the passed 32-byte witness program, which
+// is the x-only output key of a taproot output.
+func VerifyTaprootLeafCommitment(ctrlBlock *ControlBlock,
+	taprootWitnessProgram []byte, revealedScript []byte) error {
+
+	rootHash := ctrlBlock.RootHash(revealedScript)
+	outputKey, err := ComputeTaprootOutputKey(ctrlBlock.InternalKey,
+		rootHash[:])
+	if err != nil {
+		str := fmt.Sprintf("unable to compute taproot output key: %v",
+			err)
+		return scriptError(ErrTaprootMerkleProofInvalid, str)
+	}
+
+	// The x coordinate of the output key must match the witness program
+	// and its parity must match the one claimed by the control block.
+	if !bytes.Equal(schnorr.SerializePubKey(outputKey), taprootWitnessProgram) {
+		str := fmt.Sprintf("control block commits to output key %x, "+
+			"witness program is %x", schnorr.SerializePubKey(outputKey),
+			taprootWitnessProgram)
+		return scriptError(ErrTaprootMerkleProofInvalid, str)
+	}
+	if (outputKey.Y.Bit(0) == 1) != ctrlBlock.OutputKeyYIsOdd {
+		str := "control block output key parity does not match the " +
+			"output key"
+		return scriptError(ErrTaprootMerkleProofInvalid, str)
+	}
+
+	return nil
+}
+
+// isAnnexedWitness returns whether or not the passed taproot witness has an
+// annex, which is a final element starting with the annex tag when there are
+// at least two elements.
+func isAnnexedWitness(witness wire.TxWitness) bool {
+	if len(witness) < 2 {
+		return false
+	}
+	lastElement := witness[len(witness)-1]
+	return len(lastElement) > 0 && lastElement[0] == TaprootAnnexTag
+}
+
+// isOpSuccess returns whether or not the passed opcode is one of the OP_SUCCESS
+// opcodes of BIP0342, which make a tapscript containing them unconditionally
+// valid.
+func isOpSuccess(opcode byte) bool {
+	return opcode == 80 || opcode == 98 ||
+		(opcode >= 126 && opcode <= 129) ||
+		(opcode >= 131 && opcode <= 134) ||
+		(opcode >= 137 && opcode <= 138) ||
+		(opcode >= 141 && opcode <= 142) ||
+		(opcode >= 149 && opcode <= 153) ||
+		(opcode >= 187 && opcode <= 254)
+}
+
+// scriptHasOpSuccess returns whether or not the passed tapscript contains an
+// OP_SUCCESS opcode prior to the first parse failure, if any.  Per BIP0342 an
+// OP_SUCCESS opcode takes effect even when the script fails to parse after it.
+func scriptHasOpSuccess(script []byte) bool {
+	const scriptVersion = 0
+	tokenizer := MakeScriptTokenizer(scriptVersion, script)
+	for tokenizer.Next() {
+		if isOpSuccess(tokenizer.Opcode()) {
+			return true
+		}
+	}
+	return false
+}
+
+// taprootExecutionCtx houses the state of the tapscript being executed which
+// is committed to by its signature hashes or limits its execution.
+type taprootExecutionCtx struct {
+	// annex is the annex of the witness, if any, including its tag.
+	annex []byte
+
+	// codeSepPos is the opcode position of the last executed
+	// OP_CODESEPARATOR, or blankCodeSepValue when there is none.
+	codeSepPos uint32
+
+	// tapLeafHash is the leaf hash of the executed tapscript, or nil when
+	// the key path is being spent.
+	tapLeafHash []byte
+
+	// sigOpsBudget is the remaining signature operations budget of the
+	// executed tapscript.
+	sigOpsBudget int
+
+	// mustSucceed is set when the spend is valid irrespective of script
+	// execution, which is the case for key path spends once their
+	// signature is verified, unknown leaf versions and tapscripts with an
+	// OP_SUCCESS opcode.
+	mustSucceed bool
+}
+
+// blankCodeSepValue is the codeseparator position committed to by tapscript
+// signature hashes when no OP_CODESEPARATOR has been executed.
+const blankCodeSepValue = ^uint32(0)
+
+// newTaprootExecutionCtx returns a new execution context for a tapscript
+// spend with the passed witness size, which determines its signature
+// operations budget.
+func newTaprootExecutionCtx(witnessSize int) *taprootExecutionCtx {
+	return &taprootExecutionCtx{
+		codeSepPos:   blankCodeSepValue,
+		sigOpsBudget: sigOpsDelta + witnessSize,
+	}
+}
+
+// tallySigOp decrements the signature operations budget, returning an error
+// when it is exhausted.
+func (t *taprootExecutionCtx) tallySigOp() error {
+	t.sigOpsBudget -= sigOpsDelta
+	if t.sigOpsBudget < 0 {
+		return scriptError(ErrTaprootMaxSigOps, "tapscript signature "+
+			"operations budget exceeded")
+	}
+	return nil
+}
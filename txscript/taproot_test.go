@@ -0,0 +1,788 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// taprootTestFlags are the script flags used to verify taproot spends in the
+// tests.
+const taprootTestFlags = ScriptBip16 | ScriptVerifyWitness | ScriptVerifyTaproot
+
+// taprootTestKey returns a deterministic private key for use in the tests
+// derived from the passed seed.
+func taprootTestKey(seed string) *btcec.PrivateKey {
+	keyBytes := sha256.Sum256([]byte(seed))
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes[:])
+	return privKey
+}
+
+// signSchnorrForTest creates a BIP0340 signature of the passed hash with the
+// passed private key.  The nonce is derived from the key and the hash, which
+// is only suitable for tests.
+func signSchnorrForTest(privKey *btcec.PrivateKey, hash []byte) []byte {
+	curve := btcec.S256()
+
+	// The key and the nonce are negated as needed so the public key and
+	// the nonce point have even y coordinates.
+	d := new(big.Int).Set(privKey.D)
+	pubKey := privKey.PubKey()
+	if pubKey.Y.Bit(0) == 1 {
+		d.Sub(curve.N, d)
+	}
+	nonce := sha256.Sum256(append(privKey.Serialize(), hash...))
+	k := new(big.Int).SetBytes(nonce[:])
+	k.Mod(k, curve.N)
+	rx, ry := curve.ScalarBaseMult(k.Bytes())
+	if ry.Bit(0) == 1 {
+		k.Sub(curve.N, k)
+	}
+
+	// s = k + e*d mod n.
+	var rBytes [32]byte
+	rx.FillBytes(rBytes[:])
+	commitment := chainhash.TaggedHash([]byte("BIP0340/challenge"),
+		rBytes[:], schnorr.SerializePubKey(pubKey), hash)
+	e := new(big.Int).SetBytes(commitment[:])
+	s := e.Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	return schnorr.NewSignature(rx, s).Serialize()
+}
+
+// taprootTestTx returns a transaction spending the output with the passed
+// public key script and amount along with a fetcher for the spent output.
+func taprootTestTx(pkScript []byte, amt int64) (*wire.MsgTx, PrevOutputFetcher) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	tx.AddTxOut(wire.NewTxOut(amt-1000, []byte{OP_TRUE}))
+	return tx, NewCannedPrevOutputFetcher(pkScript, amt)
+}
+
+// executeTaprootTest executes the first input of the passed transaction,
+// which spends the output with the passed public key script and amount, with
+// the passed witness and flags.
+func executeTaprootTest(tx *wire.MsgTx, pkScript []byte, amt int64,
+	prevOutFetcher PrevOutputFetcher, witness wire.TxWitness,
+	flags ScriptFlags) error {
+
+	tx.TxIn[0].Witness = witness
+	sigHashes := NewTxSigHashes(tx, prevOutFetcher)
+	vm, err := NewEngine(pkScript, tx, 0, flags, nil, sigHashes, amt,
+		prevOutFetcher)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// checkTaprootTestResult reports a test failure unless the passed error matches
+// the expected error code, with ErrInternal denoting the absence of an error.
+func checkTaprootTestResult(t *testing.T, name string, err error, want ErrorCode) {
+	t.Helper()
+
+	if want == ErrInternal {
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		return
+	}
+	if !IsErrorCode(err, want) {
+		t.Errorf("%s: got error %v, want %v", name, err, want)
+	}
+}
+
+// TestControlBlockCommitment ensures control blocks are parsed and verified
+// against the witness program they commit to, including a control block and
+// leaf created by Bitcoin Core for its taproot PSBT tests.
+func TestControlBlockCommitment(t *testing.T) {
+	t.Parallel()
+
+	ctrlBlockBytes := hexToBytes("c150929b74c1a04954b78b4b6035e97a5e078a5" +
+		"a0f28ec96d547bfee9ace803ac06f7d62059e9497a1a4a267569d9876da6010" +
+		"1aff38e3529b9b939ce7f91ae970115f2e490af7cc45c4f78511f36057ce5c5" +
+		"a5c56325a29fb44dfc203f356e1f8")
+	script := hexToBytes("202cb13ac68248de806aa6a3659cf3c03eb6821d09c8114" +
+		"a4e868febde865bb6d2ac")
+	program := hexToBytes("c2247efbfd92ac47f6f40b8d42d169175a19fa9fa10e4a2" +
+		"5d7f35eb4dd85b692")
+
+	ctrlBlock, err := ParseControlBlock(ctrlBlockBytes)
+	if err != nil {
+		t.Fatalf("unable to parse control block: %v", err)
+	}
+	if ctrlBlock.LeafVersion != BaseLeafVersion || !ctrlBlock.OutputKeyYIsOdd {
+		t.Fatalf("unexpected control block leaf byte %x",
+			ctrlBlockBytes[0])
+	}
+	if !bytes.Equal(ctrlBlock.ToBytes(), ctrlBlockBytes) {
+		t.Fatalf("control block does not round trip")
+	}
+	err = VerifyTaprootLeafCommitment(ctrlBlock, program, script)
+	if err != nil {
+		t.Fatalf("unable to verify leaf commitment: %v", err)
+	}
+
+	// A different script, output key parity or merkle path must not
+	// verify.
+	badScript := append([]byte{OP_NOP}, script...)
+	err = VerifyTaprootLeafCommitment(ctrlBlock, program, badScript)
+	checkTaprootTestResult(t, "bad script", err, ErrTaprootMerkleProofInvalid)
+
+	badParity := *ctrlBlock
+	badParity.OutputKeyYIsOdd = false
+	err = VerifyTaprootLeafCommitment(&badParity, program, script)
+	checkTaprootTestResult(t, "bad parity", err, ErrTaprootMerkleProofInvalid)
+
+	badPath := *ctrlBlock
+	badPath.InclusionProof = ctrlBlock.InclusionProof[:ControlBlockNodeSize]
+	err = VerifyTaprootLeafCommitment(&badPath, program, script)
+	checkTaprootTestResult(t, "bad path", err, ErrTaprootMerkleProofInvalid)
+
+	// Control blocks of invalid sizes must be rejected.
+	sizeTests := []struct {
+		size int
+		want ErrorCode
+	}{
+		{size: ControlBlockBaseSize - 1, want: ErrControlBlockTooSmall},
+		{size: ControlBlockBaseSize + 1, want: ErrControlBlockInvalidLength},
+		{size: ControlBlockMaxSize, want: ErrInternal},
+		{size: ControlBlockMaxSize + ControlBlockNodeSize,
+			want: ErrControlBlockTooLarge},
+	}
+	for _, test := range sizeTests {
+		b := make([]byte, test.size)
+		copy(b, ctrlBlockBytes)
+		_, err := ParseControlBlock(b)
+		checkTaprootTestResult(t, "control block size", err, test.want)
+	}
+}
+
+// TestTaprootKeySpend ensures taproot key path spends are verified as defined
+// by BIP0341.
+func TestTaprootKeySpend(t *testing.T) {
+	t.Parallel()
+
+	privKey := taprootTestKey("taproot key spend")
+	outputKey, err := ComputeTaprootKeyNoScript(privKey.PubKey())
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	tweakedKey, err := TweakTaprootPrivKey(privKey, nil)
+	if err != nil {
+		t.Fatalf("unable to tweak private key: %v", err)
+	}
+	if !bytes.Equal(schnorr.SerializePubKey(tweakedKey.PubKey()),
+		schnorr.SerializePubKey(outputKey)) {
+
+		t.Fatalf("tweaked private key does not match output key")
+	}
+	pkScript, err := PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	if !IsPayToTaproot(pkScript) {
+		t.Fatalf("script %x is not pay-to-taproot", pkScript)
+	}
+	const amt = 100000000
+
+	annex := []byte{TaprootAnnexTag, 0x01, 0x02}
+	tests := []struct {
+		name      string
+		hashType  SigHashType
+		signAnnex bool
+		annex     []byte
+		noOutputs bool
+		mutate    func([]byte) []byte
+		flags     ScriptFlags
+		want      ErrorCode
+	}{{
+		name:     "default hash type",
+		hashType: SigHashDefault,
+		want:     ErrInternal,
+	}, {
+		name:     "explicit all",
+		hashType: SigHashAll,
+		want:     ErrInternal,
+	}, {
+		name:     "single anyone can pay",
+		hashType: SigHashSingle | SigHashAnyOneCanPay,
+		want:     ErrInternal,
+	}, {
+		name:     "none",
+		hashType: SigHashNone,
+		want:     ErrInternal,
+	}, {
+		name:      "signed annex",
+		hashType:  SigHashDefault,
+		signAnnex: true,
+		annex:     annex,
+		want:      ErrInternal,
+	}, {
+		name:     "unsigned annex",
+		hashType: SigHashDefault,
+		annex:    annex,
+		want:     ErrTaprootSigInvalid,
+	}, {
+		name:     "explicit default hash type",
+		hashType: SigHashDefault,
+		mutate: func(sig []byte) []byte {
+			return append(sig, byte(SigHashDefault))
+		},
+		want: ErrInvalidSigHashType,
+	}, {
+		name:     "undefined hash type",
+		hashType: SigHashAll,
+		mutate: func(sig []byte) []byte {
+			sig[64] = 0x04
+			return sig
+		},
+		want: ErrInvalidSigHashType,
+	}, {
+		name:      "single without output",
+		hashType:  SigHashSingle,
+		noOutputs: true,
+		want:      ErrInvalidSigHashType,
+	}, {
+		name:     "short signature",
+		hashType: SigHashDefault,
+		mutate: func(sig []byte) []byte {
+			return sig[:63]
+		},
+		want: ErrInvalidTaprootSigLen,
+	}, {
+		name:     "bad signature",
+		hashType: SigHashDefault,
+		mutate: func(sig []byte) []byte {
+			sig[0] ^= 0x01
+			return sig
+		},
+		want: ErrTaprootSigInvalid,
+	}, {
+		name:     "hash type mismatch",
+		hashType: SigHashAll,
+		mutate: func(sig []byte) []byte {
+			sig[64] = byte(SigHashNone)
+			return sig
+		},
+		want: ErrTaprootSigInvalid,
+	}, {
+		name:     "taproot inactive",
+		hashType: SigHashDefault,
+		mutate: func(sig []byte) []byte {
+			return nil
+		},
+		flags: ScriptBip16 | ScriptVerifyWitness,
+		want:  ErrInternal,
+	}, {
+		name:     "taproot inactive discourage upgradable",
+		hashType: SigHashDefault,
+		flags: ScriptBip16 | ScriptVerifyWitness |
+			ScriptVerifyDiscourageUpgradeableWitnessProgram,
+		want: ErrDiscourageUpgradableWitnessProgram,
+	}}
+
+	for _, test := range tests {
+		tx, prevOutFetcher := taprootTestTx(pkScript, amt)
+		if test.noOutputs {
+			tx.TxOut = nil
+		}
+
+		// Sign the input, committing to the annex as needed, by
+		// signing the signature hash the engine would calculate
+		// with a bogus single output when there are none.
+		opts := &taprootSigHashOptions{}
+		if test.signAnnex {
+			opts.annex = test.annex
+		}
+		signTx := tx
+		if test.noOutputs {
+			signTx = tx.Copy()
+			signTx.AddTxOut(wire.NewTxOut(0, nil))
+		}
+		sigHashes := NewTxSigHashes(signTx, prevOutFetcher)
+		hash, err := calcTaprootSignatureHashRaw(sigHashes, test.hashType,
+			signTx, 0, prevOutFetcher, opts)
+		if err != nil {
+			t.Fatalf("%s: unable to calculate sighash: %v", test.name,
+				err)
+		}
+		sig := signSchnorrForTest(tweakedKey, hash)
+		if test.hashType != SigHashDefault {
+			sig = append(sig, byte(test.hashType))
+		}
+		if test.mutate != nil {
+			sig = test.mutate(sig)
+		}
+
+		witness := wire.TxWitness{sig}
+		if test.annex != nil {
+			witness = append(witness, test.annex)
+		}
+		flags := test.flags
+		if flags == 0 {
+			flags = taprootTestFlags
+		}
+		err = executeTaprootTest(tx, pkScript, amt, prevOutFetcher,
+			witness, flags)
+		checkTaprootTestResult(t, test.name, err, test.want)
+	}
+
+	// The exported signature hash calculation must match the one used by
+	// the engine.
+	tx, prevOutFetcher := taprootTestTx(pkScript, amt)
+	sigHashes := NewTxSigHashes(tx, prevOutFetcher)
+	hash, err := CalcTaprootSignatureHash(sigHashes, SigHashDefault, tx, 0,
+		prevOutFetcher)
+	if err != nil {
+		t.Fatalf("unable to calculate sighash: %v", err)
+	}
+	witness := wire.TxWitness{signSchnorrForTest(tweakedKey, hash)}
+	err = executeTaprootTest(tx, pkScript, amt, prevOutFetcher, witness,
+		taprootTestFlags)
+	checkTaprootTestResult(t, "exported sighash", err, ErrInternal)
+
+	// An empty witness is never valid.
+	err = executeTaprootTest(tx, pkScript, amt, prevOutFetcher, nil,
+		taprootTestFlags)
+	checkTaprootTestResult(t, "empty witness", err, ErrWitnessProgramEmpty)
+}
+
+// TestTapscriptSpend ensures taproot script path spends and the tapscript
+// semantics of BIP0342 are enforced.
+func TestTapscriptSpend(t *testing.T) {
+	t.Parallel()
+
+	internalKey := taprootTestKey("tapscript internal key")
+	keys := make([]*btcec.PrivateKey, 3)
+	pubKeys := make([][]byte, 3)
+	for i := range keys {
+		keys[i] = taprootTestKey(string(rune('a' + i)))
+		pubKeys[i] = schnorr.SerializePubKey(keys[i].PubKey())
+	}
+
+	mustBuild := func(b *ScriptBuilder) []byte {
+		script, err := b.Script()
+		if err != nil {
+			t.Fatalf("unable to build script: %v", err)
+		}
+		return script
+	}
+
+	// checkSigsScript returns a script which checks numChecks signatures
+	// for the first key, which is only valid with a budget permitting
+	// them.
+	checkSigsScript := func(numChecks int) []byte {
+		b := NewScriptBuilder().AddData(pubKeys[0])
+		for i := 0; i < numChecks-1; i++ {
+			b.AddOp(OP_2DUP).AddOp(OP_CHECKSIGVERIFY)
+		}
+		return mustBuild(b.AddOp(OP_CHECKSIG))
+	}
+
+	const (
+		leafCheckSig = iota
+		leafCheckSigAdd
+		leafOpSuccess
+		leafCheckMultiSig
+		leafBudgetOK
+		leafBudgetExceeded
+		leafCodeSep
+		leafIf
+		leafUnknownPubKey
+		leafUnknownVersion
+	)
+	leaves := []TapLeaf{
+		leafCheckSig: NewBaseTapLeaf(mustBuild(NewScriptBuilder().
+			AddData(pubKeys[0]).AddOp(OP_CHECKSIG))),
+		leafCheckSigAdd: NewBaseTapLeaf(mustBuild(NewScriptBuilder().
+			AddData(pubKeys[0]).AddOp(OP_CHECKSIG).
+			AddData(pubKeys[1]).AddOp(OP_CHECKSIGADD).
+			AddData(pubKeys[2]).AddOp(OP_CHECKSIGADD).
+			AddOp(OP_2).AddOp(OP_NUMEQUAL))),
+		leafOpSuccess: NewBaseTapLeaf([]byte{OP_RETURN, OP_RESERVED,
+			OP_PUSHDATA1}),
+		leafCheckMultiSig: NewBaseTapLeaf(mustBuild(NewScriptBuilder().
+			AddOp(OP_0).AddOp(OP_0).AddData(pubKeys[0]).AddOp(OP_1).
+			AddOp(OP_CHECKMULTISIG))),
+		leafBudgetOK:       NewBaseTapLeaf(checkSigsScript(6)),
+		leafBudgetExceeded: NewBaseTapLeaf(checkSigsScript(7)),
+		leafCodeSep: NewBaseTapLeaf(mustBuild(NewScriptBuilder().
+			AddOp(OP_NOP).AddOp(OP_CODESEPARATOR).AddData(pubKeys[0]).
+			AddOp(OP_CHECKSIG))),
+		leafIf: NewBaseTapLeaf([]byte{OP_IF, OP_1, OP_ELSE, OP_1,
+			OP_ENDIF}),
+		leafUnknownPubKey: NewBaseTapLeaf(mustBuild(NewScriptBuilder().
+			AddData(append([]byte{0x02}, pubKeys[0]...)).
+			AddOp(OP_CHECKSIG))),
+		leafUnknownVersion: {LeafVersion: 0xc2, Script: []byte{OP_FALSE}},
+	}
+	rootHash, proofs := AssembleTaprootScriptTree(leaves...)
+	outputKey, err := ComputeTaprootOutputKey(internalKey.PubKey(),
+		rootHash[:])
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	pkScript, err := PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	const amt = 50000000
+
+	// ctrlBlockFor returns the control block revealing the passed leaf.
+	ctrlBlockFor := func(leafIdx int) []byte {
+		ctrlBlock := ControlBlock{
+			InternalKey:     internalKey.PubKey(),
+			OutputKeyYIsOdd: outputKey.Y.Bit(0) == 1,
+			LeafVersion:     leaves[leafIdx].LeafVersion,
+			InclusionProof:  proofs[leafIdx],
+		}
+		return ctrlBlock.ToBytes()
+	}
+
+	// sign returns the signatures of the passed keys for a spend of the
+	// passed leaf in the passed transaction, with an empty signature for
+	// nil keys.
+	sign := func(tx *wire.MsgTx, prevOutFetcher PrevOutputFetcher,
+		leafIdx int, codeSepPos uint32, keys ...*btcec.PrivateKey) [][]byte {
+
+		tapLeafHash := leaves[leafIdx].TapHash()
+		opts := &taprootSigHashOptions{
+			tapLeafHash: tapLeafHash[:],
+			codeSepPos:  codeSepPos,
+		}
+		sigHashes := NewTxSigHashes(tx, prevOutFetcher)
+		hash, err := calcTaprootSignatureHashRaw(sigHashes,
+			SigHashDefault, tx, 0, prevOutFetcher, opts)
+		if err != nil {
+			t.Fatalf("unable to calculate sighash: %v", err)
+		}
+		sigs := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			if key == nil {
+				sigs = append(sigs, nil)
+				continue
+			}
+			sigs = append(sigs, signSchnorrForTest(key, hash))
+		}
+		return sigs
+	}
+
+	tests := []struct {
+		name      string
+		leaf      int
+		stack     func(*wire.MsgTx, PrevOutputFetcher) [][]byte
+		ctrlBlock func([]byte) []byte
+		flags     ScriptFlags
+		want      ErrorCode
+	}{{
+		name: "checksig",
+		leaf: leafCheckSig,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSig, blankCodeSepValue, keys[0])
+		},
+		want: ErrInternal,
+	}, {
+		name: "checksig wrong key",
+		leaf: leafCheckSig,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSig, blankCodeSepValue, keys[1])
+		},
+		want: ErrTaprootSigInvalid,
+	}, {
+		name: "checksig empty signature",
+		leaf: leafCheckSig,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return [][]byte{nil}
+		},
+		want: ErrEvalFalse,
+	}, {
+		name: "wrong output key parity",
+		leaf: leafCheckSig,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSig, blankCodeSepValue, keys[0])
+		},
+		ctrlBlock: func(ctrlBlock []byte) []byte {
+			ctrlBlock[0] ^= 0x01
+			return ctrlBlock
+		},
+		want: ErrTaprootMerkleProofInvalid,
+	}, {
+		name: "wrong merkle path",
+		leaf: leafCheckSig,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSig, blankCodeSepValue, keys[0])
+		},
+		ctrlBlock: func(ctrlBlock []byte) []byte {
+			ctrlBlock[len(ctrlBlock)-1] ^= 0x01
+			return ctrlBlock
+		},
+		want: ErrTaprootMerkleProofInvalid,
+	}, {
+		name: "checksigadd 2 of 3",
+		leaf: leafCheckSigAdd,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSigAdd, blankCodeSepValue,
+				keys[2], nil, keys[0])
+		},
+		want: ErrInternal,
+	}, {
+		name: "checksigadd 1 of 3",
+		leaf: leafCheckSigAdd,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSigAdd, blankCodeSepValue,
+				nil, nil, keys[0])
+		},
+		want: ErrEvalFalse,
+	}, {
+		name: "checksigadd invalid signature",
+		leaf: leafCheckSigAdd,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCheckSigAdd, blankCodeSepValue,
+				keys[2], keys[0], keys[0])
+		},
+		want: ErrTaprootSigInvalid,
+	}, {
+		name: "op_success",
+		leaf: leafOpSuccess,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return nil
+		},
+		want: ErrInternal,
+	}, {
+		name: "op_success discouraged",
+		leaf: leafOpSuccess,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return nil
+		},
+		flags: taprootTestFlags | ScriptVerifyDiscourageOpSuccess,
+		want:  ErrDiscourageOpSuccess,
+	}, {
+		name: "checkmultisig",
+		leaf: leafCheckMultiSig,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return nil
+		},
+		want: ErrTapscriptCheckMultisig,
+	}, {
+		name: "sigops budget",
+		leaf: leafBudgetOK,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafBudgetOK, blankCodeSepValue, keys[0])
+		},
+		want: ErrInternal,
+	}, {
+		name: "sigops budget exceeded",
+		leaf: leafBudgetExceeded,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafBudgetExceeded, blankCodeSepValue,
+				keys[0])
+		},
+		want: ErrTaprootMaxSigOps,
+	}, {
+		name: "codeseparator position",
+		leaf: leafCodeSep,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCodeSep, 1, keys[0])
+		},
+		want: ErrInternal,
+	}, {
+		name: "codeseparator position not committed",
+		leaf: leafCodeSep,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return sign(tx, f, leafCodeSep, blankCodeSepValue, keys[0])
+		},
+		want: ErrTaprootSigInvalid,
+	}, {
+		name: "minimal if",
+		leaf: leafIf,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return [][]byte{{0x01}}
+		},
+		want: ErrInternal,
+	}, {
+		name: "non-minimal if",
+		leaf: leafIf,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return [][]byte{{0x02}}
+		},
+		want: ErrMinimalIf,
+	}, {
+		name: "unknown public key type",
+		leaf: leafUnknownPubKey,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return [][]byte{{0x01}}
+		},
+		want: ErrInternal,
+	}, {
+		name: "unknown public key type discouraged",
+		leaf: leafUnknownPubKey,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return [][]byte{{0x01}}
+		},
+		flags: taprootTestFlags |
+			ScriptVerifyDiscourageUpgradeablePubkeyType,
+		want: ErrDiscourageUpgradeablePubKeyType,
+	}, {
+		name: "unknown leaf version",
+		leaf: leafUnknownVersion,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return nil
+		},
+		want: ErrInternal,
+	}, {
+		name: "unknown leaf version discouraged",
+		leaf: leafUnknownVersion,
+		stack: func(tx *wire.MsgTx, f PrevOutputFetcher) [][]byte {
+			return nil
+		},
+		flags: taprootTestFlags |
+			ScriptVerifyDiscourageUpgradeableTaprootVersion,
+		want: ErrDiscourageUpgradeableTaprootVersion,
+	}}
+
+	for _, test := range tests {
+		tx, prevOutFetcher := taprootTestTx(pkScript, amt)
+		ctrlBlock := ctrlBlockFor(test.leaf)
+		if test.ctrlBlock != nil {
+			ctrlBlock = test.ctrlBlock(ctrlBlock)
+		}
+		witness := wire.TxWitness(test.stack(tx, prevOutFetcher))
+		witness = append(witness, leaves[test.leaf].Script, ctrlBlock)
+
+		flags := test.flags
+		if flags == 0 {
+			flags = taprootTestFlags
+		}
+		err := executeTaprootTest(tx, pkScript, amt, prevOutFetcher,
+			witness, flags)
+		checkTaprootTestResult(t, test.name, err, test.want)
+	}
+
+	// The exported tapscript signature hash calculation must match the
+	// one used by the engine.
+	tx, prevOutFetcher := taprootTestTx(pkScript, amt)
+	sigHashes := NewTxSigHashes(tx, prevOutFetcher)
+	hash, err := CalcTapscriptSignatureHash(sigHashes, SigHashAll, tx, 0,
+		prevOutFetcher, leaves[leafCheckSig])
+	if err != nil {
+		t.Fatalf("unable to calculate sighash: %v", err)
+	}
+	sig := append(signSchnorrForTest(keys[0], hash), byte(SigHashAll))
+	witness := wire.TxWitness{sig, leaves[leafCheckSig].Script,
+		ctrlBlockFor(leafCheckSig)}
+	err = executeTaprootTest(tx, pkScript, amt, prevOutFetcher, witness,
+		taprootTestFlags)
+	checkTaprootTestResult(t, "exported sighash", err, ErrInternal)
+}
+
+// TestTxSigHashesTaproot ensures the taproot midstates are only calculated for
+// transactions spending taproot outputs and that the segwit v0 midstates are
+// unaffected by them.
+func TestTxSigHashesTaproot(t *testing.T) {
+	t.Parallel()
+
+	taprootScript := append([]byte{OP_1, OP_DATA_32}, make([]byte, 32)...)
+	tx, prevOutFetcher := taprootTestTx(taprootScript, 1000000)
+
+	var zeroHash chainhash.Hash
+	sigHashes := NewTxSigHashes(tx, nil)
+	if sigHashes.HashInputScriptsV1 != zeroHash {
+		t.Fatalf("taproot midstates calculated without spent outputs")
+	}
+
+	taprootHashes := NewTxSigHashes(tx, prevOutFetcher)
+	if taprootHashes.HashInputScriptsV1 == zeroHash ||
+		taprootHashes.HashInputAmountsV1 == zeroHash {
+
+		t.Fatalf("taproot midstates not calculated")
+	}
+	if chainhash.HashH(taprootHashes.HashPrevOutsV1[:]) !=
+		taprootHashes.HashPrevOuts ||
+		taprootHashes.HashPrevOuts != sigHashes.HashPrevOuts {
+
+		t.Fatalf("segwit v0 midstate does not match taproot midstate")
+	}
+
+	p2wkhScript := append([]byte{OP_0, OP_DATA_20}, make([]byte, 20)...)
+	tx, prevOutFetcher = taprootTestTx(p2wkhScript, 1000000)
+	sigHashes = NewTxSigHashes(tx, prevOutFetcher)
+	if sigHashes.HashInputScriptsV1 != zeroHash {
+		t.Fatalf("taproot midstates calculated for segwit v0 spend")
+	}
+}
+
+// TestSchnorrBatchVerification ensures the engine defers the verification of
+// Schnorr signatures to its batch verifier when it has one.
+func TestSchnorrBatchVerification(t *testing.T) {
+	t.Parallel()
+
+	privKey := taprootTestKey("taproot batch")
+	outputKey, err := ComputeTaprootKeyNoScript(privKey.PubKey())
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	tweakedKey, err := TweakTaprootPrivKey(privKey, nil)
+	if err != nil {
+		t.Fatalf("unable to tweak private key: %v", err)
+	}
+	pkScript, err := PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	const amt = 100000000
+
+	for _, corrupt := range []bool{false, true} {
+		tx, prevOutFetcher := taprootTestTx(pkScript, amt)
+		sigHashes := NewTxSigHashes(tx, prevOutFetcher)
+		hash, err := CalcTaprootSignatureHash(sigHashes, SigHashDefault,
+			tx, 0, prevOutFetcher)
+		if err != nil {
+			t.Fatalf("unable to calculate sighash: %v", err)
+		}
+		sig, err := schnorr.Sign(tweakedKey, hash)
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		sigBytes := sig.Serialize()
+		if corrupt {
+			sigBytes[40] ^= 0x01
+		}
+		tx.TxIn[0].Witness = wire.TxWitness{sigBytes}
+
+		batch := schnorr.NewBatchVerifier()
+		vm, err := NewEngine(pkScript, tx, 0, taprootTestFlags, nil,
+			sigHashes, amt, prevOutFetcher)
+		if err != nil {
+			t.Fatalf("unable to create engine: %v", err)
+		}
+		vm.SetSchnorrBatchVerifier(batch)
+		if err := vm.Execute(); err != nil {
+			t.Fatalf("corrupt %v: deferred signature check failed: %v",
+				corrupt, err)
+		}
+		if batch.Len() != 1 {
+			t.Fatalf("corrupt %v: got %d batched signatures, want 1",
+				corrupt, batch.Len())
+		}
+		if batch.Verify() == corrupt {
+			t.Fatalf("corrupt %v: got batch result %v", corrupt,
+				!corrupt)
+		}
+	}
+}
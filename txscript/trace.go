@@ -0,0 +1,157 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"strings"
+)
+
+// TraceSigCheck describes a signature checked by the engine against the
+// signature hash it computed for it.
+type TraceSigCheck struct {
+	// PubKey is the public key the signature was checked against.  It is
+	// an x-only key for taproot signatures.
+	PubKey []byte
+
+	// Signature is the signature without its hash type.
+	Signature []byte
+
+	// HashType is the hash type of the signature.
+	HashType SigHashType
+
+	// SigHash is the signature hash the signature commits to.
+	SigHash []byte
+
+	// Valid is whether the signature is valid.  Signatures queued to a
+	// Schnorr batch verifier are reported as valid.
+	Valid bool
+}
+
+// TraceStep describes the execution of a single opcode by the engine.
+type TraceStep struct {
+	// ScriptIdx and OpcodeIdx locate the opcode within the scripts of the
+	// engine, as with DisasmPC.
+	ScriptIdx int
+	OpcodeIdx int
+
+	// Opcode is the disassembly of the opcode along with its data.
+	Opcode string
+
+	// Executed is false when the opcode was skipped because it is in a
+	// conditional branch which is not executed.
+	Executed bool
+
+	// StackBefore is the data stack before the opcode was executed.  Stack,
+	// AltStack and CondStack are the data, alternate and condition stacks
+	// after it was executed, and NumOps is the number of non-push
+	// operations of the script counted so far.  The last element of the
+	// stacks is the top.
+	StackBefore [][]byte
+	Stack       [][]byte
+	AltStack    [][]byte
+	CondStack   []int
+	NumOps      int
+
+	// SigChecks are the signatures checked by the step.  This includes the
+	// signature of a taproot key path spend, which is checked once the
+	// public key script is executed.
+	SigChecks []TraceSigCheck
+
+	// Err is the error the step failed with, if any.
+	Err error
+}
+
+// Tracer is the interface implemented by the observers of the execution of
+// scripts by the engine.
+type Tracer interface {
+	// OnStep is invoked with each opcode executed by the engine once the
+	// execution is complete.  The step must not be retained once it
+	// returns unless it is copied.
+	OnStep(step *TraceStep)
+}
+
+// TraceFunc is an adapter to allow the use of ordinary functions as tracers.
+type TraceFunc func(step *TraceStep)
+
+// OnStep calls f(step).
+func (f TraceFunc) OnStep(step *TraceStep) {
+	f(step)
+}
+
+// SetTracer makes the engine report each opcode it executes to the passed
+// tracer.  A nil tracer disables tracing.
+func (vm *Engine) SetTracer(tracer Tracer) {
+	vm.tracer = tracer
+}
+
+// copyStack returns a deep copy of the passed stack contents.
+func copyStack(stack [][]byte) [][]byte {
+	stackCopy := make([][]byte, len(stack))
+	for i, item := range stack {
+		stackCopy[i] = make([]byte, len(item))
+		copy(stackCopy[i], item)
+	}
+	return stackCopy
+}
+
+// beginTraceStep returns the trace of the opcode about to be executed by the
+// engine.
+func (vm *Engine) beginTraceStep() *TraceStep {
+	op := vm.tokenizer.op
+	var buf strings.Builder
+	disasmOpcode(&buf, op, vm.tokenizer.Data(), false)
+	return &TraceStep{
+		ScriptIdx:   vm.scriptIdx,
+		OpcodeIdx:   vm.opcodeIdx,
+		Opcode:      buf.String(),
+		Executed:    vm.isBranchExecuting() || isOpcodeConditional(op.value),
+		StackBefore: copyStack(vm.GetStack()),
+	}
+}
+
+// snapshotTraceStep records the state of the engine right after the opcode of
+// the passed step was executed, before moving on to the next script when it is
+// the last of its script.
+func (vm *Engine) snapshotTraceStep(step *TraceStep) {
+	step.Stack = copyStack(vm.GetStack())
+	step.AltStack = copyStack(vm.GetAltStack())
+	step.CondStack = append([]int(nil), vm.condStack...)
+	step.NumOps = vm.numOps
+}
+
+// endTraceStep reports the passed step to the tracer along with the signatures
+// checked while executing it and the error it failed with.
+func (vm *Engine) endTraceStep(step *TraceStep, err error) {
+	step.SigChecks = vm.traceSigChecks
+	step.Err = err
+	vm.traceSigChecks = nil
+	vm.tracer.OnStep(step)
+}
+
+// traceSigCheck records the passed signature check for the trace of the
+// current step.  It does nothing unless the engine has a tracer.
+func (vm *Engine) traceSigCheck(pkBytes, sigBytes []byte, hashType SigHashType,
+	hash []byte, valid bool) {
+
+	if vm.tracer == nil {
+		return
+	}
+	vm.traceSigChecks = append(vm.traceSigChecks, TraceSigCheck{
+		PubKey:    append([]byte(nil), pkBytes...),
+		Signature: append([]byte(nil), sigBytes...),
+		HashType:  hashType,
+		SigHash:   append([]byte(nil), hash...),
+		Valid:     valid,
+	})
+}
+
+// taprootSigHashType returns the hash type of the passed taproot signature
+// which calcTaprootSigHash accepted.
+func taprootSigHashType(rawSig []byte) SigHashType {
+	if len(rawSig) == 65 {
+		return SigHashType(rawSig[64])
+	}
+	return SigHashDefault
+}
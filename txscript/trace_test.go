@@ -0,0 +1,282 @@
+// Copyright (c) 2013-2022 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/schnorr"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// traceEngine executes the passed engine while recording the steps it
+// reports, and returns them along with the result of the execution.
+func traceEngine(vm *Engine) ([]TraceStep, error) {
+	var steps []TraceStep
+	vm.SetTracer(TraceFunc(func(step *TraceStep) {
+		steps = append(steps, *step)
+	}))
+	return steps, vm.Execute()
+}
+
+// TestTraceSteps ensures the tracer is reported every opcode executed by the
+// engine along with the stacks before and after it, including the opcodes
+// skipped by conditionals.
+func TestTraceSteps(t *testing.T) {
+	t.Parallel()
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			SignatureScript: mustParseShortForm("0"),
+		}},
+		TxOut: []*wire.TxOut{{}},
+	}
+	pkScript := mustParseShortForm("IF 2 ELSE 3 TOALTSTACK 4 ENDIF")
+	vm, err := NewEngine(pkScript, tx, 0, 0, nil, nil, 0, nil)
+	if err != nil {
+		t.Fatalf("unable to create engine: %v", err)
+	}
+	steps, err := traceEngine(vm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []TraceStep{{
+		ScriptIdx:   0,
+		OpcodeIdx:   0,
+		Opcode:      "OP_0",
+		Executed:    true,
+		StackBefore: [][]byte{},
+		Stack:       [][]byte{{}},
+		AltStack:    [][]byte{},
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   0,
+		Opcode:      "OP_IF",
+		Executed:    true,
+		StackBefore: [][]byte{{}},
+		Stack:       [][]byte{},
+		AltStack:    [][]byte{},
+		CondStack:   []int{OpCondFalse},
+		NumOps:      1,
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   1,
+		Opcode:      "OP_2",
+		StackBefore: [][]byte{},
+		Stack:       [][]byte{},
+		AltStack:    [][]byte{},
+		CondStack:   []int{OpCondFalse},
+		NumOps:      1,
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   2,
+		Opcode:      "OP_ELSE",
+		Executed:    true,
+		StackBefore: [][]byte{},
+		Stack:       [][]byte{},
+		AltStack:    [][]byte{},
+		CondStack:   []int{OpCondTrue},
+		NumOps:      2,
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   3,
+		Opcode:      "OP_3",
+		Executed:    true,
+		StackBefore: [][]byte{},
+		Stack:       [][]byte{{3}},
+		AltStack:    [][]byte{},
+		CondStack:   []int{OpCondTrue},
+		NumOps:      2,
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   4,
+		Opcode:      "OP_TOALTSTACK",
+		Executed:    true,
+		StackBefore: [][]byte{{3}},
+		Stack:       [][]byte{},
+		AltStack:    [][]byte{{3}},
+		CondStack:   []int{OpCondTrue},
+		NumOps:      3,
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   5,
+		Opcode:      "OP_4",
+		Executed:    true,
+		StackBefore: [][]byte{},
+		Stack:       [][]byte{{4}},
+		AltStack:    [][]byte{{3}},
+		CondStack:   []int{OpCondTrue},
+		NumOps:      3,
+	}, {
+		ScriptIdx:   1,
+		OpcodeIdx:   6,
+		Opcode:      "OP_ENDIF",
+		Executed:    true,
+		StackBefore: [][]byte{{4}},
+		Stack:       [][]byte{{4}},
+		AltStack:    [][]byte{{3}},
+		CondStack:   []int{},
+		NumOps:      4,
+	}}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d", len(steps), len(want))
+	}
+	for i := range want {
+		// The condition stack is only compared by its contents.
+		if len(steps[i].CondStack) == 0 && len(want[i].CondStack) == 0 {
+			steps[i].CondStack = want[i].CondStack
+		}
+		if !reflect.DeepEqual(steps[i], want[i]) {
+			t.Fatalf("step %d: got %+v, want %+v", i, steps[i],
+				want[i])
+		}
+	}
+
+	// Failing steps report their error.
+	pkScript = mustParseShortForm("VERIFY")
+	vm, err = NewEngine(pkScript, tx, 0, 0, nil, nil, 0, nil)
+	if err != nil {
+		t.Fatalf("unable to create engine: %v", err)
+	}
+	steps, err = traceEngine(vm)
+	if !IsErrorCode(err, ErrVerify) {
+		t.Fatalf("got error %v, want %v", err, ErrVerify)
+	}
+	last := steps[len(steps)-1]
+	if last.Opcode != "OP_VERIFY" || last.Err != err {
+		t.Fatalf("last step %s failed with %v, want OP_VERIFY failing "+
+			"with %v", last.Opcode, last.Err, err)
+	}
+}
+
+// TestTraceCheckSig ensures the signatures checked by OP_CHECKSIG are traced
+// along with the signature hashes they were checked against.
+func TestTraceCheckSig(t *testing.T) {
+	t.Parallel()
+
+	privKey := taprootTestKey("trace checksig")
+	pubKey := privKey.PubKey().SerializeCompressed()
+	builder := NewScriptBuilder()
+	builder.AddOp(OP_DUP).AddOp(OP_HASH160)
+	builder.AddData(btcutil.Hash160(pubKey))
+	builder.AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG)
+	pkScript, err := builder.Script()
+	if err != nil {
+		t.Fatalf("unable to build script: %v", err)
+	}
+
+	for _, corrupt := range []bool{false, true} {
+		tx := &wire.MsgTx{
+			Version: 1,
+			TxIn:    []*wire.TxIn{{}},
+			TxOut:   []*wire.TxOut{{Value: 1000}},
+		}
+		sigScript, err := SignatureScript(tx, 0, pkScript, SigHashAll,
+			privKey, true)
+		if err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+		if corrupt {
+			tx.LockTime = 1
+		}
+		tx.TxIn[0].SignatureScript = sigScript
+
+		vm, err := NewEngine(pkScript, tx, 0, 0, nil, nil, 0, nil)
+		if err != nil {
+			t.Fatalf("unable to create engine: %v", err)
+		}
+		steps, err := traceEngine(vm)
+		if (err != nil) != corrupt {
+			t.Fatalf("corrupt %v: unexpected result %v", corrupt, err)
+		}
+
+		last := steps[len(steps)-1]
+		if last.Opcode != "OP_CHECKSIG" || len(last.SigChecks) != 1 {
+			t.Fatalf("corrupt %v: last step %s has %d signature "+
+				"checks, want OP_CHECKSIG with 1", corrupt,
+				last.Opcode, len(last.SigChecks))
+		}
+		for _, step := range steps[:len(steps)-1] {
+			if len(step.SigChecks) != 0 {
+				t.Fatalf("corrupt %v: unexpected signature check "+
+					"by %s", corrupt, step.Opcode)
+			}
+		}
+
+		hash, err := CalcSignatureHash(pkScript, SigHashAll, tx, 0)
+		if err != nil {
+			t.Fatalf("unable to calculate sighash: %v", err)
+		}
+		check := last.SigChecks[0]
+		if !bytes.Equal(check.PubKey, pubKey) ||
+			check.HashType != SigHashAll ||
+			!bytes.Equal(check.SigHash, hash) || check.Valid == corrupt {
+
+			t.Fatalf("corrupt %v: got signature check %+v", corrupt,
+				check)
+		}
+	}
+}
+
+// TestTraceTaprootKeySpend ensures the signature of a taproot key path spend is
+// traced with the last opcode of the public key script.
+func TestTraceTaprootKeySpend(t *testing.T) {
+	t.Parallel()
+
+	privKey := taprootTestKey("trace taproot")
+	outputKey, err := ComputeTaprootKeyNoScript(privKey.PubKey())
+	if err != nil {
+		t.Fatalf("unable to compute output key: %v", err)
+	}
+	tweakedKey, err := TweakTaprootPrivKey(privKey, nil)
+	if err != nil {
+		t.Fatalf("unable to tweak private key: %v", err)
+	}
+	pkScript, err := PayToTaprootScript(outputKey)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	const amt = 100000000
+
+	tx, prevOutFetcher := taprootTestTx(pkScript, amt)
+	sigHashes := NewTxSigHashes(tx, prevOutFetcher)
+	hash, err := CalcTaprootSignatureHash(sigHashes, SigHashDefault, tx, 0,
+		prevOutFetcher)
+	if err != nil {
+		t.Fatalf("unable to calculate sighash: %v", err)
+	}
+	sig, err := schnorr.Sign(tweakedKey, hash)
+	if err != nil {
+		t.Fatalf("unable to sign: %v", err)
+	}
+	tx.TxIn[0].Witness = wire.TxWitness{sig.Serialize()}
+
+	vm, err := NewEngine(pkScript, tx, 0, taprootTestFlags, nil, sigHashes,
+		amt, prevOutFetcher)
+	if err != nil {
+		t.Fatalf("unable to create engine: %v", err)
+	}
+	steps, err := traceEngine(vm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	last := steps[len(steps)-1]
+	if len(last.SigChecks) != 1 {
+		t.Fatalf("got %d signature checks, want 1", len(last.SigChecks))
+	}
+	check := last.SigChecks[0]
+	if !bytes.Equal(check.PubKey, schnorr.SerializePubKey(outputKey)) ||
+		check.HashType != SigHashDefault ||
+		!bytes.Equal(check.SigHash, hash) || !check.Valid {
+
+		t.Fatalf("got signature check %+v", check)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package v2transport implements the BIP0324 version 2 encrypted peer-to-peer
+transport protocol.
+
+Transport Overview
+
+The v2 transport replaces the cleartext message header of the original bitcoin
+wire protocol with an encrypted and authenticated packet stream.  Both sides
+exchange ElligatorSwift encoded ephemeral public keys, which are
+indistinguishable from random bytes, derive a shared secret using x-only ECDH
+and use it to key a pair of forward secure ciphers in each direction.  Packet
+lengths are encrypted with FSChaCha20 and packet contents are encrypted and
+authenticated with FSChaCha20Poly1305.
+
+Messages are carried inside packets with a one byte short message ID for the
+most common messages or a 12-byte command for everything else.  Utreexo
+specific messages such as ublock and getublocks are assigned short IDs as well.
+
+Fallback
+
+The responding side of a connection detects peers that speak the original v1
+protocol by inspecting the first 16 bytes sent and reports ErrUseV1Transport
+so the caller can continue with the v1 protocol using the bytes that were
+already consumed.  The initiating side can't detect a v1 peer in-band, so
+callers are expected to reconnect using the v1 protocol when a handshake fails
+before any bytes are received.
+*/
+package v2transport
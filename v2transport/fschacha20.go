@@ -0,0 +1,146 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// rekeyInterval is the number of messages (or length chunks) that are
+// encrypted with a key before the key is replaced as specified in BIP0324.
+const rekeyInterval = 224
+
+// fsChaCha20 is the forward secure ChaCha20 stream cipher used to encrypt the
+// 3-byte packet lengths.  The key stream is continuous across chunks and the
+// key is replaced with the next 32 bytes of key stream every rekeyInterval
+// chunks.
+type fsChaCha20 struct {
+	key          [chacha20.KeySize]byte
+	chunkCounter uint32
+	rekeyCounter uint64
+	cipher       *chacha20.Cipher
+}
+
+// newFSChaCha20 returns a new forward secure ChaCha20 cipher keyed with the
+// passed key.
+func newFSChaCha20(key []byte) *fsChaCha20 {
+	f := &fsChaCha20{}
+	copy(f.key[:], key)
+	f.resetCipher()
+	return f
+}
+
+// resetCipher creates a new underlying ChaCha20 instance for the current key
+// and rekey counter.
+func (f *fsChaCha20) resetCipher() {
+	var nonce [chacha20.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeyCounter)
+
+	// This can only fail on invalid key or nonce sizes which are fixed.
+	c, err := chacha20.NewUnauthenticatedCipher(f.key[:], nonce[:])
+	if err != nil {
+		panic(err)
+	}
+	f.cipher = c
+}
+
+// crypt encrypts or decrypts the passed chunk in place.
+func (f *fsChaCha20) crypt(chunk []byte) {
+	f.cipher.XORKeyStream(chunk, chunk)
+
+	f.chunkCounter++
+	if f.chunkCounter == rekeyInterval {
+		var newKey [chacha20.KeySize]byte
+		f.cipher.XORKeyStream(newKey[:], newKey[:])
+		f.key = newKey
+		f.chunkCounter = 0
+		f.rekeyCounter++
+		f.resetCipher()
+	}
+}
+
+// fsChaCha20Poly1305 is the forward secure ChaCha20-Poly1305 AEAD used to
+// encrypt packet contents.  The nonce is derived from the packet counter and
+// the key is replaced every rekeyInterval packets.
+type fsChaCha20Poly1305 struct {
+	key           [chacha20poly1305.KeySize]byte
+	packetCounter uint32
+	rekeyCounter  uint64
+	aead          cipher.AEAD
+}
+
+// newFSChaCha20Poly1305 returns a new forward secure AEAD keyed with the
+// passed key.
+func newFSChaCha20Poly1305(key []byte) *fsChaCha20Poly1305 {
+	f := &fsChaCha20Poly1305{}
+	copy(f.key[:], key)
+	f.resetAEAD()
+	return f
+}
+
+// resetAEAD creates a new underlying AEAD instance for the current key.
+func (f *fsChaCha20Poly1305) resetAEAD() {
+	// This can only fail on an invalid key size which is fixed.
+	aead, err := chacha20poly1305.New(f.key[:])
+	if err != nil {
+		panic(err)
+	}
+	f.aead = aead
+}
+
+// nonce returns the nonce for the current packet.
+func (f *fsChaCha20Poly1305) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint32(nonce[:4], f.packetCounter)
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeyCounter)
+	return nonce[:]
+}
+
+// nextPacket advances the packet counter and replaces the key once every
+// rekeyInterval packets.  The new key is the first 32 bytes of the key stream
+// for the special nonce 0xffffffff || rekeyCounter, starting at block 1 just
+// like the AEAD plaintext.
+func (f *fsChaCha20Poly1305) nextPacket() {
+	f.packetCounter++
+	if f.packetCounter != rekeyInterval {
+		return
+	}
+
+	var nonce [chacha20.NonceSize]byte
+	binary.LittleEndian.PutUint32(nonce[:4], 0xffffffff)
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeyCounter)
+	c, err := chacha20.NewUnauthenticatedCipher(f.key[:], nonce[:])
+	if err != nil {
+		panic(err)
+	}
+	c.SetCounter(1)
+
+	var newKey [chacha20poly1305.KeySize]byte
+	c.XORKeyStream(newKey[:], newKey[:])
+	f.key = newKey
+	f.packetCounter = 0
+	f.rekeyCounter++
+	f.resetAEAD()
+}
+
+// encrypt encrypts and authenticates the plaintext along with the additional
+// data and returns the ciphertext with the appended tag.
+func (f *fsChaCha20Poly1305) encrypt(aad, plaintext []byte) []byte {
+	ciphertext := f.aead.Seal(nil, f.nonce(), plaintext, aad)
+	f.nextPacket()
+	return ciphertext
+}
+
+// decrypt authenticates and decrypts the ciphertext along with the additional
+// data and returns the plaintext.
+func (f *fsChaCha20Poly1305) decrypt(aad, ciphertext []byte) ([]byte, error) {
+	plaintext, err := f.aead.Open(nil, f.nonce(), ciphertext, aad)
+	f.nextPacket()
+	return plaintext, err
+}
@@ -0,0 +1,422 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// lengthFieldLen is the length of the encrypted packet length field.
+	lengthFieldLen = 3
+
+	// headerLen is the length of the packet header which holds the flags.
+	headerLen = 1
+
+	// tagLen is the length of the Poly1305 authentication tag.
+	tagLen = 16
+
+	// garbageTerminatorLen is the length of the garbage terminator.
+	garbageTerminatorLen = 16
+
+	// MaxGarbageLen is the maximum amount of garbage bytes either side may
+	// send before its garbage terminator.
+	MaxGarbageLen = 4095
+
+	// ignoreBit is the header flag which marks a packet as a decoy which
+	// must be ignored by the receiver.
+	ignoreBit = 1 << 7
+
+	// maxContentsLen is the maximum length of the contents of a packet.
+	// It allows for the largest possible message payload along with the
+	// long form message type.
+	maxContentsLen = wire.MaxMessagePayload + 1 + wire.CommandSize
+
+	// v1PrefixLen is the number of bytes inspected by the responder to
+	// detect peers using the v1 protocol.
+	v1PrefixLen = 16
+)
+
+var (
+	// ErrUseV1Transport is returned by the responder side of the handshake
+	// when the remote peer sent the start of a v1 version message.  The
+	// consumed bytes are available via V1Prefix.
+	ErrUseV1Transport = errors.New("remote peer is using the v1 transport")
+
+	// ErrGarbageTerminator is returned when the remote peer did not send
+	// its garbage terminator within the maximum allowed garbage length.
+	ErrGarbageTerminator = errors.New("garbage terminator not found")
+
+	// ErrPacketTooLarge is returned when the remote peer announces a
+	// packet that exceeds the maximum allowed contents length.
+	ErrPacketTooLarge = errors.New("packet exceeds maximum size")
+
+	// ErrHandshakeIncomplete is returned when attempting to send or
+	// receive messages before the handshake completed.
+	ErrHandshakeIncomplete = errors.New("v2 handshake not complete")
+)
+
+// Transport houses the state of one side of a BIP0324 v2 transport
+// connection.  A Transport must complete the handshake before messages can be
+// sent or received.
+//
+// NOTE: The send and receive halves are independent so one goroutine may send
+// while another receives, however neither half is safe for concurrent use by
+// multiple goroutines.
+type Transport struct {
+	btcnet    wire.BitcoinNet
+	initiator bool
+
+	privKey *btcec.PrivateKey
+	ourEnc  [btcec.EllswiftEncodingLen]byte
+	garbage []byte
+
+	sendL *fsChaCha20
+	sendP *fsChaCha20Poly1305
+	recvL *fsChaCha20
+	recvP *fsChaCha20Poly1305
+
+	sendGarbageTerminator [garbageTerminatorLen]byte
+	recvGarbageTerminator [garbageTerminatorLen]byte
+	sessionID             [32]byte
+
+	v1Prefix []byte
+	complete bool
+}
+
+// NewTransport returns a new v2 transport for the passed network.  The
+// initiator flag specifies whether the local side opened the connection.
+func NewTransport(btcnet wire.BitcoinNet, initiator bool) (*Transport, error) {
+	privKey, enc, err := btcec.EllswiftCreate()
+	if err != nil {
+		return nil, err
+	}
+
+	garbage := make([]byte, mrand.Intn(MaxGarbageLen+1))
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+
+	return &Transport{
+		btcnet:    btcnet,
+		initiator: initiator,
+		privKey:   privKey,
+		ourEnc:    enc,
+		garbage:   garbage,
+	}, nil
+}
+
+// SessionID returns the session ID shared by both sides of the connection.
+// It is only valid once the handshake completed.
+func (t *Transport) SessionID() [32]byte {
+	return t.sessionID
+}
+
+// V1Prefix returns the bytes consumed from a remote peer that turned out to be
+// using the v1 transport.  It is only set when the handshake returned
+// ErrUseV1Transport.
+func (t *Transport) V1Prefix() []byte {
+	return t.v1Prefix
+}
+
+// expectedV1Prefix returns the first 16 bytes of a v1 version message for the
+// transport's network.
+func (t *Transport) expectedV1Prefix() []byte {
+	var prefix [v1PrefixLen]byte
+	binary.LittleEndian.PutUint32(prefix[:4], uint32(t.btcnet))
+	copy(prefix[4:], wire.CmdVersion)
+	return prefix[:]
+}
+
+// initCiphers derives the session keys from the ECDH shared secret and sets up
+// the ciphers for both directions.
+func (t *Transport) initCiphers(theirEnc [btcec.EllswiftEncodingLen]byte) error {
+	secret, err := btcec.V2Ecdh(t.privKey, theirEnc, t.ourEnc, t.initiator)
+	if err != nil {
+		return err
+	}
+
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(t.btcnet))
+	salt := append([]byte("bitcoin_v2_shared_secret"), magic[:]...)
+	prk := hkdf.Extract(sha256.New, secret[:], salt)
+
+	expand := func(label string) []byte {
+		out := make([]byte, 32)
+		io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(label)), out)
+		return out
+	}
+
+	initiatorL := expand("initiator_L")
+	initiatorP := expand("initiator_P")
+	responderL := expand("responder_L")
+	responderP := expand("responder_P")
+	terminators := expand("garbage_terminators")
+	copy(t.sessionID[:], expand("session_id"))
+
+	if t.initiator {
+		t.sendL = newFSChaCha20(initiatorL)
+		t.sendP = newFSChaCha20Poly1305(initiatorP)
+		t.recvL = newFSChaCha20(responderL)
+		t.recvP = newFSChaCha20Poly1305(responderP)
+		copy(t.sendGarbageTerminator[:], terminators[:16])
+		copy(t.recvGarbageTerminator[:], terminators[16:])
+	} else {
+		t.sendL = newFSChaCha20(responderL)
+		t.sendP = newFSChaCha20Poly1305(responderP)
+		t.recvL = newFSChaCha20(initiatorL)
+		t.recvP = newFSChaCha20Poly1305(initiatorP)
+		copy(t.sendGarbageTerminator[:], terminators[16:])
+		copy(t.recvGarbageTerminator[:], terminators[:16])
+	}
+
+	return nil
+}
+
+// encryptPacket returns the encrypted packet for the passed contents.
+func (t *Transport) encryptPacket(contents, aad []byte, ignore bool) []byte {
+	var header byte
+	if ignore {
+		header = ignoreBit
+	}
+
+	var length [lengthFieldLen]byte
+	length[0] = byte(len(contents))
+	length[1] = byte(len(contents) >> 8)
+	length[2] = byte(len(contents) >> 16)
+	t.sendL.crypt(length[:])
+
+	plaintext := make([]byte, 0, headerLen+len(contents))
+	plaintext = append(plaintext, header)
+	plaintext = append(plaintext, contents...)
+
+	packet := make([]byte, 0, lengthFieldLen+len(plaintext)+tagLen)
+	packet = append(packet, length[:]...)
+	return append(packet, t.sendP.encrypt(aad, plaintext)...)
+}
+
+// readPacket reads and decrypts the next packet from r and returns its
+// contents, whether it was a decoy and the number of bytes read.
+func (t *Transport) readPacket(r io.Reader, aad []byte) ([]byte, bool, int, error) {
+	var length [lengthFieldLen]byte
+	n, err := io.ReadFull(r, length[:])
+	if err != nil {
+		return nil, false, n, err
+	}
+	t.recvL.crypt(length[:])
+	contentsLen := int(length[0]) | int(length[1])<<8 | int(length[2])<<16
+	if contentsLen > maxContentsLen {
+		return nil, false, n, ErrPacketTooLarge
+	}
+
+	ciphertext := make([]byte, headerLen+contentsLen+tagLen)
+	read, err := io.ReadFull(r, ciphertext)
+	n += read
+	if err != nil {
+		return nil, false, n, err
+	}
+
+	plaintext, err := t.recvP.decrypt(aad, ciphertext)
+	if err != nil {
+		return nil, false, n, err
+	}
+
+	return plaintext[headerLen:], plaintext[0]&ignoreBit != 0, n, nil
+}
+
+// asyncWriter writes buffers to the underlying writer from a separate
+// goroutine so the handshake can keep reading from a connection that doesn't
+// buffer writes, such as net.Pipe.
+type asyncWriter struct {
+	queue chan []byte
+	done  chan error
+}
+
+// newAsyncWriter starts a goroutine writing queued buffers to w in order.
+func newAsyncWriter(w io.Writer) *asyncWriter {
+	aw := &asyncWriter{
+		queue: make(chan []byte, 4),
+		done:  make(chan error, 1),
+	}
+	go func() {
+		var err error
+		for buf := range aw.queue {
+			if err != nil {
+				continue
+			}
+			_, err = w.Write(buf)
+		}
+		aw.done <- err
+	}()
+	return aw
+}
+
+// write queues the passed buffer to be written.
+func (aw *asyncWriter) write(buf []byte) {
+	aw.queue <- buf
+}
+
+// wait blocks until all queued buffers are written and returns the first
+// write error.
+func (aw *asyncWriter) wait() error {
+	close(aw.queue)
+	return <-aw.done
+}
+
+// Handshake performs the v2 handshake over the passed connection.  When the
+// local side is the responder and the remote peer is detected to be using the
+// v1 transport, ErrUseV1Transport is returned and the bytes consumed are
+// available via V1Prefix.
+//
+// NOTE: Any other error leaves the connection in an undefined state with
+// writes possibly still pending, so the caller must close it.
+func (t *Transport) Handshake(rw io.ReadWriter) error {
+	aw := newAsyncWriter(rw)
+	if err := t.handshake(rw, aw); err != nil {
+		close(aw.queue)
+		return err
+	}
+	if err := aw.wait(); err != nil {
+		return err
+	}
+
+	t.complete = true
+	return nil
+}
+
+// handshake implements the handshake logic of Handshake using the passed async
+// writer for all writes.
+func (t *Transport) handshake(r io.Reader, aw *asyncWriter) error {
+	// The initiator sends its key and garbage right away, while the
+	// responder first needs to make sure the remote peer isn't a v1 peer.
+	if t.initiator {
+		aw.write(append(t.ourEnc[:], t.garbage...))
+	}
+
+	var theirEnc [btcec.EllswiftEncodingLen]byte
+	if !t.initiator {
+		if _, err := io.ReadFull(r, theirEnc[:v1PrefixLen]); err != nil {
+			return err
+		}
+		if bytes.Equal(theirEnc[:v1PrefixLen], t.expectedV1Prefix()) {
+			t.v1Prefix = append([]byte(nil), theirEnc[:v1PrefixLen]...)
+			return ErrUseV1Transport
+		}
+		if _, err := io.ReadFull(r, theirEnc[v1PrefixLen:]); err != nil {
+			return err
+		}
+	} else {
+		if _, err := io.ReadFull(r, theirEnc[:]); err != nil {
+			return err
+		}
+	}
+
+	if err := t.initCiphers(theirEnc); err != nil {
+		return err
+	}
+
+	// Send the garbage terminator followed by the version packet which
+	// authenticates the garbage sent.  The version packet contents are
+	// empty as there are no transport extensions defined.
+	var out []byte
+	if !t.initiator {
+		out = append(out, t.ourEnc[:]...)
+		out = append(out, t.garbage...)
+	}
+	out = append(out, t.sendGarbageTerminator[:]...)
+	out = append(out, t.encryptPacket(nil, t.garbage, false)...)
+	aw.write(out)
+
+	// Read the remote garbage until the garbage terminator is found.
+	theirGarbage := make([]byte, 0, garbageTerminatorLen)
+	var b [1]byte
+	for {
+		if len(theirGarbage) >= garbageTerminatorLen &&
+			bytes.Equal(theirGarbage[len(theirGarbage)-garbageTerminatorLen:],
+				t.recvGarbageTerminator[:]) {
+
+			theirGarbage = theirGarbage[:len(theirGarbage)-garbageTerminatorLen]
+			break
+		}
+		if len(theirGarbage) >= MaxGarbageLen+garbageTerminatorLen {
+			return ErrGarbageTerminator
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return err
+		}
+		theirGarbage = append(theirGarbage, b[0])
+	}
+
+	// The first packet authenticates the garbage.  Decoy packets are
+	// skipped until the version packet arrives.
+	aad := theirGarbage
+	for {
+		_, ignore, _, err := t.readPacket(r, aad)
+		if err != nil {
+			return fmt.Errorf("unable to read version packet: %v", err)
+		}
+		aad = nil
+		if !ignore {
+			break
+		}
+	}
+
+	// The private key is no longer needed once the ciphers are set up.
+	t.privKey = nil
+	return nil
+}
+
+// WriteMessage encodes the passed message, encrypts it into a packet and
+// writes it to w.  It returns the number of bytes written.
+func (t *Transport) WriteMessage(w io.Writer, msg wire.Message, pver uint32,
+	enc wire.MessageEncoding) (int, error) {
+
+	if !t.complete {
+		return 0, ErrHandshakeIncomplete
+	}
+
+	contents, err := wire.EncodeV2Message(msg, pver, enc)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(t.encryptPacket(contents, nil, false))
+}
+
+// ReadMessage reads and decrypts the next packet from r, skipping any decoy
+// packets, and decodes the message it contains.  It returns the number of
+// bytes read along with the message and its raw payload.
+func (t *Transport) ReadMessage(r io.Reader, pver uint32,
+	enc wire.MessageEncoding) (int, wire.Message, []byte, error) {
+
+	if !t.complete {
+		return 0, nil, nil, ErrHandshakeIncomplete
+	}
+
+	totalBytes := 0
+	for {
+		contents, ignore, n, err := t.readPacket(r, nil)
+		totalBytes += n
+		if err != nil {
+			return totalBytes, nil, nil, err
+		}
+		if ignore {
+			continue
+		}
+
+		msg, payload, err := wire.DecodeV2Message(contents, pver, enc)
+		return totalBytes, msg, payload, err
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// handshakePair creates a connected initiator and responder transport and
+// performs the handshake between them over an in-process pipe.
+func handshakePair(t *testing.T) (*Transport, *Transport, net.Conn, net.Conn) {
+	t.Helper()
+
+	initiator, err := NewTransport(wire.SimNet, true)
+	if err != nil {
+		t.Fatalf("NewTransport: unexpected error: %v", err)
+	}
+	responder, err := NewTransport(wire.SimNet, false)
+	if err != nil {
+		t.Fatalf("NewTransport: unexpected error: %v", err)
+	}
+
+	c1, c2 := net.Pipe()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- responder.Handshake(c2)
+	}()
+	if err := initiator.Handshake(c1); err != nil {
+		t.Fatalf("initiator Handshake: unexpected error: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("responder Handshake: unexpected error: %v", err)
+	}
+
+	return initiator, responder, c1, c2
+}
+
+// TestHandshake ensures the initiator and responder of a handshake derive the
+// same session and can exchange messages in both directions.
+func TestHandshake(t *testing.T) {
+	initiator, responder, c1, c2 := handshakePair(t)
+	defer c1.Close()
+	defer c2.Close()
+
+	if initiator.SessionID() != responder.SessionID() {
+		t.Fatalf("mismatched session ids - %x != %x",
+			initiator.SessionID(), responder.SessionID())
+	}
+
+	getUBlocks := wire.NewMsgGetUBlocks(&chainhash.Hash{0x02})
+	getUBlocks.AddBlockLocatorHash(&chainhash.Hash{0x01})
+	msgs := []wire.Message{
+		wire.NewMsgPing(1),
+		wire.NewMsgVerAck(),
+		getUBlocks,
+		wire.NewMsgSendHeaders(),
+	}
+
+	pver := wire.ProtocolVersion
+	for i, msg := range msgs {
+		for _, dir := range []struct {
+			from, to     *Transport
+			fromC, toC   net.Conn
+			directionStr string
+		}{
+			{initiator, responder, c1, c2, "initiator->responder"},
+			{responder, initiator, c2, c1, "responder->initiator"},
+		} {
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := dir.from.WriteMessage(dir.fromC, msg, pver,
+					wire.LatestEncoding)
+				errChan <- err
+			}()
+
+			_, got, _, err := dir.to.ReadMessage(dir.toC, pver,
+				wire.LatestEncoding)
+			if err != nil {
+				t.Fatalf("#%d %s: ReadMessage error: %v", i,
+					dir.directionStr, err)
+			}
+			if err := <-errChan; err != nil {
+				t.Fatalf("#%d %s: WriteMessage error: %v", i,
+					dir.directionStr, err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Fatalf("#%d %s: mismatched message - got %v, "+
+					"want %v", i, dir.directionStr, got, msg)
+			}
+		}
+	}
+}
+
+// TestRekey ensures messages keep decrypting correctly past the rekey
+// interval of both the length and the packet ciphers.
+func TestRekey(t *testing.T) {
+	initiator, responder, c1, c2 := handshakePair(t)
+	defer c1.Close()
+	defer c2.Close()
+
+	const numMsgs = rekeyInterval*2 + 10
+	errChan := make(chan error, 1)
+	go func() {
+		for i := 0; i < numMsgs; i++ {
+			_, err := initiator.WriteMessage(c1,
+				wire.NewMsgPing(uint64(i)), wire.ProtocolVersion,
+				wire.LatestEncoding)
+			if err != nil {
+				errChan <- err
+				return
+			}
+		}
+		errChan <- nil
+	}()
+
+	for i := 0; i < numMsgs; i++ {
+		_, msg, _, err := responder.ReadMessage(c2, wire.ProtocolVersion,
+			wire.LatestEncoding)
+		if err != nil {
+			t.Fatalf("#%d: ReadMessage error: %v", i, err)
+		}
+		ping, ok := msg.(*wire.MsgPing)
+		if !ok || ping.Nonce != uint64(i) {
+			t.Fatalf("#%d: unexpected message %v", i, msg)
+		}
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("WriteMessage error: %v", err)
+	}
+}
+
+// TestDecoyPackets ensures decoy packets are skipped by the receiver.
+func TestDecoyPackets(t *testing.T) {
+	initiator, responder, c1, c2 := handshakePair(t)
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		c1.Write(initiator.encryptPacket([]byte("decoy"), nil, true))
+		initiator.WriteMessage(c1, wire.NewMsgPing(7),
+			wire.ProtocolVersion, wire.LatestEncoding)
+	}()
+
+	_, msg, _, err := responder.ReadMessage(c2, wire.ProtocolVersion,
+		wire.LatestEncoding)
+	if err != nil {
+		t.Fatalf("ReadMessage error: %v", err)
+	}
+	if ping, ok := msg.(*wire.MsgPing); !ok || ping.Nonce != 7 {
+		t.Fatalf("unexpected message %v", msg)
+	}
+}
+
+// TestTamperedPacket ensures a modified packet fails authentication.
+func TestTamperedPacket(t *testing.T) {
+	initiator, responder, c1, c2 := handshakePair(t)
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		packet := initiator.encryptPacket([]byte{18, 0, 0, 0, 0, 0, 0,
+			0, 0}, nil, false)
+		packet[len(packet)-1] ^= 0x01
+		c1.Write(packet)
+	}()
+
+	_, _, _, err := responder.ReadMessage(c2, wire.ProtocolVersion,
+		wire.LatestEncoding)
+	if err == nil {
+		t.Fatalf("ReadMessage: expected authentication failure")
+	}
+}
+
+// TestV1Detection ensures the responder detects a peer using the v1
+// transport and hands back the consumed bytes.
+func TestV1Detection(t *testing.T) {
+	responder, err := NewTransport(wire.SimNet, false)
+	if err != nil {
+		t.Fatalf("NewTransport: unexpected error: %v", err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	var v1Msg bytes.Buffer
+	msg := wire.NewMsgVersion(&wire.NetAddress{}, &wire.NetAddress{}, 1, 0)
+	wire.WriteMessage(&v1Msg, msg, wire.ProtocolVersion, wire.SimNet)
+	go c1.Write(v1Msg.Bytes())
+
+	err = responder.Handshake(c2)
+	if err != ErrUseV1Transport {
+		t.Fatalf("Handshake: unexpected error - got %v, want %v", err,
+			ErrUseV1Transport)
+	}
+	if !bytes.Equal(responder.V1Prefix(), v1Msg.Bytes()[:v1PrefixLen]) {
+		t.Fatalf("V1Prefix: mismatched prefix - got %x, want %x",
+			responder.V1Prefix(), v1Msg.Bytes()[:v1PrefixLen])
+	}
+}
+
+// TestMessageBeforeHandshake ensures messages can't be exchanged before the
+// handshake completed.
+func TestMessageBeforeHandshake(t *testing.T) {
+	tr, err := NewTransport(wire.SimNet, true)
+	if err != nil {
+		t.Fatalf("NewTransport: unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	_, err = tr.WriteMessage(&buf, wire.NewMsgVerAck(),
+		wire.ProtocolVersion, wire.LatestEncoding)
+	if err != ErrHandshakeIncomplete {
+		t.Fatalf("WriteMessage: unexpected error - got %v, want %v",
+			err, ErrHandshakeIncomplete)
+	}
+	_, _, _, err = tr.ReadMessage(&buf, wire.ProtocolVersion,
+		wire.LatestEncoding)
+	if err != ErrHandshakeIncomplete {
+		t.Fatalf("ReadMessage: unexpected error - got %v, want %v",
+			err, ErrHandshakeIncomplete)
+	}
+}
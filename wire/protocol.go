@@ -165,6 +165,10 @@ const (
 
 	// SimNet represents the simulation test network.
 	SimNet BitcoinNet = 0x12141c16
+
+	// SigNet represents the public default signet network (BIP0325).
+	// Custom signets derive their own magic from their challenge script.
+	SigNet BitcoinNet = 0x40cf030a
 )
 
 // bnStrings is a map of bitcoin networks back to their constant names for
@@ -174,6 +178,7 @@ var bnStrings = map[BitcoinNet]string{
 	TestNet:  "TestNet",
 	TestNet3: "TestNet3",
 	SimNet:   "SimNet",
+	SigNet:   "SigNet",
 }
 
 // String returns the BitcoinNet in human-readable form.
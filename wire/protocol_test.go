@@ -45,6 +45,7 @@ func TestBitcoinNetStringer(t *testing.T) {
 		{TestNet, "TestNet"},
 		{TestNet3, "TestNet3"},
 		{SimNet, "SimNet"},
+		{SigNet, "SigNet"},
 		{0xffffffff, "Unknown BitcoinNet (4294967295)"},
 	}
 
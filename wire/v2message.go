@@ -0,0 +1,173 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// V2LongCommandID is the message type byte which indicates that the message
+// type is encoded as a 12-byte command string following the type byte rather
+// than as a single byte short ID.
+const V2LongCommandID = 0
+
+// v2ShortIDs maps the commands that have a one byte short ID in the BIP0324
+// v2 transport to that ID.  IDs 1 through 28 are assigned by BIP0324 while
+// the ublock and getublocks IDs are specific to the utreexo network and were
+// picked from the upper end of the range to avoid future BIP assignments.
+var v2ShortIDs = map[string]uint8{
+	CmdAddr:         1,
+	CmdBlock:        2,
+	CmdFeeFilter:    5,
+	CmdFilterAdd:    6,
+	CmdFilterClear:  7,
+	CmdFilterLoad:   8,
+	CmdGetBlocks:    9,
+	CmdGetData:      11,
+	CmdGetHeaders:   12,
+	CmdHeaders:      13,
+	CmdInv:          14,
+	CmdMemPool:      15,
+	CmdMerkleBlock:  16,
+	CmdNotFound:     17,
+	CmdPing:         18,
+	CmdPong:         19,
+	CmdTx:           21,
+	CmdGetCFilters:  22,
+	CmdCFilter:      23,
+	CmdGetCFHeaders: 24,
+	CmdCFHeaders:    25,
+	CmdGetCFCheckpt: 26,
+	CmdCFCheckpt:    27,
+	CmdUBlock:       250,
+	CmdGetUBlocks:   251,
+}
+
+// v2ShortIDCommands is the reverse mapping of v2ShortIDs.
+var v2ShortIDCommands = func() map[uint8]string {
+	m := make(map[uint8]string, len(v2ShortIDs))
+	for cmd, id := range v2ShortIDs {
+		m[id] = cmd
+	}
+	return m
+}()
+
+// V2ShortID returns the BIP0324 short message ID for the passed command along
+// with whether or not the command has one.
+func V2ShortID(command string) (uint8, bool) {
+	id, ok := v2ShortIDs[command]
+	return id, ok
+}
+
+// EncodeV2Message serializes the passed message into the contents of a BIP0324
+// v2 transport packet.  The contents consist of the message type, either as a
+// single byte short ID or as a zero byte followed by the 12-byte command, and
+// the message payload.
+func EncodeV2Message(msg Message, pver uint32, encoding MessageEncoding) ([]byte, error) {
+	cmd := msg.Command()
+	if len(cmd) > CommandSize {
+		str := fmt.Sprintf("command [%s] is too long [max %v]",
+			cmd, CommandSize)
+		return nil, messageError("EncodeV2Message", str)
+	}
+
+	var bw bytes.Buffer
+	if id, ok := v2ShortIDs[cmd]; ok {
+		bw.WriteByte(id)
+	} else {
+		var command [CommandSize]byte
+		copy(command[:], cmd)
+		bw.WriteByte(V2LongCommandID)
+		bw.Write(command[:])
+	}
+	typeLen := bw.Len()
+
+	err := msg.BtcEncode(&bw, pver, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enforce maximum overall message payload.
+	lenp := bw.Len() - typeLen
+	if lenp > MaxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload is %d bytes",
+			lenp, MaxMessagePayload)
+		return nil, messageError("EncodeV2Message", str)
+	}
+
+	// Enforce maximum message payload based on the message type.
+	mpl := msg.MaxPayloadLength(pver)
+	if uint32(lenp) > mpl {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload size for "+
+			"messages of type [%s] is %d.", lenp, cmd, mpl)
+		return nil, messageError("EncodeV2Message", str)
+	}
+
+	return bw.Bytes(), nil
+}
+
+// DecodeV2Message parses the contents of a BIP0324 v2 transport packet into a
+// message.  It returns the parsed message along with the raw payload bytes.
+func DecodeV2Message(contents []byte, pver uint32,
+	encoding MessageEncoding) (Message, []byte, error) {
+
+	if len(contents) == 0 {
+		return nil, nil, messageError("DecodeV2Message",
+			"empty message contents")
+	}
+
+	var command string
+	payload := contents[1:]
+	if contents[0] == V2LongCommandID {
+		if len(contents) < 1+CommandSize {
+			str := fmt.Sprintf("message contents too short for "+
+				"command - got %d bytes", len(contents))
+			return nil, nil, messageError("DecodeV2Message", str)
+		}
+		command = string(bytes.TrimRight(contents[1:1+CommandSize],
+			"\x00"))
+		payload = contents[1+CommandSize:]
+
+		if !utf8.ValidString(command) {
+			str := fmt.Sprintf("invalid command %v", []byte(command))
+			return nil, nil, messageError("DecodeV2Message", str)
+		}
+	} else {
+		cmd, ok := v2ShortIDCommands[contents[0]]
+		if !ok {
+			str := fmt.Sprintf("unknown short message id %d",
+				contents[0])
+			return nil, nil, messageError("DecodeV2Message", str)
+		}
+		command = cmd
+	}
+
+	msg, err := makeEmptyMessage(command)
+	if err != nil {
+		return nil, nil, messageError("DecodeV2Message", err.Error())
+	}
+
+	// Check for maximum length based on the message type.
+	mpl := msg.MaxPayloadLength(pver)
+	if uint32(len(payload)) > mpl {
+		str := fmt.Sprintf("payload exceeds max length - indicates "+
+			"%v bytes, but max payload size for messages of type "+
+			"[%v] is %v.", len(payload), command, mpl)
+		return nil, nil, messageError("DecodeV2Message", str)
+	}
+
+	// NOTE: This must be a *bytes.Buffer since the MsgVersion BtcDecode
+	// function requires it.
+	err = msg.BtcDecode(bytes.NewBuffer(payload), pver, encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return msg, payload, nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2013-2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestV2Message tests the EncodeV2Message and DecodeV2Message API for
+// messages with and without a short message ID.
+func TestV2Message(t *testing.T) {
+	pver := ProtocolVersion
+
+	addrYou := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 8333}
+	you := NewNetAddress(addrYou, SFNodeNetwork)
+	you.Timestamp = time.Time{} // Version message has zero value timestamp.
+	addrMe := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8333}
+	me := NewNetAddress(addrMe, SFNodeNetwork)
+	me.Timestamp = time.Time{} // Version message has zero value timestamp.
+	msgVersion := NewMsgVersion(me, you, 123123, 0)
+
+	msgGetUBlocks := NewMsgGetUBlocks(&chainhash.Hash{})
+	msgGetUBlocks.AddBlockLocatorHash(&chainhash.Hash{0x01})
+
+	tests := []struct {
+		in      Message // Value to encode
+		typeLen int     // Expected length of the message type
+		typeID  uint8   // Expected first byte of the contents
+	}{
+		{NewMsgPing(123123), 1, 18},
+		{NewMsgPong(123123), 1, 19},
+		{NewMsgTx(1), 1, 21},
+		{msgGetUBlocks, 1, 251},
+		{msgVersion, 13, V2LongCommandID},
+		{NewMsgVerAck(), 13, V2LongCommandID},
+		{NewMsgSendHeaders(), 13, V2LongCommandID},
+		{NewMsgReject("block", RejectDuplicate, "duplicate block"), 13, V2LongCommandID},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		contents, err := EncodeV2Message(test.in, pver, BaseEncoding)
+		if err != nil {
+			t.Errorf("EncodeV2Message #%d error %v", i, err)
+			continue
+		}
+		if contents[0] != test.typeID {
+			t.Errorf("EncodeV2Message #%d wrong type id - got %d, "+
+				"want %d", i, contents[0], test.typeID)
+			continue
+		}
+
+		var payload bytes.Buffer
+		test.in.BtcEncode(&payload, pver, BaseEncoding)
+		if !bytes.Equal(contents[test.typeLen:], payload.Bytes()) {
+			t.Errorf("EncodeV2Message #%d wrong payload - got %x, "+
+				"want %x", i, contents[test.typeLen:],
+				payload.Bytes())
+			continue
+		}
+
+		msg, _, err := DecodeV2Message(contents, pver, BaseEncoding)
+		if err != nil {
+			t.Errorf("DecodeV2Message #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg, test.in) {
+			t.Errorf("DecodeV2Message #%d\n got: %v want: %v", i,
+				spew.Sdump(msg), spew.Sdump(test.in))
+			continue
+		}
+	}
+}
+
+// TestV2MessageErrors performs negative tests against DecodeV2Message to
+// ensure malformed contents are rejected.
+func TestV2MessageErrors(t *testing.T) {
+	pver := ProtocolVersion
+
+	tests := []struct {
+		name     string
+		contents []byte
+	}{
+		{"empty", nil},
+		{"unknown short id", []byte{200}},
+		{"truncated command", []byte{V2LongCommandID, 'p', 'i', 'n', 'g'}},
+		{"unknown command", append([]byte{V2LongCommandID},
+			[]byte("bogus\x00\x00\x00\x00\x00\x00\x00")...)},
+		{"invalid utf8 command", append([]byte{V2LongCommandID},
+			[]byte("\xff\xfe\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")...)},
+	}
+
+	for _, test := range tests {
+		_, _, err := DecodeV2Message(test.contents, pver, BaseEncoding)
+		if _, ok := err.(*MessageError); !ok {
+			t.Errorf("DecodeV2Message (%s): wrong error type - "+
+				"got %T (%v), want *MessageError", test.name,
+				err, err)
+		}
+	}
+}